@@ -0,0 +1,294 @@
+// Example {
+//      adminport.SetMemTransport(true)
+//      server := adminport.NewServer(config, reqch)
+//      ...
+//      client := adminport.NewClient("localhost:9999", "/adminport/")
+// }
+//
+// In-memory adminport connects clients and servers within the same
+// process by their listen address, without opening sockets, so that
+// components talking over adminport can be tested deterministically.
+// Requests and responses are still encoded and decoded to catch
+// marshalling errors.
+
+package adminport
+
+import "errors"
+import "fmt"
+import "reflect"
+import "sync"
+import "sync/atomic"
+
+import "github.com/couchbase/indexing/secondary/logging"
+import c "github.com/couchbase/indexing/secondary/common"
+
+// ErrorServerNotFound
+var ErrorServerNotFound = errors.New("adminport.serverNotFound")
+
+var useMemTransport int32
+
+var memServers struct {
+	mu      sync.Mutex
+	servers map[string]*memServer // listenAddr -> server
+}
+
+func init() {
+	memServers.servers = make(map[string]*memServer)
+}
+
+// SetMemTransport selects in-memory transport for the servers and clients
+// created with NewServer() and NewClient() there after.
+func SetMemTransport(enable bool) {
+	if enable {
+		atomic.StoreInt32(&useMemTransport, 1)
+	} else {
+		atomic.StoreInt32(&useMemTransport, 0)
+	}
+}
+
+// NewServer creates an admin-server using the selected transport, http
+// by default.
+func NewServer(config c.Config, reqch chan<- Request) Server {
+	if atomic.LoadInt32(&useMemTransport) == 1 {
+		return NewMemServer(config, reqch)
+	}
+	return NewHTTPServer(config, reqch)
+}
+
+// NewClient returns a Client using the selected transport, http by
+// default.
+func NewClient(listenAddr, urlPrefix string) Client {
+	if atomic.LoadInt32(&useMemTransport) == 1 {
+		return NewMemClient(listenAddr, urlPrefix)
+	}
+	return NewHTTPClient(listenAddr, urlPrefix)
+}
+
+// memServer is a concrete type implementing adminport Server
+// interface over in-memory transport.
+type memServer struct {
+	mu       sync.Mutex
+	messages map[string]MessageMarshaller
+	reqch    chan<- Request
+	started  bool
+
+	// config params
+	name      string
+	laddr     string
+	urlPrefix string
+
+	logPrefix     string
+	statsMessages map[string][3]uint64 // msgname -> [3]uint64{in,out,err}
+}
+
+// NewMemServer creates an instance of in-memory admin-server.
+// Start() will register the server with its listen address.
+func NewMemServer(config c.Config, reqch chan<- Request) Server {
+	s := &memServer{
+		messages:      make(map[string]MessageMarshaller),
+		reqch:         reqch,
+		name:          config["name"].String(),
+		laddr:         config["listenAddr"].String(),
+		urlPrefix:     config["urlPrefix"].String(),
+		statsMessages: make(map[string][3]uint64),
+	}
+	s.logPrefix = fmt.Sprintf("%s[mem:%s]", s.name, s.laddr)
+	return s
+}
+
+// Register is part of Server interface.
+func (s *memServer) Register(msg MessageMarshaller) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		logging.Errorf("%v can't register, server already started\n", s.logPrefix)
+		return ErrorRegisteringRequest
+	}
+	key := s.urlPrefix + msg.Name()
+	s.messages[key] = msg
+	s.statsMessages[key] = [3]uint64{0, 0, 0}
+	return nil
+}
+
+// RegisterHTTPHandler is part of Server interface, http handlers are
+// not served over in-memory transport.
+func (s *memServer) RegisterHTTPHandler(pattern string, handler interface{}) error {
+	logging.Infof("%v ignoring http handler %s\n", s.logPrefix, pattern)
+	return nil
+}
+
+// Unregister is part of Server interface.
+func (s *memServer) Unregister(msg MessageMarshaller) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		logging.Errorf("%v can't unregister, server already started\n", s.logPrefix)
+		return ErrorRegisteringRequest
+	}
+	key := s.urlPrefix + msg.Name()
+	if _, ok := s.messages[key]; !ok {
+		return ErrorMessageUnknown
+	}
+	delete(s.messages, key)
+	return nil
+}
+
+// GetStatistics is part of Server interface.
+func (s *memServer) GetStatistics() c.Statistics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := map[string]interface{}{"urlPrefix": s.urlPrefix}
+	for name, ns := range s.statsMessages {
+		m[name] = [3]uint64{ns[0] /*in*/, ns[1] /*out*/, ns[2] /*err*/}
+	}
+	stats, _ := c.NewStatistics(m)
+	return stats
+}
+
+// Start is part of Server interface.
+func (s *memServer) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrorServerStarted
+	}
+
+	memServers.mu.Lock()
+	defer memServers.mu.Unlock()
+	if _, ok := memServers.servers[s.laddr]; ok {
+		return fmt.Errorf("%v address %v already in use", s.logPrefix, s.laddr)
+	}
+	memServers.servers[s.laddr] = s
+	s.started = true
+	logging.Infof("%s started ...\n", s.logPrefix)
+	return nil
+}
+
+// Stop is part of Server interface.
+func (s *memServer) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		memServers.mu.Lock()
+		if memServers.servers[s.laddr] == s {
+			delete(memServers.servers, s.laddr)
+		}
+		memServers.mu.Unlock()
+		close(s.reqch)
+		s.started = false
+	}
+	logging.Infof("%s ... stopped\n", s.logPrefix)
+}
+
+// handle request, with the same semantics as httpServer.systemHandler.
+func (s *memServer) handle(path string, dataIn []byte) ([]byte, error) {
+	s.mu.Lock()
+	msg, ok := s.messages[path]
+	stats := s.statsMessages[path]
+	stats[0]++ // request count
+	s.statsMessages[path] = stats
+	started := s.started
+	s.mu.Unlock()
+
+	if !started {
+		return nil, ErrorServerNotFound
+	} else if !ok {
+		return nil, ErrorPathNotFound
+	}
+
+	dataOut, err := func() ([]byte, error) {
+		typeOfMsg := reflect.ValueOf(msg).Elem().Type()
+		msg := reflect.New(typeOfMsg).Interface().(MessageMarshaller)
+		if err := msg.Decode(dataIn); err != nil {
+			return nil, fmt.Errorf("%v, %v", ErrorDecodeRequest, err)
+		}
+
+		waitch := make(chan interface{}, 1)
+		s.reqch <- &memAdminRequest{msg: msg, waitch: waitch}
+		switch v := (<-waitch).(type) {
+		case MessageMarshaller:
+			dataOut, err := v.Encode()
+			if err != nil {
+				return nil, fmt.Errorf("%v, %v", ErrorEncodeResponse, err)
+			}
+			return dataOut, nil
+		case error:
+			return nil, v
+		}
+		return nil, ErrorInternal
+	}()
+
+	s.mu.Lock()
+	stats = s.statsMessages[path]
+	if err != nil {
+		stats[2]++ // error count
+	}
+	stats[1]++ // response count
+	s.statsMessages[path] = stats
+	s.mu.Unlock()
+
+	return dataOut, err
+}
+
+// concrete type implementing Request interface
+type memAdminRequest struct {
+	msg    MessageMarshaller
+	waitch chan interface{}
+}
+
+// GetMessage is part of Request interface.
+func (r *memAdminRequest) GetMessage() MessageMarshaller {
+	return r.msg
+}
+
+// Send is part of Request interface.
+func (r *memAdminRequest) Send(msg MessageMarshaller) error {
+	r.waitch <- msg
+	close(r.waitch)
+	return nil
+}
+
+// SendError is part of Request interface.
+func (r *memAdminRequest) SendError(err error) error {
+	r.waitch <- err
+	close(r.waitch)
+	return nil
+}
+
+// memClient is a concrete type implementing Client interface over
+// in-memory transport.
+type memClient struct {
+	serverAddr string
+	urlPrefix  string
+}
+
+// NewMemClient returns a new instance of Client over in-memory
+// transport.
+func NewMemClient(listenAddr, urlPrefix string) Client {
+	return &memClient{serverAddr: listenAddr, urlPrefix: urlPrefix}
+}
+
+// Request is part of `Client` interface
+func (c *memClient) Request(msg, resp MessageMarshaller) error {
+	memServers.mu.Lock()
+	s, ok := memServers.servers[c.serverAddr]
+	memServers.mu.Unlock()
+	if !ok {
+		return ErrorServerNotFound
+	}
+
+	body, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	data, err := s.handle(c.urlPrefix+msg.Name(), body)
+	if err != nil {
+		return err
+	}
+	return resp.Decode(data)
+}
@@ -59,6 +59,45 @@ func TestLoopback(t *testing.T) {
 	}
 }
 
+func TestMemLoopback(t *testing.T) {
+	SetMemTransport(true)
+	defer SetMemTransport(false)
+
+	apConfig := common.SystemConfig.SectionConfig("projector.adminport.", true)
+	apConfig.SetValue("name", "test-mem-adminport")
+	apConfig.SetValue("listenAddr", "mem:9999")
+	urlPrefix := apConfig["urlPrefix"].String()
+
+	reqch := make(chan Request, 10)
+	server := NewServer(apConfig, reqch)
+	if err := server.Register(&testMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for req := range reqch {
+			req.Send(req.GetMessage())
+		}
+	}()
+
+	client := NewClient("mem:9999", urlPrefix)
+	req := &testMessage{DefnID: 0x1234, Bucket: "default", IName: "example-index"}
+	resp := &testMessage{}
+	if err := client.Request(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(req, resp) == false {
+		t.Error("unexpected response")
+	}
+
+	server.Stop()
+	if err := client.Request(req, resp); err != ErrorServerNotFound {
+		t.Errorf("expected %v, got %v", ErrorServerNotFound, err)
+	}
+}
+
 func BenchmarkClientRequest(b *testing.B) {
 	logging.SetLogLevel(logging.Silent)
 	urlPrefix := common.SystemConfig["projector.adminport.urlPrefix"].String()
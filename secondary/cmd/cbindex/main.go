@@ -32,6 +32,16 @@ func usage(fset *flag.FlagSet) {
     cbindex -auth user:pass -type create -bucket default -using memdb -index first_name -fields=first_name,last_name
     cbindex -auth user:pass -type create -bucket default -primary=true -index primary
     cbindex -auth user:pass -type drop -instanceid 1234
+    cbindex -auth user:pass -type create -bucket default -index first_name -fields=first_name -with '{"num_replica":1}' -dryrun
+
+- Apply Index Template
+    cbindex -auth user:pass -type apply -file indexes.json -vars bucket=travel
+    cbindex -auth user:pass -type apply -file indexes.json -vars bucket=travel -dryrun
+    cbindex -auth user:pass -type apply -file indexes.json -vars bucket=travel -force
+
+    indexes.json:
+    {"variables": {"bucket": "default"},
+     "indexes": [{"name": "def_city", "bucket": "${bucket}", "secExprs": ["city"]}]}
 
 - List
     cbindex -auth user:pass -type list
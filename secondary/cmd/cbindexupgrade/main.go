@@ -0,0 +1,130 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/indexer"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+var options struct {
+	command  string
+	target   string
+	verify   bool
+	logLevel string
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Usage: cbindexupgrade [options] <slice directory> ...")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr,
+		`cbindexupgrade upgrades the slice files of forestdb indexes offline, from the latest committed snapshot
+of the index, without rebuilding the index from KV.  The index can be upgraded to the current forestdb file
+format, or converted to memory optimized disk snapshot files.`)
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr,
+		`Examples:
+    cbindexupgrade -command=upgrade -target=forestdb /opt/couchbase/var/lib/couchbase/data/@2i/default_idx1_1234_0.index
+    cbindexupgrade -command=upgrade -target=memory_optimized -verify /opt/couchbase/var/lib/couchbase/data/@2i/*.index
+    cbindexupgrade -command=verify /opt/couchbase/var/lib/couchbase/data/@2i/default_idx1_1234_0.index
+    cbindexupgrade -command=rollback /opt/couchbase/var/lib/couchbase/data/@2i/default_idx1_1234_0.index
+    cbindexupgrade -command=commit /opt/couchbase/var/lib/couchbase/data/@2i/default_idx1_1234_0.index
+    `)
+	fmt.Fprintln(os.Stderr,
+		`Usage Note:
+1) The indexer must be stopped while cbindexupgrade is running.
+2) The original slice directory is kept with the suffix .orig, until the upgrade is committed or rolled back.
+   A slice cannot be upgraded again before that.
+3) Mutations after the latest committed snapshot of the index are not upgraded.  The indexer catches up
+   with them from KV on restart.
+4) Converted memory optimized indexes are used once the storage mode of the indexer node is memory optimized.
+5) Primary indexes with document metadata cannot be converted to memory optimized.
+    `)
+}
+
+func main() {
+
+	flag.StringVar(&options.command, "command", "", "upgrade | verify | rollback | commit")
+	flag.StringVar(&options.target, "target", "forestdb", "storage mode to upgrade to, forestdb or memory_optimized")
+	flag.BoolVar(&options.verify, "verify", true, "verify the upgraded slice before replacing the slice")
+	flag.StringVar(&options.logLevel, "logLevel", "info", "log level")
+	flag.Usage = usage
+	flag.Parse()
+
+	logging.SetLogLevel(logging.Level(options.logLevel))
+
+	slices := flag.Args()
+	if len(slices) == 0 || options.command == "" {
+		usage()
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, slice := range slices {
+		if err := run(filepath.Clean(slice)); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v failed: %v\n", slice, options.command, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run(slice string) error {
+
+	switch options.command {
+	case "upgrade":
+		mode := common.IndexTypeToStorageMode(common.IndexType(options.target))
+		if err := indexer.UpgradeSlice(slice, mode, options.verify); err != nil {
+			return err
+		}
+		fmt.Printf("%v: upgraded to %v\n", slice, mode)
+
+	case "verify":
+		orig, upgraded, err := indexer.VerifySliceUpgrade(slice)
+		if orig != nil {
+			fmt.Printf("%v: original items %v checksum %x\n", slice, orig.Count, orig.Checksum)
+		}
+		if upgraded != nil {
+			fmt.Printf("%v: upgraded items %v checksum %x\n", slice, upgraded.Count, upgraded.Checksum)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v: verified\n", slice)
+
+	case "rollback":
+		if err := indexer.RollbackSliceUpgrade(slice); err != nil {
+			return err
+		}
+		fmt.Printf("%v: rolled back\n", slice)
+
+	case "commit":
+		if err := indexer.CommitSliceUpgrade(slice); err != nil {
+			return err
+		}
+		fmt.Printf("%v: committed\n", slice)
+
+	default:
+		return fmt.Errorf("unknown command %v", options.command)
+	}
+
+	return nil
+}
@@ -51,6 +51,7 @@ func main() {
 	keyFile := fset.String("keyFile", "", "Index https cert key file")
 	isEnterprise := fset.Bool("isEnterprise", true, "Enterprise Edition")
 	isIPv6 := fset.Bool("ipv6", false, "IPV6 cluster")
+	alertWebhookAllowlist := fset.String("alertWebhookAllowlist", "", "Comma separated hosts to which alert webhooks may post")
 
 	for i := 1; i < len(os.Args); i++ {
 		if err := fset.Parse(os.Args[i : i+1]); err != nil {
@@ -94,6 +95,7 @@ func main() {
 	config.SetValue("indexer.nodeuuid", *nodeuuid)
 	config.SetValue("indexer.isEnterprise", *isEnterprise)
 	config.SetValue("indexer.isIPv6", *isIPv6)
+	config.SetValue("indexer.alert.webhook_allowlist", *alertWebhookAllowlist)
 
 	// Prior to watson (4.5 version) storage_dir parameter was converted
 	// to lower case. Post watson, the plan is to keep the parameter
@@ -162,10 +164,15 @@ func main() {
 
 	common.SetIpv6(*isIPv6)
 
-	_, msg := indexer.NewIndexer(config)
+	idx, err := indexer.NewIndexer(config)
+	if err == nil {
+		err = idx.Start()
+	}
 
-	if msg.GetMsgType() != indexer.MSG_SUCCESS {
-		logging.Warnf("Indexer Failure to Init %v", msg)
+	if err != nil {
+		logging.Warnf("Indexer Failure to Init %v", err)
+	} else {
+		idx.Wait()
 	}
 
 	logging.Infof("Indexer exiting normally\n")
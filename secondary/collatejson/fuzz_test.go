@@ -0,0 +1,398 @@
+//  Copyright (c) 2018 Couchbase, Inc.
+
+package collatejson
+
+import "bytes"
+import "encoding/json"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "math"
+import "math/rand"
+import "os"
+import "path/filepath"
+import "reflect"
+import "strings"
+import "testing"
+import "time"
+
+import qv "github.com/couchbase/query/value"
+
+// Fuzz testing of the codec. Random JSON values are generated and
+// checked for,
+//   * round trip, Decode(Encode(x)) is x.
+//   * EncodeN1QLValue(x) is same as Encode(x).
+//   * order, encoded values compare like their N1QL collation.
+//   * composite keys, ExplodeArray and JoinArray give back the key, and
+//     reverse collated keys compare like their N1QL collation with desc
+//     elements inverted.
+//
+// Objects are only round tripped, as their properties are collated as
+// (key, value) pairs, where N1QL collates all the keys before values.
+//
+// Values that fail are saved in fuzzCorpus with -fuzz.save, and every
+// file in fuzzCorpus is checked on each run, one value per line. Run
+// with -fuzz.iterations and -fuzz.seed to fuzz longer, or to repeat a
+// failed run.
+
+var fuzzIterations = flag.Int("fuzz.iterations", 1000, "number of random values to check")
+var fuzzSeed = flag.Int64("fuzz.seed", 0, "seed for random values, 0 for current time")
+var fuzzSave = flag.Bool("fuzz.save", false, "save failed values in fuzz corpus")
+
+// kept out of testData, where every file has a reference.
+var fuzzCorpus = "./fuzzcorpus"
+
+const fuzzMaxDepth = 3
+
+type fuzzGen struct {
+	rnd      *rand.Rand
+	noObject bool
+}
+
+func (g *fuzzGen) value(depth int) interface{} {
+	n := 7
+	if depth >= fuzzMaxDepth {
+		n = 5 // scalars only
+	} else if g.noObject {
+		n = 6
+	}
+	switch g.rnd.Intn(n) {
+	case 0:
+		return nil
+	case 1:
+		return g.rnd.Intn(2) == 0
+	case 2:
+		return g.integer()
+	case 3:
+		return g.float()
+	case 4:
+		return g.str()
+	case 5:
+		arr := make([]interface{}, g.rnd.Intn(4))
+		for i := range arr {
+			arr[i] = g.value(depth + 1)
+		}
+		return arr
+	}
+	obj := make(map[string]interface{})
+	for i := g.rnd.Intn(4); i > 0; i-- {
+		obj[g.str()] = g.value(depth + 1)
+	}
+	return obj
+}
+
+// integers are exact in float64, upto 2^53.
+func (g *fuzzGen) integer() float64 {
+	var x int64
+	switch g.rnd.Intn(3) {
+	case 0:
+		x = int64(g.rnd.Intn(21)) - 10
+	case 1:
+		x = g.rnd.Int63n(1 << 32)
+	default:
+		x = g.rnd.Int63n(1 << 53)
+	}
+	if g.rnd.Intn(2) == 0 {
+		x = -x
+	}
+	return float64(x)
+}
+
+func (g *fuzzGen) float() float64 {
+	f := g.rnd.Float64() * math.Pow(10, float64(g.rnd.Intn(41)-20))
+	if g.rnd.Intn(2) == 0 {
+		f = -f
+	}
+	return f
+}
+
+var fuzzRunes = []rune("aAbz09 _-.~\"\\/\t\n\x00\x01éÿĀ世\U0001f600")
+
+func (g *fuzzGen) str() string {
+	rs := make([]rune, g.rnd.Intn(6))
+	for i := range rs {
+		rs[i] = fuzzRunes[g.rnd.Intn(len(fuzzRunes))]
+	}
+	return string(rs)
+}
+
+// compositeKey returns a key of n elements, that can be MISSING.
+func (g *fuzzGen) compositeKey(n int) []interface{} {
+	key := make([]interface{}, n)
+	for i := range key {
+		if g.rnd.Intn(8) == 0 {
+			key[i] = string(MissingLiteral)
+		} else {
+			key[i] = g.value(1)
+		}
+	}
+	return key
+}
+
+// n1qlCollate returns the sign of N1QL collation of JSON values,
+// MissingLiteral being MISSING.
+func n1qlCollate(val1, val2 interface{}) int {
+	arr1, ok1 := val1.([]interface{})
+	arr2, ok2 := val2.([]interface{})
+	if ok1 && ok2 { // compare elements, then lengths
+		for i := 0; i < len(arr1) && i < len(arr2); i++ {
+			if cmp := n1qlCollate(arr1[i], arr2[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return collateSign(len(arr1) - len(arr2))
+	}
+	return collateSign(n1qlValue(val1).Collate(n1qlValue(val2)))
+}
+
+func n1qlValue(val interface{}) qv.Value {
+	if s, ok := val.(string); ok && MissingLiteral.Equal(s) {
+		return qv.NewMissingValue()
+	}
+	return qv.NewValue(val)
+}
+
+func collateSign(x int) int {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkRoundTrip returns an error if text does not survive encoding.
+func checkRoundTrip(codec *Codec, text []byte) error {
+	var val, decVal interface{}
+	if err := json.Unmarshal(text, &val); err != nil {
+		return err
+	}
+
+	code, err := codec.Encode(text, make([]byte, 0, 3*len(text)+MinBufferSize))
+	if err != nil {
+		return fmt.Errorf("Encode: %v", err)
+	}
+	dec, err := codec.Decode(code, make([]byte, 0, 3*len(code)+MinBufferSize))
+	if err != nil {
+		return fmt.Errorf("Decode: %v", err)
+	} else if err := json.Unmarshal(dec, &decVal); err != nil {
+		return fmt.Errorf("Decode gave %s: %v", dec, err)
+	} else if !reflect.DeepEqual(val, decVal) {
+		return fmt.Errorf("Decode gave %s", dec)
+	}
+
+	if strings.Contains(string(text), string(MissingLiteral)) {
+		return nil // not a N1QL value
+	}
+	n1qlCode, err := codec.EncodeN1QLValue(qv.NewValue(val), make([]byte, 0, 3*len(text)+MinBufferSize))
+	if err != nil {
+		return fmt.Errorf("EncodeN1QLValue: %v", err)
+	} else if !bytes.Equal(code, n1qlCode) {
+		return fmt.Errorf("EncodeN1QLValue gave %q, Encode gave %q", n1qlCode, code)
+	}
+	return nil
+}
+
+// checkOrder returns an error if encoded texts do not compare like
+// their N1QL collation.
+func checkOrder(codec *Codec, text1, text2 []byte) error {
+	var val1, val2 interface{}
+	if err := json.Unmarshal(text1, &val1); err != nil {
+		return err
+	} else if err := json.Unmarshal(text2, &val2); err != nil {
+		return err
+	}
+
+	code1, err := codec.Encode(text1, make([]byte, 0, 3*len(text1)+MinBufferSize))
+	if err != nil {
+		return err
+	}
+	code2, err := codec.Encode(text2, make([]byte, 0, 3*len(text2)+MinBufferSize))
+	if err != nil {
+		return err
+	}
+
+	expected := n1qlCollate(val1, val2)
+	if cmp := bytes.Compare(code1, code2); cmp != expected {
+		return fmt.Errorf("encoded compare %v, N1QL collate %v", cmp, expected)
+	}
+	return nil
+}
+
+// checkCompositeKeys returns an error if composite keys key1, key2 do
+// not explode, join and compare with desc elements as expected.
+func checkCompositeKeys(codec *Codec, text1, text2 []byte, desc []bool) error {
+	var key1, key2 []interface{}
+	if err := json.Unmarshal(text1, &key1); err != nil {
+		return err
+	} else if err := json.Unmarshal(text2, &key2); err != nil {
+		return err
+	} else if len(key1) != len(desc) || len(key2) != len(desc) {
+		return nil
+	}
+
+	codes := make([][]byte, 2)
+	for i, text := range [][]byte{text1, text2} {
+		code, err := codec.Encode(text, make([]byte, 0, 3*len(text)+MinBufferSize))
+		if err != nil {
+			return err
+		}
+		elems, err := codec.ExplodeArray(code, make([]byte, 0, 3*len(code)+MinBufferSize))
+		if err != nil {
+			return fmt.Errorf("ExplodeArray: %v", err)
+		}
+		joined, err := codec.JoinArray(elems, make([]byte, 0, len(code)))
+		if err != nil {
+			return fmt.Errorf("JoinArray: %v", err)
+		} else if !bytes.Equal(joined, code) {
+			return fmt.Errorf("JoinArray gave %q, expected %q", joined, code)
+		}
+
+		reversed := codec.ReverseCollate(append([]byte(nil), code...), desc)
+		orig := codec.ReverseCollate(append([]byte(nil), reversed...), desc)
+		if !bytes.Equal(orig, code) {
+			return fmt.Errorf("ReverseCollate twice gave %q, expected %q", orig, code)
+		}
+		codes[i] = reversed
+	}
+
+	expected := 0
+	for i := range desc {
+		cmp := n1qlCollate(key1[i], key2[i])
+		if desc[i] {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			expected = cmp
+			break
+		}
+	}
+	if cmp := bytes.Compare(codes[0], codes[1]); cmp != expected {
+		return fmt.Errorf("desc %v reversed compare %v, N1QL collate %v", desc, cmp, expected)
+	}
+	return nil
+}
+
+// checkFuzzPair runs all checks on a pair of values.
+func checkFuzzPair(codec *Codec, text1, text2 []byte, ordered bool) error {
+	for _, text := range [][]byte{text1, text2} {
+		if err := checkRoundTrip(codec, text); err != nil {
+			return err
+		}
+	}
+	if !ordered {
+		return nil
+	}
+	if err := checkOrder(codec, text1, text2); err != nil {
+		return err
+	}
+
+	var key []interface{}
+	if json.Unmarshal(text1, &key) != nil || len(key) == 0 || len(key) > 4 {
+		return nil
+	}
+	for mask := 0; mask < 1<<uint(len(key)); mask++ {
+		desc := make([]bool, len(key))
+		for i := range desc {
+			desc[i] = mask&(1<<uint(i)) != 0
+		}
+		if err := checkCompositeKeys(codec, text1, text2, desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasObject(val interface{}) bool {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		for _, x := range v {
+			if hasObject(x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func saveFuzzPair(t *testing.T, seed int64, i int, text1, text2 []byte) {
+	if err := os.MkdirAll(fuzzCorpus, 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	filename := filepath.Join(fuzzCorpus, fmt.Sprintf("seed%v-%v", seed, i))
+	data := append(append(append(text1, '\n'), text2...), '\n')
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	t.Logf("saved %v", filename)
+}
+
+func TestFuzzCorpus(t *testing.T) {
+	fs, err := ioutil.ReadDir(fuzzCorpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := NewCodec(16)
+	for _, f := range fs {
+		lines := readLines(filepath.Join(fuzzCorpus, f.Name()), t)
+		for i := range lines {
+			for j := i; j < len(lines); j++ {
+				var val1, val2 interface{}
+				json.Unmarshal(lines[i], &val1)
+				json.Unmarshal(lines[j], &val2)
+				ordered := !hasObject(val1) && !hasObject(val2)
+				if err := checkFuzzPair(codec, lines[i], lines[j], ordered); err != nil {
+					t.Errorf("%v: %s, %s: %v", f.Name(), lines[i], lines[j], err)
+				}
+			}
+		}
+	}
+}
+
+func TestFuzzCollate(t *testing.T) {
+	seed := *fuzzSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("fuzz seed %v", seed)
+
+	codec := NewCodec(16)
+	rnd := rand.New(rand.NewSource(seed))
+	failed := 0
+	for i := 0; i < *fuzzIterations && failed < 10; i++ {
+		var val1, val2 interface{}
+		ordered := i%4 != 0
+		gen := &fuzzGen{rnd: rnd, noObject: ordered}
+		if ordered && i%2 == 1 {
+			n := 1 + rnd.Intn(4)
+			val1, val2 = gen.compositeKey(n), gen.compositeKey(n)
+		} else {
+			val1, val2 = gen.value(0), gen.value(0)
+		}
+
+		text1, err := json.Marshal(val1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text2, err := json.Marshal(val2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(text1)+string(text2), "\n") {
+			t.Fatalf("values must fit a line of the corpus, %s %s", text1, text2)
+		}
+
+		if err := checkFuzzPair(codec, text1, text2, ordered); err != nil {
+			failed++
+			t.Errorf("seed %v iteration %v: %s, %s: %v", seed, i, text1, text2, err)
+			if *fuzzSave {
+				saveFuzzPair(t, seed, i, text1, text2)
+			}
+		}
+	}
+}
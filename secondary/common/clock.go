@@ -0,0 +1,106 @@
+package common
+
+import "sort"
+import "sync"
+import "time"
+
+// Clock is the source of time for components which schedule work based
+// on wall clock time. Use SystemClock in production, and FakeClock for
+// deterministic unit tests that must not sleep.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current
+	// time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock implements Clock using the time package.
+type SystemClock struct{}
+
+// Now implements Clock{} interface.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements Clock{} interface.
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock implements Clock whose time moves only when Advance is
+// called, firing the channels returned by After in order of their
+// deadline.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters fakeClockWaiters
+}
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+type fakeClockWaiters []*fakeClockWaiter
+
+func (ws fakeClockWaiters) Len() int           { return len(ws) }
+func (ws fakeClockWaiters) Swap(i, j int)      { ws[i], ws[j] = ws[j], ws[i] }
+func (ws fakeClockWaiters) Less(i, j int) bool { return ws[i].deadline.Before(ws[j].deadline) }
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now implements Clock{} interface.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+// After implements Clock{} interface.
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.now
+		return ch
+	}
+	w := &fakeClockWaiter{deadline: fc.now.Add(d), ch: ch}
+	fc.waiters = append(fc.waiters, w)
+	return ch
+}
+
+// Advance moves the clock forward by d, and fires all the waiters whose
+// deadline has elapsed.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.now = fc.now.Add(d)
+
+	sort.Stable(fc.waiters)
+	n := 0
+	for _, w := range fc.waiters {
+		if w.deadline.After(fc.now) {
+			fc.waiters[n] = w
+			n++
+			continue
+		}
+		w.ch <- fc.now
+	}
+	fc.waiters = fc.waiters[:n]
+}
+
+// NumWaiters returns the number of pending After() calls, which tests
+// can use to synchronize with the code under test before Advance.
+func (fc *FakeClock) NumWaiters() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return len(fc.waiters)
+}
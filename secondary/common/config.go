@@ -135,6 +135,15 @@ var SystemConfig = Config{
 		false,      // mutable
 		false,      // case-insensitive
 	},
+	"projector.feedAckStuckTimeout": ConfigValue{
+		120 * 1000,
+		"timeout, in milliseconds, after which an endpoint is reported " +
+			"stuck if its acknowledged timestamps do not advance while " +
+			"mutations are pending for it, 0 disables",
+		120 * 1000, // 120s
+		false,      // mutable
+		false,      // case-insensitive
+	},
 	"projector.mutationChanSize": ConfigValue{
 		500,
 		"channel size of projector's vbucket workers, " +
@@ -173,6 +182,41 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"projector.evaluatorPoolSize": ConfigValue{
+		-1,
+		"number of routines evaluating secondary expressions, shared " +
+			"by all buckets. ZERO sizes the pool to GOMAXPROCS, negative " +
+			"value, the default, evaluates expressions in vbucket worker " +
+			"routines.",
+		-1,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.guardrails.maxDocSize": ConfigValue{
+		0,
+		"documents larger than this, in bytes, are not evaluated, " +
+			"0 disables the limit",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.guardrails.maxKeySize": ConfigValue{
+		0,
+		"secondary keys larger than this, in bytes, are not sent " +
+			"downstream, 0 disables the limit",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.guardrails.policy": ConfigValue{
+		"skip",
+		"policy for documents over maxDocSize or keys over maxKeySize, " +
+			"skip - skip the document, error - handle as an evaluation " +
+			"error as per the index's evaluation error policy",
+		"skip",
+		false, // mutable
+		false, // case-insensitive
+	},
 	"projector.syncTimeout": ConfigValue{
 		2000,
 		"timeout, in milliseconds, for sending periodic Sync messages, " +
@@ -232,6 +276,23 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"projector.topicRecovery.dir": ConfigValue{
+		"",
+		"directory to persist topic definitions, so that a restarted " +
+			"projector re-opens its topics. Empty string disables " +
+			"topic recovery.",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"projector.topicRecovery.checkpointInterval": ConfigValue{
+		10 * 1000, // 10 seconds
+		"periodic tick, in milli-seconds to save vbucket seqnos processed " +
+			"by each topic, for topic recovery.",
+		10 * 1000,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	// projector dcp parameters
 	"projector.dcp.genChanSize": ConfigValue{
 		2048,
@@ -367,6 +428,76 @@ var SystemConfig = Config{
 		true,        // immutable
 		false,       // case-insensitive
 	},
+	"projector.dataport.maxFrameSize": ConfigValue{
+		0,
+		"maximum frame length, in bytes, for transmission data from " +
+			"router to downstream client, batches larger than this " +
+			"are split across frames, 0 does not limit the frame " +
+			"length, applies only to downstream advertising support " +
+			"for fragments, does not affect existing feeds.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.frameChecksum": ConfigValue{
+		false,
+		"send CRC32C checksum with every frame, to be validated by " +
+			"indexer, applies only to downstream advertising support " +
+			"for checksums, does not affect existing feeds.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.tcpNoDelay": ConfigValue{
+		true,
+		"disable Nagle's algorithm on dataport connections, " +
+			"does not affect existing connections.",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.tcpKeepAliveInterval": ConfigValue{
+		0,
+		"keep alive interval, in seconds, to set on dataport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.tcpReadBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket receive buffer for dataport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.tcpWriteBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket send buffer for dataport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.writeCoalesceSize": ConfigValue{
+		0,
+		"size, in bytes, of the buffer coalescing writes to dataport " +
+			"connections, 0 writes through.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.dataport.writeCoalesceInterval": ConfigValue{
+		0,
+		"interval, in milliseconds, after which coalesced writes to " +
+			"dataport connections are flushed by the next write, 0 " +
+			"flushes only when the buffer is full or a batch ends.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+
 	"projector.dataport.statTick": ConfigValue{
 		5 * 60 * 1000, // 5 minutes
 		"tick, in milliseconds, to log endpoint statistics",
@@ -374,6 +505,81 @@ var SystemConfig = Config{
 		false,         // mutable
 		false,         // case-insensitive
 	},
+	"projector.dataport.maxMutationRate": ConfigValue{
+		0,
+		"maximum number of mutations per second sent by an endpoint, " +
+			"0 is unlimited.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dataport.highWatermark": ConfigValue{
+		50000,
+		"number of mutations buffered by an endpoint, at which upstream " +
+			"vbucket routines are paused, 0 disables flow control. " +
+			"Shall be less than keyChanSize.",
+		50000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dataport.lowWatermark": ConfigValue{
+		10000,
+		"number of mutations buffered by an endpoint, at which paused " +
+			"upstream vbucket routines are resumed.",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	// projector dataport client parameters for initial index build,
+	// override projector.dataport.* for backfill feeds.
+	"projector.backfill.dataport.keyChanSize": ConfigValue{
+		100000,
+		"channel size of backfill endpoints data input, " +
+			"does not affect existing feeds.",
+		100000,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.backfill.dataport.bufferSize": ConfigValue{
+		100,
+		"number of entries to buffer before flushing it, for " +
+			"backfill endpoints.",
+		100,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.backfill.dataport.bufferTimeout": ConfigValue{
+		25,
+		"timeout in milliseconds, to flush vbucket-mutations from " +
+			"backfill endpoints.",
+		25,    // 25ms
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.backfill.dataport.maxMutationRate": ConfigValue{
+		0,
+		"maximum number of mutations per second sent by a backfill " +
+			"endpoint, 0 is unlimited.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.backfill.dataport.highWatermark": ConfigValue{
+		50000,
+		"number of mutations buffered by a backfill endpoint, at which " +
+			"upstream vbucket routines are paused, 0 disables flow control.",
+		50000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.backfill.dataport.lowWatermark": ConfigValue{
+		10000,
+		"number of mutations buffered by a backfill endpoint, at which " +
+			"paused upstream vbucket routines are resumed.",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"projector.gogc": ConfigValue{
 		100, // 100 percent
 		"set GOGC percent",
@@ -432,6 +638,24 @@ var SystemConfig = Config{
 		true,        // immutable
 		false,       // case-insensitive
 	},
+	"indexer.dataport.maxFrameSize": ConfigValue{
+		16 * 1024 * 1024,
+		"maximum frame length, in bytes, for receiving data from router, " +
+			"connections sending larger frames are closed, 0 does not " +
+			"limit the frame length. Shall be larger than " +
+			"projector.dataport.maxFrameSize.",
+		16 * 1024 * 1024, // 16MB
+		true,             // immutable
+		false,            // case-insensitive
+	},
+	"indexer.dataport.maxAssembledSize": ConfigValue{
+		64 * 1024 * 1024,
+		"maximum length, in bytes, of data reassembled from frames " +
+			"received from router, 0 does not limit the length.",
+		64 * 1024 * 1024, // 64MB
+		true,             // immutable
+		false,            // case-insensitive
+	},
 	"indexer.dataport.tcpReadDeadline": ConfigValue{
 		300 * 1000,
 		"timeout, in milliseconds, while reading from socket, " +
@@ -441,6 +665,72 @@ var SystemConfig = Config{
 		false,      // mutable
 		false,      // case-insensitive
 	},
+	"indexer.dataport.pooledDecode": ConfigValue{
+		true,
+		"decode mutations into pooled buffers, without copying keys",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.maxConnections": ConfigValue{
+		0,
+		"maximum connections from routers to a stream of the indexer, " +
+			"further connections are closed, 0 does not limit connections.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.maxHostConnections": ConfigValue{
+		0,
+		"maximum connections from a router host to a stream of the " +
+			"indexer, further connections are closed, 0 does not limit " +
+			"connections.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.packetReadTimeout": ConfigValue{
+		60 * 1000,
+		"timeout, in milliseconds, to receive a packet once its first " +
+			"byte is received, connections exceeding it are closed, " +
+			"0 only applies indexer.dataport.tcpReadDeadline.",
+		60 * 1000, // 60s
+		true,      // immutable
+		false,     // case-insensitive
+	},
+	"indexer.dataport.tcpNoDelay": ConfigValue{
+		true,
+		"disable Nagle's algorithm on dataport connections, " +
+			"does not affect existing connections.",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.tcpKeepAliveInterval": ConfigValue{
+		0,
+		"keep alive interval, in seconds, to set on dataport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.tcpReadBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket receive buffer for dataport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.tcpWriteBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket send buffer for dataport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+
 	// indexer queryport configuration
 	"indexer.queryport.maxPayload": ConfigValue{
 		64 * 1024,
@@ -484,6 +774,92 @@ var SystemConfig = Config{
 		false, // immutable
 		false, // case-insensitive
 	},
+	"indexer.queryport.tcpNoDelay": ConfigValue{
+		true,
+		"disable Nagle's algorithm on queryport connections, " +
+			"does not affect existing connections.",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.tcpReadBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket receive buffer for queryport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.tcpWriteBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket send buffer for queryport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.writeCoalesceSize": ConfigValue{
+		0,
+		"size, in bytes, of the buffer coalescing writes to queryport " +
+			"connections, 0 writes through.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.writeCoalesceInterval": ConfigValue{
+		0,
+		"interval, in milliseconds, after which coalesced writes to " +
+			"queryport connections are flushed by the next write, 0 " +
+			"flushes only when the buffer is full or a batch ends.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+
+	"indexer.queryport.tls.enabled": ConfigValue{
+		false,
+		"serve queryport connections over TLS",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.tls.certFile": ConfigValue{
+		"",
+		"TLS certificate for queryport",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"indexer.queryport.tls.keyFile": ConfigValue{
+		"",
+		"TLS certificate key for queryport",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"indexer.queryport.tls.caFile": ConfigValue{
+		"",
+		"CA certificate to verify client certificates, " +
+			"defaults to tls.certFile",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"indexer.queryport.tls.clientAuth": ConfigValue{
+		"none",
+		"client certificate authentication, one of none, optional, mandatory",
+		"none",
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.auth.enabled": ConfigValue{
+		false,
+		"require queryport clients to authenticate with a verified " +
+			"certificate or with username/password",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	// queryport client configuration
 	"queryport.client.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -595,6 +971,38 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.load.errorRateThreshold": ConfigValue{
+		0.5,
+		"recent error rate of scans above which replicas on an indexer " +
+			"node are avoided, if ZERO error rate is not considered.",
+		0.5,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.failover.heartbeatInterval": ConfigValue{
+		0,
+		"interval in milliseconds between heartbeats to indexer nodes, " +
+			"if ZERO failure detection is disabled.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.failover.heartbeatMisses": ConfigValue{
+		3,
+		"number of consecutive missed heartbeats to declare an indexer " +
+			"node dead.",
+		3,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.failover.promoteReplica": ConfigValue{
+		false,
+		"scan partitions from a live replica when the indexer node " +
+			"hosting the partition is dead.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"queryport.client.settings.backfillLimit": ConfigValue{
 		5 * 1024, // 5GB
 		"limit in mega-bytes to cap n1ql side backfilling, if ZERO backfill " +
@@ -603,6 +1011,14 @@ var SystemConfig = Config{
 		false,    // mutable
 		false,    // case-insensitive
 	},
+	"queryport.client.settings.previewSampleSize": ConfigValue{
+		0,
+		"number of documents sampled from the bucket to estimate the size " +
+			"of an index before it is created, if ZERO estimation is disabled.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"queryport.client.scanLagPercent": ConfigValue{
 		0.2,
 		"allowed threshold on mutation lag from fastest replica during scan, " +
@@ -647,6 +1063,97 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"queryport.client.tls.enabled": ConfigValue{
+		false,
+		"connect to queryport over TLS",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tls.caFile": ConfigValue{
+		"",
+		"CA certificate to verify queryport server certificate",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"queryport.client.tls.certFile": ConfigValue{
+		"",
+		"client certificate presented to queryport, if any",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"queryport.client.tls.keyFile": ConfigValue{
+		"",
+		"client certificate key",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"queryport.client.settings.scanPriority": ConfigValue{
+		"interactive",
+		"priority of scans issued by this client, interactive or batch",
+		"interactive",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.settings.rowEncoding": ConfigValue{
+		"default",
+		"encoding of rows in scan responses, default, packed or " +
+			"packed_dict. Used only when supported by the indexer",
+		"default",
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tcpNoDelay": ConfigValue{
+		true,
+		"disable Nagle's algorithm on queryport connections, " +
+			"does not affect existing connections.",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tcpKeepAliveInterval": ConfigValue{
+		0,
+		"keep alive interval, in seconds, to set on queryport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tcpReadBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket receive buffer for queryport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tcpWriteBufferSize": ConfigValue{
+		0,
+		"size, in bytes, of the socket send buffer for queryport " +
+			"connections, 0 leaves the system default.",
+		0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+
+	"queryport.client.settings.readPreference": ConfigValue{
+		"nearest",
+		"replica preferred for scans issued by this client, " +
+			"nearest, primary or replica_ok",
+		"nearest",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.auth.enabled": ConfigValue{
+		false,
+		"authenticate queryport connections with cluster credentials",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	// projector's adminport client, can be used by indexer.
 	"indexer.projectorclient.retryInterval": ConfigValue{
 		16,
@@ -795,6 +1302,15 @@ var SystemConfig = Config{
 		true, // immutable
 		true, // case-sensitive
 	},
+	"indexer.alert.webhook_allowlist": ConfigValue{
+		"",
+		"Comma separated list of hosts, as host or host:port, to which " +
+			"alert webhooks may post. Set on the command line, and cannot " +
+			"be changed through settings.",
+		"",
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"indexer.nodeuuid": ConfigValue{
 		"",
 		"Indexer node UUID",
@@ -1362,6 +1878,14 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.mutation_queue.coalesceDuplicates": ConfigValue{
+		false,
+		"coalesce back to back mutations for the same docid, " +
+			"within a flush, storing only the latest one",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.memstatTick": ConfigValue{
 		60, // in second
 		"in second, periodically log runtime memory-stats.",
@@ -1428,6 +1952,14 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.mutation_manager.flushBatchSize": ConfigValue{
+		256,
+		"Max number of queued mutations of a vbucket applied to storage " +
+			"as a single batch sorted by index and docid",
+		256,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.mutation_manager.maxQueueMem": ConfigValue{
 		uint64(1 * 1024 * 1024 * 1024),
 		"Max memory used by the mutation queue",
@@ -1435,6 +1967,25 @@ var SystemConfig = Config{
 		false,
 		false,
 	},
+	"indexer.mutation_manager.indexQueueMemFrac": ConfigValue{
+		0.0,
+		"Max fraction of mutation queue memory used by a single index, " +
+			"for both incremental and initial build streams. Enqueue of " +
+			"mutations for an index over the limit is throttled. " +
+			"0 disables the limit.",
+		0.0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.mutation_manager.indexQueueThrottleTimeout": ConfigValue{
+		uint64(300000),
+		"Max time in milliseconds an enqueue of mutations for an index " +
+			"over its memory limit is throttled, before it is let through. " +
+			"0 throttles till the index drains below the limit.",
+		uint64(300000),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.gc_percent": ConfigValue{
 		100,
 		"(GOGC) Ratio of current heap size over heap size from last GC." +
@@ -1462,6 +2013,51 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.stream.vb_repair_budget": ConfigValue{
+		0,
+		"Number of repairs of a vbucket of a stream, without receiving " +
+			"StreamBegin, after which the vbucket is quarantined and no " +
+			"longer repaired. Indexes of the stream are marked partially " +
+			"available. 0 repairs forever.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.ingest_lag.threshold": ConfigValue{
+		uint64(0),
+		"Ingest lag, as the number of KV seqnos not yet applied to the " +
+			"last snapshot of an index, above which an ingest lag event " +
+			"is raised. 0 disables ingest lag events.",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.ingest_lag.duration": ConfigValue{
+		uint64(300),
+		"Time in seconds for which the ingest lag of an index must stay " +
+			"above indexer.settings.ingest_lag.threshold to raise an " +
+			"ingest lag event.",
+		uint64(300),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.alert.webhooks": ConfigValue{
+		"",
+		"Comma separated list of URLs to which alerts, like sustained " +
+			"ingest lag, memory quota breach, stream quarantine and storage " +
+			"corruption, are posted as JSON. The host of every URL must be " +
+			"in indexer.alert.webhook_allowlist.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.alert.timeout": ConfigValue{
+		10,
+		"Timeout in seconds to post an alert to a webhook.",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.http.readTimeout": ConfigValue{
 		1200,
 		"timeout in seconds, is indexer http server's read timeout",
@@ -1577,6 +2173,66 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.persisted_snapshot.mutation_threshold": ConfigValue{
+		uint64(0),
+		"Persist a snapshot once this many mutations are queued since " +
+			"the last persisted snapshot, or at the persisted snapshot " +
+			"interval, whichever comes first. 0 disables",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.stream_ack.interval": ConfigValue{
+		uint64(5000),
+		"Minimum interval, in milliseconds, between acknowledgements of " +
+			"snapshot timestamps to projectors, per stream and bucket. " +
+			"0 disables acknowledgements",
+		uint64(5000),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.persisted_snapshot.bytes_threshold": ConfigValue{
+		uint64(0),
+		"Persist a snapshot once this many bytes of index keys are " +
+			"queued since the last persisted snapshot, or at the persisted " +
+			"snapshot interval, whichever comes first. 0 disables",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.persisted_snapshot.init_stream.interval": ConfigValue{
+		uint64(0),
+		"Persisted snapshotting interval in milliseconds for INIT_STREAM, " +
+			"restart points of long index builds. 0 uses the interval of " +
+			"the storage mode",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.inmemory_snapshot.init_stream.interval": ConfigValue{
+		uint64(0),
+		"InMemory snapshotting interval in milliseconds for INIT_STREAM. " +
+			"0 uses the interval of the storage mode",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.ts_history.window": ConfigValue{
+		uint64(0),
+		"Seconds of stability timestamps kept in memory per stream and " +
+			"bucket, 0 disables the history",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.ts_history.interval": ConfigValue{
+		uint64(1000),
+		"Minimum interval in milliseconds between stability timestamps " +
+			"kept in the history",
+		uint64(1000),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.moi.recovery.max_rollbacks": ConfigValue{
 		2,
 		"Maximum number of committed rollback points",
@@ -1614,6 +2270,24 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.cpu_quota.ingest_percent": ConfigValue{
+		0,
+		"percent of the cores available to the indexer, as set by " +
+			"max_cpu_percent, that index writers of all indexes can use " +
+			"at a time, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.cpu_quota.scan_percent": ConfigValue{
+		0,
+		"percent of the cores available to the indexer, as set by " +
+			"max_cpu_percent, that scans can use at a time, it caps the " +
+			"scans run concurrently by the scan scheduler, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.log_level": ConfigValue{
 		"info", // keep in sync with index_settings_manager.erl
 		"Indexer logging level",
@@ -1628,6 +2302,159 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"indexer.settings.scan_consistency_wait": ConfigValue{
+		0,
+		"timeout, in milliseconds, to wait for a snapshot consistent with " +
+			"the timestamp of a session or at_plus scan, 0 waits till the scan times out",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.diagnostics.enable": ConfigValue{
+		true,
+		"capture a diagnostics bundle when the indexer crashes on a " +
+			"fatal error",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.diagnostics.dir": ConfigValue{
+		"",
+		"directory of diagnostics bundles, defaults to the diagnostics " +
+			"directory under the storage directory",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.diagnostics.max_bundles": ConfigValue{
+		10,
+		"number of most recent diagnostics bundles kept, older bundles " +
+			"are deleted when a new bundle is captured",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.storage_dirs": ConfigValue{
+		"",
+		"comma separated data directories, in addition to the storage " +
+			"directory, new index slices are placed on the data directory " +
+			"with the fewest slices. A directory holding slices must not be " +
+			"removed from the list",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
+	"indexer.settings.diagnostics.stream_events": ConfigValue{
+		100,
+		"number of most recent stream events kept for diagnostics bundles",
+		100,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_slowlog.latency_threshold": ConfigValue{
+		0,
+		"scans taking longer than this, in milliseconds, including their " +
+			"wait for a snapshot, are recorded in the slow scan log, 0 disables",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_slowlog.rows_threshold": ConfigValue{
+		0,
+		"scans examining more rows than this are recorded in the " +
+			"slow scan log, 0 disables",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_slowlog.size": ConfigValue{
+		100,
+		"number of most recent slow scans kept in the slow scan log",
+		100,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.verify.interval": ConfigValue{
+		0,
+		"interval, in seconds, between background verification of a " +
+			"sample of each index against KV, 0 disables",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.verify.sample_size": ConfigValue{
+		1000,
+		"number of documents sampled from the index, and from the bucket, " +
+			"to verify an index against KV",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_scheduler.max_concurrent": ConfigValue{
+		0,
+		"maximum number of scans run concurrently, further scans are " +
+			"queued with interactive scans ahead of batch scans, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_scheduler.max_batch_concurrent": ConfigValue{
+		4,
+		"maximum number of batch priority scans run concurrently, " +
+			"0 is unlimited",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_intersect.max_docids": ConfigValue{
+		1000000,
+		"maximum number of docids collected from each scan of an " +
+			"intersect request, 0 is unlimited",
+		1000000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.profile": ConfigValue{
+		"standard",
+		"profile of coherent defaults for queue sizes, snapshot intervals, " +
+			"rollback points, compaction and scan concurrency, applied when " +
+			"set through the settings API (standard, memory_optimized, ingest_heavy)",
+		"standard",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.admission.high_mem_frac": ConfigValue{
+		0.0,
+		"fraction of memory_quota above which batch priority scans " +
+			"are rejected and INIT streams are throttled, 0 disables",
+		0.0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.admission.critical_mem_frac": ConfigValue{
+		0.0,
+		"fraction of memory_quota above which all scans are rejected, " +
+			"0 disables",
+		0.0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.throttle.commit_latency_high": ConfigValue{
+		0,
+		"average storage commit latency in milliseconds above which " +
+			"mutations of all streams are throttled, 0 disables",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.throttle.commit_latency_low": ConfigValue{
+		0,
+		"average storage commit latency in milliseconds below which " +
+			"throttled streams are resumed",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.max_array_seckey_size": ConfigValue{
 		10240,
 		"Maximum size of secondary index key size for array index",
@@ -1759,6 +2586,24 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.forestdb.read_cache_size": ConfigValue{
+		uint64(0),
+		"Size of read cache, in bytes, for forestdb slices. Read cache " +
+			"is carved out of memory quota, 0 disables the cache. " +
+			"Change takes effect on restart",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.key_stats.histogram_buckets": ConfigValue{
+		0,
+		"Number of buckets of the histogram on the leading key, maintained " +
+			"when an index is compacted, 0 disables key statistics. " +
+			"Change takes effect on restart",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.fast_flush_mode": ConfigValue{
 		true,
 		"Skips InMem Snapshots When Indexer Is Backed Up",
@@ -1942,6 +2787,14 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.rebalance.ship_snapshot": ConfigValue{
+		false,
+		"bootstrap forestdb indexes moved or repaired during rebalance from the " +
+			"latest persisted snapshot of a peer replica, instead of building from scratch",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.storage_mode.disable_upgrade": ConfigValue{
 		false,
 		"Disable upgrading storage mode. This is checked on every indexer restart, " +
@@ -29,6 +29,12 @@ var ErrorInvalidRequest = errors.New("secondary.invalidRequest")
 // ErrorNotFound
 var ErrorNotFound = errors.New("secondary.notFound")
 
+// ErrorDocumentTooLarge
+var ErrorDocumentTooLarge = errors.New("secondary.documentTooLarge")
+
+// ErrorKeyTooLarge
+var ErrorKeyTooLarge = errors.New("secondary.keyTooLarge")
+
 // ProtobufDataPathMajorNum major version number for mutation data path.
 var ProtobufDataPathMajorNum byte // = 0
 
@@ -49,6 +55,10 @@ var ErrClientCancel = errors.New("Client requested cancel")
 
 var ErrIndexerInBootstrap = errors.New("Indexer In Warmup State. Please retry the request later.")
 
+// ErrIndexerMemoryPressure when indexer rejects a scan as its memory usage
+// is close to the quota.
+var ErrIndexerMemoryPressure = errors.New("Indexer is under memory pressure. Please retry the request later.")
+
 const INDEXER_45_VERSION = 1
 const INDEXER_50_VERSION = 2
 const INDEXER_55_VERSION = 3
@@ -28,4 +28,7 @@ type Evaluator interface {
 		vbuuid uint64, m *mc.DcpEvent, data map[string]interface{}, encodeBuf []byte,
 		docval qvalue.AnnotatedValue, context qexpr.Context,
 	) ([]byte, error)
+
+	// EvalErrorStats return counters on documents failing evaluation.
+	EvalErrorStats() map[string]interface{}
 }
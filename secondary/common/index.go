@@ -79,6 +79,31 @@ func (s HashScheme) String() string {
 	return "HASH_SCHEME_UNKNOWN"
 }
 
+// EvalErrorPolicy decides what is indexed for a document whose index
+// expressions fail to evaluate.
+type EvalErrorPolicy string
+
+const (
+	// EvalErrorSkip does not index the document, the default.
+	EvalErrorSkip EvalErrorPolicy = "skip"
+	// EvalErrorIndexNull indexes the document with a NULL key.
+	EvalErrorIndexNull EvalErrorPolicy = "index_null"
+	// EvalErrorFail does not index the document, and records it as
+	// failed in the projector's statistics.
+	EvalErrorFail EvalErrorPolicy = "fail"
+)
+
+// ParseEvalErrorPolicy returns the policy named by s.
+func ParseEvalErrorPolicy(s string) (EvalErrorPolicy, error) {
+	switch p := EvalErrorPolicy(strings.ToLower(s)); p {
+	case "":
+		return EvalErrorSkip, nil
+	case EvalErrorSkip, EvalErrorIndexNull, EvalErrorFail:
+		return p, nil
+	}
+	return EvalErrorSkip, fmt.Errorf("invalid eval error policy %q", s)
+}
+
 type IndexState int
 
 const (
@@ -169,6 +194,12 @@ const (
 	// and make sure to return a stable data-set that is atleast as
 	// recent as the timestamp-vector.
 	QueryConsistency
+
+	// StalenessConsistency indexer would return data that is at most
+	// a caller specified seqno or time lag behind KV. If the index is
+	// staler than that, the scan waits for the index to catch up or
+	// fails, as requested by the caller.
+	StalenessConsistency
 )
 
 func (cons Consistency) String() string {
@@ -179,11 +210,93 @@ func (cons Consistency) String() string {
 		return "SESSION_CONSISTENCY"
 	case QueryConsistency:
 		return "QUERY_CONSISTENCY"
+	case StalenessConsistency:
+		return "STALENESS_CONSISTENCY"
 	default:
 		return "UNKNOWN_CONSISTENCY"
 	}
 }
 
+// ScanPriority of an index-scan request. The indexer admits
+// interactive scans ahead of batch scans when scan workers are scarce.
+type ScanPriority uint32
+
+const (
+	// InteractivePriority is meant for latency sensitive lookups and
+	// is the default for all scans.
+	InteractivePriority ScanPriority = iota
+
+	// BatchPriority is meant for analytical full scans and backfills,
+	// which may be queued behind interactive scans.
+	BatchPriority
+)
+
+func (p ScanPriority) String() string {
+	switch p {
+	case InteractivePriority:
+		return "interactive"
+	case BatchPriority:
+		return "batch"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseScanPriority returns the priority named by s.
+func ParseScanPriority(s string) (ScanPriority, error) {
+	switch strings.ToLower(s) {
+	case "", "interactive":
+		return InteractivePriority, nil
+	case "batch":
+		return BatchPriority, nil
+	}
+	return InteractivePriority, fmt.Errorf("invalid scan priority %q", s)
+}
+
+// ReadPreference of an index-scan request, decides which replica of
+// the index shall serve the scan.
+type ReadPreference uint32
+
+const (
+	// ReadNearest scans the replica under least load, and is the default
+	// for all scans.
+	ReadNearest ReadPreference = iota
+
+	// ReadPrimary scans the primary instance of the index, replicas are
+	// scanned only when the primary is not available.
+	ReadPrimary
+
+	// ReadReplicaOk scans replicas ahead of the primary instance, meant
+	// to offload heavy analytical scans from the primary.
+	ReadReplicaOk
+)
+
+func (p ReadPreference) String() string {
+	switch p {
+	case ReadNearest:
+		return "nearest"
+	case ReadPrimary:
+		return "primary"
+	case ReadReplicaOk:
+		return "replica_ok"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseReadPreference returns the read preference named by s.
+func ParseReadPreference(s string) (ReadPreference, error) {
+	switch strings.ToLower(s) {
+	case "", "nearest":
+		return ReadNearest, nil
+	case "primary":
+		return ReadPrimary, nil
+	case "replica_ok", "replica-ok":
+		return ReadReplicaOk, nil
+	}
+	return ReadNearest, fmt.Errorf("invalid read preference %q", s)
+}
+
 //IndexDefn represents the index definition as specified
 //during CREATE INDEX
 type IndexDefn struct {
@@ -210,6 +323,15 @@ type IndexDefn struct {
 	RetainDeletedXATTR bool       `json:"retainDeletedXATTR,omitempty"`
 	HashScheme         HashScheme `json:"hashScheme,omitempty"`
 
+	// What to index for documents failing expression evaluation
+	EvalErrorPolicy EvalErrorPolicy `json:"evalErrorPolicy,omitempty"`
+
+	// Primary index keeps document metadata (cas, expiry, size)
+	DocMeta bool `json:"docMeta,omitempty"`
+
+	// Index documents whose leading key is MISSING, instead of skipping
+	IncludeMissing bool `json:"includeMissing,omitempty"`
+
 	// Sizing info
 	NumDoc        uint64  `json:"numDoc,omitempty"`
 	SecKeySize    uint64  `json:"secKeySize,omitempty"`
@@ -265,6 +387,9 @@ func (idx IndexDefn) String() string {
 	str += fmt.Sprintf("PartitionKeys: %v ", idx.PartitionKeys)
 	str += fmt.Sprintf("WhereExpr: %v ", logging.TagUD(idx.WhereExpr))
 	str += fmt.Sprintf("RetainDeletedXATTR: %v ", idx.RetainDeletedXATTR)
+	str += fmt.Sprintf("EvalErrorPolicy: %v ", idx.EvalErrorPolicy)
+	str += fmt.Sprintf("DocMeta: %v ", idx.DocMeta)
+	str += fmt.Sprintf("IncludeMissing: %v ", idx.IncludeMissing)
 	return str
 
 }
@@ -292,6 +417,9 @@ func (idx IndexDefn) Clone() *IndexDefn {
 		IsArrayIndex:       idx.IsArrayIndex,
 		NumReplica:         idx.NumReplica,
 		RetainDeletedXATTR: idx.RetainDeletedXATTR,
+		EvalErrorPolicy:    idx.EvalErrorPolicy,
+		DocMeta:            idx.DocMeta,
+		IncludeMissing:     idx.IncludeMissing,
 		NumDoc:             idx.NumDoc,
 		SecKeySize:         idx.SecKeySize,
 		DocKeySize:         idx.DocKeySize,
@@ -381,6 +509,14 @@ func (s StreamId) String() string {
 	}
 }
 
+// Topic names used by the indexer to open each mutation stream on
+// projectors. The indexer suffixes them with its node id.
+const (
+	MAINT_TOPIC   = "MAINT_STREAM_TOPIC"
+	CATCHUP_TOPIC = "CATCHUP_STREAM_TOPIC"
+	INIT_TOPIC    = "INIT_STREAM_TOPIC"
+)
+
 type RebalanceState int
 
 const (
@@ -553,7 +689,9 @@ func IsEquivalentIndex(d1, d2 *IndexDefn) bool {
 		d1.PartitionScheme != d2.PartitionScheme ||
 		d1.HashScheme != d2.HashScheme ||
 		d1.WhereExpr != d2.WhereExpr ||
-		d1.RetainDeletedXATTR != d2.RetainDeletedXATTR {
+		d1.RetainDeletedXATTR != d2.RetainDeletedXATTR ||
+		d1.DocMeta != d2.DocMeta ||
+		d1.IncludeMissing != d2.IncludeMissing {
 
 		return false
 	}
@@ -0,0 +1,145 @@
+package common
+
+import "encoding/json"
+import "fmt"
+import "os"
+import "strings"
+
+import qexpr "github.com/couchbase/query/expression"
+import qparser "github.com/couchbase/query/expression/parser"
+
+// IndexTemplate is a set of index definitions, in the JSON format of
+// IndexDefn, to be applied idempotently. String attributes of the
+// definitions can refer to variables as ${name}, like the bucket name,
+// which are set by Variables and overridden when the template is
+// applied.
+type IndexTemplate struct {
+	Variables map[string]string `json:"variables,omitempty"`
+	Indexes   []IndexDefn       `json:"indexes"`
+}
+
+// TemplateAction is the action taken to apply an index definition of a
+// template.
+type TemplateAction string
+
+const (
+	TemplateCreate    TemplateAction = "create"
+	TemplateRecreate  TemplateAction = "recreate"
+	TemplateUnchanged TemplateAction = "unchanged"
+	TemplateDrifted   TemplateAction = "drifted" // not recreated without force
+)
+
+// TemplateResult of applying an index definition of a template.
+type TemplateResult struct {
+	Bucket string         `json:"bucket"`
+	Name   string         `json:"name"`
+	Action TemplateAction `json:"action"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// ParseIndexTemplate parses a template and expands its variables,
+// variables in vars take precedence over those in the template.
+func ParseIndexTemplate(data []byte, vars map[string]string) (*IndexTemplate, error) {
+	template := &IndexTemplate{}
+	if err := json.Unmarshal(data, template); err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]string)
+	for name, value := range template.Variables {
+		variables[name] = value
+	}
+	for name, value := range vars {
+		variables[name] = value
+	}
+
+	var undefined []string
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			value, ok := variables[name]
+			if !ok {
+				undefined = append(undefined, name)
+			}
+			return value
+		})
+	}
+	expandAll := func(ss []string) {
+		for i, s := range ss {
+			ss[i] = expand(s)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range template.Indexes {
+		defn := &template.Indexes[i]
+		defn.Name, defn.Bucket = expand(defn.Name), expand(defn.Bucket)
+		defn.WhereExpr = expand(defn.WhereExpr)
+		expandAll(defn.SecExprs)
+		expandAll(defn.PartitionKeys)
+		expandAll(defn.Nodes)
+
+		if defn.Name == "" || defn.Bucket == "" {
+			return nil, fmt.Errorf("index %v: name and bucket are required", i)
+		} else if len(defn.SecExprs) == 0 && !defn.IsPrimary {
+			return nil, fmt.Errorf("index %v:%v: secExprs are required", defn.Bucket, defn.Name)
+		}
+		key := defn.Bucket + ":" + defn.Name
+		if names[key] {
+			return nil, fmt.Errorf("index %v: duplicate definition", key)
+		}
+		names[key] = true
+	}
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined variables %v", strings.Join(undefined, ","))
+	}
+	return template, nil
+}
+
+// IndexDrifted returns true if the existing index definition differs
+// from the template definition, in attributes that need the index to be
+// recreated. Placement, replicas and deferred build are not compared.
+func IndexDrifted(template, existing *IndexDefn) bool {
+	t, e := normalizeIndexDefn(template), normalizeIndexDefn(existing)
+	if t.NumPartitions != 0 && e.NumPartitions != 0 && t.NumPartitions != e.NumPartitions {
+		return true
+	}
+	return !IsEquivalentIndex(t, e)
+}
+
+// normalizeIndexDefn returns a copy of defn with expressions formatted
+// like the definitions stored in metadata, so that "age" and "`age`"
+// compare equal, and with defaults set for attributes left out.
+func normalizeIndexDefn(defn *IndexDefn) *IndexDefn {
+	normalized := *defn
+	normalized.SecExprs = normalizeExprs(defn.SecExprs)
+	normalized.PartitionKeys = normalizeExprs(defn.PartitionKeys)
+	normalized.WhereExpr = normalizeExpr(defn.WhereExpr)
+	if normalized.ExprType == "" {
+		normalized.ExprType = N1QL
+	}
+	if normalized.PartitionScheme == "" {
+		normalized.PartitionScheme = SINGLE
+	}
+	normalized.Desc = make([]bool, len(defn.SecExprs))
+	copy(normalized.Desc, defn.Desc)
+	return &normalized
+}
+
+func normalizeExpr(expr string) string {
+	if strings.TrimSpace(expr) == "" {
+		return ""
+	}
+	e, err := qparser.Parse(expr)
+	if err != nil {
+		return expr
+	}
+	return qexpr.NewStringer().Visit(e)
+}
+
+func normalizeExprs(exprs []string) []string {
+	normalized := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		normalized = append(normalized, normalizeExpr(expr))
+	}
+	return normalized
+}
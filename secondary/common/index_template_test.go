@@ -0,0 +1,59 @@
+package common
+
+import "testing"
+
+func TestParseIndexTemplate(t *testing.T) {
+	data := []byte(`{
+		"variables": {"bucket": "default", "field": "city"},
+		"indexes": [
+			{"name": "idx_${field}", "bucket": "${bucket}", "secExprs": ["${field}"]},
+			{"name": "#primary", "bucket": "${bucket}", "isPrimary": true}
+		]}`)
+
+	template, err := ParseIndexTemplate(data, map[string]string{"bucket": "travel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defn := template.Indexes[0]
+	if defn.Name != "idx_city" || defn.Bucket != "travel" || defn.SecExprs[0] != "city" {
+		t.Fatalf("unexpected definition %v", defn)
+	}
+	if template.Indexes[1].Bucket != "travel" {
+		t.Fatalf("unexpected bucket %v", template.Indexes[1].Bucket)
+	}
+
+	data = []byte(`{"indexes": [{"name": "idx", "bucket": "${bucket}", "secExprs": ["a"]}]}`)
+	if _, err := ParseIndexTemplate(data, nil); err == nil {
+		t.Fatalf("expected error for undefined variable")
+	}
+	data = []byte(`{"indexes": [{"name": "idx", "bucket": "b"}]}`)
+	if _, err := ParseIndexTemplate(data, nil); err == nil {
+		t.Fatalf("expected error for missing secExprs")
+	}
+}
+
+func TestIndexDrifted(t *testing.T) {
+	template := &IndexDefn{Name: "idx", Bucket: "default", SecExprs: []string{"city", "age"}}
+	existing := &IndexDefn{
+		Name: "idx", Bucket: "default", SecExprs: []string{"`city`", "`age`"},
+		ExprType: N1QL, PartitionScheme: SINGLE, Desc: []bool{false, false},
+		Deferred: true, NumReplica: 1,
+	}
+	if IndexDrifted(template, existing) {
+		t.Fatalf("unexpected drift")
+	}
+
+	template.WhereExpr = "age > 10"
+	if !IndexDrifted(template, existing) {
+		t.Fatalf("expected drift of where clause")
+	}
+	existing.WhereExpr = "`age` > 10"
+	if IndexDrifted(template, existing) {
+		t.Fatalf("unexpected drift")
+	}
+
+	template.Desc = []bool{false, true}
+	if !IndexDrifted(template, existing) {
+		t.Fatalf("expected drift of desc")
+	}
+}
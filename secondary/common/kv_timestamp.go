@@ -0,0 +1,185 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "errors"
+import "fmt"
+import "sync"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/dcp"
+import "github.com/couchbase/indexing/secondary/logging"
+
+// cache Bucket{} objects and number of vbuckets, per cluster and bucket,
+// to make GetCurrentKVTimestamp fast.
+var kv_timestamps struct {
+	mu       sync.Mutex
+	fetchers map[string]*kvTsFetcher // cluster/bucket -> *kvTsFetcher
+}
+
+var errKVTsFetcherClosed = errors.New("kvTsFetcher - closed already")
+
+func init() {
+	kv_timestamps.fetchers = make(map[string]*kvTsFetcher)
+}
+
+// kvTsFetcher fetches the timestamp of a bucket from all kv nodes in
+// bulk, one vbucket-details STATS call per node. Concurrent callers share
+// a fetch, provided the fetch started after they called, so that the
+// timestamp is never older than the time of the call.
+type kvTsFetcher struct {
+	cluster string
+	bucketn string
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	bucket   *couchbase.Bucket
+	numVbs   int
+	fetching bool
+	closed   bool
+
+	// result of the last fetch, and the time it started.
+	ts      *TsVbuuid
+	err     error
+	tsStart time.Time
+
+	fetchTs func() (*TsVbuuid, error) // replaced by tests
+}
+
+// GetCurrentKVTimestamp returns the current high seqnos and vbuuids of
+// all vbuckets in bucket, as seen by the active vbuckets in kv. Use this
+// for request_plus scans and to validate the vbuuids of an at_plus
+// timestamp. Caller owns the returned timestamp.
+//
+// This call fails if a vbucket is not active on any node, which is the
+// case during kv rebalance and failover, and it can be retried.
+func GetCurrentKVTimestamp(cluster, bucketn string) (*TsVbuuid, error) {
+	key := cluster + "/" + bucketn
+
+	for {
+		kv_timestamps.mu.Lock()
+		fetcher, ok := kv_timestamps.fetchers[key]
+		if !ok {
+			fetcher = newKVTsFetcher(cluster, bucketn)
+			kv_timestamps.fetchers[key] = fetcher
+		}
+		kv_timestamps.mu.Unlock()
+
+		ts, err := fetcher.get()
+		if err == errKVTsFetcherClosed {
+			continue // raced with a failed fetch, use a new fetcher.
+		} else if err != nil {
+			// bucket could have been deleted or rebalanced, reconnect
+			// on next call.
+			kv_timestamps.mu.Lock()
+			if kv_timestamps.fetchers[key] == fetcher {
+				delete(kv_timestamps.fetchers, key)
+			}
+			kv_timestamps.mu.Unlock()
+			fetcher.close()
+			return nil, err
+		}
+		return ts, nil
+	}
+}
+
+func newKVTsFetcher(cluster, bucketn string) *kvTsFetcher {
+	f := &kvTsFetcher{cluster: cluster, bucketn: bucketn}
+	f.cond = sync.NewCond(&f.mu)
+	f.fetchTs = f.fetch
+	return f
+}
+
+func (f *kvTsFetcher) get() (*TsVbuuid, error) {
+	arrival := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		if !f.tsStart.IsZero() && !f.tsStart.Before(arrival) {
+			if f.err != nil {
+				return nil, f.err
+			}
+			return f.ts.Copy(), nil
+		}
+		if f.closed {
+			return nil, errKVTsFetcherClosed
+		}
+		if !f.fetching {
+			break
+		}
+		f.cond.Wait()
+	}
+
+	f.fetching = true
+	start := time.Now()
+	f.mu.Unlock()
+
+	ts, err := f.fetchTs()
+
+	f.mu.Lock()
+	f.ts, f.err, f.tsStart = ts, err, start
+	f.fetching = false
+	f.cond.Broadcast()
+
+	if err != nil {
+		return nil, err
+	}
+	return ts.Copy(), nil
+}
+
+// fetch is called by a single caller at a time.
+func (f *kvTsFetcher) fetch() (*TsVbuuid, error) {
+	var err error
+
+	if f.bucket == nil {
+		if f.bucket, err = ConnectBucket(f.cluster, "default", f.bucketn); err != nil {
+			logging.Errorf("GetCurrentKVTimestamp: unable to connect with bucket %q: %v", f.bucketn, err)
+			return nil, err
+		}
+		if f.numVbs, err = MaxVbuckets(f.bucket); err != nil {
+			return nil, err
+		}
+		if f.numVbs == 0 {
+			return nil, fmt.Errorf("Found 0 vbuckets - perhaps the bucket is not ready yet")
+		}
+	}
+
+	seqnos, vbuuids, err := BucketTs(f.bucket, f.numVbs)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := NewTsVbuuid(f.bucketn, f.numVbs)
+	for vbno := 0; vbno < f.numVbs; vbno++ {
+		if vbuuids[vbno] == 0 {
+			return nil, fmt.Errorf("unable to get active vbucket %v of bucket %q", vbno, f.bucketn)
+		}
+		ts.Seqnos[vbno] = seqnos[vbno]
+		ts.Vbuuids[vbno] = vbuuids[vbno]
+		ts.Snapshots[vbno] = [2]uint64{seqnos[vbno], seqnos[vbno]}
+	}
+	return ts, nil
+}
+
+func (f *kvTsFetcher) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// wait for an outstanding fetch, which is using the bucket.
+	for f.fetching {
+		f.cond.Wait()
+	}
+	if !f.closed && f.bucket != nil {
+		f.bucket.Close()
+	}
+	f.closed = true
+}
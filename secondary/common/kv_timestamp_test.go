@@ -0,0 +1,112 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKVTsFetcherFresh(t *testing.T) {
+	var fetches int32
+	f := newKVTsFetcher("localhost:9000", "default")
+	f.fetchTs = func() (*TsVbuuid, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		ts := NewTsVbuuid("default", 4)
+		ts.Seqnos[0] = uint64(n)
+		return ts, nil
+	}
+
+	ts1, err := f.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a later call never gets a timestamp fetched before it was made.
+	time.Sleep(time.Millisecond)
+	ts2, err := f.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts1.Seqnos[0] != 1 || ts2.Seqnos[0] != 2 {
+		t.Errorf("expected fresh timestamps, got %v %v", ts1.Seqnos[0], ts2.Seqnos[0])
+	}
+
+	// the caller owns the returned timestamp.
+	ts2.Seqnos[0] = 100
+	if f.ts.Seqnos[0] != 2 {
+		t.Errorf("expected cached timestamp to be unchanged, got %v", f.ts.Seqnos[0])
+	}
+}
+
+func TestKVTsFetcherShared(t *testing.T) {
+	var fetches int32
+	release := make(chan bool)
+	f := newKVTsFetcher("localhost:9000", "default")
+	f.fetchTs = func() (*TsVbuuid, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return NewTsVbuuid("default", 4), nil
+	}
+
+	// first caller starts a fetch, the others arrive while it is in
+	// progress and share the fetch that follows.
+	go f.get()
+	for atomic.LoadInt32(&fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected 2 fetches, got %v", n)
+	}
+}
+
+func TestGetCurrentKVTimestampError(t *testing.T) {
+	cluster, bucketn := "localhost:9000", "kvts_test"
+	key := cluster + "/" + bucketn
+	fetchErr := errors.New("bucket not found")
+
+	f := newKVTsFetcher(cluster, bucketn)
+	f.fetchTs = func() (*TsVbuuid, error) { return nil, fetchErr }
+	kv_timestamps.mu.Lock()
+	kv_timestamps.fetchers[key] = f
+	kv_timestamps.mu.Unlock()
+
+	if _, err := GetCurrentKVTimestamp(cluster, bucketn); err != fetchErr {
+		t.Fatalf("expected %v, got %v", fetchErr, err)
+	}
+
+	// a failed fetcher is closed and forgotten, to reconnect on next call.
+	kv_timestamps.mu.Lock()
+	_, ok := kv_timestamps.fetchers[key]
+	kv_timestamps.mu.Unlock()
+	if ok || !f.closed {
+		t.Errorf("expected failed fetcher to be closed and removed")
+	}
+	if _, err := f.get(); err != errKVTsFetcherClosed {
+		t.Errorf("expected %v, got %v", errKVTsFetcherClosed, err)
+	}
+}
@@ -0,0 +1,193 @@
+package common
+
+import "fmt"
+import "reflect"
+import "sort"
+import "strconv"
+import "strings"
+
+// ProtoSchema describes the protobuf messages of a wire protocol, as
+// compiled into the binary. It is derived from the struct tags of the
+// generated code, so that tools and clients in other languages can
+// decode captured messages and check compatibility, without the .proto
+// files the binary was built from.
+type ProtoSchema struct {
+	Name     string                      `json:"name"`
+	Version  uint32                      `json:"version"`
+	Messages []*ProtoMessage             `json:"messages"`
+	Enums    map[string]map[string]int32 `json:"enums,omitempty"`
+}
+
+// ProtoMessage describes a protobuf message.
+type ProtoMessage struct {
+	Name   string        `json:"name"`
+	Fields []*ProtoField `json:"fields"`
+}
+
+// ProtoField describes a field of a protobuf message.
+type ProtoField struct {
+	Name    string `json:"name"`
+	Number  int    `json:"number"`
+	Label   string `json:"label"` // required, optional or repeated
+	Type    string `json:"type"`  // scalar type, or message or enum name
+	Packed  bool   `json:"packed,omitempty"`
+	Default string `json:"default,omitempty"`
+	// Enum names the values of a scalar field that is coded from an
+	// enum of the go code, rather than a protobuf enum.
+	Enum string `json:"enum,omitempty"`
+}
+
+// NewProtoSchema describes the messages reachable from roots, which
+// are pointers to generated messages. Message names are qualified by
+// pkg, the package of the .proto files, and enums maps an enum name to
+// its values.
+func NewProtoSchema(
+	name string, version uint32, pkg string,
+	enums map[string]map[string]int32, roots ...interface{}) *ProtoSchema {
+
+	schema := &ProtoSchema{
+		Name:    name,
+		Version: version,
+		Enums:   make(map[string]map[string]int32),
+	}
+	for enum, values := range enums {
+		schema.Enums[protoTypeName(enum)] = values
+	}
+	seen := make(map[reflect.Type]bool)
+
+	var describe func(typ reflect.Type)
+	describe = func(typ reflect.Type) {
+		if seen[typ] {
+			return
+		}
+		seen[typ] = true
+
+		msg := &ProtoMessage{Name: protoTypeName(pkg + "." + typ.Name())}
+		for i := 0; i < typ.NumField(); i++ {
+			sf := typ.Field(i)
+			tag := sf.Tag.Get("protobuf")
+			if tag == "" {
+				continue
+			}
+			field := parseProtoTag(tag)
+
+			ft := sf.Type
+			for ft.Kind() == reflect.Ptr || (ft.Kind() == reflect.Slice &&
+				ft.Elem().Kind() != reflect.Uint8) {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				field.Type = protoTypeName(pkg + "." + ft.Name())
+				describe(ft)
+			} else if field.Type == "" {
+				field.Type = protoScalarType(tag, ft)
+			}
+			msg.Fields = append(msg.Fields, field)
+		}
+		schema.Messages = append(schema.Messages, msg)
+	}
+
+	for _, root := range roots {
+		describe(reflect.TypeOf(root).Elem())
+	}
+	sort.Slice(schema.Messages, func(i, j int) bool {
+		return schema.Messages[i].Name < schema.Messages[j].Name
+	})
+	return schema
+}
+
+// CodedEnum records the values of an enum of the go code, and marks
+// fields, named like "protobuf.ScanRequest.cons", as coded from it.
+func (schema *ProtoSchema) CodedEnum(
+	enum string, values map[string]int32, fields ...string) {
+
+	schema.Enums[enum] = values
+	for _, msg := range schema.Messages {
+		for _, field := range msg.Fields {
+			for _, name := range fields {
+				if name == msg.Name+"."+field.Name {
+					field.Enum = enum
+				}
+			}
+		}
+	}
+}
+
+// protoTypeName returns the .proto name of a message or enum, from the
+// name of its generated go type. The go types of nested messages and
+// enums are named like Outer_Inner, for Outer.Inner in the .proto file.
+func protoTypeName(name string) string {
+	return strings.Replace(name, "_", ".", -1)
+}
+
+// parseProtoTag parses a struct tag generated for a protobuf field,
+// like "varint,3,opt,name=state,enum=protobuf.IndexState,def=1".
+func parseProtoTag(tag string) *ProtoField {
+	field := &ProtoField{}
+	for i, part := range strings.Split(tag, ",") {
+		switch {
+		case i == 1:
+			field.Number, _ = strconv.Atoi(part)
+		case part == "req":
+			field.Label = "required"
+		case part == "opt":
+			field.Label = "optional"
+		case part == "rep":
+			field.Label = "repeated"
+		case part == "packed":
+			field.Packed = true
+		case strings.HasPrefix(part, "name="):
+			field.Name = strings.TrimPrefix(part, "name=")
+		case strings.HasPrefix(part, "enum="):
+			field.Type = protoTypeName(strings.TrimPrefix(part, "enum="))
+		case strings.HasPrefix(part, "def="):
+			field.Default = strings.TrimPrefix(part, "def=")
+		}
+	}
+	return field
+}
+
+// protoScalarType returns the .proto type of a scalar field, from its
+// wire type and go type.
+func protoScalarType(tag string, typ reflect.Type) string {
+	wire := strings.SplitN(tag, ",", 2)[0]
+	switch wire {
+	case "varint":
+		switch typ.Kind() {
+		case reflect.Bool:
+			return "bool"
+		case reflect.Int32:
+			return "int32"
+		case reflect.Int64:
+			return "int64"
+		case reflect.Uint32:
+			return "uint32"
+		case reflect.Uint64:
+			return "uint64"
+		}
+	case "zigzag32":
+		return "sint32"
+	case "zigzag64":
+		return "sint64"
+	case "fixed32":
+		if typ.Kind() == reflect.Float32 {
+			return "float"
+		} else if typ.Kind() == reflect.Int32 {
+			return "sfixed32"
+		}
+		return "fixed32"
+	case "fixed64":
+		if typ.Kind() == reflect.Float64 {
+			return "double"
+		} else if typ.Kind() == reflect.Int64 {
+			return "sfixed64"
+		}
+		return "fixed64"
+	case "bytes":
+		if typ.Kind() == reflect.String {
+			return "string"
+		}
+		return "bytes"
+	}
+	return fmt.Sprintf("%v(%v)", wire, typ)
+}
@@ -0,0 +1,108 @@
+package common
+
+import "reflect"
+import "testing"
+
+type testOuter struct {
+	Id               *uint64            `protobuf:"varint,1,req,name=id" json:"id,omitempty"`
+	State            *int32             `protobuf:"varint,2,opt,name=state,enum=protobuf.TestOuter_State,def=1" json:"state,omitempty"`
+	Inner            []*testOuter_Inner `protobuf:"bytes,3,rep,name=inner" json:"inner,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
+}
+
+type testOuter_Inner struct {
+	Keys             [][]byte `protobuf:"bytes,1,rep,name=keys" json:"keys,omitempty"`
+	Vbnos            []uint32 `protobuf:"varint,2,rep,packed,name=vbnos" json:"vbnos,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func TestParseProtoTag(t *testing.T) {
+	testcases := []struct {
+		tag   string
+		field ProtoField
+	}{
+		{"varint,1,req,name=id",
+			ProtoField{Name: "id", Number: 1, Label: "required"}},
+		{"varint,3,opt,name=state,enum=protobuf.IndexState,def=1",
+			ProtoField{Name: "state", Number: 3, Label: "optional",
+				Type: "protobuf.IndexState", Default: "1"}},
+		{"varint,2,rep,packed,name=vbnos",
+			ProtoField{Name: "vbnos", Number: 2, Label: "repeated", Packed: true}},
+		{"bytes,4,opt,name=kind,enum=protobuf.Outer_Kind",
+			ProtoField{Name: "kind", Number: 4, Label: "optional",
+				Type: "protobuf.Outer.Kind"}},
+	}
+	for _, tc := range testcases {
+		if field := parseProtoTag(tc.tag); *field != tc.field {
+			t.Errorf("%q: expected %+v, got %+v", tc.tag, tc.field, *field)
+		}
+	}
+}
+
+func TestProtoScalarType(t *testing.T) {
+	testcases := []struct {
+		tag string
+		val interface{}
+		typ string
+	}{
+		{"varint,1,opt", true, "bool"},
+		{"varint,1,opt", int32(0), "int32"},
+		{"varint,1,opt", int64(0), "int64"},
+		{"varint,1,opt", uint32(0), "uint32"},
+		{"varint,1,opt", uint64(0), "uint64"},
+		{"zigzag32,1,opt", int32(0), "sint32"},
+		{"zigzag64,1,opt", int64(0), "sint64"},
+		{"fixed32,1,opt", float32(0), "float"},
+		{"fixed32,1,opt", int32(0), "sfixed32"},
+		{"fixed32,1,opt", uint32(0), "fixed32"},
+		{"fixed64,1,opt", float64(0), "double"},
+		{"fixed64,1,opt", int64(0), "sfixed64"},
+		{"fixed64,1,opt", uint64(0), "fixed64"},
+		{"bytes,1,opt", "", "string"},
+		{"bytes,1,opt", []byte(nil), "bytes"},
+		{"group,1,opt", uint32(0), "group(uint32)"},
+	}
+	for _, tc := range testcases {
+		typ := protoScalarType(tc.tag, reflect.TypeOf(tc.val))
+		if typ != tc.typ {
+			t.Errorf("%q %T: expected %v, got %v", tc.tag, tc.val, tc.typ, typ)
+		}
+	}
+}
+
+func TestNewProtoSchema(t *testing.T) {
+	states := map[string]int32{"Active": 1, "Deleted": 2}
+	schema := NewProtoSchema("test", 1, "protobuf",
+		map[string]map[string]int32{"protobuf.TestOuter_State": states},
+		&testOuter{})
+
+	if len(schema.Messages) != 2 ||
+		schema.Messages[0].Name != "protobuf.testOuter" ||
+		schema.Messages[1].Name != "protobuf.testOuter.Inner" {
+		t.Fatalf("unexpected messages %v", schema.Messages)
+	}
+	if !reflect.DeepEqual(schema.Enums["protobuf.TestOuter.State"], states) {
+		t.Fatalf("expected nested enum, got %v", schema.Enums)
+	}
+
+	outer, inner := schema.Messages[0].Fields, schema.Messages[1].Fields
+	if len(outer) != 3 || outer[0].Type != "uint64" ||
+		outer[1].Type != "protobuf.TestOuter.State" ||
+		outer[2].Type != "protobuf.testOuter.Inner" || outer[2].Label != "repeated" {
+		t.Fatalf("unexpected fields of outer message %+v %+v %+v",
+			outer[0], outer[1], outer[2])
+	}
+	if len(inner) != 2 || inner[0].Type != "bytes" ||
+		inner[1].Type != "uint32" || !inner[1].Packed {
+		t.Fatalf("unexpected fields of inner message %+v %+v", inner[0], inner[1])
+	}
+
+	schema.CodedEnum("common.Consistency", map[string]int32{"ANY": 1},
+		"protobuf.testOuter.id")
+	if outer[0].Enum != "common.Consistency" || outer[1].Enum != "" {
+		t.Fatalf("expected coded enum on id, got %+v %+v", outer[0], outer[1])
+	}
+	if _, ok := schema.Enums["common.Consistency"]; !ok {
+		t.Fatalf("expected coded enum values, got %v", schema.Enums)
+	}
+}
@@ -0,0 +1,146 @@
+package common
+
+import "bufio"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+
+// SocketOptions tune the TCP connections of dataport and queryport, as
+// links across data centers need different tuning than local ones.
+type SocketOptions struct {
+	NoDelay     bool          // disable Nagle's algorithm, default true
+	KeepAlive   time.Duration // keep alive interval, 0 leaves it as is
+	ReadBuffer  int           // socket receive buffer in bytes, 0 leaves it as is
+	WriteBuffer int           // socket send buffer in bytes, 0 leaves it as is
+	// writes are coalesced in a buffer of CoalesceSize bytes, flushed
+	// once full, on Flush, or by a write made CoalesceInterval after the
+	// oldest buffered write. 0 CoalesceSize writes through.
+	CoalesceSize     int
+	CoalesceInterval time.Duration
+}
+
+// NewSocketOptions returns the socket options of a config section, like
+// "indexer.dataport.", from its optional parameters tcpNoDelay,
+// tcpKeepAliveInterval (seconds), tcpReadBufferSize, tcpWriteBufferSize,
+// writeCoalesceSize and writeCoalesceInterval (milliseconds).
+func NewSocketOptions(config Config) SocketOptions {
+	opts := SocketOptions{NoDelay: true}
+	if cv, ok := config["tcpNoDelay"]; ok {
+		opts.NoDelay = cv.Bool()
+	}
+	if cv, ok := config["tcpKeepAliveInterval"]; ok {
+		opts.KeepAlive = time.Duration(cv.Int()) * time.Second
+	}
+	if cv, ok := config["tcpReadBufferSize"]; ok {
+		opts.ReadBuffer = cv.Int()
+	}
+	if cv, ok := config["tcpWriteBufferSize"]; ok {
+		opts.WriteBuffer = cv.Int()
+	}
+	if cv, ok := config["writeCoalesceSize"]; ok {
+		opts.CoalesceSize = cv.Int()
+	}
+	if cv, ok := config["writeCoalesceInterval"]; ok {
+		opts.CoalesceInterval = time.Duration(cv.Int()) * time.Millisecond
+	}
+	return opts
+}
+
+func (opts SocketOptions) String() string {
+	return fmt.Sprintf("nodelay:%v keepalive:%v rcvbuf:%v sndbuf:%v coalesce:%v/%v",
+		opts.NoDelay, opts.KeepAlive, opts.ReadBuffer, opts.WriteBuffer,
+		opts.CoalesceSize, opts.CoalesceInterval)
+}
+
+// Apply the TCP options to conn, other connections are left as is.
+func (opts SocketOptions) Apply(conn net.Conn) error {
+	tcpconn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tcpconn.SetNoDelay(opts.NoDelay); err != nil {
+		return err
+	}
+	if opts.KeepAlive > 0 {
+		if err := tcpconn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tcpconn.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if opts.ReadBuffer > 0 {
+		if err := tcpconn.SetReadBuffer(opts.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.WriteBuffer > 0 {
+		if err := tcpconn.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Coalesce returns conn coalescing its writes, conn itself if writes
+// are not to be coalesced. Writers shall call FlushConn at the end of
+// a batch of writes.
+func (opts SocketOptions) Coalesce(conn net.Conn) net.Conn {
+	if opts.CoalesceSize <= 0 {
+		return conn
+	}
+	return &CoalescedConn{
+		Conn:     conn,
+		w:        bufio.NewWriterSize(conn, opts.CoalesceSize),
+		interval: opts.CoalesceInterval,
+	}
+}
+
+// CoalescedConn buffers writes to a connection.
+type CoalescedConn struct {
+	net.Conn
+	mu       sync.Mutex
+	w        *bufio.Writer
+	interval time.Duration
+	oldest   time.Time // of buffered writes
+}
+
+// Write implements net.Conn interface.
+func (conn *CoalescedConn) Write(b []byte) (int, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	now := time.Now()
+	if conn.w.Buffered() == 0 {
+		conn.oldest = now
+	}
+	n, err := conn.w.Write(b)
+	if err == nil && conn.w.Buffered() > 0 && conn.interval > 0 &&
+		now.Sub(conn.oldest) >= conn.interval {
+		err = conn.w.Flush()
+	}
+	return n, err
+}
+
+// Flush buffered writes to the connection.
+func (conn *CoalescedConn) Flush() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.w.Flush()
+}
+
+// Close implements net.Conn interface, after flushing buffered writes.
+func (conn *CoalescedConn) Close() error {
+	conn.Flush()
+	return conn.Conn.Close()
+}
+
+// FlushConn flushes writes buffered by a connection returned by
+// SocketOptions.Coalesce.
+func FlushConn(conn net.Conn) error {
+	if cconn, ok := conn.(*CoalescedConn); ok {
+		return cconn.Flush()
+	}
+	return nil
+}
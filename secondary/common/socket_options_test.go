@@ -0,0 +1,42 @@
+package common
+
+import "io/ioutil"
+import "net"
+import "testing"
+
+func TestCoalescedConn(t *testing.T) {
+	opts := NewSocketOptions(Config{
+		"writeCoalesceSize": ConfigValue{Value: 1024},
+	})
+	if !opts.NoDelay {
+		t.Fatalf("expected tcpNoDelay by default")
+	}
+
+	client, server := net.Pipe()
+	conn := opts.Coalesce(client)
+	if _, ok := conn.(*CoalescedConn); !ok {
+		t.Fatalf("expected coalesced connection, got %T", conn)
+	}
+	// net.Pipe is synchronous, writes not coalesced would block.
+	for i := 0; i < 10; i++ {
+		if _, err := conn.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	donech := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(server)
+		donech <- data
+	}()
+	if err := FlushConn(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if data := <-donech; len(data) != 100 {
+		t.Fatalf("expected 100 bytes, got %v", len(data))
+	}
+
+	if conn := NewSocketOptions(Config{}).Coalesce(client); conn != client {
+		t.Fatalf("expected connection to write through")
+	}
+}
@@ -0,0 +1,294 @@
+package common
+
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "sync"
+import "time"
+
+// TaskType of a long running operation.
+type TaskType string
+
+const (
+	TaskBuild      TaskType = "build"
+	TaskCompaction TaskType = "compaction"
+	TaskMoveIndex  TaskType = "move"
+	TaskBackup     TaskType = "backup"
+	TaskVerify     TaskType = "verify"
+)
+
+// TaskState of a long running operation.
+type TaskState string
+
+const (
+	TaskRunning   TaskState = "running"
+	TaskDone      TaskState = "done"
+	TaskFailed    TaskState = "failed"
+	TaskCancelled TaskState = "cancelled"
+
+	// TaskInterrupted is the state of tasks that were running when
+	// the process restarted.
+	TaskInterrupted TaskState = "interrupted"
+)
+
+// ErrTaskNotFound is returned for unknown task-id.
+var ErrTaskNotFound = errors.New("Task not found")
+
+// ErrTaskNotCancellable is returned when cancelling a task that does
+// not support cancellation, or is not running.
+var ErrTaskNotCancellable = errors.New("Task cannot be cancelled")
+
+// number of finished tasks remembered, oldest are forgotten first.
+const maxFinishedTasks = 256
+
+// TaskStatus of a long running operation.
+type TaskStatus struct {
+	Id          string    `json:"id"`
+	Type        TaskType  `json:"type"`
+	Description string    `json:"description"`
+	State       TaskState `json:"state"`
+	Progress    float64   `json:"progress"`
+	Cancellable bool      `json:"cancellable"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Task is the handle to a long running operation registered with
+// NewTask. All methods are no-op on a nil Task.
+type Task struct {
+	status     TaskStatus
+	cancel     func() error
+	cancelled  bool
+	progressFn func() float64
+}
+
+// task-id -> task, persisted to a file once LoadTasks is called.
+var tasks struct {
+	mu    sync.Mutex
+	tasks map[string]*Task
+	file  string
+	seqno uint64
+}
+
+// NewTask registers a running task of type typ. If cancel is not nil,
+// the task can be cancelled by calling CancelTask, and cancel is
+// expected to make the operation complete with an error.
+func NewTask(typ TaskType, description string, cancel func() error) *Task {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+
+	if tasks.tasks == nil {
+		tasks.tasks = make(map[string]*Task)
+	}
+	tasks.seqno++
+	now := time.Now()
+	t := &Task{
+		status: TaskStatus{
+			Id:          fmt.Sprintf("%v-%v-%v", typ, now.UnixNano(), tasks.seqno),
+			Type:        typ,
+			Description: description,
+			State:       TaskRunning,
+			Cancellable: cancel != nil,
+			StartTime:   now,
+		},
+		cancel: cancel,
+	}
+	pruneTasksNoLock()
+	tasks.tasks[t.status.Id] = t
+	saveTasksNoLock()
+	return t
+}
+
+// Id of the task.
+func (t *Task) Id() string {
+	if t == nil {
+		return ""
+	}
+	return t.status.Id
+}
+
+// SetProgress of the task, as percent complete.
+func (t *Task) SetProgress(progress float64) {
+	if t == nil {
+		return
+	}
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+	t.status.Progress = progress
+}
+
+// SetProgressFunc to compute the progress of the task, as percent
+// complete, when its status is requested.
+func (t *Task) SetProgressFunc(fn func() float64) {
+	if t == nil {
+		return
+	}
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+	t.progressFn = fn
+}
+
+// Done marks the task as complete. A task that completes with error
+// after CancelTask is marked as cancelled.
+func (t *Task) Done(err error) {
+	if t == nil {
+		return
+	}
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+
+	if t.status.State != TaskRunning {
+		return
+	}
+	if t.progressFn != nil {
+		t.status.Progress = t.progressFn()
+		t.progressFn = nil
+	}
+	switch {
+	case err == nil:
+		t.status.State, t.status.Progress = TaskDone, 100
+	case t.cancelled:
+		t.status.State = TaskCancelled
+	default:
+		t.status.State, t.status.Error = TaskFailed, err.Error()
+	}
+	t.status.EndTime = time.Now()
+	t.cancel = nil
+	saveTasksNoLock()
+}
+
+// GetTasks returns the status of tasks, of all tasks if id is empty.
+func GetTasks(id string) ([]TaskStatus, error) {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+
+	if len(id) != 0 {
+		t, ok := tasks.tasks[id]
+		if !ok {
+			return nil, ErrTaskNotFound
+		}
+		return []TaskStatus{t.statusNoLock()}, nil
+	}
+	statuses := make([]TaskStatus, 0, len(tasks.tasks))
+	for _, t := range tasks.tasks {
+		statuses = append(statuses, t.statusNoLock())
+	}
+	return statuses, nil
+}
+
+// CancelTask requests cancellation of a running task, the task is
+// marked as cancelled once the operation completes.
+func CancelTask(id string) (TaskStatus, error) {
+	tasks.mu.Lock()
+	t, ok := tasks.tasks[id]
+	if !ok {
+		tasks.mu.Unlock()
+		return TaskStatus{}, ErrTaskNotFound
+	} else if t.status.State != TaskRunning || t.cancel == nil {
+		tasks.mu.Unlock()
+		return TaskStatus{}, ErrTaskNotCancellable
+	}
+	t.cancelled = true
+	cancel := t.cancel
+	tasks.mu.Unlock()
+
+	// cancel is called without lock, as it may complete the task.
+	if err := cancel(); err != nil {
+		return TaskStatus{}, err
+	}
+
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+	return t.statusNoLock(), nil
+}
+
+// LoadTasks loads tasks persisted by the previous process from
+// filename, and persists tasks to filename from then on. Tasks that
+// were running are marked as interrupted.
+func LoadTasks(filename string) error {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+
+	if tasks.tasks == nil {
+		tasks.tasks = make(map[string]*Task)
+	}
+	tasks.file = filename
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var statuses []TaskStatus
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return fmt.Errorf("invalid tasks file %v: %v", filename, err)
+	}
+	for _, status := range statuses {
+		if status.State == TaskRunning {
+			status.State, status.Cancellable = TaskInterrupted, false
+		}
+		if _, ok := tasks.tasks[status.Id]; !ok {
+			tasks.tasks[status.Id] = &Task{status: status}
+		}
+	}
+	pruneTasksNoLock()
+	saveTasksNoLock()
+	return nil
+}
+
+func (t *Task) statusNoLock() TaskStatus {
+	status := t.status
+	if t.progressFn != nil {
+		status.Progress = t.progressFn()
+	}
+	status.Cancellable = status.State == TaskRunning && t.cancel != nil
+	return status
+}
+
+// pruneTasksNoLock forgets the oldest finished tasks beyond
+// maxFinishedTasks.
+func pruneTasksNoLock() {
+	for {
+		var oldest *Task
+		numFinished := 0
+		for _, t := range tasks.tasks {
+			if t.status.State == TaskRunning {
+				continue
+			}
+			numFinished++
+			if oldest == nil || t.status.EndTime.Before(oldest.status.EndTime) {
+				oldest = t
+			}
+		}
+		if numFinished < maxFinishedTasks {
+			return
+		}
+		delete(tasks.tasks, oldest.status.Id)
+	}
+}
+
+// saveTasksNoLock persists tasks atomically, by writing to a temporary
+// file and renaming it. Failures are ignored, persisted tasks are only
+// meant for reporting.
+func saveTasksNoLock() {
+	if len(tasks.file) == 0 {
+		return
+	}
+	statuses := make([]TaskStatus, 0, len(tasks.tasks))
+	for _, t := range tasks.tasks {
+		statuses = append(statuses, t.statusNoLock())
+	}
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		return
+	}
+	tmpfile := tasks.file + ".tmp"
+	if err := ioutil.WriteFile(tmpfile, data, 0644); err != nil {
+		return
+	}
+	os.Rename(tmpfile, tasks.file)
+}
@@ -0,0 +1,168 @@
+package common
+
+import "errors"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+func resetTasks() {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+	tasks.tasks, tasks.file = nil, ""
+}
+
+func getTask(t *testing.T, id string) TaskStatus {
+	statuses, err := GetTasks(id)
+	if err != nil || len(statuses) != 1 {
+		t.Fatalf("unexpected tasks %v %v", statuses, err)
+	}
+	return statuses[0]
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	resetTasks()
+	defer resetTasks()
+
+	progress := 10.0
+	t1 := NewTask(TaskBuild, "build", nil)
+	t1.SetProgressFunc(func() float64 { return progress })
+	t2 := NewTask(TaskBackup, "backup", nil)
+	t2.SetProgress(40)
+
+	if status := getTask(t, t1.Id()); status.State != TaskRunning ||
+		status.Progress != 10 || status.Cancellable {
+		t.Errorf("unexpected status %v", status)
+	}
+	progress = 20
+	if status := getTask(t, t1.Id()); status.Progress != 20 {
+		t.Errorf("expected progress 20, got %v", status.Progress)
+	}
+
+	t1.Done(nil)
+	t2.Done(errors.New("backup failed"))
+	if status := getTask(t, t1.Id()); status.State != TaskDone || status.Progress != 100 {
+		t.Errorf("unexpected status %v", status)
+	}
+	if status := getTask(t, t2.Id()); status.State != TaskFailed ||
+		status.Progress != 40 || status.Error != "backup failed" {
+		t.Errorf("unexpected status %v", status)
+	}
+
+	// completed tasks are not completed again.
+	t2.Done(nil)
+	if status := getTask(t, t2.Id()); status.State != TaskFailed {
+		t.Errorf("expected %v, got %v", TaskFailed, status.State)
+	}
+
+	if statuses, err := GetTasks(""); err != nil || len(statuses) != 2 {
+		t.Errorf("expected 2 tasks, got %v %v", statuses, err)
+	}
+	if _, err := GetTasks("unknown"); err != ErrTaskNotFound {
+		t.Errorf("expected %v, got %v", ErrTaskNotFound, err)
+	}
+
+	// nil task is a no-op.
+	var nilTask *Task
+	nilTask.SetProgress(10)
+	nilTask.Done(nil)
+	if nilTask.Id() != "" {
+		t.Errorf("expected empty id for nil task")
+	}
+}
+
+func TestTaskCancel(t *testing.T) {
+	resetTasks()
+	defer resetTasks()
+
+	var task *Task
+	task = NewTask(TaskCompaction, "compaction", func() error {
+		task.Done(errors.New("compaction cancelled"))
+		return nil
+	})
+	if status := getTask(t, task.Id()); !status.Cancellable {
+		t.Errorf("expected task to be cancellable")
+	}
+
+	status, err := CancelTask(task.Id())
+	if err != nil {
+		t.Fatal(err)
+	} else if status.State != TaskCancelled || status.Cancellable {
+		t.Errorf("unexpected status %v", status)
+	}
+	if _, err := CancelTask(task.Id()); err != ErrTaskNotCancellable {
+		t.Errorf("expected %v, got %v", ErrTaskNotCancellable, err)
+	}
+
+	notCancellable := NewTask(TaskBuild, "build", nil)
+	if _, err := CancelTask(notCancellable.Id()); err != ErrTaskNotCancellable {
+		t.Errorf("expected %v, got %v", ErrTaskNotCancellable, err)
+	}
+	if _, err := CancelTask("unknown"); err != ErrTaskNotFound {
+		t.Errorf("expected %v, got %v", ErrTaskNotFound, err)
+	}
+}
+
+func TestTaskPersistence(t *testing.T) {
+	resetTasks()
+	defer resetTasks()
+
+	dir, err := ioutil.TempDir("", "tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "indexer.tasks")
+
+	if err := LoadTasks(filename); err != nil {
+		t.Fatal(err)
+	}
+	done := NewTask(TaskBuild, "build", nil)
+	done.Done(nil)
+	running := NewTask(TaskCompaction, "compaction", func() error { return nil })
+
+	// restart.
+	resetTasks()
+	if err := LoadTasks(filename); err != nil {
+		t.Fatal(err)
+	}
+	if status := getTask(t, done.Id()); status.State != TaskDone {
+		t.Errorf("expected %v, got %v", TaskDone, status.State)
+	}
+	status := getTask(t, running.Id())
+	if status.State != TaskInterrupted || status.Cancellable {
+		t.Errorf("unexpected status %v", status)
+	}
+
+	resetTasks()
+	if err := ioutil.WriteFile(filename, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadTasks(filename); err == nil {
+		t.Errorf("expected error for invalid tasks file")
+	}
+}
+
+func TestTaskPrune(t *testing.T) {
+	resetTasks()
+	defer resetTasks()
+
+	first := NewTask(TaskBuild, "build", nil)
+	first.Done(nil)
+	tasks.mu.Lock()
+	first.status.EndTime = first.status.EndTime.Add(-time.Hour)
+	tasks.mu.Unlock()
+	running := NewTask(TaskBuild, "build", nil)
+	for i := 0; i < maxFinishedTasks; i++ {
+		NewTask(TaskBuild, "build", nil).Done(nil)
+	}
+	NewTask(TaskBuild, "build", nil)
+
+	if _, err := GetTasks(first.Id()); err != ErrTaskNotFound {
+		t.Errorf("expected oldest finished task to be pruned, got %v", err)
+	}
+	if _, err := GetTasks(running.Id()); err != nil {
+		t.Errorf("expected running task to be kept, got %v", err)
+	}
+}
@@ -267,7 +267,9 @@ func (ah *CbAuthHandler) AuthenticateMemcachedConn(host string, conn *memcached.
 		return err
 	}
 
-	_, err = conn.Auth(u, p)
+	if _, err = conn.Auth(u, p); err != nil {
+		return err
+	}
 	_, err = conn.SelectBucket(ah.Bucket)
 	return err
 }
@@ -659,6 +661,22 @@ func IndexStatement(def IndexDefn, printNodes bool) string {
 		withExpr += " \"retain_deleted_xattr\":true"
 	}
 
+	if def.DocMeta {
+		if len(withExpr) != 0 {
+			withExpr += ","
+		}
+
+		withExpr += " \"doc_meta\":true"
+	}
+
+	if def.IncludeMissing {
+		if len(withExpr) != 0 {
+			withExpr += ","
+		}
+
+		withExpr += " \"include_missing\":true"
+	}
+
 	if printNodes && len(def.Nodes) != 0 {
 		if len(withExpr) != 0 {
 			withExpr += ","
@@ -59,6 +59,7 @@ type Client struct {
 	maxVbuckets   int
 	mutChanSize   int
 	maxPayload    int
+	maxFrame      int
 	bufferSize    int
 	bufferTimeout time.Duration
 	logPrefix     string
@@ -98,10 +99,13 @@ func NewClient(
 		bufferTimeout: time.Duration(config["bufferTimeout"].Int()),
 		logPrefixes:   make(map[int]string),
 	}
+	if cv, ok := config["maxFrameSize"]; ok {
+		c.maxFrame = cv.Int()
+	}
 	c.logPrefix = fmt.Sprintf("ENDC[%v<-%v #%v]", raddr, cluster, topic)
 	// open connections with remote
 	for i := 0; i < parConns; i++ {
-		if conn, err = net.Dial("tcp", raddr); err != nil {
+		if conn, err = dial(raddr); err != nil {
 			logging.Errorf("%v Dialing to %q: %v\n", c.logPrefix, raddr, err)
 			c.doClose()
 			return nil, err
@@ -366,6 +370,7 @@ func (c *Client) runTransmitter(
 	}()
 
 	pkt := transport.NewTransportPacket(c.maxPayload, flags)
+	pkt.SetFrameSize(c.maxFrame, 0)
 	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
 	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
 
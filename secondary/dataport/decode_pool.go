@@ -0,0 +1,180 @@
+// Pooled, zero-copy decoding of mutation payloads.
+//
+// Packets are copied once into a buffer allocated from a BufferPool and
+// decoded in place, so that docids and keys of the decoded KeyVersions
+// refer to the buffer instead of being allocated one by one. The buffer
+// is shared by all VbKeyVersions of the packet and is released back to
+// the pool after application has released each one of them using
+// Server.ReleaseKeyVersions(). Buffers that are not released by the time
+// their connection is closed are left to the garbage collector.
+
+package dataport
+
+import "sync"
+import "sync/atomic"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/couchbase/indexing/secondary/transport"
+
+// BufferPool allocates buffers for decoding packets.
+type BufferPool interface {
+	// AllocBuf returns a buffer of length size.
+	AllocBuf(size int) []byte
+
+	// ReleaseBuf returns a buffer obtained from AllocBuf.
+	ReleaseBuf(buf []byte)
+}
+
+// DecodeStats on pooled decoding.
+type DecodeStats struct {
+	NumAllocs    int64 // number of packet buffers allocated
+	NumReleases  int64 // number of packet buffers released
+	NumForgotten int64 // number of packet buffers left to GC on close
+	BytesInUse   int64 // bytes held by packets not yet released
+}
+
+// packetBuf is a packet buffer shared by all VbKeyVersions decoded
+// from it.
+type packetBuf struct {
+	buf   []byte
+	refs  int32
+	raddr string // connection the packet was received on
+}
+
+// decodePool decodes packets into buffers from pool and tracks the
+// buffer referred by every VbKeyVersions.
+type decodePool struct {
+	pool BufferPool
+
+	mu   sync.Mutex
+	bufs map[*protobuf.VbKeyVersions]*packetBuf
+
+	numAllocs    int64
+	numReleases  int64
+	numForgotten int64
+	bytesInUse   int64
+}
+
+func newDecodePool(pool BufferPool) *decodePool {
+	return &decodePool{
+		pool: pool,
+		bufs: make(map[*protobuf.VbKeyVersions]*packetBuf),
+	}
+}
+
+// decoder returns a transport decoder for packets received from raddr.
+func (p *decodePool) decoder(raddr string) transport.Decoder {
+	return func(data []byte) (interface{}, error) {
+		return p.decode(raddr, data)
+	}
+}
+
+// decode complements protobufDecode(), but without copying keys and
+// docids of the payload.
+func (p *decodePool) decode(raddr string, data []byte) (value interface{}, err error) {
+	buf := p.pool.AllocBuf(len(data))
+	copy(buf, data)
+	atomic.AddInt64(&p.numAllocs, 1)
+	atomic.AddInt64(&p.bytesInUse, int64(len(buf)))
+
+	pl := &protobuf.Payload{}
+	if err = pl.UnmarshalNoCopy(buf); err == nil {
+		value, err = validatePayload(pl)
+	}
+
+	vbs, ok := value.([]*protobuf.VbKeyVersions)
+	if err != nil || !ok || len(vbs) == 0 {
+		// vbmap does not refer to the buffer.
+		p.release(buf)
+		return value, err
+	}
+
+	pbuf := &packetBuf{buf: buf, refs: int32(len(vbs)), raddr: raddr}
+	p.mu.Lock()
+	for _, vb := range vbs {
+		p.bufs[vb] = pbuf
+	}
+	p.mu.Unlock()
+	return vbs, nil
+}
+
+// releaseKeyVersions drops the reference of vb on its packet buffer.
+func (p *decodePool) releaseKeyVersions(vb *protobuf.VbKeyVersions) {
+	p.mu.Lock()
+	pbuf, ok := p.bufs[vb]
+	if ok {
+		delete(p.bufs, vb)
+		pbuf.refs--
+		ok = pbuf.refs == 0
+	}
+	p.mu.Unlock()
+
+	if ok {
+		p.release(pbuf.buf)
+	}
+}
+
+// forget stops tracking the packets received from raddr, once its
+// connection is closed. Key versions that application is yet to receive
+// or release may still refer to those buffers, so they are not returned
+// to the pool but left to the garbage collector.
+func (p *decodePool) forget(raddr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for vb, pbuf := range p.bufs {
+		if pbuf.raddr != raddr {
+			continue
+		}
+		delete(p.bufs, vb)
+		if pbuf.refs > 0 {
+			pbuf.refs = 0
+			atomic.AddInt64(&p.numForgotten, 1)
+			atomic.AddInt64(&p.bytesInUse, -int64(len(pbuf.buf)))
+		}
+	}
+}
+
+func (p *decodePool) release(buf []byte) {
+	atomic.AddInt64(&p.numReleases, 1)
+	atomic.AddInt64(&p.bytesInUse, -int64(len(buf)))
+	p.pool.ReleaseBuf(buf)
+}
+
+func (p *decodePool) stats() DecodeStats {
+	return DecodeStats{
+		NumAllocs:    atomic.LoadInt64(&p.numAllocs),
+		NumReleases:  atomic.LoadInt64(&p.numReleases),
+		NumForgotten: atomic.LoadInt64(&p.numForgotten),
+		BytesInUse:   atomic.LoadInt64(&p.bytesInUse),
+	}
+}
+
+// NewSyncBufferPool returns a BufferPool backed by sync.Pool, for
+// buffers upto maxSize. Larger buffers are not pooled.
+func NewSyncBufferPool(maxSize int) BufferPool {
+	return &syncBufferPool{
+		maxSize: maxSize,
+		pool: sync.Pool{
+			New: func() interface{} { return make([]byte, maxSize) },
+		},
+	}
+}
+
+type syncBufferPool struct {
+	maxSize int
+	pool    sync.Pool
+}
+
+func (p *syncBufferPool) AllocBuf(size int) []byte {
+	if size > p.maxSize {
+		return make([]byte, size)
+	}
+	return p.pool.Get().([]byte)[:size]
+}
+
+func (p *syncBufferPool) ReleaseBuf(buf []byte) {
+	if cap(buf) == p.maxSize {
+		p.pool.Put(buf[:p.maxSize])
+	}
+}
@@ -0,0 +1,89 @@
+package dataport
+
+import "testing"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/golang/protobuf/proto"
+
+func testDecodePacket(t *testing.T, nvbs int) []byte {
+	pl := &protobuf.Payload{Version: proto.Uint32(uint32(ProtobufVersion()))}
+	for i := 0; i < nvbs; i++ {
+		pl.Vbkeys = append(pl.Vbkeys, &protobuf.VbKeyVersions{
+			Vbucket:    proto.Uint32(uint32(i)),
+			Vbuuid:     proto.Uint64(1),
+			Bucketname: proto.String("default"),
+			Kvs: []*protobuf.KeyVersions{
+				&protobuf.KeyVersions{
+					Seqno: proto.Uint64(1),
+					Docid: []byte("doc"),
+				},
+			},
+		})
+	}
+	data, err := proto.Marshal(pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodePoolRelease(t *testing.T) {
+	p := newDecodePool(NewSyncBufferPool(1024))
+	data := testDecodePacket(t, 2)
+
+	value, err := p.decoder("host:1")(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbs := value.([]*protobuf.VbKeyVersions)
+	if len(vbs) != 2 || string(vbs[1].Kvs[0].Docid) != "doc" {
+		t.Fatalf("unexpected key versions %v", vbs)
+	}
+
+	// the packet buffer is released with the last key versions.
+	p.releaseKeyVersions(vbs[0])
+	if stats := p.stats(); stats.NumReleases != 0 {
+		t.Fatalf("unexpected release %v", stats)
+	}
+	p.releaseKeyVersions(vbs[1])
+	p.releaseKeyVersions(vbs[1]) // no-op
+	stats := p.stats()
+	if stats.NumAllocs != 1 || stats.NumReleases != 1 || stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+	if len(p.bufs) != 0 {
+		t.Fatalf("expected no tracked buffers, got %v", len(p.bufs))
+	}
+}
+
+func TestDecodePoolForget(t *testing.T) {
+	p := newDecodePool(NewSyncBufferPool(1024))
+
+	v1, err := p.decoder("host:1")(testDecodePacket(t, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := p.decoder("host:2")(testDecodePacket(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// key versions not released when the connection is closed are left
+	// to the garbage collector.
+	p.forget("host:1")
+	if len(p.bufs) != 1 {
+		t.Fatalf("expected 1 tracked buffer, got %v", len(p.bufs))
+	}
+	for _, vb := range v1.([]*protobuf.VbKeyVersions) {
+		p.releaseKeyVersions(vb) // no-op
+	}
+	p.releaseKeyVersions(v2.([]*protobuf.VbKeyVersions)[0])
+
+	stats := p.stats()
+	if stats.NumReleases != 1 || stats.NumForgotten != 1 || stats.BytesInUse != 0 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+	if len(p.bufs) != 0 {
+		t.Fatalf("expected no tracked buffers, got %v", len(p.bufs))
+	}
+}
@@ -15,6 +15,9 @@
 //                            |
 //                            V
 //                          buffers
+//
+// Send() blocks upstream once the number of mutations buffered by the
+// endpoint reaches highWatermark, till they drain down to lowWatermark.
 
 package dataport
 
@@ -44,9 +47,12 @@ type RouterEndpoint struct {
 	bufferTm   time.Duration // timeout to flush endpoint-buffer
 	harakiriTm time.Duration // timeout after which endpoint commits harakiri
 	statTick   time.Duration // timeout for logging statistics
+	maxRate    int           // maximum mutations per second, 0 is unlimited
+	maxFrame   int           // maximum size of a frame on the wire
 	// gen-server
 	ch    chan []interface{} // carries control commands
 	finch chan bool
+	flow  *flowControl // bounds mutations buffered by endpoint
 	// downstream
 	pkt  *transport.TransportPacket
 	conn net.Conn
@@ -60,6 +66,7 @@ type RouterEndpoint struct {
 	endCount    int64
 	snapCount   int64
 	flushCount  int64
+	throttleTm  time.Duration // time spent throttling mutations
 	prjLatency  *Average
 }
 
@@ -69,7 +76,7 @@ func NewRouterEndpoint(
 	cluster, topic, raddr string, maxvbs int,
 	config c.Config) (*RouterEndpoint, error) {
 
-	conn, err := net.Dial("tcp", raddr)
+	conn, err := dial(raddr)
 	if err != nil {
 		return nil, err
 	}
@@ -87,12 +94,36 @@ func NewRouterEndpoint(
 		harakiriTm: time.Duration(config["harakiriTimeout"].Int()),
 		prjLatency: &Average{},
 	}
+	if cv, ok := config["maxMutationRate"]; ok {
+		endpoint.maxRate = cv.Int()
+	}
+	high, low := int64(0), int64(0)
+	if cv, ok := config["highWatermark"]; ok {
+		high = int64(cv.Int())
+	}
+	if cv, ok := config["lowWatermark"]; ok {
+		low = int64(cv.Int())
+	}
+	endpoint.flow = newFlowControl(high, low, endpoint.onHighWatermark,
+		endpoint.onLowWatermark)
 	endpoint.ch = make(chan []interface{}, endpoint.keyChSize)
-	endpoint.conn = conn
+	sockOpts := c.NewSocketOptions(config)
+	if err := sockOpts.Apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	endpoint.conn = sockOpts.Coalesce(conn)
 	// TODO: add configuration params for transport flags.
 	flags := transport.TransportFlag(0).SetProtobuf()
 	maxPayload := config["maxPayload"].Int()
 	endpoint.pkt = transport.NewTransportPacket(maxPayload, flags)
+	if cv, ok := config["maxFrameSize"]; ok {
+		endpoint.maxFrame = cv.Int()
+		endpoint.pkt.SetFrameSize(endpoint.maxFrame, 0)
+	}
+	if cv, ok := config["frameChecksum"]; ok {
+		endpoint.pkt.SetCRC32C(cv.Bool())
+	}
 	endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
 	endpoint.pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
 
@@ -139,12 +170,32 @@ func (endpoint *RouterEndpoint) ResetConfig(config c.Config) error {
 
 // Send KeyVersions to other end, asynchronous call.
 // Asynchronous call. Return ErrorChannelFull that can be used by caller.
-func (endpoint *RouterEndpoint) Send(data interface{}) error {
+func (endpoint *RouterEndpoint) Send(data interface{}) (err error) {
+	if err = endpoint.flow.acquire(endpoint.block); err != nil {
+		return err
+	}
 	cmd := []interface{}{endpCmdSend, data}
 	if endpoint.block {
-		return c.FailsafeOpAsync(endpoint.ch, cmd, endpoint.finch)
+		err = c.FailsafeOpAsync(endpoint.ch, cmd, endpoint.finch)
+	} else {
+		err = c.FailsafeOpNoblock(endpoint.ch, cmd, endpoint.finch)
 	}
-	return c.FailsafeOpNoblock(endpoint.ch, cmd, endpoint.finch)
+	if err != nil {
+		endpoint.flow.release(1)
+	}
+	return err
+}
+
+// onHighWatermark is called by flow control when upstream is paused.
+func (endpoint *RouterEndpoint) onHighWatermark(occupancy int64) {
+	fmsg := "%v buffered %v mutations, pausing upstream\n"
+	logging.Debugf(fmsg, endpoint.logPrefix, occupancy)
+}
+
+// onLowWatermark is called by flow control when upstream is resumed.
+func (endpoint *RouterEndpoint) onLowWatermark(occupancy int64) {
+	fmsg := "%v buffered %v mutations, resuming upstream\n"
+	logging.Debugf(fmsg, endpoint.logPrefix, occupancy)
 }
 
 // GetStatistics for this endpoint, synchronous call.
@@ -186,13 +237,15 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 		}
 		// close the connection
 		endpoint.conn.Close()
+		// wake up upstream blocked on flow control
+		endpoint.flow.close()
 		// close this endpoint
 		close(endpoint.finch)
 		logging.Infof("%v ... stopped\n", endpoint.logPrefix)
 	}()
 
 	statSince := time.Now()
-	var stitems [14]string
+	var stitems [18]string
 	logstats := func() {
 		prjLatency := endpoint.prjLatency
 		stitems[0] = `"topic":"` + endpoint.topic + `"`
@@ -209,6 +262,11 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 		stitems[11] = `"latency.min":` + strconv.Itoa(int(prjLatency.Min()))
 		stitems[12] = `"latency.max":` + strconv.Itoa(int(prjLatency.Max()))
 		stitems[13] = `"latency.avg":` + strconv.Itoa(int(prjLatency.Mean()))
+		stitems[14] = `"throttleTime":` + strconv.Itoa(int(endpoint.throttleTm/time.Millisecond))
+		occupancy, paused, numPauses := endpoint.flow.stats()
+		stitems[15] = `"bufferOccupancy":` + strconv.Itoa(int(occupancy))
+		stitems[16] = `"bufferPaused":` + strconv.FormatBool(paused)
+		stitems[17] = `"bufferPauses":` + strconv.Itoa(int(numPauses))
 		statjson := strings.Join(stitems[:], ",")
 		fmsg := "%v stats {%v}\n"
 		logging.Infof(fmsg, endpoint.logPrefix, statjson)
@@ -218,17 +276,34 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 	lastActiveTime := time.Now()
 	buffers := newEndpointBuffers(raddr)
 
+	// throttle mutations to maxRate per second. While throttled, the
+	// endpoint stops receiving from its channel but keeps flushing its
+	// buffers; upstream backs off once the channel is full.
+	limiter := newRateLimiter(endpoint.maxRate, time.Now())
+	recvch := ch
+	var throttlech <-chan time.Time
+	throttle := func() {
+		if d := limiter.take(time.Now()); d > 0 {
+			recvch, throttlech = nil, time.After(d)
+			endpoint.throttleTm += d
+		}
+	}
+
 	messageCount := 0
 	flushBuffers := func() (err error) {
 		fmsg := "%v sent %v mutations to %q\n"
 		logging.Tracef(fmsg, endpoint.logPrefix, messageCount, raddr)
 		if messageCount > 0 {
 			err = buffers.flushBuffers(endpoint, endpoint.conn, endpoint.pkt)
+			if err == nil {
+				err = c.FlushConn(endpoint.conn)
+			}
 			if err != nil {
 				logging.Errorf("%v flushBuffers() %v\n", endpoint.logPrefix, err)
 			}
 			endpoint.flushCount++
 		}
+		endpoint.flow.release(messageCount)
 		messageCount = 0
 		if time.Since(statSince) > endpoint.statTick {
 			logstats()
@@ -240,7 +315,7 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 loop:
 	for {
 		select {
-		case msg := <-ch:
+		case msg := <-recvch:
 			switch msg[0].(byte) {
 			case endpCmdPing:
 				respch := msg[1].(chan []interface{})
@@ -265,6 +340,7 @@ loop:
 						break loop
 					}
 				}
+				throttle()
 
 				lastActiveTime = time.Now()
 
@@ -277,6 +353,16 @@ loop:
 				if cv, ok := config["bufferSize"]; ok {
 					endpoint.bufferSize = cv.Int()
 				}
+				if cv, ok := config["maxMutationRate"]; ok {
+					endpoint.maxRate = cv.Int()
+					limiter.reset(endpoint.maxRate, time.Now())
+				}
+				hcv, hok := config["highWatermark"]
+				lcv, lok := config["lowWatermark"]
+				if hok && lok {
+					high, low := int64(hcv.Int()), int64(lcv.Int())
+					endpoint.flow.setWatermarks(high, low)
+				}
 				if cv, ok := config["statTick"]; ok {
 					endpoint.statTick = time.Duration(cv.Int())
 					endpoint.statTick *= time.Millisecond
@@ -311,6 +397,9 @@ loop:
 				break loop
 			}
 
+		case <-throttlech:
+			recvch, throttlech = ch, nil
+
 		case <-flushTick.C:
 			if err := flushBuffers(); err != nil {
 				break loop
@@ -335,7 +424,12 @@ loop:
 }
 
 func (endpoint *RouterEndpoint) newStats() c.Statistics {
-	m := map[string]interface{}{}
+	occupancy, paused, numPauses := endpoint.flow.stats()
+	m := map[string]interface{}{
+		"bufferOccupancy": float64(occupancy),
+		"bufferPaused":    paused,
+		"bufferPauses":    float64(numPauses),
+	}
 	stats, _ := c.NewStatistics(m)
 	return stats
 }
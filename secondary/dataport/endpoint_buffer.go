@@ -72,8 +72,61 @@ func (b *endpointBuffers) flushBuffers(
 	}
 	b.vbs = make(map[string]*c.VbKeyVersions)
 
-	if err := pkt.Send(conn, vbs); err != nil {
-		return err
+	for _, frame := range splitFrames(vbs, endpoint.maxFrame) {
+		if err := pkt.Send(conn, frame); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// splitFrames splits a batch of vbuckets into frames, each estimated to
+// be encoded within maxFrame bytes. A vbucket's mutations may be split
+// across frames, in seqno order. A frame that still exceeds maxFrame,
+// like a single large mutation, is fragmented by transport.
+func splitFrames(vbs []*c.VbKeyVersions, maxFrame int) [][]*c.VbKeyVersions {
+	if maxFrame <= 0 {
+		return [][]*c.VbKeyVersions{vbs}
+	}
+	frames := make([][]*c.VbKeyVersions, 0, 1)
+	frame, size := make([]*c.VbKeyVersions, 0, len(vbs)), 0
+	for _, vb := range vbs {
+		part := newPartVbKeyVersions(vb, len(vb.Kvs))
+		for _, kv := range vb.Kvs {
+			kvsize := kvEncodedSize(kv)
+			if size > 0 && size+kvsize > maxFrame { // close the frame.
+				if len(part.Kvs) > 0 {
+					frame = append(frame, part)
+					part = newPartVbKeyVersions(vb, 16)
+				}
+				frames = append(frames, frame)
+				frame, size = make([]*c.VbKeyVersions, 0, len(vbs)), 0
+			}
+			part.Kvs = append(part.Kvs, kv)
+			size += kvsize
+		}
+		if len(part.Kvs) > 0 {
+			frame = append(frame, part)
+		}
+	}
+	if len(frame) > 0 {
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func newPartVbKeyVersions(vb *c.VbKeyVersions, nMuts int) *c.VbKeyVersions {
+	part := c.NewVbKeyVersions(vb.Bucket, vb.Vbucket, vb.Vbuuid, nMuts)
+	part.ProjVer = vb.ProjVer
+	return part
+}
+
+// kvEncodedSize estimates the size of key-versions encoded as protobuf,
+// including the field tags and lengths.
+func kvEncodedSize(kv *c.KeyVersions) int {
+	size := 16 + len(kv.Docid)
+	for i := range kv.Uuids {
+		size += 24 + len(kv.Keys[i]) + len(kv.Oldkeys[i]) + len(kv.Partnkeys[i])
+	}
+	return size
+}
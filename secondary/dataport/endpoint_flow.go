@@ -0,0 +1,154 @@
+package dataport
+
+import "sync"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// flowControl bounds the number of mutations buffered by an endpoint,
+// that is, sent by upstream and not yet flushed to the remote. Once the
+// occupancy reaches the high watermark, onHigh is called and Send() blocks
+// the upstream vbucket routines till the occupancy drains down to the low
+// watermark, when onLow is called. A high watermark of 0 disables flow
+// control.
+type flowControl struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	occupancy int64
+	high      int64
+	low       int64
+	paused    bool
+	closed    bool
+	numPauses int64
+	onHigh    func(occupancy int64)
+	onLow     func(occupancy int64)
+}
+
+func newFlowControl(high, low int64, onHigh, onLow func(int64)) *flowControl {
+	fc := &flowControl{onHigh: onHigh, onLow: onLow}
+	fc.cond = sync.NewCond(&fc.mu)
+	fc.setWatermarks(high, low)
+	return fc
+}
+
+// setWatermarks live updates the watermarks.
+func (fc *flowControl) setWatermarks(high, low int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if low > high {
+		low = high
+	}
+	fc.high, fc.low = high, low
+	if fc.paused && (fc.high <= 0 || fc.occupancy <= fc.low) {
+		fc.resume()
+	}
+}
+
+// acquire a slot for a mutation, blocking while paused if block is
+// true. Return ErrorChannelFull if paused and block is false, or
+// ErrorClosed if the endpoint is closed.
+func (fc *flowControl) acquire(block bool) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for fc.paused && !fc.closed {
+		if !block {
+			return c.ErrorChannelFull
+		}
+		fc.cond.Wait()
+	}
+	if fc.closed {
+		return c.ErrorClosed
+	}
+
+	fc.occupancy++
+	if fc.high > 0 && fc.occupancy >= fc.high {
+		fc.paused = true
+		fc.numPauses++
+		if fc.onHigh != nil {
+			fc.onHigh(fc.occupancy)
+		}
+	}
+	return nil
+}
+
+// release n slots, once the mutations are flushed to remote or dropped.
+func (fc *flowControl) release(n int) {
+	if n <= 0 {
+		return
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.occupancy -= int64(n)
+	if fc.occupancy < 0 {
+		fc.occupancy = 0
+	}
+	if fc.paused && fc.occupancy <= fc.low {
+		fc.resume()
+	}
+}
+
+func (fc *flowControl) resume() {
+	fc.paused = false
+	if fc.onLow != nil {
+		fc.onLow(fc.occupancy)
+	}
+	fc.cond.Broadcast()
+}
+
+// close wakes up all blocked upstream routines.
+func (fc *flowControl) close() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.closed = true
+	fc.cond.Broadcast()
+}
+
+// stats return occupancy, whether upstream is paused and the number of
+// times upstream was paused.
+func (fc *flowControl) stats() (occupancy int64, paused bool, numPauses int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.occupancy, fc.paused, fc.numPauses
+}
+
+// rateLimiter paces the mutations sent by an endpoint to maxRate per
+// second. A maxRate of 0 disables rate limiting.
+type rateLimiter struct {
+	maxRate int
+	since   time.Time
+	count   int
+}
+
+func newRateLimiter(maxRate int, now time.Time) *rateLimiter {
+	rl := &rateLimiter{}
+	rl.reset(maxRate, now)
+	return rl
+}
+
+// reset the rate and start a new measurement window.
+func (rl *rateLimiter) reset(maxRate int, now time.Time) {
+	rl.maxRate, rl.since, rl.count = maxRate, now, 0
+}
+
+// take accounts for a mutation sent at now, and returns how long the
+// endpoint shall hold off further mutations to stay within maxRate.
+func (rl *rateLimiter) take(now time.Time) time.Duration {
+	if rl.maxRate <= 0 {
+		return 0
+	}
+	rl.count++
+	elapsed := now.Sub(rl.since)
+	expected := time.Duration(rl.count) * time.Second / time.Duration(rl.maxRate)
+	if d := expected - elapsed; d > time.Millisecond {
+		return d
+	} else if elapsed > time.Second {
+		rl.since, rl.count = now, 0
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package dataport
+
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+func TestFlowControlWatermarks(t *testing.T) {
+	var highs, lows int
+	fc := newFlowControl(4, 2,
+		func(int64) { highs++ }, func(int64) { lows++ })
+
+	for i := 0; i < 4; i++ {
+		if err := fc.acquire(true); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if occupancy, paused, _ := fc.stats(); occupancy != 4 || !paused {
+		t.Fatalf("expected paused at 4, got %v %v", occupancy, paused)
+	}
+	if err := fc.acquire(false); err != c.ErrorChannelFull {
+		t.Fatalf("expected %v, got %v", c.ErrorChannelFull, err)
+	}
+
+	donech := make(chan error)
+	go func() { donech <- fc.acquire(true) }()
+
+	fc.release(1) // above low watermark, stays paused.
+	select {
+	case <-donech:
+		t.Fatalf("expected upstream to be paused")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fc.release(1)
+	if err := <-donech; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if highs != 1 || lows != 1 {
+		t.Fatalf("expected 1 pause and resume, got %v %v", highs, lows)
+	}
+
+	fc.close()
+	if err := fc.acquire(true); err != c.ErrorClosed {
+		t.Fatalf("expected %v, got %v", c.ErrorClosed, err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(100, now) // 10ms per mutation
+
+	if d := rl.take(now); d != 10*time.Millisecond {
+		t.Fatalf("expected 10ms, got %v", d)
+	}
+	// mutations spaced at the allowed rate are not held off.
+	if d := rl.take(now.Add(20 * time.Millisecond)); d != 0 {
+		t.Fatalf("expected no delay, got %v", d)
+	}
+	// a burst is held off till the rate catches up.
+	for i := 0; i < 8; i++ {
+		rl.take(now.Add(20 * time.Millisecond))
+	}
+	if d := rl.take(now.Add(20 * time.Millisecond)); d != 90*time.Millisecond {
+		t.Fatalf("expected 90ms, got %v", d)
+	}
+
+	rl.reset(0, now)
+	if d := rl.take(now); d != 0 {
+		t.Fatalf("expected no delay when disabled, got %v", d)
+	}
+}
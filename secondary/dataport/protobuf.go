@@ -83,6 +83,12 @@ func protobufDecode(data []byte) (value interface{}, err error) {
 	if err = proto.Unmarshal(data, pl); err != nil {
 		return nil, err
 	}
+	return validatePayload(pl)
+}
+
+// validatePayload checks the version of a decoded payload and returns
+// back the value inside the payload.
+func validatePayload(pl *protobuf.Payload) (value interface{}, err error) {
 	currVer := ProtobufVersion()
 	if ver := byte(pl.GetVersion()); ver == currVer {
 		// do nothing
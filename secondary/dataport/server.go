@@ -56,6 +56,7 @@ import "errors"
 import "fmt"
 import "io"
 import "net"
+import "sync/atomic"
 import "time"
 
 import c "github.com/couchbase/indexing/secondary/common"
@@ -83,6 +84,12 @@ var ErrorDuplicateClient = errors.New("dataport.duplicateClient")
 // ErrorWorkerKilled
 var ErrorWorkerKilled = errors.New("dataport.workerKilled")
 
+// ErrorTooManyConnections
+var ErrorTooManyConnections = errors.New("dataport.tooManyConnections")
+
+// ErrorTooManyHostConnections
+var ErrorTooManyHostConnections = errors.New("dataport.tooManyHostConnections")
+
 type activeVb struct {
 	raddr  string // remote connection carrying this vbucket.
 	bucket string
@@ -138,8 +145,21 @@ type Server struct {
 	maxVbuckets  int
 	genChSize    int           // channel size for genServer routine
 	maxPayload   int           // maximum payload length from router
+	maxFrame     int           // maximum frame length from router
+	maxAssembled int           // maximum payload length reassembled from frames
 	readDeadline time.Duration // timeout, in millisecond, reading from socket
 	logPrefix    string
+
+	// limits on remote connections, 0 for no limit
+	maxConns      int           // maximum connections
+	maxHostConns  int           // maximum connections from a host
+	packetTimeout time.Duration // timeout, in millisecond, reading a packet once started
+	sockOpts      c.SocketOptions
+
+	decPool *decodePool // nil, unless decoding into pooled buffers
+
+	// stats
+	corruptFrames int64 // frames received with checksum mismatch
 }
 
 // NewServer creates a new dataport daemon.
@@ -149,6 +169,20 @@ func NewServer(
 	config c.Config,
 	appch chan<- interface{}) (s *Server, err error) {
 
+	return NewServerWithPool(laddr, maxvbs, config, appch, nil)
+}
+
+// NewServerWithPool creates a new dataport daemon that decodes mutations
+// into buffers allocated from pool. Application shall release every
+// *VbKeyVersions it receives using ReleaseKeyVersions(). If pool is nil,
+// mutations are decoded the usual way.
+func NewServerWithPool(
+	laddr string,
+	maxvbs int,
+	config c.Config,
+	appch chan<- interface{},
+	pool BufferPool) (s *Server, err error) {
+
 	genChSize := config["genServerChanSize"].Int()
 	dataChSize := config["dataChanSize"].Int()
 
@@ -165,9 +199,28 @@ func NewServer(
 		genChSize:    genChSize,
 		maxPayload:   config["maxPayload"].Int(),
 		readDeadline: time.Duration(config["tcpReadDeadline"].Int()),
+		sockOpts:     c.NewSocketOptions(config),
+	}
+	if cv, ok := config["maxFrameSize"]; ok {
+		s.maxFrame = cv.Int()
+	}
+	if cv, ok := config["maxAssembledSize"]; ok {
+		s.maxAssembled = cv.Int()
+	}
+	if cv, ok := config["maxConnections"]; ok {
+		s.maxConns = cv.Int()
+	}
+	if cv, ok := config["maxHostConnections"]; ok {
+		s.maxHostConns = cv.Int()
+	}
+	if cv, ok := config["packetReadTimeout"]; ok {
+		s.packetTimeout = time.Duration(cv.Int())
+	}
+	if pool != nil {
+		s.decPool = newDecodePool(pool)
 	}
 	s.logPrefix = fmt.Sprintf("DATP[->dataport %q]", laddr)
-	if s.lis, err = net.Listen("tcp", laddr); err != nil {
+	if s.lis, err = listen(laddr); err != nil {
 		logging.Errorf("%v failed starting! %v\n", s.logPrefix, err)
 		return nil, err
 	}
@@ -246,6 +299,7 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 			if avbok && (msg.raddr != avb.raddr) {
 				fmsg := "%v filter %d mutations for %v\n"
 				logging.Warnf(fmsg, s.logPrefix, len(kvs), id)
+				s.ReleaseKeyVersions(vb)
 				continue
 			}
 			vbok := false
@@ -255,6 +309,10 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 				}
 				switch byte(kv.GetCommands()[0]) {
 				case c.StreamBegin: // new vbucket stream(s) have started
+					// StreamBegin carries no docid, it is delivered with
+					// the remote address of the projector streaming the
+					// vbucket as docid.
+					kv.Docid = []byte(msg.raddr)
 					avb = &activeVb{raddr: msg.raddr, bucket: bucket, vbno: vbno}
 					hostUuids = s.addUuids(keeper{id: avb}, hostUuids)
 					avbok, vbok = true, true
@@ -282,6 +340,7 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 			} else {
 				fmsg := "%v mutations filtered for %v\n"
 				logging.Warnf(fmsg, s.logPrefix, id)
+				s.ReleaseKeyVersions(vb)
 			}
 			logging.Tracef("%v {%v, %v}\n", s.logPrefix, bucket, vbno)
 		}
@@ -298,11 +357,19 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 				logging.Errorf("%v %q already active\n", s.logPrefix, raddr)
 				conn.Close()
 
+			} else if err := s.admitConnection(raddr); err != nil {
+				logging.Errorf("%v %q rejected: %v\n", s.logPrefix, raddr, err)
+				conn.Close()
+
+			} else if err := s.sockOpts.Apply(conn); err != nil {
+				logging.Errorf("%v %q socket options: %v\n", s.logPrefix, raddr, err)
+				conn.Close()
+
 			} else { // connection accepted
 				worker := make(chan interface{}, s.maxVbuckets)
 				s.conns[raddr] = &netConn{
 					conn: conn, worker: worker,
-					tpkt: newTransportPkt(s.maxPayload),
+					tpkt: s.newTransportPkt(raddr),
 				}
 				n := len(s.conns)
 				fmsg := "%v new connection %q +%d\n"
@@ -369,6 +436,37 @@ loop:
 	}
 }
 
+// ReleaseKeyVersions shall be called by application once it is done
+// with the key versions received from a server created with
+// NewServerWithPool(). It is a no-op otherwise.
+func (s *Server) ReleaseKeyVersions(vb *protobuf.VbKeyVersions) {
+	if s.decPool != nil {
+		s.decPool.releaseKeyVersions(vb)
+	}
+}
+
+// forgetDecodeBufs stops tracking decode buffers of a closed connection.
+func (s *Server) forgetDecodeBufs(raddr string) {
+	if s.decPool != nil {
+		s.decPool.forget(raddr)
+	}
+}
+
+// GetDecodeStats returns stats on decoding into pooled buffers.
+func (s *Server) GetDecodeStats() DecodeStats {
+	if s.decPool != nil {
+		return s.decPool.stats()
+	}
+	return DecodeStats{}
+}
+
+// GetCorruptFrames returns the number of frames received with checksum
+// mismatch. Connection, on which a corrupt frame is received, is closed
+// and its vbuckets are reported to application as ConnectionError.
+func (s *Server) GetCorruptFrames() int64 {
+	return atomic.LoadInt64(&s.corruptFrames)
+}
+
 // shutdown this gen server and all its routines.
 func (s *Server) handleClose() {
 	defer func() {
@@ -382,6 +480,7 @@ func (s *Server) handleClose() {
 
 	for raddr, nc := range s.conns {
 		closeConnection(s.logPrefix, raddr, nc)
+		s.forgetDecodeBufs(raddr)
 	}
 	s.lis, s.conns = nil, nil
 	close(s.finch)
@@ -390,6 +489,18 @@ func (s *Server) handleClose() {
 	return
 }
 
+// admitConnection checks the limits on remote connections, for a new
+// connection from raddr.
+func (s *Server) admitConnection(raddr string) error {
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		return ErrorTooManyConnections
+	}
+	if s.maxHostConns > 0 && len(remoteConnections(raddr, s.conns)) >= s.maxHostConns {
+		return ErrorTooManyHostConnections
+	}
+	return nil
+}
+
 // start a connection worker to read mutation message for a subset of vbuckets.
 func (s *Server) startWorker(raddr string) {
 	nc, ok := s.conns[raddr]
@@ -399,7 +510,7 @@ func (s *Server) startWorker(raddr string) {
 		return
 	}
 	logging.Tracef("%v starting worker for connection %q\n", s.logPrefix, raddr)
-	go doReceive(s.logPrefix, nc, s.maxPayload, s.readDeadline, s.datach)
+	go doReceive(s.logPrefix, nc, s.maxPayload, s.readDeadline, s.packetTimeout, s.datach)
 	nc.active = true
 }
 
@@ -421,6 +532,17 @@ func (s *Server) jumboErrorHandler(
 		logging.Errorf("%v remote %q closed\n", s.logPrefix, raddr)
 		whatJumbo = "closeremote"
 
+	} else if err == transport.ErrorFrameCorrupt || err == transport.ErrorChecksumMismatch {
+		atomic.AddInt64(&s.corruptFrames, 1)
+		fmsg := "%v remote %q corrupt frame, restarting streams: %v\n"
+		logging.Errorf(fmsg, s.logPrefix, raddr, err)
+		whatJumbo = "closeremote"
+
+	} else if err == ErrorSlowPacket {
+		fmsg := "%v remote %q evicted, packet not received within %vms\n"
+		logging.Errorf(fmsg, s.logPrefix, raddr, int64(s.packetTimeout))
+		whatJumbo = "closeremote"
+
 	} else if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 		logging.Errorf("%v remote %q timeout: %v\n", s.logPrefix, raddr, err)
 		whatJumbo = "closeremote"
@@ -442,6 +564,7 @@ func (s *Server) jumboErrorHandler(
 		actvUuids = s.delUuids(finished, hostUuids)
 		closeConnection(s.logPrefix, raddr, s.conns[raddr])
 		delete(s.conns, raddr)
+		s.forgetDecodeBufs(raddr)
 		msg = ce
 
 	// NOTE: application does not expect dataport-server to be automatically
@@ -537,10 +660,11 @@ loop:
 func doReceive(
 	prefix string,
 	nc *netConn,
-	maxPayload int, readDeadline time.Duration,
+	maxPayload int, readDeadline, packetTimeout time.Duration,
 	datach chan<- []interface{}) {
 
 	conn, worker := nc.conn, nc.worker
+	pconn := &packetConn{Conn: conn, timeout: packetTimeout * time.Millisecond}
 
 	pkt := nc.tpkt
 	msg := serverMessage{raddr: conn.RemoteAddr().String()}
@@ -558,10 +682,10 @@ func doReceive(
 loop:
 	for {
 		timeoutMs := readDeadline * time.Millisecond
-		conn.SetReadDeadline(time.Now().Add(timeoutMs))
+		pconn.begin(time.Now().Add(timeoutMs))
 		msg.cmd, msg.err, msg.args = 0, nil, nil
-		if payload, err := pkt.Receive(conn); err != nil {
-			msg.cmd, msg.err = serverCmdError, err
+		if payload, err := pkt.Receive(pconn); err != nil {
+			msg.cmd, msg.err = serverCmdError, pconn.receiveError(err)
 			datach <- []interface{}{msg}
 			logging.Errorf("%v worker %q exit: %v\n", prefix, msg.raddr, err)
 			break loop
@@ -609,6 +733,46 @@ loop:
 	nc.active = false
 }
 
+// ErrorSlowPacket
+var ErrorSlowPacket = errors.New("dataport.slowPacket")
+
+// packetConn shortens the read deadline of a connection to timeout, once
+// the first byte of a packet is received, so that a remote trickling a
+// packet is evicted without waiting for the read deadline.
+type packetConn struct {
+	net.Conn
+	timeout  time.Duration // 0 for no timeout
+	deadline time.Time     // read deadline of the connection
+	started  bool          // packet being received
+}
+
+// begin receiving a packet within deadline.
+func (pc *packetConn) begin(deadline time.Time) {
+	pc.deadline, pc.started = deadline, false
+	pc.Conn.SetReadDeadline(deadline)
+}
+
+func (pc *packetConn) Read(b []byte) (n int, err error) {
+	n, err = pc.Conn.Read(b)
+	if n > 0 && !pc.started && pc.timeout > 0 {
+		pc.started = true
+		if deadline := time.Now().Add(pc.timeout); deadline.Before(pc.deadline) {
+			pc.Conn.SetReadDeadline(deadline)
+		}
+	}
+	return n, err
+}
+
+// receiveError returns ErrorSlowPacket for a timeout after a packet
+// started to be received, within the read deadline.
+func (pc *packetConn) receiveError(err error) error {
+	if neterr, ok := err.(net.Error); ok && neterr.Timeout() &&
+		pc.started && pc.timeout > 0 && time.Now().Before(pc.deadline) {
+		return ErrorSlowPacket
+	}
+	return err
+}
+
 func vbucketSchedule(vb *protobuf.VbKeyVersions) (s, e *protobuf.KeyVersions) {
 	for _, kv := range vb.GetKvs() {
 		commands := kv.GetCommands()
@@ -650,10 +814,15 @@ func (ce ConnectionError) Append(hostUuids keeper, raddr string) keeper {
 	return finished
 }
 
-func newTransportPkt(maxPayload int) *transport.TransportPacket {
+func (s *Server) newTransportPkt(raddr string) *transport.TransportPacket {
 	flags := transport.TransportFlag(0).SetProtobuf()
-	pkt := transport.NewTransportPacket(maxPayload, flags)
+	pkt := transport.NewTransportPacket(s.maxPayload, flags)
+	pkt.SetFrameSize(s.maxFrame, s.maxAssembled)
 	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
-	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	if s.decPool != nil {
+		pkt.SetDecoder(transport.EncodingProtobuf, s.decPool.decoder(raddr))
+	} else {
+		pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	}
 	return pkt
 }
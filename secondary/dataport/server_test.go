@@ -3,6 +3,8 @@ package dataport
 import "testing"
 import "time"
 import "fmt"
+import "io"
+import "net"
 
 import "github.com/couchbase/indexing/secondary/logging"
 import c "github.com/couchbase/indexing/secondary/common"
@@ -258,6 +260,26 @@ func BenchmarkLoopback(b *testing.B) {
 	daemon.Close()
 }
 
+func TestSlowPacket(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	pconn := &packetConn{Conn: server, timeout: 50 * time.Millisecond}
+	pconn.begin(time.Now().Add(10 * time.Second))
+
+	go client.Write([]byte{0}) // trickle a single byte of the packet
+
+	start := time.Now()
+	_, err := io.ReadFull(pconn, make([]byte, 8))
+	if err = pconn.receiveError(err); err != ErrorSlowPacket {
+		t.Fatalf("expected %v, got %v", ErrorSlowPacket, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("slow packet detected after %v", elapsed)
+	}
+}
+
 func makeVbmaps(maxvbuckets int, maxBuckets int) []*c.VbConnectionMap {
 	vbmaps := make([]*c.VbConnectionMap, 0, maxBuckets)
 	for i := 0; i < maxBuckets; i++ {
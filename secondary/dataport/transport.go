@@ -0,0 +1,183 @@
+// transport used by dataport server, client and router-endpoint to
+// listen for and open connections. By default connections are TCP
+// sockets, while tests can use in-memory transport to stream mutations
+// between projector and indexer within the same process.
+
+package dataport
+
+import "errors"
+import "net"
+import "strconv"
+import "sync"
+
+// ErrorTransportClosed
+var ErrorTransportClosed = errors.New("dataport.transportClosed")
+
+// ErrorAddressInUse
+var ErrorAddressInUse = errors.New("dataport.addressInUse")
+
+// ErrorConnectionRefused
+var ErrorConnectionRefused = errors.New("dataport.connectionRefused")
+
+// Transport to listen for and open dataport connections.
+type Transport interface {
+	// Listen on local address laddr.
+	Listen(laddr string) (net.Listener, error)
+
+	// Dial remote address raddr.
+	Dial(raddr string) (net.Conn, error)
+}
+
+var transportMu sync.RWMutex
+var dpTransport Transport = tcpTransport{}
+
+// SetTransport sets the transport for dataport connections opened
+// there after, and returns the previous transport.
+func SetTransport(t Transport) Transport {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	old := dpTransport
+	dpTransport = t
+	return old
+}
+
+func getTransport() Transport {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	return dpTransport
+}
+
+func listen(laddr string) (net.Listener, error) {
+	return getTransport().Listen(laddr)
+}
+
+func dial(raddr string) (net.Conn, error) {
+	return getTransport().Dial(raddr)
+}
+
+// tcpTransport is the default transport.
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(laddr string) (net.Listener, error) {
+	return net.Listen("tcp", laddr)
+}
+
+func (tcpTransport) Dial(raddr string) (net.Conn, error) {
+	return net.Dial("tcp", raddr)
+}
+
+// MemTransport connects dialers with listeners by address, using
+// in-memory pipes.
+type MemTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*memListener
+	nextPort  int // to make up unique remote address for every dial
+}
+
+// NewMemTransport returns an in-memory transport.
+func NewMemTransport() *MemTransport {
+	return &MemTransport{
+		listeners: make(map[string]*memListener),
+		nextPort:  1,
+	}
+}
+
+// Listen is part of Transport interface.
+func (t *MemTransport) Listen(laddr string) (net.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.listeners[laddr]; ok {
+		return nil, ErrorAddressInUse
+	}
+	lis := &memListener{
+		t:      t,
+		laddr:  memAddr(laddr),
+		connch: make(chan net.Conn),
+		finch:  make(chan bool),
+	}
+	t.listeners[laddr] = lis
+	return lis, nil
+}
+
+// Dial is part of Transport interface.
+func (t *MemTransport) Dial(raddr string) (net.Conn, error) {
+	t.mu.Lock()
+	lis, ok := t.listeners[raddr]
+	laddr := memAddr("mem-client:" + strconv.Itoa(t.nextPort))
+	t.nextPort++
+	t.mu.Unlock()
+
+	if !ok {
+		return nil, ErrorConnectionRefused
+	}
+
+	client, server := net.Pipe()
+	select {
+	case lis.connch <- &memConn{Conn: server, laddr: lis.laddr, raddr: laddr}:
+		return &memConn{Conn: client, laddr: laddr, raddr: lis.laddr}, nil
+	case <-lis.finch:
+		client.Close()
+		server.Close()
+		return nil, ErrorConnectionRefused
+	}
+}
+
+type memListener struct {
+	t      *MemTransport
+	laddr  memAddr
+	connch chan net.Conn
+	finch  chan bool
+	once   sync.Once
+}
+
+func (lis *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-lis.connch:
+		return conn, nil
+	case <-lis.finch:
+		// same as a closed tcp listener, refer listener()
+		return nil, &net.OpError{Op: "accept", Net: "mem", Err: ErrorTransportClosed}
+	}
+}
+
+func (lis *memListener) Close() error {
+	lis.once.Do(func() {
+		lis.t.mu.Lock()
+		if lis.t.listeners[string(lis.laddr)] == lis {
+			delete(lis.t.listeners, string(lis.laddr))
+		}
+		lis.t.mu.Unlock()
+		close(lis.finch)
+	})
+	return nil
+}
+
+func (lis *memListener) Addr() net.Addr {
+	return lis.laddr
+}
+
+// memConn reports the dialed and listened address, unlike net.Pipe().
+type memConn struct {
+	net.Conn
+	laddr memAddr
+	raddr memAddr
+}
+
+func (conn *memConn) LocalAddr() net.Addr {
+	return conn.laddr
+}
+
+func (conn *memConn) RemoteAddr() net.Addr {
+	return conn.raddr
+}
+
+type memAddr string
+
+func (addr memAddr) Network() string {
+	return "mem"
+}
+
+func (addr memAddr) String() string {
+	return string(addr)
+}
@@ -0,0 +1,61 @@
+package dataport
+
+import "testing"
+
+import "github.com/couchbase/indexing/secondary/logging"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+
+func TestMemTransport(t *testing.T) {
+	logging.SetLogLevel(logging.Silent)
+
+	old := SetTransport(NewMemTransport())
+	defer SetTransport(old)
+
+	raddr := "indexer:9999"
+	maxBuckets, maxvbuckets, mutChanSize := 1, 4, 100
+
+	// start server
+	appch := make(chan interface{}, mutChanSize)
+	dconfig := c.SystemConfig.SectionConfig("indexer.dataport.", true /*trim*/)
+	daemon, err := NewServer(raddr, maxvbuckets, dconfig, appch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+
+	if _, err := dial("indexer:8888"); err != ErrorConnectionRefused {
+		t.Fatalf("expected %v, got %v", ErrorConnectionRefused, err)
+	}
+
+	// start endpoint
+	config := c.SystemConfig.SectionConfig("projector.dataport.", true /*trim*/)
+	endp, err := NewRouterEndpoint("clust", "topic", raddr, maxvbuckets, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer endp.Close()
+
+	vbmap := makeVbmaps(maxvbuckets, maxBuckets)[0]
+	for i := 0; i < len(vbmap.Vbuckets); i++ {
+		vbno, vbuuid := vbmap.Vbuckets[i], vbmap.Vbuuids[i]
+		kv := c.NewKeyVersions(uint64(0), []byte("Bourne"), 1)
+		kv.AddStreamBegin()
+		dkv := &c.DataportKeyVersions{
+			Bucket: vbmap.Bucket, Vbno: vbno, Vbuuid: vbuuid, Kv: kv,
+		}
+		if err := endp.Send(dkv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := 0
+	for count < len(vbmap.Vbuckets) {
+		msg := <-appch
+		vbs, ok := msg.([]*protobuf.VbKeyVersions)
+		if !ok {
+			t.Fatalf("unexpected message %T %v", msg, msg)
+		}
+		count += len(vbs)
+	}
+}
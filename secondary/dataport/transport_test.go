@@ -37,6 +37,108 @@ func TestPktKeyVersions(t *testing.T) {
 	}
 }
 
+func TestPktFragments(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	tc := newTestConnection()
+	tc.reset()
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(1000*1024, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt.SetFrameSize(100, 0)
+
+	if err := pkt.Send(tc, vbsRef); err != nil { // Send fragments
+		t.Fatal(err)
+	}
+	if payload, err := pkt.Receive(tc); err != nil { // Receive reassembled
+		t.Fatal(err)
+	} else { // compare both
+		vbs := protobuf2VbKeyVersions(payload.([]*protobuf.VbKeyVersions))
+		if len(vbsRef) != len(vbs) {
+			t.Fatal("Mismatch in length")
+		}
+		for i, vb := range vbs {
+			if vb.Equal(vbsRef[i]) == false {
+				t.Fatal("Mismatch in VbKeyVersions")
+			}
+		}
+	}
+
+	// reassembled payload overflow
+	tc.reset()
+	if err := pkt.Send(tc, vbsRef); err != nil {
+		t.Fatal(err)
+	}
+	pkt.SetFrameSize(100, 1000)
+	if _, err := pkt.Receive(tc); err != transport.ErrorPacketOverflow {
+		t.Fatalf("expected %v, got %v", transport.ErrorPacketOverflow, err)
+	}
+}
+
+func TestPktCRC32C(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	tc := newTestConnection()
+	tc.reset()
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(1000*1024, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt.SetCRC32C(true)
+
+	if err := pkt.Send(tc, vbsRef); err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := pkt.Receive(tc); err != nil {
+		t.Fatal(err)
+	} else if vbs := payload.([]*protobuf.VbKeyVersions); len(vbs) != len(vbsRef) {
+		t.Fatal("Mismatch in length")
+	}
+
+	// corrupt the payload
+	tc.reset()
+	if err := pkt.Send(tc, vbsRef); err != nil {
+		t.Fatal(err)
+	}
+	tc.buf[tc.woff/2] ^= 0xFF
+	if _, err := pkt.Receive(tc); err != transport.ErrorFrameCorrupt {
+		t.Fatalf("expected %v, got %v", transport.ErrorFrameCorrupt, err)
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	maxFrame := kvEncodedSize(vbsRef[0].Kvs[0]) * 3
+
+	frames := splitFrames(vbsRef, maxFrame)
+	if len(frames) < 2 {
+		t.Fatalf("expected batch to be split, got %v frames", len(frames))
+	}
+	nKvs := make(map[uint16]int)
+	for _, frame := range frames {
+		size := 0
+		for _, vb := range frame {
+			for _, kv := range vb.Kvs {
+				if kv != vbsRef[vb.Vbucket].Kvs[nKvs[vb.Vbucket]] {
+					t.Fatalf("out of order mutation for vbucket %v", vb.Vbucket)
+				}
+				nKvs[vb.Vbucket]++
+				size += kvEncodedSize(kv)
+			}
+		}
+		if size > maxFrame {
+			t.Fatalf("frame size %v > %v", size, maxFrame)
+		}
+	}
+	for _, vb := range vbsRef {
+		if nKvs[vb.Vbucket] != len(vb.Kvs) {
+			t.Fatalf("expected %v mutations, got %v", len(vb.Kvs), nKvs[vb.Vbucket])
+		}
+	}
+}
+
 func TestPktVbmap(t *testing.T) {
 	vbmapRef := &c.VbConnectionMap{
 		Bucket:   "default",
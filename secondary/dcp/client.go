@@ -29,6 +29,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"runtime"
 	"strings"
 	"sync"
@@ -174,6 +175,23 @@ func (b *Bucket) GetStats(which string) (map[string]map[string]string, error) {
 	return rv, err
 }
 
+// GetRandomDoc returns a random document from a random server of the
+// bucket.
+func (b *Bucket) GetRandomDoc() (*transport.MCResponse, error) {
+	vsm := b.VBServerMap()
+	if len(vsm.ServerList) == 0 {
+		return nil, errors.New("no server for bucket")
+	}
+
+	pool := b.getConnPool(rand.Intn(len(vsm.ServerList)))
+	conn, err := pool.Get()
+	defer pool.Return(conn)
+	if err != nil {
+		return nil, err
+	}
+	return conn.GetRandomDoc()
+}
+
 func isAuthError(err error) bool {
 	if err == io.EOF {
 		return true
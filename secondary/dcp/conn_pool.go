@@ -14,6 +14,23 @@ import (
 var errClosedPool = errors.New("the pool is closed")
 var errNoPool = errors.New("no pool")
 
+// AuthError is returned when a new memcached connection fails to
+// authenticate with the kv-node, or to select the bucket.
+type AuthError struct {
+	Host string
+	Err  error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("auth failed with %v: %v", e.Host, e.Err)
+}
+
+// IsAuthError returns true if err is an AuthError.
+func IsAuthError(err error) bool {
+	_, ok := err.(*AuthError)
+	return ok
+}
+
 // GenericMcdAuthHandler is a kind of AuthHandler that performs
 // special auth exchange (like non-standard auth, possibly followed by
 // select-bucket).
@@ -61,7 +78,7 @@ func defaultMkConn(host string, ah AuthHandler) (*memcached.Client, error) {
 		err = gah.AuthenticateMemcachedConn(host, conn)
 		if err != nil {
 			conn.Close()
-			return nil, err
+			return nil, &AuthError{Host: host, Err: err}
 		}
 		return conn, nil
 	}
@@ -70,7 +87,7 @@ func defaultMkConn(host string, ah AuthHandler) (*memcached.Client, error) {
 		_, err = conn.Auth(name, pass)
 		if err != nil {
 			conn.Close()
-			return nil, err
+			return nil, &AuthError{Host: host, Err: err}
 		}
 	}
 	return conn, nil
@@ -120,6 +120,14 @@ func (c *Client) Get(vb uint16, key string) (*transport.MCResponse, error) {
 	})
 }
 
+// GetRandomDoc returns a random document from the bucket, the key of
+// the document is in the response key.
+func (c *Client) GetRandomDoc() (*transport.MCResponse, error) {
+	return c.Send(&transport.MCRequest{
+		Opcode: transport.GET_RANDOM_KEY,
+	})
+}
+
 // Del deletes a key.
 func (c *Client) Del(vb uint16, key string) (*transport.MCResponse, error) {
 	return c.Send(&transport.MCRequest{
@@ -87,6 +87,8 @@ const (
 	SELECT_BUCKET = CommandCode(0x89) // Select bucket
 
 	OBSERVE = CommandCode(0x92)
+
+	GET_RANDOM_KEY = CommandCode(0xb6) // Get a random document
 )
 
 // Status field for memcached response.
@@ -195,6 +197,7 @@ func init() {
 	CommandNames[DCP_BUFFERACK] = "DCP_BUFFERACK"
 	CommandNames[DCP_CONTROL] = "DCP_CONTROL"
 	CommandNames[DCP_GET_SEQNO] = "DCP_GET_SEQNO"
+	CommandNames[GET_RANDOM_KEY] = "GET_RANDOM_KEY"
 
 	StatusNames = make(map[Status]string)
 	StatusNames[SUCCESS] = "SUCCESS"
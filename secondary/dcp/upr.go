@@ -166,7 +166,10 @@ func (b *Bucket) StartDcpFeedOver(
 	feed.activeVbOnly = config["activeVbOnly"].(bool)
 
 	feed.C = feed.output
-	if feed.connectToNodes(kvaddrs, opaque, flags, config) != nil {
+	if err := feed.connectToNodes(kvaddrs, opaque, flags, config); err != nil {
+		if IsAuthError(err) {
+			return nil, err
+		}
 		return nil, ErrorInvalidBucket
 	}
 	go feed.genServer(feed.reqch, opaque)
@@ -335,6 +338,11 @@ func (feed *DcpFeed) connectToNodes(
 				for _, singleFeed := range nodeFeeds {
 					singleFeed.dcpFeed.Close()
 				}
+				if IsAuthError(err) {
+					fmsg := "%v ##%x StartDcpFeed(%v): %v\n"
+					logging.Errorf(fmsg, prefix, opaque, serverConn.host, err)
+					return err
+				}
 				return memcached.ErrorInvalidFeed
 			}
 			// add the node to the connection map
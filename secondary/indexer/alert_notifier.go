@@ -0,0 +1,194 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  alert notifier
+//
+/////////////////////////////////////////////////////////////////////////
+
+// AlertType identifies the condition an alert is raised for.
+type AlertType string
+
+const (
+	ALERT_INGEST_LAG        AlertType = "ingest_lag"
+	ALERT_QUOTA_BREACH      AlertType = "quota_breach"
+	ALERT_STREAM_QUARANTINE AlertType = "stream_quarantine"
+	ALERT_CORRUPTION        AlertType = "corruption"
+)
+
+// Alert is posted, as JSON, to the configured webhooks. Resolved is set
+// once the condition of an earlier alert of the same type has cleared.
+type Alert struct {
+	Type     AlertType `json:"type"`
+	Time     time.Time `json:"time"`
+	Node     string    `json:"node"`
+	Bucket   string    `json:"bucket,omitempty"`
+	Index    string    `json:"index,omitempty"`
+	InstId   uint64    `json:"instId,omitempty"`
+	Resolved bool      `json:"resolved,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// alertQueueSize bounds the alerts waiting to be sent, further alerts
+// are dropped so that raising an alert never blocks the indexer.
+const alertQueueSize = 256
+
+// alertNotifier sends alerts to settings.alert.webhooks, a comma
+// separated list of URLs. Settings can be changed through REST, so
+// webhooks are only posted to hosts in alert.webhook_allowlist, which
+// is read once from the command line and never from settings.
+type alertNotifier struct {
+	config    common.ConfigHolder
+	allowlist []string
+	alertch   chan *Alert
+	dropped   int64
+}
+
+func newAlertNotifier(config common.Config, allowlist []string) *alertNotifier {
+	n := &alertNotifier{
+		allowlist: allowlist,
+		alertch:   make(chan *Alert, alertQueueSize),
+	}
+	n.config.Store(config)
+	go n.run()
+	return n
+}
+
+func (n *alertNotifier) setConfig(config common.Config) {
+	n.config.Store(config)
+}
+
+// notify queues the alert, if any notifier is configured.
+func (n *alertNotifier) notify(alert *Alert) {
+	config := n.config.Load()
+	if len(n.webhooks(config)) == 0 {
+		return
+	}
+
+	alert.Time = time.Now()
+	if cv, ok := config["nodeuuid"]; ok {
+		alert.Node = cv.String()
+	}
+
+	select {
+	case n.alertch <- alert:
+	default:
+		dropped := atomic.AddInt64(&n.dropped, 1)
+		logging.Warnf("alertNotifier::notify queue full, dropped %v alert (%v dropped)",
+			alert.Type, dropped)
+	}
+}
+
+func (n *alertNotifier) run() {
+	for alert := range n.alertch {
+		n.send(alert)
+	}
+}
+
+func (n *alertNotifier) send(alert *Alert) {
+	config := n.config.Load()
+	timeout := time.Duration(config["settings.alert.timeout"].Int()) * time.Second
+
+	data, err := json.Marshal(alert)
+	if err != nil {
+		logging.Errorf("alertNotifier::send error marshalling %v alert: %v", alert.Type, err)
+		return
+	}
+
+	for _, url := range n.webhooks(config) {
+		if err := postAlert(url, data, timeout); err != nil {
+			logging.Errorf("alertNotifier::send %v alert to %v: %v",
+				alert.Type, logging.TagUD(url), err)
+		}
+	}
+}
+
+// webhooks returns the configured webhooks allowed by the allowlist.
+func (n *alertNotifier) webhooks(config common.Config) []string {
+	var urls []string
+	for _, url := range alertWebhookURLs(config["settings.alert.webhooks"].String()) {
+		if err := checkAlertWebhook(url, n.allowlist); err != nil {
+			logging.Warnf("alertNotifier::webhooks skipping %v: %v", logging.TagUD(url), err)
+			continue
+		}
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+func alertWebhookURLs(webhooks string) []string {
+	var urls []string
+	for _, url := range strings.Split(webhooks, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+func splitAlertAllowlist(allowlist string) []string {
+	var hosts []string
+	for _, host := range strings.Split(allowlist, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// checkAlertWebhook returns an error unless rawurl is an http or https
+// URL whose host, or host:port, is in the allowlist.
+func checkAlertWebhook(rawurl string, allowlist []string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("Invalid alert webhook %v: %v", rawurl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Alert webhook %v must be an http or https URL", rawurl)
+	}
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	for _, allowed := range allowlist {
+		if allowed == host || (port != "" && allowed == net.JoinHostPort(host, port)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Host of alert webhook %v is not in %v", rawurl, alertAllowlistConfig)
+}
+
+func postAlert(url string, data []byte, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestAlertNotifierWebhook(t *testing.T) {
+	alertch := make(chan Alert, 2)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Error decoding alert %v", err)
+		}
+		alertch <- alert
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	config := common.Config{
+		"settings.alert.webhooks": common.ConfigValue{Value: " , " + server.URL},
+		"settings.alert.timeout":  common.ConfigValue{Value: 10},
+		"nodeuuid":                common.ConfigValue{Value: "node1"},
+	}
+	n := newAlertNotifier(config, splitAlertAllowlist(server.Listener.Addr().String()))
+	n.notify(&Alert{Type: ALERT_CORRUPTION, Bucket: "default", Index: "idx"})
+
+	select {
+	case alert := <-alertch:
+		if alert.Type != ALERT_CORRUPTION || alert.Node != "node1" ||
+			alert.Bucket != "default" || alert.Index != "idx" || alert.Time.IsZero() {
+			t.Fatalf("Unexpected alert %+v", alert)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timeout waiting for alert")
+	}
+
+	// no alerts are queued once notifiers are removed.
+	config["settings.alert.webhooks"] = common.ConfigValue{Value: ""}
+	n.setConfig(config)
+	n.notify(&Alert{Type: ALERT_CORRUPTION})
+	if len(n.alertch) != 0 {
+		t.Fatalf("Unexpected alert queued")
+	}
+}
+
+func TestAlertNotifierAllowlist(t *testing.T) {
+	allowlist := splitAlertAllowlist(" Hooks.example.com, 10.0.0.1:8080,[::1]:9000 ")
+
+	allowed := []string{
+		"https://hooks.example.com/alert",
+		"http://HOOKS.example.com:8443/alert",
+		"http://10.0.0.1:8080/alert",
+		"http://[::1]:9000/alert",
+	}
+	for _, url := range allowed {
+		if err := checkAlertWebhook(url, allowlist); err != nil {
+			t.Errorf("Expected %v to be allowed, got %v", url, err)
+		}
+	}
+
+	denied := []string{
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1:8081/alert",
+		"http://10.0.0.1/alert",
+		"http://hooks.example.com.evil.com/alert",
+		"file:///etc/passwd",
+		"gopher://hooks.example.com/alert",
+	}
+	for _, url := range denied {
+		if err := checkAlertWebhook(url, allowlist); err == nil {
+			t.Errorf("Expected %v to be denied", url)
+		}
+	}
+
+	// webhooks outside the allowlist are never posted to.
+	config := common.Config{
+		"settings.alert.webhooks": common.ConfigValue{Value: "http://169.254.169.254/"},
+		"settings.alert.timeout":  common.ConfigValue{Value: 10},
+	}
+	n := newAlertNotifier(config, allowlist)
+	n.notify(&Alert{Type: ALERT_CORRUPTION})
+	if len(n.alertch) != 0 {
+		t.Fatalf("Unexpected alert queued")
+	}
+}
+
+func TestValidateAlertSettings(t *testing.T) {
+	allowlist := splitAlertAllowlist("hooks.example.com")
+	current := common.Config{}
+
+	valid := []byte(`{"indexer.settings.alert.webhooks": "https://hooks.example.com/a"}`)
+	if err := validateSettings(valid, current, allowlist, false); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	invalid := [][]byte{
+		[]byte(`{"indexer.settings.alert.webhooks": "https://hooks.example.com/a,http://localhost:9102/"}`),
+		[]byte(`{"indexer.alert.webhook_allowlist": "localhost"}`),
+	}
+	for _, value := range invalid {
+		if err := validateSettings(value, current, allowlist, true); err == nil {
+			t.Errorf("Expected %s to be rejected", value)
+		}
+	}
+}
@@ -153,12 +153,18 @@ func (c *clustMgrAgent) handleSupvervisorCommands(cmd Message) {
 	case INDEX_STATS_DONE:
 		c.handleStats(cmd)
 
+	case TK_INGEST_LAG:
+		c.handleIngestLag(cmd)
+
 	case CONFIG_SETTINGS_UPDATE:
 		c.handleConfigUpdate(cmd)
 
 	case CLUST_MGR_CLEANUP_PARTITION:
 		c.handleCleanupPartition(cmd)
 
+	case CLUST_MGR_UPDATE_SLICE_LAYOUT:
+		c.handleUpdateSliceLayout(cmd)
+
 	case CLUST_MGR_MERGE_PARTITION:
 		c.handleMergePartition(cmd)
 
@@ -248,6 +254,25 @@ func (c *clustMgrAgent) handleCleanupPartition(cmd Message) {
 	c.supvCmdch <- &MsgSuccess{}
 }
 
+func (c *clustMgrAgent) handleUpdateSliceLayout(cmd Message) {
+
+	logging.Infof("ClustMgr:handleUpdateSliceLayout %v", cmd)
+
+	msg := cmd.(*MsgClustMgrUpdateSliceLayout)
+	defn := msg.GetDefn()
+
+	if err := c.mgr.UpdateSliceLayout(defn.Bucket, defn.DefnId, msg.GetInstId(), msg.GetPartitionId(),
+		uint64(msg.GetSliceId()), msg.GetVbuckets(), msg.GetHostPath()); err != nil {
+		c.supvCmdch <- &MsgError{
+			err: Error{severity: NORMAL,
+				cause:    err,
+				category: CLUSTER_MGR}}
+		return
+	}
+
+	c.supvCmdch <- &MsgSuccess{}
+}
+
 func (c *clustMgrAgent) handleMergePartition(cmd Message) {
 
 	logging.Infof("ClustMgr:handleMergePartition%v", cmd)
@@ -309,6 +334,23 @@ func (c *clustMgrAgent) handleStatsInternal() {
 	}
 }
 
+func (c *clustMgrAgent) handleIngestLag(cmd Message) {
+
+	c.supvCmdch <- &MsgSuccess{}
+
+	msg := cmd.(*MsgTKIngestLag)
+	inst := msg.GetIndexInst()
+	c.mgr.NotifyIngestLag(manager.IngestLagEvent{
+		DefnId:  inst.Defn.DefnId,
+		InstId:  inst.InstId,
+		Bucket:  inst.Defn.Bucket,
+		Name:    inst.Defn.Name,
+		Lag:     msg.GetLag(),
+		Since:   msg.GetSince(),
+		Cleared: msg.IsCleared(),
+	})
+}
+
 func (c *clustMgrAgent) handleConfigUpdate(cmd Message) {
 
 	logging.Infof("ClustMgr:handleConfigUpdate")
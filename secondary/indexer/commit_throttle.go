@@ -0,0 +1,187 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  write throttling
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// The latency of storage commits, which fsync the index files, is kept
+// as a moving average by the storage manager. When the average is over
+// the high threshold, the disk cannot keep up with the mutations, and
+// enqueue of mutations of all streams is throttled till the average is
+// back under the low threshold. A throttled enqueue blocks the stream
+// reader, so that backpressure propagates upstream to the projector,
+// instead of the mutation queues growing unbounded. A minimum queue
+// length is always allowed, so that flushes and commits go on.
+//
+
+// weight of a new sample in the moving average of commit latency.
+const commitLatencyWeight = 0.2
+
+// the average is stale after this many snapshot intervals without a
+// commit.
+const commitStaleIntervals = 3
+
+type commitThrottle struct {
+	avgLatency   int64 // moving average of commit latency, nanoseconds
+	lastCommit   int64 // unix nanoseconds of last commit
+	highLatency  int64 // nanoseconds, 0 disables throttling
+	lowLatency   int64 // nanoseconds
+	throttled    int32
+	numThrottled int64 // num of enqueues throttled
+	throttleTime int64 // total time throttled in nanoseconds
+
+	clock common.Clock // nil for system clock
+}
+
+var gCommitThrottle commitThrottle
+
+// record a commit latency sample.
+func (t *commitThrottle) record(latency time.Duration) {
+	for {
+		old := atomic.LoadInt64(&t.avgLatency)
+		avg := int64(latency)
+		if old != 0 {
+			avg = int64(commitLatencyWeight*float64(latency) + (1-commitLatencyWeight)*float64(old))
+		}
+		if atomic.CompareAndSwapInt64(&t.avgLatency, old, avg) {
+			break
+		}
+	}
+	atomic.StoreInt64(&t.lastCommit, t.now().UnixNano())
+	t.evaluate()
+}
+
+// update the thresholds, in milliseconds. A low threshold over high is
+// set to high. Commits are expected every snapInterval, the persisted
+// snapshot interval.
+func (t *commitThrottle) update(highMs, lowMs int, snapInterval time.Duration) {
+	high := int64(highMs) * int64(time.Millisecond)
+	low := int64(lowMs) * int64(time.Millisecond)
+	if low > high {
+		low = high
+	}
+	atomic.StoreInt64(&t.highLatency, high)
+	atomic.StoreInt64(&t.lowLatency, low)
+
+	// without commits, the average is not refreshed. Forget it once no
+	// commit was seen for a few snapshot intervals, so that a slow commit
+	// long ago does not keep streams throttled. A commit takes as long as
+	// the average, so that is waited for as well.
+	stale := commitStaleIntervals * (snapInterval + time.Duration(high))
+	last := atomic.LoadInt64(&t.lastCommit)
+	if high > 0 && last != 0 && t.now().Sub(time.Unix(0, last)) > stale {
+		atomic.StoreInt64(&t.avgLatency, 0)
+	}
+	t.evaluate()
+}
+
+func (t *commitThrottle) evaluate() {
+	high := atomic.LoadInt64(&t.highLatency)
+	avg := atomic.LoadInt64(&t.avgLatency)
+
+	throttled := t.isThrottled()
+	if high <= 0 {
+		throttled = false
+	} else if avg >= high {
+		throttled = true
+	} else if avg < atomic.LoadInt64(&t.lowLatency) {
+		throttled = false
+	}
+
+	var v int32
+	if throttled {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&t.throttled, v); old != v {
+		logging.Infof("CommitThrottle::evaluate throttled %v. AvgCommitLatency %v High %v Low %v",
+			throttled, time.Duration(avg), time.Duration(high),
+			time.Duration(atomic.LoadInt64(&t.lowLatency)))
+	}
+}
+
+func (t *commitThrottle) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
+func (t *commitThrottle) isThrottled() bool {
+	return atomic.LoadInt32(&t.throttled) == 1
+}
+
+func (t *commitThrottle) getAvgLatency() int64 {
+	return atomic.LoadInt64(&t.avgLatency)
+}
+
+// throttleCommitLatency waits while storage commits are too slow. It
+// returns false if the queue is stopped.
+func (q *atomicMutationQueue) throttleCommitLatency(vbucket Vbucket, appch StopChannel) bool {
+
+	if !gCommitThrottle.isThrottled() {
+		return true
+	}
+
+	start := time.Now()
+	atomic.AddInt64(&gCommitThrottle.numThrottled, 1)
+	defer func() {
+		atomic.AddInt64(&gCommitThrottle.throttleTime, int64(time.Since(start)))
+	}()
+
+	ticker := time.NewTicker(time.Millisecond * time.Duration(q.allocPollInterval))
+	defer ticker.Stop()
+
+	for {
+		//a minimum queue length is always allowed so that flusher
+		//can make progress
+		if atomic.LoadInt64(&q.size[vbucket]) < int64(q.minQueueLen) {
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+			if !gCommitThrottle.isThrottled() {
+				return true
+			}
+
+		case <-q.stopch[vbucket]:
+			return false
+
+		case <-appch:
+			return true
+		}
+	}
+}
+
+// updateCommitThrottle refreshes the commit latency thresholds.
+func (idx *indexer) updateCommitThrottle() {
+
+	interval := idx.config["settings.persisted_snapshot.moi.interval"].Uint64()
+	if common.GetStorageMode() == common.FORESTDB {
+		interval = idx.config["settings.persisted_snapshot.fdb.interval"].Uint64()
+	}
+	gCommitThrottle.update(
+		idx.config["settings.throttle.commit_latency_high"].Int(),
+		idx.config["settings.throttle.commit_latency_low"].Int(),
+		time.Duration(interval)*time.Millisecond)
+}
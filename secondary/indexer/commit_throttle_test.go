@@ -0,0 +1,72 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCommitThrottle(t *testing.T) {
+	var ct commitThrottle
+
+	ct.record(500 * time.Millisecond)
+	if ct.isThrottled() {
+		t.Fatalf("unexpected throttle with throttling disabled")
+	}
+
+	ct.update(100, 50, 5*time.Second)
+	if !ct.isThrottled() {
+		t.Fatalf("expected throttle over high latency")
+	}
+
+	// average decays under high, but stays over low
+	for i := 0; i < 8; i++ {
+		ct.record(10 * time.Millisecond)
+	}
+	if avg := time.Duration(ct.getAvgLatency()); avg >= 100*time.Millisecond || avg < 50*time.Millisecond {
+		t.Fatalf("unexpected average latency %v", avg)
+	}
+	if !ct.isThrottled() {
+		t.Fatalf("expected throttle till latency is under low")
+	}
+
+	for i := 0; i < 10; i++ {
+		ct.record(10 * time.Millisecond)
+	}
+	if ct.isThrottled() {
+		t.Fatalf("unexpected throttle under low latency, average %v",
+			time.Duration(ct.getAvgLatency()))
+	}
+}
+
+func TestCommitThrottleStale(t *testing.T) {
+	clock := common.NewFakeClock(time.Now())
+	ct := commitThrottle{clock: clock}
+	snapInterval := 10 * time.Minute
+
+	ct.record(500 * time.Millisecond)
+	ct.update(100, 50, snapInterval)
+	if !ct.isThrottled() {
+		t.Fatalf("expected throttle over high latency")
+	}
+
+	// commits are widely spaced with a long snapshot interval, the
+	// average is kept between them.
+	for i := 0; i < 3; i++ {
+		clock.Advance(snapInterval)
+		ct.update(100, 50, snapInterval)
+		if ct.getAvgLatency() == 0 || !ct.isThrottled() {
+			t.Fatalf("unexpected stale average after %v", time.Duration(i+1)*snapInterval)
+		}
+		ct.record(500 * time.Millisecond)
+	}
+
+	// without commits for a few snapshot intervals, the average is stale.
+	clock.Advance(commitStaleIntervals*snapInterval + time.Second)
+	ct.update(100, 50, snapInterval)
+	if ct.getAvgLatency() != 0 || ct.isThrottled() {
+		t.Fatalf("expected stale average to be forgotten, average %v",
+			time.Duration(ct.getAvgLatency()))
+	}
+}
@@ -12,6 +12,7 @@ package indexer
 import (
 	"fmt"
 	"math"
+	"net/http"
 	"sort"
 	"strings"
 	"sync"
@@ -34,12 +35,13 @@ type compactionManager struct {
 	config    common.Config
 	supvMsgCh MsgChannel
 	supvCmdCh MsgChannel
+	clock     common.Clock
+	daemon    *compactionDaemon
 }
 
 type compactionDaemon struct {
 	quitch       chan bool
 	started      bool
-	timer        *time.Timer
 	msgch        MsgChannel
 	config       common.ConfigHolder
 	stats        IndexerStatsHolder
@@ -48,7 +50,11 @@ type compactionDaemon struct {
 	history      map[string]*indexCompaction
 	clusterAddr  string
 	lastCheckDay int32
+	clock        common.Clock
 	mutex        sync.Mutex
+
+	// compactions requested through admin api
+	tasks map[string]*compactionTask
 }
 
 type indexCompaction struct {
@@ -64,9 +70,6 @@ type indexCompaction struct {
 
 func (cd *compactionDaemon) Start() {
 	if !cd.started {
-		conf := cd.config.Load()
-		dur := time.Second * time.Duration(conf["check_period"].Int())
-		cd.timer = time.NewTimer(dur)
 		cd.started = true
 		go cd.loop()
 	}
@@ -74,7 +77,6 @@ func (cd *compactionDaemon) Start() {
 
 func (cd *compactionDaemon) Stop() {
 	if cd.started {
-		cd.timer.Stop()
 		cd.quitch <- true
 		<-cd.quitch
 	}
@@ -112,26 +114,26 @@ func (cd *compactionDaemon) ResetConfig(c common.Config) {
 func (cd *compactionDaemon) loop() {
 	hasStartedToday := false
 
+	checkPeriod := func() time.Duration {
+		conf := cd.config.Load()
+		return time.Second * time.Duration(conf["check_period"].Int())
+	}
+	timerch := cd.clock.After(checkPeriod())
+
 loop:
 	for {
 		select {
-		case _, ok := <-cd.timer.C:
+		case <-timerch:
 
 			if stats := cd.stats.Get(); stats != nil && stats.indexerState.Value() != int64(common.INDEXER_BOOTSTRAP) {
 				if common.GetStorageMode() == common.FORESTDB {
-					if ok {
-						hasStartedToday = cd.compactFDB(hasStartedToday)
-					}
+					hasStartedToday = cd.compactFDB(hasStartedToday)
 				} else if common.GetStorageMode() == common.PLASMA {
-					if ok {
-						cd.compactPlasma()
-					}
+					cd.compactPlasma()
 				}
 			}
 
-			conf := cd.config.Load()
-			dur := time.Second * time.Duration(conf["check_period"].Int())
-			cd.timer.Reset(dur)
+			timerch = cd.clock.After(checkPeriod())
 
 		case <-cd.quitch:
 			cd.quitch <- true
@@ -216,12 +218,12 @@ func (cd *compactionDaemon) needsCompaction(is IndexStorageStats, config common.
 				abort := config["abort_exceed_interval"].Bool()
 
 				// if past 24 hours, then stop this run.
-				if abort && time.Now().After(abortTime) {
+				if abort && cd.clock.Now().After(abortTime) {
 					return false
 				}
 
 				if abort && end_min != 0 {
-					now_hr, now_min, _ := time.Now().Clock()
+					now_hr, now_min, _ := cd.clock.Now().Clock()
 					now_min += now_hr * 60
 
 					// At this point, we know we have past start time.
@@ -283,8 +285,8 @@ func (cd *compactionDaemon) compactFDB(hasStartedToday bool) bool {
 	stats = <-replych
 
 	// each compaction interval cannot go over 24 hours if specified.
-	abortTime := time.Now().Add(time.Duration(24) * time.Hour)
-	checkTime := time.Now()
+	abortTime := cd.clock.Now().Add(time.Duration(24) * time.Hour)
+	checkTime := cd.clock.Now()
 
 	mode := strings.ToLower(conf["compaction_mode"].String())
 	if mode == "circular" {
@@ -521,12 +523,12 @@ func (cd *compactionDaemon) addOptional() []*MsgIndexCompact {
 	return compactMsgs
 }
 
-func (cd *compactionDaemon) runCompaction(compactReq *MsgIndexCompact) {
+func (cd *compactionDaemon) runCompaction(compactReq *MsgIndexCompact) error {
 
 	logging.Infof("CompactionDaemon: run compaction for inst %v partition %v.",
 		compactReq.GetInstId(), compactReq.GetPartitionId())
 
-	cd.updateCompactionStartTime(compactReq.GetInstId(), compactReq.GetPartitionId(), time.Now().UnixNano())
+	cd.updateCompactionStartTime(compactReq.GetInstId(), compactReq.GetPartitionId(), cd.clock.Now().UnixNano())
 
 	cd.msgch <- compactReq
 	err := <-compactReq.GetErrorChannel()
@@ -541,7 +543,8 @@ func (cd *compactionDaemon) runCompaction(compactReq *MsgIndexCompact) {
 			compactReq.GetInstId(), compactReq.GetPartitionId())
 	}
 
-	cd.updateCompactionEndTime(compactReq.GetInstId(), compactReq.GetPartitionId(), time.Now().UnixNano())
+	cd.updateCompactionEndTime(compactReq.GetInstId(), compactReq.GetPartitionId(), cd.clock.Now().UnixNano())
+	return err
 }
 
 func (cd *compactionDaemon) updateIndexInstMap(indexInstMap common.IndexInstMap) {
@@ -712,18 +715,27 @@ func computeGarbage(stats *IndexStats) int64 {
 
 func NewCompactionManager(supvCmdCh MsgChannel, supvMsgCh MsgChannel,
 	config common.Config) (CompactionManager, Message) {
+
+	return newCompactionManager(supvCmdCh, supvMsgCh, config, common.SystemClock{})
+}
+
+func newCompactionManager(supvCmdCh MsgChannel, supvMsgCh MsgChannel,
+	config common.Config, clock common.Clock) (CompactionManager, Message) {
 	cm := &compactionManager{
 		config:    config,
 		supvCmdCh: supvCmdCh,
 		supvMsgCh: supvMsgCh,
 		logPrefix: "CompactionManager",
+		clock:     clock,
 	}
+	cm.daemon = cm.newCompactionDaemon()
+	http.HandleFunc("/compactIndex", cm.handleCompactIndexReq)
 	go cm.run()
 	return cm, &MsgSuccess{}
 }
 
 func (cm *compactionManager) run() {
-	cd := cm.newCompactionDaemon()
+	cd := cm.daemon
 	cd.Start()
 loop:
 	for {
@@ -769,8 +781,10 @@ func (cm *compactionManager) newCompactionDaemon() *compactionDaemon {
 		msgch:        cm.supvMsgCh,
 		clusterAddr:  clusterAddr,
 		lastCheckDay: -1,
+		clock:        cm.clock,
 		compactions:  make(map[string]*indexCompaction),
 		history:      make(map[string]*indexCompaction),
+		tasks:        make(map[string]*compactionTask),
 	}
 	cd.config.Store(cfg)
 
@@ -0,0 +1,98 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCompactionDaemonInterval(t *testing.T) {
+	saved := common.GetStorageMode()
+	common.SetStorageMode(common.FORESTDB)
+	defer common.SetStorageMode(saved)
+
+	// Monday, 1 AM
+	clock := common.NewFakeClock(time.Date(2018, 1, 1, 1, 0, 0, 0, time.Local))
+
+	stats := NewIndexerStats()
+	stats.indexerState.Set(int64(common.INDEXER_ACTIVE))
+
+	config := common.SystemConfig.SectionConfig("indexer.settings.compaction.", true)
+	config.SetValue("interval", "02:00,04:00")
+	config.SetValue("abort_exceed_interval", true)
+	config.SetValue("days_of_week", "Monday")
+	checkPeriod := time.Duration(config["check_period"].Int()) * time.Second
+
+	msgch := make(MsgChannel)
+	cd := &compactionDaemon{
+		quitch:       make(chan bool),
+		msgch:        msgch,
+		lastCheckDay: -1,
+		clock:        clock,
+		compactions:  make(map[string]*indexCompaction),
+		history:      make(map[string]*indexCompaction),
+		tasks:        make(map[string]*compactionTask),
+	}
+	cd.config.Store(config)
+	cd.stats.Set(stats)
+	cd.Start()
+	defer cd.Stop()
+
+	// check runs only when the check period has elapsed.
+	waitForClockWaiters(t, clock, 1)
+	clock.Advance(checkPeriod - time.Second)
+	select {
+	case msg := <-msgch:
+		t.Fatalf("unexpected message %v before check period", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	storageStats := []IndexStorageStats{{InstId: 1, PartnId: 0}}
+	replyStats := func() {
+		clock.Advance(time.Second)
+		msg := <-msgch
+		msg.(*MsgIndexStorageStats).respch <- storageStats
+	}
+
+	// outside of the compaction interval.
+	replyStats()
+	waitForClockWaiters(t, clock, 1)
+
+	// within the compaction interval.
+	clock.Advance(90*time.Minute - time.Second)
+	replyStats()
+	compact, ok := (<-msgch).(*MsgIndexCompact)
+	if !ok || compact.instId != 1 {
+		t.Fatalf("expected compaction of instance 1, got %v", compact)
+	}
+	if expected := clock.Now().Add(24 * time.Hour); !compact.abortTime.Equal(expected) {
+		t.Errorf("expected abort time %v, got %v", expected, compact.abortTime)
+	}
+	compact.errch <- nil
+	waitForClockWaiters(t, clock, 1)
+
+	// circular compaction runs once a day.
+	clock.Advance(checkPeriod - time.Second)
+	replyStats()
+	waitForClockWaiters(t, clock, 1)
+}
+
+func waitForClockWaiters(t *testing.T, clock *common.FakeClock, n int) {
+	for i := 0; i < 100; i++ {
+		if clock.NumWaiters() == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %v clock waiters, got %v", n, clock.NumWaiters())
+}
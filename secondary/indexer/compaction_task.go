@@ -0,0 +1,419 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+var ErrCompactionTaskNotFound = errors.New("Compaction task not found")
+var ErrCompactionCancelled = errors.New("Compaction cancelled")
+
+const (
+	compactionTaskRunning   = "running"
+	compactionTaskDone      = "done"
+	compactionTaskCancelled = "cancelled"
+	compactionTaskFailed    = "failed"
+)
+
+// number of finished compaction tasks remembered for status requests.
+const maxFinishedCompactionTasks = 64
+
+// IndexCompactionTask is the status of a compaction requested for an
+// index through the admin api, outside of the compaction schedule.
+type IndexCompactionTask struct {
+	TaskId        string             `json:"taskId"`
+	DefnId        common.IndexDefnId `json:"defnId"`
+	Bucket        string             `json:"bucket"`
+	Index         string             `json:"index"`
+	State         string             `json:"state"`
+	NumPartitions int                `json:"numPartitions"`
+	NumCompacted  int                `json:"numCompacted"`
+	TargetDir     string             `json:"targetDir,omitempty"`
+	Progress      float64            `json:"progress"`
+	StartTime     time.Time          `json:"startTime"`
+	EndTime       time.Time          `json:"endTime"`
+	Error         string             `json:"error,omitempty"`
+}
+
+type compactionPartn struct {
+	instId      common.IndexInstId
+	partitionId common.PartitionId
+}
+
+// compactionTask compacts the partitions of an index one after the
+// other. Status is guarded by compactionDaemon's mutex.
+type compactionTask struct {
+	status    IndexCompactionTask
+	partns    []compactionPartn
+	cancelch  chan bool
+	cancelled bool
+	handle    *common.Task
+
+	// progress is read by the task manager, hence guarded by its own
+	// lock. Progress within the partition being compacted is estimated
+	// from the duration of its last compaction.
+	progressMu    sync.Mutex
+	numCompacted  int
+	partnStart    time.Time     // zero if no partition is being compacted
+	partnExpected time.Duration // zero if not known
+}
+
+// maxPartnProgress caps the estimated progress within a partition, so
+// that a partition is not reported as compacted before it is done.
+const maxPartnProgress = 0.99
+
+// progress of the task as of now, as percent complete.
+func (task *compactionTask) progress(now time.Time) float64 {
+	task.progressMu.Lock()
+	defer task.progressMu.Unlock()
+
+	compacted := float64(task.numCompacted)
+	if !task.partnStart.IsZero() && task.partnExpected > 0 {
+		partn := float64(now.Sub(task.partnStart)) / float64(task.partnExpected)
+		compacted += math.Max(0, math.Min(partn, maxPartnProgress))
+	}
+	return compacted * 100 / float64(len(task.partns))
+}
+
+// startPartn marks the partition as being compacted, expected to take
+// as long as its last compaction.
+func (task *compactionTask) startPartn(now time.Time, expected time.Duration) {
+	task.progressMu.Lock()
+	defer task.progressMu.Unlock()
+	task.partnStart, task.partnExpected = now, expected
+}
+
+// endPartn marks the partition being compacted as done.
+func (task *compactionTask) endPartn(compacted bool) {
+	task.progressMu.Lock()
+	defer task.progressMu.Unlock()
+	task.partnStart, task.partnExpected = time.Time{}, 0
+	if compacted {
+		task.numCompacted++
+	}
+}
+
+// statusNoLock returns the status of the task with its progress as of
+// now, must be called with compactionDaemon's mutex held.
+func (task *compactionTask) statusNoLock(now time.Time) IndexCompactionTask {
+	status := task.status
+	status.Progress = task.progress(now)
+	return status
+}
+
+// CompactIndex starts compaction of all partitions of the index hosted
+// by this node, irrespective of their fragmentation. If the index is
+// being compacted by an earlier request, that task is returned.
+func (cd *compactionDaemon) CompactIndex(defnId common.IndexDefnId) (IndexCompactionTask, error) {
+	return cd.startCompactionTask(defnId, "")
+}
+
+// RelocateIndex starts relocation of all partitions of the index hosted
+// by this node to data directory targetDir. Partitions are relocated
+// online, by compacting them into targetDir.
+func (cd *compactionDaemon) RelocateIndex(defnId common.IndexDefnId, targetDir string) (IndexCompactionTask, error) {
+	return cd.startCompactionTask(defnId, targetDir)
+}
+
+func (cd *compactionDaemon) startCompactionTask(defnId common.IndexDefnId,
+	targetDir string) (IndexCompactionTask, error) {
+
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+
+	for _, task := range cd.tasks {
+		if task.status.DefnId == defnId && task.status.State == compactionTaskRunning {
+			return task.statusNoLock(cd.clock.Now()), nil
+		}
+	}
+
+	task := &compactionTask{cancelch: make(chan bool)}
+	for _, inst := range cd.indexInstMap {
+		if inst.Defn.DefnId != defnId || inst.State != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+		task.status.Bucket, task.status.Index = inst.Defn.Bucket, inst.Defn.Name
+		for _, partn := range inst.Pc.GetAllPartitions() {
+			task.partns = append(task.partns, compactionPartn{inst.InstId, partn.GetPartitionId()})
+		}
+	}
+	if len(task.partns) == 0 {
+		return IndexCompactionTask{}, common.ErrIndexNotFound
+	}
+
+	desc := fmt.Sprintf("Compact index %v:%v", task.status.Bucket, task.status.Index)
+	if targetDir != "" {
+		desc = fmt.Sprintf("Relocate index %v:%v to %v", task.status.Bucket, task.status.Index, targetDir)
+	}
+	task.handle = common.NewTask(common.TaskCompaction, desc, func() error {
+		_, err := cd.CancelCompaction(task.status.TaskId)
+		return err
+	})
+	task.handle.SetProgressFunc(func() float64 {
+		return task.progress(cd.clock.Now())
+	})
+
+	now := cd.clock.Now()
+	task.status.TaskId = task.handle.Id()
+	task.status.DefnId = defnId
+	task.status.State = compactionTaskRunning
+	task.status.NumPartitions = len(task.partns)
+	task.status.TargetDir = targetDir
+	task.status.StartTime = now
+
+	cd.pruneCompactionTasksNoLock()
+	cd.tasks[task.status.TaskId] = task
+
+	logging.Infof("CompactionDaemon: task %v started compaction of index %v partitions %v.",
+		task.status.TaskId, defnId, len(task.partns))
+
+	go cd.runCompactionTask(task)
+	return task.statusNoLock(now), nil
+}
+
+// CancelCompaction cancels a running compaction task. Partitions are
+// compacted one after the other, the partition being compacted is
+// completed and the remaining partitions are skipped.
+func (cd *compactionDaemon) CancelCompaction(taskId string) (IndexCompactionTask, error) {
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+
+	task, ok := cd.tasks[taskId]
+	if !ok {
+		return IndexCompactionTask{}, ErrCompactionTaskNotFound
+	}
+	if task.status.State == compactionTaskRunning && !task.cancelled {
+		task.cancelled = true
+		close(task.cancelch)
+	}
+	return task.statusNoLock(cd.clock.Now()), nil
+}
+
+// CompactionTasks returns the status of compaction tasks, of all tasks
+// if taskId is empty.
+func (cd *compactionDaemon) CompactionTasks(taskId string) ([]IndexCompactionTask, error) {
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+
+	now := cd.clock.Now()
+	if len(taskId) != 0 {
+		task, ok := cd.tasks[taskId]
+		if !ok {
+			return nil, ErrCompactionTaskNotFound
+		}
+		return []IndexCompactionTask{task.statusNoLock(now)}, nil
+	}
+
+	tasks := make([]IndexCompactionTask, 0, len(cd.tasks))
+	for _, task := range cd.tasks {
+		tasks = append(tasks, task.statusNoLock(now))
+	}
+	return tasks, nil
+}
+
+func (cd *compactionDaemon) runCompactionTask(task *compactionTask) {
+
+	var err error
+	for _, partn := range task.partns {
+		err = cd.compactTaskPartition(task, partn)
+		task.endPartn(err == nil)
+		if err != nil {
+			break
+		}
+
+		cd.mutex.Lock()
+		task.status.NumCompacted++
+		cd.mutex.Unlock()
+	}
+
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+
+	switch err {
+	case nil:
+		task.status.State = compactionTaskDone
+	case ErrCompactionCancelled:
+		task.status.State = compactionTaskCancelled
+	default:
+		task.status.State = compactionTaskFailed
+		task.status.Error = err.Error()
+	}
+	task.status.EndTime = cd.clock.Now()
+	task.status.Progress = task.progress(task.status.EndTime)
+	task.handle.Done(err)
+
+	logging.Infof("CompactionDaemon: task %v compaction of index %v %v, compacted %v of %v partitions.",
+		task.status.TaskId, task.status.DefnId, task.status.State,
+		task.status.NumCompacted, task.status.NumPartitions)
+}
+
+// compactTaskPartition compacts a partition on behalf of the task. If
+// the partition is being compacted by the scheduler, wait for it to
+// complete before compacting again.
+func (cd *compactionDaemon) compactTaskPartition(task *compactionTask, partn compactionPartn) error {
+
+	for {
+		select {
+		case <-task.cancelch:
+			return ErrCompactionCancelled
+		default:
+		}
+
+		cd.mutex.Lock()
+		ok := cd.addIndexCompactionNoLock(partn.instId, partn.partitionId, nil)
+		expected := cd.lastCompactionDurationNoLock(partn)
+		cd.mutex.Unlock()
+		if ok {
+			task.startPartn(cd.clock.Now(), expected)
+			break
+		}
+
+		select {
+		case <-task.cancelch:
+			return ErrCompactionCancelled
+		case <-cd.clock.After(time.Second):
+		}
+	}
+
+	compactReq := newMsgIndexCompact(partn.instId, partn.partitionId, 0)
+	compactReq.abortTime = cd.clock.Now().Add(time.Duration(24) * time.Hour)
+	compactReq.targetDir = task.status.TargetDir
+	if err := cd.runCompaction(compactReq); err != nil {
+		return err
+	}
+
+	// record the new location of the relocated partition
+	if task.status.TargetDir != "" {
+		cd.msgch <- &MsgClustMgrUpdateSliceLayout{instId: partn.instId, partnId: partn.partitionId}
+	}
+	return nil
+}
+
+// lastCompactionDurationNoLock returns the duration of the last
+// completed compaction of the partition, zero if not known.
+func (cd *compactionDaemon) lastCompactionDurationNoLock(partn compactionPartn) time.Duration {
+	hist, ok := cd.history[indexCompactionName(partn.instId, partn.partitionId)]
+	if !ok || hist.endTime == math.MaxInt64 || hist.endTime <= hist.startTime {
+		return 0
+	}
+	return time.Duration(hist.endTime - hist.startTime)
+}
+
+// pruneCompactionTasksNoLock forgets the oldest finished tasks beyond
+// maxFinishedCompactionTasks.
+func (cd *compactionDaemon) pruneCompactionTasksNoLock() {
+
+	for {
+		var oldest *compactionTask
+		numFinished := 0
+		for _, task := range cd.tasks {
+			if task.status.State == compactionTaskRunning {
+				continue
+			}
+			numFinished++
+			if oldest == nil || task.status.EndTime.Before(oldest.status.EndTime) {
+				oldest = task
+			}
+		}
+		if numFinished < maxFinishedCompactionTasks {
+			return
+		}
+		delete(cd.tasks, oldest.status.TaskId)
+	}
+}
+
+// handleCompactIndexReq returns the status of compaction tasks on GET,
+// starts compaction of the index given by defnId on POST, or relocation
+// of the index if a data directory is given by path, and cancels the
+// compaction task given by taskId on DELETE.
+func (cm *compactionManager) handleCompactIndexReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	var data interface{}
+	taskId := r.FormValue("taskId")
+
+	switch r.Method {
+	case "GET":
+		if !common.IsAllowed(creds, []string{"cluster.settings!read"}, w) {
+			return
+		}
+		data, err = cm.daemon.CompactionTasks(taskId)
+
+	case "POST":
+		if !common.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+
+		str := r.FormValue("defnId")
+		defnId, err1 := strconv.ParseUint(str, 10, 64)
+		if err1 != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("Invalid defnId " + str + "\n"))
+			return
+		}
+		if path := r.FormValue("path"); path != "" {
+			data, err = cm.daemon.RelocateIndex(common.IndexDefnId(defnId), path)
+		} else {
+			data, err = cm.daemon.CompactIndex(common.IndexDefnId(defnId))
+		}
+
+	case "DELETE":
+		if !common.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+		// cancel through the task manager, so that the task is
+		// reported as cancelled in the task list as well.
+		if _, err = common.CancelTask(taskId); err == nil {
+			data, err = cm.daemon.CompactionTasks(taskId)
+		}
+
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("%v\n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(buf)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestCompactionTaskDaemon(clock common.Clock) (*compactionDaemon, MsgChannel) {
+	pc := common.NewKeyPartitionContainer(1024, 2, common.KEY, common.CRC32)
+	for _, partnId := range []common.PartitionId{1, 2} {
+		pc.AddPartition(partnId, common.KeyPartitionDefn{Id: partnId})
+	}
+	inst := common.IndexInst{
+		InstId: 100,
+		Defn:   common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx"},
+		State:  common.INDEX_STATE_ACTIVE,
+		Pc:     pc,
+	}
+
+	msgch := make(MsgChannel)
+	cd := &compactionDaemon{
+		msgch:       msgch,
+		clock:       clock,
+		compactions: make(map[string]*indexCompaction),
+		history:     make(map[string]*indexCompaction),
+		tasks:       make(map[string]*compactionTask),
+	}
+	cd.updateIndexInstMap(common.IndexInstMap{100: inst})
+	return cd, msgch
+}
+
+func compactionTaskStatus(t *testing.T, cd *compactionDaemon, taskId string) IndexCompactionTask {
+	tasks, err := cd.CompactionTasks(taskId)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("unexpected tasks %v %v", tasks, err)
+	}
+	return tasks[0]
+}
+
+func waitForCompactionTask(t *testing.T, cd *compactionDaemon, taskId, state string) IndexCompactionTask {
+	for i := 0; i < 100; i++ {
+		if status := compactionTaskStatus(t, cd, taskId); status.State == state {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for task %v to be %v", taskId, state)
+	return IndexCompactionTask{}
+}
+
+func TestCompactionTaskProgress(t *testing.T) {
+	clock := common.NewFakeClock(time.Now())
+	cd, msgch := newTestCompactionTaskDaemon(clock)
+
+	// last compaction of partition 1 took 10 minutes, partition 2 has
+	// never been compacted.
+	name := indexCompactionName(100, 1)
+	cd.history[name].startTime = clock.Now().Add(-time.Hour).UnixNano()
+	cd.history[name].endTime = clock.Now().Add(-50 * time.Minute).UnixNano()
+
+	status, err := cd.CompactIndex(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.NumPartitions != 2 || status.Progress != 0 {
+		t.Fatalf("unexpected status %v", status)
+	}
+
+	compact := (<-msgch).(*MsgIndexCompact)
+	if compact.instId != 100 || compact.partnId != 1 {
+		t.Fatalf("unexpected compaction %v:%v", compact.instId, compact.partnId)
+	}
+	// progress within the partition.
+	clock.Advance(5 * time.Minute)
+	if status := compactionTaskStatus(t, cd, status.TaskId); status.Progress != 25 {
+		t.Errorf("expected progress 25, got %v", status.Progress)
+	}
+	// estimate never completes the partition.
+	clock.Advance(time.Hour)
+	if status := compactionTaskStatus(t, cd, status.TaskId); status.Progress >= 50 {
+		t.Errorf("expected progress below 50, got %v", status.Progress)
+	}
+	compact.errch <- nil
+
+	compact = (<-msgch).(*MsgIndexCompact)
+	if compact.partnId != 2 {
+		t.Fatalf("unexpected compaction of partition %v", compact.partnId)
+	}
+	clock.Advance(5 * time.Minute)
+	if status := compactionTaskStatus(t, cd, status.TaskId); status.Progress != 50 {
+		t.Errorf("expected progress 50, got %v", status.Progress)
+	}
+	compact.errch <- nil
+
+	status = waitForCompactionTask(t, cd, status.TaskId, compactionTaskDone)
+	if status.NumCompacted != 2 || status.Progress != 100 {
+		t.Errorf("unexpected status %v", status)
+	}
+}
+
+func TestCompactionTaskCancel(t *testing.T) {
+	clock := common.NewFakeClock(time.Now())
+	cd, msgch := newTestCompactionTaskDaemon(clock)
+
+	status, err := cd.CompactIndex(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a request for the index being compacted returns the same task.
+	if dup, err := cd.CompactIndex(10); err != nil || dup.TaskId != status.TaskId {
+		t.Errorf("expected task %v, got %v %v", status.TaskId, dup.TaskId, err)
+	}
+
+	compact := (<-msgch).(*MsgIndexCompact)
+	if _, err := cd.CancelCompaction(status.TaskId); err != nil {
+		t.Fatal(err)
+	}
+	compact.errch <- nil
+
+	status = waitForCompactionTask(t, cd, status.TaskId, compactionTaskCancelled)
+	if status.NumCompacted != 1 || status.Progress != 50 {
+		t.Errorf("unexpected status %v", status)
+	}
+
+	if _, err := cd.CompactIndex(20); err != common.ErrIndexNotFound {
+		t.Errorf("expected %v, got %v", common.ErrIndexNotFound, err)
+	}
+	if _, err := cd.CancelCompaction("unknown"); err != ErrCompactionTaskNotFound {
+		t.Errorf("expected %v, got %v", ErrCompactionTaskNotFound, err)
+	}
+}
+
+func TestCompactionTaskRelocate(t *testing.T) {
+	clock := common.NewFakeClock(time.Now())
+	cd, msgch := newTestCompactionTaskDaemon(clock)
+
+	status, err := cd.RelocateIndex(10, "/data2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, partnId := range []common.PartitionId{1, 2} {
+		compact := (<-msgch).(*MsgIndexCompact)
+		if compact.partnId != partnId || compact.targetDir != "/data2" {
+			t.Fatalf("unexpected relocation %v to %v", compact.partnId, compact.targetDir)
+		}
+		compact.errch <- nil
+
+		// the new location of the partition is recorded.
+		layout := (<-msgch).(*MsgClustMgrUpdateSliceLayout)
+		if layout.GetInstId() != 100 || layout.GetPartitionId() != partnId {
+			t.Errorf("unexpected slice layout update %v:%v", layout.GetInstId(), layout.GetPartitionId())
+		}
+	}
+
+	status = waitForCompactionTask(t, cd, status.TaskId, compactionTaskDone)
+	if status.NumCompacted != 2 {
+		t.Errorf("unexpected status %v", status)
+	}
+}
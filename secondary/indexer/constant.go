@@ -9,6 +9,8 @@
 
 package indexer
 
+import "github.com/couchbase/indexing/secondary/common"
+
 //Max number of vbuckets supported in the system
 const MAX_NUM_VBUCKETS = 1024
 
@@ -24,13 +26,13 @@ const WORKER_RECV_QUEUE_LEN = 10000
 const DEFAULT_CLUSTER_ENDPOINT = "127.0.0.1:9000"
 
 //Maintenance Topic Name
-const MAINT_TOPIC = "MAINT_STREAM_TOPIC"
+const MAINT_TOPIC = common.MAINT_TOPIC
 
 //Catchup Topic Name
-const CATCHUP_TOPIC = "CATCHUP_STREAM_TOPIC"
+const CATCHUP_TOPIC = common.CATCHUP_TOPIC
 
 //Initial Stream Topic Name
-const INIT_TOPIC = "INIT_STREAM_TOPIC"
+const INIT_TOPIC = common.INIT_TOPIC
 
 //Default Pool Name
 const DEFAULT_POOL = "default"
@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  cpu quota
+//
+/////////////////////////////////////////////////////////////////////////
+
+// cpuPool caps the number of workers of a subsystem running at a time,
+// so that the subsystem uses at most its share of the cores available
+// to the indexer. Workers hold a token of a channel sized to the limit,
+// a nil channel means unlimited. When the limit changes, workers holding
+// a token of the previous channel return it there, so the new limit can
+// be exceeded till they are done.
+type cpuPool struct {
+	tokens atomic.Value // chan struct{}
+}
+
+// gIngestCPU caps the index writers of all slices, gScanCPU caps the
+// scans admitted by the scan scheduler.
+var gIngestCPU = newCPUPool()
+var gScanCPU = newCPUPool()
+
+func newCPUPool() *cpuPool {
+	p := &cpuPool{}
+	p.tokens.Store((chan struct{})(nil))
+	return p
+}
+
+func (p *cpuPool) getTokens() chan struct{} {
+	return p.tokens.Load().(chan struct{})
+}
+
+func (p *cpuPool) getLimit() int {
+	return cap(p.getTokens())
+}
+
+func (p *cpuPool) setLimit(limit int) {
+	if limit == p.getLimit() {
+		return
+	}
+
+	var tokens chan struct{}
+	if limit > 0 {
+		tokens = make(chan struct{}, limit)
+	}
+	p.tokens.Store(tokens)
+}
+
+// acquire blocks till a worker can run. Returns nil, without blocking,
+// if the pool is unlimited. The result is to be passed to release once
+// the work is done.
+func (p *cpuPool) acquire() chan struct{} {
+	tokens := p.getTokens()
+	if tokens != nil {
+		tokens <- struct{}{}
+	}
+	return tokens
+}
+
+func (p *cpuPool) release(tokens chan struct{}) {
+	if tokens != nil {
+		<-tokens
+	}
+}
+
+// cpuShare returns the number of cores for percent of ncpu, rounded up,
+// or 0 if percent is 0.
+func cpuShare(ncpu int, percent int) int {
+	if percent <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(ncpu) * float64(percent) / 100))
+}
+
+// setCPUQuota derives the caps of the ingest and scan pools from their
+// share of the ncpu cores available to the indexer.
+func setCPUQuota(ncpu int, cfg common.Config) {
+	ingest := cpuShare(ncpu, cfg["indexer.settings.cpu_quota.ingest_percent"].Int())
+	scan := cpuShare(ncpu, cfg["indexer.settings.cpu_quota.scan_percent"].Int())
+
+	if ingest != gIngestCPU.getLimit() || scan != gScanCPU.getLimit() {
+		logging.Infof("Setting cpu quota ingest = %d scan = %d of %d cores", ingest, scan, ncpu)
+	}
+	gIngestCPU.setLimit(ingest)
+	gScanCPU.setLimit(scan)
+}
+
+// maxConcurrentScans returns the lower of the configured scan
+// concurrency and the scan cpu quota, 0 if both are unlimited.
+func maxConcurrentScans(maxConcurrent int) int {
+	limit := gScanCPU.getLimit()
+	if limit != 0 && (maxConcurrent == 0 || limit < maxConcurrent) {
+		return limit
+	}
+	return maxConcurrent
+}
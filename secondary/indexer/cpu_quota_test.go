@@ -0,0 +1,51 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPUPool(t *testing.T) {
+	p := newCPUPool()
+	if p.acquire() != nil {
+		t.Fatalf("unexpected acquire of unlimited pool")
+	}
+
+	p.setLimit(1)
+	first := p.acquire()
+
+	acquired := make(chan chan struct{})
+	go func() { acquired <- p.acquire() }()
+	select {
+	case <-acquired:
+		t.Fatalf("unexpected acquire over limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	p.release(first)
+	second := <-acquired
+	if second == nil {
+		t.Fatalf("expected acquire after release")
+	}
+
+	// a new limit applies to the workers acquiring after it is set.
+	p.setLimit(2)
+	third, fourth := p.acquire(), p.acquire()
+	if p.getLimit() != 2 || third == nil || fourth == nil {
+		t.Fatalf("expected acquire of 2 workers with limit 2")
+	}
+	p.release(second)
+	p.release(third)
+	p.release(fourth)
+	p.setLimit(0)
+	if p.acquire() != nil {
+		t.Fatalf("unexpected acquire of unlimited pool")
+	}
+
+	if n := cpuShare(8, 25); n != 2 {
+		t.Fatalf("expected 2 cores, got %v", n)
+	}
+	if n := cpuShare(2, 10); n != 1 {
+		t.Fatalf("expected 1 core, got %v", n)
+	}
+}
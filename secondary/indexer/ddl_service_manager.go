@@ -1264,6 +1264,12 @@ func (s *ddlSettings) StorageMode() string {
 	return s.storageMode
 }
 
+// DDL service manager only recovers indexes that are already sized,
+// so it does not need to sample the bucket.
+func (s *ddlSettings) PreviewSampleSize() int32 {
+	return 0
+}
+
 func (s *ddlSettings) handleSettings(config common.Config) {
 
 	numReplica := int32(config["settings.num_replica"].Int())
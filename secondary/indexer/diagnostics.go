@@ -0,0 +1,220 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  diagnostics bundle
+//
+/////////////////////////////////////////////////////////////////////////
+
+// StreamEvent is a stream message handled by the indexer, kept for the
+// diagnostics bundle.
+type StreamEvent struct {
+	Time    time.Time `json:"time"`
+	MsgType string    `json:"msgType"`
+	Msg     string    `json:"msg"`
+}
+
+// streamEventLog keeps the most recent stream events in a ring buffer.
+type streamEventLog struct {
+	mu     sync.Mutex
+	events []StreamEvent
+	next   int
+	full   bool
+}
+
+func newStreamEventLog(size int) *streamEventLog {
+	if size <= 0 {
+		size = 1
+	}
+	return &streamEventLog{events: make([]StreamEvent, size)}
+}
+
+func (l *streamEventLog) add(msg Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events[l.next] = StreamEvent{
+		Time:    time.Now(),
+		MsgType: msg.GetMsgType().String(),
+		Msg:     fmt.Sprintf("%v", msg),
+	}
+	l.next++
+	if l.next == len(l.events) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Events returns the events from oldest to newest.
+func (l *streamEventLog) Events() []StreamEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]StreamEvent(nil), l.events[:l.next]...)
+	}
+	result := make([]StreamEvent, 0, len(l.events))
+	result = append(result, l.events[l.next:]...)
+	return append(result, l.events[:l.next]...)
+}
+
+// isStreamEvent returns true for messages recorded in the stream event
+// log. Mutation and snapshot messages are too frequent to be useful.
+func isStreamEvent(mType MsgType) bool {
+	switch mType {
+	case STREAM_READER_STREAM_BEGIN,
+		STREAM_READER_STREAM_END,
+		STREAM_READER_STREAM_DROP_DATA,
+		STREAM_READER_CONN_ERROR,
+		STREAM_READER_ERROR,
+		STREAM_REQUEST_DONE,
+		KV_STREAM_REPAIR,
+		KV_SENDER_RESTART_VBUCKETS,
+		TK_MERGE_STREAM,
+		TK_VB_QUARANTINE,
+		INDEXER_PREPARE_RECOVERY,
+		INDEXER_INITIATE_RECOVERY,
+		INDEXER_RECOVERY_DONE,
+		MSG_ERROR:
+		return true
+	}
+	return false
+}
+
+// captureDiagnostics writes a diagnostics bundle for a fatal error to a
+// timestamped directory under settings.diagnostics.dir, or the storage
+// directory if it is not set. The bundle has the goroutine dump, a
+// stats snapshot, the slow scan log, the recent stream events and the
+// config. Only the most recent settings.diagnostics.max_bundles
+// bundles are kept. Returns the directory of the bundle, or "" if
+// disabled or the directory could not be created.
+func (idx *indexer) captureDiagnostics(cause error) string {
+
+	if !idx.config["settings.diagnostics.enable"].Bool() {
+		return ""
+	}
+
+	base := idx.config["settings.diagnostics.dir"].String()
+	if base == "" {
+		base = filepath.Join(idx.config["storage_dir"].String(), "diagnostics")
+	}
+	dir := filepath.Join(base, fmt.Sprintf("diag-%v", time.Now().UTC().Format("20060102T150405.000Z")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logging.Errorf("Indexer::captureDiagnostics Fail to create %v: %v", dir, err)
+		return ""
+	}
+
+	write := func(name string, data []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			logging.Errorf("Indexer::captureDiagnostics Fail to write %v: %v", name, err)
+		}
+	}
+	writeJSON := func(name string, v interface{}) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			logging.Errorf("Indexer::captureDiagnostics Fail to marshal %v: %v", name, err)
+			return
+		}
+		write(name, data)
+	}
+
+	write("error.txt", []byte(fmt.Sprintf("%v\n", cause)))
+
+	buf := new(bytes.Buffer)
+	pprof.Lookup("goroutine").WriteTo(buf, 2)
+	write("goroutines.txt", buf.Bytes())
+
+	if idx.stats != nil {
+		if data, err := idx.stats.MarshalJSON(true, true, false); err == nil {
+			write("stats.json", data)
+		} else {
+			logging.Errorf("Indexer::captureDiagnostics Fail to marshal stats: %v", err)
+		}
+	}
+
+	if scanCoord, ok := idx.scanCoord.(*scanCoordinator); ok && scanCoord.slowScans != nil {
+		writeJSON("slow_scans.json", scanCoord.slowScans.Entries())
+	}
+
+	if idx.streamEvents != nil {
+		writeJSON("stream_events.json", idx.streamEvents.Events())
+	}
+
+	write("config.json", idx.config.Json())
+
+	logging.Infof("Indexer::captureDiagnostics Diagnostics for %v written to %v", cause, dir)
+
+	pruneDiagnostics(base, idx.config["settings.diagnostics.max_bundles"].Int())
+	return dir
+}
+
+// pruneDiagnostics deletes the oldest diagnostics bundles under base,
+// keeping at most maxBundles of them. Bundle directories are named by
+// their UTC timestamp, so that they sort from oldest to newest.
+func pruneDiagnostics(base string, maxBundles int) {
+
+	if maxBundles <= 0 {
+		maxBundles = 1
+	}
+
+	bundles, err := filepath.Glob(filepath.Join(base, "diag-*"))
+	if err != nil {
+		logging.Errorf("Indexer::pruneDiagnostics Fail to list %v: %v", base, err)
+		return
+	}
+	if len(bundles) <= maxBundles {
+		return
+	}
+
+	sort.Strings(bundles)
+	for _, bundle := range bundles[:len(bundles)-maxBundles] {
+		if err := os.RemoveAll(bundle); err != nil {
+			logging.Errorf("Indexer::pruneDiagnostics Fail to remove %v: %v", bundle, err)
+		} else {
+			logging.Infof("Indexer::pruneDiagnostics Removed diagnostics %v", bundle)
+		}
+	}
+}
+
+// crashOnFatalError crashes the indexer on an error with a cause. For a
+// FATAL error, a diagnostics bundle is captured first and referenced in
+// the error.
+func (idx *indexer) crashOnFatalError(err Error) {
+
+	cause := err.cause
+	if cause == nil {
+		return
+	}
+
+	if err.severity == FATAL {
+		if dir := idx.captureDiagnostics(cause); dir != "" {
+			cause = fmt.Errorf("%v (diagnostics in %v)", cause, dir)
+		}
+	}
+	common.CrashOnError(cause)
+}
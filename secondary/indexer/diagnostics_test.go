@@ -0,0 +1,84 @@
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func streamEventMsgs(events []StreamEvent) []string {
+	msgs := make([]string, len(events))
+	for i, e := range events {
+		msgs[i] = e.Msg
+	}
+	return msgs
+}
+
+func TestStreamEventLogWrap(t *testing.T) {
+	l := newStreamEventLog(3)
+	if events := l.Events(); len(events) != 0 {
+		t.Fatalf("expected no events, got %v", events)
+	}
+
+	var msgs []string
+	for _, bucket := range []string{"b1", "b2", "b3", "b4", "b5"} {
+		msg := &MsgStreamInfo{mType: STREAM_READER_STREAM_BEGIN, streamId: common.MAINT_STREAM, bucket: bucket}
+		l.add(msg)
+		msgs = append(msgs, msg.String())
+
+		expected := msgs
+		if len(expected) > 3 {
+			expected = expected[len(expected)-3:]
+		}
+		events := l.Events()
+		if got := streamEventMsgs(events); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+		if events[len(events)-1].MsgType != MsgType(STREAM_READER_STREAM_BEGIN).String() {
+			t.Errorf("unexpected message type %v", events[len(events)-1].MsgType)
+		}
+	}
+}
+
+func TestPruneDiagnostics(t *testing.T) {
+	base, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	bundles := []string{
+		"diag-20260101T000000.000Z",
+		"diag-20260101T000001.000Z",
+		"diag-20260102T000000.000Z",
+		"diag-20260103T000000.000Z",
+	}
+	// created out of order, bundles are ordered by name.
+	for _, i := range []int{2, 0, 3, 1} {
+		if err := os.MkdirAll(filepath.Join(base, bundles[i]), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(base, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pruneDiagnostics(base, 2)
+
+	infos, err := ioutil.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	expected := []string{bundles[2], bundles[3], "other"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
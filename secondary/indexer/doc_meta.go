@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  document metadata of primary index
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// A primary index created with doc_meta keeps the cas, expiry and size
+// of every document, as of the mutation indexed. The projector sends the
+// metadata as the key of the primary index mutation, `[cas,expiry,size]`,
+// and the slice stores it, binary encoded, as the value of the entry.
+//
+// Scans projecting doc_meta return the metadata as the secondary key of
+// the row, `[cas,expiry,size]`, alongside the docid. Within the scan
+// pipeline, the metadata is appended to the primary index entry.
+//
+
+var (
+	ErrDocMetaNotAvailable = errors.New("Index does not keep document metadata")
+	ErrDocMetaDocIdOnly    = errors.New("Document metadata cannot be projected with packed docids")
+)
+
+// length of the binary encoded metadata, cas(8) expiry(4) size(4)
+const docMetaLen = 16
+
+// docMetaRanger is implemented by snapshots of storage keeping document
+// metadata. Entries are passed to the callback with the metadata
+// appended.
+type docMetaRanger interface {
+	RangeDocMeta(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+		callb EntryCallback) error
+}
+
+// docMetaFromKey encodes the metadata of the primary index key sent by
+// the projector. The metadata is zero if the key does not carry it.
+func docMetaFromKey(key []byte) []byte {
+
+	meta := make([]byte, docMetaLen)
+
+	key = bytes.TrimSpace(key)
+	if len(key) < 2 || key[0] != '[' || key[len(key)-1] != ']' {
+		return meta
+	}
+	fields := bytes.Split(key[1:len(key)-1], []byte(","))
+	if len(fields) != 3 {
+		return meta
+	}
+
+	cas, err := strconv.ParseUint(string(bytes.TrimSpace(fields[0])), 10, 64)
+	if err != nil {
+		return meta
+	}
+	expiry, err := strconv.ParseUint(string(bytes.TrimSpace(fields[1])), 10, 32)
+	if err != nil {
+		return meta
+	}
+	size, err := strconv.ParseUint(string(bytes.TrimSpace(fields[2])), 10, 32)
+	if err != nil {
+		return meta
+	}
+
+	binary.BigEndian.PutUint64(meta[0:8], cas)
+	binary.BigEndian.PutUint32(meta[8:12], uint32(expiry))
+	binary.BigEndian.PutUint32(meta[12:16], uint32(size))
+	return meta
+}
+
+// appendDocMeta appends the entry and its metadata to buf.
+func appendDocMeta(buf, entry, meta []byte) []byte {
+
+	buf = append(buf, entry...)
+	if len(meta) == docMetaLen {
+		return append(buf, meta...)
+	}
+	// entries indexed before the metadata was available
+	var zero [docMetaLen]byte
+	return append(buf, zero[:]...)
+}
+
+// docMetaSplitEntry splits a primary index entry with metadata into
+// the metadata, json encoded as a secondary key in tmp, and the docid.
+func docMetaSplitEntry(entry []byte, tmp []byte) ([]byte, []byte) {
+
+	docid, meta := entry[:len(entry)-docMetaLen], entry[len(entry)-docMetaLen:]
+
+	sk := append(tmp, '[')
+	sk = strconv.AppendUint(sk, binary.BigEndian.Uint64(meta[0:8]), 10)
+	sk = append(sk, ',')
+	sk = strconv.AppendUint(sk, uint64(binary.BigEndian.Uint32(meta[8:12])), 10)
+	sk = append(sk, ',')
+	sk = strconv.AppendUint(sk, uint64(binary.BigEndian.Uint32(meta[12:16])), 10)
+	sk = append(sk, ']')
+	return sk, docid
+}
+
+// validateDocMeta checks that the metadata projected by the request is
+// kept by the index.
+func (r *ScanRequest) validateDocMeta() error {
+
+	if !r.isPrimary || !r.IndexInst.Defn.DocMeta {
+		return ErrDocMetaNotAvailable
+	}
+	if r.DocIdOnly {
+		return ErrDocMetaDocIdOnly
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//IndexerDeps are the dependencies of the indexer which can be replaced
+//when the indexer is embedded in tests or custom binaries. Zero value
+//of each field keeps the default of the indexer process.
+type IndexerDeps struct {
+	//StorageMode overrides the storage mode bootstrapped from metakv
+	StorageMode common.StorageMode
+	//DisableAdmin runs the indexer without the index manager and its
+	//admin/REST endpoints
+	DisableAdmin bool
+	//Clock is used in place of the system clock
+	Clock common.Clock
+	//Projectors is used in place of ClusterInfoCache and projector
+	//clients to manage mutation streams, like FakeProjector
+	Projectors ProjectorCluster
+	//NewSlice is used in place of NewSlice to create the storage of
+	//index partitions
+	NewSlice SliceFactory
+}
+
+var ErrIndexerStarted = errors.New("Indexer is already started")
+var ErrIndexerNotStarted = errors.New("Indexer is not started")
+
+//SliceFactory creates the storage slice of an index partition
+type SliceFactory func(id SliceId, indInst *common.IndexInst, partnInst *PartitionInst,
+	conf common.Config, stats *IndexerStats) (Slice, error)
+
+//Indexer runs the indexer inside the calling process. Start returns
+//once the indexer has bootstrapped, and the main indexer loop runs in
+//the background till Stop.
+type Indexer struct {
+	//Deps can be set before Start to replace the dependencies of
+	//the indexer
+	Deps IndexerDeps
+
+	config common.Config
+
+	mu      sync.Mutex
+	idx     *indexer
+	stopped bool
+	donech  chan bool
+}
+
+//NewIndexer creates the indexer with config. The indexer is not
+//started till Start is called.
+func NewIndexer(config common.Config) (*Indexer, error) {
+
+	if config == nil {
+		return nil, errors.New("Indexer config is nil")
+	}
+
+	return &Indexer{
+		config: config,
+		donech: make(chan bool),
+	}, nil
+}
+
+//Start bootstraps the indexer and starts the main indexer loop. An
+//Indexer can be started only once.
+func (e *Indexer) Start() error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.idx != nil || e.stopped {
+		return ErrIndexerStarted
+	}
+
+	idx, res := newIndexer(e.config, e.Deps)
+	if res.GetMsgType() != MSG_SUCCESS {
+		return msgToError(res)
+	}
+	e.idx = idx
+
+	go func() {
+		defer close(e.donech)
+		idx.run()
+	}()
+
+	return nil
+}
+
+//Stop shuts down the indexer and waits for the shutdown to complete.
+//It is safe to call Stop more than once.
+func (e *Indexer) Stop() error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.idx == nil {
+		return ErrIndexerNotStarted
+	}
+	if e.stopped {
+		return nil
+	}
+	e.stopped = true
+
+	if res := e.idx.Shutdown(); res != nil && res.GetMsgType() != MSG_SUCCESS {
+		return msgToError(res)
+	}
+	<-e.donech
+	return nil
+}
+
+//Wait blocks till the main indexer loop exits.
+func (e *Indexer) Wait() {
+	<-e.donech
+}
+
+func msgToError(res Message) error {
+	if msgErr, ok := res.(*MsgError); ok {
+		return errors.New(msgErr.GetError().String())
+	}
+	return fmt.Errorf("Indexer failed with message %v", res.GetMsgType())
+}
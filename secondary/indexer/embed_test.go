@@ -0,0 +1,64 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestNewIndexer(t *testing.T) {
+	if _, err := NewIndexer(nil); err == nil {
+		t.Fatalf("expected error for nil config")
+	}
+
+	idx, err := NewIndexer(common.SystemConfig.SectionConfig("indexer.", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Stop(); err != ErrIndexerNotStarted {
+		t.Fatalf("expected %v, got %v", ErrIndexerNotStarted, err)
+	}
+}
+
+func TestInitPartnInstanceSliceFactory(t *testing.T) {
+	pc := common.NewKeyPartitionContainer(1024, 2, common.KEY, common.CRC32)
+	for _, partnId := range []common.PartitionId{1, 2} {
+		pc.AddPartition(partnId, common.KeyPartitionDefn{Id: partnId})
+	}
+	inst := common.IndexInst{InstId: 100, Defn: common.IndexDefn{Bucket: "default", Name: "idx"}, Pc: pc}
+
+	var created []common.PartitionId
+	idx := &indexer{deps: IndexerDeps{
+		NewSlice: func(id SliceId, indInst *common.IndexInst, partnInst *PartitionInst,
+			conf common.Config, stats *IndexerStats) (Slice, error) {
+			created = append(created, partnInst.Defn.GetPartitionId())
+			return nil, nil
+		},
+	}}
+
+	partnInstMap, _, err := idx.initPartnInstance(inst, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || len(partnInstMap) != 2 {
+		t.Errorf("expected a slice for each partition, got %v", created)
+	}
+
+	// a storage failure fails the index.
+	idx.deps.NewSlice = func(SliceId, *common.IndexInst, *PartitionInst, common.Config, *IndexerStats) (Slice, error) {
+		return nil, errors.New("no space")
+	}
+	if _, _, err := idx.initPartnInstance(inst, nil, false); err == nil {
+		t.Errorf("expected slice creation error")
+	}
+}
@@ -55,6 +55,7 @@ const (
 	ERROR_KV_SENDER_UNKNOWN_STREAM
 	ERROR_KV_SENDER_UNKNOWN_BUCKET
 	ERROR_KVSENDER_STREAM_ALREADY_CLOSED
+	ERROR_KVSENDER_STREAM_AUTH_ERROR
 
 	//ScanCoordinator
 	ERROR_SCAN_COORD_UNKNOWN_COMMAND
@@ -0,0 +1,715 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/dataport"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"github.com/couchbase/indexing/secondary/logging"
+	projClient "github.com/couchbase/indexing/secondary/projector/client"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+	"github.com/golang/protobuf/proto"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  fake projector
+//
+/////////////////////////////////////////////////////////////////////////
+
+// FakeProjector is an in-process projector for tests, to be used as
+// IndexerDeps.Projectors. It implements StreamAdmin and ProjectorCluster,
+// and streams the mutations of its fake KV buckets to the dataport
+// endpoints of its topics, transformed by the index instances of the
+// topic like a projector does for DCP mutations.
+//
+// KV is scripted with Mutate, Sync, StreamEnd and Failover. Every vbucket
+// keeps its history, so that a stream requested from a seqno replays
+// the mutations after it, and a stream requested on a stale branch gets
+// a rollback. Scripted mutations are applied in the order they are
+// made, hence tests are deterministic.
+type FakeProjector struct {
+	mu      sync.Mutex
+	cluster string
+	maxvbs  int
+	config  common.Config // config of dataport endpoints
+	buckets map[string][]*fakeVbucket
+	topics  map[string]*fakeTopic
+	reqErr  error
+}
+
+// FakeDoc is a document mutation, or deletion, scripted with Mutate.
+type FakeDoc struct {
+	Key     []byte
+	Value   []byte // JSON document
+	Deleted bool
+}
+
+type fakeVbucket struct {
+	flog   [][2]uint64 // failover log of {vbuuid, seqno}, latest first
+	seqno  uint64      // high seqno
+	events []*fakeEvent
+}
+
+type fakeEvent struct {
+	seqno uint64
+	doc   FakeDoc
+}
+
+type fakeTopic struct {
+	engines   map[uint64]common.Evaluator
+	routers   map[uint64]common.Router
+	endpoints map[string]common.RouterEndpoint
+	streams   map[string]map[uint16]*fakeStream // bucket -> vbno -> stream
+	acks      map[string]*protobuf.TsVbuuid     // bucket -> acknowledged ts
+	encodeBuf []byte
+}
+
+type fakeStream struct {
+	vbuuid uint64
+	seqno  uint64 // last seqno streamed
+}
+
+// NewFakeProjector creates a fake projector for `cluster`, with KV
+// buckets of `maxvbs` vbuckets. Dataport endpoints are created with
+// `config`, the "projector.dataport." section of system config if nil.
+func NewFakeProjector(cluster string, maxvbs int, config common.Config) *FakeProjector {
+	if config == nil {
+		config = common.SystemConfig.SectionConfig("projector.dataport.", true)
+	}
+	return &FakeProjector{
+		cluster: cluster,
+		maxvbs:  maxvbs,
+		config:  config,
+		buckets: make(map[string][]*fakeVbucket),
+		topics:  make(map[string]*fakeTopic),
+	}
+}
+
+// StreamAdmin implement ProjectorCluster interface, the fake projector
+// is the projector of every node.
+func (fp *FakeProjector) StreamAdmin(addr string) StreamAdmin {
+	return fp
+}
+
+// GetLocalHostname implement ProjectorCluster interface, indexer
+// endpoints are on the local host.
+func (fp *FakeProjector) GetLocalHostname() (string, error) {
+	return "127.0.0.1", nil
+}
+
+// GetAllVbuckets implement ProjectorCluster interface.
+func (fp *FakeProjector) GetAllVbuckets(bucket string) ([]uint32, []string, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if _, ok := fp.buckets[bucket]; !ok {
+		return nil, nil, projClient.ErrorInvalidBucket
+	}
+	vbnos := make([]uint32, fp.maxvbs)
+	for i := range vbnos {
+		vbnos[i] = uint32(i)
+	}
+	return vbnos, []string{fp.cluster}, nil
+}
+
+// GetAllProjectorAddrs implement ProjectorCluster interface.
+func (fp *FakeProjector) GetAllProjectorAddrs() ([]string, error) {
+	return []string{fp.cluster}, nil
+}
+
+// GetProjAddrsForVbuckets implement ProjectorCluster interface.
+func (fp *FakeProjector) GetProjAddrsForVbuckets(
+	bucket string, vbnos []uint16) ([]string, error) {
+
+	return []string{fp.cluster}, nil
+}
+
+// AddBucket creates an empty KV bucket.
+func (fp *FakeProjector) AddBucket(bucket string) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	vbuckets := make([]*fakeVbucket, fp.maxvbs)
+	for vbno := range vbuckets {
+		vbuckets[vbno] = &fakeVbucket{
+			flog: [][2]uint64{{fakeVbuuid(uint16(vbno), 1), 0}},
+		}
+	}
+	fp.buckets[bucket] = vbuckets
+}
+
+// SetRequestError fails every StreamAdmin request with err, till it is
+// reset with nil.
+func (fp *FakeProjector) SetRequestError(err error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.reqErr = err
+}
+
+// HighSeqnos returns the KV high seqnos of bucket.
+func (fp *FakeProjector) HighSeqnos(bucket string) []uint64 {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	seqnos := make([]uint64, len(fp.buckets[bucket]))
+	for vbno, vb := range fp.buckets[bucket] {
+		seqnos[vbno] = vb.seqno
+	}
+	return seqnos
+}
+
+// AckedTimestamp returns the last timestamp of bucket acknowledged on
+// topic, nil if none.
+func (fp *FakeProjector) AckedTimestamp(topic, bucket string) *protobuf.TsVbuuid {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if t := fp.topics[topic]; t != nil {
+		return t.acks[bucket]
+	}
+	return nil
+}
+
+// Mutate applies docs to a vbucket, as a single snapshot, and streams
+// them to active streams of the vbucket. Returns the new high seqno.
+func (fp *FakeProjector) Mutate(bucket string, vbno uint16, docs ...FakeDoc) (uint64, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	vb, err := fp.getVbucket(bucket, vbno)
+	if err != nil {
+		return 0, err
+	}
+	start := vb.seqno + 1
+	events := make([]*fakeEvent, 0, len(docs))
+	for _, doc := range docs {
+		vb.seqno++
+		events = append(events, &fakeEvent{seqno: vb.seqno, doc: doc})
+	}
+	vb.events = append(vb.events, events...)
+
+	for name, topic := range fp.topics {
+		if stream := topic.streams[bucket][vbno]; stream != nil {
+			fp.sendSnapshot(name, topic, bucket, vbno, stream, start, vb.seqno, events)
+		}
+	}
+	return vb.seqno, nil
+}
+
+// Sync sends a sync message on every active stream of bucket.
+func (fp *FakeProjector) Sync(bucket string) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	for _, topic := range fp.topics {
+		for vbno, stream := range topic.streams[bucket] {
+			fp.sendControl(topic, bucket, func(ev common.Evaluator) interface{} {
+				return ev.SyncData(vbno, stream.vbuuid, stream.seqno)
+			})
+		}
+	}
+}
+
+// StreamEnd ends the active streams of a vbucket, like KV closing the
+// stream on rebalance.
+func (fp *FakeProjector) StreamEnd(bucket string, vbno uint16) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	for _, topic := range fp.topics {
+		fp.endStream(topic, bucket, vbno)
+	}
+}
+
+// Failover starts a new branch of a vbucket at seqno, dropping its
+// mutations after seqno and ending its active streams. Streams
+// requested after seqno on the old branch receive a rollback.
+func (fp *FakeProjector) Failover(bucket string, vbno uint16, seqno uint64) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	vb, err := fp.getVbucket(bucket, vbno)
+	if err != nil {
+		return err
+	}
+	if seqno > vb.seqno {
+		seqno = vb.seqno
+	}
+	n := sort.Search(len(vb.events), func(i int) bool {
+		return vb.events[i].seqno > seqno
+	})
+	vb.events, vb.seqno = vb.events[:n], seqno
+	vbuuid := fakeVbuuid(vbno, uint64(len(vb.flog)+1))
+	vb.flog = append([][2]uint64{{vbuuid, seqno}}, vb.flog...)
+
+	for _, topic := range fp.topics {
+		fp.endStream(topic, bucket, vbno)
+	}
+	return nil
+}
+
+// StreamAdmin interface
+
+// GetFailoverLogs implement StreamAdmin interface.
+func (fp *FakeProjector) GetFailoverLogs(
+	pooln, bucketn string, vbnos []uint32) (*protobuf.FailoverLogResponse, error) {
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return nil, fp.reqErr
+	}
+	res := &protobuf.FailoverLogResponse{}
+	for _, vbno := range vbnos {
+		vb, err := fp.getVbucket(bucketn, uint16(vbno))
+		if err != nil {
+			return nil, err
+		}
+		flog := &protobuf.FailoverLog{Vbno: proto.Uint32(vbno)}
+		for _, entry := range vb.flog {
+			flog.Vbuuids = append(flog.Vbuuids, entry[0])
+			flog.Seqnos = append(flog.Seqnos, entry[1])
+		}
+		res.Logs = append(res.Logs, flog)
+	}
+	return res, nil
+}
+
+// MutationTopicRequest implement StreamAdmin interface.
+func (fp *FakeProjector) MutationTopicRequest(
+	topic, endpointType string,
+	reqTimestamps []*protobuf.TsVbuuid, instances []*protobuf.Instance,
+	requestId string) (*protobuf.TopicResponse, error) {
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return nil, fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		t = &fakeTopic{
+			engines:   make(map[uint64]common.Evaluator),
+			routers:   make(map[uint64]common.Router),
+			endpoints: make(map[string]common.RouterEndpoint),
+			streams:   make(map[string]map[uint16]*fakeStream),
+			acks:      make(map[string]*protobuf.TsVbuuid),
+			encodeBuf: make([]byte, 0, 1024),
+		}
+		fp.topics[topic] = t
+	}
+	req := protobuf.NewMutationTopicRequest(topic, endpointType, instances)
+	if err := fp.addEngines(topic, t, req); err != nil {
+		return nil, err
+	}
+	return fp.startStreams(topic, t, reqTimestamps)
+}
+
+// RestartVbuckets implement StreamAdmin interface.
+func (fp *FakeProjector) RestartVbuckets(
+	topic string,
+	restartTimestamps []*protobuf.TsVbuuid) (*protobuf.TopicResponse, error) {
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return nil, fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return nil, projClient.ErrorTopicMissing
+	}
+	for _, ts := range restartTimestamps {
+		if _, ok := t.streams[ts.GetBucket()]; !ok {
+			return nil, projClient.ErrorInvalidBucket
+		}
+	}
+	return fp.startStreams(topic, t, restartTimestamps)
+}
+
+// ShutdownVbuckets implement StreamAdmin interface.
+func (fp *FakeProjector) ShutdownVbuckets(
+	topic string, shutdownTimestamps []*protobuf.TsVbuuid) error {
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return projClient.ErrorTopicMissing
+	}
+	for _, ts := range shutdownTimestamps {
+		for _, vbno := range ts.GetVbnos() {
+			fp.endStream(t, ts.GetBucket(), uint16(vbno))
+		}
+	}
+	return nil
+}
+
+// AddInstances implement StreamAdmin interface.
+func (fp *FakeProjector) AddInstances(
+	topic string, instances []*protobuf.Instance,
+	requestId string) (*protobuf.TimestampResponse, error) {
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return nil, fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return nil, projClient.ErrorTopicMissing
+	}
+	req := protobuf.NewAddInstancesRequest(topic, instances)
+	if err := fp.addEngines(topic, t, req); err != nil {
+		return nil, err
+	}
+	res := &protobuf.TimestampResponse{Topic: &topic}
+	for bucket, streams := range t.streams {
+		seqnos := make(map[uint16]uint64)
+		for vbno, stream := range streams {
+			seqnos[vbno] = stream.seqno
+		}
+		res.AddCurrentTimestamp(DEFAULT_POOL, bucket, seqnos)
+	}
+	return res, nil
+}
+
+// DelInstances implement StreamAdmin interface.
+func (fp *FakeProjector) DelInstances(topic string, uuids []uint64) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return projClient.ErrorTopicMissing
+	}
+	for _, uuid := range uuids {
+		delete(t.engines, uuid)
+		delete(t.routers, uuid)
+	}
+	return nil
+}
+
+// DelBuckets implement StreamAdmin interface.
+func (fp *FakeProjector) DelBuckets(topic string, buckets []string) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return projClient.ErrorTopicMissing
+	}
+	for _, bucket := range buckets {
+		for vbno := range t.streams[bucket] {
+			fp.endStream(t, bucket, vbno)
+		}
+		delete(t.streams, bucket)
+		for uuid, engine := range t.engines {
+			if engine.Bucket() == bucket {
+				delete(t.engines, uuid)
+				delete(t.routers, uuid)
+			}
+		}
+	}
+	return nil
+}
+
+// ShutdownTopic implement StreamAdmin interface.
+func (fp *FakeProjector) ShutdownTopic(topic string) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return projClient.ErrorTopicMissing
+	}
+	for _, endpoint := range t.endpoints {
+		endpoint.Close()
+	}
+	delete(fp.topics, topic)
+	return nil
+}
+
+// AckTimestamps implement StreamAdmin interface.
+func (fp *FakeProjector) AckTimestamps(
+	topic, endpoint string, ackTimestamps []*protobuf.TsVbuuid) error {
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.reqErr != nil {
+		return fp.reqErr
+	}
+	t := fp.topics[topic]
+	if t == nil {
+		return projClient.ErrorTopicMissing
+	}
+	for _, ts := range ackTimestamps {
+		t.acks[ts.GetBucket()] = ts
+	}
+	return nil
+}
+
+// local functions
+
+func fakeVbuuid(vbno uint16, branch uint64) uint64 {
+	return branch<<16 | uint64(vbno)
+}
+
+func (fp *FakeProjector) getVbucket(bucket string, vbno uint16) (*fakeVbucket, error) {
+	vbuckets, ok := fp.buckets[bucket]
+	if !ok {
+		return nil, projClient.ErrorInvalidBucket
+	} else if int(vbno) >= len(vbuckets) {
+		return nil, projClient.ErrorInvalidVbucket
+	}
+	return vbuckets[vbno], nil
+}
+
+type fakeSubscriber interface {
+	GetEvaluators() (map[uint64]common.Evaluator, error)
+	GetRouters() (map[uint64]common.Router, error)
+}
+
+func (fp *FakeProjector) addEngines(topic string, t *fakeTopic, req fakeSubscriber) error {
+	evaluators, err := req.GetEvaluators()
+	if err != nil {
+		return err
+	}
+	routers, err := req.GetRouters()
+	if err != nil {
+		return err
+	}
+	for uuid, evaluator := range evaluators {
+		t.engines[uuid] = evaluator
+		t.routers[uuid] = routers[uuid]
+		for _, raddr := range routers[uuid].Endpoints() {
+			if _, ok := t.endpoints[raddr]; ok {
+				continue
+			}
+			endpoint, err := dataport.NewRouterEndpoint(
+				fp.cluster, topic, raddr, fp.maxvbs, fp.config)
+			if err != nil {
+				return err
+			}
+			t.endpoints[raddr] = endpoint
+		}
+	}
+	return nil
+}
+
+// startStreams starts the requested vbucket streams of topic, and
+// replays the mutations after the requested seqno. Vbuckets requested
+// on a stale branch are returned in rollback timestamps.
+func (fp *FakeProjector) startStreams(topic string, t *fakeTopic,
+	reqTimestamps []*protobuf.TsVbuuid) (*protobuf.TopicResponse, error) {
+
+	res := &protobuf.TopicResponse{Topic: &topic}
+	for uuid := range t.engines {
+		res.InstanceIds = append(res.InstanceIds, uuid)
+	}
+
+	for _, reqTs := range reqTimestamps {
+		bucket := reqTs.GetBucket()
+		if _, ok := fp.buckets[bucket]; !ok {
+			return nil, projClient.ErrorInvalidBucket
+		}
+		if t.streams[bucket] == nil {
+			t.streams[bucket] = make(map[uint16]*fakeStream)
+		}
+		rollbTs := protobuf.NewTsVbuuid(DEFAULT_POOL, bucket, fp.maxvbs)
+		for i, vbno32 := range reqTs.GetVbnos() {
+			vbno := uint16(vbno32)
+			seqno, vbuuid := reqTs.GetSeqnos()[i], reqTs.GetVbuuids()[i]
+			if _, ok := t.streams[bucket][vbno]; ok {
+				continue // already active
+			}
+			vb := fp.buckets[bucket][vbno]
+			if rollbSeqno, ok := vb.rollback(vbuuid, seqno); !ok {
+				rollbTs.Append(vbno, rollbSeqno, vbuuid, 0, 0)
+				continue
+			}
+			stream := &fakeStream{vbuuid: vb.flog[0][0], seqno: seqno}
+			t.streams[bucket][vbno] = stream
+			fp.sendControl(t, bucket, func(ev common.Evaluator) interface{} {
+				return ev.StreamBeginData(vbno, stream.vbuuid, seqno)
+			})
+			n := sort.Search(len(vb.events), func(i int) bool {
+				return vb.events[i].seqno > seqno
+			})
+			if events := vb.events[n:]; len(events) > 0 {
+				fp.sendSnapshot(topic, t, bucket, vbno, stream, seqno+1, vb.seqno, events)
+			}
+		}
+		if !rollbTs.IsEmpty() {
+			res.RollbackTimestamps = append(res.RollbackTimestamps, rollbTs)
+		}
+	}
+
+	for bucket, streams := range t.streams {
+		actTs := protobuf.NewTsVbuuid(DEFAULT_POOL, bucket, fp.maxvbs)
+		for vbno, stream := range streams {
+			actTs.Append(vbno, stream.seqno, stream.vbuuid, stream.seqno, stream.seqno)
+		}
+		res.ActiveTimestamps = append(res.ActiveTimestamps, actTs)
+	}
+	return res, nil
+}
+
+// rollback returns the seqno to rollback to and false, if the branch
+// {vbuuid, seqno} has diverged from the vbucket history.
+func (vb *fakeVbucket) rollback(vbuuid, seqno uint64) (uint64, bool) {
+	if seqno == 0 {
+		return 0, true
+	}
+	for i, entry := range vb.flog {
+		if entry[0] != vbuuid {
+			continue
+		}
+		end := vb.seqno
+		if i > 0 {
+			end = vb.flog[i-1][1] // branch ends where the next one starts
+		}
+		if seqno > end {
+			return end, false
+		}
+		return seqno, true
+	}
+	return 0, false
+}
+
+func (fp *FakeProjector) endStream(t *fakeTopic, bucket string, vbno uint16) {
+	stream, ok := t.streams[bucket][vbno]
+	if !ok {
+		return
+	}
+	fp.sendControl(t, bucket, func(ev common.Evaluator) interface{} {
+		return ev.StreamEndData(vbno, stream.vbuuid, stream.seqno)
+	})
+	delete(t.streams[bucket], vbno)
+}
+
+// sendControl sends control data made by the first engine of bucket to
+// all endpoints of the engines of bucket.
+func (fp *FakeProjector) sendControl(t *fakeTopic, bucket string,
+	makeData func(common.Evaluator) interface{}) {
+
+	var data interface{}
+	raddrs := make(map[string]bool)
+	for uuid, engine := range t.engines {
+		if engine.Bucket() != bucket {
+			continue
+		}
+		if data == nil {
+			data = makeData(engine)
+		}
+		for _, raddr := range t.routers[uuid].Endpoints() {
+			raddrs[raddr] = true
+		}
+	}
+	for raddr := range raddrs {
+		fp.sendEndpoint(t, raddr, data)
+	}
+}
+
+func (fp *FakeProjector) sendSnapshot(topic string, t *fakeTopic,
+	bucket string, vbno uint16, stream *fakeStream, start, end uint64,
+	events []*fakeEvent) {
+
+	snapshot := &mc.DcpEvent{
+		Opcode:       mcd.DCP_SNAPSHOT,
+		VBucket:      vbno,
+		SnapstartSeq: start,
+		SnapendSeq:   end,
+		SnapshotType: 1, // memory
+	}
+	fp.sendControl(t, bucket, func(ev common.Evaluator) interface{} {
+		return ev.SnapshotData(snapshot, vbno, stream.vbuuid, stream.seqno)
+	})
+
+	for _, event := range events {
+		m := &mc.DcpEvent{
+			Opcode:  mcd.DCP_MUTATION,
+			VBucket: vbno,
+			VBuuid:  stream.vbuuid,
+			Key:     event.doc.Key,
+			Value:   event.doc.Value,
+			Seqno:   event.seqno,
+		}
+		if event.doc.Deleted {
+			m.Opcode, m.Value = mcd.DCP_DELETION, nil
+		} else {
+			m.TreatAsJSON()
+		}
+		stream.seqno = event.seqno
+
+		data := make(map[string]interface{})
+		nvalue := qvalue.NewParsedValueWithOptions(m.Value, true, true)
+		docval := qvalue.NewAnnotatedValue(nvalue)
+		context := qexpr.NewIndexContext()
+		for _, engine := range t.engines {
+			if engine.Bucket() != bucket {
+				continue
+			}
+			newBuf, err := engine.TransformRoute(
+				stream.vbuuid, m, data, t.encodeBuf, docval, context)
+			if err != nil {
+				logging.Errorf("FakeProjector %v TransformRoute: %v", topic, err)
+			}
+			if cap(newBuf) > cap(t.encodeBuf) {
+				t.encodeBuf = newBuf[:0]
+			}
+		}
+		for raddr, d := range data {
+			fp.sendEndpoint(t, raddr, d)
+		}
+	}
+}
+
+func (fp *FakeProjector) sendEndpoint(t *fakeTopic, raddr string, data interface{}) {
+	if data == nil {
+		return
+	}
+	if endpoint, ok := t.endpoints[raddr]; ok {
+		if err := endpoint.Send(data); err != nil {
+			logging.Errorf("FakeProjector endpoint %v: %v", raddr, err)
+		}
+	}
+}
+
+func (fp *FakeProjector) String() string {
+	return fmt.Sprintf("FakeProjector[%v]", fp.cluster)
+}
@@ -0,0 +1,64 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+func TestFakeProjectorRollback(t *testing.T) {
+	fp := NewFakeProjector("localhost:9000", 4, common.Config{})
+	fp.AddBucket("default")
+	for i := 0; i < 10; i++ {
+		if _, err := fp.Mutate("default", 1, FakeDoc{Key: []byte("k"), Value: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flogs, err := fp.GetFailoverLogs(DEFAULT_POOL, "default", []uint32{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbuuid := flogs.GetLogs()[0].GetVbuuids()[0]
+
+	if err := fp.Failover("default", 1, 6); err != nil {
+		t.Fatal(err)
+	}
+	if seqnos := fp.HighSeqnos("default"); seqnos[1] != 6 {
+		t.Fatalf("expected high seqno 6, got %v", seqnos[1])
+	}
+
+	reqTs := protobuf.NewTsVbuuid(DEFAULT_POOL, "default", 4)
+	reqTs.Append(1, 8, vbuuid, 8, 8)
+	res, err := fp.MutationTopicRequest("MAINT_STREAM_TOPIC", "dataport", []*protobuf.TsVbuuid{reqTs}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollbTs := res.GetRollbackTimestamps()
+	if len(rollbTs) != 1 || rollbTs[0].GetSeqnos()[0] != 6 {
+		t.Fatalf("expected rollback to seqno 6, got %v", rollbTs)
+	}
+
+	// restart from the rollback point on the old branch.
+	reqTs = protobuf.NewTsVbuuid(DEFAULT_POOL, "default", 4)
+	reqTs.Append(1, 6, vbuuid, 6, 6)
+	res, err = fp.RestartVbuckets("MAINT_STREAM_TOPIC", []*protobuf.TsVbuuid{reqTs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetRollbackTimestamps()) != 0 {
+		t.Fatalf("unexpected rollback %v", res.GetRollbackTimestamps())
+	}
+	actTs := res.GetActiveTimestamps()
+	if len(actTs) != 1 || actTs[0].GetVbuuids()[0] == vbuuid {
+		t.Fatalf("expected stream on new branch, got %v", actTs)
+	}
+
+	if err := fp.ShutdownTopic("MAINT_STREAM_TOPIC"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fp.RestartVbuckets("MAINT_STREAM_TOPIC", nil); err == nil {
+		t.Fatalf("expected error for missing topic")
+	}
+}
@@ -73,11 +73,17 @@ type flusher struct {
 	indexPartnMap IndexPartnMap
 	config        common.Config
 	stats         *IndexerStats
+	batchSize     int
+	indexMem      *indexQueueMem //memory held per index till applied
 }
 
 //NewFlusher returns new instance of flusher
 func NewFlusher(config common.Config, stats *IndexerStats) *flusher {
-	return &flusher{config: config, stats: stats}
+	return &flusher{
+		config:    config,
+		stats:     stats,
+		batchSize: config["mutation_manager.flushBatchSize"].Int(),
+	}
 }
 
 //PersistUptoTS will flush the mutation queue upto the
@@ -258,6 +264,7 @@ func (f *flusher) flushSingleVbucket(q MutationQueue, streamId common.StreamId,
 	var mut *MutationKeys
 
 	bucketStats := f.stats.buckets[mut.meta.bucket]
+	batch := newFlushBatch(f.batchSize)
 	//Process till supervisor asks to stop on the channel
 	for ok {
 		select {
@@ -267,16 +274,18 @@ func (f *flusher) flushSingleVbucket(q MutationQueue, streamId common.StreamId,
 					//No persistence is required. Just skip this mutation.
 					continue
 				}
-				f.flushSingleMutation(mut, streamId)
-				if bucketStats != nil {
-					bucketStats.mutationQueueSize.Add(-1)
+				f.flushSingleMutation(mut, streamId, batch)
+				if batch.full() || len(mutch) == 0 {
+					f.applyBatch(batch, bucketStats, false)
 				}
 			}
 		case <-stopch:
+			f.applyBatch(batch, bucketStats, false)
 			qstopch <- true
 			return
 		}
 	}
+	f.applyBatch(batch, bucketStats, false)
 }
 
 //flushSingleVbucket is the actual implementation which flushes the given queue
@@ -292,6 +301,7 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 			"%v till Seqno: %v for Stream: %v", vbucket, seqno, streamId)
 	})
 
+	coalesced := q.GetNumCoalesced(vbucket)
 	mutch, errch, err := q.DequeueUptoSeqno(vbucket, seqno)
 	if err != nil {
 		//TODO
@@ -300,9 +310,20 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 	ok := true
 	var mut *MutationKeys
 	bucketStats := f.stats.buckets[bucket]
+	batch := newFlushBatch(f.batchSize)
+
+	//mutations coalesced by the queue are not received, but were counted
+	//as queued by the stream reader
+	defer func() {
+		if n := q.GetNumCoalesced(vbucket) - coalesced; n != 0 && bucketStats != nil {
+			bucketStats.mutationQueueSize.Add(-n)
+			bucketStats.numMutationsCoalesced.Add(n)
+		}
+	}()
 
 	//Read till the channel is closed by queue indicating it has sent all the
-	//sequence numbers requested
+	//sequence numbers requested. Mutations already available on the channel
+	//are batched before being applied to storage.
 	for ok {
 		select {
 		case mut, ok = <-mutch:
@@ -311,35 +332,41 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 					//No persistence is required. Just skip this mutation.
 					continue
 				}
-				f.flushSingleMutation(mut, streamId)
-				mut.Free()
-				if bucketStats != nil {
-					bucketStats.mutationQueueSize.Add(-1)
+				f.flushSingleMutation(mut, streamId, batch)
+				if batch.full() || len(mutch) == 0 {
+					f.applyBatch(batch, bucketStats, true)
 				}
 			}
 		case <-errch:
+			f.applyBatch(batch, bucketStats, true)
 			workerMsgCh <- &MsgError{}
 			return
 
 		}
 	}
+	f.applyBatch(batch, bucketStats, true)
 }
 
-//flushSingleMutation talks to persistence layer to store the mutations
-//Any error from persistence layer is sent back on workerMsgCh
-func (f *flusher) flushSingleMutation(mut *MutationKeys, streamId common.StreamId) {
+//flushSingleMutation adds the mutations to the batch to be stored by the
+//persistence layer
+func (f *flusher) flushSingleMutation(mut *MutationKeys, streamId common.StreamId,
+	batch *flushBatch) {
 
 	switch streamId {
 
 	case common.MAINT_STREAM, common.INIT_STREAM, common.CATCHUP_STREAM:
-		f.flush(mut, streamId)
+		f.flush(mut, streamId, batch)
 
 	default:
 		logging.Errorf("Flusher::flushSingleMutation Invalid StreamId: %v", streamId)
 	}
+	if f.indexMem != nil {
+		f.indexMem.add(mut)
+	}
+	batch.addKeys(mut)
 }
 
-func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
+func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId, batch *flushBatch) {
 
 	logging.LazyTrace(func() string {
 		return fmt.Sprintf("Flusher::flush Flushing Stream %v Mutations %v", streamId, logging.TagUD(mutk))
@@ -385,11 +412,10 @@ func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
 		case common.Upsert:
 			processedUpserts = append(processedUpserts, mut.uuid)
 
-			f.processUpsert(mut, mutk.docid, mutk.meta)
-			f.processDeletionAfterUpsert(mut, mutk.docid, mutk.meta, immutable)
+			batch.add(mut, mutk, flushOpUpsert, immutable)
 
 		case common.Deletion:
-			f.processDelete(mut, mutk.docid, mutk.meta)
+			batch.add(mut, mutk, flushOpDelete, immutable)
 
 		case common.UpsertDeletion:
 
@@ -410,7 +436,7 @@ func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
 			if skipUpsertDeletion {
 				continue
 			} else {
-				f.processDelete(mut, mutk.docid, mutk.meta)
+				batch.add(mut, mutk, flushOpDelete, immutable)
 			}
 
 		default:
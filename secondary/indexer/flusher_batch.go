@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"sort"
+)
+
+const (
+	flushOpUpsert byte = iota
+	flushOpDelete
+)
+
+// flushOp is a single storage operation for an index instance
+type flushOp struct {
+	op        byte
+	immutable bool
+	mut       *Mutation
+	docid     []byte
+	meta      *MutationMeta
+}
+
+// flushOps sorts operations by index instance and docid. Operations on
+// the same docid are to be kept in arrival order, so it is only used
+// with sort.Stable.
+type flushOps []flushOp
+
+func (ops flushOps) Len() int      { return len(ops) }
+func (ops flushOps) Swap(i, j int) { ops[i], ops[j] = ops[j], ops[i] }
+func (ops flushOps) Less(i, j int) bool {
+	if ops[i].mut.uuid != ops[j].mut.uuid {
+		return ops[i].mut.uuid < ops[j].mut.uuid
+	}
+	return bytes.Compare(ops[i].docid, ops[j].docid) < 0
+}
+
+// flushBatch accumulates the decoded mutations of a vbucket, so that they
+// can be applied to storage grouped by index instance, in docid order.
+// Mutations on different docids are independent and all of them are
+// applied before the stability timestamp is committed, so reordering them
+// is safe.
+type flushBatch struct {
+	ops  flushOps
+	keys []*MutationKeys
+	size int
+}
+
+func newFlushBatch(size int) *flushBatch {
+	if size <= 0 {
+		size = 1
+	}
+	return &flushBatch{
+		ops:  make(flushOps, 0, size),
+		keys: make([]*MutationKeys, 0, size),
+		size: size,
+	}
+}
+
+func (b *flushBatch) add(mut *Mutation, mutk *MutationKeys, op byte, immutable bool) {
+	b.ops = append(b.ops, flushOp{
+		op:        op,
+		immutable: immutable,
+		mut:       mut,
+		docid:     mutk.docid,
+		meta:      mutk.meta,
+	})
+}
+
+// addKeys tracks mutk till the batch is applied
+func (b *flushBatch) addKeys(mutk *MutationKeys) {
+	b.keys = append(b.keys, mutk)
+}
+
+func (b *flushBatch) full() bool {
+	return len(b.keys) >= b.size
+}
+
+func (b *flushBatch) reset() {
+	for i := range b.ops {
+		b.ops[i] = flushOp{}
+	}
+	for i := range b.keys {
+		b.keys[i] = nil
+	}
+	b.ops = b.ops[:0]
+	b.keys = b.keys[:0]
+}
+
+// applyBatch stores all the mutations of the batch and releases them
+// if free is set.
+func (f *flusher) applyBatch(b *flushBatch, bucketStats *BucketStats, free bool) {
+
+	if len(b.keys) == 0 {
+		return
+	}
+
+	if len(b.ops) > 1 {
+		sort.Stable(b.ops)
+	}
+
+	for _, op := range b.ops {
+		switch op.op {
+		case flushOpUpsert:
+			f.processUpsert(op.mut, op.docid, op.meta)
+			f.processDeletionAfterUpsert(op.mut, op.docid, op.meta, op.immutable)
+		case flushOpDelete:
+			f.processDelete(op.mut, op.docid, op.meta)
+		}
+	}
+
+	if bucketStats != nil {
+		bucketStats.mutationQueueSize.Add(int64(-len(b.keys)))
+	}
+
+	if f.indexMem != nil {
+		for _, mutk := range b.keys {
+			f.indexMem.release(mutk)
+		}
+	}
+
+	if free {
+		for _, mutk := range b.keys {
+			mutk.Free()
+		}
+	}
+
+	b.reset()
+}
@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"sort"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestFlushBatchSortStable(t *testing.T) {
+	b := newFlushBatch(10)
+
+	add := func(inst c.IndexInstId, docid string, op byte) {
+		mutk := &MutationKeys{docid: []byte(docid)}
+		b.add(&Mutation{uuid: inst}, mutk, op, false)
+		b.addKeys(mutk)
+	}
+	add(2, "d2", flushOpUpsert)
+	add(1, "d3", flushOpUpsert)
+	add(1, "d1", flushOpUpsert)
+	add(2, "d2", flushOpDelete)
+	add(1, "d1", flushOpDelete)
+
+	sort.Stable(b.ops)
+
+	expected := []struct {
+		inst  c.IndexInstId
+		docid string
+		op    byte
+	}{
+		{1, "d1", flushOpUpsert},
+		{1, "d1", flushOpDelete},
+		{1, "d3", flushOpUpsert},
+		{2, "d2", flushOpUpsert},
+		{2, "d2", flushOpDelete},
+	}
+	for i, e := range expected {
+		op := b.ops[i]
+		if op.mut.uuid != e.inst || string(op.docid) != e.docid || op.op != e.op {
+			t.Errorf("op %d: expected %v, got {%v %s %v}", i, e, op.mut.uuid, op.docid, op.op)
+		}
+	}
+}
+
+func TestFlushBatchFullAndReset(t *testing.T) {
+	b := newFlushBatch(2)
+	for i := 0; i < 2; i++ {
+		if b.full() {
+			t.Fatalf("batch full after %d keys", i)
+		}
+		mutk := &MutationKeys{docid: []byte("d")}
+		b.add(&Mutation{}, mutk, flushOpUpsert, false)
+		b.addKeys(mutk)
+	}
+	if !b.full() {
+		t.Fatalf("expected batch to be full")
+	}
+
+	b.reset()
+	if b.full() || len(b.ops) != 0 || len(b.keys) != 0 {
+		t.Fatalf("expected empty batch after reset")
+	}
+	if cap(b.ops) < 2 {
+		t.Errorf("expected reset to retain capacity")
+	}
+
+	// a batch holds at least one mutation.
+	if b := newFlushBatch(0); b.size != 1 {
+		t.Errorf("expected size 1, got %d", b.size)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//
+// Read cache for forestdb slices.
+//
+// Scans on forestdb walk the b+tree of the snapshot for every request,
+// and scan heavy workloads end up reading the same blocks over and over.
+// The read cache keeps blocks of consecutive index entries, read from
+// committed snapshots, in an LRU bounded by
+// indexer.settings.forestdb.read_cache_size. The cache is carved out of
+// the forestdb buffer cache, so that together they stay within the
+// memory quota.
+//
+// A block is identified by the slice, the seqnum of the snapshot and the
+// key the block starts at. Committed snapshots are immutable, hence
+// cached blocks are never invalidated, except on rollback which can
+// reuse seqnums, where the slice is assigned a new cache id.
+//
+// Only full scans, starting with SeekFirst, go through the cache. Blocks
+// of a range scan would start at the arbitrary key the scan seeks to,
+// and would fill the cache with overlapping blocks that are rarely hit
+// again, hence range scans read from forestdb directly.
+//
+
+const (
+	fdbReadBlockEntries  = 256 // index entries per block
+	fdbReadEntryOverhead = 32  // approximate bytes of book-keeping per entry
+)
+
+type fdbKeyIterator interface {
+	SeekFirst()
+	Seek(key []byte)
+	Valid() bool
+	Next()
+	Key() []byte
+	Close() error
+}
+
+type fdbReadBlock struct {
+	key     string
+	entries [][]byte
+	last    bool // no more entries in the snapshot after this block
+	size    int64
+	elem    *list.Element
+}
+
+type fdbReadCache struct {
+	mu       sync.Mutex
+	capacity int64
+	used     int64
+	blocks   map[string]*fdbReadBlock
+	lru      *list.List
+
+	hits   int64
+	misses int64
+}
+
+// nil if read cache is disabled.
+var gFdbReadCache *fdbReadCache
+var gFdbReadCacheOnce sync.Once
+var gFdbReadCacheId uint64
+
+// initFdbReadCache creates the read cache shared by all forestdb
+// slices, once per process.
+func initFdbReadCache(capacity int64) {
+	gFdbReadCacheOnce.Do(func() {
+		if capacity > 0 {
+			logging.Infof("ForestDB read cache size %v", capacity)
+			gFdbReadCache = &fdbReadCache{
+				capacity: capacity,
+				blocks:   make(map[string]*fdbReadBlock),
+				lru:      list.New(),
+			}
+		}
+	})
+}
+
+// nextFdbReadCacheId returns a cache id for slice, unique within the
+// process.
+func nextFdbReadCacheId() uint64 {
+	return atomic.AddUint64(&gFdbReadCacheId, 1)
+}
+
+// fdbReadCacheStats returns the memory used by the read cache and its
+// hit and miss count.
+func fdbReadCacheStats() (used, hits, misses int64) {
+	c := gFdbReadCache
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used, c.hits, c.misses
+}
+
+func (c *fdbReadCache) get(key string) *fdbReadBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if b, ok := c.blocks[key]; ok {
+		c.lru.MoveToFront(b.elem)
+		c.hits++
+		return b
+	}
+	c.misses++
+	return nil
+}
+
+func (c *fdbReadCache) put(b *fdbReadBlock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if b.size > c.capacity {
+		return
+	} else if _, ok := c.blocks[b.key]; ok {
+		return
+	}
+	b.elem = c.lru.PushFront(b)
+	c.blocks[b.key] = b
+	c.used += b.size
+
+	for c.used > c.capacity {
+		elem := c.lru.Back()
+		old := elem.Value.(*fdbReadBlock)
+		c.lru.Remove(elem)
+		delete(c.blocks, old.key)
+		c.used -= old.size
+	}
+}
+
+// fdbCachedIterator iterates a committed snapshot block by block,
+// blocks are read from the read cache, and from forestdb when they are
+// not cached. Iteration after Seek bypasses the cache.
+type fdbCachedIterator struct {
+	cache  *fdbReadCache
+	prefix string
+	it     fdbKeyIterator
+	itNext string // block key at which `it` is positioned
+	block  *fdbReadBlock
+	pos    int
+	direct bool // iterating `it` directly, after Seek
+}
+
+func newFdbCachedIterator(s *fdbSnapshot, it *ForestDBIterator) *fdbCachedIterator {
+	cacheId := atomic.LoadUint64(&s.slice.readCacheId)
+	return &fdbCachedIterator{
+		cache:  gFdbReadCache,
+		prefix: fmt.Sprintf("%d:%d:", cacheId, s.mainSeqNum),
+		it:     it,
+	}
+}
+
+func (ci *fdbCachedIterator) SeekFirst() {
+	ci.direct = false
+	ci.load(ci.prefix+"s", nil, false)
+}
+
+func (ci *fdbCachedIterator) Seek(key []byte) {
+	ci.direct, ci.block, ci.itNext = true, nil, ""
+	ci.it.Seek(key)
+}
+
+func (ci *fdbCachedIterator) Valid() bool {
+	if ci.direct {
+		return ci.it.Valid()
+	}
+	return ci.block != nil && ci.pos < len(ci.block.entries)
+}
+
+func (ci *fdbCachedIterator) Next() {
+	if ci.direct {
+		ci.it.Next()
+		return
+	} else if ci.block == nil {
+		return
+	}
+	ci.pos++
+	if ci.pos < len(ci.block.entries) {
+		return
+	} else if ci.block.last {
+		ci.block = nil
+		return
+	}
+	lastKey := ci.block.entries[len(ci.block.entries)-1]
+	ci.load(ci.afterKey(lastKey), lastKey, true)
+}
+
+func (ci *fdbCachedIterator) Key() []byte {
+	if ci.direct {
+		return ci.it.Key()
+	} else if ci.Valid() {
+		return ci.block.entries[ci.pos]
+	}
+	return nil
+}
+
+func (ci *fdbCachedIterator) Close() error {
+	return ci.it.Close()
+}
+
+// block key for the block following the block ending with key.
+func (ci *fdbCachedIterator) afterKey(key []byte) string {
+	return ci.prefix + "a" + string(key)
+}
+
+// load the block identified by bkey, which starts at seek, or after
+// seek if `after` is true.
+func (ci *fdbCachedIterator) load(bkey string, seek []byte, after bool) {
+	if b := ci.cache.get(bkey); b != nil {
+		ci.block, ci.pos = b, 0
+		return
+	}
+
+	if ci.itNext != bkey {
+		if seek == nil {
+			ci.it.SeekFirst()
+		} else {
+			ci.it.Seek(seek)
+		}
+		if after && ci.it.Valid() && bytes.Equal(ci.it.Key(), seek) {
+			ci.it.Next()
+		}
+	}
+
+	b := &fdbReadBlock{key: bkey}
+	for ; ci.it.Valid() && len(b.entries) < fdbReadBlockEntries; ci.it.Next() {
+		entry := append([]byte(nil), ci.it.Key()...)
+		b.entries = append(b.entries, entry)
+		b.size += int64(len(entry) + fdbReadEntryOverhead)
+	}
+	b.size += int64(len(bkey))
+	b.last = !ci.it.Valid()
+
+	ci.itNext = ""
+	if !b.last {
+		ci.itNext = ci.afterKey(b.entries[len(b.entries)-1])
+	}
+	ci.cache.put(b)
+	ci.block, ci.pos = b, 0
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// testKeyIterator iterates sorted keys, in place of a forestdb iterator.
+type testKeyIterator struct {
+	keys  [][]byte
+	pos   int
+	seeks int
+}
+
+func newTestKeyIterator(n int) *testKeyIterator {
+	it := &testKeyIterator{}
+	for i := 0; i < n; i++ {
+		it.keys = append(it.keys, []byte(fmt.Sprintf("key%05d", i)))
+	}
+	return it
+}
+
+func (it *testKeyIterator) SeekFirst() {
+	it.pos = 0
+	it.seeks++
+}
+
+func (it *testKeyIterator) Seek(key []byte) {
+	it.pos = sort.Search(len(it.keys), func(i int) bool {
+		return bytes.Compare(it.keys[i], key) >= 0
+	})
+	it.seeks++
+}
+
+func (it *testKeyIterator) Valid() bool  { return it.pos < len(it.keys) }
+func (it *testKeyIterator) Next()        { it.pos++ }
+func (it *testKeyIterator) Key() []byte  { return it.keys[it.pos] }
+func (it *testKeyIterator) Close() error { return nil }
+
+func newTestFdbReadCache(capacity int64) *fdbReadCache {
+	return &fdbReadCache{
+		capacity: capacity,
+		blocks:   make(map[string]*fdbReadBlock),
+		lru:      list.New(),
+	}
+}
+
+func collectKeys(it fdbKeyIterator) (keys []string) {
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return keys
+}
+
+func TestFdbReadCacheFullScan(t *testing.T) {
+	n := 3*fdbReadBlockEntries + 10
+	cache := newTestFdbReadCache(1 << 20)
+	fdbIt := newTestKeyIterator(n)
+
+	for i := 0; i < 2; i++ {
+		ci := &fdbCachedIterator{cache: cache, prefix: "1:10:", it: fdbIt}
+		ci.SeekFirst()
+		keys := collectKeys(ci)
+		if len(keys) != n || keys[0] != "key00000" || keys[n-1] != string(fdbIt.keys[n-1]) {
+			t.Fatalf("scan %v: unexpected keys %v..", i, len(keys))
+		}
+	}
+
+	// second scan is served from the cache.
+	if len(cache.blocks) != 4 || cache.misses != 4 || cache.hits != 4 {
+		t.Errorf("expected 4 blocks, 4 misses and 4 hits, got %v %v %v",
+			len(cache.blocks), cache.misses, cache.hits)
+	}
+	// forestdb iterator is only positioned for the first block.
+	if fdbIt.seeks != 1 {
+		t.Errorf("expected 1 seek, got %v", fdbIt.seeks)
+	}
+}
+
+func TestFdbReadCacheSeekBypass(t *testing.T) {
+	cache := newTestFdbReadCache(1 << 20)
+	fdbIt := newTestKeyIterator(2 * fdbReadBlockEntries)
+
+	for _, start := range []string{"key00010", "key00011", "key00300"} {
+		ci := &fdbCachedIterator{cache: cache, prefix: "1:10:", it: fdbIt}
+		ci.Seek([]byte(start))
+		keys := collectKeys(ci)
+		if len(keys) == 0 || keys[0] != start || keys[len(keys)-1] != "key00511" {
+			t.Fatalf("seek %v: unexpected keys %v", start, keys)
+		}
+	}
+	if len(cache.blocks) != 0 || cache.used != 0 {
+		t.Errorf("expected seek to bypass cache, got %v blocks", len(cache.blocks))
+	}
+
+	// SeekFirst after Seek goes back to the cache.
+	ci := &fdbCachedIterator{cache: cache, prefix: "1:10:", it: fdbIt}
+	ci.Seek([]byte("key00100"))
+	ci.SeekFirst()
+	if keys := collectKeys(ci); len(keys) != 2*fdbReadBlockEntries {
+		t.Errorf("expected %v keys, got %v", 2*fdbReadBlockEntries, len(keys))
+	}
+	if len(cache.blocks) != 2 {
+		t.Errorf("expected 2 blocks, got %v", len(cache.blocks))
+	}
+}
+
+func TestFdbReadCacheEviction(t *testing.T) {
+	fdbIt := newTestKeyIterator(fdbReadBlockEntries)
+	ci := &fdbCachedIterator{cache: newTestFdbReadCache(1 << 20), prefix: "1:10:", it: fdbIt}
+	ci.SeekFirst()
+	size := ci.block.size
+
+	// room for two blocks, the least recently used is evicted.
+	cache := newTestFdbReadCache(2 * size)
+	for _, prefix := range []string{"1:10:", "1:11:", "1:10:", "1:12:"} {
+		ci := &fdbCachedIterator{cache: cache, prefix: prefix, it: fdbIt}
+		ci.SeekFirst()
+	}
+	if cache.used > cache.capacity || len(cache.blocks) != 2 {
+		t.Fatalf("expected 2 blocks within capacity, got %v blocks %v bytes",
+			len(cache.blocks), cache.used)
+	}
+	for _, prefix := range []string{"1:10:", "1:12:"} {
+		if _, ok := cache.blocks[prefix+"s"]; !ok {
+			t.Errorf("expected block %v to be cached", prefix)
+		}
+	}
+}
@@ -64,9 +64,21 @@ func NewForestDBSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
 	config.SetDurabilityOpt(forestdb.DRB_ASYNC)
 
 	memQuota := sysconf["settings.memory_quota"].Uint64()
-	logging.Debugf("NewForestDBSlice(): buffer cache size %d", memQuota)
-	config.SetBufferCacheSize(memQuota)
-	logging.Debugf("NewForestDBSlice(): buffer cache size %d", memQuota)
+
+	// read cache is carved out of the buffer cache, capped at half of
+	// memory quota.
+	readCacheSize := sysconf["settings.forestdb.read_cache_size"].Uint64()
+	if readCacheSize > memQuota/2 {
+		logging.Warnf("NewForestDBSlice(): read cache size %d capped to %d", readCacheSize, memQuota/2)
+		readCacheSize = memQuota / 2
+	}
+	initFdbReadCache(int64(readCacheSize))
+	slice.readCacheId = nextFdbReadCacheId()
+
+	bufCacheSize := memQuota - readCacheSize
+	logging.Debugf("NewForestDBSlice(): buffer cache size %d", bufCacheSize)
+	config.SetBufferCacheSize(bufCacheSize)
+	logging.Debugf("NewForestDBSlice(): buffer cache size %d", bufCacheSize)
 
 	prob := sysconf["settings.max_writer_lock_prob"].Int()
 	config.SetMaxWriterLockProb(uint8(prob))
@@ -256,6 +268,9 @@ type fdbSlice struct {
 	confLock   sync.RWMutex
 	statFdLock sync.Mutex
 
+	// id of the slice in read cache, changed on rollback
+	readCacheId uint64
+
 	// Array processing
 	arrayExprPosition int
 	isArrayDistinct   bool
@@ -327,6 +342,7 @@ loop:
 		var nmut int
 		select {
 		case c = <-fdb.cmdCh:
+			acquired := gIngestCPU.acquire()
 			switch c.(type) {
 			case *indexItem:
 				icmd = c.(*indexItem)
@@ -346,6 +362,7 @@ loop:
 				logging.Errorf("ForestDBSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v Received "+
 					"Unknown Command %v", fdb.id, fdb.idxInstId, logging.TagUD(c))
 			}
+			gIngestCPU.release(acquired)
 
 			fdb.idxStats.numItemsFlushed.Add(int64(nmut))
 			fdb.idxStats.numDocsIndexed.Add(1)
@@ -368,7 +385,9 @@ loop:
 func (fdb *fdbSlice) insert(key []byte, rawKey []byte, docid []byte, workerId int) int {
 	var nmut int
 
-	if fdb.isPrimary {
+	if fdb.isPrimary && fdb.idxDefn.DocMeta {
+		nmut = fdb.insertPrimaryIndexDocMeta(key, rawKey, docid, workerId)
+	} else if fdb.isPrimary {
 		nmut = fdb.insertPrimaryIndex(key, docid, workerId)
 	} else if !fdb.idxDefn.IsArrayIndex {
 		nmut = fdb.insertSecIndex(key, docid, workerId)
@@ -412,6 +431,28 @@ func (fdb *fdbSlice) insertPrimaryIndex(key []byte, docid []byte, workerId int)
 	return 1
 }
 
+// insertPrimaryIndexDocMeta sets the document metadata carried by rawKey
+// as the value of the primary index entry. The entry is set on every
+// mutation, to keep the metadata current.
+func (fdb *fdbSlice) insertPrimaryIndexDocMeta(key []byte, rawKey []byte, docid []byte, workerId int) (nmut int) {
+
+	logging.Tracef("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Set Key - %s", fdb.id, fdb.idxInstId, logging.TagStrUD(docid))
+
+	meta := docMetaFromKey(rawKey)
+
+	t0 := time.Now()
+	if err := fdb.main[workerId].SetKV(key, meta); err != nil {
+		fdb.checkFatalDbError(err)
+		logging.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error in Main Index Set. "+
+			"Skipped Key %s. Error %v", fdb.id, fdb.idxInstId, logging.TagStrUD(docid), err)
+	}
+	fdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&fdb.insert_bytes, int64(len(key)+len(meta)))
+	fdb.isDirty = true
+
+	return 1
+}
+
 func (fdb *fdbSlice) insertSecIndex(key []byte, docid []byte, workerId int) (nmut int) {
 	var err error
 	var oldkey []byte
@@ -977,6 +1018,9 @@ func (fdb *fdbSlice) Rollback(info SnapshotInfo) error {
 		common.CrashOnError(errors.New("Slice Invariant Violation - rollback with pending mutations"))
 	}
 
+	//rollback can reuse seqnums, forget blocks cached so far
+	atomic.StoreUint64(&fdb.readCacheId, nextFdbReadCacheId())
+
 	//get the seqnum from snapshot
 	snapInfo := info.(*fdbSnapshotInfo)
 
@@ -1033,6 +1077,9 @@ func (fdb *fdbSlice) RollbackToZero() error {
 	zeroSeqNum := forestdb.SeqNum(0)
 	var err error
 
+	//rollback can reuse seqnums, forget blocks cached so far
+	atomic.StoreUint64(&fdb.readCacheId, nextFdbReadCacheId())
+
 	//rollback meta-store first, if main/back index rollback fails, recovery
 	//will pick up the rolled-back meta information.
 	err = fdb.meta.Rollback(zeroSeqNum)
@@ -1315,6 +1362,66 @@ func (fdb *fdbSlice) Compact(abortTime time.Time, minFrag int) error {
 		return nil
 	}
 
+	return fdb.compactInto(abortTime, fdb.Path(), false)
+}
+
+// Relocate moves the slice to data directory dir, online. Compaction of
+// the file into the slice directory under dir copies the live data,
+// catches up with the mutations written meanwhile, and switches the
+// file handles of the slice over, after which the old slice directory
+// is removed.
+func (fdb *fdbSlice) Relocate(dir string) error {
+	fdb.IncrRef()
+	defer fdb.DecrRef()
+
+	fdb.confLock.RLock()
+	validDir := isDataDir(fdb.sysconf, dir)
+	fdb.confLock.RUnlock()
+	if !validDir {
+		return ErrInvalidDataDir
+	}
+
+	oldpath := fdb.Path()
+	newpath := filepath.Join(dir, filepath.Base(oldpath))
+	if filepath.Clean(newpath) == filepath.Clean(oldpath) {
+		return nil
+	}
+
+	fdb.setIsCompacting(true)
+	defer fdb.setIsCompacting(false)
+
+	if err := beginSliceRelocation(newpath); err != nil {
+		return err
+	}
+
+	logging.Infof("ForestDBSlice::Relocate Relocating Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v from %v to %v", fdb.id, fdb.idxInstId, fdb.idxDefnId, oldpath, newpath)
+
+	abortTime := time.Now().Add(time.Duration(24) * time.Hour)
+	if err := fdb.compactInto(abortTime, newpath, true); err != nil {
+		os.RemoveAll(newpath)
+		return err
+	}
+
+	fdb.lock.Lock()
+	fdb.path = newpath
+	fdb.lock.Unlock()
+
+	endSliceRelocation(newpath)
+	if err := os.RemoveAll(oldpath); err != nil {
+		logging.Errorf("ForestDBSlice::Relocate Error removing %v. Error %v", oldpath, err)
+	}
+
+	logging.Infof("ForestDBSlice::Relocate Relocated Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v to %v", fdb.id, fdb.idxInstId, fdb.idxDefnId, newpath)
+	return nil
+}
+
+// compactInto compacts the file upto the oldest snapshot, into a new
+// file version in dirpath. If mustCompact, it is an error for there to
+// be no snapshot to compact upto.
+func (fdb *fdbSlice) compactInto(abortTime time.Time, dirpath string, mustCompact bool) error {
+
 	//get oldest snapshot upto which compaction can be done
 	infos, err := fdb.getSnapshotsMeta()
 	if err != nil {
@@ -1327,6 +1434,9 @@ func (fdb *fdbSlice) Compact(abortTime time.Time, minFrag int) error {
 	if osnap == nil {
 		logging.Infof("ForestDBSlice::Compact No Snapshot Found. Skipped Compaction."+
 			"Slice Id %v, IndexInstId %v, IndexDefnId %v", fdb.id, fdb.idxInstId, fdb.idxDefnId)
+		if mustCompact {
+			return ErrNoSnapshotToCompact
+		}
 		return nil
 	}
 
@@ -1364,6 +1474,9 @@ snaploop:
 		logging.Infof("ForestDBSlice::Compact No Valid SnapMarker Found. Skipped Compaction."+
 			"Slice Id %v, IndexInstId %v, IndexDefnId %v MetaSeq %v LeastCmSeq %v", fdb.id, fdb.idxInstId, fdb.idxDefnId,
 			metaSeq, leastCmSeq)
+		if mustCompact {
+			return ErrNoSnapshotToCompact
+		}
 		return nil
 	} else {
 		logging.Infof("ForestDBSlice::Compact Compacting upto SeqNum %v. "+
@@ -1375,7 +1488,7 @@ snaploop:
 	defer close(donech)
 	go fdb.cancelCompactionIfExpire(abortTime, donech)
 
-	newpath := newFdbFile(fdb.path, true)
+	newpath := filepath.Join(dirpath, filepath.Base(newFdbFile(fdb.Path(), true)))
 	// Remove any existing files leftover due to a crash during last compaction attempt
 	os.Remove(newpath)
 	err = fdb.compactFd.CompactUpto(newpath, snapMarker)
@@ -184,16 +184,50 @@ func (s *fdbSnapshot) All(ctx IndexReaderContext, callb EntryCallback) error {
 	return s.Range(ctx, MinIndexKey, MaxIndexKey, Both, callb)
 }
 
+// RangeDocMeta implements docMetaRanger for primary indexes keeping
+// document metadata, as the value of entries.
+func (s *fdbSnapshot) RangeDocMeta(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	callb EntryCallback) error {
+
+	if !s.isPrimary() || !s.slice.idxDefn.DocMeta {
+		return ErrDocMetaNotAvailable
+	}
+
+	return s.iterate(ctx, low, high, inclusion, compareExact, callb, true)
+}
+
 func (s *fdbSnapshot) Iterate(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
 	cmpFn CmpEntry, callback EntryCallback) error {
 
+	return s.iterate(ctx, low, high, inclusion, cmpFn, callback, false)
+}
+
+func (s *fdbSnapshot) iterate(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	cmpFn CmpEntry, callback EntryCallback, docMeta bool) error {
+
 	ttime := time.Now()
 
 	var entry IndexEntry
-	it, err := newFDBSnapshotIterator(s)
+	fdbIt, err := newFDBSnapshotIterator(s)
 	if err != nil {
 		return err
 	}
+
+	// the read cache keeps keys only, entries with metadata are read
+	// off the iterator.
+	var it fdbKeyIterator = fdbIt
+	if gFdbReadCache != nil && s.committed && !docMeta {
+		it = newFdbCachedIterator(s, fdbIt)
+	}
+
+	if docMeta {
+		var buf []byte
+		entryCallback := callback
+		callback = func(key []byte) error {
+			buf = appendDocMeta(buf[:0], key, fdbIt.Value())
+			return entryCallback(buf)
+		}
+	}
 	defer func() {
 		go closeIterator(it)
 	}()
@@ -246,7 +280,7 @@ func (s *fdbSnapshot) isPrimary() bool {
 	return s.slice.isPrimary
 }
 
-func closeIterator(it *ForestDBIterator) {
+func closeIterator(it fdbKeyIterator) {
 	err := it.Close()
 	if err != nil {
 		logging.Errorf("ForestDB iterator: dealloc failed (%v)", err)
@@ -264,7 +298,7 @@ func (s *fdbSnapshot) newIndexEntry(b []byte, entry *IndexEntry) {
 	common.CrashOnError(err)
 }
 
-func (s *fdbSnapshot) iterEqualKeys(k IndexKey, it *ForestDBIterator,
+func (s *fdbSnapshot) iterEqualKeys(k IndexKey, it fdbKeyIterator,
 	cmpFn CmpEntry, callback func([]byte) error) error {
 	var err error
 
@@ -0,0 +1,702 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//
+// Index key statistics.
+//
+// Query optimizers estimate the selectivity of predicates on the
+// leading key of an index from the distribution of its values. While a
+// partition of an index is compacted, its latest snapshot is scanned
+// to build an equi-depth histogram on the leading key, and a
+// hyperloglog sketch of the distinct leading keys. Statistics are thus
+// refreshed about as often as the partition changes enough to need
+// compaction, and compaction completes once they are refreshed.
+// Statistics are disabled by default, see
+// indexer.settings.key_stats.histogram_buckets.
+//
+// Entries are read in index order, and the histogram is built in a
+// single pass. A bucket is closed once it holds `depth` entries, and
+// when there are twice as many buckets as configured, adjacent buckets
+// are merged and the depth is doubled. A leading key value never spans
+// buckets.
+//
+// Statistics are held by the stats of the index instance. Statistics
+// of a partition are replaced as a whole once rebuilt, and partitions
+// are copied on write, so that scans read statistics without locks.
+//
+// Partitions of a hash partitioned index hold overlapping key ranges.
+// Sketches of the partitions are merged for the distinct count of the
+// index, whereas histograms are merged approximately, by combining
+// buckets in the order of their lower bound.
+//
+
+// 2^12 registers, standard error of about 1.6%.
+const keyStatsHllPrecision = 12
+
+// KeyStatsBucket is a bucket of the histogram on the leading key. Low
+// and High are the first and last leading key in the bucket, in index
+// order.
+type KeyStatsBucket struct {
+	Low         json.RawMessage `json:"low"`
+	High        json.RawMessage `json:"high"`
+	NumEntries  uint64          `json:"numEntries"`
+	NumDistinct uint64          `json:"numDistinct"`
+}
+
+// IndexKeyStats is the key distribution of an index instance, over the
+// partitions hosted by this node.
+type IndexKeyStats struct {
+	DefnId        common.IndexDefnId `json:"defnId"`
+	InstId        common.IndexInstId `json:"instId"`
+	Bucket        string             `json:"bucket"`
+	Index         string             `json:"index"`
+	NumPartitions int                `json:"numPartitions"`
+	NumEntries    uint64             `json:"numEntries"`
+	NumDistinct   uint64             `json:"numDistinct"`
+	Histogram     []KeyStatsBucket   `json:"histogram"`
+	UpdateTime    time.Time          `json:"updateTime"` // of the least recently updated partition
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  hyperloglog
+//
+/////////////////////////////////////////////////////////////////////////
+
+type hllSketch []uint8
+
+func newHllSketch() hllSketch {
+	return make(hllSketch, 1<<keyStatsHllPrecision)
+}
+
+func (h hllSketch) add(key []byte) {
+	hash := fnv.New64a()
+	hash.Write(key)
+	x := mix64(hash.Sum64())
+
+	idx := x >> (64 - keyStatsHllPrecision)
+	w := x << keyStatsHllPrecision
+	rho := uint8(1)
+	for w&(1<<63) == 0 && rho <= 64-keyStatsHllPrecision {
+		rho++
+		w <<= 1
+	}
+	if rho > h[idx] {
+		h[idx] = rho
+	}
+}
+
+func (h hllSketch) merge(other hllSketch) {
+	for i, r := range other {
+		if r > h[i] {
+			h[i] = r
+		}
+	}
+}
+
+func (h hllSketch) estimate() uint64 {
+	m := float64(len(h))
+	sum, zeros := 0.0, 0
+	for _, r := range h {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// small range correction
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+// fnv does not spread short keys over the high bits, which select the
+// register. Finalizer of murmur3.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  partition statistics
+//
+/////////////////////////////////////////////////////////////////////////
+
+type keyStatsBucket struct {
+	low, high   []byte // collatejson encoded leading key
+	numEntries  uint64
+	numDistinct uint64
+}
+
+type partnKeyStats struct {
+	numBuckets int
+	defn       common.IndexDefn
+	instId     common.IndexInstId
+	partnId    common.PartitionId
+	numEntries uint64
+	hll        hllSketch
+	buckets    []keyStatsBucket
+	updateTime time.Time
+}
+
+type keyStatsBuilder struct {
+	numBuckets int
+	depth      uint64
+	stats      *partnKeyStats
+	last       []byte
+	keybuf     []byte
+	tmpbuf     []byte
+}
+
+func newKeyStatsBuilder(inst common.IndexInst, partnId common.PartitionId,
+	numBuckets int) *keyStatsBuilder {
+
+	return &keyStatsBuilder{
+		numBuckets: numBuckets,
+		depth:      1,
+		stats: &partnKeyStats{
+			numBuckets: numBuckets,
+			defn:       inst.Defn,
+			instId:     inst.InstId,
+			partnId:    partnId,
+			hll:        newHllSketch(),
+		},
+	}
+}
+
+// addEntry adds an index entry, as returned by the snapshot.
+func (b *keyStatsBuilder) addEntry(entry []byte) error {
+
+	e := secondaryIndexEntry(entry)
+	b.keybuf = append(b.keybuf[:0], entry[:e.lenKey()]...)
+	if b.stats.defn.Desc != nil {
+		jsonEncoder.ReverseCollate(b.keybuf, b.stats.defn.Desc)
+	}
+
+	if len(entry) > cap(b.tmpbuf) {
+		b.tmpbuf = make([]byte, 0, len(entry)+RESIZE_PAD)
+	}
+	keys, err := jsonEncoder.ExplodeArray(b.keybuf, b.tmpbuf[:0])
+	if err != nil {
+		return err
+	} else if len(keys) == 0 {
+		return nil
+	}
+
+	b.add(keys[0])
+	return nil
+}
+
+func (b *keyStatsBuilder) add(key []byte) {
+
+	st := b.stats
+	st.numEntries++
+
+	n := len(st.buckets)
+	if b.last != nil && bytes.Equal(key, b.last) {
+		st.buckets[n-1].numEntries++
+		return
+	}
+
+	st.hll.add(key)
+	b.last = append([]byte(nil), key...)
+
+	if n == 0 || st.buckets[n-1].numEntries >= b.depth {
+		st.buckets = append(st.buckets, keyStatsBucket{
+			low:         b.last,
+			high:        b.last,
+			numEntries:  1,
+			numDistinct: 1,
+		})
+		if len(st.buckets) > 2*b.numBuckets {
+			st.buckets = mergeAdjacentBuckets(st.buckets)
+			b.depth *= 2
+		}
+		return
+	}
+
+	curr := &st.buckets[n-1]
+	curr.high = b.last
+	curr.numEntries++
+	curr.numDistinct++
+}
+
+func (b *keyStatsBuilder) finish() *partnKeyStats {
+	for len(b.stats.buckets) > b.numBuckets {
+		b.stats.buckets = mergeAdjacentBuckets(b.stats.buckets)
+	}
+	b.stats.updateTime = time.Now()
+	return b.stats
+}
+
+func mergeAdjacentBuckets(buckets []keyStatsBucket) []keyStatsBucket {
+	merged := make([]keyStatsBucket, 0, (len(buckets)+1)/2)
+	for i := 0; i < len(buckets); i += 2 {
+		bkt := buckets[i]
+		if i+1 < len(buckets) {
+			bkt.high = buckets[i+1].high
+			bkt.numEntries += buckets[i+1].numEntries
+			bkt.numDistinct += buckets[i+1].numDistinct
+		}
+		merged = append(merged, bkt)
+	}
+	return merged
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  statistics of an index instance
+//
+/////////////////////////////////////////////////////////////////////////
+
+type keyStatsPartns map[common.PartitionId]*partnKeyStats
+
+// indexKeyStats are the key statistics of the partitions of an index
+// instance hosted by this node. All methods are no-op on nil.
+type indexKeyStats struct {
+	mu     sync.Mutex     // serializes updates
+	partns unsafe.Pointer // *keyStatsPartns, copied on write
+}
+
+func newIndexKeyStats() *indexKeyStats {
+	partns := make(keyStatsPartns)
+	return &indexKeyStats{partns: unsafe.Pointer(&partns)}
+}
+
+func (ks *indexKeyStats) load() keyStatsPartns {
+	if ks == nil {
+		return nil
+	}
+	return *(*keyStatsPartns)(atomic.LoadPointer(&ks.partns))
+}
+
+// update applies fn to a copy of the partitions, and publishes the copy.
+func (ks *indexKeyStats) update(fn func(partns keyStatsPartns)) {
+	if ks == nil {
+		return
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	partns := make(keyStatsPartns)
+	for partnId, st := range ks.load() {
+		partns[partnId] = st
+	}
+	fn(partns)
+	atomic.StorePointer(&ks.partns, unsafe.Pointer(&partns))
+}
+
+func (ks *indexKeyStats) set(st *partnKeyStats) {
+	ks.update(func(partns keyStatsPartns) {
+		partns[st.partnId] = st
+	})
+}
+
+// prune forgets statistics of partitions no longer hosted by this node.
+func (ks *indexKeyStats) prune(partnMap PartitionInstMap) {
+	for partnId := range ks.load() {
+		if _, ok := partnMap[partnId]; !ok {
+			ks.update(func(partns keyStatsPartns) {
+				for partnId := range partns {
+					if _, ok := partnMap[partnId]; !ok {
+						delete(partns, partnId)
+					}
+				}
+			})
+			return
+		}
+	}
+}
+
+// get returns the statistics of the instance, combined over its
+// partitions, false if no partition has statistics.
+func (ks *indexKeyStats) get() (IndexKeyStats, bool) {
+
+	partns := ks.load()
+	if len(partns) == 0 {
+		return IndexKeyStats{}, false
+	}
+
+	sts := make([]*partnKeyStats, 0, len(partns))
+	for _, st := range partns {
+		sts = append(sts, st)
+	}
+	return combineKeyStats(sts), true
+}
+
+func combineKeyStats(sts []*partnKeyStats) IndexKeyStats {
+
+	defn := sts[0].defn
+	stats := IndexKeyStats{
+		DefnId:        defn.DefnId,
+		InstId:        sts[0].instId,
+		Bucket:        defn.Bucket,
+		Index:         defn.Name,
+		NumPartitions: len(sts),
+		UpdateTime:    sts[0].updateTime,
+	}
+
+	hll := newHllSketch()
+	numBuckets := 1
+	var buckets []keyStatsBucket
+	for _, st := range sts {
+		if st.numBuckets > numBuckets {
+			numBuckets = st.numBuckets
+		}
+		stats.NumEntries += st.numEntries
+		hll.merge(st.hll)
+		buckets = append(buckets, st.buckets...)
+		if st.updateTime.Before(stats.UpdateTime) {
+			stats.UpdateTime = st.updateTime
+		}
+	}
+	stats.NumDistinct = hll.estimate()
+
+	desc := len(defn.Desc) != 0 && defn.Desc[0]
+	sort.Sort(keyStatsBuckets{buckets, desc})
+
+	depth := stats.NumEntries / uint64(numBuckets)
+	var merged []keyStatsBucket
+	for _, bkt := range buckets {
+		n := len(merged)
+		if n == 0 || merged[n-1].numEntries >= depth {
+			merged = append(merged, bkt)
+			continue
+		}
+		curr := &merged[n-1]
+		if (bytes.Compare(bkt.high, curr.high) > 0) != desc {
+			curr.high = bkt.high
+		}
+		curr.numEntries += bkt.numEntries
+		curr.numDistinct += bkt.numDistinct
+	}
+
+	stats.Histogram = make([]KeyStatsBucket, 0, len(merged))
+	for _, bkt := range merged {
+		stats.Histogram = append(stats.Histogram, KeyStatsBucket{
+			Low:         decodeKeyStatsBound(bkt.low),
+			High:        decodeKeyStatsBound(bkt.high),
+			NumEntries:  bkt.numEntries,
+			NumDistinct: bkt.numDistinct,
+		})
+	}
+	return stats
+}
+
+// keyStatsBuckets sorts buckets by their lower bound, in index order.
+type keyStatsBuckets struct {
+	buckets []keyStatsBucket
+	desc    bool
+}
+
+func (b keyStatsBuckets) Len() int      { return len(b.buckets) }
+func (b keyStatsBuckets) Swap(i, j int) { b.buckets[i], b.buckets[j] = b.buckets[j], b.buckets[i] }
+func (b keyStatsBuckets) Less(i, j int) bool {
+	cmp := bytes.Compare(b.buckets[i].low, b.buckets[j].low)
+	if b.desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func decodeKeyStatsBound(key []byte) json.RawMessage {
+	buf := make([]byte, 0, len(key)*3+RESIZE_PAD)
+	text, err := jsonEncoder.Decode(key, buf)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(text)
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  scan estimates
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// The number of rows of a scan is estimated from the histograms of the
+// partitions being scanned, using the range of the leading key of each
+// filter. Buckets within the range count fully, an equality predicate
+// counts the average entries per distinct key of the bucket, and a range
+// partially overlapping a bucket counts half of it. Inclusion of range
+// bounds is ignored.
+//
+
+// keyRange is a range of the leading key, in collation order. Bounds
+// are encoded values, nil if unbounded.
+type keyRange struct {
+	low, high []byte
+}
+
+// estimateScanRows estimates the number of rows returned by the scan,
+// false if key statistics are not available for all partitions.
+func estimateScanRows(r *ScanRequest) (uint64, bool) {
+
+	if r.Stats == nil || r.isPrimary {
+		return 0, false
+	}
+
+	rows, ok := r.Stats.keyStats.estimate(r.PartitionIds, leadingKeyRanges(r.Scans))
+	if !ok {
+		return 0, false
+	}
+
+	if r.GroupAggr == nil {
+		if r.Offset > 0 {
+			if rows <= uint64(r.Offset) {
+				return 0, true
+			}
+			rows -= uint64(r.Offset)
+		}
+		if r.Limit > 0 && rows > uint64(r.Limit) {
+			rows = uint64(r.Limit)
+		}
+	}
+	return rows, true
+}
+
+// leadingKeyRanges returns the disjoint ranges of the leading key
+// qualified by the scans.
+func leadingKeyRanges(scans []Scan) []keyRange {
+
+	var ranges []keyRange
+	for _, scan := range scans {
+		if scan.ScanType == AllReq || len(scan.Filters) == 0 {
+			return []keyRange{{}}
+		}
+		for _, filter := range scan.Filters {
+			if len(filter.CompositeFilters) == 0 {
+				return []keyRange{{}}
+			}
+			cf := filter.CompositeFilters[0]
+			ranges = append(ranges, keyRange{low: keyRangeBound(cf.Low), high: keyRangeBound(cf.High)})
+		}
+	}
+
+	sort.Sort(keyRanges(ranges))
+
+	var merged []keyRange
+	for _, kr := range ranges {
+		n := len(merged)
+		if n == 0 || (merged[n-1].high != nil && kr.low != nil && bytes.Compare(kr.low, merged[n-1].high) > 0) {
+			merged = append(merged, kr)
+			continue
+		}
+		if merged[n-1].high != nil && (kr.high == nil || bytes.Compare(kr.high, merged[n-1].high) > 0) {
+			merged[n-1].high = kr.high
+		}
+	}
+	return merged
+}
+
+func keyRangeBound(key IndexKey) []byte {
+	if key == nil {
+		return nil
+	}
+	if _, ok := key.(*NilIndexKey); ok {
+		return nil
+	}
+	return key.Bytes()
+}
+
+// keyRanges sorts ranges by their lower bound.
+type keyRanges []keyRange
+
+func (r keyRanges) Len() int      { return len(r) }
+func (r keyRanges) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r keyRanges) Less(i, j int) bool {
+	if r[j].low == nil {
+		return false
+	} else if r[i].low == nil {
+		return true
+	}
+	return bytes.Compare(r[i].low, r[j].low) < 0
+}
+
+func (ks *indexKeyStats) estimate(partnIds []common.PartitionId, ranges []keyRange) (uint64, bool) {
+
+	if len(partnIds) == 0 {
+		partnIds = []common.PartitionId{0}
+	}
+
+	partns := ks.load()
+	var rows float64
+	for _, partnId := range partnIds {
+		st, ok := partns[partnId]
+		if !ok {
+			return 0, false
+		}
+		for _, bkt := range st.buckets {
+			for _, kr := range ranges {
+				rows += bkt.overlap(kr)
+			}
+		}
+	}
+	return uint64(rows + 0.5), true
+}
+
+// overlap returns the estimated number of entries of the bucket within
+// the range.
+func (bkt *keyStatsBucket) overlap(kr keyRange) float64 {
+
+	// buckets of descending keys are in reverse collation order
+	low, high := bkt.low, bkt.high
+	if bytes.Compare(low, high) > 0 {
+		low, high = high, low
+	}
+
+	if kr.high != nil && bytes.Compare(kr.high, low) < 0 {
+		return 0
+	} else if kr.low != nil && bytes.Compare(kr.low, high) > 0 {
+		return 0
+	}
+
+	if (kr.low == nil || bytes.Compare(kr.low, low) <= 0) &&
+		(kr.high == nil || bytes.Compare(kr.high, high) >= 0) {
+		return float64(bkt.numEntries)
+	}
+
+	if kr.low != nil && kr.high != nil && bytes.Equal(kr.low, kr.high) {
+		return float64(bkt.numEntries) / float64(bkt.numDistinct)
+	}
+	return float64(bkt.numEntries) / 2
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  storage manager
+//
+/////////////////////////////////////////////////////////////////////////
+
+// updateKeyStats rebuilds the statistics of the partition from the
+// latest readable snapshot of the index.
+func (s *storageMgr) updateKeyStats(inst common.IndexInst, partnId common.PartitionId,
+	ctx IndexReaderContext, ks *indexKeyStats) {
+
+	if inst.Defn.IsPrimary {
+		return
+	}
+
+	s.muSnap.Lock()
+	is := CloneIndexSnapshot(s.indexSnapMap[inst.InstId])
+	s.muSnap.Unlock()
+
+	if is == nil {
+		return
+	}
+	defer DestroyIndexSnapshot(is)
+
+	ps, ok := is.Partitions()[partnId]
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	b := newKeyStatsBuilder(inst, partnId, s.keyStatsBuckets)
+
+	ctx.Init()
+	defer ctx.Done()
+
+	for _, ss := range ps.Slices() {
+		if err := ss.Snapshot().All(ctx, b.addEntry); err != nil {
+			logging.Errorf("StorageMgr::updateKeyStats IndexInst:%v Partition:%v Error %v",
+				inst.InstId, partnId, err)
+			return
+		}
+	}
+
+	st := b.finish()
+	ks.set(st)
+
+	logging.Infof("StorageMgr::updateKeyStats IndexInst:%v Partition:%v entries %v buckets %v "+
+		"elapsed %v", inst.InstId, partnId, st.numEntries, len(st.buckets), time.Since(start))
+}
+
+// handleIndexKeyStatsReq returns key statistics of the index given by
+// bucket and index name.
+func (s *storageMgr) handleIndexKeyStatsReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	bucket, index := r.FormValue("bucket"), r.FormValue("index")
+	if bucket == "" || index == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("Missing bucket or index\n"))
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+	if !common.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	stats := []IndexKeyStats{}
+	for _, idxStats := range s.stats.Get().indexes {
+		if idxStats.bucket != bucket || idxStats.name != index {
+			continue
+		}
+		if st, ok := idxStats.keyStats.get(); ok {
+			stats = append(stats, st)
+		}
+	}
+
+	buf, err := json.Marshal(stats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("%v\n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(buf)
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func testKeyStatsInst(instId common.IndexInstId) common.IndexInst {
+	return common.IndexInst{
+		InstId: instId,
+		Defn:   common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx"},
+		State:  common.INDEX_STATE_ACTIVE,
+	}
+}
+
+// buildKeyStats builds statistics of a partition with an entry for
+// every leading key in keys, in index order.
+func buildKeyStats(t *testing.T, inst common.IndexInst, partnId common.PartitionId,
+	numBuckets int, keys []int) *partnKeyStats {
+
+	b := newKeyStatsBuilder(inst, partnId, numBuckets)
+	for i, key := range keys {
+		entry, err := newSKEntry([]byte(fmt.Sprintf(`[%d,"x"]`, key)), []byte(fmt.Sprintf("doc%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.addEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.finish()
+}
+
+func TestKeyStatsBuilder(t *testing.T) {
+	var keys []int
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, i)
+	}
+	st := buildKeyStats(t, testKeyStatsInst(100), 1, 8, keys)
+
+	if st.numEntries != 1000 || len(st.buckets) > 8 || len(st.buckets) < 4 {
+		t.Fatalf("unexpected stats, %v entries, %v buckets", st.numEntries, len(st.buckets))
+	}
+	var numEntries, numDistinct uint64
+	for i, bkt := range st.buckets {
+		numEntries += bkt.numEntries
+		numDistinct += bkt.numDistinct
+		if bytes.Compare(bkt.low, bkt.high) > 0 {
+			t.Errorf("bucket %v: low above high", i)
+		}
+		if i > 0 && bytes.Compare(st.buckets[i-1].high, bkt.low) >= 0 {
+			t.Errorf("bucket %v: overlaps previous bucket", i)
+		}
+	}
+	if numEntries != 1000 || numDistinct != 1000 {
+		t.Errorf("expected 1000 entries and distinct keys, got %v %v", numEntries, numDistinct)
+	}
+	if low := string(decodeKeyStatsBound(st.buckets[0].low)); low != "0" {
+		t.Errorf("expected low 0, got %v", low)
+	}
+	if high := string(decodeKeyStatsBound(st.buckets[len(st.buckets)-1].high)); high != "999" {
+		t.Errorf("expected high 999, got %v", high)
+	}
+}
+
+func TestKeyStatsDuplicateKeys(t *testing.T) {
+	// a leading key never spans buckets.
+	var keys []int
+	for _, key := range []int{1, 2, 3} {
+		for i := 0; i < 100; i++ {
+			keys = append(keys, key)
+		}
+	}
+	st := buildKeyStats(t, testKeyStatsInst(100), 1, 8, keys)
+
+	if len(st.buckets) != 3 {
+		t.Fatalf("expected 3 buckets, got %v", len(st.buckets))
+	}
+	for i, bkt := range st.buckets {
+		if bkt.numEntries != 100 || bkt.numDistinct != 1 || !bytes.Equal(bkt.low, bkt.high) {
+			t.Errorf("bucket %v: unexpected %v entries %v distinct", i, bkt.numEntries, bkt.numDistinct)
+		}
+	}
+}
+
+func TestHllSketch(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h := newHllSketch()
+		for i := 0; i < n; i++ {
+			h.add([]byte(fmt.Sprintf("key%d", i)))
+			h.add([]byte(fmt.Sprintf("key%d", i)))
+		}
+		est := float64(h.estimate())
+		if math.Abs(est-float64(n))/float64(n) > 0.05 {
+			t.Errorf("expected about %v distinct keys, got %v", n, est)
+		}
+	}
+
+	// merged sketches count keys of both.
+	h1, h2 := newHllSketch(), newHllSketch()
+	for i := 0; i < 1000; i++ {
+		h1.add([]byte(fmt.Sprintf("key%d", i)))
+		h2.add([]byte(fmt.Sprintf("key%d", i+500)))
+	}
+	h1.merge(h2)
+	if est := float64(h1.estimate()); math.Abs(est-1500)/1500 > 0.05 {
+		t.Errorf("expected about 1500 distinct keys, got %v", est)
+	}
+}
+
+func TestKeyStatsCombine(t *testing.T) {
+	inst := testKeyStatsInst(100)
+
+	// hash partitions hold interleaved keys.
+	var keys1, keys2 []int
+	for i := 0; i < 500; i++ {
+		keys1 = append(keys1, 2*i)
+		keys2 = append(keys2, 2*i+1)
+	}
+	ks := newIndexKeyStats()
+	if _, ok := ks.get(); ok {
+		t.Errorf("expected no stats")
+	}
+	ks.set(buildKeyStats(t, inst, 1, 4, keys1))
+	ks.set(buildKeyStats(t, inst, 2, 4, keys2))
+
+	st, ok := ks.get()
+	if !ok {
+		t.Fatal("expected stats")
+	}
+	if st.InstId != 100 || st.DefnId != 10 || st.NumPartitions != 2 || st.NumEntries != 1000 {
+		t.Errorf("unexpected stats %v", st)
+	}
+	if math.Abs(float64(st.NumDistinct)-1000)/1000 > 0.05 {
+		t.Errorf("expected about 1000 distinct keys, got %v", st.NumDistinct)
+	}
+	var numEntries uint64
+	for _, bkt := range st.Histogram {
+		numEntries += bkt.NumEntries
+	}
+	if numEntries != 1000 || len(st.Histogram) > 5 {
+		t.Errorf("unexpected histogram %v", st.Histogram)
+	}
+	if string(st.Histogram[0].Low) != "0" || string(st.Histogram[len(st.Histogram)-1].High) != "999" {
+		t.Errorf("unexpected histogram bounds %v", st.Histogram)
+	}
+
+	// nil stats, of instances added before key statistics.
+	var nilStats *indexKeyStats
+	nilStats.set(buildKeyStats(t, inst, 1, 4, keys1))
+	if _, ok := nilStats.get(); ok {
+		t.Errorf("expected no stats")
+	}
+}
+
+func TestKeyStatsPrune(t *testing.T) {
+	inst := testKeyStatsInst(100)
+	ks := newIndexKeyStats()
+	ks.set(buildKeyStats(t, inst, 1, 4, []int{1}))
+	ks.set(buildKeyStats(t, inst, 2, 4, []int{2}))
+
+	// readers keep the statistics they loaded.
+	before := ks.load()
+	ks.prune(PartitionInstMap{1: PartitionInst{}})
+
+	if st, ok := ks.get(); !ok || st.NumPartitions != 1 {
+		t.Errorf("expected stats of 1 partition, got %v", st)
+	}
+	if len(before) != 2 {
+		t.Errorf("expected loaded stats to be unchanged, got %v partitions", len(before))
+	}
+}
+
+func TestKeyStatsEstimate(t *testing.T) {
+	inst := testKeyStatsInst(100)
+
+	// 100 entries for every key 0..9
+	var keys []int
+	for key := 0; key < 10; key++ {
+		for i := 0; i < 100; i++ {
+			keys = append(keys, key)
+		}
+	}
+	ks := newIndexKeyStats()
+	ks.set(buildKeyStats(t, inst, 1, 16, keys))
+	ks.set(buildKeyStats(t, inst, 2, 16, keys))
+
+	encode := func(key int) []byte {
+		b, err := jsonEncoder.Encode([]byte(fmt.Sprintf("%d", key)), make([]byte, 0, 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	testcases := []struct {
+		name     string
+		partnIds []common.PartitionId
+		ranges   []keyRange
+		expected uint64
+	}{
+		{"all", []common.PartitionId{1}, []keyRange{{}}, 1000},
+		{"all partitions", []common.PartitionId{1, 2}, []keyRange{{}}, 2000},
+		{"equality", []common.PartitionId{1}, []keyRange{{encode(3), encode(3)}}, 100},
+		{"range", []common.PartitionId{1}, []keyRange{{encode(2), encode(5)}}, 400},
+		{"open range", []common.PartitionId{1}, []keyRange{{low: encode(8)}}, 200},
+		{"disjoint", []common.PartitionId{1}, []keyRange{{high: encode(0)}, {low: encode(9)}}, 200},
+		{"no match", []common.PartitionId{1}, []keyRange{{low: encode(20)}}, 0},
+	}
+	for _, tc := range testcases {
+		rows, ok := ks.estimate(tc.partnIds, tc.ranges)
+		if !ok || rows != tc.expected {
+			t.Errorf("%v: expected %v rows, got %v %v", tc.name, tc.expected, rows, ok)
+		}
+	}
+
+	// estimate needs statistics of all partitions scanned.
+	if _, ok := ks.estimate([]common.PartitionId{1, 3}, []keyRange{{}}); ok {
+		t.Errorf("expected no estimate without stats of partition 3")
+	}
+	var nilStats *indexKeyStats
+	if _, ok := nilStats.estimate([]common.PartitionId{1}, []keyRange{{}}); ok {
+		t.Errorf("expected no estimate without stats")
+	}
+}
+
+func TestEstimateScanRows(t *testing.T) {
+	idxStats := &IndexStats{}
+	idxStats.Init()
+	idxStats.keyStats = newIndexKeyStats()
+
+	var keys []int
+	for i := 0; i < 100; i++ {
+		keys = append(keys, i)
+	}
+	idxStats.keyStats.set(buildKeyStats(t, testKeyStatsInst(100), 0, 8, keys))
+
+	req := &ScanRequest{Stats: idxStats, Scans: []Scan{{ScanType: AllReq}}}
+	if rows, ok := estimateScanRows(req); !ok || rows != 100 {
+		t.Errorf("expected 100 rows, got %v %v", rows, ok)
+	}
+
+	req.Offset, req.Limit = 10, 50
+	if rows, ok := estimateScanRows(req); !ok || rows != 50 {
+		t.Errorf("expected 50 rows, got %v %v", rows, ok)
+	}
+	req.Offset = 95
+	if rows, ok := estimateScanRows(req); !ok || rows != 5 {
+		t.Errorf("expected 5 rows, got %v %v", rows, ok)
+	}
+
+	req.Stats = nil
+	if _, ok := estimateScanRows(req); ok {
+		t.Errorf("expected no estimate without stats")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//indexQueueMem accounts the memory used by each index instance for its
+//mutations, both queued and held by the flusher workers till they are
+//applied to the index. Enqueue of mutations for an instance over the per
+//index limit is throttled till the flusher drains it, so that a single
+//index (e.g. a large array index) cannot use up the memory of all the
+//queues.
+type indexQueueMem struct {
+	mu      sync.RWMutex
+	insts   map[common.IndexInstId]*instQueueMem
+	limit   int64 //per index limit, 0 disables throttling
+	timeout int64 //max time an enqueue is throttled, 0 for no timeout
+}
+
+type instQueueMem struct {
+	used         int64 //queue memory used by the index
+	numThrottled int64 //num of enqueues throttled
+	throttleTime int64 //total time throttled in nanoseconds
+}
+
+func newIndexQueueMem() *indexQueueMem {
+	return &indexQueueMem{
+		insts: make(map[common.IndexInstId]*instQueueMem),
+	}
+}
+
+func (m *indexQueueMem) setLimit(limit int64) {
+	atomic.StoreInt64(&m.limit, limit)
+}
+
+func (m *indexQueueMem) setTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&m.timeout, int64(timeout))
+}
+
+func (m *indexQueueMem) getInst(instId common.IndexInstId) *instQueueMem {
+
+	m.mu.RLock()
+	inst, ok := m.insts[instId]
+	m.mu.RUnlock()
+	if ok {
+		return inst
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if inst, ok = m.insts[instId]; !ok {
+		inst = &instQueueMem{}
+		m.insts[instId] = inst
+	}
+	return inst
+}
+
+//add accounts the memory of mutation to its index instances
+func (m *indexQueueMem) add(mk *MutationKeys) {
+	for _, mut := range mk.mut {
+		atomic.AddInt64(&m.getInst(mut.uuid).used, mut.Size())
+	}
+}
+
+//release the memory of mutation, once it is dequeued
+func (m *indexQueueMem) release(mk *MutationKeys) {
+	for _, mut := range mk.mut {
+		atomic.AddInt64(&m.getInst(mut.uuid).used, -mut.Size())
+	}
+}
+
+//overLimit returns the index instance of mutation which is over the limit,
+//or nil if there is none.
+func (m *indexQueueMem) overLimit(mk *MutationKeys) *instQueueMem {
+
+	limit := atomic.LoadInt64(&m.limit)
+	if limit <= 0 {
+		return nil
+	}
+
+	for _, mut := range mk.mut {
+		inst := m.getInst(mut.uuid)
+		if atomic.LoadInt64(&inst.used) >= limit {
+			return inst
+		}
+	}
+	return nil
+}
+
+//updateStats sets queue memory and throttling stats of each index, and
+//forgets the instances which are no more in stats.
+func (m *indexQueueMem) updateStats(stats *IndexerStats) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for instId, inst := range m.insts {
+		idxStats, ok := stats.indexes[instId]
+		if !ok {
+			if atomic.LoadInt64(&inst.used) == 0 {
+				delete(m.insts, instId)
+			}
+			continue
+		}
+		idxStats.memUsedQueue.Set(atomic.LoadInt64(&inst.used))
+		idxStats.numQueueThrottled.Set(atomic.LoadInt64(&inst.numThrottled))
+		idxStats.queueThrottleTime.Set(atomic.LoadInt64(&inst.throttleTime))
+	}
+}
+
+//throttle waits while any index instance of the mutation is over the
+//limit. It returns false if the queue is stopped.
+func (q *atomicMutationQueue) throttle(mutation *MutationKeys,
+	vbucket Vbucket, appch StopChannel) bool {
+
+	if !q.throttleMemPressure(vbucket, appch) {
+		return false
+	}
+
+	if !q.throttleCommitLatency(vbucket, appch) {
+		return false
+	}
+
+	if q.indexMem == nil {
+		return true
+	}
+
+	inst := q.indexMem.overLimit(mutation)
+	if inst == nil {
+		return true
+	}
+
+	start := time.Now()
+	atomic.AddInt64(&inst.numThrottled, 1)
+	defer func() {
+		atomic.AddInt64(&inst.throttleTime, int64(time.Since(start)))
+	}()
+
+	ticker := time.NewTicker(time.Millisecond * time.Duration(q.allocPollInterval))
+	defer ticker.Stop()
+
+	for {
+		//a minimum queue length is always allowed so that flusher
+		//can make progress
+		if atomic.LoadInt64(&q.size[vbucket]) < int64(q.minQueueLen) {
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+			if q.indexMem.overLimit(mutation) == nil {
+				return true
+			}
+			timeout := time.Duration(atomic.LoadInt64(&q.indexMem.timeout))
+			if timeout > 0 && time.Since(start) > timeout {
+				return true
+			}
+
+		case <-q.stopch[vbucket]:
+			return false
+
+		case <-appch:
+			return true
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func testIndexMutation(seqno uint64, insts ...common.IndexInstId) *MutationKeys {
+	mk := &MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: Seqno(seqno)}}
+	for _, inst := range insts {
+		mk.mut = append(mk.mut, &Mutation{uuid: inst, key: make([]byte, 100)})
+	}
+	return mk
+}
+
+func TestIndexQueueMemLimit(t *testing.T) {
+
+	m := newIndexQueueMem()
+	mk := testIndexMutation(1, 1, 2)
+
+	m.add(mk)
+	if m.overLimit(mk) != nil {
+		t.Fatalf("expected no limit when disabled")
+	}
+
+	m.setLimit(mk.mut[0].Size())
+	if inst := m.overLimit(mk); inst == nil || inst.used != mk.mut[0].Size() {
+		t.Fatalf("expected index over limit, got %v", inst)
+	}
+	if m.overLimit(testIndexMutation(2, 3)) != nil {
+		t.Errorf("expected other index not to be over limit")
+	}
+
+	m.release(mk)
+	if m.overLimit(mk) != nil {
+		t.Errorf("expected index under limit once released")
+	}
+}
+
+func TestIndexQueueMemThrottle(t *testing.T) {
+
+	maxMemory := int64(100 * 1024 * 1024)
+	var memUsed int64
+	conf := common.SystemConfig.SectionConfig("indexer.", true /*trim*/)
+	conf.SetValue("mutation_queue.fdb.allocPollInterval", 1)
+	conf.SetValue("mutation_queue.moi.allocPollInterval", 1)
+	conf.SetValue("settings.minVbQueueLength", 1)
+
+	q := NewAtomicMutationQueue("default", 1, &maxMemory, &memUsed, conf)
+	m := newIndexQueueMem()
+	q.SetIndexMemory(m)
+
+	mk1 := testIndexMutation(1, 1)
+	m.setLimit(mk1.mut[0].Size())
+	q.Enqueue(mk1, 0, nil)
+
+	// a flusher worker holding the mutation keeps the index over limit
+	// after it is dequeued, till the mutation is applied.
+	f := &flusher{indexMem: m}
+	batch := newFlushBatch(10)
+	f.flushSingleMutation(q.DequeueSingleElement(0), common.MAINT_STREAM, batch)
+	q.Enqueue(testIndexMutation(2, 2), 0, nil)
+
+	done := make(chan bool)
+	go func() {
+		q.Enqueue(testIndexMutation(3, 1), 0, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("expected enqueue to be throttled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.applyBatch(batch, nil, false)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected enqueue to proceed once applied")
+	}
+}
+
+func TestIndexQueueMemThrottleTimeout(t *testing.T) {
+
+	maxMemory := int64(100 * 1024 * 1024)
+	var memUsed int64
+	conf := common.SystemConfig.SectionConfig("indexer.", true /*trim*/)
+	conf.SetValue("mutation_queue.fdb.allocPollInterval", 1)
+	conf.SetValue("mutation_queue.moi.allocPollInterval", 1)
+	conf.SetValue("settings.minVbQueueLength", 1)
+
+	q := NewAtomicMutationQueue("default", 1, &maxMemory, &memUsed, conf)
+	m := newIndexQueueMem()
+	q.SetIndexMemory(m)
+
+	mk1 := testIndexMutation(1, 1)
+	m.setLimit(mk1.mut[0].Size())
+	m.setTimeout(20 * time.Millisecond)
+	q.Enqueue(mk1, 0, nil)
+
+	start := time.Now()
+	q.Enqueue(testIndexMutation(2, 1), 0, nil)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected enqueue to be throttled till timeout, took %v", elapsed)
+	}
+	checkSizeA(t, q, 0, 2)
+}
@@ -0,0 +1,966 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/common/queryutil"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  index verification against KV
+//
+/////////////////////////////////////////////////////////////////////////
+
+// Index verification samples documents, from the index and from the
+// bucket, re-evaluates the index expressions on the documents fetched
+// from KV and compares the result with the entries in the index.
+//
+// The index snapshot is taken at a KV timestamp later than the document
+// reads, and a document that has changed by the time a discrepancy is
+// found is not reported, so that mutations in flight are not mistaken
+// for missed mutations.
+
+const (
+	VERIFY_MISSING = "missing" // document has no entries in index
+	VERIFY_EXTRA   = "extra"   // index has entries for document not to be indexed
+	VERIFY_WRONG   = "wrong"   // index entries do not match the document
+)
+
+const verifyMaxDiscrepancies = 100
+const verifySnapshotTimeout = 2 * time.Minute
+const verifyBatchSize = 10000
+const verifyFetchConcurrency = 16
+
+var ErrVerifyInProgress = errors.New("Index verification already in progress")
+var ErrVerifyNotSupported = errors.New("Index verification is not supported for index on XATTRs")
+
+// IndexDiscrepancy is a document whose entries in the index do not
+// match the document in KV.
+type IndexDiscrepancy struct {
+	DocId       string `json:"docId"`
+	Type        string `json:"type"`
+	NumExpected int    `json:"numExpected"`
+	NumActual   int    `json:"numActual"`
+}
+
+// IndexVerifyResult is the outcome of verifying an index instance.
+type IndexVerifyResult struct {
+	InstId        common.IndexInstId  `json:"instId"`
+	Bucket        string              `json:"bucket"`
+	Index         string              `json:"index"`
+	Full          bool                `json:"full"`
+	Running       bool                `json:"running"`
+	StartTime     time.Time           `json:"startTime"`
+	EndTime       time.Time           `json:"endTime"`
+	NumChecked    uint64              `json:"numChecked"`
+	NumMissing    uint64              `json:"numMissing"`
+	NumExtra      uint64              `json:"numExtra"`
+	NumWrong      uint64              `json:"numWrong"`
+	NumChanged    uint64              `json:"numChanged"`
+	Discrepancies []*IndexDiscrepancy `json:"discrepancies,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// indexVerifier keeps the result of the last verification of each
+// index instance.
+type indexVerifier struct {
+	mu      sync.Mutex
+	results map[common.IndexInstId]*IndexVerifyResult
+}
+
+func newIndexVerifier() *indexVerifier {
+	return &indexVerifier{
+		results: make(map[common.IndexInstId]*IndexVerifyResult),
+	}
+}
+
+// begin records the start of a verification, it fails if the instance
+// is being verified already. The verification is run on a working copy
+// of the result, which is passed to end.
+func (v *indexVerifier) begin(inst *common.IndexInst, full bool) (*IndexVerifyResult, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if r, ok := v.results[inst.InstId]; ok && r.Running {
+		return nil, ErrVerifyInProgress
+	}
+
+	r := &IndexVerifyResult{
+		InstId:    inst.InstId,
+		Bucket:    inst.Defn.Bucket,
+		Index:     inst.Defn.Name,
+		Full:      full,
+		Running:   true,
+		StartTime: time.Now(),
+	}
+	v.results[inst.InstId] = r
+	return r, nil
+}
+
+// end records the outcome of a verification, from the working copy of
+// the result.
+func (v *indexVerifier) end(r *IndexVerifyResult, work *IndexVerifyResult, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	r.NumChecked = work.NumChecked
+	r.NumMissing = work.NumMissing
+	r.NumExtra = work.NumExtra
+	r.NumWrong = work.NumWrong
+	r.NumChanged = work.NumChanged
+	r.Discrepancies = work.Discrepancies
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.Running = false
+	r.EndTime = time.Now()
+}
+
+// lastStart returns the start time of the last verification of the
+// instance, zero if it has not been verified.
+func (v *indexVerifier) lastStart(instId common.IndexInstId) time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if r, ok := v.results[instId]; ok {
+		return r.StartTime
+	}
+	return time.Time{}
+}
+
+// Results returns a copy of the results, of all instances if instId
+// is 0.
+func (v *indexVerifier) Results(instId common.IndexInstId) []IndexVerifyResult {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var results []IndexVerifyResult
+	for id, r := range v.results {
+		if instId == 0 || id == instId {
+			results = append(results, *r)
+		}
+	}
+	return results
+}
+
+func (v *indexVerifier) forget(instMap common.IndexInstMap) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for instId := range v.results {
+		if _, ok := instMap[instId]; !ok {
+			delete(v.results, instId)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  expression evaluation
+//
+/////////////////////////////////////////////////////////////////////////
+
+// verifyEvaluator computes the index entries of a document, the same
+// way projector evaluates and slices store the secondary keys.
+type verifyEvaluator struct {
+	defn            *common.IndexDefn
+	secExprs        []interface{}
+	whereExpr       interface{}
+	isArrayDistinct bool
+	arrayPos        int
+	encodeBuf       []byte
+	arrayBuf        []byte
+}
+
+func newVerifyEvaluator(defn *common.IndexDefn) (*verifyEvaluator, error) {
+
+	e := &verifyEvaluator{
+		defn:      defn,
+		encodeBuf: make([]byte, 0, maxSecKeyBufferLen),
+	}
+
+	if defn.IsPrimary {
+		return e, nil
+	}
+
+	if len(defn.ExprType) != 0 && defn.ExprType != common.N1QL {
+		return nil, fmt.Errorf("Cannot verify index with expression type %v", defn.ExprType)
+	}
+
+	exprs := defn.SecExprs
+	if len(defn.WhereExpr) != 0 {
+		exprs = append([]string{defn.WhereExpr}, exprs...)
+	}
+	if present, _, _ := queryutil.GetXATTRNames(exprs); present {
+		return nil, ErrVerifyNotSupported
+	}
+
+	var err error
+	if e.secExprs, err = protobuf.CompileN1QLExpression(defn.SecExprs); err != nil {
+		return nil, err
+	}
+
+	if len(defn.WhereExpr) != 0 {
+		whereExprs, err := protobuf.CompileN1QLExpression([]string{defn.WhereExpr})
+		if err != nil {
+			return nil, err
+		}
+		e.whereExpr = whereExprs[0]
+	}
+
+	if defn.IsArrayIndex {
+		_, e.isArrayDistinct, e.arrayPos, err = queryutil.GetArrayExpressionPosition(defn.SecExprs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// entries returns the index entries of the document, nil if the document
+// is not indexed.
+func (e *verifyEvaluator) entries(docid, body []byte, cas uint64) map[string]bool {
+
+	if e.defn.IsPrimary {
+		entry, err := NewPrimaryIndexEntry(docid)
+		if err != nil {
+			return nil
+		}
+		return map[string]bool{string(entry): true}
+	}
+
+	docval := qvalue.NewAnnotatedValue(qvalue.NewParsedValueWithOptions(body, true, true))
+	docval.SetAttachment("meta", map[string]interface{}{"id": string(docid), "cas": cas})
+	context := qexpr.NewIndexContext()
+
+	if e.whereExpr != nil {
+		out, _, err := protobuf.N1QLTransform(nil, docval, context, []interface{}{e.whereExpr}, nil)
+		if err != nil || string(out) != "true" {
+			return nil
+		}
+	}
+
+	evaluate := protobuf.N1QLTransform
+	if e.defn.IncludeMissing {
+		evaluate = protobuf.N1QLEvaluateMissing
+	}
+	key, newBuf, err := evaluate(docid, docval, context, e.secExprs, e.encodeBuf)
+	if newBuf != nil {
+		e.encodeBuf = newBuf
+	}
+	if err != nil || key == nil {
+		return nil
+	}
+
+	newEntry := func(key []byte, isArray bool, count int) []byte {
+		buf := make([]byte, 0, len(key)+len(docid)+ENCODE_BUF_SAFE_PAD)
+		entry, err := NewSecondaryIndexEntry(key, docid, isArray, count, e.defn.Desc, buf, nil)
+		if err != nil {
+			return nil
+		}
+		return entry
+	}
+
+	if !e.defn.IsArrayIndex {
+		if entry := newEntry(key, false, 1); entry != nil {
+			return map[string]bool{string(entry): true}
+		}
+		return nil
+	}
+
+	e.arrayBuf = resizeArrayBuf(e.arrayBuf, len(key)*3)
+	items, counts, _, err := ArrayIndexItems(key, e.arrayPos, e.arrayBuf, e.isArrayDistinct, !allowLargeKeys)
+	if err != nil {
+		return nil
+	}
+
+	entries := make(map[string]bool)
+	for i, item := range items {
+		if entry := newEntry(item, false, counts[i]); entry != nil {
+			entries[string(entry)] = true
+		}
+	}
+	return entries
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  verification
+//
+/////////////////////////////////////////////////////////////////////////
+
+type verifyDoc struct {
+	docid    string
+	found    bool
+	cas      uint64
+	expected map[string]bool
+	actual   map[string]bool
+}
+
+// verifySource gives access to the documents in the bucket and to the
+// entries in the index being verified.
+type verifySource interface {
+	// fetch reads the document from the bucket, and computes its
+	// expected index entries if eval is set.
+	fetch(doc *verifyDoc, eval bool) error
+
+	// sample returns up to n documents sampled from the bucket, with
+	// their expected index entries.
+	sample(n int) ([]*verifyDoc, error)
+
+	// scan calls callb for every entry of an index snapshot as recent
+	// as the bucket at the time of the call.
+	scan(callb func(docid string, entry []byte) error) error
+
+	// count returns the approximate number of entries in the index.
+	count() (uint64, error)
+}
+
+// verifyIndex verifies sampleSize documents sampled from the index and
+// as many documents sampled from the bucket. In full mode, every
+// document in the index is verified.
+func (s *scanCoordinator) verifyIndex(inst *common.IndexInst, r *IndexVerifyResult, sampleSize int) error {
+
+	eval, err := newVerifyEvaluator(&inst.Defn)
+	if err != nil {
+		return err
+	}
+
+	cfg := s.config.Load()
+	clusterAddr := cfg["clusterAddr"].String()
+
+	bucket, err := common.ConnectBucket(clusterAddr, "default", inst.Defn.Bucket)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+
+	src := &kvVerifySource{
+		s:           s,
+		inst:        inst,
+		bucket:      bucket,
+		eval:        eval,
+		clusterAddr: clusterAddr,
+	}
+	if r.Full {
+		err = verifyAll(src, r, verifyBatchSize)
+	} else {
+		err = verifySample(src, r, sampleSize)
+	}
+	if err != nil {
+		return err
+	}
+
+	if stats := s.stats.Get(); stats != nil {
+		if idxStats := stats.indexes[inst.InstId]; idxStats != nil {
+			idxStats.numVerifyChecked.Add(int64(r.NumChecked))
+			idxStats.numVerifyMissing.Add(int64(r.NumMissing))
+			idxStats.numVerifyExtra.Add(int64(r.NumExtra))
+			idxStats.numVerifyWrong.Add(int64(r.NumWrong))
+		}
+	}
+
+	if r.NumMissing+r.NumExtra+r.NumWrong != 0 {
+		logging.Errorf("%v verifyIndex: index %v:%v found %v missing, %v extra and %v wrong "+
+			"out of %v documents", s.logPrefix, inst.Defn.Bucket, inst.Defn.Name,
+			r.NumMissing, r.NumExtra, r.NumWrong, r.NumChecked)
+		common.Console(clusterAddr, "Index %v:%v verification against KV found %v missing, "+
+			"%v extra and %v wrong entries out of %v documents", inst.Defn.Bucket, inst.Defn.Name,
+			r.NumMissing, r.NumExtra, r.NumWrong, r.NumChecked)
+	}
+
+	return nil
+}
+
+// verifySample verifies the documents sampled from the index, using
+// reservoir sampling, and the documents sampled from the bucket.
+func verifySample(src verifySource, r *IndexVerifyResult, sampleSize int) error {
+
+	var docids []string
+	seen := make(map[string]bool)
+	n := 0
+
+	err := src.scan(func(docid string, entry []byte) error {
+		if seen[docid] {
+			return nil
+		}
+
+		n++
+		if len(docids) < sampleSize {
+			seen[docid] = true
+			docids = append(docids, docid)
+		} else if i := rand.Intn(n); i < sampleSize {
+			delete(seen, docids[i])
+			seen[docid] = true
+			docids[i] = docid
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	docs := make(map[string]*verifyDoc)
+	for _, docid := range docids {
+		docs[docid] = &verifyDoc{docid: docid}
+	}
+	if err := fetchVerifyDocs(src, docs, true); err != nil {
+		return err
+	}
+
+	sampled, err := src.sample(sampleSize)
+	if err != nil {
+		return err
+	}
+	for _, doc := range sampled {
+		docs[doc.docid] = doc
+	}
+
+	if err := collectIndexEntries(src, docs, nil); err != nil {
+		return err
+	}
+	return compareVerifyDocs(src, r, docs)
+}
+
+// verifyAll verifies every document in the index, in batches of about
+// batchSize documents, so that only the documents of a batch are held in
+// memory.  Documents are assigned to batches by hash of docid, and the
+// index is scanned once per batch, both to collect the entries of the
+// documents fetched for the previous batch and to select the documents of
+// the next batch.
+func verifyAll(src verifySource, r *IndexVerifyResult, batchSize int) error {
+
+	total, err := src.count()
+	if err != nil {
+		return err
+	}
+	numBatches := uint32(total/uint64(batchSize)) + 1
+
+	var docs map[string]*verifyDoc
+	for batch := uint32(0); batch <= numBatches; batch++ {
+
+		var next map[string]*verifyDoc
+		if batch < numBatches {
+			next = make(map[string]*verifyDoc)
+		}
+
+		err := collectIndexEntries(src, docs, func(docid string) {
+			if next != nil && verifyDocBatch(docid, numBatches) == batch && next[docid] == nil {
+				next[docid] = &verifyDoc{docid: docid}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := compareVerifyDocs(src, r, docs); err != nil {
+			return err
+		}
+
+		if err := fetchVerifyDocs(src, next, true); err != nil {
+			return err
+		}
+		docs = next
+	}
+
+	return nil
+}
+
+func verifyDocBatch(docid string, numBatches uint32) uint32 {
+	return crc32.ChecksumIEEE([]byte(docid)) % numBatches
+}
+
+// fetchVerifyDocs fetches the documents from the bucket, with up to
+// verifyFetchConcurrency concurrent requests.
+func fetchVerifyDocs(src verifySource, docs map[string]*verifyDoc, eval bool) error {
+
+	docch := make(chan *verifyDoc)
+	errch := make(chan error, 1)
+	donech := make(chan bool)
+
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < verifyFetchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docch {
+				if err := src.fetch(doc, eval); err != nil {
+					once.Do(func() {
+						errch <- err
+						close(donech)
+					})
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, doc := range docs {
+		select {
+		case docch <- doc:
+		case <-donech:
+			break loop
+		}
+	}
+	close(docch)
+	wg.Wait()
+
+	select {
+	case err := <-errch:
+		return err
+	default:
+		return nil
+	}
+}
+
+// collectIndexEntries sets the entries in the index of each document, and
+// calls visit for every document in the index.
+func collectIndexEntries(src verifySource, docs map[string]*verifyDoc, visit func(docid string)) error {
+
+	return src.scan(func(docid string, entry []byte) error {
+		if doc, ok := docs[docid]; ok {
+			if doc.actual == nil {
+				doc.actual = make(map[string]bool)
+			}
+			doc.actual[string(entry)] = true
+		}
+		if visit != nil {
+			visit(docid)
+		}
+		return nil
+	})
+}
+
+// compareVerifyDocs adds the discrepancies between the documents and
+// their entries in the index to the result.  A document that has changed
+// since it was read is not reported.
+func compareVerifyDocs(src verifySource, r *IndexVerifyResult, docs map[string]*verifyDoc) error {
+
+	found := make(map[string]*verifyDoc)
+	checks := make(map[string]*verifyDoc)
+	for docid, doc := range docs {
+		if compareVerifyDoc(doc) != "" {
+			found[docid] = doc
+			checks[docid] = &verifyDoc{docid: docid}
+		}
+	}
+	if err := fetchVerifyDocs(src, checks, false); err != nil {
+		return err
+	}
+
+	r.NumChecked += uint64(len(docs))
+	for docid, doc := range found {
+		check := checks[docid]
+		if check.found != doc.found || check.cas != doc.cas {
+			r.NumChanged++
+			continue
+		}
+
+		typ := compareVerifyDoc(doc)
+		switch typ {
+		case VERIFY_MISSING:
+			r.NumMissing++
+		case VERIFY_EXTRA:
+			r.NumExtra++
+		case VERIFY_WRONG:
+			r.NumWrong++
+		}
+		if len(r.Discrepancies) < verifyMaxDiscrepancies {
+			r.Discrepancies = append(r.Discrepancies, &IndexDiscrepancy{
+				DocId:       doc.docid,
+				Type:        typ,
+				NumExpected: len(doc.expected),
+				NumActual:   len(doc.actual),
+			})
+		}
+	}
+	return nil
+}
+
+func compareVerifyDoc(doc *verifyDoc) string {
+
+	if len(doc.expected) == 0 && len(doc.actual) == 0 {
+		return ""
+	} else if len(doc.expected) == 0 {
+		return VERIFY_EXTRA
+	} else if len(doc.actual) == 0 {
+		return VERIFY_MISSING
+	}
+
+	if len(doc.expected) != len(doc.actual) {
+		return VERIFY_WRONG
+	}
+	for entry := range doc.expected {
+		if !doc.actual[entry] {
+			return VERIFY_WRONG
+		}
+	}
+	return ""
+}
+
+// kvVerifySource verifies an index of this node against the bucket.
+type kvVerifySource struct {
+	s           *scanCoordinator
+	inst        *common.IndexInst
+	bucket      *couchbase.Bucket
+	eval        *verifyEvaluator
+	clusterAddr string
+
+	// evaluator is shared by the concurrent fetches
+	mu sync.Mutex
+}
+
+func (src *kvVerifySource) fetch(doc *verifyDoc, eval bool) error {
+
+	body, _, cas, err := src.bucket.GetsRaw(doc.docid)
+	if err != nil {
+		if mc.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	doc.found, doc.cas = true, cas
+	if eval {
+		src.mu.Lock()
+		doc.expected = src.eval.entries([]byte(doc.docid), body, cas)
+		src.mu.Unlock()
+	}
+	return nil
+}
+
+// Documents of partitioned index can be indexed on other nodes, and are
+// not sampled from the bucket.
+func (src *kvVerifySource) sample(n int) ([]*verifyDoc, error) {
+
+	if common.IsPartitioned(src.inst.Defn.PartitionScheme) {
+		return nil, nil
+	}
+
+	var docs []*verifyDoc
+	for i := 0; i < n; i++ {
+		resp, err := src.bucket.GetRandomDoc()
+		if err != nil {
+			logging.Warnf("%v verifyIndex: stop sampling bucket %v: %v", src.s.logPrefix, src.inst.Defn.Bucket, err)
+			break
+		}
+		doc := &verifyDoc{docid: string(resp.Key), found: true, cas: resp.Cas}
+		doc.expected = src.eval.entries(resp.Key, resp.Body, resp.Cas)
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// The index snapshot is taken at a timestamp later than the document
+// reads so far.
+func (src *kvVerifySource) scan(callb func(docid string, entry []byte) error) error {
+
+	kvTs, err := common.GetCurrentKVTimestamp(src.clusterAddr, src.inst.Defn.Bucket)
+	if err != nil {
+		return err
+	}
+	is, err := src.s.getVerifySnapshot(src.inst.InstId, kvTs)
+	if err != nil {
+		return err
+	}
+	defer DestroyIndexSnapshot(is)
+
+	isPrimary := src.inst.Defn.IsPrimary
+	return src.s.iterateIndexEntries(src.inst.InstId, is, func(entry []byte) error {
+		return callb(readEntryDocId(isPrimary, entry), entry)
+	})
+}
+
+func (src *kvVerifySource) count() (uint64, error) {
+
+	is, err := src.s.getVerifySnapshot(src.inst.InstId, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer DestroyIndexSnapshot(is)
+
+	var total uint64
+	for _, ps := range is.Partitions() {
+		for _, ss := range ps.Slices() {
+			n, err := ss.Snapshot().StatCountTotal()
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// getVerifySnapshot returns the index snapshot at least as recent as ts,
+// the latest snapshot if ts is nil.
+func (s *scanCoordinator) getVerifySnapshot(instId common.IndexInstId,
+	ts *common.TsVbuuid) (IndexSnapshot, error) {
+
+	cons := common.AnyConsistency
+	if ts != nil {
+		cons = common.QueryConsistency
+	}
+
+	snapResch := make(chan interface{}, 1)
+	s.supvMsgch <- &MsgIndexSnapRequest{
+		ts:          ts,
+		cons:        cons,
+		respch:      snapResch,
+		idxInstId:   instId,
+		expiredTime: time.Now().Add(verifySnapshotTimeout),
+	}
+
+	var msg interface{}
+	select {
+	case msg = <-snapResch:
+	case <-time.After(verifySnapshotTimeout):
+		go readDeallocSnapshot(snapResch)
+		return nil, common.ErrScanTimedOut
+	}
+
+	switch m := msg.(type) {
+	case IndexSnapshot:
+		if m != nil {
+			return m, nil
+		}
+	case error:
+		return nil, m
+	}
+	return nil, ErrSnapNotAvailable
+}
+
+// iterateIndexEntries calls callb for every entry in the local partitions
+// of the index snapshot.
+func (s *scanCoordinator) iterateIndexEntries(instId common.IndexInstId, is IndexSnapshot,
+	callb EntryCallback) error {
+
+	s.mu.RLock()
+	partnMap := s.indexPartnMap[instId]
+	s.mu.RUnlock()
+
+	for pid, ps := range is.Partitions() {
+		partnInst, ok := partnMap[pid]
+		if !ok {
+			return ErrNotMyPartition
+		}
+		for sid, ss := range ps.Slices() {
+			err := func() error {
+				ctx := partnInst.Sc.GetSliceById(sid).GetReaderContext()
+				ctx.Init()
+				defer ctx.Done()
+				return ss.Snapshot().All(ctx, callb)
+			}()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readEntryDocId(isPrimary bool, entry []byte) string {
+	var docid []byte
+	if isPrimary {
+		docid, _ = (*primaryIndexEntry)(&entry).ReadDocId(nil)
+	} else {
+		docid, _ = secondaryIndexEntry(entry).ReadDocId(nil)
+	}
+	return string(docid)
+}
+
+// startVerifyIndex starts verification of the index instance in the
+// background.
+func (s *scanCoordinator) startVerifyIndex(instId common.IndexInstId, full bool,
+	sampleSize int) (*IndexVerifyResult, error) {
+
+	s.mu.RLock()
+	inst, ok := s.indexInstMap[instId]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, common.ErrIndexNotFound
+	} else if inst.State != common.INDEX_STATE_ACTIVE {
+		return nil, fmt.Errorf("Index %v is not active", inst.Defn.Name)
+	}
+
+	r, err := s.verifier.begin(&inst, full)
+	if err != nil {
+		return nil, err
+	}
+	result := *r
+
+	desc := fmt.Sprintf("Verify index %v:%v", inst.Defn.Bucket, inst.Defn.Name)
+	task := common.NewTask(common.TaskVerify, desc, nil)
+
+	go func() {
+		work := &IndexVerifyResult{Full: full}
+		err := s.verifyIndex(&inst, work, sampleSize)
+		if err != nil {
+			logging.Errorf("%v verifyIndex: index %v:%v failed: %v", s.logPrefix,
+				inst.Defn.Bucket, inst.Defn.Name, err)
+		}
+		s.verifier.end(r, work, err)
+		task.Done(err)
+	}()
+
+	return &result, nil
+}
+
+// verifyDaemon verifies a sample of each active index, once every
+// configured interval. Indexes are verified one at a time.
+func (s *scanCoordinator) verifyDaemon() {
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.verifyStopCh:
+			return
+		}
+
+		cfg := s.config.Load()
+		interval := time.Duration(cfg["settings.verify.interval"].Int()) * time.Second
+		sampleSize := cfg["settings.verify.sample_size"].Int()
+		if interval == 0 || sampleSize <= 0 || s.getIndexerState() != common.INDEXER_ACTIVE {
+			continue
+		}
+
+		s.mu.RLock()
+		var insts []common.IndexInst
+		for _, inst := range s.indexInstMap {
+			if inst.State == common.INDEX_STATE_ACTIVE && inst.RState == common.REBAL_ACTIVE {
+				insts = append(insts, inst)
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, inst := range insts {
+			if time.Since(s.verifier.lastStart(inst.InstId)) < interval {
+				continue
+			}
+
+			r, err := s.verifier.begin(&inst, false)
+			if err != nil {
+				continue
+			}
+			work := &IndexVerifyResult{}
+			err = s.verifyIndex(&inst, work, sampleSize)
+			if err != nil && err != ErrVerifyNotSupported {
+				logging.Warnf("%v verifyDaemon: index %v:%v failed: %v", s.logPrefix,
+					inst.Defn.Bucket, inst.Defn.Name, err)
+			}
+			s.verifier.end(r, work, err)
+
+			select {
+			case <-s.verifyStopCh:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// handleVerifyIndexReq returns the verification results on GET, and
+// starts verification of the index instance given by instId on POST,
+// sampling sample documents, or all documents if full is true.
+func (s *scanCoordinator) handleVerifyIndexReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	var instId uint64
+	if str := r.FormValue("instId"); len(str) != 0 {
+		if instId, err = strconv.ParseUint(str, 10, 64); err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("Invalid instId " + str + "\n"))
+			return
+		}
+	}
+
+	var data interface{}
+
+	switch r.Method {
+	case "GET":
+		if !common.IsAllowed(creds, []string{"cluster.settings!read"}, w) {
+			return
+		}
+		data = s.verifier.Results(common.IndexInstId(instId))
+
+	case "POST":
+		if !common.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+
+		full := r.FormValue("full") == "true"
+		sampleSize := s.config.Load()["settings.verify.sample_size"].Int()
+		if str := r.FormValue("sample"); len(str) != 0 {
+			if sampleSize, err = strconv.Atoi(str); err != nil || sampleSize <= 0 {
+				w.WriteHeader(400)
+				w.Write([]byte("Invalid sample " + str + "\n"))
+				return
+			}
+		}
+
+		result, err := s.startVerifyIndex(common.IndexInstId(instId), full, sampleSize)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		data = result
+
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("%v\n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(buf)
+}
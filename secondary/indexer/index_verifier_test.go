@@ -0,0 +1,272 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+type fakeVerifyEntry struct {
+	docid string
+	entry string
+}
+
+// fakeVerifySource holds the documents of a bucket, with their expected
+// entries, and the entries of an index.
+type fakeVerifySource struct {
+	mu       sync.Mutex
+	docs     map[string][]string // docid -> expected entries
+	cas      map[string]uint64
+	index    []fakeVerifyEntry
+	fetchErr error
+
+	// changed is called after every fetch, to mutate the bucket
+	changed func(docid string)
+
+	numScans   int
+	maxTracked int
+}
+
+func (src *fakeVerifySource) fetch(doc *verifyDoc, eval bool) error {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.fetchErr != nil {
+		return src.fetchErr
+	}
+	entries, ok := src.docs[doc.docid]
+	if !ok {
+		return nil
+	}
+	doc.found, doc.cas = true, src.cas[doc.docid]
+	if eval && len(entries) != 0 {
+		doc.expected = make(map[string]bool)
+		for _, entry := range entries {
+			doc.expected[entry] = true
+		}
+	}
+	if src.changed != nil {
+		src.changed(doc.docid)
+	}
+	return nil
+}
+
+func (src *fakeVerifySource) sample(n int) ([]*verifyDoc, error) {
+	var docids []string
+	for docid := range src.docs {
+		docids = append(docids, docid)
+	}
+	sort.Strings(docids)
+
+	var docs []*verifyDoc
+	for i := 0; i < n && i < len(docids); i++ {
+		doc := &verifyDoc{docid: docids[i]}
+		if err := src.fetch(doc, true); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (src *fakeVerifySource) scan(callb func(docid string, entry []byte) error) error {
+	src.numScans++
+	for _, e := range src.index {
+		if err := callb(e.docid, []byte(e.entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (src *fakeVerifySource) count() (uint64, error) {
+	return uint64(len(src.index)), nil
+}
+
+func newFakeVerifySource(numDocs int) *fakeVerifySource {
+	src := &fakeVerifySource{
+		docs: make(map[string][]string),
+		cas:  make(map[string]uint64),
+	}
+	for i := 0; i < numDocs; i++ {
+		docid := fmt.Sprintf("doc-%03d", i)
+		entry := fmt.Sprintf("key-%03d", i)
+		src.docs[docid] = []string{entry}
+		src.cas[docid] = 1
+		src.index = append(src.index, fakeVerifyEntry{docid, entry})
+	}
+	return src
+}
+
+func (src *fakeVerifySource) corrupt() {
+	// doc-001 is missing in the index, doc-002 has a wrong key, and the
+	// index has an entry for doc-999 which does not exist.
+	index := src.index[:0]
+	for _, e := range src.index {
+		switch e.docid {
+		case "doc-001":
+			continue
+		case "doc-002":
+			e.entry = "key-xxx"
+		}
+		index = append(index, e)
+	}
+	src.index = append(index, fakeVerifyEntry{"doc-999", "key-999"})
+}
+
+func discrepancyTypes(r *IndexVerifyResult) map[string]string {
+	types := make(map[string]string)
+	for _, d := range r.Discrepancies {
+		types[d.DocId] = d.Type
+	}
+	return types
+}
+
+func TestVerifyAll(t *testing.T) {
+	src := newFakeVerifySource(100)
+	src.corrupt()
+
+	r := &IndexVerifyResult{Full: true}
+	if err := verifyAll(src, r, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// doc-001 is not in the index, and is not checked in full mode.
+	if r.NumChecked != 100 {
+		t.Errorf("expected 100 documents checked, got %v", r.NumChecked)
+	}
+	if r.NumMissing != 0 || r.NumWrong != 1 || r.NumExtra != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	types := discrepancyTypes(r)
+	if types["doc-002"] != VERIFY_WRONG || types["doc-999"] != VERIFY_EXTRA {
+		t.Errorf("unexpected discrepancies %v", types)
+	}
+	if r.NumChanged != 0 {
+		t.Errorf("unexpected changed documents %v", r.NumChanged)
+	}
+
+	// one scan for each of the 11 batches, and one for the last batch.
+	if src.numScans != 12 {
+		t.Errorf("expected 12 scans, got %v", src.numScans)
+	}
+}
+
+func TestVerifyAllBatches(t *testing.T) {
+	src := newFakeVerifySource(1000)
+
+	maxBatch := 0
+	for batch := uint32(0); batch < 11; batch++ {
+		n := 0
+		for _, e := range src.index {
+			if verifyDocBatch(e.docid, 11) == batch {
+				n++
+			}
+		}
+		if n > maxBatch {
+			maxBatch = n
+		}
+	}
+	if maxBatch > 200 {
+		t.Errorf("expected documents to be spread across batches, got %v in a batch", maxBatch)
+	}
+
+	r := &IndexVerifyResult{Full: true}
+	if err := verifyAll(src, r, 100); err != nil {
+		t.Fatal(err)
+	}
+	if r.NumChecked != 1000 || len(r.Discrepancies) != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestVerifySample(t *testing.T) {
+	src := newFakeVerifySource(10)
+	src.corrupt()
+
+	r := &IndexVerifyResult{}
+	if err := verifySample(src, r, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	types := discrepancyTypes(r)
+	expected := map[string]string{
+		"doc-001": VERIFY_MISSING,
+		"doc-002": VERIFY_WRONG,
+		"doc-999": VERIFY_EXTRA,
+	}
+	for docid, typ := range expected {
+		if types[docid] != typ {
+			t.Errorf("expected %v to be %v, got %v", docid, typ, types[docid])
+		}
+	}
+	if r.NumMissing != 1 || r.NumWrong != 1 || r.NumExtra != 1 || r.NumChecked != 11 {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestVerifyChangedDoc(t *testing.T) {
+	src := newFakeVerifySource(10)
+	src.corrupt()
+
+	// doc-002 is updated after it is read.
+	src.changed = func(docid string) {
+		if docid == "doc-002" {
+			src.cas[docid]++
+		}
+	}
+
+	r := &IndexVerifyResult{}
+	if err := verifySample(src, r, 100); err != nil {
+		t.Fatal(err)
+	}
+	if r.NumWrong != 0 || r.NumChanged != 1 {
+		t.Errorf("expected changed document not to be reported, got %+v", r)
+	}
+}
+
+func TestVerifyFetchError(t *testing.T) {
+	src := newFakeVerifySource(100)
+	src.fetchErr = errors.New("timeout")
+
+	if err := verifyAll(src, &IndexVerifyResult{Full: true}, 10); err != src.fetchErr {
+		t.Errorf("expected %v, got %v", src.fetchErr, err)
+	}
+}
+
+func TestIndexVerifierResults(t *testing.T) {
+	v := newIndexVerifier()
+	inst := &common.IndexInst{InstId: 1, Defn: common.IndexDefn{Bucket: "default", Name: "idx"}}
+
+	r, err := v.begin(inst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.begin(inst, false); err != ErrVerifyInProgress {
+		t.Errorf("expected %v, got %v", ErrVerifyInProgress, err)
+	}
+
+	v.end(r, &IndexVerifyResult{NumChecked: 10, NumWrong: 1}, nil)
+	results := v.Results(1)
+	if len(results) != 1 || results[0].Running || results[0].NumChecked != 10 || results[0].NumWrong != 1 {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	v.forget(common.IndexInstMap{})
+	if results := v.Results(0); len(results) != 0 {
+		t.Errorf("expected results of dropped index to be removed, got %v", results)
+	}
+}
@@ -41,10 +41,6 @@ import (
 	"github.com/couchbase/indexing/secondary/stubs/nitro/plasma"
 )
 
-type Indexer interface {
-	Shutdown() Message
-}
-
 var StreamAddrMap StreamAddressMap
 var StreamTopicName map[common.StreamId]string
 var ServiceAddrMap map[string]string
@@ -101,6 +97,8 @@ type indexer struct {
 	//TODO Remove this once cbq bridge support goes away
 	bucketCreateClientChMap map[string]MsgChannel
 
+	buildTasks map[common.IndexInstId]*common.Task //task of index instances being built
+
 	wrkrRecvCh          MsgChannel //channel to receive messages from workers
 	internalRecvCh      MsgChannel //buffered channel to queue worker requests
 	adminRecvCh         MsgChannel //channel to receive admin messages
@@ -134,6 +132,8 @@ type indexer struct {
 	settingsMgr   settingsManager
 	statsMgr      *statsManager
 	scanCoord     ScanCoordinator //handle to ScanCoordinator
+	alerts        *alertNotifier  //handle to alert notifier
+	streamEvents  *streamEventLog //recent stream events for diagnostics
 	config        common.Config
 
 	kvlock    sync.Mutex   //fine-grain lock for KVSender
@@ -144,6 +144,8 @@ type indexer struct {
 	enableManager bool
 	cpuProfFd     *os.File
 
+	deps IndexerDeps //injected dependencies
+
 	rebalanceRunning bool
 	rebalanceToken   *RebalanceToken
 
@@ -177,9 +179,20 @@ type pruneSpec struct {
 	partitions []common.PartitionId
 }
 
-func NewIndexer(config common.Config) (Indexer, Message) {
+//newIndexer creates the indexer with the given dependencies, starts all
+//the workers and bootstraps the indexer. Caller runs the main indexer loop.
+func newIndexer(config common.Config, deps IndexerDeps) (*indexer, Message) {
+
+	if deps.Clock == nil {
+		deps.Clock = common.SystemClock{}
+	}
+	if deps.NewSlice == nil {
+		deps.NewSlice = NewSlice
+	}
 
 	idx := &indexer{
+		deps: deps,
+
 		wrkrRecvCh:          make(MsgChannel, WORKER_RECV_QUEUE_LEN),
 		internalRecvCh:      make(MsgChannel, WORKER_MSG_QUEUE_LEN),
 		adminRecvCh:         make(MsgChannel, WORKER_MSG_QUEUE_LEN),
@@ -218,6 +231,7 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		bucketBuildTs:                make(map[string]Timestamp),
 		bucketRollbackTimes:          make(map[string]int64),
 		bucketCreateClientChMap:      make(map[string]MsgChannel),
+		buildTasks:                   make(map[common.IndexInstId]*common.Task),
 	}
 
 	logging.Infof("Indexer::NewIndexer Status Warmup")
@@ -231,6 +245,15 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 
 	idx.stats = NewIndexerStats()
 	idx.initFromConfig()
+	idx.alerts = newAlertNotifier(idx.config,
+		splitAlertAllowlist(config[alertAllowlistConfig].String()))
+	idx.streamEvents = newStreamEventLog(idx.config["settings.diagnostics.stream_events"].Int())
+
+	// tasks running before restart are reported as interrupted
+	tasksFile := filepath.Join(idx.config["storage_dir"].String(), "indexer.tasks")
+	if err := common.LoadTasks(tasksFile); err != nil {
+		logging.Errorf("Indexer::NewIndexer Fail to load tasks from %v: %v", tasksFile, err)
+	}
 
 	logging.Infof("Indexer::NewIndexer Starting with Vbuckets %v", idx.config["numVbuckets"].Int())
 
@@ -242,14 +265,18 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 	}
 
 	//Start KV Sender
-	idx.kvSender, res = NewKVSender(idx.kvSenderCmdCh, idx.wrkrRecvCh, idx.config)
+	idx.kvSender, res = NewKVSender(idx.kvSenderCmdCh, idx.wrkrRecvCh, idx.config, idx.deps.Projectors)
 	if res.GetMsgType() != MSG_SUCCESS {
 		logging.Fatalf("Indexer::NewIndexer KVSender Init Error %+v", res)
 		return nil, res
 	}
 
+	//vbuckets missing from streams, tracked by the timekeeper and
+	//skipped by partial scans
+	missingVbs := newMissingVbs()
+
 	//Start Timekeeper
-	idx.tk, res = NewTimekeeper(idx.tkCmdCh, idx.wrkrRecvCh, idx.config)
+	idx.tk, res = NewTimekeeper(idx.tkCmdCh, idx.wrkrRecvCh, idx.config, missingVbs)
 	if res.GetMsgType() != MSG_SUCCESS {
 		logging.Fatalf("Indexer::NewIndexer Timekeeper Init Error %+v", res)
 		return nil, res
@@ -257,14 +284,14 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 
 	//Start Scan Coordinator
 	snapshotNotifych := make(chan IndexSnapshot, 100)
-	idx.scanCoord, res = NewScanCoordinator(idx.scanCoordCmdCh, idx.wrkrRecvCh, idx.config, snapshotNotifych)
+	idx.scanCoord, res = NewScanCoordinator(idx.scanCoordCmdCh, idx.wrkrRecvCh, idx.config, snapshotNotifych, missingVbs)
 	if res.GetMsgType() != MSG_SUCCESS {
 		logging.Fatalf("Indexer::NewIndexer Scan Coordinator Init Error %+v", res)
 		return nil, res
 	}
 
 	// Start compaction manager
-	idx.compactMgr, res = NewCompactionManager(idx.compactMgrCmdCh, idx.wrkrRecvCh, idx.config)
+	idx.compactMgr, res = newCompactionManager(idx.compactMgrCmdCh, idx.wrkrRecvCh, idx.config, idx.deps.Clock)
 	if res.GetMsgType() != MSG_SUCCESS {
 		logging.Fatalf("Indexer::NewCompactionmanager Init Error %+v", res)
 		return nil, res
@@ -318,9 +345,6 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		return nil, res
 	}
 
-	//start the main indexer loop
-	idx.run()
-
 	return idx, &MsgSuccess{}
 
 }
@@ -340,7 +364,7 @@ func (idx *indexer) initFromConfig() {
 	idx.initStreamFlushMap()
 	idx.initServiceAddressMap()
 
-	idx.enableManager = idx.config["enableManager"].Bool()
+	idx.enableManager = idx.config["enableManager"].Bool() && !idx.deps.DisableAdmin
 
 	isEnterprise := idx.config["isEnterprise"].Bool()
 	if isEnterprise {
@@ -678,6 +702,10 @@ func (idx *indexer) listenWorkerMsgs() {
 
 func (idx *indexer) handleWorkerMsgs(msg Message) {
 
+	if isStreamEvent(msg.GetMsgType()) {
+		idx.streamEvents.add(msg)
+	}
+
 	switch msg.GetMsgType() {
 
 	case STREAM_READER_HWT:
@@ -786,6 +814,9 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 	case TK_MERGE_STREAM:
 		idx.handleMergeStream(msg)
 
+	case TK_VB_QUARANTINE:
+		idx.handleVbQuarantine(msg)
+
 	case INDEXER_PREPARE_RECOVERY:
 		idx.handlePrepareRecovery(msg)
 
@@ -798,6 +829,15 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 		idx.storageMgrCmdCh <- msg
 		<-idx.storageMgrCmdCh
 
+	case CLUST_MGR_UPDATE_SLICE_LAYOUT:
+		//partition relocated by compaction manager
+		msg := msg.(*MsgClustMgrUpdateSliceLayout)
+		if inst, ok := idx.indexInstMap[msg.GetInstId()]; ok {
+			if partnInst, ok := idx.indexPartnMap[inst.InstId][msg.GetPartitionId()]; ok {
+				idx.updateSliceLayout(inst, PartitionInstMap{msg.GetPartitionId(): partnInst})
+			}
+		}
+
 	case CONFIG_SETTINGS_UPDATE:
 		idx.handleConfigUpdate(msg)
 
@@ -828,7 +868,7 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 	case STREAM_REQUEST_DONE:
 		idx.handleStreamRequestDone(msg)
 
-	case KV_SENDER_RESTART_VBUCKETS:
+	case KV_SENDER_RESTART_VBUCKETS, KV_SENDER_ACK_TIMESTAMPS:
 
 		//fwd the message to kv_sender
 		idx.sendMsgToKVSender(msg)
@@ -849,6 +889,9 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 		idx.clustMgrAgentCmdCh <- msg
 		<-idx.clustMgrAgentCmdCh
 
+	case TK_INGEST_LAG:
+		idx.handleIngestLag(msg)
+
 	case INDEXER_BUCKET_NOT_FOUND:
 		idx.handleBucketNotFound(msg)
 
@@ -863,7 +906,7 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 		//crash for all errors by default
 		logging.Fatalf("Indexer::handleWorkerMsgs Fatal Error On Worker Channel %+v", msg)
 		err := msg.(*MsgError).GetError()
-		common.CrashOnError(err.cause)
+		idx.crashOnFatalError(err)
 
 	case STATS_RESET:
 		idx.handleResetStats()
@@ -1023,6 +1066,7 @@ func (idx *indexer) handleConfigUpdate(msg Message) {
 	memdb.Debug(idx.config["settings.moi.debug"].Bool())
 	idx.setProfilerOptions(newConfig)
 	idx.config = newConfig
+	idx.alerts.setConfig(newConfig)
 	idx.compactMgrCmdCh <- msg
 	<-idx.compactMgrCmdCh
 	idx.tkCmdCh <- msg
@@ -1068,7 +1112,7 @@ func (idx *indexer) handleAdminMsgs(msg Message) {
 
 		logging.Fatalf("Indexer::handleAdminMsgs Fatal Error On Admin Channel %+v", msg)
 		err := msg.(*MsgError).GetError()
-		common.CrashOnError(err.cause)
+		idx.crashOnFatalError(err)
 
 	default:
 		logging.Errorf("Indexer::handleAdminMsgs Unknown Message %+v", msg)
@@ -1227,16 +1271,35 @@ func (idx *indexer) handleCreateIndex(msg Message) {
 		}
 	}
 
+	//document metadata is kept only by forestdb slices
+	if indexInst.Defn.DocMeta && common.GetStorageMode() != common.FORESTDB {
+		errStr := fmt.Sprintf("Cannot Create Index with doc_meta. Indexer "+
+			"Storage Mode %v", common.GetStorageMode())
+
+		logging.Errorf(errStr)
+
+		if clientCh != nil {
+			clientCh <- &MsgError{
+				err: Error{severity: FATAL,
+					cause:    errors.New(errStr),
+					category: INDEXER}}
+
+		}
+		return
+	}
+
 	partitions := indexInst.Pc.GetAllPartitions()
 	for _, partnDefn := range partitions {
 		idx.stats.AddPartition(indexInst.InstId, indexInst.Defn.Bucket, indexInst.Defn.Name, indexInst.ReplicaId, partnDefn.GetPartitionId())
 	}
+	idx.stats.GetBucketDDLStats(indexInst.Defn.Bucket).numCreateIndex.Add(1)
 
 	//allocate partition/slice
 	var partnInstMap PartitionInstMap
 	if partnInstMap, _, err = idx.initPartnInstance(indexInst, clientCh, false); err != nil {
 		return
 	}
+	idx.updateSliceLayout(indexInst, partnInstMap)
 
 	// update rollback time for the bucket
 	if _, ok := idx.bucketRollbackTimes[indexInst.Defn.Bucket]; !ok {
@@ -2316,6 +2379,8 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 			logging.Infof("Indexer::handleBuildIndex Bucket %v validation successful", bucket)
 		}
 
+		idx.stats.GetBucketDDLStats(bucket).numBuildIndex.Add(int64(len(instIdList)))
+
 		if ok := idx.checkBucketInRecovery(bucket, instIdList, clientCh, errMap); ok {
 			logging.Errorf("Indexer::handleBuildIndex \n\tCannot Process Build Index "+
 				"In Recovery Mode. Bucket %v. Index In Error %v", bucket, errMap)
@@ -2511,6 +2576,7 @@ func (idx *indexer) handleDropIndex(msg Message) {
 
 	}
 
+	idx.stats.GetBucketDDLStats(indexInst.Defn.Bucket).numDropIndex.Add(1)
 	idx.stats.RemoveIndex(indexInst.InstId)
 
 	//if the index state is Created/Ready/Deleted, only data cleanup is
@@ -3179,14 +3245,18 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 
 	respCh := make(MsgChannel)
 
+	// indexes bootstrapped from snapshots shipped by a peer only need
+	// to catch up from the snapshot.
+	restartTs := idx.shippedSnapshotRestartTs(instIdList)
+
 	cmd = &MsgStreamUpdate{mType: OPEN_STREAM,
 		streamId:           buildStream,
 		bucket:             bucket,
 		indexList:          indexList,
 		buildTs:            buildTs,
 		respCh:             respCh,
-		restartTs:          nil,
-		allowMarkFirstSnap: true,
+		restartTs:          restartTs,
+		allowMarkFirstSnap: restartTs == nil,
 		rollbackTime:       idx.bucketRollbackTimes[bucket]}
 
 	//send stream update to timekeeper
@@ -3485,7 +3555,7 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 			indexInst.InstId, partnInst)
 
 		//add a single slice per partition for now
-		if slice, err := NewSlice(SliceId(0), &indexInst, &partnInst, idx.config, idx.stats); err == nil {
+		if slice, err := idx.deps.NewSlice(SliceId(0), &indexInst, &partnInst, idx.config, idx.stats); err == nil {
 			partnInst.Sc.AddSlice(0, slice)
 			logging.Infof("Indexer::initPartnInstance Initialized Slice: \n\t Index: %v Slice: %v",
 				indexInst.InstId, slice)
@@ -3517,9 +3587,38 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 	return partnInstMap, failedPartnInstances, nil
 }
 
+// updateSliceLayout records the storage path of the slices of the
+// partitions in the index topology. Every slice owns all the vbuckets
+// of its partition. Failure is logged, the layout is recorded again
+// when the slice is next opened or relocated.
+func (idx *indexer) updateSliceLayout(indexInst common.IndexInst, partnInstMap PartitionInstMap) {
+
+	if !idx.enableManager {
+		return
+	}
+
+	for partnId, partnInst := range partnInstMap {
+		for _, slice := range partnInst.Sc.GetAllSlices() {
+			msg := &MsgClustMgrUpdateSliceLayout{
+				defn:     indexInst.Defn,
+				instId:   indexInst.InstId,
+				partnId:  partnId,
+				sliceId:  slice.Id(),
+				hostPath: slice.Path(),
+			}
+			if err := idx.sendMsgToClusterMgr(msg); err != nil {
+				logging.Errorf("Indexer::updateSliceLayout %v %v Error updating slice layout %v",
+					indexInst.InstId, partnId, err)
+			}
+		}
+	}
+}
+
 func (idx *indexer) distributeIndexMapsToWorkers(msgUpdateIndexInstMap Message,
 	msgUpdateIndexPartnMap Message) error {
 
+	idx.updateBuildTasks()
+
 	//update index map in storage manager
 	if err := idx.sendUpdatedIndexMapToWorker(msgUpdateIndexInstMap, msgUpdateIndexPartnMap, idx.storageMgrCmdCh,
 		"StorageMgr"); err != nil {
@@ -3561,6 +3660,44 @@ func (idx *indexer) distributeIndexMapsToWorkers(msgUpdateIndexInstMap Message,
 	return nil
 }
 
+// updateBuildTasks registers a build task for index instances being
+// built, and completes the task once the instance is active, or when
+// the build is aborted.
+func (idx *indexer) updateBuildTasks() {
+
+	for instId, task := range idx.buildTasks {
+		inst, ok := idx.indexInstMap[instId]
+		switch {
+		case !ok || inst.State == common.INDEX_STATE_DELETED:
+			task.Done(errors.New("Index dropped during build"))
+		case inst.State == common.INDEX_STATE_ACTIVE:
+			task.Done(nil)
+		case inst.State == common.INDEX_STATE_INITIAL || inst.State == common.INDEX_STATE_CATCHUP:
+			continue
+		default:
+			task.Done(fmt.Errorf("Index build aborted. %v", inst.Error))
+		}
+		delete(idx.buildTasks, instId)
+	}
+
+	for instId, inst := range idx.indexInstMap {
+		if inst.State != common.INDEX_STATE_INITIAL && inst.State != common.INDEX_STATE_CATCHUP {
+			continue
+		}
+		if _, ok := idx.buildTasks[instId]; ok {
+			continue
+		}
+		desc := fmt.Sprintf("Build index %v:%v", inst.Defn.Bucket, inst.Defn.Name)
+		task := common.NewTask(common.TaskBuild, desc, nil)
+		if idxStats, ok := idx.stats.indexes[instId]; ok {
+			task.SetProgressFunc(func() float64 {
+				return float64(idxStats.buildProgress.Value())
+			})
+		}
+		idx.buildTasks[instId] = task
+	}
+}
+
 func (idx *indexer) sendUpdatedIndexMapToWorker(msgUpdateIndexInstMap Message,
 	msgUpdateIndexPartnMap Message, workerCmdCh chan Message, workerStr string) error {
 
@@ -3968,6 +4105,113 @@ func (idx *indexer) handleInitialBuildDone(msg Message) {
 
 }
 
+//vbQuarantineErrPrefix prefixes the error of indexes with quarantined
+//vbuckets, to clear only that error once the vbuckets recover.
+const vbQuarantineErrPrefix = "Index is partially available."
+
+//handleVbQuarantine marks the indexes of a stream and bucket as partially
+//available, when vbuckets of the stream are no longer repaired, and as
+//available again once all of them have recovered.
+func (idx *indexer) handleVbQuarantine(msg Message) {
+
+	bucket := msg.(*MsgTKVbQuarantine).GetBucket()
+	streamId := msg.(*MsgTKVbQuarantine).GetStreamId()
+	vbList := msg.(*MsgTKVbQuarantine).GetVbList()
+
+	if len(vbList) == 0 {
+		idx.handleVbQuarantineRecovery(streamId, bucket)
+		return
+	}
+
+	errStr := fmt.Sprintf("%v Vbuckets %v of %v are quarantined after "+
+		"repeated repair failures.", vbQuarantineErrPrefix, vbList, streamId)
+
+	var instIdList []common.IndexInstId
+	for instId, inst := range idx.indexInstMap {
+		if inst.Defn.Bucket == bucket && inst.Stream == streamId &&
+			inst.State != common.INDEX_STATE_DELETED {
+			inst.Error = errStr
+			idx.indexInstMap[instId] = inst
+			instIdList = append(instIdList, instId)
+
+			common.Console(idx.config["clusterAddr"].String(),
+				"Index %v on bucket %v is partially available. Vbuckets %v are quarantined "+
+					"after repeated repair failures.", inst.Defn.Name, bucket, vbList)
+		}
+	}
+
+	logging.Errorf("Indexer::handleVbQuarantine Stream %v Bucket %v Vbuckets %v "+
+		"quarantined. Indexes %v", streamId, bucket, vbList, instIdList)
+
+	idx.alerts.notify(&Alert{
+		Type:   ALERT_STREAM_QUARANTINE,
+		Bucket: bucket,
+		Message: fmt.Sprintf("Vbuckets %v of %v quarantined after repeated repair "+
+			"failures. Indexes %v are partially available.", vbList, streamId, instIdList),
+	})
+
+	if len(instIdList) != 0 {
+		if err := idx.updateMetaInfoForIndexList(instIdList, false, false, true,
+			false, false, false, false, false, nil); err != nil {
+			logging.Errorf("Indexer::handleVbQuarantine Error updating metadata %v", err)
+		}
+	}
+}
+
+//handleVbQuarantineRecovery clears the error of indexes of a stream and
+//bucket, set when their vbuckets were quarantined.
+func (idx *indexer) handleVbQuarantineRecovery(streamId common.StreamId, bucket string) {
+
+	var instIdList []common.IndexInstId
+	for instId, inst := range idx.indexInstMap {
+		if inst.Defn.Bucket == bucket && inst.Stream == streamId &&
+			strings.HasPrefix(inst.Error, vbQuarantineErrPrefix) {
+			inst.Error = ""
+			idx.indexInstMap[instId] = inst
+			instIdList = append(instIdList, instId)
+
+			common.Console(idx.config["clusterAddr"].String(),
+				"Index %v on bucket %v is available. Quarantined vbuckets have recovered.",
+				inst.Defn.Name, bucket)
+		}
+	}
+
+	logging.Infof("Indexer::handleVbQuarantineRecovery Stream %v Bucket %v "+
+		"quarantined vbuckets recovered. Indexes %v", streamId, bucket, instIdList)
+
+	if len(instIdList) != 0 {
+		if err := idx.updateMetaInfoForIndexList(instIdList, false, false, true,
+			false, false, false, false, false, nil); err != nil {
+			logging.Errorf("Indexer::handleVbQuarantineRecovery Error updating metadata %v", err)
+		}
+	}
+}
+
+func (idx *indexer) handleIngestLag(msg Message) {
+
+	lagMsg := msg.(*MsgTKIngestLag)
+	inst := lagMsg.GetIndexInst()
+
+	alert := &Alert{
+		Type:     ALERT_INGEST_LAG,
+		Bucket:   inst.Defn.Bucket,
+		Index:    inst.Defn.Name,
+		InstId:   uint64(inst.InstId),
+		Resolved: lagMsg.IsCleared(),
+	}
+	if lagMsg.IsCleared() {
+		alert.Message = fmt.Sprintf("Ingest lag recovered to %v seqnos.", lagMsg.GetLag())
+	} else {
+		alert.Message = fmt.Sprintf("Ingest lag of %v seqnos since %v.",
+			lagMsg.GetLag(), lagMsg.GetSince().Format(time.RFC3339))
+	}
+	idx.alerts.notify(alert)
+
+	//fwd the message to cluster manager agent
+	idx.clustMgrAgentCmdCh <- msg
+	<-idx.clustMgrAgentCmdCh
+}
+
 func (idx *indexer) handleMergeStream(msg Message) {
 
 	bucket := msg.(*MsgTKMergeStream).GetBucket()
@@ -4869,11 +5113,19 @@ func (idx *indexer) initFromPersistedState() (bool, error) {
 		if partnInstMap, failedPartnInstances, err = idx.initPartnInstance(inst, nil, true); err != nil {
 			return needsRestart, err
 		}
+		idx.updateSliceLayout(inst, partnInstMap)
 
 		// Cleanup all partition instances for which, initPartnInstance has failed due to storage corruption
 		for failedPartnId, failedPartnInstance := range failedPartnInstances {
 			logMsg := "Detected storage corruption for index %v, partition id %v. Starting cleanup."
 			common.Console(idx.config["clusterAddr"].String(), logMsg, inst.Defn.Name, failedPartnId)
+			idx.alerts.notify(&Alert{
+				Type:    ALERT_CORRUPTION,
+				Bucket:  inst.Defn.Bucket,
+				Index:   inst.Defn.Name,
+				InstId:  uint64(inst.InstId),
+				Message: fmt.Sprintf("Storage corruption detected in partition %v.", failedPartnId),
+			})
 
 			logging.Infof("Indexer::initFromPersistedState Starting cleanup for %v", failedPartnInstance)
 			// Can this return an error?
@@ -5129,12 +5381,9 @@ func (idx *indexer) upgradeSingleIndex(inst *common.IndexInst, storageMode commo
 	inst.Error = ""
 
 	// remove old files
-	storage_dir := idx.config["storage_dir"].String()
-
 	partnDefnList := inst.Pc.GetAllPartitions()
 	for _, partnDefn := range partnDefnList {
-		path := filepath.Join(storage_dir, IndexPath(inst, partnDefn.GetPartitionId(), SliceId(0)))
-		if err := os.RemoveAll(path); err != nil {
+		if err := removeSlicePaths(idx.config, inst, partnDefn.GetPartitionId(), SliceId(0)); err != nil {
 			common.CrashOnError(err)
 		}
 	}
@@ -5293,9 +5542,7 @@ func (idx *indexer) forceCleanupIndexData(inst *common.IndexInst, sliceId SliceI
 //been initialized
 func (idx *indexer) forceCleanupPartitionData(inst *common.IndexInst, partitionId common.PartitionId, sliceId SliceId) error {
 
-	storage_dir := idx.config["storage_dir"].String()
-	path := filepath.Join(storage_dir, IndexPath(inst, partitionId, sliceId))
-	return os.RemoveAll(path)
+	return removeSlicePaths(idx.config, inst, partitionId, sliceId)
 }
 
 //On recovery, deleted indexes are ignored. There can be
@@ -5906,7 +6153,7 @@ func NewSlice(id SliceId, indInst *common.IndexInst, partnInst *PartitionInst,
 	if _, e := os.Stat(storage_dir); e != nil {
 		common.CrashOnError(e)
 	}
-	path := filepath.Join(storage_dir, IndexPath(indInst, partnInst.Defn.GetPartitionId(), id))
+	path := SlicePath(conf, indInst, partnInst.Defn.GetPartitionId(), id)
 
 	ephemeral, err := IsEphemeral(conf["clusterAddr"].String(), indInst.Defn.Bucket)
 	if err != nil {
@@ -6164,12 +6411,23 @@ func (idx *indexer) monitorMemUsage() {
 					!canResume && mem_used > min_oom_mem {
 					idx.internalRecvCh <- &MsgIndexerState{mType: INDEXER_PAUSE}
 					canResume = true
+					idx.alerts.notify(&Alert{
+						Type: ALERT_QUOTA_BREACH,
+						Message: fmt.Sprintf("Memory used %v is above %v of memory quota %v. "+
+							"Indexer paused.", mem_used, high_mem_mark, memory_quota),
+					})
 				}
 
 			case common.INDEXER_PAUSED:
 				if float64(mem_used) < (low_mem_mark*float64(memory_quota)) && canResume {
 					idx.internalRecvCh <- &MsgIndexerState{mType: INDEXER_RESUME}
 					canResume = false
+					idx.alerts.notify(&Alert{
+						Type:     ALERT_QUOTA_BREACH,
+						Resolved: true,
+						Message: fmt.Sprintf("Memory used %v is below %v of memory quota %v. "+
+							"Indexer resumed.", mem_used, low_mem_mark, memory_quota),
+					})
 				}
 			}
 		} else if common.GetStorageMode() == common.FORESTDB {
@@ -6184,6 +6442,9 @@ func (idx *indexer) monitorMemUsage() {
 
 		}
 
+		idx.updateMemPressure()
+		idx.updateCommitThrottle()
+
 		time.Sleep(time.Second * time.Duration(monitorInterval))
 	}
 
@@ -6619,6 +6880,10 @@ func (idx *indexer) getStorageModeOverride(config common.Config) common.StorageM
 
 func (idx *indexer) getBootstrapStorageMode(config common.Config) common.StorageMode {
 
+	if idx.deps.StorageMode != common.NOT_SET {
+		return idx.deps.StorageMode
+	}
+
 	nodeUUID := config["nodeuuid"].String()
 	s, err := mc.GetIndexerLocalStorageMode(nodeUUID)
 	if s == common.NOT_SET || err != nil {
@@ -37,35 +37,78 @@ const (
 type KVSender interface {
 }
 
+//StreamAdmin is the admin API of a projector used to manage the mutation
+//streams of the indexer. It is implemented by the projector client, and
+//by FakeProjector for tests.
+type StreamAdmin interface {
+	GetFailoverLogs(pooln, bucketn string,
+		vbnos []uint32) (*protobuf.FailoverLogResponse, error)
+	MutationTopicRequest(topic, endpointType string,
+		reqTimestamps []*protobuf.TsVbuuid, instances []*protobuf.Instance,
+		requestId string) (*protobuf.TopicResponse, error)
+	RestartVbuckets(topic string,
+		restartTimestamps []*protobuf.TsVbuuid) (*protobuf.TopicResponse, error)
+	ShutdownVbuckets(topic string, shutdownTimestamps []*protobuf.TsVbuuid) error
+	AddInstances(topic string, instances []*protobuf.Instance,
+		requestId string) (*protobuf.TimestampResponse, error)
+	DelInstances(topic string, uuids []uint64) error
+	DelBuckets(topic string, buckets []string) error
+	ShutdownTopic(topic string) error
+	AckTimestamps(topic, endpoint string, ackTimestamps []*protobuf.TsVbuuid) error
+}
+
+//ProjectorCluster locates the projectors of the cluster, and returns
+//their StreamAdmin. It is implemented over ClusterInfoCache and the
+//projector client, and by FakeProjector for tests.
+type ProjectorCluster interface {
+	//StreamAdmin returns the StreamAdmin of projector at addr.
+	StreamAdmin(addr string) StreamAdmin
+	//GetLocalHostname returns the host of this indexer node, on which
+	//its stream endpoints listen.
+	GetLocalHostname() (string, error)
+	//GetAllVbuckets returns the vbuckets of bucket, and the projectors
+	//of the KV nodes owning them.
+	GetAllVbuckets(bucket string) ([]uint32, []string, error)
+	//GetAllProjectorAddrs returns the projectors of all KV nodes.
+	GetAllProjectorAddrs() ([]string, error)
+	//GetProjAddrsForVbuckets returns the projectors of the KV nodes
+	//owning any of vbnos.
+	GetProjAddrsForVbuckets(bucket string, vbnos []uint16) ([]string, error)
+}
+
 type kvSender struct {
 	supvCmdch  MsgChannel //supervisor sends commands on this channel
 	supvRespch MsgChannel //channel to send any message to supervisor
 
-	cInfoCache *c.ClusterInfoCache
+	projectors ProjectorCluster
 	config     c.Config
 }
 
 func NewKVSender(supvCmdch MsgChannel, supvRespch MsgChannel,
-	config c.Config) (KVSender, Message) {
+	config c.Config, projectors ProjectorCluster) (KVSender, Message) {
 
-	var cinfo *c.ClusterInfoCache
-	url, err := c.ClusterAuthUrl(config["clusterAddr"].String())
-	if err == nil {
-		cinfo, err = c.NewClusterInfoCache(url, DEFAULT_POOL)
-	}
-	if err != nil {
-		panic("Unable to initialize cluster_info - " + err.Error())
+	if projectors == nil {
+		var cinfo *c.ClusterInfoCache
+		url, err := c.ClusterAuthUrl(config["clusterAddr"].String())
+		if err == nil {
+			cinfo, err = c.NewClusterInfoCache(url, DEFAULT_POOL)
+		}
+		if err != nil {
+			panic("Unable to initialize cluster_info - " + err.Error())
+		}
+		cinfo.SetMaxRetries(MAX_CLUSTER_FETCH_RETRY)
+		cinfo.SetLogPrefix("KVSender: ")
+		projectors = &clusterInfoProjectors{cinfo: cinfo}
 	}
+
 	//Init the kvSender struct
 	k := &kvSender{
 		supvCmdch:  supvCmdch,
 		supvRespch: supvRespch,
-		cInfoCache: cinfo,
+		projectors: projectors,
 		config:     config,
 	}
 
-	k.cInfoCache.SetMaxRetries(MAX_CLUSTER_FETCH_RETRY)
-	k.cInfoCache.SetLogPrefix("KVSender: ")
 	//start kvsender loop which listens to commands from its supervisor
 	go k.run()
 
@@ -121,6 +164,9 @@ func (k *kvSender) handleSupvervisorCommands(cmd Message) {
 	case KV_SENDER_RESTART_VBUCKETS:
 		k.handleRestartVbuckets(cmd)
 
+	case KV_SENDER_ACK_TIMESTAMPS:
+		k.handleAckTimestamps(cmd)
+
 	case CONFIG_SETTINGS_UPDATE:
 		k.handleConfigUpdate(cmd)
 
@@ -232,6 +278,77 @@ func (k *kvSender) handleRestartVbuckets(cmd Message) {
 	k.supvCmdch <- &MsgSuccess{}
 }
 
+func (k *kvSender) handleAckTimestamps(cmd Message) {
+
+	streamId := cmd.(*MsgAckTimestamps).GetStreamId()
+	bucket := cmd.(*MsgAckTimestamps).GetBucket()
+	ackTs := cmd.(*MsgAckTimestamps).GetAckTs()
+
+	if endpoint, err := k.getStreamEndpoint(streamId); err != nil {
+		logging.Warnf("KVSender::handleAckTimestamps %v %v Error in getting "+
+			"stream endpoint %v", streamId, bucket, err)
+	} else {
+		go k.ackTimestamps(streamId, bucket, endpoint, ackTs)
+	}
+	k.supvCmdch <- &MsgSuccess{}
+}
+
+//ackTimestamps sends the timestamp of the last snapshot of bucket to
+//all projectors of the stream, so that projectors can track
+//how far the indexer endpoint of the stream is behind. Acks are best
+//effort, errors are logged and the next ack supersedes this one.
+func (k *kvSender) ackTimestamps(streamId c.StreamId, bucket string,
+	endpoint string, ackTs *c.TsVbuuid) {
+
+	addrs, err := k.projectors.GetAllProjectorAddrs()
+	if err != nil {
+		logging.Warnf("KVSender::ackTimestamps %v %v Error in getting "+
+			"projector addrs %v", streamId, bucket, err)
+		return
+	}
+
+	vbnos := make([]uint32, 0, len(ackTs.Seqnos))
+	for vbno, seqno := range ackTs.Seqnos {
+		if seqno != 0 {
+			vbnos = append(vbnos, uint32(vbno))
+		}
+	}
+	if len(vbnos) == 0 {
+		return
+	}
+	protoTs, _ := makeRestartTsFromTsVbuuid(bucket, ackTs, vbnos)
+
+	topic := getTopicForStreamId(streamId)
+	for _, addr := range addrs {
+		ap := k.projectors.StreamAdmin(addr)
+		err := ap.AckTimestamps(topic, endpoint, []*protobuf.TsVbuuid{protoTs})
+		if err != nil {
+			logging.Debugf("KVSender::ackTimestamps Projector %v Topic %v %v "+
+				"Err %v", ap, topic, bucket, err)
+		}
+	}
+}
+
+//getStreamEndpoint returns the dataport endpoint of this indexer for
+//streamId, as set in the instances of its mutation topic.
+func (k *kvSender) getStreamEndpoint(streamId c.StreamId) (string, error) {
+
+	host, err := k.projectors.GetLocalHostname()
+	if err != nil {
+		return "", err
+	}
+
+	switch streamId {
+	case c.MAINT_STREAM:
+		return net.JoinHostPort(host, k.config["streamMaintPort"].String()), nil
+	case c.CATCHUP_STREAM:
+		return net.JoinHostPort(host, k.config["streamCatchupPort"].String()), nil
+	case c.INIT_STREAM:
+		return net.JoinHostPort(host, k.config["streamInitPort"].String()), nil
+	}
+	return "", fmt.Errorf("invalid stream %v", streamId)
+}
+
 func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.IndexInst,
 	restartTs *c.TsVbuuid, respCh MsgChannel, stopCh StopChannel) {
 
@@ -241,11 +358,11 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 		return
 	}
 
-	protoInstList := convertIndexListToProto(k.config, k.cInfoCache, indexInstList, streamId)
+	protoInstList := convertIndexListToProto(k.config, k.projectors, indexInstList, streamId)
 	bucket := indexInstList[0].Defn.Bucket
 
 	//use any bucket as list of vbs remain the same for all buckets
-	vbnos, addrs, err := k.getAllVbucketsInCluster(bucket)
+	vbnos, addrs, err := k.projectors.GetAllVbuckets(bucket)
 	if err != nil {
 		logging.Errorf("KVSender::openMutationStream %v %v Error in fetching vbuckets info %v",
 			streamId, bucket, err)
@@ -269,21 +386,26 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 
 	var rollbackTs *protobuf.TsVbuuid
 	var activeTs *protobuf.TsVbuuid
+	var authErr error
 	topic := getTopicForStreamId(streamId)
+	requestId := newProjRequestId()
 
 	fn := func(r int, err error) error {
 
 		//clear the error before every retry
-		err = nil
+		err, authErr = nil, nil
 		for _, addr := range addrs {
 
 			execWithStopCh(func() {
-				ap := newProjClient(addr)
-				if res, ret := k.sendMutationTopicRequest(ap, topic, restartTsList, protoInstList); ret != nil {
+				ap := k.projectors.StreamAdmin(addr)
+				if res, ret := k.sendMutationTopicRequest(ap, topic, restartTsList, protoInstList, requestId); ret != nil {
 					//for all errors, retry
 					logging.Errorf("KVSender::openMutationStream %v %v Error Received %v from %v",
 						streamId, bucket, ret, addr)
 					err = ret
+					if isProjectorAuthError(ret) {
+						authErr = ret
+					}
 				} else {
 					activeTs = updateActiveTsFromResponse(bucket, activeTs, res)
 					if rollbackTs != nil {
@@ -333,6 +455,10 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 		respCh <- &MsgRollback{streamId: streamId,
 			bucket:     bucket,
 			rollbackTs: nativeTs}
+	} else if authErr != nil {
+		logging.Errorf("KVSender::openMutationStream %v %v Projector failed to "+
+			"authenticate with KV %v", streamId, bucket, authErr)
+		respCh <- streamAuthError(streamId, bucket, authErr)
 	} else if err != nil {
 		logging.Errorf("KVSender::openMutationStream %v %v Error from Projector %v",
 			streamId, bucket, err)
@@ -349,7 +475,7 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 	connErrVbs []Vbucket, respCh MsgChannel, stopCh StopChannel) {
 
-	addrs, err := k.getProjAddrsForVbuckets(restartTs.Bucket, restartTs.GetVbnos())
+	addrs, err := k.projectors.GetProjAddrsForVbuckets(restartTs.Bucket, restartTs.GetVbnos())
 	if err != nil {
 		logging.Errorf("KVSender::restartVbuckets %v %v Error in fetching cluster info %v",
 			streamId, restartTs.Bucket, err)
@@ -368,21 +494,26 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 	protoRestartTs = protoTs.FromTsVbuuid(restartTs)
 
 	var rollbackTs *protobuf.TsVbuuid
+	var authErr error
 	topic := getTopicForStreamId(streamId)
 	rollback := false
 	aborted := false
 
 	fn := func(r int, err error) error {
 
+		authErr = nil
 		for _, addr := range addrs {
 			aborted = execWithStopCh(func() {
-				ap := newProjClient(addr)
+				ap := k.projectors.StreamAdmin(addr)
 
 				if res, ret := k.sendRestartVbuckets(ap, topic, connErrVbs, protoRestartTs); ret != nil {
 					//retry for all errors
 					logging.Errorf("KVSender::restartVbuckets %v %v Error Received %v from %v",
 						streamId, restartTs.Bucket, ret, addr)
 					err = ret
+					if isProjectorAuthError(ret) {
+						authErr = ret
+					}
 				} else {
 					rollbackTs = updateRollbackTsFromResponse(restartTs.Bucket, rollbackTs, res)
 				}
@@ -414,8 +545,16 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 			rollbackTs: nativeTs}
 	} else if err != nil {
 		//if there is a topicMissing/genServer.Closed error, a fresh
-		//MutationTopicRequest is required.
-		if err.Error() == projClient.ErrorTopicMissing.Error() ||
+		//MutationTopicRequest is required. So is an authentication failure,
+		//as the projector has closed the feed of the bucket.
+		if authErr != nil {
+			logging.Errorf("KVSender::restartVbuckets %v %v Projector failed to "+
+				"authenticate with KV %v", streamId, restartTs.Bucket, authErr)
+			respCh <- &MsgKVStreamRepair{
+				streamId: streamId,
+				bucket:   restartTs.Bucket,
+			}
+		} else if err.Error() == projClient.ErrorTopicMissing.Error() ||
 			err.Error() == c.ErrorClosed.Error() ||
 			err.Error() == projClient.ErrorInvalidBucket.Error() {
 			respCh <- &MsgKVStreamRepair{
@@ -437,7 +576,7 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 func (k *kvSender) addIndexForExistingBucket(streamId c.StreamId, bucket string, indexInstList []c.IndexInst,
 	respCh MsgChannel, stopCh StopChannel) {
 
-	addrs, err := k.getAllProjectorAddrs()
+	addrs, err := k.projectors.GetAllProjectorAddrs()
 	if err != nil {
 		logging.Errorf("KVSender::addIndexForExistingBucket %v %v Error in fetching cluster info %v",
 			streamId, bucket, err)
@@ -449,20 +588,25 @@ func (k *kvSender) addIndexForExistingBucket(streamId c.StreamId, bucket string,
 	}
 
 	var currentTs *protobuf.TsVbuuid
-	protoInstList := convertIndexListToProto(k.config, k.cInfoCache, indexInstList, streamId)
+	var authErr error
+	protoInstList := convertIndexListToProto(k.config, k.projectors, indexInstList, streamId)
 	topic := getTopicForStreamId(streamId)
+	requestId := newProjRequestId()
 
 	fn := func(r int, err error) error {
 
 		//clear the error before every retry
-		err = nil
+		err, authErr = nil, nil
 		for _, addr := range addrs {
 			execWithStopCh(func() {
-				ap := newProjClient(addr)
-				if res, ret := sendAddInstancesRequest(ap, topic, protoInstList); ret != nil {
+				ap := k.projectors.StreamAdmin(addr)
+				if res, ret := sendAddInstancesRequest(ap, topic, protoInstList, requestId); ret != nil {
 					logging.Errorf("KVSender::addIndexForExistingBucket %v %v Error Received %v from %v",
 						streamId, bucket, ret, addr)
 					err = ret
+					if isProjectorAuthError(ret) {
+						authErr = ret
+					}
 				} else {
 					currentTs = updateCurrentTsFromResponse(bucket, currentTs, res)
 				}
@@ -481,7 +625,12 @@ func (k *kvSender) addIndexForExistingBucket(streamId c.StreamId, bucket string,
 
 	rh := c.NewRetryHelper(MAX_KV_REQUEST_RETRY, time.Second, BACKOFF_FACTOR, fn)
 	err = rh.Run()
-	if err != nil {
+	if authErr != nil {
+		logging.Errorf("KVSender::addIndexForExistingBucket %v %v Projector failed to "+
+			"authenticate with KV %v", streamId, bucket, authErr)
+		respCh <- streamAuthError(streamId, bucket, authErr)
+		return
+	} else if err != nil {
 		logging.Errorf("KVSender::addIndexForExistingBucket %v %v Error from Projector %v",
 			streamId, bucket, err)
 		respCh <- &MsgError{
@@ -503,7 +652,7 @@ func (k *kvSender) addIndexForExistingBucket(streamId c.StreamId, bucket string,
 func (k *kvSender) deleteIndexesFromStream(streamId c.StreamId, indexInstList []c.IndexInst,
 	respCh MsgChannel, stopCh StopChannel) {
 
-	addrs, err := k.getAllProjectorAddrs()
+	addrs, err := k.projectors.GetAllProjectorAddrs()
 	if err != nil {
 		logging.Errorf("KVSender::deleteIndexesFromStream %v %v Error in fetching cluster info %v",
 			streamId, indexInstList[0].Defn.Bucket, err)
@@ -527,7 +676,7 @@ func (k *kvSender) deleteIndexesFromStream(streamId c.StreamId, indexInstList []
 		err = nil
 		for _, addr := range addrs {
 			execWithStopCh(func() {
-				ap := newProjClient(addr)
+				ap := k.projectors.StreamAdmin(addr)
 				if ret := sendDelInstancesRequest(ap, topic, uuids); ret != nil {
 					logging.Errorf("KVSender::deleteIndexesFromStream %v %v Error Received %v from %v",
 						streamId, indexInstList[0].Defn.Bucket, ret, addr)
@@ -564,7 +713,7 @@ func (k *kvSender) deleteIndexesFromStream(streamId c.StreamId, indexInstList []
 func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string,
 	respCh MsgChannel, stopCh StopChannel) {
 
-	addrs, err := k.getAllProjectorAddrs()
+	addrs, err := k.projectors.GetAllProjectorAddrs()
 	if err != nil {
 		logging.Errorf("KVSender::deleteBucketsFromStream %v %v Error in fetching cluster info %v",
 			streamId, buckets[0], err)
@@ -583,7 +732,7 @@ func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string
 		err = nil
 		for _, addr := range addrs {
 			execWithStopCh(func() {
-				ap := newProjClient(addr)
+				ap := k.projectors.StreamAdmin(addr)
 				if ret := sendDelBucketsRequest(ap, topic, buckets); ret != nil {
 					logging.Errorf("KVSender::deleteBucketsFromStream %v %v Error Received %v from %v",
 						streamId, buckets[0], ret, addr)
@@ -619,7 +768,7 @@ func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string
 func (k *kvSender) closeMutationStream(streamId c.StreamId, bucket string,
 	respCh MsgChannel, stopCh StopChannel) {
 
-	addrs, err := k.getAllProjectorAddrs()
+	addrs, err := k.projectors.GetAllProjectorAddrs()
 	if err != nil {
 		logging.Errorf("KVSender::closeMutationStream %v %v Error in fetching cluster info %v",
 			streamId, bucket, err)
@@ -638,7 +787,7 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId, bucket string,
 		err = nil
 		for _, addr := range addrs {
 			execWithStopCh(func() {
-				ap := newProjClient(addr)
+				ap := k.projectors.StreamAdmin(addr)
 				if ret := sendShutdownTopic(ap, topic); ret != nil {
 					logging.Errorf("KVSender::closeMutationStream %v %v Error Received %v from %v",
 						streamId, bucket, ret, addr)
@@ -672,10 +821,26 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId, bucket string,
 
 }
 
+//isProjectorAuthError returns true if the projector failed to
+//authenticate with KV for the bucket of a request.
+func isProjectorAuthError(err error) bool {
+	return err.Error() == projClient.ErrorDCPAuth.Error()
+}
+
+//streamAuthError reports the authentication failure of the projector
+//with KV as an error of the stream request for bucket.
+func streamAuthError(streamId c.StreamId, bucket string, err error) *MsgError {
+	return &MsgError{
+		err: Error{code: ERROR_KVSENDER_STREAM_AUTH_ERROR,
+			severity: FATAL,
+			cause: fmt.Errorf("Stream %v Bucket %v: projector failed to "+
+				"authenticate with KV: %v", streamId, bucket, err)}}
+}
+
 //send the actual MutationStreamRequest on adminport
-func (k *kvSender) sendMutationTopicRequest(ap *projClient.Client, topic string,
+func (k *kvSender) sendMutationTopicRequest(ap StreamAdmin, topic string,
 	reqTimestamps *protobuf.TsVbuuid,
-	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
+	instances []*protobuf.Instance, requestId string) (*protobuf.TopicResponse, error) {
 
 	logging.Infof("KVSender::sendMutationTopicRequest Projector %v Topic %v %v \n\tInstances %v",
 		ap, topic, reqTimestamps.GetBucket(), formatInstances(instances))
@@ -685,7 +850,7 @@ func (k *kvSender) sendMutationTopicRequest(ap *projClient.Client, topic string,
 	endpointType := "dataport"
 
 	if res, err := ap.MutationTopicRequest(topic, endpointType,
-		[]*protobuf.TsVbuuid{reqTimestamps}, instances); err != nil {
+		[]*protobuf.TsVbuuid{reqTimestamps}, instances, requestId); err != nil {
 		logging.Errorf("KVSender::sendMutationTopicRequest Projector %v Topic %v %v \n\tUnexpected Error %v", ap,
 			topic, reqTimestamps.GetBucket(), err)
 
@@ -702,7 +867,7 @@ func (k *kvSender) sendMutationTopicRequest(ap *projClient.Client, topic string,
 	}
 }
 
-func (k *kvSender) sendRestartVbuckets(ap *projClient.Client,
+func (k *kvSender) sendRestartVbuckets(ap StreamAdmin,
 	topic string, connErrVbs []Vbucket,
 	restartTs *protobuf.TsVbuuid) (*protobuf.TopicResponse, error) {
 
@@ -756,14 +921,14 @@ func (k *kvSender) sendRestartVbuckets(ap *projClient.Client,
 }
 
 //send the actual AddInstances request on adminport
-func sendAddInstancesRequest(ap *projClient.Client,
+func sendAddInstancesRequest(ap StreamAdmin,
 	topic string,
-	instances []*protobuf.Instance) (*protobuf.TimestampResponse, error) {
+	instances []*protobuf.Instance, requestId string) (*protobuf.TimestampResponse, error) {
 
 	logging.Infof("KVSender::sendAddInstancesRequest Projector %v Topic %v \nInstances %v",
 		ap, topic, formatInstances(instances))
 
-	if res, err := ap.AddInstances(topic, instances); err != nil {
+	if res, err := ap.AddInstances(topic, instances, requestId); err != nil {
 		logging.Errorf("KVSender::sendAddInstancesRequest Unexpected Error During "+
 			"Add Instances Request Projector %v Topic %v IndexInst %v. Err %v", ap,
 			topic, formatInstances(instances), err)
@@ -783,7 +948,7 @@ func sendAddInstancesRequest(ap *projClient.Client,
 }
 
 //send the actual DelInstances request on adminport
-func sendDelInstancesRequest(ap *projClient.Client,
+func sendDelInstancesRequest(ap StreamAdmin,
 	topic string,
 	uuids []uint64) error {
 
@@ -806,7 +971,7 @@ func sendDelInstancesRequest(ap *projClient.Client,
 }
 
 //send the actual DelBuckets request on adminport
-func sendDelBucketsRequest(ap *projClient.Client,
+func sendDelBucketsRequest(ap StreamAdmin,
 	topic string,
 	buckets []string) error {
 
@@ -827,7 +992,7 @@ func sendDelBucketsRequest(ap *projClient.Client,
 }
 
 //send the actual ShutdownStreamRequest on adminport
-func sendShutdownTopic(ap *projClient.Client,
+func sendShutdownTopic(ap StreamAdmin,
 	topic string) error {
 
 	logging.Infof("KVSender::sendShutdownTopic Projector %v Topic %v", ap, topic)
@@ -983,7 +1148,7 @@ func (k *kvSender) getFailoverLogs(bucket string,
 	var err error
 	var res *protobuf.FailoverLogResponse
 
-	addrs, err := k.getAllProjectorAddrs()
+	addrs, err := k.projectors.GetAllProjectorAddrs()
 	if err != nil {
 		return nil, err
 	}
@@ -991,7 +1156,7 @@ func (k *kvSender) getFailoverLogs(bucket string,
 loop:
 	for _, addr := range addrs {
 		//create client for node's projectors
-		client := newProjClient(addr)
+		client := k.projectors.StreamAdmin(addr)
 		if res, err = client.GetFailoverLogs(DEFAULT_POOL, bucket, vbnos); err == nil {
 			break loop
 		}
@@ -1008,110 +1173,6 @@ loop:
 	return res, err
 }
 
-func (k *kvSender) getAllVbucketsInCluster(bucket string) ([]uint32, []string, error) {
-
-	k.cInfoCache.Lock()
-	defer k.cInfoCache.Unlock()
-
-	err := k.cInfoCache.Fetch()
-	if err != nil {
-		return nil, nil, err
-	}
-
-	//get all kv nodes
-	nodes, err := k.cInfoCache.GetNodesByBucket(bucket)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	var vbs []uint32
-	var addrList []string
-
-	for _, nid := range nodes {
-		//get the list of vbnos for this kv
-		if vbnos, err := k.cInfoCache.GetVBuckets(nid, bucket); err != nil {
-			return nil, nil, err
-		} else {
-			vbs = append(vbs, vbnos...)
-			addr, err := k.cInfoCache.GetServiceAddress(nid, "projector")
-			if err != nil {
-				return nil, nil, err
-			}
-			addrList = append(addrList, addr)
-
-		}
-	}
-
-	return vbs, addrList, nil
-}
-
-func (k *kvSender) getAllProjectorAddrs() ([]string, error) {
-
-	k.cInfoCache.Lock()
-	defer k.cInfoCache.Unlock()
-
-	err := k.cInfoCache.Fetch()
-	if err != nil {
-		return nil, err
-	}
-
-	nodes := k.cInfoCache.GetNodesByServiceType("projector")
-
-	var addrList []string
-	for _, nid := range nodes {
-		addr, err := k.cInfoCache.GetServiceAddress(nid, "projector")
-		if err != nil {
-			return nil, err
-		}
-		addrList = append(addrList, addr)
-	}
-
-	return addrList, nil
-}
-
-func (k *kvSender) getProjAddrsForVbuckets(bucket string, vbnos []uint16) ([]string, error) {
-
-	k.cInfoCache.Lock()
-	defer k.cInfoCache.Unlock()
-
-	err := k.cInfoCache.Fetch()
-	if err != nil {
-		return nil, err
-	}
-
-	var addrList []string
-
-	nodes := k.cInfoCache.GetNodesByServiceType("projector")
-
-	for _, n := range nodes {
-		vbs, err := k.cInfoCache.GetVBuckets(n, bucket)
-		if err != nil {
-			return nil, err
-		}
-		found := false
-	outerloop:
-		for _, vb := range vbs {
-			for _, vbc := range vbnos {
-				if vb == uint32(vbc) {
-					found = true
-					break outerloop
-				}
-			}
-		}
-
-		if found {
-			addr, err := k.cInfoCache.GetServiceAddress(n, "projector")
-			if err != nil {
-				return nil, err
-			}
-			addrList = append(addrList, addr)
-		}
-	}
-
-	return addrList, nil
-
-}
-
 func (k *kvSender) handleConfigUpdate(cmd Message) {
 	cfgUpdate := cmd.(*MsgConfigUpdate)
 	k.config = cfgUpdate.GetConfig()
@@ -1120,12 +1181,12 @@ func (k *kvSender) handleConfigUpdate(cmd Message) {
 }
 
 // convert IndexInst to protobuf format
-func convertIndexListToProto(cfg c.Config, cinfo *c.ClusterInfoCache, indexList []c.IndexInst,
+func convertIndexListToProto(cfg c.Config, projectors ProjectorCluster, indexList []c.IndexInst,
 	streamId c.StreamId) []*protobuf.Instance {
 
 	protoList := make([]*protobuf.Instance, 0)
 	for _, index := range indexList {
-		protoInst := convertIndexInstToProtoInst(cfg, cinfo, index, streamId)
+		protoInst := convertIndexInstToProtoInst(cfg, projectors, index, streamId)
 		protoList = append(protoList, protoInst)
 	}
 
@@ -1133,7 +1194,7 @@ func convertIndexListToProto(cfg c.Config, cinfo *c.ClusterInfoCache, indexList
 		if c.IsPartitioned(index.Defn.PartitionScheme) && index.RealInstId != 0 {
 			for _, protoInst := range protoList {
 				if protoInst.IndexInstance.GetInstId() == uint64(index.RealInstId) {
-					addPartnInfoToProtoInst(cfg, cinfo, index, streamId, protoInst.IndexInstance)
+					addPartnInfoToProtoInst(cfg, projectors, index, streamId, protoInst.IndexInstance)
 				}
 			}
 		}
@@ -1144,13 +1205,13 @@ func convertIndexListToProto(cfg c.Config, cinfo *c.ClusterInfoCache, indexList
 }
 
 // convert IndexInst to protobuf format
-func convertIndexInstToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
+func convertIndexInstToProtoInst(cfg c.Config, projectors ProjectorCluster,
 	indexInst c.IndexInst, streamId c.StreamId) *protobuf.Instance {
 
 	protoDefn := convertIndexDefnToProtobuf(indexInst.Defn)
 	protoInst := convertIndexInstToProtobuf(cfg, indexInst, protoDefn)
 
-	addPartnInfoToProtoInst(cfg, cinfo, indexInst, streamId, protoInst)
+	addPartnInfoToProtoInst(cfg, projectors, indexInst, streamId, protoInst)
 
 	return &protobuf.Instance{IndexInstance: protoInst}
 }
@@ -1168,6 +1229,14 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 			protobuf.PartitionScheme_value[string(c.KEY)]).Enum()
 	}
 
+	evalErrorPolicy := protobuf.EvalErrorPolicy_EVAL_SKIP.Enum()
+	switch indexDefn.EvalErrorPolicy {
+	case c.EvalErrorIndexNull:
+		evalErrorPolicy = protobuf.EvalErrorPolicy_EVAL_INDEX_NULL.Enum()
+	case c.EvalErrorFail:
+		evalErrorPolicy = protobuf.EvalErrorPolicy_EVAL_FAIL.Enum()
+	}
+
 	defn := &protobuf.IndexDefn{
 		DefnID:             proto.Uint64(uint64(indexDefn.DefnId)),
 		Bucket:             proto.String(indexDefn.Bucket),
@@ -1181,6 +1250,10 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 		HashScheme:         protobuf.HashScheme(indexDefn.HashScheme).Enum(),
 		WhereExpression:    proto.String(indexDefn.WhereExpr),
 		RetainDeletedXATTR: proto.Bool(indexDefn.RetainDeletedXATTR),
+		EvalErrorPolicy:    evalErrorPolicy,
+		DocMeta:            proto.Bool(indexDefn.DocMeta),
+		IncludeMissing:     proto.Bool(indexDefn.IncludeMissing),
+		IsArrayIndex:       proto.Bool(indexDefn.IsArrayIndex),
 	}
 
 	return defn
@@ -1199,7 +1272,7 @@ func convertIndexInstToProtobuf(cfg c.Config, indexInst c.IndexInst,
 	return instance
 }
 
-func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
+func addPartnInfoToProtoInst(cfg c.Config, projectors ProjectorCluster,
 	indexInst c.IndexInst, streamId c.StreamId, protoInst *protobuf.IndexInst) {
 
 	switch partn := indexInst.Pc.(type) {
@@ -1211,13 +1284,7 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 
 		//TODO move this to indexer init. These addresses cannot change.
 		//Better to get these once and store.
-		cinfo.Lock()
-		defer cinfo.Unlock()
-
-		err := cinfo.Fetch()
-		c.CrashOnError(err)
-
-		host, err := cinfo.GetLocalHostname()
+		host, err := projectors.GetLocalHostname()
 		c.CrashOnError(err)
 
 		streamMaintAddr := net.JoinHostPort(host, cfg["streamMaintPort"].String())
@@ -1264,8 +1331,14 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 	}
 }
 
+//clusterInfoProjectors locates projectors with ClusterInfoCache, and
+//talks to them with the projector client.
+type clusterInfoProjectors struct {
+	cinfo *c.ClusterInfoCache
+}
+
 //create client for node's projectors
-func newProjClient(addr string) *projClient.Client {
+func (p *clusterInfoProjectors) StreamAdmin(addr string) StreamAdmin {
 
 	config := c.SystemConfig.SectionConfig("indexer.projectorclient.", true)
 	config.SetValue("retryInterval", 0) //no retry
@@ -1274,6 +1347,134 @@ func newProjClient(addr string) *projClient.Client {
 
 }
 
+func (p *clusterInfoProjectors) GetLocalHostname() (string, error) {
+
+	p.cinfo.Lock()
+	defer p.cinfo.Unlock()
+
+	if err := p.cinfo.Fetch(); err != nil {
+		return "", err
+	}
+	return p.cinfo.GetLocalHostname()
+}
+
+func (p *clusterInfoProjectors) GetAllVbuckets(bucket string) ([]uint32, []string, error) {
+
+	p.cinfo.Lock()
+	defer p.cinfo.Unlock()
+
+	err := p.cinfo.Fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//get all kv nodes
+	nodes, err := p.cinfo.GetNodesByBucket(bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var vbs []uint32
+	var addrList []string
+
+	for _, nid := range nodes {
+		//get the list of vbnos for this kv
+		if vbnos, err := p.cinfo.GetVBuckets(nid, bucket); err != nil {
+			return nil, nil, err
+		} else {
+			vbs = append(vbs, vbnos...)
+			addr, err := p.cinfo.GetServiceAddress(nid, "projector")
+			if err != nil {
+				return nil, nil, err
+			}
+			addrList = append(addrList, addr)
+
+		}
+	}
+
+	return vbs, addrList, nil
+}
+
+func (p *clusterInfoProjectors) GetAllProjectorAddrs() ([]string, error) {
+
+	p.cinfo.Lock()
+	defer p.cinfo.Unlock()
+
+	err := p.cinfo.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := p.cinfo.GetNodesByServiceType("projector")
+
+	var addrList []string
+	for _, nid := range nodes {
+		addr, err := p.cinfo.GetServiceAddress(nid, "projector")
+		if err != nil {
+			return nil, err
+		}
+		addrList = append(addrList, addr)
+	}
+
+	return addrList, nil
+}
+
+func (p *clusterInfoProjectors) GetProjAddrsForVbuckets(bucket string, vbnos []uint16) ([]string, error) {
+
+	p.cinfo.Lock()
+	defer p.cinfo.Unlock()
+
+	err := p.cinfo.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrList []string
+
+	nodes := p.cinfo.GetNodesByServiceType("projector")
+
+	for _, n := range nodes {
+		vbs, err := p.cinfo.GetVBuckets(n, bucket)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+	outerloop:
+		for _, vb := range vbs {
+			for _, vbc := range vbnos {
+				if vb == uint32(vbc) {
+					found = true
+					break outerloop
+				}
+			}
+		}
+
+		if found {
+			addr, err := p.cinfo.GetServiceAddress(n, "projector")
+			if err != nil {
+				return nil, err
+			}
+			addrList = append(addrList, addr)
+		}
+	}
+
+	return addrList, nil
+
+}
+
+//newProjRequestId generates the request id to de-duplicate retries of
+//a projector request, empty string if id can't be generated.
+func newProjRequestId() string {
+
+	uuid, err := c.NewUUID()
+	if err != nil {
+		logging.Warnf("KVSender::newProjRequestId Error generating request id %v", err)
+		return ""
+	}
+	return uuid.Str()
+
+}
+
 func compareIfActiveTsEqual(origTs, compTs *c.TsVbuuid) bool {
 
 	vbnosOrig := origTs.GetVbnos()
@@ -0,0 +1,140 @@
+package indexer
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	projClient "github.com/couchbase/indexing/secondary/projector/client"
+)
+
+func TestKVSenderOpenStream(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+
+	fp := NewFakeProjector("127.0.0.1:9999", 4, nil)
+	fp.AddBucket("default")
+	for i := 0; i < 10; i++ {
+		if _, err := fp.Mutate("default", 1, FakeDoc{Key: []byte("k"), Value: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flogs, err := fp.GetFailoverLogs(DEFAULT_POOL, "default", []uint32{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbuuid := flogs.GetLogs()[0].GetVbuuids()[0]
+
+	k := &kvSender{
+		projectors: fp,
+		config: common.Config{
+			"numVbuckets":     common.ConfigValue{Value: 4},
+			"streamMaintPort": common.ConfigValue{Value: port},
+		},
+	}
+
+	pc := common.NewKeyPartitionContainer(4, 1, common.SINGLE, common.CRC32)
+	pc.AddPartition(0, common.KeyPartitionDefn{Id: 0,
+		Endpts: []common.Endpoint{common.Endpoint(net.JoinHostPort("", port))}})
+	inst := common.IndexInst{
+		InstId: 100,
+		Defn: common.IndexDefn{DefnId: 100, Bucket: "default", Name: "#primary",
+			IsPrimary: true, Using: common.ForestDB, ExprType: common.N1QL},
+		Pc: pc,
+	}
+	if endpoint, err := k.getStreamEndpoint(common.MAINT_STREAM); err != nil ||
+		endpoint != lis.Addr().String() {
+		t.Fatalf("Unexpected stream endpoint %v %v", endpoint, err)
+	}
+
+	respCh := make(MsgChannel, 1)
+	k.openMutationStream(common.MAINT_STREAM, []common.IndexInst{inst}, nil, respCh, nil)
+	resp, ok := (<-respCh).(*MsgSuccessOpenStream)
+	if !ok {
+		t.Fatalf("Expected stream to be opened, got %v", resp)
+	}
+	activeTs := resp.GetActiveTs()
+	if activeTs.Len() != 4 {
+		t.Fatalf("Expected all vbuckets to be active, got %v", activeTs)
+	}
+
+	// a stream on a failed over branch is rolled back.
+	k.closeMutationStream(common.MAINT_STREAM, "default", respCh, nil)
+	if _, ok := (<-respCh).(*MsgSuccess); !ok {
+		t.Fatalf("Expected stream to be closed")
+	}
+	if err := fp.Failover("default", 1, 6); err != nil {
+		t.Fatal(err)
+	}
+	restartTs := common.NewTsVbuuid("default", 4)
+	restartTs.Seqnos[1], restartTs.Vbuuids[1] = 8, vbuuid
+	restartTs.Snapshots[1] = [2]uint64{8, 8}
+	k.openMutationStream(common.MAINT_STREAM, []common.IndexInst{inst}, restartTs, respCh, nil)
+	rollback, ok := (<-respCh).(*MsgRollback)
+	if !ok {
+		t.Fatalf("Expected rollback, got %v", rollback)
+	}
+	if seqno := rollback.GetRollbackTs().Seqnos[1]; seqno != 6 {
+		t.Fatalf("Expected rollback to seqno 6, got %v", seqno)
+	}
+}
+
+func TestKVSenderAuthError(t *testing.T) {
+	fp := NewFakeProjector("127.0.0.1:9999", 4, nil)
+	fp.AddBucket("default")
+	k := &kvSender{
+		projectors: fp,
+		config: common.Config{
+			"numVbuckets":     common.ConfigValue{Value: 4},
+			"streamMaintPort": common.ConfigValue{Value: "9105"},
+		},
+	}
+
+	pc := common.NewKeyPartitionContainer(4, 1, common.SINGLE, common.CRC32)
+	pc.AddPartition(0, common.KeyPartitionDefn{Id: 0,
+		Endpts: []common.Endpoint{common.Endpoint("127.0.0.1:9105")}})
+	inst := common.IndexInst{
+		InstId: 100,
+		Defn: common.IndexDefn{DefnId: 100, Bucket: "default", Name: "#primary",
+			IsPrimary: true, Using: common.ForestDB, ExprType: common.N1QL},
+		Pc: pc,
+	}
+
+	respCh := make(MsgChannel, 1)
+	fp.SetRequestError(projClient.ErrorDCPAuth)
+	k.openMutationStream(common.MAINT_STREAM, []common.IndexInst{inst}, nil, respCh, nil)
+	resp, ok := (<-respCh).(*MsgError)
+	if !ok || resp.GetError().code != ERROR_KVSENDER_STREAM_AUTH_ERROR {
+		t.Fatalf("Expected stream auth error, got %v", resp)
+	}
+
+	// the stream is repaired with a new request, once the projector
+	// fails to authenticate on restart.
+	fp.SetRequestError(nil)
+	k.openMutationStream(common.MAINT_STREAM, []common.IndexInst{inst}, nil, respCh, nil)
+	if _, ok := (<-respCh).(*MsgSuccessOpenStream); !ok {
+		t.Fatalf("Expected stream to be opened")
+	}
+	fp.SetRequestError(projClient.ErrorDCPAuth)
+	restartTs := common.NewTsVbuuid("default", 4)
+	restartTs.Vbuuids[1] = fakeVbuuid(1, 1)
+	k.restartVbuckets(common.MAINT_STREAM, restartTs, nil, respCh, nil)
+	if repair, ok := (<-respCh).(*MsgKVStreamRepair); !ok || repair.GetBucket() != "default" {
+		t.Fatalf("Expected stream repair, got %v", repair)
+	}
+}
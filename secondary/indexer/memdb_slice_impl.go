@@ -374,6 +374,7 @@ loop:
 		var nmut int
 		select {
 		case icmd = <-mdb.cmdCh[workerId]:
+			acquired := gIngestCPU.acquire()
 			switch icmd.op {
 			case opUpdate:
 				start = time.Now()
@@ -391,6 +392,7 @@ loop:
 				logging.Errorf("MemDBSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v PartitionId %v Received "+
 					"Unknown Command %v", mdb.id, mdb.idxInstId, mdb.idxPartnId, logging.TagUD(icmd))
 			}
+			gIngestCPU.release(acquired)
 
 			mdb.idxStats.numItemsFlushed.Add(int64(nmut))
 			mdb.idxStats.numDocsIndexed.Add(1)
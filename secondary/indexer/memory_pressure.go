@@ -0,0 +1,156 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  admission control
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// The memory usage of the indexer is sampled by monitorMemUsage(), and
+// classified into a pressure level by the fraction of memory_quota in
+// use, not counting idle heap the runtime can return to the OS. Admission
+// control is disabled by default. Under high pressure, batch priority scans are rejected and
+// mutations of INIT streams are throttled, so that index builds do not
+// add to the memory of the indexer. Under critical pressure, all scans
+// are rejected. Rejected scans fail with ErrIndexerMemoryPressure,
+// before taking a slot in the scan scheduler.
+//
+
+type memPressureLevel int32
+
+const (
+	memPressureNone memPressureLevel = iota
+	memPressureHigh
+	memPressureCritical
+)
+
+func (l memPressureLevel) String() string {
+	switch l {
+	case memPressureNone:
+		return "none"
+	case memPressureHigh:
+		return "high"
+	case memPressureCritical:
+		return "critical"
+	}
+	return "invalid"
+}
+
+type memPressure struct {
+	level            int32 // memPressureLevel
+	numScansRejected int64
+	numThrottled     int64 // num of enqueues of INIT streams throttled
+}
+
+var gMemPressure memPressure
+
+func (m *memPressure) getLevel() memPressureLevel {
+	return memPressureLevel(atomic.LoadInt32(&m.level))
+}
+
+// update the pressure level from memory used. A fraction of 0 disables
+// the level.
+func (m *memPressure) update(memUsed, memQuota uint64, highFrac, criticalFrac float64) {
+
+	level := memPressureNone
+	if memQuota > 0 {
+		frac := float64(memUsed) / float64(memQuota)
+		if criticalFrac > 0 && frac >= criticalFrac {
+			level = memPressureCritical
+		} else if highFrac > 0 && frac >= highFrac {
+			level = memPressureHigh
+		}
+	}
+
+	old := memPressureLevel(atomic.SwapInt32(&m.level, int32(level)))
+	if old != level {
+		logging.Infof("MemoryPressure::update level changed from %v to %v. MemoryUsed %v MemoryQuota %v",
+			old, level, memUsed, memQuota)
+	}
+}
+
+// admitScan returns ErrIndexerMemoryPressure if the scan must be shed.
+func (m *memPressure) admitScan(p common.ScanPriority) error {
+
+	switch m.getLevel() {
+	case memPressureCritical:
+	case memPressureHigh:
+		if !isBatchPriority(p) {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	atomic.AddInt64(&m.numScansRejected, 1)
+	return common.ErrIndexerMemoryPressure
+}
+
+func (m *memPressure) throttleStreams() bool {
+	return m.getLevel() >= memPressureHigh
+}
+
+// throttleMemPressure waits while the indexer is under memory pressure,
+// for queues of INIT streams. It returns false if the queue is stopped.
+func (q *atomicMutationQueue) throttleMemPressure(vbucket Vbucket, appch StopChannel) bool {
+
+	if !q.memThrottle || !gMemPressure.throttleStreams() {
+		return true
+	}
+
+	atomic.AddInt64(&gMemPressure.numThrottled, 1)
+
+	ticker := time.NewTicker(time.Millisecond * time.Duration(q.allocPollInterval))
+	defer ticker.Stop()
+
+	for {
+		//a minimum queue length is always allowed so that flusher
+		//can make progress
+		if atomic.LoadInt64(&q.size[vbucket]) < int64(q.minQueueLen) {
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+			if !gMemPressure.throttleStreams() {
+				return true
+			}
+
+		case <-q.stopch[vbucket]:
+			return false
+
+		case <-appch:
+			return true
+		}
+	}
+}
+
+// updateMemPressure samples the memory used, for admission control.
+func (idx *indexer) updateMemPressure() {
+
+	memQuota := idx.config["settings.memory_quota"].Uint64()
+	memUsed, idle, _ := idx.memoryUsed(false)
+
+	// idle heap is returned to the OS on demand, and is not pressure.
+	gMemPressure.update(memUsed-idle, memQuota,
+		idx.config["settings.admission.high_mem_frac"].Float64(),
+		idx.config["settings.admission.critical_mem_frac"].Float64())
+}
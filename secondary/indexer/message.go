@@ -59,6 +59,8 @@ const (
 	TK_MERGE_STREAM
 	TK_MERGE_STREAM_ACK
 	TK_GET_BUCKET_HWT
+	TK_VB_QUARANTINE
+	TK_INGEST_LAG
 
 	//STORAGE_MANAGER
 	STORAGE_MGR_SHUTDOWN
@@ -74,6 +76,7 @@ const (
 	KV_SENDER_GET_CURR_KV_TS
 	KV_SENDER_RESTART_VBUCKETS
 	KV_SENDER_REPAIR_ENDPOINTS
+	KV_SENDER_ACK_TIMESTAMPS
 	KV_STREAM_REPAIR
 	MSG_SUCCESS_OPEN_STREAM
 
@@ -99,6 +102,7 @@ const (
 	CLUST_MGR_CLEANUP_PARTITION
 	CLUST_MGR_MERGE_PARTITION
 	CLUST_MGR_PRUNE_PARTITION
+	CLUST_MGR_UPDATE_SLICE_LAYOUT
 
 	//CBQ_BRIDGE_SHUTDOWN
 	CBQ_BRIDGE_SHUTDOWN
@@ -228,6 +232,7 @@ type MsgStream struct {
 	streamId common.StreamId
 	meta     *MutationMeta
 	snapshot *MutationSnapshot
+	source   string // projector address of StreamBegin
 }
 
 func (m *MsgStream) GetMsgType() MsgType {
@@ -238,6 +243,10 @@ func (m *MsgStream) GetMutationMeta() *MutationMeta {
 	return m.meta
 }
 
+func (m *MsgStream) GetSource() string {
+	return m.source
+}
+
 func (m *MsgStream) GetStreamId() common.StreamId {
 	return m.streamId
 }
@@ -675,6 +684,62 @@ func (m *MsgTKMergeStream) GetMergeList() []common.IndexInst {
 	return m.mergeList
 }
 
+//TK_VB_QUARANTINE
+type MsgTKVbQuarantine struct {
+	mType    MsgType
+	streamId common.StreamId
+	bucket   string
+	vbList   []Vbucket
+}
+
+func (m *MsgTKVbQuarantine) GetMsgType() MsgType {
+	return m.mType
+}
+
+func (m *MsgTKVbQuarantine) GetStreamId() common.StreamId {
+	return m.streamId
+}
+
+func (m *MsgTKVbQuarantine) GetBucket() string {
+	return m.bucket
+}
+
+func (m *MsgTKVbQuarantine) GetVbList() []Vbucket {
+	return m.vbList
+}
+
+//TK_INGEST_LAG
+type MsgTKIngestLag struct {
+	indexInst common.IndexInst
+	lag       uint64
+	since     time.Time
+	cleared   bool
+}
+
+func (m *MsgTKIngestLag) GetMsgType() MsgType {
+	return TK_INGEST_LAG
+}
+
+func (m *MsgTKIngestLag) GetIndexInst() common.IndexInst {
+	return m.indexInst
+}
+
+//GetLag returns the number of KV seqnos not yet applied to the last
+//snapshot of the index instance.
+func (m *MsgTKIngestLag) GetLag() uint64 {
+	return m.lag
+}
+
+//GetSince returns the time since which the lag is above threshold.
+func (m *MsgTKIngestLag) GetSince() time.Time {
+	return m.since
+}
+
+//IsCleared returns true once a lag above threshold has recovered.
+func (m *MsgTKIngestLag) IsCleared() bool {
+	return m.cleared
+}
+
 //TK_ENABLE_FLUSH
 //TK_DISABLE_FLUSH
 type MsgTKToggleFlush struct {
@@ -790,6 +855,58 @@ func (m *MsgCancelMergePartition) GetString() string {
 	return str
 }
 
+// CLUST_MGR_UPDATE_SLICE_LAYOUT
+// Sent by the compaction manager to the indexer with only the partition,
+// once it has been relocated, and by the indexer to the cluster manager
+// agent with the layout of a slice.
+type MsgClustMgrUpdateSliceLayout struct {
+	defn     common.IndexDefn
+	instId   common.IndexInstId
+	partnId  common.PartitionId
+	sliceId  SliceId
+	vbuckets []uint16
+	hostPath string
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetMsgType() MsgType {
+	return CLUST_MGR_UPDATE_SLICE_LAYOUT
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetDefn() common.IndexDefn {
+	return m.defn
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetPartitionId() common.PartitionId {
+	return m.partnId
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetSliceId() SliceId {
+	return m.sliceId
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetVbuckets() []uint16 {
+	return m.vbuckets
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetHostPath() string {
+	return m.hostPath
+}
+
+func (m *MsgClustMgrUpdateSliceLayout) GetString() string {
+
+	str := "\n\tMessage: MsgClustMgrUpdateSliceLayout"
+	str += fmt.Sprintf("\n\tType: %v", CLUST_MGR_UPDATE_SLICE_LAYOUT)
+	str += fmt.Sprintf("\n\tIndex inst Id: %v", m.instId)
+	str += fmt.Sprintf("\n\tIndex partition Id: %v", m.partnId)
+	str += fmt.Sprintf("\n\tSlice Id: %v", m.sliceId)
+	str += fmt.Sprintf("\n\tHost path: %v", m.hostPath)
+	return str
+}
+
 // CLUST_MGR_CLEANUP_PARTITION
 type MsgClustMgrCleanupPartition struct {
 	defn             common.IndexDefn
@@ -1135,6 +1252,37 @@ func (m *MsgRepairEndpoints) String() string {
 	return str
 }
 
+//KV_SENDER_ACK_TIMESTAMPS
+type MsgAckTimestamps struct {
+	streamId common.StreamId
+	bucket   string
+	ackTs    *common.TsVbuuid
+}
+
+func (m *MsgAckTimestamps) GetMsgType() MsgType {
+	return KV_SENDER_ACK_TIMESTAMPS
+}
+
+func (m *MsgAckTimestamps) GetStreamId() common.StreamId {
+	return m.streamId
+}
+
+func (m *MsgAckTimestamps) GetBucket() string {
+	return m.bucket
+}
+
+func (m *MsgAckTimestamps) GetAckTs() *common.TsVbuuid {
+	return m.ackTs
+}
+
+func (m *MsgAckTimestamps) String() string {
+	str := "\n\tMessage: MsgAckTimestamps"
+	str += fmt.Sprintf("\n\tStreamId: %v", m.streamId)
+	str += fmt.Sprintf("\n\tBucket: %v", m.bucket)
+	str += fmt.Sprintf("\n\tAckTs: %v", m.ackTs)
+	return str
+}
+
 //INDEXER_INIT_PREP_RECOVERY
 //INDEXER_PREPARE_RECOVERY
 //INDEXER_PREPARE_DONE
@@ -1333,6 +1481,7 @@ type MsgIndexCompact struct {
 	errch     chan error
 	abortTime time.Time
 	minFrag   int
+	targetDir string
 }
 
 func (m *MsgIndexCompact) GetMsgType() MsgType {
@@ -1359,6 +1508,10 @@ func (m *MsgIndexCompact) GetMinFrag() int {
 	return m.minFrag
 }
 
+func (m *MsgIndexCompact) GetTargetDir() string {
+	return m.targetDir
+}
+
 //KV_STREAM_REPAIR
 type MsgKVStreamRepair struct {
 	streamId  common.StreamId
@@ -1640,6 +1793,10 @@ func (m MsgType) String() string {
 		return "TK_MERGE_STREAM_ACK"
 	case TK_GET_BUCKET_HWT:
 		return "TK_GET_BUCKET_HWT"
+	case TK_VB_QUARANTINE:
+		return "TK_VB_QUARANTINE"
+	case TK_INGEST_LAG:
+		return "TK_INGEST_LAG"
 	case REPAIR_ABORT:
 		return "REPAIR_ABORT"
 
@@ -1726,6 +1883,8 @@ func (m MsgType) String() string {
 		return "KV_SENDER_RESTART_VBUCKETS"
 	case KV_SENDER_REPAIR_ENDPOINTS:
 		return "KV_SENDER_REPAIR_ENDPOINTS"
+	case KV_SENDER_ACK_TIMESTAMPS:
+		return "KV_SENDER_ACK_TIMESTAMPS"
 	case KV_STREAM_REPAIR:
 		return "KV_STREAM_REPAIR"
 
@@ -1759,6 +1918,8 @@ func (m MsgType) String() string {
 		return "CLUST_MGR_MERGE_PARTITION"
 	case CLUST_MGR_PRUNE_PARTITION:
 		return "CLUST_MGR_PRUNE_PARTITION"
+	case CLUST_MGR_UPDATE_SLICE_LAYOUT:
+		return "CLUST_MGR_UPDATE_SLICE_LAYOUT"
 
 	case CBQ_CREATE_INDEX_DDL:
 		return "CBQ_CREATE_INDEX_DDL"
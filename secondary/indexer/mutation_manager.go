@@ -13,6 +13,7 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
@@ -33,6 +34,8 @@ type mutationMgr struct {
 	memUsed   int64 //memory used by queue
 	maxMemory int64 //max memory to be used
 
+	indexMem *indexQueueMem //memory used by queue per index
+
 	streamBucketQueueMap map[common.StreamId]BucketQueueMap
 	streamIndexQueueMap  map[common.StreamId]IndexQueueMap
 
@@ -96,6 +99,7 @@ func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		config:                 config,
 		memUsed:                0,
 		maxMemory:              0,
+		indexMem:               newIndexQueueMem(),
 	}
 
 	//start Mutation Manager loop which listens to commands from its supervisor
@@ -374,6 +378,8 @@ func (m *mutationMgr) handleOpenStream(cmd Message) {
 						category: MUTATION_QUEUE}}
 				return
 			}
+			queue.(*atomicMutationQueue).SetIndexMemory(m.indexMem)
+			queue.(*atomicMutationQueue).SetMemThrottle(streamId == common.INIT_STREAM)
 
 			bucketQueueMap[i.Defn.Bucket] = IndexerMutationQueue{
 				queue: queue}
@@ -460,6 +466,8 @@ func (m *mutationMgr) addIndexListToExistingStream(streamId common.StreamId,
 						severity: FATAL,
 						category: MUTATION_QUEUE}}
 			}
+			queue.(*atomicMutationQueue).SetIndexMemory(m.indexMem)
+			queue.(*atomicMutationQueue).SetMemThrottle(streamId == common.INIT_STREAM)
 
 			bucketQueueMap[i.Defn.Bucket] = IndexerMutationQueue{
 				queue: queue}
@@ -855,6 +863,7 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 		defer m.flusherWaitGroup.Done()
 
 		flusher := NewFlusher(config, stats)
+		flusher.indexMem = m.indexMem
 		sts := getSeqTsFromTsVbuuid(ts)
 		msgch := flusher.PersistUptoTS(q.queue, streamId, ts.Bucket,
 			m.indexInstMap, m.indexPartnMap, sts, changeVec, stopch)
@@ -871,6 +880,7 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 		}()
 
 		stats.memoryUsedQueue.Set(atomic.LoadInt64(&m.memUsed))
+		m.indexMem.updateStats(stats)
 
 		//send the response to supervisor
 		if msg.GetMsgType() == MSG_SUCCESS {
@@ -927,6 +937,7 @@ func (m *mutationMgr) drainMutationQueue(q IndexerMutationQueue,
 		defer m.flusherWaitGroup.Done()
 
 		flusher := NewFlusher(config, stats)
+		flusher.indexMem = m.indexMem
 		sts := getSeqTsFromTsVbuuid(ts)
 		msgch := flusher.DrainUptoTS(q.queue, streamId, ts.Bucket,
 			sts, changeVec, stopch)
@@ -964,6 +975,7 @@ func (m *mutationMgr) handleGetMutationQueueHWT(cmd Message) {
 	stats := m.stats.Get()
 	go func(config common.Config) {
 		flusher := NewFlusher(config, stats)
+		flusher.indexMem = m.indexMem
 		ts := flusher.GetQueueHWT(q.queue)
 		m.supvCmdch <- &MsgTimestamp{ts: ts}
 	}(m.config)
@@ -984,6 +996,7 @@ func (m *mutationMgr) handleGetMutationQueueLWT(cmd Message) {
 	stats := m.stats.Get()
 	go func(config common.Config) {
 		flusher := NewFlusher(config, stats)
+		flusher.indexMem = m.indexMem
 		ts := flusher.GetQueueLWT(q.queue)
 		m.supvCmdch <- &MsgTimestamp{ts: ts}
 	}(m.config)
@@ -1081,6 +1094,14 @@ func (m *mutationMgr) setMaxMemoryFromQuota() {
 	atomic.StoreInt64(&m.maxMemory, maxMem)
 	logging.Infof("MutationMgr::MaxQueueMemoryQuota %v", maxMem)
 
+	indexMemFrac := m.config["mutation_manager.indexQueueMemFrac"].Float64()
+	m.indexMem.setLimit(int64(indexMemFrac * float64(maxMem)))
+	throttleTimeout := m.config["mutation_manager.indexQueueThrottleTimeout"].Uint64()
+	m.indexMem.setTimeout(time.Duration(throttleTimeout) * time.Millisecond)
+	if indexMemFrac > 0 {
+		logging.Infof("MutationMgr::MaxIndexQueueMemory %v", int64(indexMemFrac*float64(maxMem)))
+	}
+
 }
 
 func (m *mutationMgr) handleIndexerPause(cmd Message) {
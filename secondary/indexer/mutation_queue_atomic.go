@@ -10,6 +10,7 @@
 package indexer
 
 import (
+	"bytes"
 	"errors"
 	"sync/atomic"
 	"time"
@@ -46,6 +47,9 @@ type MutationQueue interface {
 	//returns the numbers of vbuckets for the queue
 	GetNumVbuckets() uint16
 
+	//return number of mutations coalesced per vbucket
+	GetNumCoalesced(vbucket Vbucket) int64
+
 	//destroy the resources
 	Destroy()
 }
@@ -68,13 +72,17 @@ type atomicMutationQueue struct {
 	head      []unsafe.Pointer //head pointer per vbucket queue
 	tail      []unsafe.Pointer //tail pointer per vbucket queue
 	size      []int64          //size of queue per vbucket
+	coalesced []int64          //num mutations coalesced per vbucket
 	memUsed   *int64           //memory used by queue
 	maxMemory *int64           //max memory to be used
+	indexMem  *indexQueueMem   //memory used per index
 
 	allocPollInterval   uint64 //poll interval for new allocs, if queue is full
 	dequeuePollInterval uint64 //poll interval for dequeue, if waiting for mutations
 	resultChanSize      uint64 //size of buffered result channel
 	minQueueLen         uint64
+	coalesce            bool //coalesce duplicate mutations on dequeue upto seqno
+	memThrottle         bool //throttle enqueue under memory pressure
 
 	free        []*node //free pointer per vbucket queue
 	stopch      []StopChannel
@@ -92,6 +100,7 @@ func NewAtomicMutationQueue(bucket string, numVbuckets uint16, maxMemory *int64,
 		tail:                make([]unsafe.Pointer, numVbuckets),
 		free:                make([]*node, numVbuckets),
 		size:                make([]int64, numVbuckets),
+		coalesced:           make([]int64, numVbuckets),
 		numVbuckets:         numVbuckets,
 		maxMemory:           maxMemory,
 		memUsed:             memUsed,
@@ -100,6 +109,7 @@ func NewAtomicMutationQueue(bucket string, numVbuckets uint16, maxMemory *int64,
 		dequeuePollInterval: config["mutation_queue.dequeuePollInterval"].Uint64(),
 		resultChanSize:      config["mutation_queue.resultChanSize"].Uint64(),
 		minQueueLen:         config["settings.minVbQueueLength"].Uint64(),
+		coalesce:            config["mutation_queue.coalesceDuplicates"].Bool(),
 		bucket:              bucket,
 	}
 
@@ -117,6 +127,18 @@ func NewAtomicMutationQueue(bucket string, numVbuckets uint16, maxMemory *int64,
 
 }
 
+//SetIndexMemory sets the tracker of per index memory used by the queue.
+//It must be called before the queue is used.
+func (q *atomicMutationQueue) SetIndexMemory(indexMem *indexQueueMem) {
+	q.indexMem = indexMem
+}
+
+//SetMemThrottle enables throttling of enqueue while the indexer is
+//under memory pressure. It must be called before the queue is used.
+func (q *atomicMutationQueue) SetMemThrottle(throttle bool) {
+	q.memThrottle = throttle
+}
+
 //Node represents a single element in the queue
 type node struct {
 	mutation *MutationKeys
@@ -141,6 +163,11 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys,
 		return nil
 	}
 
+	//wait for the indexes over their memory limit to drain
+	if !q.throttle(mutation, vbucket, appch) {
+		return nil
+	}
+
 	//create a new node
 	n := q.allocNode(vbucket, appch)
 	if n == nil {
@@ -151,6 +178,9 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys,
 	n.next = nil
 
 	atomic.AddInt64(q.memUsed, n.mutation.Size())
+	if q.indexMem != nil {
+		q.indexMem.add(n.mutation)
+	}
 
 	//point tail's next to new node
 	tail := (*node)(atomic.LoadPointer(&q.tail[vbucket]))
@@ -170,6 +200,8 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys,
 //to be sent. It terminates when it finds a mutation with seqno higher than
 //the one specified as argument. This allow for multiple mutations with same
 //seqno (e.g. in case of multiple indexes)
+//If coalescing is enabled, a mutation immediately followed by another one
+//for the same docid, also upto the seqno, is dropped in favour of the latter.
 //It closes the mutation channel to indicate its done.
 func (q *atomicMutationQueue) DequeueUptoSeqno(vbucket Vbucket, seqno Seqno) (
 	<-chan *MutationKeys, chan bool, error) {
@@ -211,6 +243,15 @@ func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
 				atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 				atomic.AddInt64(&q.size[vbucket], -1)
 				atomic.AddInt64(q.memUsed, -m.Size())
+				if q.indexMem != nil {
+					q.indexMem.release(m)
+				}
+				if q.coalesce && q.isSuperseded(vbucket, head.next, m, seqno) {
+					//only the latest mutation needs to be stored
+					atomic.AddInt64(&q.coalesced[vbucket], 1)
+					m.Free()
+					continue
+				}
 				//send mutation to caller
 				dequeueSeq = m.meta.seqno
 				datach <- m
@@ -232,6 +273,38 @@ func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
 	}
 }
 
+//isSuperseded returns true if mutation m, dequeued from node n, is followed
+//by a mutation for the same docid upto seqno, which has an entry for every
+//index instance in m. Storing the latter alone gives the same index state.
+func (q *atomicMutationQueue) isSuperseded(vbucket Vbucket, n *node,
+	m *MutationKeys, seqno Seqno) bool {
+
+	//next node is only safe to read if n is not the tail
+	if unsafe.Pointer(n) == atomic.LoadPointer(&q.tail[vbucket]) {
+		return false
+	}
+
+	next := n.next.mutation
+	if next == nil || next.meta.seqno > seqno ||
+		len(m.docid) == 0 || !bytes.Equal(m.docid, next.docid) {
+		return false
+	}
+
+	for _, mut := range m.mut {
+		found := false
+		for _, nmut := range next.mut {
+			if nmut.uuid == mut.uuid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 //Dequeue returns a channel on which it will return mutation reference for specified vbucket.
 //This function will keep polling and send mutations as those become available.
 //It returns a stop channel on which caller can signal it to stop.
@@ -291,6 +364,9 @@ func (q *atomicMutationQueue) DequeueSingleElement(vbucket Vbucket) *MutationKey
 		atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 		atomic.AddInt64(&q.size[vbucket], -1)
 		atomic.AddInt64(q.memUsed, -m.Size())
+		if q.indexMem != nil {
+			q.indexMem.release(m)
+		}
 		return m
 	}
 	return nil
@@ -326,6 +402,12 @@ func (q *atomicMutationQueue) GetNumVbuckets() uint16 {
 	return q.numVbuckets
 }
 
+//GetNumCoalesced returns the number of mutations of the vbucket dropped
+//in favour of a later mutation for the same docid
+func (q *atomicMutationQueue) GetNumCoalesced(vbucket Vbucket) int64 {
+	return atomic.LoadInt64(&q.coalesced[vbucket])
+}
+
 //allocNode tries to get node from freelist, otherwise allocates a new node and returns
 func (q *atomicMutationQueue) allocNode(vbucket Vbucket, appch StopChannel) *node {
 
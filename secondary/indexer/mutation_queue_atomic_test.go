@@ -184,6 +184,50 @@ func TestDequeueUptoSeqnoA(t *testing.T) {
 	checkSizeA(t, q, 0, 0)
 }
 
+func TestDequeueUptoSeqnoCoalesceA(t *testing.T) {
+
+	maxMemory = 100 * 1024 * 1024
+	conf := common.SystemConfig.SectionConfig("indexer.", true /*trim*/)
+	conf.SetValue("mutation_queue.coalesceDuplicates", true)
+
+	q := NewAtomicMutationQueue("default", 1, &maxMemory, &memUsed, conf)
+
+	newMutk := func(docid string, seqno Seqno, uuids ...common.IndexInstId) *MutationKeys {
+		mk := &MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: seqno},
+			docid: []byte(docid)}
+		for _, uuid := range uuids {
+			mk.mut = append(mk.mut, &Mutation{uuid: uuid, command: common.Upsert})
+		}
+		return mk
+	}
+
+	m := []*MutationKeys{
+		newMutk("a", 1, 1),
+		newMutk("a", 2, 1),    //supersedes previous
+		newMutk("b", 3, 1, 2), //not superseded, next has no entry for 2
+		newMutk("b", 3, 1),    //not superseded, next is beyond seqno
+		newMutk("b", 4, 1),
+	}
+	for _, mk := range m {
+		q.Enqueue(mk, 0, nil)
+	}
+
+	ch, _, _ := q.DequeueUptoSeqno(0, 3)
+
+	i := 1
+	for p := range ch {
+		checkItemA(t, m[i], p)
+		i++
+	}
+	if i != 4 {
+		t.Errorf("expected 3 mutations on dequeue, got %v", i-1)
+	}
+	if n := q.GetNumCoalesced(0); n != 1 {
+		t.Errorf("expected 1 coalesced mutation, got %v", n)
+	}
+	checkSizeA(t, q, 0, 1)
+}
+
 func TestDequeueA(t *testing.T) {
 
 	maxMemory = 100 * 1024 * 1024
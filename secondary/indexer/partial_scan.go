@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  partial scans
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// Vbuckets of a stream exceeding indexer.settings.stream.vb_repair_budget
+// are quarantined by the timekeeper, until the vbucket receives
+// StreamBegin again. Quarantined vbuckets are retried with an exponential
+// backoff, from VB_QUARANTINE_RETRY_MIN up to VB_QUARANTINE_RETRY_MAX.
+// Till then mutations of quarantined vbuckets are not received, so a scan
+// waiting for them to be consistent can only time out.
+//
+// Consistent scans on indexes with quarantined vbuckets fail with
+// ErrVbucketsQuarantined, and are retried by the client on a replica.
+// Consistent scans waiting on vbuckets failed over (StreamEnd or
+// connection error) but still being repaired wait as before.
+//
+// A scan request with allowPartial skips both the quarantined and the
+// failed over vbuckets in its consistency timestamp, and lists them in
+// missingVbuckets of the first response. Rows of missing vbuckets are
+// returned as of the last mutation received, and may be stale.
+//
+// The missing vbuckets are tracked by the timekeeper, in a missingVbs
+// created by the indexer and shared with the scan coordinator.
+//
+
+type bucketMissingVbs struct {
+	quarantined []uint32
+	missing     []uint32 //quarantined and failed over
+}
+
+type missingVbs struct {
+	mu  sync.RWMutex
+	vbs map[common.StreamId]map[string]bucketMissingVbs
+}
+
+func newMissingVbs() *missingVbs {
+	return &missingVbs{
+		vbs: make(map[common.StreamId]map[string]bucketMissingVbs),
+	}
+}
+
+// set replaces the missing vbuckets of the stream and bucket, clearing
+// them if none is missing.
+func (m *missingVbs) set(streamId common.StreamId, bucket string,
+	quarantined, missing []Vbucket) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(missing) == 0 {
+		delete(m.vbs[streamId], bucket)
+		return
+	}
+
+	buckets, ok := m.vbs[streamId]
+	if !ok {
+		buckets = make(map[string]bucketMissingVbs)
+		m.vbs[streamId] = buckets
+	}
+	buckets[bucket] = bucketMissingVbs{
+		quarantined: sortedVbList(quarantined),
+		missing:     sortedVbList(missing),
+	}
+}
+
+// get returns the quarantined and all the missing vbuckets of the stream
+// and bucket, in ascending order.
+func (m *missingVbs) get(streamId common.StreamId, bucket string) (quarantined, missing []uint32) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	vbs := m.vbs[streamId][bucket]
+	return vbs.quarantined, vbs.missing
+}
+
+// has returns true if any vbucket of the stream and bucket is missing.
+func (m *missingVbs) has(streamId common.StreamId, bucket string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.vbs[streamId][bucket]
+	return ok
+}
+
+func sortedVbList(vbs []Vbucket) []uint32 {
+	if len(vbs) == 0 {
+		return nil
+	}
+
+	vbList := make([]uint32, 0, len(vbs))
+	for _, vb := range vbs {
+		vbList = append(vbList, uint32(vb))
+	}
+	sort.Slice(vbList, func(i, j int) bool { return vbList[i] < vbList[j] })
+	return vbList
+}
+
+// checkPartialScan fails a consistent scan on an index with quarantined
+// vbuckets, unless the request allows partial results. w is nil for scans
+// not returning rows to the client.
+func (s *scanCoordinator) checkPartialScan(req *ScanRequest, w *protoResponseWriter) error {
+
+	quarantined, missing := s.missingVbs.get(req.IndexInst.Stream, req.Bucket)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if !req.AllowPartial {
+		if len(quarantined) == 0 ||
+			req.Consistency == nil || *req.Consistency == common.AnyConsistency {
+			return nil
+		}
+		return ErrVbucketsQuarantined
+	}
+
+	if req.Ts != nil {
+		for _, vb := range missing {
+			if int(vb) < len(req.Ts.Seqnos) {
+				req.Ts.Seqnos[vb] = 0
+			}
+			if int(vb) < len(req.Ts.Vbuuids) {
+				req.Ts.Vbuuids[vb] = 0
+			}
+		}
+	}
+	if w != nil {
+		w.missingVbuckets = missing
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCheckPartialScan(t *testing.T) {
+	streamId, bucket := common.MAINT_STREAM, "default"
+	s := &scanCoordinator{missingVbs: newMissingVbs()}
+
+	newReq := func(cons common.Consistency, allowPartial bool) *ScanRequest {
+		ts := common.NewTsVbuuid(bucket, 4)
+		for i := range ts.Seqnos {
+			ts.Seqnos[i], ts.Vbuuids[i] = 10, 100
+		}
+		req := &ScanRequest{Bucket: bucket, Ts: ts, AllowPartial: allowPartial}
+		req.IndexInst.Stream = streamId
+		if cons != 0 {
+			req.Consistency = &cons
+		}
+		return req
+	}
+
+	// nothing missing
+	w := &protoResponseWriter{}
+	if err := s.checkPartialScan(newReq(common.SessionConsistency, false), w); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// failed over vbuckets are waited for by consistent scans, and
+	// skipped by partial scans
+	s.missingVbs.set(streamId, bucket, nil, []Vbucket{2})
+	if err := s.checkPartialScan(newReq(common.SessionConsistency, false), w); err != nil {
+		t.Fatalf("Unexpected error for failed over vbucket %v", err)
+	}
+	req := newReq(common.SessionConsistency, true)
+	if err := s.checkPartialScan(req, w); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(w.missingVbuckets, []uint32{2}) {
+		t.Fatalf("Expected missing vbuckets [2], got %v", w.missingVbuckets)
+	}
+	if req.Ts.Seqnos[2] != 0 || req.Ts.Vbuuids[2] != 0 || req.Ts.Seqnos[1] != 10 {
+		t.Fatalf("Expected only vbucket 2 to be skipped, got %v", req.Ts)
+	}
+
+	// quarantined vbuckets fail consistent scans
+	s.missingVbs.set(streamId, bucket, []Vbucket{3}, []Vbucket{3, 2})
+	if err := s.checkPartialScan(newReq(common.SessionConsistency, false), w); err != ErrVbucketsQuarantined {
+		t.Fatalf("Expected %v, got %v", ErrVbucketsQuarantined, err)
+	}
+	if err := s.checkPartialScan(newReq(common.AnyConsistency, false), w); err != nil {
+		t.Fatalf("Unexpected error for stale scan %v", err)
+	}
+	if err := s.checkPartialScan(newReq(0, false), w); err != nil {
+		t.Fatalf("Unexpected error without consistency %v", err)
+	}
+
+	w = &protoResponseWriter{}
+	req = newReq(common.QueryConsistency, true)
+	if err := s.checkPartialScan(req, w); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(w.missingVbuckets, []uint32{2, 3}) {
+		t.Fatalf("Expected missing vbuckets [2 3], got %v", w.missingVbuckets)
+	}
+	if req.Ts.Seqnos[2] != 0 || req.Ts.Seqnos[3] != 0 {
+		t.Fatalf("Expected vbuckets 2 and 3 to be skipped, got %v", req.Ts)
+	}
+
+	// scans without a response writer
+	if err := s.checkPartialScan(newReq(common.SessionConsistency, true), nil); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	s.missingVbs.set(streamId, bucket, nil, nil)
+	if s.missingVbs.has(streamId, bucket) {
+		t.Fatalf("Expected no missing vbuckets once cleared")
+	}
+}
+
+func TestUpdateMissingVbs(t *testing.T) {
+	ss := InitStreamState(common.Config{
+		"numVbuckets":                      common.ConfigValue{Value: 4},
+		"settings.stream.vb_repair_budget": common.ConfigValue{Value: 1},
+	})
+	streamId, bucket := common.MAINT_STREAM, "default"
+	ss.initNewStream(streamId)
+	ss.initBucketInStream(streamId, bucket)
+	defer ss.cleanupBucketFromStream(streamId, bucket)
+	tk := &timekeeper{ss: ss, missingVbs: newMissingVbs()}
+
+	ss.streamBucketVbStatusMap[streamId][bucket][1] = VBS_STREAM_END
+	ss.streamBucketVbStatusMap[streamId][bucket][2] = VBS_CONN_ERROR
+	tk.updateMissingVbs(streamId, bucket)
+	quarantined, missing := tk.missingVbs.get(streamId, bucket)
+	if len(quarantined) != 0 || !reflect.DeepEqual(missing, []uint32{1, 2}) {
+		t.Fatalf("Expected failed over vbuckets [1 2], got %v %v", quarantined, missing)
+	}
+
+	ss.countVbRepairs(streamId, bucket)
+	ss.countVbRepairs(streamId, bucket)
+	tk.updateMissingVbs(streamId, bucket)
+	quarantined, missing = tk.missingVbs.get(streamId, bucket)
+	if !reflect.DeepEqual(quarantined, []uint32{1, 2}) || !reflect.DeepEqual(missing, []uint32{1, 2}) {
+		t.Fatalf("Expected quarantined vbuckets [1 2], got %v %v", quarantined, missing)
+	}
+
+	ss.updateVbStatus(streamId, bucket, []Vbucket{1, 2}, VBS_STREAM_BEGIN)
+	ss.clearVbRepairCount(streamId, bucket, 1)
+	ss.clearVbRepairCount(streamId, bucket, 2)
+	tk.updateMissingVbs(streamId, bucket)
+	if tk.missingVbs.has(streamId, bucket) {
+		t.Fatalf("Expected no missing vbuckets after StreamBegin")
+	}
+
+	ss.streamBucketVbStatusMap[streamId][bucket][3] = VBS_STREAM_END
+	tk.updateMissingVbs(streamId, bucket)
+	tk.clearMissingVbs(streamId, bucket)
+	if tk.missingVbs.has(streamId, bucket) {
+		t.Fatalf("Expected no missing vbuckets after cleanup")
+	}
+}
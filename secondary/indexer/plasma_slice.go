@@ -475,6 +475,7 @@ loop:
 		var nmut int
 		select {
 		case icmd = <-mdb.cmdCh[workerId]:
+			acquired := gIngestCPU.acquire()
 			switch icmd.op {
 			case opUpdate, opInsert:
 				start = time.Now()
@@ -492,6 +493,7 @@ loop:
 				logging.Errorf("plasmaSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v PartitionId %v Received "+
 					"Unknown Command %v", mdb.id, mdb.idxInstId, mdb.idxPartnId, logging.TagUD(icmd))
 			}
+			gIngestCPU.release(acquired)
 
 			mdb.idxStats.numItemsFlushed.Add(int64(nmut))
 			mdb.idxStats.numDocsIndexed.Add(1)
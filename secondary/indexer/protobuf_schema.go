@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/couchbase/indexing/secondary/common"
+	dataproto "github.com/couchbase/indexing/secondary/protobuf/data"
+	projproto "github.com/couchbase/indexing/secondary/protobuf/projector"
+	queryproto "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+// handleProtobufSchemasReq serves the protobuf schemas of dataport,
+// adminport and queryport messages, as compiled into the indexer, for
+// tools decoding captured messages. A single schema is returned if
+// requested by name.
+func (s *settingsManager) handleProtobufSchemasReq(w http.ResponseWriter, r *http.Request) {
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method != "GET" {
+		s.writeError(w, errors.New("Unsupported method"))
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.settings!read"}, w) {
+		return
+	}
+
+	schemas := []*common.ProtoSchema{
+		dataproto.Schema(),
+		projproto.Schema(),
+		queryproto.Schema(),
+	}
+
+	var data interface{} = schemas
+	if name := r.FormValue("name"); name != "" {
+		data = nil
+		for _, schema := range schemas {
+			if schema.Name == name {
+				data = schema
+			}
+		}
+		if data == nil {
+			s.writeError(w, errors.New("Unknown schema "+name))
+			return
+		}
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJson(w, buf)
+}
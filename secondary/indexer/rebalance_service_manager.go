@@ -27,6 +27,7 @@ import (
 	"errors"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 
 	"encoding/json"
@@ -180,6 +181,8 @@ func (m *ServiceMgr) initService(cleanupPending bool) {
 	http.HandleFunc("/cleanupRebalance", m.handleCleanupRebalance)
 	http.HandleFunc("/moveIndex", m.handleMoveIndex)
 	http.HandleFunc("/moveIndexInternal", m.handleMoveIndexInternal)
+	http.HandleFunc("/dryRunMoveIndexInternal", m.handleDryRunMoveIndexInternal)
+	http.HandleFunc("/repartitionIndex", m.handleRepartitionIndex)
 	http.HandleFunc("/nodeuuid", m.handleNodeuuid)
 }
 
@@ -2315,6 +2318,110 @@ func (m *ServiceMgr) handleMoveIndexInternal(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+//handleDryRunMoveIndexInternal validates a move index request, and
+//returns the placement of the index after the move, without moving it.
+func (m *ServiceMgr) handleDryRunMoveIndexInternal(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuth(w, r)
+	if !ok {
+		l.Errorf("ServiceMgr::handleDryRunMoveIndexInternal Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method == "POST" {
+		bytes, _ := ioutil.ReadAll(r.Body)
+		var req manager.IndexRequest
+		if err := json.Unmarshal(bytes, &req); err != nil {
+			l.Errorf("ServiceMgr::handleDryRunMoveIndexInternal %v", err)
+			sendIndexResponseWithError(http.StatusBadRequest, w, err.Error())
+			return
+		}
+
+		permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!alter", req.Index.Bucket)
+		if !c.IsAllowed(creds, []string{permission}, w) {
+			return
+		}
+
+		result, code, err := m.dryRunMoveIndex(&req)
+		if err != nil {
+			l.Errorf("ServiceMgr::handleDryRunMoveIndexInternal %v", err)
+			sendIndexResponseWithError(code, w, err.Error())
+			return
+		}
+		send(http.StatusOK, w, &manager.IndexResponse{Code: manager.RESP_SUCCESS, DryRun: result})
+
+	} else {
+		sendIndexResponseWithError(http.StatusBadRequest, w, "Unsupported method")
+		return
+	}
+}
+
+//dryRunMoveIndex runs the validations of a move index request and
+//generates its transfer tokens, without registering a move index token
+//or starting the rebalancer.
+func (m *ServiceMgr) dryRunMoveIndex(req *manager.IndexRequest) (*client.DryRunResult, int, error) {
+
+	nodes, err := validateMoveIndexReq(req)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkTransferIndexLOCKED(); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	transferTokens, err := m.generateTransferTokenForMoveIndex(req, nodes)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if len(transferTokens) == 0 {
+		return nil, http.StatusBadRequest,
+			errors.New("No Index Movement Required for Specified Destination List")
+	}
+
+	nodeAddrs := make(map[string]string)
+	for _, node := range nodes {
+		if nodeUUID, err := m.getNodeIdFromDest(node); err == nil {
+			nodeAddrs[nodeUUID] = node
+		}
+	}
+
+	return moveIndexDryRunResult(transferTokens, nodeAddrs), http.StatusOK, nil
+}
+
+//moveIndexDryRunResult returns the placement of the instances moved by
+//transferTokens, nodeAddrs maps node uuid to node address.
+func moveIndexDryRunResult(transferTokens map[string]*c.TransferToken,
+	nodeAddrs map[string]string) *client.DryRunResult {
+
+	result := &client.DryRunResult{Operation: "alter"}
+	for _, tt := range transferTokens {
+		if result.Definition == nil {
+			defn := tt.IndexInst.Defn
+			defn.Partitions, defn.Versions = nil, nil
+			result.Definition = &defn
+		}
+		partitions := append([]c.PartitionId(nil), tt.IndexInst.Defn.Partitions...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		result.Placement = append(result.Placement, client.DryRunPlacement{
+			ReplicaId:  tt.IndexInst.ReplicaId,
+			IndexerId:  c.IndexerId(tt.DestId),
+			NodeAddr:   nodeAddrs[tt.DestId],
+			Partitions: partitions,
+		})
+	}
+	sort.Slice(result.Placement, func(i, j int) bool {
+		if result.Placement[i].ReplicaId != result.Placement[j].ReplicaId {
+			return result.Placement[i].ReplicaId < result.Placement[j].ReplicaId
+		}
+		return result.Placement[i].IndexerId < result.Placement[j].IndexerId
+	})
+	return result
+}
+
 func (m *ServiceMgr) doHandleMoveIndex(req *manager.IndexRequest) (int, string) {
 
 	l.Infof("ServiceMgr::doHandleMoveIndex %v", l.TagUD(req))
@@ -2334,14 +2441,17 @@ func (m *ServiceMgr) doHandleMoveIndex(req *manager.IndexRequest) (int, string)
 		l.Warnf("ServiceMgr::doHandleMoveIndex %v", warnStr)
 		return http.StatusBadRequest, warnStr
 	} else {
-		go m.monitorMoveIndex()
+		desc := fmt.Sprintf("Move index %v to %v", req.IndexIds.DefnIds, nodes)
+		task := c.NewTask(c.TaskMoveIndex, desc, nil)
+		go m.monitorMoveIndex(task)
 		return http.StatusOK, ""
 	}
 }
 
-func (m *ServiceMgr) monitorMoveIndex() {
+func (m *ServiceMgr) monitorMoveIndex(task *c.Task) {
 	select {
 	case err := <-m.moveStatusCh:
+		task.Done(err)
 		if err != nil {
 			cfg := m.config.Load()
 			clusterAddr := cfg["clusterAddr"].String()
@@ -2355,49 +2465,32 @@ func (m *ServiceMgr) monitorMoveIndex() {
 
 func (m *ServiceMgr) initMoveIndex(req *manager.IndexRequest, nodes []string) (error, bool) {
 
+	return m.initTransferIndex(func() (map[string]*c.TransferToken, error) {
+		return m.generateTransferTokenForMoveIndex(req, nodes)
+	})
+}
+
+// initTransferIndex starts a rebalancer, outside of cluster rebalance,
+// for the transfer tokens returned by genTokens. Used by move index and
+// repartition index. Returns true if there is nothing to transfer.
+func (m *ServiceMgr) initTransferIndex(genTokens func() (map[string]*c.TransferToken, error)) (error, bool) {
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	var err error
-	if !m.indexerReady {
-		l.Errorf("ServiceMgr::initMoveIndex Cannot Process Request %v", c.ErrIndexerInBootstrap)
-		return c.ErrIndexerInBootstrap, false
-	}
-
-	if m.checkRebalanceRunning() {
-		err = errors.New("Cannot Process Move Index - Rebalance/MoveIndex In Progress")
-		l.Errorf("ServiceMgr::initMoveIndex %v", err)
+	if err = m.checkTransferIndexLOCKED(); err != nil {
 		return err, false
 	}
 
-	if m.state.rebalanceID != "" {
-		err = errors.New("Cannot Process Move Index - Failover In Progress")
-		l.Errorf("ServiceMgr::initMoveIndex %v", err)
-		return err, false
-	}
-
-	//check globally as move index init happens on only one node
-	if m.checkGlobalCleanupPending() {
-		err = errors.New("Cannot Process Move Index - cleanup pending from previous " +
-			"failed/aborted rebalance/failover/move index. please retry the request later.")
-		l.Errorf("ServiceMgr::initMoveIndex %v", err)
-		return err, false
-	}
-
-	cfg := m.config.Load()
-	allWarmedup, _ := checkAllIndexersWarmedup(cfg["clusterAddr"].String())
-	if !allWarmedup {
-		return errors.New("Cannot Process Move Index - All Indexers are not Active"), false
-	}
-
 	if err := m.genMoveIndexToken(); err != nil {
 		m.rebalanceToken = nil
 		return err, false
 	}
 
-	l.Infof("ServiceMgr::handleMoveIndex New Move Index Token %v Dest %v", m.rebalanceToken, nodes)
+	l.Infof("ServiceMgr::initTransferIndex New Move Index Token %v", m.rebalanceToken)
 
-	transferTokens, err := m.generateTransferTokenForMoveIndex(req, nodes)
+	transferTokens, err := genTokens()
 	if err != nil {
 		m.rebalanceToken = nil
 		return err, false
@@ -2436,6 +2529,44 @@ func (m *ServiceMgr) initMoveIndex(req *manager.IndexRequest, nodes []string) (e
 
 }
 
+//checkTransferIndexLOCKED returns an error if an index cannot be moved
+//now, must be called with m.mu held.
+func (m *ServiceMgr) checkTransferIndexLOCKED() error {
+
+	var err error
+	if !m.indexerReady {
+		l.Errorf("ServiceMgr::initTransferIndex Cannot Process Request %v", c.ErrIndexerInBootstrap)
+		return c.ErrIndexerInBootstrap
+	}
+
+	if m.checkRebalanceRunning() {
+		err = errors.New("Cannot Process Move Index - Rebalance/MoveIndex In Progress")
+		l.Errorf("ServiceMgr::initTransferIndex %v", err)
+		return err
+	}
+
+	if m.state.rebalanceID != "" {
+		err = errors.New("Cannot Process Move Index - Failover In Progress")
+		l.Errorf("ServiceMgr::initTransferIndex %v", err)
+		return err
+	}
+
+	//check globally as move index init happens on only one node
+	if m.checkGlobalCleanupPending() {
+		err = errors.New("Cannot Process Move Index - cleanup pending from previous " +
+			"failed/aborted rebalance/failover/move index. please retry the request later.")
+		l.Errorf("ServiceMgr::initTransferIndex %v", err)
+		return err
+	}
+
+	cfg := m.config.Load()
+	allWarmedup, _ := checkAllIndexersWarmedup(cfg["clusterAddr"].String())
+	if !allWarmedup {
+		return errors.New("Cannot Process Move Index - All Indexers are not Active")
+	}
+	return nil
+}
+
 func (m *ServiceMgr) genMoveIndexToken() error {
 
 	cfg := m.config.Load()
@@ -2647,12 +2778,18 @@ func (m *ServiceMgr) genTransferToken(indexInst *c.IndexInst, sourceId string, d
 
 	ustr, _ := c.NewUUID()
 
+	//there is no rebalance token for a dry run
+	var rebalId string
+	if m.rebalanceToken != nil {
+		rebalId = m.rebalanceToken.RebalId
+	}
+
 	ttid := fmt.Sprintf("TransferToken%s", ustr.Str())
 	tt := &c.TransferToken{
 		MasterId:  string(m.nodeInfo.NodeID),
 		SourceId:  sourceId,
 		DestId:    destId,
-		RebalId:   m.rebalanceToken.RebalId,
+		RebalId:   rebalId,
 		State:     c.TransferTokenCreated,
 		InstId:    indexInst.InstId,
 		IndexInst: *indexInst,
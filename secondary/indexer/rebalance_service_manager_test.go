@@ -0,0 +1,65 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager"
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func TestMoveIndexDryRunResult(t *testing.T) {
+	newToken := func(replicaId int, destId string, partitions ...c.PartitionId) *c.TransferToken {
+		tt := &c.TransferToken{SourceId: "src", DestId: destId}
+		tt.IndexInst.ReplicaId = replicaId
+		tt.IndexInst.Defn = c.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx",
+			Partitions: partitions, Versions: make([]int, len(partitions))}
+		return tt
+	}
+	tokens := map[string]*c.TransferToken{
+		"tt1": newToken(1, "node2", 3, 1),
+		"tt2": newToken(0, "node3", 2),
+	}
+
+	result := moveIndexDryRunResult(tokens, map[string]string{"node2": "host2:8091"})
+	if result.Operation != "alter" || result.Definition == nil ||
+		result.Definition.Name != "idx" || result.Definition.Partitions != nil {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	expected := []client.DryRunPlacement{
+		{ReplicaId: 0, IndexerId: "node3", Partitions: []c.PartitionId{2}},
+		{ReplicaId: 1, IndexerId: "node2", NodeAddr: "host2:8091", Partitions: []c.PartitionId{1, 3}},
+	}
+	if !reflect.DeepEqual(result.Placement, expected) {
+		t.Fatalf("Expected placement %+v, got %+v", expected, result.Placement)
+	}
+	// the tokens are not modified.
+	if parts := tokens["tt1"].IndexInst.Defn.Partitions; parts[0] != 3 {
+		t.Fatalf("Unexpected change to transfer token partitions %v", parts)
+	}
+}
+
+func TestValidateMoveIndexDryRun(t *testing.T) {
+	m := &ServiceMgr{}
+	invalid := []manager.IndexRequest{
+		{},
+		{IndexIds: client.IndexIdList{DefnIds: []uint64{1}}},
+		{IndexIds: client.IndexIdList{DefnIds: []uint64{1}},
+			Plan: map[string]interface{}{"nodes": []interface{}{"n1", "n1"}}},
+	}
+	for _, req := range invalid {
+		if _, _, err := m.dryRunMoveIndex(&req); err == nil {
+			t.Errorf("Expected error for %+v", req)
+		}
+	}
+}
@@ -656,6 +656,12 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 	switch tt.State {
 	case c.TransferTokenCreated:
 
+		// bootstrap from a peer snapshot, if enabled, before the index
+		// is created, so that the slice is opened on the shipped file.
+		if r.shipSnapshot(tt) {
+			tt.BuildSource = c.TokenBuildSourcePeer
+		}
+
 		indexDefn := tt.IndexInst.Defn
 		indexDefn.Nodes = nil
 		indexDefn.Deferred = true
@@ -0,0 +1,422 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	l "github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  repartition index implementation
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// Changing the partition count of a hash partitioned index rehashes
+// every entry, so partitions cannot be split or merged in place.
+// Instead, for every replica of the index, a new instance with the new
+// partition count is built from the stream, on the nodes hosting the
+// replica, using the move index machinery with copy-mode transfer
+// tokens. Each instance carries its own partition map in topology, and
+// scans never combine partitions of instances with different partition
+// counts. Once all new instances are active, the old instances are
+// dropped, switching the index over to the new partition map. If the
+// build fails, the new instances are dropped instead.
+//
+// A new instance gets a replica id of its own, as it coexists with the
+// old replicas till they are dropped. As every node holds a second copy
+// of its replicas in the meantime, repartition is rejected if a node
+// does not have the memory quota for the copy.
+//
+
+var RepartitionIndexStarted = "Repartition Index has started. Check Indexes UI for progress and Logs UI for any error"
+
+// repartitionInst is an instance of the index being repartitioned, and
+// the nodes hosting its partitions.
+type repartitionInst struct {
+	defn      c.IndexDefn
+	instId    c.IndexInstId
+	replicaId int
+	nodes     []string
+}
+
+func (m *ServiceMgr) handleRepartitionIndex(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuth(w, r)
+	if !ok {
+		l.Errorf("ServiceMgr::handleRepartitionIndex Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method != "POST" {
+		sendIndexResponseWithError(http.StatusBadRequest, w, "Unsupported method")
+		return
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	in := make(map[string]interface{})
+	if err := json.Unmarshal(buf, &in); err != nil {
+		send(http.StatusBadRequest, w, err.Error())
+		return
+	}
+
+	bucket, ok := in["bucket"].(string)
+	if !ok {
+		send(http.StatusBadRequest, w, "Bad Request - Bucket Information Missing")
+		return
+	}
+
+	index, ok := in["index"].(string)
+	if !ok {
+		send(http.StatusBadRequest, w, "Bad Request - Index Information Missing")
+		return
+	}
+
+	numPartitions, ok := in["numPartitions"].(float64)
+	if !ok || numPartitions < 1 || numPartitions != float64(int(numPartitions)) {
+		send(http.StatusBadRequest, w, "Bad Request - Invalid numPartitions")
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!alter", bucket)
+	if !c.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	code, errStr := m.doHandleRepartitionIndex(bucket, index, int(numPartitions))
+	if errStr != "" {
+		sendIndexResponseWithError(code, w, errStr)
+	} else {
+		sendIndexResponseMsg(w, RepartitionIndexStarted)
+	}
+}
+
+func (m *ServiceMgr) doHandleRepartitionIndex(bucket string, index string, numPartitions int) (int, string) {
+
+	l.Infof("ServiceMgr::doHandleRepartitionIndex %v:%v numPartitions %v", bucket, index, numPartitions)
+
+	var oldInsts, newInsts []*repartitionInst
+	err, noop := m.initTransferIndex(func() (map[string]*c.TransferToken, error) {
+		var tokens map[string]*c.TransferToken
+		var err error
+		tokens, oldInsts, newInsts, err = m.generateTransferTokenForRepartition(bucket, index, numPartitions)
+		return tokens, err
+	})
+	if err != nil {
+		l.Errorf("ServiceMgr::doHandleRepartitionIndex %v %v", err, m.rebalanceToken)
+		return http.StatusInternalServerError, err.Error()
+	} else if noop {
+		warnStr := fmt.Sprintf("Index %v:%v already has %v partitions", bucket, index, numPartitions)
+		l.Warnf("ServiceMgr::doHandleRepartitionIndex %v", warnStr)
+		return http.StatusBadRequest, warnStr
+	}
+
+	desc := fmt.Sprintf("Repartition index %v:%v to %v partitions", bucket, index, numPartitions)
+	task := c.NewTask(c.TaskMoveIndex, desc, nil)
+	go m.monitorRepartitionIndex(task, oldInsts, newInsts)
+	return http.StatusOK, ""
+}
+
+// monitorRepartitionIndex waits for the new instances to be built, and
+// drops the old instances, or the new instances if the build failed.
+func (m *ServiceMgr) monitorRepartitionIndex(task *c.Task, oldInsts, newInsts []*repartitionInst) {
+
+	err := <-m.moveStatusCh
+	if err == nil {
+		err = m.dropRepartitionedInsts(oldInsts)
+	} else {
+		for _, inst := range newInsts {
+			if err := m.dropRepartitionedInst(inst); err != nil {
+				l.Errorf("ServiceMgr::monitorRepartitionIndex Fail to cleanup instance %v: %v",
+					inst.instId, err)
+			}
+		}
+	}
+	task.Done(err)
+
+	if err != nil {
+		cfg := m.config.Load()
+		l.Errorf("ServiceMgr::monitorRepartitionIndex RepartitionIndex failed: %v", err)
+		c.Console(cfg["clusterAddr"].String(), fmt.Sprintf("RepartitionIndex failed: %v", err))
+	} else {
+		l.Infof("ServiceMgr: Repartition Index succeeded")
+	}
+}
+
+func (m *ServiceMgr) dropRepartitionedInsts(insts []*repartitionInst) error {
+
+	for _, inst := range insts {
+		if err := m.dropRepartitionedInst(inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropRepartitionedInst drops the instance on all nodes hosting it.
+func (m *ServiceMgr) dropRepartitionedInst(inst *repartitionInst) error {
+
+	cfg := m.config.Load()
+	defn := inst.defn
+	defn.InstId = inst.instId
+	defn.ReplicaId = inst.replicaId
+	defn.RealInstId = 0
+	defn.Partitions = nil
+	defn.Versions = nil
+
+	body, err := json.Marshal(&manager.IndexRequest{Index: defn})
+	if err != nil {
+		return err
+	}
+
+	for _, nodeId := range inst.nodes {
+		addr, err := getIndexerAddrByNodeId(cfg["clusterAddr"].String(), nodeId)
+		if err != nil {
+			return err
+		}
+
+		resp, err := postWithAuth(addr+"/dropIndex", "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			return err
+		}
+
+		response := new(manager.IndexResponse)
+		if err := convertResponse(resp, response); err != nil {
+			return err
+		}
+		if response.Code == manager.RESP_ERROR {
+			return fmt.Errorf("Fail to drop instance %v on %v: %v", inst.instId, addr, response.Error)
+		}
+
+		l.Infof("ServiceMgr::dropRepartitionedInst Dropped instance %v on %v", inst.instId, addr)
+	}
+	return nil
+}
+
+// getNodeStats returns the indexer stats of the node with node uuid
+// nodeId.
+func (m *ServiceMgr) getNodeStats(nodeId string) (c.Statistics, error) {
+
+	cfg := m.config.Load()
+	addr, err := getIndexerAddrByNodeId(cfg["clusterAddr"].String(), nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := getWithAuth(addr + "/stats?async=true")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(c.Statistics)
+	if err := convertResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// checkRepartitionMemory verifies that every node hosting instances
+// being repartitioned has the memory quota to hold a second copy of
+// them, while the old and new instances coexist.
+func checkRepartitionMemory(insts []*repartitionInst, bucket, index string,
+	getStats func(nodeId string) (c.Statistics, error)) error {
+
+	needed := make(map[string]float64)
+	stats := make(map[string]c.Statistics)
+	for _, inst := range insts {
+		for _, nodeId := range inst.nodes {
+			if _, ok := stats[nodeId]; !ok {
+				nodeStats, err := getStats(nodeId)
+				if err != nil {
+					return fmt.Errorf("Fail to get stats of node %v: %v", nodeId, err)
+				}
+				stats[nodeId] = nodeStats
+			}
+			name := c.FormatIndexInstDisplayName(index, inst.replicaId)
+			used, _ := stats[nodeId][fmt.Sprintf("%s:%s:memory_used", bucket, name)].(float64)
+			needed[nodeId] += used
+		}
+	}
+
+	for nodeId, need := range needed {
+		quota, _ := stats[nodeId]["memory_quota"].(float64)
+		used, _ := stats[nodeId]["memory_used"].(float64)
+		if quota > 0 && used+need > quota {
+			return fmt.Errorf("Insufficient memory quota on node %v to repartition index, "+
+				"needs %v bytes, %v of %v bytes used", nodeId, int64(need), int64(used), int64(quota))
+		}
+	}
+	return nil
+}
+
+// generateTransferTokenForRepartition generates copy-mode transfer
+// tokens building a new instance with numPartitions partitions, for
+// every replica of the index. Returns the tokens, the instances being
+// replaced and the new instances.
+func (m *ServiceMgr) generateTransferTokenForRepartition(bucket string, index string,
+	numPartitions int) (map[string]*c.TransferToken, []*repartitionInst, []*repartitionInst, error) {
+
+	topology, err := getGlobalTopology(m.localhttp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	insts := make(map[c.IndexInstId]*repartitionInst)
+	replicaIds := make(map[int]bool)
+	for _, localMeta := range topology.Metadata {
+
+		t := findTopologyByBucket(localMeta.IndexTopologies, bucket)
+		if t == nil {
+			continue
+		}
+		defnDist := t.FindIndexDefinition(bucket, index)
+		if defnDist == nil {
+			continue
+		}
+
+		var defn *c.IndexDefn
+		for i := range localMeta.IndexDefinitions {
+			if localMeta.IndexDefinitions[i].DefnId == c.IndexDefnId(defnDist.DefnId) {
+				defn = &localMeta.IndexDefinitions[i]
+			}
+		}
+		if defn == nil {
+			continue
+		}
+
+		if defn.PartitionScheme != c.KEY {
+			return nil, nil, nil, errors.New("Only hash partitioned index can be repartitioned")
+		}
+
+		for _, instDist := range defnDist.Instances {
+			if c.IndexState(instDist.State) != c.INDEX_STATE_ACTIVE ||
+				c.RebalanceState(instDist.RState) != c.REBAL_ACTIVE {
+				return nil, nil, nil, fmt.Errorf("Index instance %v is not active", instDist.InstId)
+			}
+			replicaIds[int(instDist.ReplicaId)] = true
+
+			if int(instDist.NumPartitions) == numPartitions {
+				continue
+			}
+
+			inst, ok := insts[c.IndexInstId(instDist.InstId)]
+			if !ok {
+				inst = &repartitionInst{
+					defn:      *defn,
+					instId:    c.IndexInstId(instDist.InstId),
+					replicaId: int(instDist.ReplicaId),
+				}
+				insts[inst.instId] = inst
+			}
+			inst.nodes = append(inst.nodes, localMeta.NodeUUID)
+		}
+	}
+
+	var oldInsts []*repartitionInst
+	for _, inst := range insts {
+		sort.Strings(inst.nodes)
+		oldInsts = append(oldInsts, inst)
+	}
+	sort.Slice(oldInsts, func(i, j int) bool { return oldInsts[i].replicaId < oldInsts[j].replicaId })
+
+	if err := checkRepartitionMemory(oldInsts, bucket, index, m.getNodeStats); err != nil {
+		return nil, nil, nil, err
+	}
+
+	newInsts := make([]*repartitionInst, 0, len(oldInsts))
+	for _, inst := range oldInsts {
+		newInstId, err := c.NewIndexInstId()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Fail to generate transfer token.  Reason: %v", err)
+		}
+		newInsts = append(newInsts, &repartitionInst{
+			defn:      inst.defn,
+			instId:    newInstId,
+			replicaId: nextReplicaId(replicaIds),
+			nodes:     inst.nodes,
+		})
+	}
+
+	transferTokens := make(map[string]*c.TransferToken)
+	for _, tt := range repartitionTokens(newInsts, numPartitions, string(m.nodeInfo.NodeID),
+		m.rebalanceToken.RebalId) {
+		ustr, _ := c.NewUUID()
+		ttid := fmt.Sprintf("TransferToken%s", ustr.Str())
+		l.Infof("ServiceMgr::generateTransferTokenForRepartition Generated TransferToken %v %v", ttid, tt)
+		transferTokens[ttid] = tt
+	}
+
+	return transferTokens, oldInsts, newInsts, nil
+}
+
+// nextReplicaId returns the lowest replica id not in used, and marks
+// it as used.
+func nextReplicaId(used map[int]bool) int {
+	replicaId := 0
+	for used[replicaId] {
+		replicaId++
+	}
+	used[replicaId] = true
+	return replicaId
+}
+
+// repartitionTokens returns copy-mode transfer tokens building the new
+// instances with numPartitions partitions. Partitions of an instance
+// are spread round robin over the nodes hosting the instance.
+func repartitionTokens(newInsts []*repartitionInst, numPartitions int,
+	masterId string, rebalId string) []*c.TransferToken {
+
+	var result []*c.TransferToken
+	for _, inst := range newInsts {
+		tokens := make(map[string]*c.TransferToken)
+		for partnId := 1; partnId <= numPartitions; partnId++ {
+			destId := inst.nodes[(partnId-1)%len(inst.nodes)]
+
+			tt, ok := tokens[destId]
+			if !ok {
+				tt = &c.TransferToken{
+					MasterId:     masterId,
+					DestId:       destId,
+					RebalId:      rebalId,
+					State:        c.TransferTokenCreated,
+					InstId:       inst.instId,
+					TransferMode: c.TokenTransferModeCopy,
+				}
+				tt.IndexInst = c.IndexInst{
+					InstId:    inst.instId,
+					Defn:      inst.defn,
+					State:     c.INDEX_STATE_ACTIVE,
+					ReplicaId: inst.replicaId,
+				}
+				tt.IndexInst.Defn.InstVersion = 1
+				tt.IndexInst.Defn.ReplicaId = inst.replicaId
+				tt.IndexInst.Defn.NumPartitions = uint32(numPartitions)
+				tt.IndexInst.Defn.Partitions = nil
+				tt.IndexInst.Defn.Versions = nil
+				tokens[destId] = tt
+				result = append(result, tt)
+			}
+			tt.IndexInst.Defn.Partitions = append(tt.IndexInst.Defn.Partitions, c.PartitionId(partnId))
+			tt.IndexInst.Defn.Versions = append(tt.IndexInst.Defn.Versions, 1)
+		}
+	}
+	return result
+}
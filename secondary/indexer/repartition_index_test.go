@@ -0,0 +1,113 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestNextReplicaId(t *testing.T) {
+	used := map[int]bool{0: true, 2: true}
+	var ids []int
+	for i := 0; i < 3; i++ {
+		ids = append(ids, nextReplicaId(used))
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3, 4}) {
+		t.Errorf("unexpected replica ids %v", ids)
+	}
+}
+
+func TestRepartitionTokens(t *testing.T) {
+	defn := c.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx", NumPartitions: 8}
+	newInsts := []*repartitionInst{
+		{defn: defn, instId: 200, replicaId: 2, nodes: []string{"n1", "n2"}},
+		{defn: defn, instId: 300, replicaId: 3, nodes: []string{"n3"}},
+	}
+
+	tokens := repartitionTokens(newInsts, 3, "master", "rebal")
+	if len(tokens) != 3 {
+		t.Fatalf("expected 3 tokens, got %v", len(tokens))
+	}
+
+	expected := []struct {
+		instId    c.IndexInstId
+		replicaId int
+		destId    string
+		partns    []c.PartitionId
+	}{
+		{200, 2, "n1", []c.PartitionId{1, 3}},
+		{200, 2, "n2", []c.PartitionId{2}},
+		{300, 3, "n3", []c.PartitionId{1, 2, 3}},
+	}
+	for i, tt := range tokens {
+		e := expected[i]
+		if tt.InstId != e.instId || tt.IndexInst.InstId != e.instId || tt.DestId != e.destId {
+			t.Errorf("token %v: unexpected inst %v dest %v", i, tt.InstId, tt.DestId)
+		}
+		if tt.IndexInst.ReplicaId != e.replicaId || tt.IndexInst.Defn.ReplicaId != e.replicaId {
+			t.Errorf("token %v: expected replica %v, got %v", i, e.replicaId, tt.IndexInst.ReplicaId)
+		}
+		if !reflect.DeepEqual(tt.IndexInst.Defn.Partitions, e.partns) ||
+			len(tt.IndexInst.Defn.Versions) != len(e.partns) {
+			t.Errorf("token %v: expected partitions %v, got %v", i, e.partns, tt.IndexInst.Defn.Partitions)
+		}
+		if tt.IndexInst.Defn.NumPartitions != 3 || tt.TransferMode != c.TokenTransferModeCopy ||
+			tt.MasterId != "master" || tt.RebalId != "rebal" {
+			t.Errorf("token %v: unexpected token %v", i, tt)
+		}
+	}
+}
+
+func TestCheckRepartitionMemory(t *testing.T) {
+	insts := []*repartitionInst{
+		{instId: 100, replicaId: 0, nodes: []string{"n1", "n2"}},
+		{instId: 101, replicaId: 1, nodes: []string{"n2"}},
+	}
+	nodeStats := map[string]c.Statistics{
+		"n1": {
+			"memory_quota":            float64(1000),
+			"memory_used":             float64(500),
+			"default:idx:memory_used": float64(300),
+		},
+		"n2": {
+			"memory_quota":                        float64(1000),
+			"memory_used":                         float64(500),
+			"default:idx:memory_used":             float64(200),
+			"default:idx (replica 1):memory_used": float64(200),
+		},
+	}
+	getStats := func(nodeId string) (c.Statistics, error) {
+		if stats, ok := nodeStats[nodeId]; ok {
+			return stats, nil
+		}
+		return nil, errors.New("unknown node")
+	}
+
+	if err := checkRepartitionMemory(insts, "default", "idx", getStats); err != nil {
+		t.Fatal(err)
+	}
+
+	// n2 needs 400 for both replicas.
+	nodeStats["n2"]["memory_used"] = float64(700)
+	err := checkRepartitionMemory(insts, "default", "idx", getStats)
+	if err == nil || !strings.Contains(err.Error(), "node n2") {
+		t.Errorf("expected insufficient memory on n2, got %v", err)
+	}
+
+	insts[1].nodes = []string{"n4"}
+	if err := checkRepartitionMemory(insts, "default", "idx", getStats); err == nil {
+		t.Errorf("expected error for unknown node")
+	}
+}
@@ -13,12 +13,14 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"github.com/couchbase/cbauth"
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 	p "github.com/couchbase/indexing/secondary/pipeline"
@@ -35,6 +37,10 @@ var (
 	ErrUnsupportedRequest = errors.New("Unsupported query request")
 	ErrVbuuidMismatch     = errors.New("Mismatch in session vbuuids")
 	ErrNotMyPartition     = errors.New("Not my partition")
+	ErrUnauthorizedScan   = errors.New("Not authorized to scan")
+
+	ErrKeyStatsNotAvailable = errors.New("Key statistics not available for index")
+	ErrVbucketsQuarantined  = errors.New("Index is partially available, vbuckets are quarantined")
 )
 
 var secKeyBufPool *common.BytesBufPool
@@ -68,6 +74,16 @@ type scanCoordinator struct {
 	stats IndexerStatsHolder
 
 	indexerState atomic.Value
+
+	slowScans *slowScanLog
+	scheduler scanScheduler
+
+	verifier     *indexVerifier
+	verifyStopCh chan bool
+
+	staleness *stalenessTracker
+
+	missingVbs *missingVbs
 }
 
 // NewScanCoordinator returns an instance of scanCoordinator or err message
@@ -76,7 +92,8 @@ type scanCoordinator struct {
 // Any async message to supervisor is sent to supvMsgch.
 // If supvCmdch get closed, ScanCoordinator will shut itself down.
 func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
-	config common.Config, snapshotNotifych chan IndexSnapshot) (ScanCoordinator, Message) {
+	config common.Config, snapshotNotifych chan IndexSnapshot,
+	missingVbs *missingVbs) (ScanCoordinator, Message) {
 	var err error
 
 	s := &scanCoordinator{
@@ -86,6 +103,11 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		snapshotNotifych: snapshotNotifych,
 		logPrefix:        "ScanCoordinator",
 		reqCounter:       0,
+		slowScans:        newSlowScanLog(config["settings.scan_slowlog.size"].Int()),
+		verifier:         newIndexVerifier(),
+		verifyStopCh:     make(chan bool),
+		staleness:        newStalenessTracker(),
+		missingVbs:       missingVbs,
 	}
 
 	s.config.Store(config)
@@ -93,7 +115,12 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 
 	addr := net.JoinHostPort("", config["scanPort"].String())
 	queryportCfg := config.SectionConfig("queryport.", true)
-	s.serv, err = queryport.NewServer(addr, s.serverCallback, createConnectionContext, queryportCfg)
+	if queryportCfg["auth.enabled"].Bool() {
+		s.serv, err = queryport.NewServerWithAuth(addr, s.serverCallback,
+			createConnectionContext, authQueryportConn, queryportCfg)
+	} else {
+		s.serv, err = queryport.NewServer(addr, s.serverCallback, createConnectionContext, queryportCfg)
+	}
 
 	if err != nil {
 		errMsg := &MsgError{err: Error{code: ERROR_SCAN_COORD_QUERYPORT_FAIL,
@@ -106,10 +133,14 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 	}
 
 	s.setIndexerState(common.INDEXER_BOOTSTRAP)
+	http.HandleFunc("/scanSlowLog", s.handleSlowScanLogReq)
+	http.HandleFunc("/verifyIndex", s.handleVerifyIndexReq)
+	http.HandleFunc("/shipSnapshot", s.handleShipSnapshotReq)
 
 	// main loop
 	go s.run()
 	go s.listenSnapshot()
+	go s.verifyDaemon()
 
 	return s, &MsgSuccess{}
 
@@ -124,6 +155,7 @@ loop:
 				if cmd.GetMsgType() == SCAN_COORD_SHUTDOWN {
 					logging.Infof("ScanCoordinator: Shutting Down")
 					s.serv.Close()
+					close(s.verifyStopCh)
 					s.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -216,6 +248,8 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	req, err := NewScanRequest(protoReq, ctx, cancelCh, s)
 	atime := time.Now()
 	w := NewProtoWriter(req.ScanType, conn)
+	w.docIdOnly = req.DocIdOnly
+	w.encoding = req.Encoding
 	defer func() {
 		s.handleError(req.LogPrefix, w.Done())
 		req.Done()
@@ -246,6 +280,19 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		return
 	}
 
+	if req.ScanType == ScanReq || req.ScanType == ScanAllReq {
+		rows, ok := estimateScanRows(req)
+		if ok {
+			w.estimatedRows = proto.Uint64(rows)
+		}
+		if req.EstimateOnly {
+			if !ok {
+				s.handleError(req.LogPrefix, w.Error(ErrKeyStatsNotAvailable))
+			}
+			return
+		}
+	}
+
 	if req.Stats != nil {
 		req.Stats.scanReqAllocDuration.Add(time.Now().Sub(atime).Nanoseconds())
 	}
@@ -255,6 +302,13 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		return
 	}
 
+	if req.ScanType != IntersectReq {
+		if err := s.checkPartialScan(req, w); err != nil {
+			s.tryRespondWithError(w, req, err)
+			return
+		}
+	}
+
 	if req.Stats != nil {
 		req.Stats.scanReqInitDuration.Add(time.Now().Sub(ttime).Nanoseconds())
 
@@ -274,10 +328,32 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	}
 
 	t0 := time.Now()
+	cfg := s.config.Load()
+	maxConcurrent := maxConcurrentScans(cfg["settings.scan_scheduler.max_concurrent"].Int())
+	maxBatch := cfg["settings.scan_scheduler.max_batch_concurrent"].Int()
+	err = gMemPressure.admitScan(req.Priority)
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+	err = s.scheduler.acquire(req.Priority, req.CancelCh, req.getTimeoutCh(),
+		maxConcurrent, maxBatch)
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+	defer s.scheduler.release(req.Priority, maxConcurrent, maxBatch)
+
+	// An intersect request scans the snapshots of its sub-requests.
+	if req.ScanType == IntersectReq {
+		s.handleIntersectRequest(req, w, t0)
+		return
+	}
+
+	t1 := time.Now()
 	is, err := s.getRequestedIndexSnapshot(req)
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
+	t2 := time.Now()
 
 	defer DestroyIndexSnapshot(is)
 
@@ -286,6 +362,10 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 			req.LogPrefix, ScanTStoString(is.Timestamp()))
 	})
 
+	if req.ReturnSnapshotTs {
+		w.setSnapshotTs(is.Timestamp())
+	}
+
 	defer func() {
 		if req.Stats != nil {
 			req.Stats.scanReqDuration.Add(time.Now().Sub(ttime).Nanoseconds())
@@ -298,7 +378,16 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		}
 	}
 
-	s.processRequest(req, w, is, t0)
+	if req.Explain {
+		timings := ScanExplainTimings{
+			ParseTime:    atime.Sub(ttime).Nanoseconds(),
+			ScheduleTime: t1.Sub(t0).Nanoseconds(),
+			SnapshotTime: t2.Sub(t1).Nanoseconds(),
+		}
+		s.handleExplainRequest(req, w, is, timings)
+	} else {
+		s.processRequest(req, w, is, t0)
+	}
 
 	if len(req.Ctxs) != 0 {
 		for _, ctx := range req.Ctxs {
@@ -307,6 +396,23 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	}
 }
 
+// authQueryportConn validates credentials of a queryport connection.
+// Scan clients are expected to carry cluster-wide query privileges.
+func authQueryportConn(user string, password []byte) error {
+	creds, err := cbauth.Auth(user, string(password))
+	if err != nil {
+		return err
+	}
+
+	allowed, err := creds.IsAllowed("cluster.n1ql.meta!read")
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrUnauthorizedScan
+	}
+	return nil
+}
+
 func (s *scanCoordinator) processRequest(req *ScanRequest, w ScanResponseWriter,
 	is IndexSnapshot, t0 time.Time) {
 
@@ -359,6 +465,9 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 		}
 	}
 
+	s.recordSlowScan(req, scanPipeline.RowsScanned(), scanPipeline.RowsReturned(),
+		waitTime, scanTime, err)
+
 	if err != nil {
 		status := fmt.Sprintf("(error = %s)", err)
 		logging.LazyVerbose(func() string {
@@ -497,6 +606,9 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 		if ok && ss != nil && isSnapshotConsistent(ss, cons, r.Ts) {
 			return CloneIndexSnapshot(ss), nil
 		}
+		if cons == common.StalenessConsistency && !r.StalenessWait {
+			return nil, ErrIndexTooStale
+		}
 		return nil, nil
 	}()
 
@@ -515,6 +627,16 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 		expiredTime: r.ExpiredTime,
 	}
 
+	// The wait for a consistent snapshot can be bounded shorter than the
+	// scan, so that consistent scans on a lagging index fail fast.
+	var consTimeoutCh <-chan time.Time
+	if wait := s.config.Load()["settings.scan_consistency_wait"].Int(); wait > 0 &&
+		(*r.Consistency == common.QueryConsistency || *r.Consistency == common.SessionConsistency) {
+		consTimer := time.NewTimer(time.Duration(wait) * time.Millisecond)
+		defer consTimer.Stop()
+		consTimeoutCh = consTimer.C
+	}
+
 	// Block wait until a ts is available for fullfilling the request
 	s.supvMsgch <- snapReqMsg
 	var msg interface{}
@@ -523,6 +645,11 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 	case <-r.getTimeoutCh():
 		go readDeallocSnapshot(snapResch)
 		msg = common.ErrScanTimedOut
+	case <-consTimeoutCh:
+		go readDeallocSnapshot(snapResch)
+		logging.Warnf("%v Timed out waiting for %v snapshot of index %v. Requested timestamp %v",
+			r.LogPrefix, strings.ToLower(r.Consistency.String()), r.IndexInstId, ScanTStoString(r.Ts))
+		msg = common.ErrScanTimedOut
 	}
 
 	switch msg.(type) {
@@ -572,6 +699,8 @@ func isSnapshotConsistent(
 			return false
 		} else if cons == common.AnyConsistency {
 			return true
+		} else if cons == common.StalenessConsistency {
+			return reqTs == nil || snapTs.AsRecentTs(reqTs)
 		}
 	}
 	return false
@@ -632,7 +761,7 @@ func (s *scanCoordinator) respondWithError(conn net.Conn, req *ScanRequest, err
 		res = &protobuf.CountResponse{
 			Count: proto.Int64(0), Err: protoErr,
 		}
-	case ScanAllReq, ScanReq:
+	case ScanAllReq, ScanReq, IntersectReq:
 		res = &protobuf.ResponseStream{
 			Err: protoErr,
 		}
@@ -738,6 +867,8 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	indexInstMap := req.GetIndexInstMap()
 	s.stats.Set(req.GetStatsObject())
 	s.indexInstMap = common.CopyIndexInstMap(indexInstMap)
+	s.verifier.forget(s.indexInstMap)
+	s.staleness.forget(s.indexInstMap)
 
 	if len(req.GetRollbackTimes()) != 0 {
 		logging.Infof("ScanCoordinator::initialize rollback times on new index inst map: %v", req.GetRollbackTimes())
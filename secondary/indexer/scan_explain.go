@@ -0,0 +1,167 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  scan explain
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// A scan request with the explain flag runs the scan as usual, with the
+// requested consistency, but rows are counted and dropped instead of
+// being returned. A single ResponseStream carries the json encoded
+// ScanExplain, describing how the indexer evaluated the scan.
+//
+
+// ScanExplainTimings are the durations, in nanoseconds, of the stages
+// of a scan.
+type ScanExplainTimings struct {
+	ParseTime    int64 `json:"parseTime"`    // decoding the request
+	ScheduleTime int64 `json:"scheduleTime"` // waiting in the scan scheduler
+	SnapshotTime int64 `json:"snapshotTime"` // waiting for a consistent snapshot
+	ScanTime     int64 `json:"scanTime"`     // scanning the snapshot
+}
+
+// ScanExplainRange is the range of an index key evaluated by a filter.
+type ScanExplainRange struct {
+	Low       string `json:"low"`
+	High      string `json:"high"`
+	Inclusion int    `json:"inclusion"`
+}
+
+// ScanExplain is the response to an explain request.
+type ScanExplain struct {
+	RequestId     string               `json:"requestId"`
+	Bucket        string               `json:"bucket"`
+	Index         string               `json:"index"`
+	DefnId        uint64               `json:"defnId"`
+	InstId        common.IndexInstId   `json:"instId"`
+	ScanType      string               `json:"scanType"`
+	Consistency   string               `json:"consistency"`
+	SnapshotTs    string               `json:"snapshotTs"`
+	Partitions    []common.PartitionId `json:"partitions"`
+	Spans         [][]ScanExplainRange `json:"spans"` // key ranges of each filter
+	EstimatedRows *uint64              `json:"estimatedRows,omitempty"`
+	RowsScanned   uint64               `json:"rowsScanned"`
+	RowsReturned  uint64               `json:"rowsReturned"`
+	RowsFiltered  uint64               `json:"rowsFiltered"`
+	Timings       ScanExplainTimings   `json:"timings"`
+	Error         string               `json:"error,omitempty"`
+}
+
+// explainWriter drops the rows of an explain request.
+type explainWriter struct {
+	err error
+}
+
+func (w *explainWriter) Error(err error) error {
+	w.err = err
+	return nil
+}
+
+func (w *explainWriter) Stats(rows, unique uint64, min, max []byte) error { return nil }
+func (w *explainWriter) Count(count uint64) error                         { return nil }
+func (w *explainWriter) RawBytes([]byte) error                            { return nil }
+func (w *explainWriter) Done() error                                      { return nil }
+func (w *explainWriter) Helo() error                                      { return nil }
+func (w *explainWriter) Row(pk, sk []byte) error                          { return nil }
+
+func (s *scanCoordinator) handleExplainRequest(req *ScanRequest, w *protoResponseWriter,
+	is IndexSnapshot, timings ScanExplainTimings) {
+
+	explain := &ScanExplain{
+		RequestId:     req.RequestId,
+		Bucket:        req.Bucket,
+		Index:         req.IndexName,
+		DefnId:        req.DefnID,
+		InstId:        req.IndexInstId,
+		ScanType:      string(req.ScanType),
+		SnapshotTs:    ScanTStoString(is.Timestamp()),
+		Partitions:    req.PartitionIds,
+		Spans:         explainSpans(req.Scans),
+		EstimatedRows: w.estimatedRows,
+		Timings:       timings,
+	}
+	if req.Consistency != nil {
+		explain.Consistency = req.Consistency.String()
+	}
+
+	ew := &explainWriter{}
+	t0 := time.Now()
+
+	scanPipeline := NewScanPipeline(req, ew, is, s.config.Load())
+	cancelCb := NewCancelCallback(req, func(e error) {
+		scanPipeline.Cancel(e)
+	})
+	cancelCb.Run()
+	err := scanPipeline.Execute()
+	cancelCb.Done()
+
+	explain.Timings.ScanTime = time.Since(t0).Nanoseconds()
+	explain.RowsScanned = scanPipeline.RowsScanned()
+	explain.RowsReturned = scanPipeline.RowsReturned()
+	if req.GroupAggr == nil && explain.RowsScanned > explain.RowsReturned {
+		explain.RowsFiltered = explain.RowsScanned - explain.RowsReturned
+	}
+
+	if err == nil {
+		err = ew.err
+	}
+	if err != nil {
+		explain.Error = err.Error()
+	}
+
+	buf, err := json.Marshal(explain)
+	if err != nil {
+		s.handleError(req.LogPrefix, w.Error(err))
+		return
+	}
+	s.handleError(req.LogPrefix, w.Explain(buf))
+}
+
+func explainSpans(scans []Scan) [][]ScanExplainRange {
+	spans := make([][]ScanExplainRange, 0, len(scans))
+	for _, scan := range scans {
+		if len(scan.Filters) == 0 {
+			spans = append(spans, []ScanExplainRange{
+				{Low: explainKey(scan.Low), High: explainKey(scan.High), Inclusion: int(scan.Incl)},
+			})
+			continue
+		}
+		for _, filter := range scan.Filters {
+			ranges := make([]ScanExplainRange, 0, len(filter.CompositeFilters))
+			for _, cf := range filter.CompositeFilters {
+				ranges = append(ranges, ScanExplainRange{
+					Low:       explainKey(cf.Low),
+					High:      explainKey(cf.High),
+					Inclusion: int(cf.Inclusion),
+				})
+			}
+			spans = append(spans, ranges)
+		}
+	}
+	return spans
+}
+
+func explainKey(key IndexKey) string {
+	if key == nil {
+		return "nil"
+	}
+	return key.String()
+}
@@ -0,0 +1,127 @@
+package indexer
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/couchbase/indexing/secondary/transport"
+)
+
+func TestExplainSpans(t *testing.T) {
+	low, err := NewSecondaryKey([]byte(`["a"]`), make([]byte, 0, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := NewSecondaryKey([]byte(`["z"]`), make([]byte, 0, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scans := []Scan{
+		{Low: low, High: nil, Incl: Low},
+		{Filters: []Filter{
+			{CompositeFilters: []CompositeElementFilter{
+				{Low: low, High: high, Inclusion: Both},
+				{Low: MinIndexKey, High: MaxIndexKey, Inclusion: Neither},
+			}},
+			{CompositeFilters: []CompositeElementFilter{
+				{Low: high, High: high, Inclusion: Both},
+			}},
+		}},
+	}
+
+	spans := explainSpans(scans)
+	if len(spans) != 3 {
+		t.Fatalf("Expected a span for each filter, got %v", spans)
+	}
+	if spans[0][0] != (ScanExplainRange{Low: low.String(), High: "nil", Inclusion: int(Low)}) {
+		t.Errorf("Unexpected span of scan without filters %v", spans[0])
+	}
+	if len(spans[1]) != 2 ||
+		spans[1][0] != (ScanExplainRange{Low: low.String(), High: high.String(), Inclusion: int(Both)}) ||
+		spans[1][1] != (ScanExplainRange{Low: MinIndexKey.String(), High: MaxIndexKey.String(), Inclusion: int(Neither)}) {
+		t.Errorf("Unexpected span of composite filter %v", spans[1])
+	}
+	if len(spans[2]) != 1 || spans[2][0].Low != high.String() {
+		t.Errorf("Unexpected span of second filter %v", spans[2])
+	}
+
+	if spans := explainSpans(nil); len(spans) != 0 {
+		t.Errorf("Expected no spans, got %v", spans)
+	}
+}
+
+func TestHandleExplainRequest(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	s := &scanCoordinator{}
+	s.config.Store(common.SystemConfig.SectionConfig("indexer.", true))
+
+	cons := common.AnyConsistency
+	req := &ScanRequest{
+		ScanType:     ScanReq,
+		RequestId:    "explain-1",
+		Bucket:       "default",
+		IndexName:    "idx",
+		DefnID:       10,
+		IndexInstId:  11,
+		Consistency:  &cons,
+		PartitionIds: []common.PartitionId{0},
+		Scans:        []Scan{{Low: MinIndexKey, High: MaxIndexKey, Incl: Both}},
+		LogPrefix:    "TestHandleExplainRequest",
+	}
+	ts := common.NewTsVbuuid("default", 2)
+	ts.Seqnos[1] = 5
+	is := &indexSnapshot{instId: 11, ts: ts, epoch: true}
+
+	w := NewProtoWriter(ScanReq, server)
+	rows := uint64(3)
+	w.estimatedRows = &rows
+	go s.handleExplainRequest(req, w, is, ScanExplainTimings{ParseTime: 1, ScheduleTime: 2, SnapshotTime: 3})
+
+	_, payload, err := transport.Receive(client, make([]byte, 1024*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := protobuf.ProtobufDecode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, ok := msg.(*protobuf.ResponseStream)
+	if !ok {
+		t.Fatalf("Expected response stream, got %v", msg)
+	}
+	if len(resp.GetIndexEntries()) != 0 || resp.GetEstimatedRows() != 3 {
+		t.Errorf("Expected only the explain and estimate, got %v", resp)
+	}
+
+	var explain ScanExplain
+	if err := json.Unmarshal(resp.GetExplain(), &explain); err != nil {
+		t.Fatal(err)
+	}
+	if explain.RequestId != "explain-1" || explain.Bucket != "default" || explain.Index != "idx" ||
+		explain.DefnId != 10 || explain.InstId != 11 || explain.ScanType != string(ScanReq) {
+		t.Errorf("Unexpected request of explain %+v", explain)
+	}
+	if explain.Consistency != cons.String() || explain.SnapshotTs != ScanTStoString(ts) {
+		t.Errorf("Unexpected consistency of explain %+v", explain)
+	}
+	if len(explain.Partitions) != 1 || len(explain.Spans) != 1 || explain.Error != "" {
+		t.Errorf("Unexpected scan of explain %+v", explain)
+	}
+	if explain.EstimatedRows == nil || *explain.EstimatedRows != 3 {
+		t.Errorf("Expected estimated rows in explain %+v", explain)
+	}
+	if explain.RowsScanned != 0 || explain.RowsReturned != 0 || explain.RowsFiltered != 0 {
+		t.Errorf("Expected no rows scanned in an empty snapshot %+v", explain)
+	}
+	if explain.Timings.ParseTime != 1 || explain.Timings.ScheduleTime != 2 ||
+		explain.Timings.SnapshotTime != 3 || explain.Timings.ScanTime < 0 {
+		t.Errorf("Unexpected timings of explain %+v", explain.Timings)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+var (
+	ErrIntersectTooFewScans    = errors.New("Intersect requires at least two scans")
+	ErrIntersectBucketMismatch = errors.New("Intersect scans must be on indexes of the same bucket")
+	ErrIntersectGroupAggr      = errors.New("Intersect does not support group/aggregate scans")
+	ErrIntersectTooManyDocIds  = errors.New("Intersect scan exceeded the maximum number of docids")
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  intersect request
+//
+/////////////////////////////////////////////////////////////////////////
+
+// fillIntersect creates a ScanRequest for every scan that takes part in
+// the intersection. The sub-requests are scanned in full, so limit,
+// offset, distinct and projection given by the client are overridden.
+func (r *ScanRequest) fillIntersect(scans []*protobuf.ScanRequest,
+	ctx interface{}, cancelCh <-chan bool, s *scanCoordinator) error {
+
+	if len(scans) < 2 {
+		return ErrIntersectTooFewScans
+	}
+
+	for _, scan := range scans {
+		sub, err := NewScanRequest(scan, ctx, cancelCh, s)
+		if sub != nil {
+			r.Intersect = append(r.Intersect, sub)
+		}
+		if err != nil {
+			return err
+		}
+
+		if sub.GroupAggr != nil {
+			return ErrIntersectGroupAggr
+		}
+
+		if r.Bucket == "" {
+			r.Bucket = sub.Bucket
+		} else if r.Bucket != sub.Bucket {
+			return ErrIntersectBucketMismatch
+		}
+
+		sub.Limit = math.MaxInt64
+		sub.Offset = 0
+		sub.Distinct = false
+		sub.Indexprojection = nil
+		sub.projectPrimaryKey = true
+		sub.AllowPartial = false
+		sub.LogPrefix = fmt.Sprintf("%s/%s", r.LogPrefix, sub.LogPrefix)
+	}
+
+	r.Consistency = r.Intersect[0].Consistency
+	return nil
+}
+
+// handleIntersectRequest is called by serverCallback once the request
+// is admitted by the scan scheduler. Every sub-request is scanned on its
+// own snapshot and at most maxDocIds docids are collected from each.
+func (s *scanCoordinator) handleIntersectRequest(req *ScanRequest, w ScanResponseWriter,
+	t0 time.Time) {
+
+	maxDocIds := s.config.Load()["settings.scan_intersect.max_docids"].Int()
+
+	sets := make([][][]byte, 0, len(req.Intersect))
+	for _, sub := range req.Intersect {
+		docids, err := s.collectDocIds(sub, maxDocIds)
+		if s.tryRespondWithError(w, req, err) {
+			return
+		}
+
+		sets = append(sets, docids)
+
+		// Nothing can survive an empty set. Skip the remaining scans.
+		if len(docids) == 0 {
+			break
+		}
+	}
+
+	var rows int64
+	for _, docid := range intersectDocIds(sets) {
+		if err := w.Row(docid, nil); err != nil {
+			s.handleError(req.LogPrefix, err)
+			return
+		}
+
+		rows++
+		if req.Limit > 0 && rows == req.Limit {
+			break
+		}
+	}
+
+	logging.LazyVerbose(func() string {
+		return fmt.Sprintf("%s RESPONSE intersect rows:%d, scans:%d, totalTime:%v, status:ok",
+			req.LogPrefix, rows, len(req.Intersect), time.Now().Sub(t0))
+	})
+}
+
+// collectDocIds scans the snapshot requested by sub and returns the
+// docids of all qualifying entries. The scan fails with
+// ErrIntersectTooManyDocIds once more than maxDocIds entries qualify,
+// unless maxDocIds is 0.
+func (s *scanCoordinator) collectDocIds(sub *ScanRequest, maxDocIds int) ([][]byte, error) {
+	if err := s.isScanAllowed(*sub.Consistency, sub); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPartialScan(sub, nil); err != nil {
+		return nil, err
+	}
+
+	if sub.Stats != nil {
+		sub.Stats.numRequests.Add(1)
+		sub.Stats.numRequestsRange.Add(1)
+	}
+
+	is, err := s.getRequestedIndexSnapshot(sub)
+	if err != nil {
+		return nil, err
+	}
+	defer DestroyIndexSnapshot(is)
+
+	for _, ctx := range sub.Ctxs {
+		ctx.Init()
+	}
+	defer func() {
+		for _, ctx := range sub.Ctxs {
+			ctx.Done()
+		}
+	}()
+
+	cw := &docIdCollector{max: maxDocIds}
+	scanPipeline := NewScanPipeline(sub, cw, is, s.config.Load())
+	cancelCb := NewCancelCallback(sub, func(e error) {
+		scanPipeline.Cancel(e)
+	})
+	cancelCb.Run()
+	defer cancelCb.Done()
+
+	err = scanPipeline.Execute()
+	if err == nil {
+		err = cw.err
+	}
+
+	if sub.Stats != nil {
+		sub.Stats.numRowsReturned.Add(int64(scanPipeline.RowsReturned()))
+		sub.Stats.numRowsScannedRange.Add(int64(scanPipeline.RowsScanned()))
+		sub.Stats.scanBytesRead.Add(int64(scanPipeline.BytesRead()))
+	}
+
+	return cw.docids, err
+}
+
+// intersectDocIds returns the docids common to all sets using a
+// sort-merge, starting with the smallest set. Every set is sorted
+// in place.
+func intersectDocIds(sets [][][]byte) [][]byte {
+	if len(sets) == 0 {
+		return nil
+	}
+
+	for _, set := range sets {
+		sort.Sort(common.ByteSlices(set))
+	}
+
+	smallest := 0
+	for i, set := range sets {
+		if len(set) < len(sets[smallest]) {
+			smallest = i
+		}
+	}
+
+	result := dedupDocIds(sets[smallest])
+	for i, set := range sets {
+		if i == smallest {
+			continue
+		}
+
+		merged := result[:0]
+		for x, y := 0, 0; x < len(result) && y < len(set); {
+			switch cmp := bytes.Compare(result[x], set[y]); {
+			case cmp < 0:
+				x++
+			case cmp > 0:
+				y++
+			default:
+				merged = append(merged, result[x])
+				x++
+				y++
+			}
+		}
+		result = merged
+	}
+
+	return result
+}
+
+// dedupDocIds removes consecutive duplicates from a sorted set. An
+// array index can return the same docid for more than one entry.
+func dedupDocIds(set [][]byte) [][]byte {
+	result := make([][]byte, 0, len(set))
+	for i, docid := range set {
+		if i > 0 && bytes.Equal(docid, set[i-1]) {
+			continue
+		}
+		result = append(result, docid)
+	}
+	return result
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  docid collector
+//
+/////////////////////////////////////////////////////////////////////////
+
+// docIdCollector is a ScanResponseWriter that accumulates the docids
+// of a scan in memory instead of writing them to the client.
+type docIdCollector struct {
+	docids [][]byte
+	max    int
+	err    error
+}
+
+func (c *docIdCollector) Error(err error) error {
+	c.err = err
+	return nil
+}
+
+func (c *docIdCollector) Stats(rows, unique uint64, min, max []byte) error {
+	return ErrUnsupportedRequest
+}
+
+func (c *docIdCollector) Count(count uint64) error {
+	return ErrUnsupportedRequest
+}
+
+func (c *docIdCollector) RawBytes([]byte) error {
+	return ErrUnsupportedRequest
+}
+
+func (c *docIdCollector) Row(pk, sk []byte) error {
+	if c.max > 0 && len(c.docids) >= c.max {
+		return ErrIntersectTooManyDocIds
+	}
+	c.docids = append(c.docids, append([]byte(nil), pk...))
+	return nil
+}
+
+func (c *docIdCollector) Done() error {
+	return nil
+}
+
+func (c *docIdCollector) Helo() error {
+	return ErrUnsupportedRequest
+}
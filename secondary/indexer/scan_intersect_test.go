@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func docIdSet(ids ...string) [][]byte {
+	set := make([][]byte, len(ids))
+	for i, id := range ids {
+		set[i] = []byte(id)
+	}
+	return set
+}
+
+func TestIntersectDocIds(t *testing.T) {
+	sets := [][][]byte{
+		docIdSet("d5", "d1", "d3", "d3", "d9"),
+		docIdSet("d3", "d2", "d9", "d1"),
+		docIdSet("d9", "d3", "d7"),
+	}
+
+	result := intersectDocIds(sets)
+	if expected := docIdSet("d3", "d9"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %s, received %s", expected, result)
+	}
+}
+
+func TestIntersectDocIdsEmpty(t *testing.T) {
+	sets := [][][]byte{
+		docIdSet("d1", "d2"),
+		docIdSet(),
+	}
+
+	if result := intersectDocIds(sets); len(result) != 0 {
+		t.Errorf("Expected empty result, received %s", result)
+	}
+
+	if result := intersectDocIds(nil); result != nil {
+		t.Errorf("Expected nil result, received %s", result)
+	}
+}
+
+func TestDocIdCollectorLimit(t *testing.T) {
+	cw := &docIdCollector{max: 2}
+	for _, id := range []string{"d1", "d2"} {
+		if err := cw.Row([]byte(id), nil); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if err := cw.Row([]byte("d3"), nil); err != ErrIntersectTooManyDocIds {
+		t.Errorf("Expected %v, received %v", ErrIntersectTooManyDocIds, err)
+	}
+	if len(cw.docids) != 2 {
+		t.Errorf("Expected 2 docids, received %d", len(cw.docids))
+	}
+}
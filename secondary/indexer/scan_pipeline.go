@@ -441,8 +441,16 @@ loop:
 		t := (*tmpBuf)[:0]
 		if d.p.req.GroupAggr != nil {
 			sk, _ = jsonEncoder.Decode(row, t)
+		} else if d.p.req.DocMeta {
+			sk, docid = docMetaSplitEntry(row, t)
 		} else if d.p.req.isPrimary {
 			sk, docid = piSplitEntry(row, t)
+			if d.p.req.DocIdOnly {
+				sk = nil
+			}
+		} else if d.p.req.DocIdOnly {
+			// Secondary key is not decoded for docid only scans
+			sk, docid = nil, siDocIdEntry(row, t)
 		} else {
 			sk, docid, _ = siSplitEntry(row, t)
 		}
@@ -530,6 +538,12 @@ func siSplitEntry(entry []byte, tmp []byte) ([]byte, []byte, int) {
 	return sk, docid[len(sk):], count
 }
 
+func siDocIdEntry(entry []byte, tmp []byte) []byte {
+	docid, err := secondaryIndexEntry(entry).ReadDocId(tmp)
+	c.CrashOnError(err)
+	return docid
+}
+
 // Return true if the row needs to be skipped based on the filter
 func filterScanRow(key []byte, scan Scan, buf []byte) (bool, [][]byte, error) {
 	var compositekeys [][]byte
@@ -609,6 +623,20 @@ func applyFilter(compositekeys [][]byte, compositefilters []CompositeElementFilt
 
 	for i, filter := range compositefilters {
 		ck := compositekeys[i]
+
+		if filter.Nulls != NullMissingByRange && ck[0] == collatejson.TypeNull {
+			if filter.Nulls == NullMissingExclude {
+				return false
+			}
+			continue
+		}
+		if filter.Missing != NullMissingByRange && ck[0] == collatejson.TypeMissing {
+			if filter.Missing == NullMissingExclude {
+				return false
+			}
+			continue
+		}
+
 		checkLow := (filter.Low != MinIndexKey)
 		checkHigh := (filter.High != MaxIndexKey)
 
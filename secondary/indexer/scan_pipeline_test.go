@@ -0,0 +1,69 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+)
+
+func TestApplyFilterNullMissing(t *testing.T) {
+	explode := func(key string) [][]byte {
+		enc, err := jsonEncoder.Encode([]byte(key), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cks, err := jsonEncoder.ExplodeArray(enc, make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cks
+	}
+	low, err := NewSecondaryKey([]byte("1"), make([]byte, 0, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := `"` + string(collatejson.MissingLiteral) + `"`
+	testcases := []struct {
+		key     string
+		nulls   NullMissingInclusion
+		missing NullMissingInclusion
+		match   bool
+	}{
+		{`[5]`, NullMissingByRange, NullMissingByRange, true},
+		{`[null]`, NullMissingByRange, NullMissingByRange, false},
+		{`[null]`, NullMissingInclude, NullMissingByRange, true},
+		{`[null]`, NullMissingByRange, NullMissingInclude, false},
+		{`[` + missing + `]`, NullMissingInclude, NullMissingByRange, false},
+		{`[` + missing + `]`, NullMissingByRange, NullMissingInclude, true},
+		{`[5]`, NullMissingExclude, NullMissingExclude, true},
+	}
+	for _, tc := range testcases {
+		filter := CompositeElementFilter{
+			Low:       low,
+			High:      MaxIndexKey,
+			Inclusion: Both,
+			Nulls:     tc.nulls,
+			Missing:   tc.missing,
+		}
+		match := applyFilter(explode(tc.key), []CompositeElementFilter{filter})
+		if match != tc.match {
+			t.Errorf("%v nulls:%v missing:%v expected %v, received %v",
+				tc.key, tc.nulls, tc.missing, tc.match, match)
+		}
+	}
+
+	// exclusion applies within the range
+	filter := CompositeElementFilter{
+		Low:       MinIndexKey,
+		High:      MaxIndexKey,
+		Inclusion: Both,
+		Nulls:     NullMissingExclude,
+	}
+	if applyFilter(explode(`[null]`), []CompositeElementFilter{filter}) {
+		t.Errorf("Expected null to be excluded")
+	}
+	if !applyFilter(explode(`[`+missing+`]`), []CompositeElementFilter{filter}) {
+		t.Errorf("Expected missing to be included")
+	}
+}
@@ -35,6 +35,25 @@ type protoResponseWriter struct {
 	rowBuf     *[]byte
 	rowEntries []*protobuf.IndexEntry
 	rowSize    int
+	docIdOnly  bool // rows are sent as packed docids
+
+	// row encoding requested by the client, for helo requests the
+	// encodings supported by the client
+	encoding     uint32
+	packedKeys   []byte         // keys column of packed rows
+	packedDocIds []byte         // docids column of packed rows
+	docIdBlock   *[]byte        // backing packedDocIds
+	keyDict      map[string]int // position of keys in packedKeys
+	numKeys      int            // keys in packedKeys
+
+	// estimated result size, sent with the first ResponseStream
+	estimatedRows *uint64
+
+	// vbuckets skipped by a partial scan, sent with the first ResponseStream
+	missingVbuckets []uint32
+
+	// timestamp of the scanned snapshot, sent with the first ResponseStream
+	snapshotTs *protobuf.TsConsistency
 }
 
 func NewProtoWriter(t ScanReqType, conn net.Conn) *protoResponseWriter {
@@ -59,6 +78,10 @@ func (w *protoResponseWriter) Error(err error) error {
 	// Drop all collected rows
 	w.rowEntries = nil
 	w.rowSize = 0
+	w.resetPacked()
+	w.estimatedRows = nil
+	w.missingVbuckets = nil
+	w.snapshotTs = nil
 
 	switch w.scanType {
 	case StatsReq:
@@ -69,7 +92,7 @@ func (w *protoResponseWriter) Error(err error) error {
 		res = &protobuf.CountResponse{
 			Count: proto.Int64(0), Err: protoErr,
 		}
-	case ScanAllReq, ScanReq:
+	case ScanAllReq, ScanReq, IntersectReq:
 		res = &protobuf.ResponseStream{
 			Err: protoErr,
 		}
@@ -93,7 +116,8 @@ func (w *protoResponseWriter) Stats(rows, unique uint64, min, max []byte) error
 
 func (w *protoResponseWriter) Helo() error {
 	res := &protobuf.HeloResponse{
-		Version: proto.Uint32(common.INDEXER_CUR_VERSION),
+		Version:   proto.Uint32(common.INDEXER_CUR_VERSION),
+		Encodings: proto.Uint32(w.encoding & protobuf.SupportedEncodings),
 	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
@@ -119,8 +143,15 @@ func (w *protoResponseWriter) RawBytes(b []byte) error {
 
 func (w *protoResponseWriter) Row(pk, sk []byte) error {
 
+	if w.docIdOnly {
+		return w.packedRow(pk)
+	} else if w.encoding&protobuf.EncodingPackedRows != 0 {
+		return w.packedKeyRow(pk, sk)
+	}
+
 	if w.rowSize != 0 && w.rowSize+len(pk)+len(sk) > len(*w.rowBuf) {
-		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries}
+		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries, EstimatedRows: w.takeEstimate(),
+			MissingVbuckets: w.takeMissingVbuckets(), SnapshotTs: w.takeSnapshotTs()}
 		err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 		if err != nil {
 			return err
@@ -153,12 +184,124 @@ func (w *protoResponseWriter) Row(pk, sk []byte) error {
 	return nil
 }
 
+// packedRow accumulates docid in rowBuf using the packed docid encoding,
+// sending a ResponseStream when rowBuf is full.
+func (w *protoResponseWriter) packedRow(pk []byte) error {
+	l := protobuf.PackedDocIdLen(pk)
+	if w.rowSize != 0 && w.rowSize+l > len(*w.rowBuf) {
+		if err := w.flushPacked(); err != nil {
+			return err
+		}
+	}
+
+	if w.rowSize == 0 && l > cap(*w.rowBuf) {
+		(*w.rowBuf) = make([]byte, l, l)
+	}
+
+	packed := protobuf.AppendPackedDocId((*w.rowBuf)[:w.rowSize], pk)
+	w.rowSize = len(packed)
+	return nil
+}
+
+func (w *protoResponseWriter) flushPacked() error {
+	res := &protobuf.ResponseStream{PackedDocIds: (*w.rowBuf)[:w.rowSize], EstimatedRows: w.takeEstimate(),
+		MissingVbuckets: w.takeMissingVbuckets(), SnapshotTs: w.takeSnapshotTs()}
+	err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+	w.rowSize = 0
+	return err
+}
+
+// packedKeyRow accumulates the key and docid of a row in separate
+// columns, sending a ResponseStream when either column is full. Keys
+// are reused from rowBuf and docids from a second block, so the rows
+// of a scan are sent without allocating.
+func (w *protoResponseWriter) packedKeyRow(pk, sk []byte) error {
+	if w.docIdBlock == nil {
+		w.docIdBlock = p.GetBlock()
+		w.resetPacked()
+	}
+
+	if len(w.packedKeys) != 0 &&
+		(len(w.packedKeys)+protobuf.PackedKeyLen(sk) > cap(w.packedKeys) ||
+			len(w.packedDocIds)+protobuf.PackedDocIdLen(pk) > cap(w.packedDocIds)) {
+		if err := w.flushPackedRows(); err != nil {
+			return err
+		}
+	}
+
+	w.packedDocIds = protobuf.AppendPackedDocId(w.packedDocIds, pk)
+
+	if len(sk) == 0 {
+		w.packedKeys = protobuf.AppendPackedKey(w.packedKeys, nil)
+		return nil
+	}
+
+	if w.encoding&protobuf.EncodingKeyDict != 0 {
+		if ref, ok := w.keyDict[string(sk)]; ok {
+			w.packedKeys = protobuf.AppendPackedKeyRef(w.packedKeys, ref)
+			return nil
+		}
+		if w.keyDict == nil {
+			w.keyDict = make(map[string]int)
+		}
+		if len(w.keyDict) < maxPackedKeyDict {
+			w.keyDict[string(sk)] = w.numKeys
+		}
+	}
+	w.packedKeys = protobuf.AppendPackedKey(w.packedKeys, sk)
+	w.numKeys++
+	return nil
+}
+
+// bound on the distinct keys remembered for a ResponseStream
+const maxPackedKeyDict = 4096
+
+func (w *protoResponseWriter) flushPackedRows() error {
+	res := &protobuf.ResponseStream{
+		PackedRows: &protobuf.PackedRows{
+			Keys:   w.packedKeys,
+			Docids: w.packedDocIds,
+		},
+		EstimatedRows:   w.takeEstimate(),
+		MissingVbuckets: w.takeMissingVbuckets(),
+		SnapshotTs:      w.takeSnapshotTs(),
+	}
+	err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+	w.resetPacked()
+	return err
+}
+
+func (w *protoResponseWriter) resetPacked() {
+	if w.docIdBlock == nil {
+		return
+	}
+	w.packedKeys = (*w.rowBuf)[:0]
+	w.packedDocIds = (*w.docIdBlock)[:0]
+	for key := range w.keyDict {
+		delete(w.keyDict, key)
+	}
+	w.numKeys = 0
+}
+
 func (w *protoResponseWriter) Done() error {
 	defer p.PutBlock(w.encBuf)
 	defer p.PutBlock(w.rowBuf)
 
-	if (w.scanType == ScanReq || w.scanType == ScanAllReq) && w.rowSize > 0 {
-		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries}
+	if w.docIdOnly && w.rowSize > 0 {
+		return w.flushPacked()
+	}
+
+	if w.docIdBlock != nil {
+		defer p.PutBlock(w.docIdBlock)
+		if len(w.packedKeys) > 0 {
+			return w.flushPackedRows()
+		}
+	}
+
+	if (w.scanType == ScanReq || w.scanType == ScanAllReq || w.scanType == IntersectReq) &&
+		(w.rowSize > 0 || w.estimatedRows != nil || w.missingVbuckets != nil || w.snapshotTs != nil) {
+		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries, EstimatedRows: w.takeEstimate(),
+			MissingVbuckets: w.takeMissingVbuckets(), SnapshotTs: w.takeSnapshotTs()}
 		err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 		if err != nil {
 			return err
@@ -167,3 +310,54 @@ func (w *protoResponseWriter) Done() error {
 
 	return nil
 }
+
+// Explain sends the trace of an explain request.
+func (w *protoResponseWriter) Explain(explain []byte) error {
+	res := &protobuf.ResponseStream{Explain: explain, EstimatedRows: w.takeEstimate(),
+		MissingVbuckets: w.takeMissingVbuckets(), SnapshotTs: w.takeSnapshotTs()}
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
+// takeEstimate returns the estimated result size if it is not sent yet.
+func (w *protoResponseWriter) takeEstimate() *uint64 {
+	rows := w.estimatedRows
+	w.estimatedRows = nil
+	return rows
+}
+
+// takeMissingVbuckets returns the vbuckets skipped by a partial scan if
+// they are not sent yet.
+func (w *protoResponseWriter) takeMissingVbuckets() []uint32 {
+	vbs := w.missingVbuckets
+	w.missingVbuckets = nil
+	return vbs
+}
+
+// takeSnapshotTs returns the timestamp of the scanned snapshot if it is
+// not sent yet.
+func (w *protoResponseWriter) takeSnapshotTs() *protobuf.TsConsistency {
+	ts := w.snapshotTs
+	w.snapshotTs = nil
+	return ts
+}
+
+// setSnapshotTs sets the timestamp of the scanned snapshot, to be sent
+// to the client as a consistency token. Vbuckets without mutations are
+// left out, they satisfy any consistency vector.
+func (w *protoResponseWriter) setSnapshotTs(ts *common.TsVbuuid) {
+	if ts == nil {
+		return
+	}
+
+	vbnos := make([]uint16, 0, len(ts.Seqnos))
+	seqnos := make([]uint64, 0, len(ts.Seqnos))
+	vbuuids := make([]uint64, 0, len(ts.Seqnos))
+	for vbno, seqno := range ts.Seqnos {
+		if seqno != 0 {
+			vbnos = append(vbnos, uint16(vbno))
+			seqnos = append(seqnos, seqno)
+			vbuuids = append(vbuuids, ts.Vbuuids[vbno])
+		}
+	}
+	w.snapshotTs = protobuf.NewTsConsistency(vbnos, seqnos, vbuuids, 0)
+}
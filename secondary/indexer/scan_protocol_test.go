@@ -0,0 +1,42 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestProtoWriterSnapshotTs(t *testing.T) {
+	w := &protoResponseWriter{scanType: ScanReq}
+
+	w.setSnapshotTs(nil)
+	if ts := w.takeSnapshotTs(); ts != nil {
+		t.Fatalf("unexpected snapshot timestamp %v", ts)
+	}
+
+	// vbuckets without mutations are left out.
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos[1], ts.Vbuuids[1] = 10, 100
+	ts.Seqnos[3], ts.Vbuuids[3] = 30, 300
+	w.setSnapshotTs(ts)
+
+	snapshotTs := w.takeSnapshotTs()
+	if snapshotTs == nil {
+		t.Fatalf("expected snapshot timestamp")
+	}
+	if vbnos := snapshotTs.GetVbnos(); !reflect.DeepEqual(vbnos, []uint32{1, 3}) {
+		t.Errorf("unexpected vbuckets %v", vbnos)
+	}
+	if seqnos := snapshotTs.GetSeqnos(); !reflect.DeepEqual(seqnos, []uint64{10, 30}) {
+		t.Errorf("unexpected seqnos %v", seqnos)
+	}
+	if vbuuids := snapshotTs.GetVbuuids(); !reflect.DeepEqual(vbuuids, []uint64{100, 300}) {
+		t.Errorf("unexpected vbuuids %v", vbuuids)
+	}
+
+	// sent with the first response only.
+	if ts := w.takeSnapshotTs(); ts != nil {
+		t.Errorf("expected snapshot timestamp to be sent once, got %v", ts)
+	}
+}
@@ -38,6 +38,7 @@ const (
 	ScanAllReq                    = "scanAll"
 	HeloReq                       = "helo"
 	MultiScanCountReq             = "multiscancount"
+	IntersectReq                  = "intersect"
 )
 
 type ScanRequest struct {
@@ -72,6 +73,8 @@ type ScanRequest struct {
 	Distinct          bool
 	Offset            int64
 	projectPrimaryKey bool
+	DocIdOnly         bool // Return only docids, skipping secondary keys
+	DocMeta           bool // Return document metadata of primary index entries
 
 	//groupby/aggregate
 
@@ -90,6 +93,33 @@ type ScanRequest struct {
 	// Rollback Time
 	rollbackTime int64
 
+	// Priority used to schedule the scan
+	Priority common.ScanPriority
+
+	// Only estimate the number of rows, without scanning
+	EstimateOnly bool
+
+	// Trace the scan, without returning rows
+	Explain bool
+
+	// Scan available vbuckets, skipping quarantined vbuckets
+	AllowPartial bool
+
+	// Return timestamp of the scanned snapshot as a consistency token
+	ReturnSnapshotTs bool
+
+	// Staleness tolerated by StalenessConsistency
+	MaxStalenessSeqnos uint64
+	MaxStalenessTime   time.Duration
+	StalenessWait      bool
+
+	// Scans whose docids are intersected, for IntersectReq
+	Intersect []*ScanRequest
+
+	// Row encoding of responses, protobuf.EncodingPackedRows etc. For
+	// HeloReq, the encodings supported by the client.
+	Encoding uint32
+
 	ScanId      uint64
 	ExpiredTime time.Time
 	Timeout     *time.Timer
@@ -159,6 +189,22 @@ type CompositeElementFilter struct {
 	Low       IndexKey
 	High      IndexKey
 	Inclusion Inclusion
+	Nulls     NullMissingInclusion // NULL values of the field
+	Missing   NullMissingInclusion // MISSING values of the field
+}
+
+// NullMissingInclusion controls how NULL or MISSING values of a field
+// are treated by a CompositeElementFilter, independent of its range.
+type NullMissingInclusion int
+
+const (
+	NullMissingByRange NullMissingInclusion = iota
+	NullMissingInclude
+	NullMissingExclude
+)
+
+func (f CompositeElementFilter) hasNullMissing() bool {
+	return f.Nulls != NullMissingByRange || f.Missing != NullMissingByRange
 }
 
 // A point in index and the corresponding filter
@@ -302,6 +348,7 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 	switch req := protoReq.(type) {
 	case *protobuf.HeloRequest:
 		r.ScanType = HeloReq
+		r.Encoding = req.GetEncodings()
 	case *protobuf.StatisticsRequest:
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
@@ -328,6 +375,7 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
 		r.rollbackTime = req.GetRollbackTime()
+		r.Priority = common.ScanPriority(req.GetPriority())
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
 		cons := common.Consistency(req.GetCons())
 		vector := req.GetVector()
@@ -370,14 +418,25 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
 		r.rollbackTime = req.GetRollbackTime()
+		r.Priority = common.ScanPriority(req.GetPriority())
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
 		cons := common.Consistency(req.GetCons())
 		vector := req.GetVector()
 		r.ScanType = ScanReq
 		r.Incl = Inclusion(req.GetSpan().GetRange().GetInclusion())
 		r.Limit = req.GetLimit()
+		r.Encoding = req.GetEncoding()
 		r.Sorted = req.GetSorted()
 		r.Reverse = req.GetReverse()
+		r.EstimateOnly = req.GetEstimateOnly()
+		r.Explain = req.GetExplain()
+		r.AllowPartial = req.GetAllowPartial()
+		r.ReturnSnapshotTs = req.GetReturnSnapshotTs()
+		if bound := req.GetStaleness(); bound != nil {
+			r.MaxStalenessSeqnos = bound.GetMaxSeqnos()
+			r.MaxStalenessTime = time.Duration(bound.GetMaxTime()) * time.Millisecond
+			r.StalenessWait = bound.GetWait()
+		}
 		proj := req.GetIndexprojection()
 		if proj == nil {
 			r.Distinct = req.GetDistinct()
@@ -403,6 +462,15 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 					return
 				}
 				r.projectPrimaryKey = *proj.PrimaryKey
+				if r.DocIdOnly = proj.GetDocIdOnly(); r.DocIdOnly {
+					r.Indexprojection.projectSecKeys = false
+					r.projectPrimaryKey = true
+				}
+				if r.DocMeta = proj.GetDocMeta(); r.DocMeta {
+					if err = r.validateDocMeta(); err != nil {
+						return
+					}
+				}
 			} else {
 				if r.Indexprojection, localerr = validateIndexProjectionGroupAggr(proj, req.GetGroupAggr()); localerr != nil {
 					err = localerr
@@ -418,7 +486,12 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		if err != nil {
 			return
 		}
-		if err = r.fillScans(req.GetScans()); err != nil {
+		if ranges := req.GetSpan().GetRanges(); len(ranges) != 0 && len(req.GetScans()) == 0 {
+			err = r.fillSpanRanges(ranges)
+		} else {
+			err = r.fillScans(req.GetScans())
+		}
+		if err != nil {
 			return
 		}
 
@@ -431,11 +504,13 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
 		r.rollbackTime = req.GetRollbackTime()
+		r.Priority = common.ScanPriority(req.GetPriority())
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
 		cons := common.Consistency(req.GetCons())
 		vector := req.GetVector()
 		r.ScanType = ScanAllReq
 		r.Limit = req.GetLimit()
+		r.Encoding = req.GetEncoding()
 		r.Scans = make([]Scan, 1)
 		r.Scans[0].ScanType = AllReq
 		r.Sorted = true
@@ -452,6 +527,19 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		if err = r.setConsistency(cons, vector); err != nil {
 			return
 		}
+
+	case *protobuf.IntersectRequest:
+		r.RequestId = req.GetRequestId()
+		r.ScanType = IntersectReq
+		r.Limit = req.GetLimit()
+
+		if isBootstrapMode {
+			err = common.ErrIndexerInBootstrap
+			return
+		}
+
+		err = r.fillIntersect(req.GetScans(), ctx, cancelCh, s)
+
 	default:
 		err = ErrUnsupportedRequest
 	}
@@ -485,6 +573,10 @@ func (r *ScanRequest) Done() {
 		}
 	}
 
+	for _, sub := range r.Intersect {
+		sub.Done()
+	}
+
 	for _, buf := range r.keyBufList {
 		secKeyBufPool.Put(buf)
 	}
@@ -560,6 +652,52 @@ func (r *ScanRequest) fillRanges(low, high []byte, keys [][]byte) (localErr erro
 	return
 }
 
+// fillSpanRanges fills the scans of a request for several ranges of
+// the index key. Ranges are sorted on low key. For primary index,
+// overlapping ranges are merged, so that entries are scanned once and in
+// key order. Composite keys of secondary index do not merge on prefix
+// comparison, their ranges are scanned as is and must not overlap.
+func (r *ScanRequest) fillSpanRanges(ranges []*protobuf.Range) (localErr error) {
+	filters := make([]Filter, 0, len(ranges))
+	for _, rg := range ranges {
+		var l, h IndexKey
+		if l, localErr = r.newLowKey(rg.GetLow()); localErr != nil {
+			localErr = fmt.Errorf("Invalid low key %s (%s)", logging.TagStrUD(rg.GetLow()), localErr)
+			return
+		}
+		if h, localErr = r.newHighKey(rg.GetHigh()); localErr != nil {
+			localErr = fmt.Errorf("Invalid high key %s (%s)", logging.TagStrUD(rg.GetHigh()), localErr)
+			return
+		}
+		if IndexKeyLessThan(h, l) {
+			continue
+		}
+		filters = append(filters, Filter{Low: l, High: h, Inclusion: Inclusion(rg.GetInclusion())})
+	}
+
+	if len(filters) == 0 {
+		r.Scans = []Scan{r.getEmptyScan()}
+		return
+	}
+
+	sort.Sort(Filters(filters))
+	var scans []Scan
+	for _, filter := range filters {
+		if r.isPrimary {
+			scans = MergeFiltersForPrimary(scans, filter)
+			continue
+		}
+		scans = append(scans, Scan{
+			Low:      filter.Low,
+			High:     filter.High,
+			Incl:     flipInclusion(filter.Inclusion, r.IndexInst.Defn.Desc),
+			ScanType: RangeReq,
+		})
+	}
+	r.Scans = scans
+	return
+}
+
 func (r *ScanRequest) joinKeys(keys [][]byte) ([]byte, error) {
 	buf1 := r.getSharedBuffer(len(keys) * 3)
 	joined, e := jsonEncoder.JoinArray(keys, buf1)
@@ -574,7 +712,8 @@ func (r *ScanRequest) joinKeys(keys [][]byte) ([]byte, error) {
 func (r *ScanRequest) areFiltersNil(protoScan *protobuf.Scan) bool {
 	areFiltersNil := true
 	for _, filter := range protoScan.Filters {
-		if !r.isNil(filter.Low) || !r.isNil(filter.High) {
+		if !r.isNil(filter.Low) || !r.isNil(filter.High) ||
+			(!r.isPrimary && (filter.GetNulls() != 0 || filter.GetMissing() != 0)) {
 			areFiltersNil = false
 			break
 		}
@@ -816,7 +955,8 @@ func (r *ScanRequest) composeScans(points []IndexPoint, filters []Filter) []Scan
 		}
 
 		if scans[i].ScanType == FilterRangeReq && len(scans[i].Filters) == 1 &&
-			len(scans[i].Filters[0].CompositeFilters) == 1 {
+			len(scans[i].Filters[0].CompositeFilters) == 1 &&
+			!scans[i].Filters[0].CompositeFilters[0].hasNullMissing() {
 			// Flip inclusion if first element is descending
 			scans[i].Incl = flipInclusion(scans[i].Filters[0].CompositeFilters[0].Inclusion, r.IndexInst.Defn.Desc)
 			scans[i].ScanType = RangeReq
@@ -1038,7 +1178,7 @@ func (r *ScanRequest) fillScans(protoScans []*protobuf.Scan) (localErr error) {
 				return
 			}
 
-			var compFilters []CompositeElementFilter
+			var compFilters, rangeFilters []CompositeElementFilter
 			// Encode Filters
 			for _, fl := range protoScan.Filters {
 				if l, localErr = r.newLowKey(fl.Low); localErr != nil {
@@ -1051,17 +1191,33 @@ func (r *ScanRequest) fillScans(protoScans []*protobuf.Scan) (localErr error) {
 					return
 				}
 
-				if IndexKeyLessThan(h, l) {
-					skipScan = true
-					break
-				}
-
 				compfil := CompositeElementFilter{
 					Low:       l,
 					High:      h,
 					Inclusion: Inclusion(fl.GetInclusion()),
+					Nulls:     NullMissingInclusion(fl.GetNulls()),
+					Missing:   NullMissingInclusion(fl.GetMissing()),
+				}
+				if compfil.Nulls > NullMissingExclude || compfil.Missing > NullMissingExclude {
+					localErr = fmt.Errorf("Invalid null/missing inclusion %v/%v",
+						fl.GetNulls(), fl.GetMissing())
+					return
+				}
+				include := compfil.Nulls == NullMissingInclude ||
+					compfil.Missing == NullMissingInclude
+
+				if IndexKeyLessThan(h, l) && !include {
+					skipScan = true
+					break
 				}
 				compFilters = append(compFilters, compfil)
+
+				// NULL and MISSING collate below all other values, the
+				// scan range starts from the lowest key to include them.
+				if include {
+					compfil.Low = MinIndexKey
+				}
+				rangeFilters = append(rangeFilters, compfil)
 			}
 
 			if skipScan {
@@ -1073,7 +1229,7 @@ func (r *ScanRequest) fillScans(protoScans []*protobuf.Scan) (localErr error) {
 				Inclusion:        Both,
 			}
 
-			if localErr = r.fillFilterLowHigh(compFilters, &filter); localErr != nil {
+			if localErr = r.fillFilterLowHigh(rangeFilters, &filter); localErr != nil {
 				return
 			}
 
@@ -1163,6 +1319,8 @@ func (r *ScanRequest) setConsistency(cons common.Consistency, vector *protobuf.T
 		}
 		r.Ts.Crc64 = 0
 		r.Ts.Bucket = r.Bucket
+	} else if cons == common.StalenessConsistency {
+		localErr = r.setStalenessTs(cfg)
 	}
 	return
 }
@@ -1689,6 +1847,10 @@ func (r ScanRequest) String() string {
 		str += fmt.Sprintf(", groupaggr: %v", r.GroupAggr)
 	}
 
+	for i, sub := range r.Intersect {
+		str += fmt.Sprintf(", intersect[%d]: {%v}", i, sub)
+	}
+
 	return str
 }
 
@@ -0,0 +1,87 @@
+package indexer
+
+import (
+	"testing"
+
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/protobuf/proto"
+)
+
+func testSpanRange(low, high string, incl Inclusion) *protobuf.Range {
+	return &protobuf.Range{
+		Low: []byte(low), High: []byte(high), Inclusion: proto.Uint32(uint32(incl)),
+	}
+}
+
+func TestFillSpanRangesPrimary(t *testing.T) {
+	r := &ScanRequest{isPrimary: true}
+	ranges := []*protobuf.Range{
+		testSpanRange("x", "z", Low),
+		testSpanRange("b", "d", Both),
+		testSpanRange("a", "c", Both),
+		testSpanRange("q", "p", Both),
+	}
+	if err := r.fillSpanRanges(ranges); err != nil {
+		t.Fatal(err)
+	}
+
+	// overlapping ranges are merged, reversed ranges skipped.
+	if len(r.Scans) != 2 {
+		t.Fatalf("expected 2 scans, got %v", r.Scans)
+	}
+	expected := [][2]string{{"a", "d"}, {"x", "z"}}
+	for i, scan := range r.Scans {
+		if string(scan.Low.Bytes()) != expected[i][0] ||
+			string(scan.High.Bytes()) != expected[i][1] {
+			t.Errorf("scan %v: expected %v, got %s-%s",
+				i, expected[i], scan.Low.Bytes(), scan.High.Bytes())
+		}
+	}
+	if r.Scans[0].Incl != Both || r.Scans[1].Incl != Low {
+		t.Errorf("unexpected inclusion %v %v", r.Scans[0].Incl, r.Scans[1].Incl)
+	}
+
+	r = &ScanRequest{isPrimary: true}
+	if err := r.fillSpanRanges(ranges[3:]); err != nil {
+		t.Fatal(err)
+	} else if len(r.Scans) != 1 || r.Scans[0].Incl != Neither {
+		t.Errorf("expected empty scan, got %v", r.Scans)
+	}
+}
+
+func TestFillSpanRangesSecondary(t *testing.T) {
+	r := &ScanRequest{}
+	ranges := []*protobuf.Range{
+		testSpanRange(`[5,"a"]`, `[9]`, Low),
+		testSpanRange(`[1]`, `[6]`, Both),
+	}
+	if err := r.fillSpanRanges(ranges); err != nil {
+		t.Fatal(err)
+	}
+
+	// composite keys are not merged on prefix comparison.
+	if len(r.Scans) != 2 {
+		t.Fatalf("expected 2 scans, got %v", r.Scans)
+	}
+	for i, low := range []string{`[1]`, `[5,"a"]`} {
+		key, err := r.newKey([]byte(low))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Scans[i].Low.CompareIndexKey(key) != 0 || r.Scans[i].ScanType != RangeReq {
+			t.Errorf("scan %v: expected low %v, got %v", i, low, r.Scans[i])
+		}
+	}
+	if r.Scans[0].Incl != Both || r.Scans[1].Incl != Low {
+		t.Errorf("unexpected inclusion %v %v", r.Scans[0].Incl, r.Scans[1].Incl)
+	}
+
+	// inclusion is flipped on descending index.
+	r = &ScanRequest{}
+	r.IndexInst.Defn.Desc = []bool{true}
+	if err := r.fillSpanRanges(ranges[:1]); err != nil {
+		t.Fatal(err)
+	} else if r.Scans[0].Incl != High {
+		t.Errorf("expected inclusion %v, got %v", High, r.Scans[0].Incl)
+	}
+}
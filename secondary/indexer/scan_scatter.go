@@ -184,7 +184,9 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 		if queue != nil {
 
 			var r Row
-			if !request.isPrimary {
+			if request.DocMeta {
+				r.len = len(entry) - docMetaLen
+			} else if !request.isPrimary {
 				entry1 := secondaryIndexEntry(entry)
 				r.len = entry1.lenKey()
 			}
@@ -198,7 +200,9 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 	}
 
 	var err error
-	if scan.ScanType == AllReq {
+	if request.DocMeta {
+		err = rangeDocMeta(scan, ctx, snap.Snapshot(), handler)
+	} else if scan.ScanType == AllReq {
 		err = snap.Snapshot().All(ctx, handler)
 	} else if scan.ScanType == LookupReq {
 		err = snap.Snapshot().Range(ctx, scan.Equals, scan.Equals, Both, handler)
@@ -597,9 +601,30 @@ func forward(request *ScanRequest, queues []*Queue, donech chan bool, notifych c
 	}
 }
 
+// rangeDocMeta scans entries of a primary index along with their
+// document metadata.
+func rangeDocMeta(scan Scan, ctx IndexReaderContext, snap Snapshot, callb EntryCallback) error {
+
+	dm, ok := snap.(docMetaRanger)
+	if !ok {
+		return ErrDocMetaNotAvailable
+	}
+
+	switch scan.ScanType {
+	case AllReq:
+		return dm.RangeDocMeta(ctx, MinIndexKey, MaxIndexKey, Both, callb)
+	case LookupReq:
+		return dm.RangeDocMeta(ctx, scan.Equals, scan.Equals, Both, callb)
+	case RangeReq, FilterRangeReq:
+		return dm.RangeDocMeta(ctx, scan.Low, scan.High, scan.Incl, callb)
+	}
+	return nil
+}
+
 func compareKey(request *ScanRequest, k1 *Row, k2 *Row) int {
 
-	if request.isPrimary {
+	// rows with document metadata are compared by docid
+	if request.isPrimary && !request.DocMeta {
 		return comparePrimaryKey(k1, k2)
 	}
 
@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  scan scheduler
+//
+/////////////////////////////////////////////////////////////////////////
+
+// scanScheduler admits scans to run based on their priority. At most
+// maxConcurrent scans run at a time, out of which at most maxBatch can
+// be batch scans. Waiting interactive scans are always admitted ahead
+// of waiting batch scans, so full scans cannot starve lookups.
+// A limit of 0 means unlimited.
+type scanScheduler struct {
+	mu           sync.Mutex
+	running      int
+	runningBatch int
+	waiters      [2][]*scanWaiter
+}
+
+type scanWaiter struct {
+	granted bool
+	ch      chan struct{}
+}
+
+func isBatchPriority(p common.ScanPriority) bool {
+	return p == common.BatchPriority
+}
+
+// acquire blocks till the scan can be run, or the scan is cancelled or
+// timed out. Every successful acquire must be followed by a release.
+func (s *scanScheduler) acquire(p common.ScanPriority, cancelCh <-chan bool,
+	timeoutCh <-chan time.Time, maxConcurrent, maxBatch int) error {
+
+	batch := isBatchPriority(p)
+
+	s.mu.Lock()
+	if s.canRun(batch, maxConcurrent, maxBatch) && len(s.waiters[0]) == 0 &&
+		(!batch || len(s.waiters[1]) == 0) {
+		s.run(batch)
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &scanWaiter{ch: make(chan struct{})}
+	s.waiters[s.queue(batch)] = append(s.waiters[s.queue(batch)], w)
+	s.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.ch:
+		return nil
+	case <-cancelCh:
+		err = common.ErrClientCancel
+	case <-timeoutCh:
+		err = common.ErrScanTimedOut
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w.granted {
+		// Admitted while being cancelled. Give the slot back.
+		s.releaseLocked(batch, maxConcurrent, maxBatch)
+	} else {
+		q := s.waiters[s.queue(batch)]
+		for i, x := range q {
+			if x == w {
+				s.waiters[s.queue(batch)] = append(q[:i:i], q[i+1:]...)
+				break
+			}
+		}
+	}
+	return err
+}
+
+func (s *scanScheduler) release(p common.ScanPriority, maxConcurrent, maxBatch int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.releaseLocked(isBatchPriority(p), maxConcurrent, maxBatch)
+}
+
+func (s *scanScheduler) releaseLocked(batch bool, maxConcurrent, maxBatch int) {
+	s.running--
+	if batch {
+		s.runningBatch--
+	}
+	s.dispatch(maxConcurrent, maxBatch)
+}
+
+// dispatch admits waiting scans, interactive ones first.
+func (s *scanScheduler) dispatch(maxConcurrent, maxBatch int) {
+	for _, batch := range []bool{false, true} {
+		q := s.queue(batch)
+		for len(s.waiters[q]) > 0 && s.canRun(batch, maxConcurrent, maxBatch) {
+			w := s.waiters[q][0]
+			s.waiters[q] = s.waiters[q][1:]
+			s.run(batch)
+			w.granted = true
+			close(w.ch)
+		}
+	}
+}
+
+func (s *scanScheduler) canRun(batch bool, maxConcurrent, maxBatch int) bool {
+	if maxConcurrent > 0 && s.running >= maxConcurrent {
+		return false
+	}
+	return !batch || maxBatch <= 0 || s.runningBatch < maxBatch
+}
+
+func (s *scanScheduler) run(batch bool) {
+	s.running++
+	if batch {
+		s.runningBatch++
+	}
+}
+
+func (s *scanScheduler) queue(batch bool) int {
+	if batch {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanSchedulerPriority(t *testing.T) {
+	var s scanScheduler
+
+	if err := s.acquire(common.BatchPriority, nil, nil, 1, 0); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	order := make(chan common.ScanPriority, 2)
+	schedule := func(p common.ScanPriority) {
+		if err := s.acquire(p, nil, nil, 1, 0); err != nil {
+			t.Errorf("Unexpected error %v", err)
+			return
+		}
+		order <- p
+		s.release(p, 1, 0)
+	}
+
+	go schedule(common.BatchPriority)
+	time.Sleep(10 * time.Millisecond)
+	go schedule(common.InteractivePriority)
+	time.Sleep(10 * time.Millisecond)
+
+	s.release(common.BatchPriority, 1, 0)
+	if p := <-order; p != common.InteractivePriority {
+		t.Errorf("Expected interactive scan to run first, got %v", p)
+	}
+	<-order
+}
+
+func TestScanSchedulerBatchLimit(t *testing.T) {
+	var s scanScheduler
+
+	if err := s.acquire(common.BatchPriority, nil, nil, 0, 1); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// Interactive scans are not limited by batch scans.
+	if err := s.acquire(common.InteractivePriority, nil, nil, 0, 1); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	cancelCh := make(chan bool)
+	close(cancelCh)
+	if err := s.acquire(common.BatchPriority, cancelCh, nil, 0, 1); err != common.ErrClientCancel {
+		t.Errorf("Expected %v, got %v", common.ErrClientCancel, err)
+	}
+
+	if n := len(s.waiters[1]); n != 0 {
+		t.Errorf("Expected cancelled scan to leave the queue, found %v waiters", n)
+	}
+}
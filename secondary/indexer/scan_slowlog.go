@@ -0,0 +1,207 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  slow scan log
+//
+/////////////////////////////////////////////////////////////////////////
+
+// SlowScanEntry describes a scan that exceeded the latency or row count
+// threshold of the slow scan log.
+type SlowScanEntry struct {
+	Time         time.Time `json:"time"`
+	RequestId    string    `json:"requestId"`
+	Bucket       string    `json:"bucket"`
+	Index        string    `json:"index"`
+	DefnId       uint64    `json:"defnId"`
+	ScanType     string    `json:"scanType"`
+	Consistency  string    `json:"consistency"`
+	Scan         string    `json:"scan"`
+	RowsScanned  uint64    `json:"rowsScanned"`
+	RowsReturned uint64    `json:"rowsReturned"`
+	WaitTime     int64     `json:"waitTime"`
+	ScanTime     int64     `json:"scanTime"`
+	TotalTime    int64     `json:"totalTime"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// slowScanLog keeps the most recent slow scans in a ring buffer.
+type slowScanLog struct {
+	mu      sync.Mutex
+	entries []*SlowScanEntry
+	next    int
+	full    bool
+}
+
+func newSlowScanLog(size int) *slowScanLog {
+	if size <= 0 {
+		size = 1
+	}
+	return &slowScanLog{entries: make([]*SlowScanEntry, size)}
+}
+
+// add records an entry, overwriting the oldest one if the log is full.
+// The log is resized when size has changed.
+func (l *slowScanLog) add(e *SlowScanEntry, size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if size > 0 && size != len(l.entries) {
+		l.resize(size)
+	}
+
+	l.entries[l.next] = e
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// resize keeps the most recent entries that fit in size.
+func (l *slowScanLog) resize(size int) {
+	entries := l.list()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+
+	l.entries = make([]*SlowScanEntry, size)
+	copy(l.entries, entries)
+	l.next = len(entries) % size
+	l.full = len(entries) == size
+}
+
+// list returns the entries from oldest to newest. Caller must hold the lock.
+func (l *slowScanLog) list() []*SlowScanEntry {
+	if !l.full {
+		return append([]*SlowScanEntry(nil), l.entries[:l.next]...)
+	}
+
+	result := make([]*SlowScanEntry, 0, len(l.entries))
+	result = append(result, l.entries[l.next:]...)
+	return append(result, l.entries[:l.next]...)
+}
+
+func (l *slowScanLog) Entries() []*SlowScanEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.list()
+}
+
+func (l *slowScanLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = make([]*SlowScanEntry, len(l.entries))
+	l.next = 0
+	l.full = false
+}
+
+// recordSlowScan adds the scan to the slow scan log if it exceeds any
+// of the configured thresholds. A threshold of 0 disables it. Latency
+// of the scan includes its wait for a snapshot.
+func (s *scanCoordinator) recordSlowScan(req *ScanRequest, rowsScanned, rowsReturned uint64,
+	waitTime, scanTime time.Duration, err error) {
+
+	cfg := s.config.Load()
+	latency := time.Duration(cfg["settings.scan_slowlog.latency_threshold"].Int()) * time.Millisecond
+	rows := uint64(cfg["settings.scan_slowlog.rows_threshold"].Int())
+
+	totalTime := waitTime + scanTime
+	if (latency == 0 || totalTime < latency) && (rows == 0 || rowsScanned < rows) {
+		return
+	}
+
+	e := &SlowScanEntry{
+		Time:         time.Now(),
+		RequestId:    req.RequestId,
+		Bucket:       req.Bucket,
+		Index:        req.IndexName,
+		DefnId:       req.DefnID,
+		ScanType:     string(req.ScanType),
+		Scan:         logging.TagStrUD(req),
+		RowsScanned:  rowsScanned,
+		RowsReturned: rowsReturned,
+		WaitTime:     waitTime.Nanoseconds(),
+		ScanTime:     scanTime.Nanoseconds(),
+		TotalTime:    totalTime.Nanoseconds(),
+	}
+	if req.Consistency != nil {
+		e.Consistency = req.Consistency.String()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	s.slowScans.add(e, cfg["settings.scan_slowlog.size"].Int())
+
+	logging.Warnf("%s slow scan index:%v/%v rows scanned:%v returned:%v, waitTime:%v, scanTime:%v, totalTime:%v",
+		req.LogPrefix, req.Bucket, req.IndexName, rowsScanned, rowsReturned, waitTime, scanTime, totalTime)
+}
+
+// handleSlowScanLogReq returns the slow scan log on GET and clears it
+// on DELETE.
+func (s *scanCoordinator) handleSlowScanLogReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !common.IsAllowed(creds, []string{"cluster.settings!read"}, w) {
+			return
+		}
+
+		data, err := json.Marshal(s.slowScans.Entries())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("%v\n", err)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write(data)
+
+	case "DELETE":
+		if !common.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+
+		s.slowScans.Reset()
+		w.WriteHeader(200)
+		w.Write([]byte("OK"))
+
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+	}
+}
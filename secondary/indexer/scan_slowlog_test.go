@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func slowScanIds(entries []*SlowScanEntry) []string {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.RequestId
+	}
+	return ids
+}
+
+func TestSlowScanLogWrap(t *testing.T) {
+	l := newSlowScanLog(3)
+	for _, id := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		l.add(&SlowScanEntry{RequestId: id}, 3)
+	}
+
+	ids := slowScanIds(l.Entries())
+	if len(ids) != 3 || ids[0] != "r3" || ids[1] != "r4" || ids[2] != "r5" {
+		t.Errorf("Unexpected entries %v", ids)
+	}
+
+	l.Reset()
+	if n := len(l.Entries()); n != 0 {
+		t.Errorf("Expected empty log after reset, found %v entries", n)
+	}
+}
+
+func TestSlowScanLogResize(t *testing.T) {
+	l := newSlowScanLog(4)
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		l.add(&SlowScanEntry{RequestId: id}, 4)
+	}
+
+	l.add(&SlowScanEntry{RequestId: "r5"}, 2)
+	ids := slowScanIds(l.Entries())
+	if len(ids) != 2 || ids[0] != "r4" || ids[1] != "r5" {
+		t.Errorf("Unexpected entries %v", ids)
+	}
+}
+
+func TestRecordSlowScan(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("settings.scan_slowlog.latency_threshold", 100)
+	config.SetValue("settings.scan_slowlog.rows_threshold", 1000)
+	s := &scanCoordinator{slowScans: newSlowScanLog(10)}
+	s.config.Store(config)
+
+	testcases := []struct {
+		id                 string
+		rows               uint64
+		waitTime, scanTime time.Duration
+	}{
+		{"fast", 10, 10 * time.Millisecond, 50 * time.Millisecond},
+		{"slowScan", 10, 0, 150 * time.Millisecond},
+		{"slowWait", 10, 80 * time.Millisecond, 30 * time.Millisecond},
+		{"manyRows", 2000, 0, time.Millisecond},
+	}
+	for _, tc := range testcases {
+		req := &ScanRequest{RequestId: tc.id, ScanType: ScanReq}
+		s.recordSlowScan(req, tc.rows, tc.rows, tc.waitTime, tc.scanTime, nil)
+	}
+
+	entries := s.slowScans.Entries()
+	ids := slowScanIds(entries)
+	if len(ids) != 3 || ids[0] != "slowScan" || ids[1] != "slowWait" || ids[2] != "manyRows" {
+		t.Fatalf("Unexpected entries %v", ids)
+	}
+	e := entries[1]
+	if e.WaitTime != int64(80*time.Millisecond) || e.ScanTime != int64(30*time.Millisecond) ||
+		e.TotalTime != int64(110*time.Millisecond) {
+		t.Errorf("Unexpected timings of entry %+v", e)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  bounded staleness scans
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// A scan with StalenessConsistency tolerates the index lagging behind
+// KV by a bound given by the caller, either in seqnos or in time. The
+// seqno bound is applied by requiring a snapshot as recent as the KV
+// seqnos less the bound, on every vbucket.
+//
+// The time bound is applied by remembering, for each index, when a
+// snapshot was last found caught up with KV seqnos. If that happened
+// within the bound, the latest snapshot is scanned without fetching KV
+// seqnos. Otherwise a snapshot is required to be as recent as the KV
+// seqnos sampled by an earlier scan on the bucket, no older than the
+// bound, so that an index lagging by less than the bound need not catch
+// up with the current KV seqnos. Without such a sample, the oldest
+// sample within the bound is used, which is the current KV seqnos on
+// the first scan.
+//
+// When both bounds are given, a snapshot satisfying either of them is
+// scanned. If no snapshot satisfies the bound, the scan waits for one
+// like a session consistent scan, or fails with ErrIndexTooStale.
+//
+
+var ErrIndexTooStale = errors.New("Index is staler than the requested bound")
+
+// KV seqnos are sampled at most once in stalenessSampleInterval per
+// bucket, and samples are retained for stalenessHistory.
+const stalenessSampleInterval = time.Second
+const stalenessHistory = 10 * time.Minute
+
+// stalenessTracker remembers when indexes were last caught up with KV,
+// and recent KV seqnos of buckets.
+type stalenessTracker struct {
+	mu       sync.Mutex
+	caughtUp map[common.IndexInstId]time.Time
+	kvSeqnos map[string][]kvSeqnosSample // bucket -> samples, oldest first
+}
+
+type kvSeqnosSample struct {
+	time   time.Time
+	seqnos []uint64
+}
+
+func newStalenessTracker() *stalenessTracker {
+	return &stalenessTracker{
+		caughtUp: make(map[common.IndexInstId]time.Time),
+		kvSeqnos: make(map[string][]kvSeqnosSample),
+	}
+}
+
+// setCaughtUp records that the index had all KV mutations as of t.
+func (st *stalenessTracker) setCaughtUp(instId common.IndexInstId, t time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if t.After(st.caughtUp[instId]) {
+		st.caughtUp[instId] = t
+	}
+}
+
+// caughtUpWithin returns true if the index was caught up with KV within
+// maxTime before now.
+func (st *stalenessTracker) caughtUpWithin(instId common.IndexInstId,
+	maxTime time.Duration, now time.Time) bool {
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	t, ok := st.caughtUp[instId]
+	return ok && now.Sub(t) <= maxTime
+}
+
+// addKVSeqnos records the KV seqnos of bucket as of now.
+func (st *stalenessTracker) addKVSeqnos(bucket string, now time.Time, seqnos []uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	samples := st.kvSeqnos[bucket]
+	if n := len(samples); n > 0 && now.Sub(samples[n-1].time) < stalenessSampleInterval {
+		return
+	}
+	samples = append(samples, kvSeqnosSample{time: now, seqnos: seqnos})
+	i := 0
+	for i < len(samples)-1 && now.Sub(samples[i].time) > stalenessHistory {
+		i++
+	}
+	st.kvSeqnos[bucket] = samples[i:]
+}
+
+// kvSeqnosSince returns the oldest KV seqnos of bucket sampled at or
+// after t, nil if there is none.
+func (st *stalenessTracker) kvSeqnosSince(bucket string, t time.Time) []uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, sample := range st.kvSeqnos[bucket] {
+		if !sample.time.Before(t) {
+			return sample.seqnos
+		}
+	}
+	return nil
+}
+
+func (st *stalenessTracker) forget(instMap common.IndexInstMap) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	buckets := make(map[string]bool)
+	for _, inst := range instMap {
+		buckets[inst.Defn.Bucket] = true
+	}
+	for instId := range st.caughtUp {
+		if _, ok := instMap[instId]; !ok {
+			delete(st.caughtUp, instId)
+		}
+	}
+	for bucket := range st.kvSeqnos {
+		if !buckets[bucket] {
+			delete(st.kvSeqnos, bucket)
+		}
+	}
+}
+
+// setStalenessTs sets the timestamp a snapshot must be as recent as, to
+// be scanned within the staleness bound of the request. The timestamp is
+// nil if the index was caught up with KV within the time bound.
+func (r *ScanRequest) setStalenessTs(cfg common.Config) error {
+
+	tracker := r.sco.staleness
+	t0 := time.Now()
+	if r.MaxStalenessTime > 0 && tracker.caughtUpWithin(r.IndexInstId, r.MaxStalenessTime, t0) {
+		r.Ts = nil
+		return nil
+	}
+
+	seqnos, err := bucketSeqsWithRetry(cfg["settings.scan_getseqnos_retries"].Int(),
+		r.LogPrefix, cfg["clusterAddr"].String(), r.Bucket, cfg["numVbuckets"].Int())
+	if err != nil {
+		return err
+	}
+	if r.Stats != nil {
+		r.Stats.Timings.dcpSeqs.Put(time.Since(t0))
+	}
+	tracker.addKVSeqnos(r.Bucket, t0, seqnos)
+
+	kvTs := &common.TsVbuuid{Bucket: r.Bucket, Seqnos: seqnos}
+	r.sco.mu.RLock()
+	if ss := r.sco.lastSnapshot[r.IndexInstId]; ss != nil && ss.Timestamp().AsRecentTs(kvTs) {
+		tracker.setCaughtUp(r.IndexInstId, t0)
+	}
+	r.sco.mu.RUnlock()
+
+	var timeSeqnos []uint64
+	if r.MaxStalenessTime > 0 {
+		timeSeqnos = tracker.kvSeqnosSince(r.Bucket, t0.Add(-r.MaxStalenessTime))
+	}
+	r.Ts = &common.TsVbuuid{
+		Bucket: r.Bucket,
+		Seqnos: stalenessSeqnos(seqnos, r.MaxStalenessSeqnos, r.MaxStalenessTime > 0, timeSeqnos),
+	}
+	return nil
+}
+
+// stalenessSeqnos returns the seqnos a snapshot must be as recent as, on
+// every vbucket, given the current KV seqnos and the KV seqnos as of the
+// time bound. A snapshot within either bound is acceptable. Without a
+// seqno bound, only the time bound applies.
+func stalenessSeqnos(kvSeqnos []uint64, maxSeqnos uint64,
+	timeBound bool, timeSeqnos []uint64) []uint64 {
+
+	seqnos := make([]uint64, len(kvSeqnos))
+	for i, seqno := range kvSeqnos {
+		if timeBound && maxSeqnos == 0 {
+			seqnos[i] = seqno
+		} else if seqno > maxSeqnos {
+			seqnos[i] = seqno - maxSeqnos
+		}
+		if timeBound && i < len(timeSeqnos) && timeSeqnos[i] < seqnos[i] {
+			seqnos[i] = timeSeqnos[i]
+		}
+	}
+	return seqnos
+}
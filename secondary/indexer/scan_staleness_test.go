@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestStalenessTrackerCaughtUp(t *testing.T) {
+	st := newStalenessTracker()
+	now := time.Now()
+
+	if st.caughtUpWithin(1, time.Minute, now) {
+		t.Errorf("expected index not caught up")
+	}
+	st.setCaughtUp(1, now.Add(-10*time.Second))
+	st.setCaughtUp(1, now.Add(-time.Minute)) // older, ignored
+	if !st.caughtUpWithin(1, 10*time.Second, now) {
+		t.Errorf("expected index caught up within 10s")
+	}
+	if st.caughtUpWithin(1, 5*time.Second, now) {
+		t.Errorf("expected index not caught up within 5s")
+	}
+
+	st.addKVSeqnos("default", now, []uint64{1})
+	st.forget(common.IndexInstMap{})
+	if st.caughtUpWithin(1, time.Hour, now) || st.kvSeqnosSince("default", now) != nil {
+		t.Errorf("expected dropped index and bucket to be forgotten")
+	}
+}
+
+func TestStalenessTrackerKVSeqnos(t *testing.T) {
+	st := newStalenessTracker()
+	now := time.Now()
+
+	if seqnos := st.kvSeqnosSince("default", now.Add(-time.Minute)); seqnos != nil {
+		t.Errorf("expected no samples, got %v", seqnos)
+	}
+
+	st.addKVSeqnos("default", now, []uint64{10})
+	st.addKVSeqnos("default", now.Add(stalenessSampleInterval/2), []uint64{15}) // too soon
+	st.addKVSeqnos("default", now.Add(10*time.Second), []uint64{20})
+	st.addKVSeqnos("default", now.Add(20*time.Second), []uint64{30})
+
+	testcases := []struct {
+		since  time.Duration
+		seqnos []uint64
+	}{
+		{-time.Second, []uint64{10}},
+		{5 * time.Second, []uint64{20}},
+		{10 * time.Second, []uint64{20}},
+		{15 * time.Second, []uint64{30}},
+		{25 * time.Second, nil},
+	}
+	for _, tc := range testcases {
+		seqnos := st.kvSeqnosSince("default", now.Add(tc.since))
+		if !reflect.DeepEqual(seqnos, tc.seqnos) {
+			t.Errorf("since %v: expected %v, got %v", tc.since, tc.seqnos, seqnos)
+		}
+	}
+
+	// samples older than stalenessHistory are dropped.
+	later := now.Add(stalenessHistory + 15*time.Second)
+	st.addKVSeqnos("default", later, []uint64{40})
+	if seqnos := st.kvSeqnosSince("default", now); !reflect.DeepEqual(seqnos, []uint64{30}) {
+		t.Errorf("expected oldest sample %v, got %v", []uint64{30}, seqnos)
+	}
+}
+
+func TestStalenessSeqnos(t *testing.T) {
+	kvSeqnos := []uint64{100, 5, 50}
+	testcases := []struct {
+		name       string
+		maxSeqnos  uint64
+		timeBound  bool
+		timeSeqnos []uint64
+		expected   []uint64
+	}{
+		{"seqnos", 10, false, nil, []uint64{90, 0, 40}},
+		{"none", 0, false, nil, []uint64{100, 5, 50}},
+		{"time", 0, true, []uint64{80, 5, 45}, []uint64{80, 5, 45}},
+		{"time without sample", 0, true, nil, []uint64{100, 5, 50}},
+		{"either", 10, true, []uint64{95, 5, 30}, []uint64{90, 0, 30}},
+	}
+	for _, tc := range testcases {
+		seqnos := stalenessSeqnos(kvSeqnos, tc.maxSeqnos, tc.timeBound, tc.timeSeqnos)
+		if !reflect.DeepEqual(seqnos, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.expected, seqnos)
+		}
+	}
+}
@@ -11,6 +11,7 @@ package indexer
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -34,6 +35,8 @@ import (
 const (
 	indexCompactonMetaPath = common.IndexingMetaDir + "triggerCompaction"
 	compactionDaysSetting  = "indexer.settings.compaction.days_of_week"
+	alertWebhooksSetting   = "indexer.settings.alert.webhooks"
+	alertAllowlistConfig   = "indexer.alert.webhook_allowlist"
 )
 
 // Implements dynamic settings management for indexer
@@ -45,6 +48,9 @@ type settingsManager struct {
 	compactionToken []byte
 	indexerReady    bool
 	notifyPending   bool
+
+	// hosts to which alert webhooks may post, as set on the command line.
+	webhookAllowlist []string
 }
 
 func NewSettingsManager(supvCmdch MsgChannel,
@@ -54,6 +60,8 @@ func NewSettingsManager(supvCmdch MsgChannel,
 		supvMsgch: supvMsgch,
 		config:    config,
 		cancelCh:  make(chan struct{}),
+
+		webhookAllowlist: splitAlertAllowlist(config[alertAllowlistConfig].String()),
 	}
 
 	config, err := common.GetSettingsConfig(config)
@@ -73,6 +81,8 @@ func NewSettingsManager(supvCmdch MsgChannel,
 	http.HandleFunc("/settings/runtime/freeMemory", s.handleFreeMemoryReq)
 	http.HandleFunc("/settings/runtime/forceGC", s.handleForceGCReq)
 	http.HandleFunc("/plasmaDiag", s.handlePlasmaDiag)
+	http.HandleFunc("/tasks", s.handleTasksReq)
+	http.HandleFunc("/protobufSchemas", s.handleProtobufSchemasReq)
 
 	go func() {
 		fn := func(r int, err error) error {
@@ -153,14 +163,17 @@ func (s *settingsManager) handleSettings(w http.ResponseWriter, r *http.Request,
 				config.Update(current)
 			}
 
-			err = validateSettings(bytes, config, internal)
+			err = validateSettings(bytes, config, s.webhookAllowlist, internal)
 			if err != nil {
 				logging.Errorf("Fail to change setting.  Error: %v", err)
 				s.writeError(w, err)
 				return
 			}
 
-			err = config.Update(bytes)
+			// settings of the request override its profile
+			if err = applySettingsProfile(bytes, config); err == nil {
+				err = config.Update(bytes)
+			}
 		}
 
 		if err != nil {
@@ -236,6 +249,50 @@ func (s *settingsManager) handlePlasmaDiag(w http.ResponseWriter, r *http.Reques
 	plasma.Diag.HandleHttp(w, r)
 }
 
+// handleTasksReq returns the status of long running tasks on GET, of
+// all tasks or the task given by taskId, and cancels the task given by
+// taskId on DELETE.
+func (s *settingsManager) handleTasksReq(w http.ResponseWriter, r *http.Request) {
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	var data interface{}
+	var err error
+	taskId := r.FormValue("taskId")
+
+	switch r.Method {
+	case "GET":
+		if !common.IsAllowed(creds, []string{"cluster.settings!read"}, w) {
+			return
+		}
+		data, err = common.GetTasks(taskId)
+
+	case "DELETE":
+		if !common.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+		data, err = common.CancelTask(taskId)
+
+	default:
+		s.writeError(w, errors.New("Unsupported method"))
+		return
+	}
+
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJson(w, buf)
+}
+
 func (s *settingsManager) run() {
 loop:
 	for {
@@ -426,6 +483,7 @@ func initGlobalSettings(oldCfg, newCfg common.Config) {
 
 	ncpu := common.SetNumCPUs(newCfg["indexer.settings.max_cpu_percent"].Int())
 	logging.Infof("Setting maxcpus = %d", ncpu)
+	setCPUQuota(ncpu, newCfg)
 
 	setLogger(newCfg)
 	useMutationSyncPool = newCfg["indexer.useMutationSyncPool"].Bool()
@@ -462,11 +520,23 @@ func initStorageSettings(newCfg common.Config) {
 	ErrSecKeyTooLong = errors.New(fmt.Sprintf("Secondary key is too long (> %d)", maxSecKeyLen))
 }
 
-func validateSettings(value []byte, current common.Config, internal bool) error {
+func validateSettings(value []byte, current common.Config,
+	webhookAllowlist []string, internal bool) error {
+
 	newConfig, err := common.NewConfig(value)
 	if err != nil {
 		return err
 	}
+	if _, ok := newConfig[alertAllowlistConfig]; ok {
+		return fmt.Errorf("%v can only be set on the command line", alertAllowlistConfig)
+	}
+	if val, ok := newConfig[alertWebhooksSetting]; ok {
+		for _, url := range alertWebhookURLs(val.String()) {
+			if err := checkAlertWebhook(url, webhookAllowlist); err != nil {
+				return err
+			}
+		}
+	}
 	if val, ok := newConfig[compactionDaysSetting]; ok {
 		for _, day := range val.Strings() {
 			if !isValidDay(day) {
@@ -478,6 +548,12 @@ func validateSettings(value []byte, current common.Config, internal bool) error
 		}
 	}
 
+	if val, ok := newConfig[settingsProfileKey]; ok {
+		if _, err := settingsOfProfile(val.String()); err != nil {
+			return err
+		}
+	}
+
 	if val, ok := newConfig["indexer.settings.max_seckey_size"]; ok {
 		if val.Int() <= 0 {
 			return errors.New("Setting should be an integer greater than 0")
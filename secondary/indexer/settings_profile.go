@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//
+// Settings profiles.
+//
+// A profile is a named set of settings tuned together, for a workload.
+// When indexer.settings.profile is set through the settings API, the
+// settings of the profile are applied first, and any other setting in
+// the same request overrides the profile. Settings changed later are
+// not reverted by the profile, until it is set again.
+//
+// standard         - defaults of the indexer.
+// memory_optimized - shorter mutation queues, fewer rollback points and
+//                    eager compaction, admission control at lower memory.
+// ingest_heavy     - longer mutation queues, less frequent snapshots and
+//                    lazy compaction, fewer concurrent batch scans.
+//
+
+const settingsProfileKey = "indexer.settings.profile"
+
+// settings tuned by profiles, the standard profile restores their
+// defaults.
+var settingsProfileKeys = []string{
+	"indexer.settings.minVbQueueLength",
+	"indexer.settings.inmemory_snapshot.interval",
+	"indexer.settings.persisted_snapshot.interval",
+	"indexer.settings.persisted_snapshot_init_build.interval",
+	"indexer.settings.recovery.max_rollbacks",
+	"indexer.settings.moi.recovery.max_rollbacks",
+	"indexer.settings.plasma.recovery.max_rollbacks",
+	"indexer.settings.compaction.min_frag",
+	"indexer.settings.scan_scheduler.max_batch_concurrent",
+	"indexer.settings.admission.high_mem_frac",
+}
+
+var settingsProfiles = map[string]map[string]interface{}{
+	"standard": standardSettingsProfile(),
+	"memory_optimized": {
+		"indexer.settings.minVbQueueLength":              uint64(100),
+		"indexer.settings.recovery.max_rollbacks":        2,
+		"indexer.settings.moi.recovery.max_rollbacks":    1,
+		"indexer.settings.plasma.recovery.max_rollbacks": 1,
+		"indexer.settings.compaction.min_frag":           20,
+		"indexer.settings.admission.high_mem_frac":       0.8,
+	},
+	"ingest_heavy": {
+		"indexer.settings.minVbQueueLength":                       uint64(1000),
+		"indexer.settings.inmemory_snapshot.interval":             uint64(500),
+		"indexer.settings.persisted_snapshot.interval":            uint64(10000),
+		"indexer.settings.persisted_snapshot_init_build.interval": uint64(15000),
+		"indexer.settings.compaction.min_frag":                    50,
+		"indexer.settings.scan_scheduler.max_batch_concurrent":    2,
+	},
+}
+
+func standardSettingsProfile() map[string]interface{} {
+	profile := make(map[string]interface{})
+	for _, key := range settingsProfileKeys {
+		profile[key] = common.SystemConfig[key].Value
+	}
+	return profile
+}
+
+// settingsOfProfile returns the settings of the profile, with defaults
+// of settings not tuned by the profile.
+func settingsOfProfile(name string) (map[string]interface{}, error) {
+	profile, ok := settingsProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid settings profile %q, must be one of "+
+			"standard, memory_optimized, ingest_heavy", name)
+	}
+
+	settings := standardSettingsProfile()
+	for key, value := range profile {
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// applySettingsProfile updates config with the settings of the profile
+// in the new settings, if any.
+func applySettingsProfile(newSettings []byte, config common.Config) error {
+	newConfig, err := common.NewConfig(newSettings)
+	if err != nil {
+		return err
+	}
+
+	val, ok := newConfig[settingsProfileKey]
+	if !ok {
+		return nil
+	}
+
+	settings, err := settingsOfProfile(val.String())
+	if err != nil {
+		return err
+	}
+	return config.Update(settings)
+}
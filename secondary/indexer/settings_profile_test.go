@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestSettingsOfProfile(t *testing.T) {
+	testcases := []struct {
+		profile string
+		key     string
+		value   interface{}
+	}{
+		{"standard", "indexer.settings.minVbQueueLength",
+			common.SystemConfig["indexer.settings.minVbQueueLength"].Value},
+		{"memory_optimized", "indexer.settings.minVbQueueLength", uint64(100)},
+		{"Memory_Optimized", "indexer.settings.admission.high_mem_frac", 0.8},
+		{"memory_optimized", "indexer.settings.inmemory_snapshot.interval",
+			common.SystemConfig["indexer.settings.inmemory_snapshot.interval"].Value},
+		{"ingest_heavy", "indexer.settings.persisted_snapshot.interval", uint64(10000)},
+		{"ingest_heavy", "indexer.settings.recovery.max_rollbacks",
+			common.SystemConfig["indexer.settings.recovery.max_rollbacks"].Value},
+	}
+	for _, tc := range testcases {
+		settings, err := settingsOfProfile(tc.profile)
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", tc.profile, err)
+		}
+		if len(settings) != len(settingsProfileKeys) {
+			t.Errorf("%v: expected all profile settings, got %v", tc.profile, settings)
+		}
+		if settings[tc.key] != tc.value {
+			t.Errorf("%v: expected %v for %v, got %v",
+				tc.profile, tc.value, tc.key, settings[tc.key])
+		}
+	}
+
+	if _, err := settingsOfProfile("fastest"); err == nil {
+		t.Errorf("Expected error for invalid profile")
+	}
+}
+
+func TestApplySettingsProfile(t *testing.T) {
+	testcases := []struct {
+		settings string
+		err      bool
+		values   map[string]interface{}
+	}{
+		{`{"indexer.settings.max_cpu_percent": 400}`, false,
+			map[string]interface{}{
+				"indexer.settings.minVbQueueLength":       uint64(500),
+				"indexer.settings.recovery.max_rollbacks": 7,
+			}},
+		{`{"indexer.settings.profile": "ingest_heavy"}`, false,
+			map[string]interface{}{
+				"indexer.settings.minVbQueueLength":                       uint64(1000),
+				"indexer.settings.inmemory_snapshot.interval":             uint64(500),
+				"indexer.settings.persisted_snapshot_init_build.interval": uint64(15000),
+				"indexer.settings.compaction.min_frag":                    50,
+				"indexer.settings.scan_scheduler.max_batch_concurrent":    2,
+				"indexer.settings.recovery.max_rollbacks":                 7,
+			}},
+		{`{"indexer.settings.profile": "memory_optimized"}`, false,
+			map[string]interface{}{
+				"indexer.settings.minVbQueueLength":              uint64(100),
+				"indexer.settings.recovery.max_rollbacks":        2,
+				"indexer.settings.plasma.recovery.max_rollbacks": 1,
+				"indexer.settings.admission.high_mem_frac":       0.8,
+				"indexer.settings.compaction.min_frag":           20,
+			}},
+		{`{"indexer.settings.profile": "standard"}`, false,
+			map[string]interface{}{
+				"indexer.settings.minVbQueueLength":       uint64(250),
+				"indexer.settings.compaction.min_frag":    30,
+				"indexer.settings.recovery.max_rollbacks": 5,
+			}},
+		{`{"indexer.settings.profile": "fastest"}`, true, nil},
+		{`{"indexer.settings.profile": `, true, nil},
+	}
+
+	for _, tc := range testcases {
+		config := common.SystemConfig.Clone()
+		config.SetValue("indexer.settings.minVbQueueLength", uint64(500))
+		config.SetValue("indexer.settings.recovery.max_rollbacks", 7)
+
+		err := applySettingsProfile([]byte(tc.settings), config)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected error", tc.settings)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%v: unexpected error %v", tc.settings, err)
+		}
+		for key, value := range tc.values {
+			if config[key].Value != value {
+				t.Errorf("%v: expected %v for %v, got %v",
+					tc.settings, value, key, config[key].Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+//  Copyright (c) 2026 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Index slices are placed on the data directories of the indexer, the
+// storage directory and the directories of settings.storage_dirs. A new
+// slice is placed on the data directory holding the fewest slices, an
+// existing slice is found on whichever data directory it is on.
+//
+// A slice is relocated to another data directory by writing a copy of
+// it into a slice directory marked as relocating, the marker is removed
+// once the slice has switched over to the copy. On restart, a marked
+// slice directory is a partial copy and is removed.
+
+var (
+	ErrInvalidDataDir      = errors.New("Not a data directory of the indexer")
+	ErrNoSnapshotToCompact = errors.New("No snapshot to compact upto")
+	ErrSliceNotRelocatable = errors.New("Relocation is supported for forestdb indexes only")
+)
+
+const sliceRelocatingMarker = "slice.relocating"
+
+// relocatableSlice is a slice that can be relocated to another data
+// directory while in use.
+type relocatableSlice interface {
+	Relocate(dir string) error
+}
+
+func relocateSlice(slice Slice, dir string) error {
+	if rs, ok := slice.(relocatableSlice); ok {
+		return rs.Relocate(dir)
+	}
+	return ErrSliceNotRelocatable
+}
+
+// dataDirs returns the data directories of the indexer, the storage
+// directory first.
+func dataDirs(conf common.Config) []string {
+	dirs := []string{filepath.Clean(conf["storage_dir"].String())}
+	if v, ok := conf["settings.storage_dirs"]; ok {
+		for _, dir := range strings.Split(v.String(), ",") {
+			if dir = strings.TrimSpace(dir); dir == "" {
+				continue
+			}
+			if dir = filepath.Clean(dir); !containsDir(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+func isDataDir(conf common.Config, dir string) bool {
+	return containsDir(dataDirs(conf), filepath.Clean(dir))
+}
+
+func containsDir(dirs []string, dir string) bool {
+	for _, d := range dirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// SlicePath returns the directory of a slice. It is the directory of an
+// existing slice, on any of the data directories, otherwise a directory
+// on the data directory with the fewest slices.
+func SlicePath(conf common.Config, inst *common.IndexInst,
+	partnId common.PartitionId, sliceId SliceId) string {
+
+	name := IndexPath(inst, partnId, sliceId)
+	dirs := dataDirs(conf)
+
+	var found []string
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		dir := leastUsedDataDir(dirs)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logging.Errorf("SlicePath: Error creating data directory %v. Error %v", dir, err)
+		}
+		return filepath.Join(dir, name)
+	case 1:
+		return found[0]
+	}
+	return resolveRelocatedSlice(found)
+}
+
+// resolveRelocatedSlice returns the current directory of a slice found
+// on several data directories, left behind by a relocation interrupted
+// by a restart, and removes the others. A marked directory is a partial
+// copy, unless the old file has already been removed on switch over.
+// Otherwise the directory with the newest file version is current.
+func resolveRelocatedSlice(paths []string) string {
+	current, version := "", -1
+	for _, relocating := range []bool{false, true} {
+		for _, path := range paths {
+			if isSliceRelocating(path) != relocating {
+				continue
+			}
+			if v := fdbFileVersion(path); v > version {
+				current, version = path, v
+			}
+		}
+		if current != "" {
+			break
+		}
+	}
+	if current == "" {
+		logging.Warnf("SlicePath: No data file in any of %v, using %v", paths, paths[0])
+		return paths[0]
+	}
+	if isSliceRelocating(current) {
+		endSliceRelocation(current)
+	}
+
+	for _, path := range paths {
+		if path == current {
+			continue
+		}
+		logging.Infof("SlicePath: Removing %v left by interrupted relocation, slice is at %v",
+			path, current)
+		if err := os.RemoveAll(path); err != nil {
+			logging.Errorf("SlicePath: Error removing %v. Error %v", path, err)
+		}
+	}
+	return current
+}
+
+// removeSlicePaths removes the directories of a slice on all the data
+// directories.
+func removeSlicePaths(conf common.Config, inst *common.IndexInst,
+	partnId common.PartitionId, sliceId SliceId) error {
+
+	name := IndexPath(inst, partnId, sliceId)
+	for _, dir := range dataDirs(conf) {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func leastUsedDataDir(dirs []string) string {
+	least, count := dirs[0], -1
+	for _, dir := range dirs {
+		slices, _ := filepath.Glob(filepath.Join(dir, "*.index"))
+		if count < 0 || len(slices) < count {
+			least, count = dir, len(slices)
+		}
+	}
+	return least
+}
+
+// fdbFileVersion returns the newest version of the data files in path,
+// -1 if there are none.
+func fdbFileVersion(path string) int {
+	version := -1
+	files, _ := filepath.Glob(filepath.Join(path, "data.fdb.*"))
+	for _, file := range files {
+		var v int
+		if _, err := fmt.Sscanf(filepath.Base(file), "data.fdb.%d", &v); err == nil && v > version {
+			version = v
+		}
+	}
+	return version
+}
+
+func beginSliceRelocation(path string) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(path, sliceRelocatingMarker), nil, 0644)
+}
+
+func endSliceRelocation(path string) {
+	marker := filepath.Join(path, sliceRelocatingMarker)
+	if err := os.Remove(marker); err != nil {
+		logging.Errorf("SlicePath: Error removing %v. Error %v", marker, err)
+	}
+}
+
+func isSliceRelocating(path string) bool {
+	_, err := os.Stat(filepath.Join(path, sliceRelocatingMarker))
+	return err == nil
+}
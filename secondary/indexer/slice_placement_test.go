@@ -0,0 +1,56 @@
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestSlicePlacement(t *testing.T) {
+	root, err := ioutil.TempDir("", "slice_placement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir1, dir2 := filepath.Join(root, "d1"), filepath.Join(root, "d2")
+	conf := common.Config{
+		"storage_dir":           common.ConfigValue{Value: dir1},
+		"settings.storage_dirs": common.ConfigValue{Value: " " + dir2 + ", " + dir1 + "/"},
+	}
+	if dirs := dataDirs(conf); len(dirs) != 2 || dirs[0] != dir1 || dirs[1] != dir2 {
+		t.Fatalf("unexpected data dirs %v", dirs)
+	}
+
+	os.MkdirAll(filepath.Join(dir1, "b_i1_1_0.index"), 0755)
+	if leastUsedDataDir(dataDirs(conf)) != dir2 {
+		t.Fatalf("expected new slice on %v", dir2)
+	}
+
+	// interrupted relocation, copy to dir2 is partial
+	old, partial := filepath.Join(dir1, "b_i2_2_0.index"), filepath.Join(dir2, "b_i2_2_0.index")
+	os.MkdirAll(old, 0755)
+	ioutil.WriteFile(filepath.Join(old, "data.fdb.3"), nil, 0644)
+	beginSliceRelocation(partial)
+	ioutil.WriteFile(filepath.Join(partial, "data.fdb.4"), nil, 0644)
+	if path := resolveRelocatedSlice([]string{old, partial}); path != old {
+		t.Fatalf("expected %v, got %v", old, path)
+	}
+	if _, err := os.Stat(partial); err == nil {
+		t.Fatalf("expected partial copy %v to be removed", partial)
+	}
+
+	// interrupted after switch over, old file already removed
+	beginSliceRelocation(partial)
+	ioutil.WriteFile(filepath.Join(partial, "data.fdb.4"), nil, 0644)
+	os.Remove(filepath.Join(old, "data.fdb.3"))
+	if path := resolveRelocatedSlice([]string{old, partial}); path != partial {
+		t.Fatalf("expected %v, got %v", partial, path)
+	}
+	if isSliceRelocating(partial) {
+		t.Fatalf("expected relocation marker to be removed")
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/fdb"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/memdb"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  offline slice upgrade
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// A forestdb slice is upgraded offline, while the indexer is stopped,
+// from the latest committed snapshot of the slice, without rebuilding
+// the index from KV. To forestdb, the data file is compacted into the
+// file format of the forestdb library. To memdb, the entries of the
+// main index are written as a memdb disk snapshot, the back index is
+// rebuilt from them when the snapshot is loaded. Both storages share
+// the format of index entries, so entries are copied as they are.
+//
+// The upgraded slice is written next to the slice, and swapped with it
+// once written. The original slice is kept, so that the upgrade can be
+// rolled back, till the upgrade is committed.
+//
+
+var (
+	ErrUpgradeNoSnapshot   = errors.New("No committed snapshot to upgrade")
+	ErrUpgradeDocMeta      = errors.New("Primary index with document metadata cannot be converted to memdb")
+	ErrUpgradePending      = errors.New("Previous upgrade is not committed or rolled back")
+	ErrUpgradeNotFound     = errors.New("No upgrade to commit or roll back")
+	ErrUpgradeMismatch     = errors.New("Upgraded slice does not match the original slice")
+	ErrUpgradeStorageMode  = errors.New("Unsupported storage mode for upgrade")
+	ErrUpgradeSliceUnknown = errors.New("Neither a forestdb nor a memdb slice")
+)
+
+const (
+	sliceUpgradeSuffix = ".upgrade"
+	sliceOrigSuffix    = ".orig"
+)
+
+// SliceDigest is the content of the latest committed snapshot of a
+// slice.
+type SliceDigest struct {
+	Path     string
+	Ts       *common.TsVbuuid
+	Count    uint64
+	Checksum uint64 // crc64 of the entries in index order
+}
+
+// UpgradeSlice upgrades the forestdb slice at path to storage mode, and
+// verifies the upgraded slice against the original before the swap if
+// verify is set.
+func UpgradeSlice(path string, mode common.StorageMode, verify bool) error {
+
+	path = filepath.Clean(path)
+	orig, tmp := path+sliceOrigSuffix, path+sliceUpgradeSuffix
+
+	if _, err := os.Stat(orig); err == nil {
+		return ErrUpgradePending
+	}
+
+	// left over by an interrupted upgrade
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	var err error
+	switch mode {
+	case common.FORESTDB:
+		err = upgradeFdbSlice(path, tmp)
+	case common.MOI:
+		err = convertFdbSliceToMemdb(path, tmp)
+	default:
+		err = ErrUpgradeStorageMode
+	}
+
+	if err == nil && verify {
+		_, _, err = compareSlices(path, tmp)
+	}
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	if err := os.Rename(path, orig); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Rename(orig, path)
+		return err
+	}
+
+	logging.Infof("UpgradeSlice: upgraded %v to %v. Original slice is kept at %v", path, mode, orig)
+	return nil
+}
+
+// VerifySliceUpgrade compares the upgraded slice at path with the
+// original slice it was upgraded from.
+func VerifySliceUpgrade(path string) (*SliceDigest, *SliceDigest, error) {
+
+	path = filepath.Clean(path)
+	orig := path + sliceOrigSuffix
+	if _, err := os.Stat(orig); err != nil {
+		return nil, nil, ErrUpgradeNotFound
+	}
+
+	return compareSlices(orig, path)
+}
+
+// RollbackSliceUpgrade restores the original slice of an upgrade.
+func RollbackSliceUpgrade(path string) error {
+
+	path = filepath.Clean(path)
+	orig := path + sliceOrigSuffix
+	if _, err := os.Stat(orig); err != nil {
+		return ErrUpgradeNotFound
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	return os.Rename(orig, path)
+}
+
+// CommitSliceUpgrade removes the original slice of an upgrade.
+func CommitSliceUpgrade(path string) error {
+
+	orig := filepath.Clean(path) + sliceOrigSuffix
+	if _, err := os.Stat(orig); err != nil {
+		return ErrUpgradeNotFound
+	}
+
+	return os.RemoveAll(orig)
+}
+
+// upgradeFdbSlice writes the slice at path to dst in the current forestdb
+// file format. The data file is copied first, as the file compacted from
+// is removed by forestdb.
+func upgradeFdbSlice(path, dst string) error {
+
+	src := newFdbFile(path, false)
+	if _, err := os.Stat(src); err != nil {
+		return ErrUpgradeSliceUnknown
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	file := filepath.Join(dst, filepath.Base(src))
+	if err := common.CopyFile(file, src); err != nil {
+		return err
+	}
+
+	dbfile, err := forestdb.Open(file, forestdb.DefaultConfig())
+	if err != nil {
+		return err
+	}
+
+	from := dbfile.GetFileVersion()
+	newfile := newFdbFile(dst, true)
+	err = dbfile.Compact(newfile)
+	dbfile.Close()
+	if err != nil {
+		return err
+	}
+	os.Remove(file)
+
+	config := forestdb.DefaultConfig()
+	config.SetOpenFlags(forestdb.OPEN_FLAG_RDONLY)
+	if dbfile, err = forestdb.Open(newfile, config); err != nil {
+		return err
+	}
+	to := dbfile.GetFileVersion()
+	dbfile.Close()
+
+	logging.Infof("UpgradeSlice: compacted %v into %v. File version %v to %v", src, newfile,
+		forestdb.FdbFileVersionToString(from), forestdb.FdbFileVersionToString(to))
+	return nil
+}
+
+// convertFdbSliceToMemdb writes the latest committed snapshot of the
+// slice at path to dst as a memdb disk snapshot.
+func convertFdbSliceToMemdb(path, dst string) error {
+
+	cfg := memdb.DefaultConfig()
+	cfg.SetKeyComparator(byteItemCompare)
+	store := memdb.NewWithConfig(cfg)
+	defer store.Close()
+
+	w := store.NewWriter()
+	info, err := iterateFdbSlice(path, func(key, value []byte) error {
+		if len(value) != 0 {
+			return ErrUpgradeDocMeta
+		}
+		w.Put(append([]byte(nil), key...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	snap, err := store.NewSnapshot()
+	if err != nil {
+		return err
+	}
+
+	tmpdir := filepath.Join(dst, tmpDirName)
+	if err := store.StoreToDisk(tmpdir, snap, 1, nil); err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(&memdbSnapshotInfo{Ts: info.Ts})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "manifest.json"), bs, 0755); err != nil {
+		return err
+	}
+
+	dir := newSnapshotPath(dst)
+	if err := os.Rename(tmpdir, dir); err != nil {
+		return err
+	}
+
+	logging.Infof("UpgradeSlice: converted %v into memdb snapshot %v. Items %v", path, dir, store.ItemsCount())
+	return nil
+}
+
+// compareSlices returns the digests of slices src and dst, and
+// ErrUpgradeMismatch if their latest committed snapshots differ.
+func compareSlices(src, dst string) (*SliceDigest, *SliceDigest, error) {
+
+	srcDigest, err := GetSliceDigest(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	dstDigest, err := GetSliceDigest(dst)
+	if err != nil {
+		return srcDigest, nil, err
+	}
+
+	if srcDigest.Count != dstDigest.Count || srcDigest.Checksum != dstDigest.Checksum ||
+		!srcDigest.Ts.Equal(dstDigest.Ts) {
+		logging.Errorf("UpgradeSlice: %v does not match %v. Count %v/%v Checksum %v/%v",
+			dst, src, dstDigest.Count, srcDigest.Count, dstDigest.Checksum, srcDigest.Checksum)
+		return srcDigest, dstDigest, ErrUpgradeMismatch
+	}
+
+	return srcDigest, dstDigest, nil
+}
+
+// GetSliceDigest returns the digest of the latest committed snapshot of
+// the forestdb or memdb slice at path.
+func GetSliceDigest(path string) (*SliceDigest, error) {
+
+	digest := &SliceDigest{Path: path}
+	table := crc64.MakeTable(crc64.ISO)
+	callb := func(key, value []byte) error {
+		digest.Count++
+		digest.Checksum = crc64.Update(digest.Checksum, table, key)
+		return nil
+	}
+
+	if _, err := os.Stat(newFdbFile(path, false)); err == nil {
+		info, err := iterateFdbSlice(path, callb)
+		if err != nil {
+			return nil, err
+		}
+		digest.Ts = info.Ts
+		return digest, nil
+	}
+
+	ts, err := iterateMemdbSlice(path, callb)
+	if err != nil {
+		return nil, err
+	}
+	digest.Ts = ts
+	return digest, nil
+}
+
+// iterateFdbSlice calls callb with the main index entries of the latest
+// committed snapshot of the forestdb slice at path, in index order.
+func iterateFdbSlice(path string, callb func(key, value []byte) error) (*fdbSnapshotInfo, error) {
+
+	config := forestdb.DefaultConfig()
+	config.SetOpenFlags(forestdb.OPEN_FLAG_RDONLY)
+	dbfile, err := forestdb.Open(newFdbFile(path, false), config)
+	if err != nil {
+		return nil, err
+	}
+	defer dbfile.Close()
+
+	kvconfig := forestdb.DefaultKVStoreConfig()
+	meta, err := dbfile.OpenKVStore("default", kvconfig)
+	if err != nil {
+		return nil, err
+	}
+	data, err := meta.GetKV(snapshotMetaListKey)
+	meta.Close()
+	if err == forestdb.FDB_RESULT_KEY_NOT_FOUND {
+		return nil, ErrUpgradeNoSnapshot
+	} else if err != nil {
+		return nil, err
+	}
+
+	var infos []*fdbSnapshotInfo
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve snapshots list - %v", err)
+	}
+
+	// snapshots list is in the order of newest first
+	var info *fdbSnapshotInfo
+	for _, i := range infos {
+		if i.Committed {
+			info = i
+			break
+		}
+	}
+	if info == nil {
+		return nil, ErrUpgradeNoSnapshot
+	}
+
+	main, err := dbfile.OpenKVStore("main", kvconfig)
+	if err != nil {
+		return nil, err
+	}
+	defer main.Close()
+
+	snap, err := main.SnapshotOpen(info.MainSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	it, err := snap.IteratorInit([]byte{}, nil, forestdb.ITR_NONE|forestdb.ITR_NO_DELETES)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	// the iterator fails past the last entry, any other error is
+	// a failure to read the slice.
+	for {
+		doc, err := it.Get()
+		if err == forestdb.FDB_RESULT_ITERATOR_FAIL {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		err = callb(doc.Key(), doc.Body())
+		doc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := it.Next(); err == forestdb.FDB_RESULT_ITERATOR_FAIL {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// iterateMemdbSlice calls callb with the entries of the latest disk
+// snapshot of the memdb slice at path, in index order.
+func iterateMemdbSlice(path string, callb func(key, value []byte) error) (*common.TsVbuuid, error) {
+
+	manifests, _ := filepath.Glob(filepath.Join(path, "*", "manifest.json"))
+	var latest string
+	for _, m := range manifests {
+		if filepath.Base(filepath.Dir(m)) != tmpDirName && m > latest {
+			latest = m
+		}
+	}
+	if latest == "" {
+		return nil, ErrUpgradeSliceUnknown
+	}
+
+	bs, err := ioutil.ReadFile(latest)
+	if err != nil {
+		return nil, err
+	}
+	info := &memdbSnapshotInfo{}
+	if err := json.Unmarshal(bs, info); err != nil {
+		return nil, err
+	}
+
+	cfg := memdb.DefaultConfig()
+	cfg.SetKeyComparator(byteItemCompare)
+	store := memdb.NewWithConfig(cfg)
+	defer store.Close()
+
+	snap, err := store.LoadFromDisk(filepath.Dir(latest), 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	it := store.NewIterator(snap)
+	if it == nil {
+		return nil, memdb.ErrShutdown
+	}
+	defer it.Close()
+
+	for it.SeekFirst(); it.Valid(); it.Next() {
+		if err := callb(it.Get(), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return info.Ts, nil
+}
@@ -0,0 +1,165 @@
+package indexer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// newUpgradeTestSlice writes n documents to a forestdb slice at path,
+// and commits a snapshot of them.
+func newUpgradeTestSlice(t *testing.T, path string, defn common.IndexDefn, n int) {
+
+	stats := &IndexStats{}
+	stats.Init()
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	slice, err := NewForestDBSlice(path, SliceId(0), defn, common.IndexInstId(1),
+		common.PartitionId(0), defn.IsPrimary, 1, cfg, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	for i := 0; i < n; i++ {
+		var key []byte
+		if !defn.IsPrimary {
+			value := fmt.Sprintf(`["name-%03d"]`, i%10)
+			if defn.IsArrayIndex {
+				value = fmt.Sprintf(`[["tag-%d","tag-%d"]]`, i%10, i%7)
+			}
+			if key, err = jsonEncoder.Encode([]byte(value), make([]byte, 0, 1024)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		meta := NewMutationMeta()
+		meta.vbucket = Vbucket(i % 4)
+		if err := slice.Insert(key, []byte(fmt.Sprintf("doc-%03d", i)), meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := common.NewTsVbuuid("default", 4)
+	for vb := range ts.Seqnos {
+		ts.Seqnos[vb], ts.Vbuuids[vb] = uint64(n), 1234
+	}
+	if _, err := slice.NewSnapshot(ts, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkSliceDigest(t *testing.T, path string, expected *SliceDigest) {
+	digest, err := GetSliceDigest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Count != expected.Count || digest.Checksum != expected.Checksum ||
+		!digest.Ts.Equal(expected.Ts) {
+		t.Fatalf("Expected slice %v to match %+v, got %+v", path, expected, digest)
+	}
+}
+
+func TestUpgradeSliceForestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sliceupgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "slice")
+
+	defn := common.IndexDefn{DefnId: 1, Name: "idx", Bucket: "default",
+		SecExprs: []string{"`name`"}, Using: common.ForestDB}
+	newUpgradeTestSlice(t, path, defn, 100)
+	orig, err := GetSliceDigest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orig.Count != 100 {
+		t.Fatalf("Expected 100 entries, got %v", orig.Count)
+	}
+
+	if err := UpgradeSlice(path, common.FORESTDB, true); err != nil {
+		t.Fatal(err)
+	}
+	checkSliceDigest(t, path, orig)
+	if _, _, err := VerifySliceUpgrade(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpgradeSlice(path, common.FORESTDB, true); err != ErrUpgradePending {
+		t.Fatalf("Expected %v, got %v", ErrUpgradePending, err)
+	}
+
+	// rollback restores the original slice.
+	if err := RollbackSliceUpgrade(path); err != nil {
+		t.Fatal(err)
+	}
+	checkSliceDigest(t, path, orig)
+	if _, err := os.Stat(path + sliceOrigSuffix); !os.IsNotExist(err) {
+		t.Fatalf("Expected original slice to be restored, got %v", err)
+	}
+	if err := RollbackSliceUpgrade(path); err != ErrUpgradeNotFound {
+		t.Fatalf("Expected %v, got %v", ErrUpgradeNotFound, err)
+	}
+
+	// commit removes the original slice.
+	if err := UpgradeSlice(path, common.FORESTDB, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitSliceUpgrade(path); err != nil {
+		t.Fatal(err)
+	}
+	checkSliceDigest(t, path, orig)
+	if _, err := os.Stat(path + sliceOrigSuffix); !os.IsNotExist(err) {
+		t.Fatalf("Expected original slice to be removed, got %v", err)
+	}
+	if err := CommitSliceUpgrade(path); err != ErrUpgradeNotFound {
+		t.Fatalf("Expected %v, got %v", ErrUpgradeNotFound, err)
+	}
+}
+
+func TestUpgradeSliceMemDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sliceupgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defns := []common.IndexDefn{
+		{DefnId: 1, Name: "#primary", Bucket: "default", IsPrimary: true,
+			Using: common.ForestDB},
+		{DefnId: 2, Name: "idx", Bucket: "default", SecExprs: []string{"`name`"},
+			Using: common.ForestDB},
+		{DefnId: 3, Name: "arr", Bucket: "default", SecExprs: []string{"DISTINCT ARRAY t FOR t IN `tags` END"},
+			IsArrayIndex: true, Using: common.ForestDB},
+	}
+	for _, defn := range defns {
+		path := filepath.Join(dir, defn.Name)
+		newUpgradeTestSlice(t, path, defn, 100)
+		orig, err := GetSliceDigest(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orig.Count < 100 {
+			t.Fatalf("Expected at least 100 entries in %v, got %v", defn.Name, orig.Count)
+		}
+
+		if err := UpgradeSlice(path, common.MOI, true); err != nil {
+			t.Fatalf("Upgrade of %v failed: %v", defn.Name, err)
+		}
+		if _, err := os.Stat(newFdbFile(path, false)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %v to be a memdb slice, got %v", defn.Name, err)
+		}
+		checkSliceDigest(t, path, orig)
+
+		if err := RollbackSliceUpgrade(path); err != nil {
+			t.Fatal(err)
+		}
+		checkSliceDigest(t, path, orig)
+		if _, err := os.Stat(newFdbFile(path, false)); err != nil {
+			t.Fatalf("Expected %v to be a forestdb slice, got %v", defn.Name, err)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager"
+)
+
+//
+// Snapshot shipping between replicas.
+//
+// When an index is moved or a replica is repaired during rebalance, the
+// destination builds the index from scratch off the stream. With
+// indexer.rebalance.ship_snapshot, the destination instead copies the
+// latest persisted snapshot of a peer replica over /shipSnapshot, and
+// the build stream is opened from the timestamp of that snapshot, to
+// catch up with mutations since.
+//
+// Only forestdb slices are shipped. A forestdb file is append-only and
+// recovers from its last valid header on open, hence a prefix of the
+// file, copied while the slice is being written, is a consistent copy
+// of the snapshots committed before the copy began.
+//
+
+var ErrSnapshotNotShippable = errors.New("Snapshot cannot be shipped")
+
+// marker left in the slice directory of a shipped snapshot, consumed
+// when the index is built.
+const snapshotShippedMarker = "snapshot.shipped"
+
+const snapshotFileHeader = "X-Snapshot-File"
+
+// snapshot file is shipped in chunks of shipChunkSize, and shipping is
+// aborted if the slice starts compacting in between.
+const shipChunkSize = 4 * 1024 * 1024
+
+// handleShipSnapshotReq streams the forestdb file of the partition given
+// by instId and partnId.
+func (s *scanCoordinator) handleShipSnapshotReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	instId, err := strconv.ParseUint(r.FormValue("instId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid instId " + r.FormValue("instId") + "\n"))
+		return
+	}
+	partnId, err := strconv.ParseUint(r.FormValue("partnId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid partnId " + r.FormValue("partnId") + "\n"))
+		return
+	}
+
+	s.mu.RLock()
+	inst, ok := s.indexInstMap[common.IndexInstId(instId)]
+	partnInst, ok1 := s.indexPartnMap[common.IndexInstId(instId)][common.PartitionId(partnId)]
+	s.mu.RUnlock()
+
+	if !ok || !ok1 || inst.State != common.INDEX_STATE_ACTIVE {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(common.ErrIndexNotFound.Error() + "\n"))
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].data.docs!read", inst.Defn.Bucket)
+	if !common.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	slice, ok := partnInst.Sc.GetSliceById(0).(*fdbSlice)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(ErrSnapshotNotShippable.Error() + "\n"))
+		return
+	}
+
+	slice.IncrRef()
+	defer slice.DecrRef()
+
+	// the current file is replaced on compaction, ship only while the
+	// slice is not being compacted.
+	slice.lock.RLock()
+	filename := slice.currfile
+	slice.lock.RUnlock()
+
+	compacting := func() bool {
+		slice.lock.RLock()
+		defer slice.lock.RUnlock()
+		return slice.isCompacting || slice.currfile != filename
+	}
+
+	if compacting() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(ErrSnapshotNotShippable.Error() + ", index is being compacted\n"))
+		return
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	size := info.Size()
+
+	logging.Infof("ScanCoordinator: Shipping snapshot of index %v partition %v, %v (%v bytes)",
+		instId, partnId, filename, size)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set(snapshotFileHeader, filepath.Base(filename))
+	w.WriteHeader(200)
+	if err := copySnapshot(w, f, size, compacting); err != nil {
+		logging.Errorf("ScanCoordinator: Error shipping snapshot of index %v partition %v: %v",
+			instId, partnId, err)
+	}
+}
+
+// copySnapshot copies size bytes of the snapshot file from r to w,
+// chunk by chunk. Fails if compacting returns true before a chunk is
+// copied, the receiver then sees a short snapshot.
+func copySnapshot(w io.Writer, r io.Reader, size int64, compacting func() bool) error {
+
+	for size > 0 {
+		if compacting() {
+			return fmt.Errorf("%v, index is being compacted", ErrSnapshotNotShippable)
+		}
+		n := int64(shipChunkSize)
+		if n > size {
+			n = size
+		}
+		if _, err := io.CopyN(w, r, n); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+// shipSnapshot bootstraps the index of the transfer token from the
+// latest persisted snapshot of a peer replica. Returns false if the
+// snapshot is not shipped, in which case the index is built from
+// scratch.
+func (r *Rebalancer) shipSnapshot(tt *common.TransferToken) bool {
+
+	cfg := r.config.Load()
+	if !cfg["rebalance.ship_snapshot"].Bool() || common.GetStorageMode() != common.FORESTDB ||
+		tt.RealInstId != 0 {
+		return false
+	}
+
+	peerId, peerInstId, err := r.findSnapshotPeer(tt)
+	if err != nil {
+		logging.Infof("Rebalancer::shipSnapshot No peer to ship snapshot of %v from: %v", tt.InstId, err)
+		return false
+	}
+
+	addr, err := getIndexerAddrByNodeId(cfg["clusterAddr"].String(), peerId)
+	if err != nil {
+		logging.Errorf("Rebalancer::shipSnapshot Error finding peer %v: %v", peerId, err)
+		return false
+	}
+
+	inst := tt.IndexInst
+	inst.InstId = tt.InstId
+	partns := tt.IndexInst.Defn.Partitions
+	if len(partns) == 0 {
+		partns = []common.PartitionId{common.NON_PARTITION_ID}
+	}
+
+	var paths []string
+	for _, partnId := range partns {
+		path := SlicePath(cfg, &inst, partnId, SliceId(0))
+		if err := fetchSnapshot(addr, peerInstId, partnId, path); err != nil {
+			logging.Errorf("Rebalancer::shipSnapshot Error shipping snapshot of %v partition %v from %v: %v",
+				tt.InstId, partnId, addr, err)
+			for _, path := range paths {
+				os.RemoveAll(path)
+			}
+			return false
+		}
+		paths = append(paths, path)
+	}
+
+	logging.Infof("Rebalancer::shipSnapshot Shipped snapshot of %v partitions %v from %v instance %v",
+		tt.InstId, partns, addr, peerInstId)
+	return true
+}
+
+// findSnapshotPeer returns the node and instance of an active replica
+// hosting all partitions of the transfer token, the source node of the
+// token is preferred.
+func (r *Rebalancer) findSnapshotPeer(tt *common.TransferToken) (string, common.IndexInstId, error) {
+
+	topology, err := getGlobalTopology(r.localaddr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	hasPartns := func(inst *manager.IndexInstDistribution) bool {
+		for _, partnId := range tt.IndexInst.Defn.Partitions {
+			found := false
+			for _, partn := range inst.Partitions {
+				if partn.PartId == uint64(partnId) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	var peerId string
+	var peerInstId common.IndexInstId
+	for _, meta := range topology.Metadata {
+		if meta.NodeUUID == r.nodeId {
+			continue
+		}
+		t := findTopologyByBucket(meta.IndexTopologies, tt.IndexInst.Defn.Bucket)
+		if t == nil {
+			continue
+		}
+		defn := t.FindIndexDefinitionById(tt.IndexInst.Defn.DefnId)
+		if defn == nil {
+			continue
+		}
+		for i := range defn.Instances {
+			inst := &defn.Instances[i]
+			if common.IndexState(inst.State) != common.INDEX_STATE_ACTIVE ||
+				common.RebalanceState(inst.RState) != common.REBAL_ACTIVE ||
+				inst.RealInstId != 0 || !hasPartns(inst) {
+				continue
+			}
+			if len(peerId) == 0 || meta.NodeUUID == tt.SourceId {
+				peerId, peerInstId = meta.NodeUUID, common.IndexInstId(inst.InstId)
+			}
+		}
+	}
+
+	if len(peerId) == 0 {
+		return "", 0, common.ErrIndexNotFound
+	}
+	return peerId, peerInstId, nil
+}
+
+// fetchSnapshot copies the forestdb file of a partition from the indexer
+// at addr into the slice directory path, and marks it as shipped.
+func fetchSnapshot(addr string, instId common.IndexInstId, partnId common.PartitionId, path string) error {
+
+	url := fmt.Sprintf("%v/shipSnapshot?instId=%v&partnId=%v", addr, instId, partnId)
+	if !strings.HasPrefix(url, "http://") {
+		url = "http://" + url
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if err := cbauth.SetRequestAuthVia(req, nil); err != nil {
+		logging.Errorf("Rebalancer::fetchSnapshot Error setting auth %v", err)
+	}
+
+	// no timeout, the transfer takes as long as the size of the index.
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%v %v", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	filename := filepath.Base(resp.Header.Get(snapshotFileHeader))
+	if !strings.HasPrefix(filename, "data.fdb.") {
+		return fmt.Errorf("invalid snapshot file %v", filename)
+	}
+
+	// copy into a temporary directory, which replaces the slice
+	// directory once complete.
+	tmpPath := path + ".shipping"
+	os.RemoveAll(tmpPath)
+	if err := os.MkdirAll(tmpPath, 0777); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(tmpPath, filename))
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = fmt.Errorf("short snapshot, %v of %v bytes", n, resp.ContentLength)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(tmpPath, snapshotShippedMarker), []byte(addr), 0644)
+	}
+	if err == nil {
+		os.RemoveAll(path)
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.RemoveAll(tmpPath)
+	}
+	return err
+}
+
+// getIndexerAddrByNodeId returns the http address of the indexer with
+// node uuid nodeId.
+func getIndexerAddrByNodeId(clusterURL string, nodeId string) (string, error) {
+
+	cinfo, err := common.FetchNewClusterInfoCache(clusterURL, common.DEFAULT_POOL)
+	if err != nil {
+		return "", err
+	}
+
+	url := "/nodeuuid"
+	for _, nid := range cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE) {
+		addr, err := cinfo.GetServiceAddress(nid, common.INDEX_HTTP_SERVICE)
+		if err != nil {
+			continue
+		}
+		resp, err := getWithAuth(addr + url)
+		if err != nil {
+			continue
+		}
+		bytes, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(bytes) == nodeId {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to find indexer node %v", nodeId)
+}
+
+// shippedSnapshotRestartTs returns the timestamp to open the build
+// stream from, when all partitions of the indexes being built were
+// bootstrapped from shipped snapshots. The stream then only catches up
+// from the oldest of the snapshots. Returns nil if the indexes are to
+// be built from scratch, in which case shipped snapshots, if any, are
+// discarded.
+func (idx *indexer) shippedSnapshotRestartTs(instIdList []common.IndexInstId) *common.TsVbuuid {
+
+	var restartTs *common.TsVbuuid
+	var shipped []Slice
+	all := true
+
+	for _, instId := range instIdList {
+		inst := idx.indexInstMap[instId]
+		for partnId, partnInst := range idx.indexPartnMap[instId] {
+			path := SlicePath(idx.config, &inst, partnId, SliceId(0))
+			marker := filepath.Join(path, snapshotShippedMarker)
+			if _, err := os.Stat(marker); err != nil {
+				all = false
+				continue
+			}
+			os.Remove(marker)
+
+			slice := partnInst.Sc.GetSliceById(0)
+			shipped = append(shipped, slice)
+
+			infos, err := slice.GetSnapshots()
+			if err != nil {
+				logging.Errorf("Indexer::shippedSnapshotRestartTs Error reading snapshots of %v "+
+					"partition %v: %v", instId, partnId, err)
+				all = false
+				continue
+			}
+			latest := NewSnapshotInfoContainer(infos).GetLatest()
+			if latest == nil {
+				all = false
+				continue
+			}
+			if ts := latest.Timestamp(); restartTs == nil || !ts.AsRecent(restartTs) {
+				restartTs = ts
+			}
+		}
+	}
+
+	if len(shipped) == 0 {
+		return nil
+	}
+
+	if !all || restartTs == nil {
+		logging.Infof("Indexer::shippedSnapshotRestartTs Discarding shipped snapshots of %v, "+
+			"building from scratch", instIdList)
+		for _, slice := range shipped {
+			if err := slice.RollbackToZero(); err != nil {
+				common.CrashOnError(err)
+			}
+		}
+		return nil
+	}
+
+	logging.Infof("Indexer::shippedSnapshotRestartTs Building %v from shipped snapshots", instIdList)
+	return restartTs
+}
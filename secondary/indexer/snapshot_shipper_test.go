@@ -0,0 +1,119 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCopySnapshot(t *testing.T) {
+	data := bytes.Repeat([]byte("snapshot"), (2*shipChunkSize+100)/8)
+
+	var buf bytes.Buffer
+	checks := 0
+	err := copySnapshot(&buf, bytes.NewReader(data), int64(len(data)), func() bool {
+		checks++
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expected %v bytes, got %v", len(data), buf.Len())
+	}
+	// compaction is checked before every chunk.
+	if checks != 3 {
+		t.Errorf("expected 3 checks, got %v", checks)
+	}
+
+	// compaction starting after the first chunk aborts shipping.
+	buf.Reset()
+	checks = 0
+	err = copySnapshot(&buf, bytes.NewReader(data), int64(len(data)), func() bool {
+		checks++
+		return checks > 1
+	})
+	if err == nil || !strings.Contains(err.Error(), ErrSnapshotNotShippable.Error()) {
+		t.Errorf("expected %v, got %v", ErrSnapshotNotShippable, err)
+	} else if buf.Len() != shipChunkSize {
+		t.Errorf("expected %v bytes, got %v", shipChunkSize, buf.Len())
+	}
+}
+
+func TestFetchSnapshot(t *testing.T) {
+	data := []byte("forestdb file")
+	filename, short := "data.fdb.3", false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shipSnapshot" || r.FormValue("instId") != "100" ||
+			r.FormValue("partnId") != "2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(snapshotFileHeader, filename)
+		if short {
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(200)
+			w.Write(data)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "shipsnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "default_idx_100_2.index")
+
+	if err := fetchSnapshot(server.URL, 100, 2, path); err != nil {
+		t.Fatal(err)
+	}
+	if shipped, err := ioutil.ReadFile(filepath.Join(path, filename)); err != nil ||
+		!bytes.Equal(shipped, data) {
+		t.Errorf("unexpected snapshot %q %v", shipped, err)
+	}
+	if _, err := os.Stat(filepath.Join(path, snapshotShippedMarker)); err != nil {
+		t.Errorf("expected shipped marker, got %v", err)
+	}
+
+	// failures leave neither the slice directory nor the temporary copy.
+	for _, tc := range []struct {
+		name     string
+		filename string
+		short    bool
+		partnId  uint64
+	}{
+		{"not found", "data.fdb.3", false, 3},
+		{"invalid file", "../meta", false, 2},
+		{"short", "data.fdb.3", true, 2},
+	} {
+		os.RemoveAll(path)
+		filename, short = tc.filename, tc.short
+		if err := fetchSnapshot(server.URL, 100, common.PartitionId(tc.partnId), path); err == nil {
+			t.Errorf("%v: expected error", tc.name)
+		}
+		if _, err := os.Stat(path + ".shipping"); !os.IsNotExist(err) {
+			t.Errorf("%v: expected temporary copy to be removed", tc.name)
+		}
+		if _, err := os.Stat(filepath.Join(path, snapshotShippedMarker)); !os.IsNotExist(err) {
+			t.Errorf("%v: expected no shipped marker", tc.name)
+		}
+	}
+}
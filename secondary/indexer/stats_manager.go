@@ -10,10 +10,12 @@
 package indexer
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -42,6 +44,9 @@ type BucketStats struct {
 	numRollbacks       stats.Int64Val
 	mutationQueueSize  stats.Int64Val
 	numMutationsQueued stats.Int64Val
+	numBytesQueued     stats.Int64Val
+
+	numMutationsCoalesced stats.Int64Val
 
 	tsQueueSize   stats.Int64Val
 	numNonAlignTS stats.Int64Val
@@ -51,10 +56,82 @@ func (s *BucketStats) Init() {
 	s.numRollbacks.Init()
 	s.mutationQueueSize.Init()
 	s.numMutationsQueued.Init()
+	s.numBytesQueued.Init()
+	s.numMutationsCoalesced.Init()
 	s.tsQueueSize.Init()
 	s.numNonAlignTS.Init()
 }
 
+// BucketDDLStats counts the DDL processed for a bucket. Unlike
+// BucketStats, it is retained after the last index of the bucket is
+// dropped.
+type BucketDDLStats struct {
+	numCreateIndex stats.Int64Val
+	numBuildIndex  stats.Int64Val
+	numDropIndex   stats.Int64Val
+}
+
+func (s *BucketDDLStats) Init() {
+	s.numCreateIndex.Init()
+	s.numBuildIndex.Init()
+	s.numDropIndex.Init()
+}
+
+// BucketIndexStats is the sum of the stats of all indexes on a bucket.
+// It is used to attribute indexer load to the bucket generating it.
+type BucketIndexStats struct {
+	numRequests     int64
+	numRowsReturned int64
+	numRowsScanned  int64
+	scanDuration    int64
+	numDocsIndexed  int64
+	avgScanRate     int64
+	avgMutationRate int64
+	diskSize        int64
+	dataSize        int64
+	memUsed         int64
+	itemsCount      int64
+}
+
+func (b *BucketIndexStats) add(s *IndexStats) {
+	b.numRequests += s.numRequests.Value()
+	b.numRowsReturned += s.numRowsReturned.Value()
+	b.numRowsScanned += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.numRowsScanned.Value()
+	})
+	b.scanDuration += s.int64Stats(func(ss *IndexStats) int64 {
+		return ss.scanDuration.Value()
+	})
+	b.numDocsIndexed += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.numDocsIndexed.Value()
+	})
+	b.avgScanRate += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.avgScanRate.Value()
+	})
+	b.avgMutationRate += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.avgMutationRate.Value()
+	})
+	b.diskSize += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.diskSize.Value()
+	})
+	b.dataSize += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.dataSize.Value()
+	})
+	b.memUsed += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.memUsed.Value()
+	})
+	b.itemsCount += s.partnInt64Stats(func(ss *IndexStats) int64 {
+		return ss.itemsCount.Value()
+	})
+}
+
+func (b *BucketIndexStats) avgScanLatency() int64 {
+	if b.numRequests == 0 {
+		return 0
+	}
+	return b.scanDuration / b.numRequests
+}
+
 type IndexTimingStats struct {
 	stCloneHandle           stats.TimingStat
 	stNewIterator           stats.TimingStat
@@ -99,6 +176,9 @@ type IndexStats struct {
 
 	partitions map[common.PartitionId]*IndexStats
 
+	// key statistics of the instance, shared by clones
+	keyStats *indexKeyStats
+
 	scanDuration              stats.Int64Val
 	scanReqDuration           stats.Int64Val
 	scanReqInitDuration       stats.Int64Val
@@ -106,6 +186,7 @@ type IndexStats struct {
 	dcpSeqsDuration           stats.Int64Val
 	insertBytes               stats.Int64Val
 	numDocsPending            stats.Int64Val
+	ingestLag                 stats.Int64Val
 	scanWaitDuration          stats.Int64Val
 	numDocsIndexed            stats.Int64Val
 	numDocsProcessed          stats.Int64Val
@@ -128,6 +209,13 @@ type IndexStats struct {
 	buildProgress             stats.Int64Val
 	completionProgress        stats.Int64Val
 	numDocsQueued             stats.Int64Val
+	memUsedQueue              stats.Int64Val
+	numQueueThrottled         stats.Int64Val
+	queueThrottleTime         stats.Int64Val
+	numVerifyChecked          stats.Int64Val
+	numVerifyMissing          stats.Int64Val
+	numVerifyExtra            stats.Int64Val
+	numVerifyWrong            stats.Int64Val
 	deleteBytes               stats.Int64Val
 	dataSize                  stats.Int64Val
 	scanBytesRead             stats.Int64Val
@@ -192,6 +280,7 @@ func (s *IndexStats) Init() {
 	s.scanReqAllocDuration.Init()
 	s.insertBytes.Init()
 	s.numDocsPending.Init()
+	s.ingestLag.Init()
 	s.scanWaitDuration.Init()
 	s.numDocsIndexed.Init()
 	s.numDocsProcessed.Init()
@@ -214,6 +303,13 @@ func (s *IndexStats) Init() {
 	s.buildProgress.Init()
 	s.completionProgress.Init()
 	s.numDocsQueued.Init()
+	s.memUsedQueue.Init()
+	s.numQueueThrottled.Init()
+	s.queueThrottleTime.Init()
+	s.numVerifyChecked.Init()
+	s.numVerifyMissing.Init()
+	s.numVerifyExtra.Init()
+	s.numVerifyWrong.Init()
 	s.deleteBytes.Init()
 	s.dataSize.Init()
 	s.fragPercent.Init()
@@ -366,8 +462,9 @@ func (s *IndexStats) partnTimingStats(f func(*IndexStats) *stats.TimingStat) str
 }
 
 type IndexerStats struct {
-	indexes map[common.IndexInstId]*IndexStats
-	buckets map[string]*BucketStats
+	indexes   map[common.IndexInstId]*IndexStats
+	buckets   map[string]*BucketStats
+	bucketDDL map[string]*BucketDDLStats
 
 	numConnections     stats.Int64Val
 	memoryQuota        stats.Int64Val
@@ -378,6 +475,7 @@ type IndexerStats struct {
 	needsRestart       stats.BoolVal
 	statsResponse      stats.TimingStat
 	notFoundError      stats.Int64Val
+	numCorruptFrames   stats.Int64Val
 
 	indexerState stats.Int64Val
 }
@@ -385,6 +483,7 @@ type IndexerStats struct {
 func (s *IndexerStats) Init() {
 	s.indexes = make(map[common.IndexInstId]*IndexStats)
 	s.buckets = make(map[string]*BucketStats)
+	s.bucketDDL = make(map[string]*BucketDDLStats)
 	s.numConnections.Init()
 	s.memoryQuota.Init()
 	s.memoryUsed.Init()
@@ -395,6 +494,7 @@ func (s *IndexerStats) Init() {
 	s.statsResponse.Init()
 	s.indexerState.Init()
 	s.notFoundError.Init()
+	s.numCorruptFrames.Init()
 }
 
 func (s *IndexerStats) Reset() {
@@ -418,12 +518,25 @@ func (s *IndexerStats) AddIndex(id common.IndexInstId, bucket string, name strin
 	if _, ok := s.indexes[id]; !ok {
 		idxStats := &IndexStats{name: name, bucket: bucket, replicaId: replicaId}
 		idxStats.Init()
+		idxStats.keyStats = newIndexKeyStats()
 		s.indexes[id] = idxStats
 
 		b.indexCount++
 	}
 }
 
+// GetBucketDDLStats returns the DDL stats of bucket, creating them on
+// first use.
+func (s *IndexerStats) GetBucketDDLStats(bucket string) *BucketDDLStats {
+	b, ok := s.bucketDDL[bucket]
+	if !ok {
+		b = &BucketDDLStats{}
+		b.Init()
+		s.bucketDDL[bucket] = b
+	}
+	return b
+}
+
 func (s *IndexerStats) AddPartition(id common.IndexInstId, bucket string, name string, replicaId int, partitionId common.PartitionId) {
 
 	if _, ok := s.indexes[id]; !ok {
@@ -456,6 +569,20 @@ func (s *IndexerStats) RemovePartitionStats(id common.IndexInstId, partnId commo
 	}
 }
 
+// GetBucketIndexStats aggregates the index stats by bucket.
+func (s *IndexerStats) GetBucketIndexStats() map[string]*BucketIndexStats {
+	result := make(map[string]*BucketIndexStats)
+	for _, is := range s.indexes {
+		b, ok := result[is.bucket]
+		if !ok {
+			b = &BucketIndexStats{}
+			result[is.bucket] = b
+		}
+		b.add(is)
+	}
+	return result
+}
+
 func (s *IndexerStats) RemoveIndex(id common.IndexInstId) {
 	idx, ok := s.indexes[id]
 	if !ok {
@@ -503,6 +630,13 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 	addStat("memory_total_storage", is.memoryTotalStorage.Value())
 	addStat("memory_used_queue", is.memoryUsedQueue.Value())
 	addStat("needs_restart", is.needsRestart.Value())
+	addStat("memory_pressure", gMemPressure.getLevel().String())
+	addStat("num_scans_rejected_memory", atomic.LoadInt64(&gMemPressure.numScansRejected))
+	addStat("num_stream_throttled_memory", atomic.LoadInt64(&gMemPressure.numThrottled))
+	addStat("avg_commit_latency", gCommitThrottle.getAvgLatency())
+	addStat("commit_throttled", gCommitThrottle.isThrottled())
+	addStat("num_stream_throttled_commit", atomic.LoadInt64(&gCommitThrottle.numThrottled))
+	addStat("stream_throttle_time_commit", atomic.LoadInt64(&gCommitThrottle.throttleTime))
 	storageMode := fmt.Sprintf("%s", common.GetStorageMode())
 	addStat("storage_mode", storageMode)
 	addStat("num_cpu_core", num_cpu_core)
@@ -516,6 +650,12 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 		indexerState = common.INDEXER_PAUSED
 	}
 	addStat("indexer_state", fmt.Sprintf("%s", indexerState))
+	addStat("num_corrupt_frames", is.numCorruptFrames.Value())
+
+	readCacheUsed, readCacheHits, readCacheMisses := fdbReadCacheStats()
+	addStat("fdb_read_cache_used", readCacheUsed)
+	addStat("fdb_read_cache_hits", readCacheHits)
+	addStat("fdb_read_cache_misses", readCacheMisses)
 
 	addStat("timings/stats_response", is.statsResponse.Value())
 
@@ -554,6 +694,10 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 			s.int64Stats(func(ss *IndexStats) int64 {
 				return ss.numDocsPending.Value()
 			}))
+		addStat("ingest_lag",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.ingestLag.Value()
+			}))
 		addStat("scan_wait_duration",
 			s.int64Stats(func(ss *IndexStats) int64 {
 				return ss.scanWaitDuration.Value()
@@ -696,6 +840,34 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 			s.partnInt64Stats(func(ss *IndexStats) int64 {
 				return postiveNum(ss.numDocsFlushQueued.Value() - ss.numDocsIndexed.Value())
 			}))
+		addStat("queue_memory_used",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.memUsedQueue.Value()
+			}))
+		addStat("num_queue_throttled",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.numQueueThrottled.Value()
+			}))
+		addStat("queue_throttle_time",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.queueThrottleTime.Value()
+			}))
+		addStat("verify_docs_checked",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.numVerifyChecked.Value()
+			}))
+		addStat("verify_docs_missing",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.numVerifyMissing.Value()
+			}))
+		addStat("verify_docs_extra",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.numVerifyExtra.Value()
+			}))
+		addStat("verify_docs_wrong",
+			s.int64Stats(func(ss *IndexStats) int64 {
+				return ss.numVerifyWrong.Value()
+			}))
 		// partition stats
 		addStat("num_items_flushed",
 			s.partnInt64Stats(func(ss *IndexStats) int64 {
@@ -885,21 +1057,105 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 		}
 	}
 
+	bucketIndexStats := is.GetBucketIndexStats()
 	for _, s := range is.buckets {
 		prefix = fmt.Sprintf("%s:", s.bucket)
 		addStat("num_rollbacks", s.numRollbacks.Value())
 		addStat("mutation_queue_size", s.mutationQueueSize.Value())
 		addStat("num_mutations_queued", s.numMutationsQueued.Value())
+		addStat("num_bytes_queued", s.numBytesQueued.Value())
+		addStat("num_mutations_coalesced", s.numMutationsCoalesced.Value())
 		addStat("ts_queue_size", s.tsQueueSize.Value())
 		addStat("num_nonalign_ts", s.numNonAlignTS.Value())
 		if st := common.BucketSeqsTiming(s.bucket); st != nil {
 			addStat("timings/dcp_getseqs", st.Value())
 		}
+
+		if bs, ok := bucketIndexStats[s.bucket]; ok {
+			addStat("bucket_num_requests", bs.numRequests)
+			addStat("bucket_num_rows_returned", bs.numRowsReturned)
+			addStat("bucket_num_rows_scanned", bs.numRowsScanned)
+			addStat("bucket_avg_scan_latency", bs.avgScanLatency())
+			addStat("bucket_avg_scan_rate", bs.avgScanRate)
+			addStat("bucket_num_docs_indexed", bs.numDocsIndexed)
+			addStat("bucket_avg_mutation_rate", bs.avgMutationRate)
+			addStat("bucket_disk_size", bs.diskSize)
+			addStat("bucket_data_size", bs.dataSize)
+			addStat("bucket_memory_used", bs.memUsed)
+			addStat("bucket_items_count", bs.itemsCount)
+		}
+	}
+
+	for bucket, s := range is.bucketDDL {
+		prefix = fmt.Sprintf("%s:", bucket)
+		addStat("num_create_index", s.numCreateIndex.Value())
+		addStat("num_build_index", s.numBuildIndex.Value())
+		addStat("num_drop_index", s.numDropIndex.Value())
 	}
 
 	return statsMap
 }
 
+// PrometheusMetrics returns the per bucket stats in the prometheus text
+// exposition format.
+func (is IndexerStats) PrometheusMetrics() []byte {
+	type metric struct {
+		name, typ string
+		value     func(*BucketDDLStats, *BucketIndexStats) int64
+	}
+
+	metrics := []metric{
+		{"num_requests", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.numRequests }},
+		{"num_rows_returned", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.numRowsReturned }},
+		{"num_rows_scanned", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.numRowsScanned }},
+		{"avg_scan_latency", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.avgScanLatency() }},
+		{"avg_scan_rate", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.avgScanRate }},
+		{"num_docs_indexed", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.numDocsIndexed }},
+		{"avg_mutation_rate", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.avgMutationRate }},
+		{"disk_size", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.diskSize }},
+		{"data_size", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.dataSize }},
+		{"memory_used", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.memUsed }},
+		{"items_count", "gauge", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return bs.itemsCount }},
+		{"num_create_index", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return b.numCreateIndex.Value() }},
+		{"num_build_index", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return b.numBuildIndex.Value() }},
+		{"num_drop_index", "counter", func(b *BucketDDLStats, bs *BucketIndexStats) int64 { return b.numDropIndex.Value() }},
+	}
+
+	// DDL stats of a bucket are reported even after its last index
+	// is dropped.
+	buckets := make([]string, 0, len(is.bucketDDL))
+	for bucket := range is.bucketDDL {
+		buckets = append(buckets, bucket)
+	}
+	for bucket := range is.buckets {
+		if _, ok := is.bucketDDL[bucket]; !ok {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+
+	bucketIndexStats := is.GetBucketIndexStats()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		name := "index_bucket_" + m.name
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, m.typ)
+		for _, bucket := range buckets {
+			bs, ok := bucketIndexStats[bucket]
+			if !ok {
+				bs = &BucketIndexStats{}
+			}
+			ddl, ok := is.bucketDDL[bucket]
+			if !ok {
+				ddl = &BucketDDLStats{}
+			}
+			fmt.Fprintf(&buf, "%s{bucket=%q} %d\n", name, bucket, m.value(ddl, bs))
+		}
+	}
+
+	return buf.Bytes()
+}
+
 func (is IndexerStats) GetVersionedStats(t *target) (common.Statistics, bool) {
 	var key string
 	statsMap := make(map[string]interface{})
@@ -976,6 +1232,10 @@ func (s *IndexStats) constructIndexStats(skipEmpty bool, version string) common.
 		s.int64Stats(func(ss *IndexStats) int64 {
 			return ss.numDocsPending.Value()
 		}))
+	addStat("ingest_lag",
+		s.int64Stats(func(ss *IndexStats) int64 {
+			return ss.ingestLag.Value()
+		}))
 	// partition stats
 	addStat("num_docs_indexed",
 		s.partnInt64Stats(func(ss *IndexStats) int64 {
@@ -1082,6 +1342,10 @@ func (s IndexerStats) Clone() *IndexerStats {
 	for k, v := range s.buckets {
 		clone.buckets[k] = v
 	}
+	clone.bucketDDL = make(map[string]*BucketDDLStats)
+	for k, v := range s.bucketDDL {
+		clone.bucketDDL[k] = v
+	}
 
 	return &clone
 }
@@ -1119,6 +1383,7 @@ func NewStatsManager(supvCmdch MsgChannel,
 	http.HandleFunc("/stats/storage/mm", s.handleStorageMMStatsReq)
 	http.HandleFunc("/stats/storage", s.handleStorageStatsReq)
 	http.HandleFunc("/stats/reset", s.handleStatsResetReq)
+	http.HandleFunc("/_prometheusMetrics", s.handlePrometheusStatsReq)
 	go s.run()
 	go s.runStatsDumpLogger()
 	StartCpuCollector()
@@ -1302,6 +1567,32 @@ func (s *statsManager) handleStatsResetReq(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// handlePrometheusStatsReq exposes the per bucket stats in the prometheus
+// text exposition format. Every metric is labelled with its bucket.
+func (s *statsManager) handlePrometheusStatsReq(w http.ResponseWriter, r *http.Request) {
+	_, valid, _ := common.IsAuthValid(r)
+	if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized"))
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	stats := s.stats.Get()
+	if common.IndexerState(stats.indexerState.Value()) != common.INDEXER_BOOTSTRAP {
+		s.tryUpdateStats(false)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(200)
+	w.Write(stats.PrometheusMetrics())
+}
+
 func (s *statsManager) run() {
 loop:
 	for {
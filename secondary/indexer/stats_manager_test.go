@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestBucketDDLStatsAfterLastIndexDropped(t *testing.T) {
+	s := NewIndexerStats()
+	s.AddIndex(common.IndexInstId(1), "default", "idx1", 0)
+	s.GetBucketDDLStats("default").numCreateIndex.Add(1)
+	s.GetBucketDDLStats("default").numDropIndex.Add(1)
+	s.RemoveIndex(common.IndexInstId(1))
+
+	if _, ok := s.buckets["default"]; ok {
+		t.Fatalf("expected bucket stats to be removed with the last index")
+	}
+
+	stats := s.GetStats(false, false)
+	if v := stats["default:num_create_index"]; v != int64(1) {
+		t.Errorf("expected num_create_index 1, got %v", v)
+	}
+	if v := stats["default:num_drop_index"]; v != int64(1) {
+		t.Errorf("expected num_drop_index 1, got %v", v)
+	}
+
+	// counters keep adding up when the bucket gets an index again.
+	s.AddIndex(common.IndexInstId(2), "default", "idx2", 0)
+	s.GetBucketDDLStats("default").numCreateIndex.Add(1)
+	if v := s.Clone().GetBucketDDLStats("default").numCreateIndex.Value(); v != 2 {
+		t.Errorf("expected num_create_index 2, got %v", v)
+	}
+}
+
+func TestPrometheusMetricsBucketLabels(t *testing.T) {
+	s := NewIndexerStats()
+	s.AddIndex(common.IndexInstId(1), "beer", "idx1", 0)
+	s.GetBucketDDLStats("travel").numDropIndex.Add(3)
+
+	metrics := string(s.PrometheusMetrics())
+	for _, line := range []string{
+		"# TYPE index_bucket_num_drop_index counter",
+		`index_bucket_num_drop_index{bucket="travel"} 3`,
+		`index_bucket_num_drop_index{bucket="beer"} 0`,
+		`index_bucket_num_requests{bucket="beer"} 0`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, metrics)
+		}
+	}
+}
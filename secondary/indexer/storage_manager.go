@@ -17,6 +17,7 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/fdb"
 	"github.com/couchbase/indexing/secondary/logging"
+	"net/http"
 	"os"
 	"sort"
 	"sync"
@@ -58,6 +59,9 @@ type storageMgr struct {
 
 	stats IndexerStatsHolder
 
+	// buckets of key statistics histograms, 0 if disabled
+	keyStatsBuckets int
+
 	muSnap sync.Mutex //lock to protect snapMap and waitersMap
 }
 
@@ -131,6 +135,9 @@ func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 
 	s.updateIndexSnapMap(indexPartnMap, common.ALL_STREAMS, "")
 
+	s.keyStatsBuckets = config["settings.key_stats.histogram_buckets"].Int()
+	http.HandleFunc("/indexKeyStats", s.handleIndexKeyStatsReq)
+
 	//start Storage Manager loop which listens to commands from its supervisor
 	go s.run()
 
@@ -368,6 +375,7 @@ func (s *storageMgr) createSnapshotWorker(streamId common.StreamId, bucket strin
 							snapOpenDur := time.Since(snapOpenStart)
 
 							if needsCommit {
+								gCommitThrottle.record(snapCreateDur)
 								logging.Infof("StorageMgr::handleCreateSnapshot Added New Snapshot Index: %v "+
 									"PartitionId: %v SliceId: %v Crc64: %v (%v) SnapCreateDur %v SnapOpenDur %v", idxInstId, partnId, slice.Id(), tsVbuuid.Crc64, info, snapCreateDur, snapOpenDur)
 							}
@@ -775,6 +783,12 @@ func (s *storageMgr) handleUpdateIndexPartnMap(cmd Message) {
 	indexPartnMap := cmd.(*MsgUpdatePartnMap).GetIndexPartnMap()
 	s.indexPartnMap = CopyIndexPartnMap(indexPartnMap)
 
+	if s.keyStatsBuckets > 0 {
+		for instId, idxStats := range s.stats.Get().indexes {
+			idxStats.keyStats.prune(s.indexPartnMap[instId])
+		}
+	}
+
 	s.supvCmdch <- &MsgSuccess{}
 }
 
@@ -1184,6 +1198,7 @@ func (s *storageMgr) handleIndexCompaction(cmd Message) {
 	errch := req.GetErrorChannel()
 	abortTime := req.GetAbortTime()
 	minFrag := req.GetMinFrag()
+	targetDir := req.GetTargetDir()
 	var slices []Slice
 
 	inst, ok := s.indexInstMap[req.GetInstId()]
@@ -1208,17 +1223,40 @@ func (s *storageMgr) handleIndexCompaction(cmd Message) {
 		}
 	}
 
+	var ctx IndexReaderContext
+	if s.keyStatsBuckets > 0 && len(slices) != 0 {
+		ctx = slices[0].GetReaderContext()
+	}
+
 	// Perform file compaction without blocking storage manager main loop
 	go func() {
+		// Key statistics are rebuilt from the latest snapshot of the
+		// partition while its slices are being compacted.
+		var wg sync.WaitGroup
+		if ctx != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.updateKeyStats(inst, req.GetPartitionId(), ctx, idxStats.keyStats)
+			}()
+		}
+
 		for _, slice := range slices {
-			err := slice.Compact(abortTime, minFrag)
+			var err error
+			if targetDir != "" {
+				err = relocateSlice(slice, targetDir)
+			} else {
+				err = slice.Compact(abortTime, minFrag)
+			}
 			slice.DecrRef()
 			if err != nil {
+				wg.Wait()
 				errch <- err
 				return
 			}
 		}
 
+		wg.Wait()
 		errch <- nil
 	}()
 }
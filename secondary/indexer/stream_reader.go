@@ -47,7 +47,8 @@ type mutationStreamReader struct {
 
 	killch chan bool // kill chan for the main loop
 
-	stats IndexerStatsHolder
+	stats         IndexerStatsHolder
+	corruptFrames int64 //frames failing checksum, as of last ConnectionError
 
 	indexerState common.IndexerState
 	stateLock    sync.RWMutex
@@ -75,10 +76,17 @@ func CreateMutationStreamReader(streamId common.StreamId, bucketQueueMap BucketQ
 		"dataport.", true /*trim*/)
 
 	dpconf = overrideDataportConf(dpconf)
-	stream, err := dataport.NewServer(
+
+	//decode mutations into pooled buffers, to avoid garbage per mutation
+	var pool dataport.BufferPool
+	if dpconf["pooledDecode"].Bool() {
+		pool = dataport.NewSyncBufferPool(dpconf["maxPayload"].Int())
+	}
+
+	stream, err := dataport.NewServerWithPool(
 		string(StreamAddrMap[streamId]),
 		common.SystemConfig["maxVbuckets"].Int(),
-		dpconf, streamMutch)
+		dpconf, streamMutch, pool)
 	if err != nil {
 		//return stream init error
 		logging.Fatalf("MutationStreamReader: Error returned from NewServer."+
@@ -239,6 +247,12 @@ func (r *mutationStreamReader) handleStreamInfoMsg(msg interface{}) {
 		logging.Infof("MutationStreamReader::handleStreamInfoMsg \n\tReceived ConnectionError "+
 			"from Client for Stream %v %v.", r.streamId, msg.(dataport.ConnectionError))
 
+		//account for frames failing checksum, that caused the connection error
+		if corrupt := r.stream.GetCorruptFrames(); corrupt > r.corruptFrames {
+			r.stats.Get().numCorruptFrames.Add(corrupt - r.corruptFrames)
+			r.corruptFrames = corrupt
+		}
+
 		//send a separate message for each bucket. If the ConnError is with empty vblist,
 		//the message is ignored.
 		connErr := msg.(dataport.ConnectionError)
@@ -464,8 +478,10 @@ func (r *mutationStreamReader) logReaderStat() {
 	atomic.AddUint64(&r.mutationCount, 1)
 	c := atomic.LoadUint64(&r.mutationCount)
 	if (c%10000 == 0) || c == 1 {
+		ds := r.stream.GetDecodeStats()
 		logging.Debugf("logReaderStat:: %v "+
-			"MutationCount %v", r.streamId, c)
+			"MutationCount %v DecodeBufAllocs %v DecodeBufReleases %v DecodeBytesInUse %v",
+			r.streamId, c, ds.NumAllocs, ds.NumReleases, ds.BytesInUse)
 	}
 
 }
@@ -544,6 +560,7 @@ func (w *streamWorker) start() {
 		case vb := <-w.workerch:
 			w.handleKeyVersions(vb.GetBucketname(), Vbucket(vb.GetVbucket()),
 				Vbuuid(vb.GetVbuuid()), vb.GetKvs(), common.ProjectorVersion(vb.GetProjVer()))
+			w.reader.stream.ReleaseKeyVersions(vb)
 
 		case <-w.workerStopCh:
 			return
@@ -623,7 +640,9 @@ func (w *streamWorker) handleSingleKeyVersion(bucket string, vbucket Vbucket, vb
 				//TODO use free list here to reuse the struct and reduce garbage
 				mutk = NewMutationKeys()
 				mutk.meta = meta.Clone()
-				mutk.docid = kv.GetDocid()
+				//docid may refer to the decode buffer, which is released
+				//once the key versions are processed
+				mutk.docid = append(mutk.docid[:0], kv.GetDocid()...)
 				mutk.mut = mutk.mut[:0]
 			}
 
@@ -653,7 +672,8 @@ func (w *streamWorker) handleSingleKeyVersion(bucket string, vbucket Vbucket, vb
 			//send message to supervisor to take decision
 			msg := &MsgStream{mType: STREAM_READER_STREAM_BEGIN,
 				streamId: w.streamId,
-				meta:     meta.Clone()}
+				meta:     meta.Clone(),
+				source:   string(kv.GetDocid())}
 			w.reader.supvRespch <- msg
 
 		case common.StreamEnd:
@@ -702,6 +722,7 @@ func (w *streamWorker) handleSingleMutation(mut *MutationKeys, stopch StopChanne
 		if rstats, ok := stats.buckets[mut.meta.bucket]; ok {
 			rstats.mutationQueueSize.Add(1)
 			rstats.numMutationsQueued.Add(1)
+			rstats.numBytesQueued.Add(mut.Size())
 		}
 
 	} else {
@@ -19,11 +19,13 @@ import (
 
 type StreamState struct {
 	config common.Config
+	clock  common.Clock
 
 	streamStatus              map[common.StreamId]StreamStatus
 	streamBucketStatus        map[common.StreamId]BucketStatus
 	streamBucketVbStatusMap   map[common.StreamId]BucketVbStatusMap
 	streamBucketVbRefCountMap map[common.StreamId]BucketVbRefCountMap
+	streamBucketVbSourceMap   map[common.StreamId]BucketVbSourceMap
 
 	streamBucketHWTMap            map[common.StreamId]BucketHWTMap
 	streamBucketNeedsCommitMap    map[common.StreamId]BucketNeedsCommitMap
@@ -41,6 +43,8 @@ type StreamState struct {
 	streamBucketRestartVbErrMap   map[common.StreamId]BucketRestartVbErrMap
 	streamBucketRestartVbTsMap    map[common.StreamId]BucketRestartVbTsMap
 	streamBucketRestartVbRetryMap map[common.StreamId]BucketRestartVbRetryMap
+	streamBucketVbRepairCountMap  map[common.StreamId]BucketVbRepairCountMap
+	streamBucketVbRetryTimeMap    map[common.StreamId]BucketVbRetryTimeMap
 
 	streamBucketFlushInProgressTsMap map[common.StreamId]BucketFlushInProgressTsMap
 	streamBucketAbortInProgressMap   map[common.StreamId]BucketAbortInProgressMap
@@ -53,6 +57,10 @@ type StreamState struct {
 	streamBucketLastPersistTime map[common.StreamId]BucketLastPersistTime
 	streamBucketSkippedInMemTs  map[common.StreamId]BucketSkippedInMemTs
 
+	streamBucketLastPersistTsMap    map[common.StreamId]BucketLastPersistTsMap
+	streamBucketLastPersistBytesMap map[common.StreamId]BucketLastPersistBytesMap
+	streamBucketLastAckTime         map[common.StreamId]BucketLastAckTime
+
 	bucketRollbackTime map[string]int64
 }
 
@@ -75,13 +83,19 @@ type BucketDrainEnabledMap map[string]bool
 type BucketRestartVbErrMap map[string]bool
 type BucketRestartVbTsMap map[string]*common.TsVbuuid
 type BucketRestartVbRetryMap map[string]Timestamp
+type BucketVbRepairCountMap map[string]Timestamp
+type BucketVbRetryTimeMap map[string][]time.Time
 
 type BucketVbStatusMap map[string]Timestamp
 type BucketVbRefCountMap map[string]Timestamp
+type BucketVbSourceMap map[string][]string
 type BucketRepairStopCh map[string]StopChannel
 type BucketTimerStopCh map[string]StopChannel
 type BucketLastPersistTime map[string]time.Time
 type BucketSkippedInMemTs map[string]uint64
+type BucketLastPersistTsMap map[string]*common.TsVbuuid
+type BucketLastPersistBytesMap map[string]int64
+type BucketLastAckTime map[string]time.Time
 
 type BucketStatus map[string]StreamStatus
 
@@ -89,6 +103,7 @@ func InitStreamState(config common.Config) *StreamState {
 
 	ss := &StreamState{
 		config:                                config,
+		clock:                                 common.SystemClock{},
 		streamBucketHWTMap:                    make(map[common.StreamId]BucketHWTMap),
 		streamBucketNeedsCommitMap:            make(map[common.StreamId]BucketNeedsCommitMap),
 		streamBucketHasBuildCompTSMap:         make(map[common.StreamId]BucketHasBuildCompTSMap),
@@ -105,9 +120,12 @@ func InitStreamState(config common.Config) *StreamState {
 		streamBucketDrainEnabledMap:           make(map[common.StreamId]BucketDrainEnabledMap),
 		streamBucketVbStatusMap:               make(map[common.StreamId]BucketVbStatusMap),
 		streamBucketVbRefCountMap:             make(map[common.StreamId]BucketVbRefCountMap),
+		streamBucketVbSourceMap:               make(map[common.StreamId]BucketVbSourceMap),
 		streamBucketRestartVbErrMap:           make(map[common.StreamId]BucketRestartVbErrMap),
 		streamBucketRestartVbTsMap:            make(map[common.StreamId]BucketRestartVbTsMap),
 		streamBucketRestartVbRetryMap:         make(map[common.StreamId]BucketRestartVbRetryMap),
+		streamBucketVbRepairCountMap:          make(map[common.StreamId]BucketVbRepairCountMap),
+		streamBucketVbRetryTimeMap:            make(map[common.StreamId]BucketVbRetryTimeMap),
 		streamStatus:                          make(map[common.StreamId]StreamStatus),
 		streamBucketStatus:                    make(map[common.StreamId]BucketStatus),
 		streamBucketIndexCountMap:             make(map[common.StreamId]BucketIndexCountMap),
@@ -116,6 +134,9 @@ func InitStreamState(config common.Config) *StreamState {
 		streamBucketLastPersistTime:           make(map[common.StreamId]BucketLastPersistTime),
 		streamBucketSkippedInMemTs:            make(map[common.StreamId]BucketSkippedInMemTs),
 		streamBucketLastSnapMarker:            make(map[common.StreamId]BucketLastSnapMarker),
+		streamBucketLastPersistTsMap:          make(map[common.StreamId]BucketLastPersistTsMap),
+		streamBucketLastPersistBytesMap:       make(map[common.StreamId]BucketLastPersistBytesMap),
+		streamBucketLastAckTime:               make(map[common.StreamId]BucketLastAckTime),
 		bucketRollbackTime:                    make(map[string]int64),
 	}
 
@@ -174,9 +195,18 @@ func (ss *StreamState) initNewStream(streamId common.StreamId) {
 	bucketVbRefCountMap := make(BucketVbRefCountMap)
 	ss.streamBucketVbRefCountMap[streamId] = bucketVbRefCountMap
 
+	bucketVbSourceMap := make(BucketVbSourceMap)
+	ss.streamBucketVbSourceMap[streamId] = bucketVbSourceMap
+
 	bucketRestartVbRetryMap := make(BucketRestartVbRetryMap)
 	ss.streamBucketRestartVbRetryMap[streamId] = bucketRestartVbRetryMap
 
+	bucketVbRepairCountMap := make(BucketVbRepairCountMap)
+	ss.streamBucketVbRepairCountMap[streamId] = bucketVbRepairCountMap
+
+	bucketVbRetryTimeMap := make(BucketVbRetryTimeMap)
+	ss.streamBucketVbRetryTimeMap[streamId] = bucketVbRetryTimeMap
+
 	bucketRestartVbErrMap := make(BucketRestartVbErrMap)
 	ss.streamBucketRestartVbErrMap[streamId] = bucketRestartVbErrMap
 
@@ -198,6 +228,15 @@ func (ss *StreamState) initNewStream(streamId common.StreamId) {
 	bucketSkippedInMemTs := make(BucketSkippedInMemTs)
 	ss.streamBucketSkippedInMemTs[streamId] = bucketSkippedInMemTs
 
+	bucketLastPersistTsMap := make(BucketLastPersistTsMap)
+	ss.streamBucketLastPersistTsMap[streamId] = bucketLastPersistTsMap
+
+	bucketLastPersistBytesMap := make(BucketLastPersistBytesMap)
+	ss.streamBucketLastPersistBytesMap[streamId] = bucketLastPersistBytesMap
+
+	bucketLastAckTime := make(BucketLastAckTime)
+	ss.streamBucketLastAckTime[streamId] = bucketLastAckTime
+
 	bucketStatus := make(BucketStatus)
 	ss.streamBucketStatus[streamId] = bucketStatus
 
@@ -225,7 +264,10 @@ func (ss *StreamState) initBucketInStream(streamId common.StreamId,
 	ss.streamBucketDrainEnabledMap[streamId][bucket] = true
 	ss.streamBucketVbStatusMap[streamId][bucket] = NewTimestamp(numVbuckets)
 	ss.streamBucketVbRefCountMap[streamId][bucket] = NewTimestamp(numVbuckets)
+	ss.streamBucketVbSourceMap[streamId][bucket] = make([]string, numVbuckets)
 	ss.streamBucketRestartVbRetryMap[streamId][bucket] = NewTimestamp(numVbuckets)
+	ss.streamBucketVbRepairCountMap[streamId][bucket] = NewTimestamp(numVbuckets)
+	ss.streamBucketVbRetryTimeMap[streamId][bucket] = make([]time.Time, numVbuckets)
 	ss.streamBucketRestartVbTsMap[streamId][bucket] = nil
 	ss.streamBucketRestartVbErrMap[streamId][bucket] = false
 	ss.streamBucketIndexCountMap[streamId][bucket] = 0
@@ -237,6 +279,9 @@ func (ss *StreamState) initBucketInStream(streamId common.StreamId,
 	ss.streamBucketStartTimeMap[streamId][bucket] = uint64(0)
 	ss.streamBucketSkippedInMemTs[streamId][bucket] = 0
 	ss.streamBucketLastSnapMarker[streamId][bucket] = common.NewTsVbuuid(bucket, numVbuckets)
+	ss.streamBucketLastPersistTsMap[streamId][bucket] = nil
+	ss.streamBucketLastPersistBytesMap[streamId][bucket] = 0
+	ss.streamBucketLastAckTime[streamId][bucket] = time.Time{}
 
 	ss.streamBucketStatus[streamId][bucket] = STREAM_ACTIVE
 
@@ -265,7 +310,10 @@ func (ss *StreamState) cleanupBucketFromStream(streamId common.StreamId,
 	delete(ss.streamBucketDrainEnabledMap[streamId], bucket)
 	delete(ss.streamBucketVbStatusMap[streamId], bucket)
 	delete(ss.streamBucketVbRefCountMap[streamId], bucket)
+	delete(ss.streamBucketVbSourceMap[streamId], bucket)
 	delete(ss.streamBucketRestartVbRetryMap[streamId], bucket)
+	delete(ss.streamBucketVbRepairCountMap[streamId], bucket)
+	delete(ss.streamBucketVbRetryTimeMap[streamId], bucket)
 	delete(ss.streamBucketRestartVbErrMap[streamId], bucket)
 	delete(ss.streamBucketRestartVbTsMap[streamId], bucket)
 	delete(ss.streamBucketIndexCountMap[streamId], bucket)
@@ -277,6 +325,9 @@ func (ss *StreamState) cleanupBucketFromStream(streamId common.StreamId,
 	delete(ss.streamBucketStartTimeMap[streamId], bucket)
 	delete(ss.streamBucketLastSnapMarker[streamId], bucket)
 	delete(ss.streamBucketSkippedInMemTs[streamId], bucket)
+	delete(ss.streamBucketLastPersistTsMap[streamId], bucket)
+	delete(ss.streamBucketLastPersistBytesMap[streamId], bucket)
+	delete(ss.streamBucketLastAckTime[streamId], bucket)
 
 	ss.streamBucketStatus[streamId][bucket] = STREAM_INACTIVE
 
@@ -301,7 +352,10 @@ func (ss *StreamState) resetStreamState(streamId common.StreamId) {
 	delete(ss.streamBucketDrainEnabledMap, streamId)
 	delete(ss.streamBucketVbStatusMap, streamId)
 	delete(ss.streamBucketVbRefCountMap, streamId)
+	delete(ss.streamBucketVbSourceMap, streamId)
 	delete(ss.streamBucketRestartVbRetryMap, streamId)
+	delete(ss.streamBucketVbRepairCountMap, streamId)
+	delete(ss.streamBucketVbRetryTimeMap, streamId)
 	delete(ss.streamBucketRestartVbErrMap, streamId)
 	delete(ss.streamBucketRestartVbTsMap, streamId)
 	delete(ss.streamBucketIndexCountMap, streamId)
@@ -312,6 +366,9 @@ func (ss *StreamState) resetStreamState(streamId common.StreamId) {
 	delete(ss.streamBucketStartTimeMap, streamId)
 	delete(ss.streamBucketSkippedInMemTs, streamId)
 	delete(ss.streamBucketLastSnapMarker, streamId)
+	delete(ss.streamBucketLastPersistTsMap, streamId)
+	delete(ss.streamBucketLastPersistBytesMap, streamId)
+	delete(ss.streamBucketLastAckTime, streamId)
 
 	ss.streamStatus[streamId] = STREAM_INACTIVE
 
@@ -344,6 +401,135 @@ func (ss *StreamState) clearRestartVbRetry(streamId common.StreamId, bucket stri
 	ss.streamBucketRestartVbRetryMap[streamId][bucket][vb] = Seqno(0)
 }
 
+//clearVbRepairCount clears the repairs of a vbucket on StreamBegin, and
+//returns true if the vbucket was quarantined.
+func (ss *StreamState) clearVbRepairCount(streamId common.StreamId, bucket string, vb Vbucket) bool {
+
+	quarantined := ss.isVbOverBudget(streamId, bucket, vb)
+	if vbs, ok := ss.streamBucketVbRepairCountMap[streamId][bucket]; ok && vbs[vb] != 0 {
+		vbs[vb] = Seqno(0)
+		ss.streamBucketVbRetryTimeMap[streamId][bucket][vb] = time.Time{}
+	}
+	return quarantined
+}
+
+//isVbOverBudget returns true if the vbucket has been repaired more
+//times than the repair budget, without receiving StreamBegin.
+func (ss *StreamState) isVbOverBudget(streamId common.StreamId, bucket string, vb Vbucket) bool {
+
+	budget := ss.config["settings.stream.vb_repair_budget"].Int()
+	if budget <= 0 {
+		return false
+	}
+
+	vbs, ok := ss.streamBucketVbRepairCountMap[streamId][bucket]
+	return ok && int(vbs[vb]) > budget
+}
+
+//isVbQuarantined returns true if the vbucket is over the repair budget,
+//and not yet due for a retry.
+func (ss *StreamState) isVbQuarantined(streamId common.StreamId, bucket string, vb Vbucket) bool {
+
+	if !ss.isVbOverBudget(streamId, bucket, vb) {
+		return false
+	}
+	return ss.clock.Now().Before(ss.streamBucketVbRetryTimeMap[streamId][bucket][vb])
+}
+
+//getQuarantinedVbs returns the vbuckets over the repair budget.
+func (ss *StreamState) getQuarantinedVbs(streamId common.StreamId, bucket string) []Vbucket {
+
+	var quarantined []Vbucket
+	for i := range ss.streamBucketVbRepairCountMap[streamId][bucket] {
+		if ss.isVbOverBudget(streamId, bucket, Vbucket(i)) {
+			quarantined = append(quarantined, Vbucket(i))
+		}
+	}
+	return quarantined
+}
+
+//getFailedOverVbs returns the vbuckets not receiving mutations after
+//StreamEnd or a connection error, including the quarantined ones.
+func (ss *StreamState) getFailedOverVbs(streamId common.StreamId, bucket string) []Vbucket {
+
+	var failedOver []Vbucket
+	for i, s := range ss.streamBucketVbStatusMap[streamId][bucket] {
+		if s == VBS_STREAM_END || s == VBS_CONN_ERROR {
+			failedOver = append(failedOver, Vbucket(i))
+		}
+	}
+	return failedOver
+}
+
+//countVbRepairs counts a repair attempt for every vbucket to be repaired.
+//It returns the vbuckets which have exceeded the repair budget with this
+//attempt, and the quarantined vbuckets due for a retry with this attempt.
+func (ss *StreamState) countVbRepairs(streamId common.StreamId,
+	bucket string) (quarantined []Vbucket, retried []Vbucket) {
+
+	budget := ss.config["settings.stream.vb_repair_budget"].Int()
+	if budget <= 0 {
+		return nil, nil
+	}
+
+	now := ss.clock.Now()
+	vbs := ss.streamBucketVbRepairCountMap[streamId][bucket]
+	retryTime := ss.streamBucketVbRetryTimeMap[streamId][bucket]
+	for i, s := range ss.streamBucketVbStatusMap[streamId][bucket] {
+		if s != VBS_STREAM_END && s != VBS_CONN_ERROR {
+			continue
+		}
+		if int(vbs[i]) <= budget {
+			vbs[i]++
+			if int(vbs[i]) > budget {
+				quarantined = append(quarantined, Vbucket(i))
+				retryTime[i] = now.Add(vbRetryBackoff(0))
+			}
+		} else if !now.Before(retryTime[i]) {
+			vbs[i]++
+			retried = append(retried, Vbucket(i))
+		}
+	}
+	return quarantined, retried
+}
+
+//backoffVbRetries sets the time of the next retry of quarantined vbuckets
+//retried with this repair, and returns the time of the earliest retry of
+//all quarantined vbuckets. It is zero if there is none.
+func (ss *StreamState) backoffVbRetries(streamId common.StreamId, bucket string,
+	retried []Vbucket) time.Time {
+
+	budget := ss.config["settings.stream.vb_repair_budget"].Int()
+	now := ss.clock.Now()
+	vbs := ss.streamBucketVbRepairCountMap[streamId][bucket]
+	retryTime := ss.streamBucketVbRetryTimeMap[streamId][bucket]
+	for _, vb := range retried {
+		retryTime[vb] = now.Add(vbRetryBackoff(int(vbs[vb]) - budget - 1))
+	}
+
+	var next time.Time
+	for _, vb := range ss.getQuarantinedVbs(streamId, bucket) {
+		if next.IsZero() || retryTime[vb].Before(next) {
+			next = retryTime[vb]
+		}
+	}
+	return next
+}
+
+//vbRetryBackoff returns the time to wait before the next retry of a
+//quarantined vbucket, after the given number of retries.
+func vbRetryBackoff(retries int) time.Duration {
+
+	backoff := VB_QUARANTINE_RETRY_MIN
+	for i := 0; i < retries && backoff < VB_QUARANTINE_RETRY_MAX; i++ {
+		backoff *= 2
+	}
+	if backoff > VB_QUARANTINE_RETRY_MAX {
+		backoff = VB_QUARANTINE_RETRY_MAX
+	}
+	return backoff
+}
+
 func (ss *StreamState) markRestartVbError(streamId common.StreamId, bucket string) {
 
 	ss.streamBucketRestartVbErrMap[streamId][bucket] = true
@@ -370,6 +556,23 @@ func (ss *StreamState) incVbRefCount(streamId common.StreamId, bucket string, vb
 	vbs[vb] = Seqno(int(vbs[vb]) + 1)
 }
 
+// setVbSource records the projector which sent the last StreamBegin of
+// the vbucket.
+func (ss *StreamState) setVbSource(streamId common.StreamId, bucket string, vb Vbucket, source string) {
+
+	if sources := ss.streamBucketVbSourceMap[streamId][bucket]; int(vb) < len(sources) {
+		sources[vb] = source
+	}
+}
+
+func (ss *StreamState) getVbSource(streamId common.StreamId, bucket string, vb Vbucket) string {
+
+	if sources := ss.streamBucketVbSourceMap[streamId][bucket]; int(vb) < len(sources) {
+		return sources[vb]
+	}
+	return ""
+}
+
 func (ss *StreamState) decVbRefCount(streamId common.StreamId, bucket string, vb Vbucket) {
 
 	vbs := ss.streamBucketVbRefCountMap[streamId][bucket]
@@ -437,7 +640,11 @@ func (ss *StreamState) getRepairTsForBucket(streamId common.StreamId,
 	hasConnError := ss.hasConnectionError(streamId, bucket)
 
 	// First step : Find out if there is any StreamEnd or ConnError on any vb.
+	// Quarantined vb are no longer repaired.
 	for i, s := range ss.streamBucketVbStatusMap[streamId][bucket] {
+		if ss.isVbQuarantined(streamId, bucket, Vbucket(i)) {
+			continue
+		}
 		if s == VBS_STREAM_END || s == VBS_CONN_ERROR {
 
 			repairVbs = ss.addRepairTs(repairTs, hwtTs, Vbucket(i), repairVbs)
@@ -461,7 +668,7 @@ func (ss *StreamState) getRepairTsForBucket(streamId common.StreamId,
 	// would already be in shutdownVbs, if there is connErr.
 	if !hasConnError {
 		for i, s := range ss.streamBucketVbStatusMap[streamId][bucket] {
-			if s == VBS_STREAM_END {
+			if s == VBS_STREAM_END && !ss.isVbQuarantined(streamId, bucket, Vbucket(i)) {
 				vbs := ss.streamBucketRestartVbRetryMap[streamId][bucket]
 				vbs[i] = Seqno(int(vbs[i]) + 1)
 
@@ -484,7 +691,7 @@ func (ss *StreamState) getRepairTsForBucket(streamId common.StreamId,
 	if !anythingToRepair {
 		for i, s := range ss.streamBucketVbStatusMap[streamId][bucket] {
 			count := ss.streamBucketVbRefCountMap[streamId][bucket][i]
-			if count != 1 && s != VBS_INIT {
+			if count != 1 && s != VBS_INIT && !ss.isVbQuarantined(streamId, bucket, Vbucket(i)) {
 				logging.Infof("StreamState::getRepairTsForBucket\n\t"+
 					"Bucket %v StreamId %v Vbucket %v have ref count (%v != 1). Convert to CONN_ERROR.",
 					bucket, streamId, i, count)
@@ -516,6 +723,12 @@ func (ss *StreamState) getRepairTsForBucket(streamId common.StreamId,
 		shutdownVbs = nil
 		vbnos := repairTs.GetVbnos()
 		for _, vbno := range vbnos {
+			if ss.isVbQuarantined(streamId, bucket, Vbucket(vbno)) {
+				repairTs.Seqnos[vbno] = 0
+				repairTs.Vbuuids[vbno] = 0
+				repairTs.Snapshots[vbno] = [2]uint64{0, 0}
+				continue
+			}
 			shutdownVbs = append(shutdownVbs, Vbucket(vbno))
 		}
 	}
@@ -775,7 +988,7 @@ func (ss *StreamState) checkNewTSDue(streamId common.StreamId, bucket string) bo
 
 func (ss *StreamState) checkCommitOverdue(streamId common.StreamId, bucket string) bool {
 
-	snapPersistInterval := ss.getPersistInterval()
+	snapPersistInterval := ss.getStreamPersistInterval(streamId)
 	persistDuration := time.Duration(snapPersistInterval) * time.Millisecond
 
 	lastPersistTime := ss.streamBucketLastPersistTime[streamId][bucket]
@@ -972,6 +1185,84 @@ func (ss *StreamState) getPersistInterval() uint64 {
 
 }
 
+func (ss *StreamState) getStreamPersistInterval(streamId common.StreamId) uint64 {
+
+	if streamId == common.INIT_STREAM {
+		if interval := ss.config["settings.persisted_snapshot.init_stream.interval"].Uint64(); interval != 0 {
+			return interval
+		}
+	}
+	return ss.getPersistInterval()
+}
+
+//checkPersistThreshold returns true if the mutations, or the bytes of
+//index keys, queued since the last persisted snapshot of the stream
+//reach the configured thresholds, so that a snapshot is persisted
+//before the persist interval under bursty load. Mutations are counted
+//as the seqnos flushTs is ahead of the last persisted snapshot. Bytes
+//are counted for the bucket, across its streams.
+func (ss *StreamState) checkPersistThreshold(streamId common.StreamId,
+	bucket string, flushTs *common.TsVbuuid, bytesQueued int64) bool {
+
+	mutationThreshold := ss.config["settings.persisted_snapshot.mutation_threshold"].Uint64()
+	bytesThreshold := ss.config["settings.persisted_snapshot.bytes_threshold"].Uint64()
+	if mutationThreshold == 0 && bytesThreshold == 0 {
+		return false
+	}
+
+	//count from the first snapshot of the stream, if none is persisted yet
+	lastTs := ss.streamBucketLastPersistTsMap[streamId][bucket]
+	if lastTs == nil {
+		ss.streamBucketLastPersistTsMap[streamId][bucket] = flushTs.Copy()
+		ss.streamBucketLastPersistBytesMap[streamId][bucket] = bytesQueued
+		return false
+	}
+
+	if mutationThreshold != 0 {
+		var mutations uint64
+		for i, seqno := range flushTs.Seqnos {
+			if i < len(lastTs.Seqnos) && seqno > lastTs.Seqnos[i] {
+				mutations += seqno - lastTs.Seqnos[i]
+			}
+		}
+		if mutations >= mutationThreshold {
+			return true
+		}
+	}
+
+	bytes := bytesQueued - ss.streamBucketLastPersistBytesMap[streamId][bucket]
+	return bytesThreshold != 0 && bytes > 0 && uint64(bytes) >= bytesThreshold
+}
+
+//setLastPersist records flushTs as the last persisted snapshot of the
+//stream.
+func (ss *StreamState) setLastPersist(streamId common.StreamId,
+	bucket string, flushTs *common.TsVbuuid, bytesQueued int64) {
+
+	ss.streamBucketLastPersistTime[streamId][bucket] = time.Now()
+	ss.streamBucketLastPersistTsMap[streamId][bucket] = flushTs.Copy()
+	ss.streamBucketLastPersistBytesMap[streamId][bucket] = bytesQueued
+}
+
+//checkAckInterval returns true if the snapshot of the stream is to be
+//acknowledged to projectors, at most once per ack interval.
+func (ss *StreamState) checkAckInterval(streamId common.StreamId,
+	bucket string) bool {
+
+	interval := ss.config["settings.stream_ack.interval"].Uint64()
+	if interval == 0 {
+		return false
+	}
+
+	now := ss.clock.Now()
+	lastAckTime, ok := ss.streamBucketLastAckTime[streamId][bucket]
+	if !ok || now.Sub(lastAckTime) < time.Duration(interval)*time.Millisecond {
+		return false
+	}
+	ss.streamBucketLastAckTime[streamId][bucket] = now
+	return true
+}
+
 func (ss *StreamState) disableSnapAlignForPendingTs(streamId common.StreamId, bucket string) {
 
 	tsList := ss.streamBucketTsListMap[streamId][bucket]
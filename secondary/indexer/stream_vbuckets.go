@@ -0,0 +1,233 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  stream vbucket bookkeeping
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// The timekeeper tracks the status of every vbucket of a stream and
+// bucket, from the StreamBegin and StreamEnd messages of the projectors.
+// It is served on /streamVbuckets, so that tooling can spot vbuckets
+// that have not started after a restart, or that have more than one
+// owner. The owner of a started vbucket is the KV node of the projector
+// that sent its last StreamBegin. The owner of a vbucket not started is
+// the KV node of the active vbucket in the bucket map, where the
+// projector to stream the vbucket runs.
+//
+
+// StreamVbuckets is the vbucket bookkeeping of a stream and bucket.
+type StreamVbuckets struct {
+	Stream   string      `json:"stream"`
+	Bucket   string      `json:"bucket"`
+	Status   string      `json:"status"`
+	Active   []VbucketOf `json:"active"`   // StreamBegin without StreamEnd
+	Inactive []VbucketOf `json:"inactive"` // not started, ended or in repair
+	Multiple []VbucketOf `json:"multiple"` // more than one StreamBegin
+	Nodes    []string    `json:"nodes"`    // KV nodes, indexed by VbucketOf.Node
+	NodeErr  string      `json:"nodeError,omitempty"`
+}
+
+// VbucketOf is the status of a vbucket of a stream, and its KV node.
+type VbucketOf struct {
+	Vbucket   int    `json:"vb"`
+	Status    string `json:"status"`
+	RefCount  int    `json:"refCount"`
+	Node      int    `json:"node"`                // index in Nodes, -1 if unknown
+	Projector string `json:"projector,omitempty"` // address of last StreamBegin
+}
+
+// streamVbuckets returns the vbucket bookkeeping of the bucket in the
+// streams it is active in, or in streamId if it is not NIL_STREAM.
+func (tk *timekeeper) streamVbuckets(streamId common.StreamId, bucket string) []*StreamVbuckets {
+
+	tk.lock.Lock()
+	result := tk.ss.streamVbuckets(streamId, bucket)
+	tk.lock.Unlock()
+
+	if len(result) == 0 {
+		return result
+	}
+
+	// the bucket map is read outside of tk.lock, as getBucketConn
+	// may connect to the bucket.
+	nodes, vbNodes, err := tk.getVbNodes(bucket)
+	setVbNodes(result, nodes, vbNodes, err)
+	return result
+}
+
+// streamVbuckets returns the vbucket bookkeeping of the bucket, without
+// the KV nodes of the vbuckets.
+func (ss *StreamState) streamVbuckets(streamId common.StreamId, bucket string) []*StreamVbuckets {
+
+	var result []*StreamVbuckets
+
+	for s := common.StreamId(0); s < common.ALL_STREAMS; s++ {
+		if s == common.NIL_STREAM || (streamId != common.NIL_STREAM && s != streamId) {
+			continue
+		}
+		vbs, ok := ss.streamBucketVbStatusMap[s][bucket]
+		if !ok {
+			continue
+		}
+
+		sv := &StreamVbuckets{
+			Stream: s.String(),
+			Bucket: bucket,
+			Status: ss.streamBucketStatus[s][bucket].String(),
+		}
+		for vb, status := range vbs {
+			refCount := ss.getVbRefCount(s, bucket, Vbucket(vb))
+			vbOf := VbucketOf{
+				Vbucket:  vb,
+				Status:   VbStatus(status).String(),
+				RefCount: refCount,
+				Node:     -1,
+			}
+			if VbStatus(status) != VBS_STREAM_BEGIN {
+				sv.Inactive = append(sv.Inactive, vbOf)
+				continue
+			}
+			vbOf.Projector = ss.getVbSource(s, bucket, Vbucket(vb))
+			if refCount > 1 {
+				sv.Multiple = append(sv.Multiple, vbOf)
+			} else {
+				sv.Active = append(sv.Active, vbOf)
+			}
+		}
+		result = append(result, sv)
+	}
+	return result
+}
+
+// setVbNodes sets the KV node of vbuckets, from the host of the projector
+// of started vbuckets, else from vbNodes of the bucket map.
+func setVbNodes(result []*StreamVbuckets, nodes []string, vbNodes []int, err error) {
+
+	hostNodes := make(map[string]int)
+	for i, node := range nodes {
+		if host, _, err := net.SplitHostPort(node); err == nil {
+			hostNodes[host] = i
+		}
+	}
+
+	for _, sv := range result {
+		if err != nil {
+			sv.NodeErr = err.Error()
+			continue
+		}
+		sv.Nodes = nodes
+		for _, vbOfs := range [][]VbucketOf{sv.Active, sv.Inactive, sv.Multiple} {
+			for i := range vbOfs {
+				vbOf := &vbOfs[i]
+				if vbOf.Projector != "" {
+					if host, _, err := net.SplitHostPort(vbOf.Projector); err == nil {
+						if node, ok := hostNodes[host]; ok {
+							vbOf.Node = node
+						}
+					}
+				} else if vbOf.Vbucket < len(vbNodes) {
+					vbOf.Node = vbNodes[vbOf.Vbucket]
+				}
+			}
+		}
+	}
+}
+
+// getVbNodes returns the KV nodes of bucket, and the index of the KV
+// node of every active vbucket.
+func (tk *timekeeper) getVbNodes(bucket string) ([]string, []int, error) {
+
+	b, err := tk.getBucketConn(bucket, false)
+	if err != nil {
+		logging.Errorf("Timekeeper::getVbNodes Error connecting to bucket %v. Error %v", bucket, err)
+		return nil, nil, err
+	}
+
+	vbmap := b.VBServerMap()
+	if vbmap == nil {
+		return nil, nil, fmt.Errorf("No vbucket map for bucket %v", bucket)
+	}
+
+	vbNodes := make([]int, len(vbmap.VBucketMap))
+	for vb, idxs := range vbmap.VBucketMap {
+		vbNodes[vb] = -1
+		if len(idxs) != 0 && idxs[0] >= 0 && idxs[0] < len(vbmap.ServerList) {
+			vbNodes[vb] = idxs[0]
+		}
+	}
+	return vbmap.ServerList, vbNodes, nil
+}
+
+// handleStreamVbucketsReq returns the vbucket bookkeeping of the bucket,
+// for the given stream or for all the streams the bucket is active in.
+func (tk *timekeeper) handleStreamVbucketsReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	bucket := r.FormValue("bucket")
+	if bucket == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("Missing bucket\n"))
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+	if !common.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	streamId := common.NIL_STREAM
+	if stream := r.FormValue("stream"); stream != "" {
+		if streamId, err = parseStreamId(stream); err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+	}
+
+	buf, err := json.Marshal(tk.streamVbuckets(streamId, bucket))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("%v\n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(buf)
+}
@@ -0,0 +1,76 @@
+package indexer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestStreamVbuckets(t *testing.T) {
+	ss := InitStreamState(common.Config{
+		"numVbuckets":                      common.ConfigValue{Value: 4},
+		"settings.stream.vb_repair_budget": common.ConfigValue{Value: 0},
+	})
+	streamId, bucket := common.MAINT_STREAM, "default"
+	ss.initNewStream(streamId)
+	ss.initBucketInStream(streamId, bucket)
+	ss.streamBucketStatus[streamId][bucket] = STREAM_ACTIVE
+	ss.streamBucketIndexCountMap[streamId][bucket] = 1
+	tk := &timekeeper{ss: ss, supvCmdch: make(MsgChannel, 1)}
+
+	// the projector of StreamBegin is recorded as the owner.
+	streamBegin := func(vb Vbucket, source string) {
+		meta := NewMutationMeta()
+		meta.bucket, meta.vbucket = bucket, vb
+		tk.handleStreamBegin(&MsgStream{mType: STREAM_READER_STREAM_BEGIN,
+			streamId: streamId, meta: meta, source: source})
+		if _, ok := (<-tk.supvCmdch).(*MsgSuccess); !ok {
+			t.Fatalf("Expected StreamBegin of vb %v to succeed", vb)
+		}
+	}
+	streamBegin(0, "10.0.0.1:53000")
+	streamBegin(1, "10.0.0.2:53001")
+	streamBegin(3, "10.0.0.9:53002")
+	ss.incVbRefCount(streamId, bucket, 1)
+
+	if result := ss.streamVbuckets(common.INIT_STREAM, bucket); len(result) != 0 {
+		t.Fatalf("Unexpected vbuckets of inactive stream %v", result)
+	}
+	result := ss.streamVbuckets(common.NIL_STREAM, bucket)
+	if len(result) != 1 {
+		t.Fatalf("Expected vbuckets of 1 stream, got %v", result)
+	}
+
+	nodes := []string{"10.0.0.1:11210", "10.0.0.2:11210"}
+	setVbNodes(result, nodes, []int{1, 0, 1, 0}, nil)
+
+	sv := result[0]
+	begin, pending := VbStatus(VBS_STREAM_BEGIN).String(), VbStatus(VBS_INIT).String()
+	expected := &StreamVbuckets{
+		Stream: streamId.String(),
+		Bucket: bucket,
+		Status: STREAM_ACTIVE.String(),
+		Active: []VbucketOf{
+			{Vbucket: 0, Status: begin, RefCount: 1, Node: 0, Projector: "10.0.0.1:53000"},
+			{Vbucket: 3, Status: begin, RefCount: 1, Node: -1, Projector: "10.0.0.9:53002"},
+		},
+		Inactive: []VbucketOf{
+			{Vbucket: 2, Status: pending, Node: 1},
+		},
+		Multiple: []VbucketOf{
+			{Vbucket: 1, Status: begin, RefCount: 2, Node: 1, Projector: "10.0.0.2:53001"},
+		},
+		Nodes: nodes,
+	}
+	if !reflect.DeepEqual(sv, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, sv)
+	}
+
+	result = ss.streamVbuckets(streamId, bucket)
+	setVbNodes(result, nil, nil, errors.New("no bucket map"))
+	if result[0].NodeErr != "no bucket map" || result[0].Active[0].Node != -1 {
+		t.Errorf("Expected node error, got %+v", result[0])
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/couchbase/indexing/secondary/dcp"
 	"github.com/couchbase/indexing/secondary/logging"
 	"math"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -56,6 +57,15 @@ type timekeeper struct {
 	lock sync.RWMutex //lock to protect this structure
 
 	indexerState common.IndexerState
+
+	tsHistory *tsHistory //history of stability timestamps
+
+	//time since which the ingest lag of an index instance is above
+	//threshold, and whether an event has been raised for it
+	lagSince   map[common.IndexInstId]time.Time
+	lagAlerted map[common.IndexInstId]bool
+
+	missingVbs *missingVbs //shared with scan coordinator
 }
 
 type InitialBuildInfo struct {
@@ -71,6 +81,11 @@ const REPAIR_BATCH_TIMEOUT = 1000
 const KV_RETRY_INTERVAL = 5000
 
 //const REPAIR_RETRY_INTERVAL = 5000
+
+//backoff between retries of a vbucket quarantined after exceeding
+//the repair budget
+const VB_QUARANTINE_RETRY_MIN = time.Minute
+const VB_QUARANTINE_RETRY_MAX = 30 * time.Minute
 const REPAIR_RETRY_BEFORE_SHUTDOWN = 5
 
 //NewTimekeeper returns an instance of timekeeper or err message.
@@ -79,7 +94,7 @@ const REPAIR_RETRY_BEFORE_SHUTDOWN = 5
 //Any async response to supervisor is sent to supvRespch.
 //If supvCmdch get closed, storageMgr will shut itself down.
 func NewTimekeeper(supvCmdch MsgChannel, supvRespch MsgChannel,
-	config common.Config) (Timekeeper, Message) {
+	config common.Config, missingVbs *missingVbs) (Timekeeper, Message) {
 
 	//Init the timekeeper struct
 	tk := &timekeeper{
@@ -91,8 +106,15 @@ func NewTimekeeper(supvCmdch MsgChannel, supvRespch MsgChannel,
 		indexPartnMap:  make(IndexPartnMap),
 		indexBuildInfo: make(map[common.IndexInstId]*InitialBuildInfo),
 		bucketConn:     make(map[string]*couchbase.Bucket),
+		tsHistory:      newTsHistory(config, common.SystemClock{}),
+		lagSince:       make(map[common.IndexInstId]time.Time),
+		lagAlerted:     make(map[common.IndexInstId]bool),
+		missingVbs:     missingVbs,
 	}
 
+	http.HandleFunc("/stabilityTimestamp", tk.handleTsHistoryReq)
+	http.HandleFunc("/streamVbuckets", tk.handleStreamVbucketsReq)
+
 	//start timekeeper loop which listens to commands from its supervisor
 	go tk.run()
 
@@ -458,6 +480,7 @@ func (tk *timekeeper) removeBucketFromStream(streamId common.StreamId,
 	} else {
 		tk.stopTimer(streamId, bucket)
 		tk.ss.cleanupBucketFromStream(streamId, bucket)
+		tk.clearMissingVbs(streamId, bucket)
 	}
 
 }
@@ -535,6 +558,8 @@ func (tk *timekeeper) handleFlushDone(cmd Message) {
 			tk.ss.streamBucketLastSnapAlignFlushedTsMap[streamId][bucket] = fts.Copy()
 		}
 
+		tk.ackSnapshotTs(streamId, bucket, fts)
+
 		//update internal map to reflect flush is done
 		bucketFlushInProgressTsMap[bucket] = nil
 	} else {
@@ -560,6 +585,28 @@ func (tk *timekeeper) handleFlushDone(cmd Message) {
 
 }
 
+//ackSnapshotTs acknowledges the timestamp of a flush that created a
+//snapshot to projectors, via kv sender, at most once per ack interval.
+//In-memory snapshots are acknowledged as well, persisted snapshots can
+//be minutes apart and projectors would report the stream as stuck in
+//between.
+func (tk *timekeeper) ackSnapshotTs(streamId common.StreamId,
+	bucket string, fts *common.TsVbuuid) {
+
+	if fts == nil {
+		return
+	}
+
+	switch fts.GetSnapType() {
+	case common.INMEM_SNAP, common.DISK_SNAP, common.FORCE_COMMIT:
+		if tk.ss.checkAckInterval(streamId, bucket) {
+			tk.supvRespch <- &MsgAckTimestamps{streamId: streamId,
+				bucket: bucket,
+				ackTs:  fts.Copy()}
+		}
+	}
+}
+
 func (tk *timekeeper) processFlushAbort(streamId common.StreamId, bucket string) {
 
 	bucketLastFlushedTsMap := tk.ss.streamBucketLastFlushedTsMap[streamId]
@@ -940,6 +987,7 @@ func (tk *timekeeper) handleStreamBegin(cmd Message) {
 		// When receivng a StreamBegin, it means that the projector claims ownership
 		// of a vbucket.   Keep track of how many projectors are claiming ownership.
 		tk.ss.incVbRefCount(streamId, meta.bucket, meta.vbucket)
+		tk.ss.setVbSource(streamId, meta.bucket, meta.vbucket, cmd.(*MsgStream).GetSource())
 
 		//update the HWT of this stream and bucket with the vbuuid
 		bucketHWTMap := tk.ss.streamBucketHWTMap[streamId]
@@ -955,6 +1003,16 @@ func (tk *timekeeper) handleStreamBegin(cmd Message) {
 		tk.ss.streamBucketNewTsReqdMap[streamId][meta.bucket] = true
 
 		tk.ss.updateVbStatus(streamId, meta.bucket, []Vbucket{meta.vbucket}, VBS_STREAM_BEGIN)
+		if tk.ss.clearVbRepairCount(streamId, meta.bucket, meta.vbucket) {
+			logging.Infof("Timekeeper::handleStreamBegin Stream %v Bucket %v Vbucket %v "+
+				"recovered from quarantine.", streamId, meta.bucket, meta.vbucket)
+			if len(tk.ss.getQuarantinedVbs(streamId, meta.bucket)) == 0 {
+				tk.sendVbQuarantine(streamId, meta.bucket, nil)
+			}
+		}
+		if tk.missingVbs.has(streamId, meta.bucket) {
+			tk.updateMissingVbs(streamId, meta.bucket)
+		}
 
 		count := tk.ss.getVbRefCount(streamId, meta.bucket, meta.vbucket)
 		if count > 1 {
@@ -1531,6 +1589,7 @@ func (tk *timekeeper) handleConfigUpdate(cmd Message) {
 	cfgUpdate := cmd.(*MsgConfigUpdate)
 	tk.config = cfgUpdate.GetConfig()
 	tk.ss.UpdateConfig(tk.config)
+	tk.tsHistory.setConfig(tk.config)
 
 	tk.supvCmdch <- &MsgSuccess{}
 }
@@ -1821,6 +1880,7 @@ func (tk *timekeeper) checkInitStreamReadyToMerge(streamId common.StreamId,
 					"Bucket %v State Changed to INACTIVE", streamId, bucket)
 				tk.stopTimer(streamId, bucket)
 				tk.ss.cleanupBucketFromStream(streamId, bucket)
+				tk.clearMissingVbs(streamId, bucket)
 				return true
 
 			}
@@ -1930,7 +1990,7 @@ func (tk *timekeeper) generateNewStabilityTS(streamId common.StreamId,
 			if tsVbuuid.IsSnapAligned() {
 				logging.Infof("Timekeeper:: %v %v Forcing Overdue Commit", streamId, bucket)
 				tsVbuuid.SetSnapType(common.FORCE_COMMIT)
-				tk.ss.streamBucketLastPersistTime[streamId][bucket] = time.Now()
+				tk.ss.setLastPersist(streamId, bucket, tsVbuuid, tk.getBytesQueued(bucket))
 				tk.sendNewStabilityTS(tsVbuuid, bucket, streamId)
 			}
 		}
@@ -2064,6 +2124,7 @@ func (tk *timekeeper) sendNewStabilityTS(flushTs *common.TsVbuuid, bucket string
 	tk.setNeedsCommit(streamId, bucket, flushTs)
 
 	tk.ss.streamBucketFlushInProgressTsMap[streamId][bucket] = flushTs
+	tk.tsHistory.add(streamId, bucket, flushTs)
 
 	monitor_ts := tk.config["timekeeper.monitor_flush"].Bool()
 
@@ -2130,28 +2191,34 @@ func (tk *timekeeper) setSnapshotType(streamId common.StreamId, bucket string,
 			var snapPersistInterval uint64
 			var persistDuration time.Duration
 			if flushTs.GetSnapType() == common.INMEM_SNAP && isMergeCandidate {
-				snapPersistInterval = tk.getPersistInterval()
+				snapPersistInterval = tk.getStreamPersistInterval(streamId, false)
 				persistDuration = time.Duration(snapPersistInterval) * time.Millisecond
 			} else {
-				snapPersistInterval = tk.getPersistIntervalInitBuild()
+				snapPersistInterval = tk.getStreamPersistInterval(streamId, true)
 				persistDuration = time.Duration(snapPersistInterval) * time.Millisecond
 			}
 
-			//create disk snapshot based on wall clock time
-			if time.Since(lastPersistTime) > persistDuration {
+			//create disk snapshot based on wall clock time, or the
+			//mutations queued since the last disk snapshot
+			bytesQueued := tk.getBytesQueued(bucket)
+			if time.Since(lastPersistTime) > persistDuration ||
+				tk.ss.checkPersistThreshold(streamId, bucket, flushTs, bytesQueued) {
 				flushTs.SetSnapType(common.DISK_SNAP)
-				tk.ss.streamBucketLastPersistTime[streamId][bucket] = time.Now()
+				tk.ss.setLastPersist(streamId, bucket, flushTs, bytesQueued)
 			}
 		}
 	} else if flushTs.IsSnapAligned() {
 		//for incremental build, snapshot only if ts is snap aligned
-		//set either in-mem or persist snapshot based on wall clock time
-		snapPersistInterval := tk.getPersistInterval()
+		//set either in-mem or persist snapshot based on wall clock time,
+		//or the mutations queued since the last persisted snapshot
+		snapPersistInterval := tk.getStreamPersistInterval(streamId, false)
 		persistDuration := time.Duration(snapPersistInterval) * time.Millisecond
 
-		if time.Since(lastPersistTime) > persistDuration {
+		bytesQueued := tk.getBytesQueued(bucket)
+		if time.Since(lastPersistTime) > persistDuration ||
+			tk.ss.checkPersistThreshold(streamId, bucket, flushTs, bytesQueued) {
 			flushTs.SetSnapType(common.DISK_SNAP)
-			tk.ss.streamBucketLastPersistTime[streamId][bucket] = time.Now()
+			tk.ss.setLastPersist(streamId, bucket, flushTs, bytesQueued)
 			tk.ss.streamBucketSkippedInMemTs[streamId][bucket] = 0
 		} else {
 			fastFlush := tk.config["settings.fast_flush_mode"].Bool()
@@ -2180,6 +2247,17 @@ func (tk *timekeeper) setSnapshotType(streamId common.StreamId, bucket string,
 
 }
 
+//getBytesQueued returns the bytes of index keys queued for the bucket
+//since the indexer started
+func (tk *timekeeper) getBytesQueued(bucket string) int64 {
+
+	stats := tk.stats.Get()
+	if stat, ok := stats.buckets[bucket]; ok {
+		return stat.numBytesQueued.Value()
+	}
+	return 0
+}
+
 //checkMergeCandidateTs check if a TS is a candidate for merge with
 //MAINT_STREAM
 func (tk *timekeeper) checkMergeCandidateTs(streamId common.StreamId,
@@ -2415,6 +2493,7 @@ func (tk *timekeeper) initiateRecovery(streamId common.StreamId,
 
 		tk.stopTimer(streamId, bucket)
 		tk.ss.cleanupBucketFromStream(streamId, bucket)
+		tk.clearMissingVbs(streamId, bucket)
 
 		//send message for recovery
 		tk.supvRespch <- &MsgRecovery{mType: INDEXER_INITIATE_RECOVERY,
@@ -2495,9 +2574,30 @@ func (tk *timekeeper) repairStream(streamId common.StreamId,
 		return
 	}
 
+	//quarantine vbs which have exceeded the repair budget, quarantined
+	//vbs are retried with backoff
+	quarantined, retried := tk.ss.countVbRepairs(streamId, bucket)
+	if len(quarantined) != 0 {
+		logging.Errorf("Timekeeper::repairStream Stream %v Bucket %v Vbuckets %v "+
+			"exceeded repair budget. Quarantined.", streamId, bucket, quarantined)
+		tk.sendVbQuarantine(streamId, bucket, tk.ss.getQuarantinedVbs(streamId, bucket))
+	}
+	if len(retried) != 0 {
+		logging.Infof("Timekeeper::repairStream Stream %v Bucket %v Retry repair "+
+			"of quarantined Vbuckets %v", streamId, bucket, retried)
+	}
+	tk.updateMissingVbs(streamId, bucket)
+
 	//prepare repairTs with all vbs in STREAM_END, REPAIR status and
 	//send that to KVSender to repair
-	if repairTs, needRepair, connErrVbs := tk.ss.getRepairTsForBucket(streamId, bucket); needRepair {
+	repairTs, needRepair, connErrVbs := tk.ss.getRepairTsForBucket(streamId, bucket)
+	if len(quarantined) != 0 || len(retried) != 0 {
+		if next := tk.ss.backoffVbRetries(streamId, bucket, retried); !next.IsZero() {
+			tk.scheduleVbRetry(streamId, bucket, next.Sub(tk.ss.clock.Now()))
+		}
+	}
+
+	if needRepair {
 
 		respCh := make(MsgChannel)
 		stopCh := tk.ss.streamBucketRepairStopCh[streamId][bucket]
@@ -2525,6 +2625,60 @@ func (tk *timekeeper) repairStream(streamId common.StreamId,
 
 }
 
+//sendVbQuarantine notifies the indexer of the quarantined vbs of the
+//stream and bucket, none once all of them have recovered.
+func (tk *timekeeper) sendVbQuarantine(streamId common.StreamId, bucket string,
+	vbList []Vbucket) {
+
+	go func(msg Message) {
+		tk.supvRespch <- msg
+	}(&MsgTKVbQuarantine{mType: TK_VB_QUARANTINE,
+		streamId: streamId,
+		bucket:   bucket,
+		vbList:   vbList})
+}
+
+//updateMissingVbs updates the quarantined and failed over vbs of the
+//stream and bucket, skipped by partial scans.
+func (tk *timekeeper) updateMissingVbs(streamId common.StreamId, bucket string) {
+
+	quarantined := tk.ss.getQuarantinedVbs(streamId, bucket)
+	missing := tk.ss.getFailedOverVbs(streamId, bucket)
+	for _, vb := range quarantined {
+		if s := tk.ss.getVbStatus(streamId, bucket, vb); s != VBS_STREAM_END && s != VBS_CONN_ERROR {
+			missing = append(missing, vb)
+		}
+	}
+	tk.missingVbs.set(streamId, bucket, quarantined, missing)
+}
+
+//clearMissingVbs clears the missing vbs of a bucket removed from the stream.
+func (tk *timekeeper) clearMissingVbs(streamId common.StreamId, bucket string) {
+
+	tk.missingVbs.set(streamId, bucket, nil, nil)
+}
+
+//scheduleVbRetry repairs the stream again once quarantined vbs are due
+//for a retry. If a repair is in progress by then, it retries them.
+func (tk *timekeeper) scheduleVbRetry(streamId common.StreamId, bucket string,
+	after time.Duration) {
+
+	time.AfterFunc(after, func() {
+		tk.lock.Lock()
+		defer tk.lock.Unlock()
+
+		if status, ok := tk.ss.streamBucketStatus[streamId][bucket]; !ok || status == STREAM_INACTIVE {
+			return
+		}
+		if stopChs, ok := tk.ss.streamBucketRepairStopCh[streamId]; ok {
+			if stopCh, ok := stopChs[bucket]; !ok || stopCh == nil {
+				stopChs[bucket] = make(StopChannel)
+				go tk.repairStream(streamId, bucket)
+			}
+		}
+	})
+}
+
 func (tk *timekeeper) sendRestartMsg(restartMsg Message) {
 
 	tk.supvRespch <- restartMsg
@@ -2683,6 +2837,13 @@ func (tk *timekeeper) handleUpdateIndexInstMap(cmd Message) {
 		}
 	}
 
+	for instId := range tk.lagSince {
+		if _, ok := indexInstMap[instId]; !ok {
+			delete(tk.lagSince, instId)
+			delete(tk.lagAlerted, instId)
+		}
+	}
+
 	tk.stats.Set(req.GetStatsObject())
 	tk.indexInstMap = common.CopyIndexInstMap(indexInstMap)
 	tk.supvCmdch <- &MsgSuccess{}
@@ -2784,6 +2945,9 @@ func (tk *timekeeper) handleStats(cmd Message) {
 		tk.lock.Lock()
 		defer tk.lock.Unlock()
 
+		var lagEvents []*MsgTKIngestLag
+		now := time.Now()
+
 		stats := tk.stats.Get()
 		for _, inst := range tk.indexInstMap {
 			//skip deleted indexes
@@ -2829,6 +2993,22 @@ func (tk *timekeeper) handleStats(cmd Message) {
 				}
 			}
 
+			// ingest lag is the distance between kv seqnos and the
+			// last snapshot applied to the index.
+			lag := uint64(0)
+			for i, seqno := range kvTs {
+				flushSeqno := uint64(0)
+				if flushedTs != nil {
+					flushSeqno = flushedTs.Seqnos[i]
+				}
+				if uint64(seqno) > flushSeqno {
+					lag += uint64(seqno) - flushSeqno
+				}
+			}
+			if msg := tk.checkIngestLag(inst, lag, now); msg != nil {
+				lagEvents = append(lagEvents, msg)
+			}
+
 			switch inst.State {
 			default:
 				v = 0.00
@@ -2851,6 +3031,7 @@ func (tk *timekeeper) handleStats(cmd Message) {
 				idxStats.numDocsProcessed.Set(int64(flushedCount))
 				idxStats.numDocsQueued.Set(int64(queued))
 				idxStats.numDocsPending.Set(int64(pending))
+				idxStats.ingestLag.Set(int64(lag))
 				idxStats.buildProgress.Set(int64(v))
 				idxStats.completionProgress.Set(int64(math.Float64bits(v)))
 				idxStats.lastRollbackTime.Set(tk.ss.bucketRollbackTime[inst.Defn.Bucket])
@@ -2858,10 +3039,56 @@ func (tk *timekeeper) handleStats(cmd Message) {
 			}
 		}
 
+		if len(lagEvents) > 0 {
+			go func() {
+				for _, msg := range lagEvents {
+					tk.supvRespch <- msg
+				}
+			}()
+		}
+
 		replych <- true
 	}()
 }
 
+//checkIngestLag tracks the index instances whose ingest lag is above
+//settings.ingest_lag.threshold. It returns an event once the lag stays
+//above threshold for settings.ingest_lag.duration, and another once the
+//lag recovers. Caller shall hold tk.lock.
+func (tk *timekeeper) checkIngestLag(inst common.IndexInst,
+	lag uint64, now time.Time) *MsgTKIngestLag {
+
+	threshold := tk.config["settings.ingest_lag.threshold"].Uint64()
+	duration := time.Duration(tk.config["settings.ingest_lag.duration"].Uint64()) * time.Second
+
+	// lag is expected while an index is being built.
+	if threshold == 0 || lag <= threshold || inst.State != common.INDEX_STATE_ACTIVE {
+		since, alerted := tk.lagSince[inst.InstId], tk.lagAlerted[inst.InstId]
+		delete(tk.lagSince, inst.InstId)
+		delete(tk.lagAlerted, inst.InstId)
+		if alerted {
+			logging.Infof("Timekeeper::checkIngestLag Index %v %v:%v lag %v recovered",
+				inst.InstId, inst.Defn.Bucket, inst.Defn.Name, lag)
+			return &MsgTKIngestLag{indexInst: inst, lag: lag, since: since, cleared: true}
+		}
+		return nil
+	}
+
+	since, ok := tk.lagSince[inst.InstId]
+	if !ok {
+		since = now
+		tk.lagSince[inst.InstId] = since
+	}
+	if !tk.lagAlerted[inst.InstId] && now.Sub(since) >= duration {
+		tk.lagAlerted[inst.InstId] = true
+		logging.Warnf("Timekeeper::checkIngestLag Index %v %v:%v lag %v above "+
+			"threshold %v since %v", inst.InstId, inst.Defn.Bucket, inst.Defn.Name,
+			lag, threshold, since)
+		return &MsgTKIngestLag{indexInst: inst, lag: lag, since: since}
+	}
+	return nil
+}
+
 func (tk *timekeeper) updateTimestampStats() {
 
 	tk.lock.Lock()
@@ -2938,7 +3165,7 @@ func (tk *timekeeper) startTimer(streamId common.StreamId,
 
 	logging.Infof("Timekeeper::startTimer %v %v", streamId, bucket)
 
-	snapInterval := tk.getInMemSnapInterval()
+	snapInterval := tk.getStreamInMemSnapInterval(streamId)
 	ticker := time.NewTicker(time.Millisecond * time.Duration(snapInterval))
 	stopCh := tk.ss.streamBucketTimerStopCh[streamId][bucket]
 
@@ -3168,6 +3395,36 @@ func (tk *timekeeper) getInMemSnapInterval() uint64 {
 
 }
 
+//getStreamPersistInterval returns the persisted snapshot interval of the
+//stream. INIT_STREAM can have its own interval, so that long index builds
+//have more frequent restart points than MAINT_STREAM.
+func (tk *timekeeper) getStreamPersistInterval(streamId common.StreamId,
+	initBuild bool) uint64 {
+
+	if streamId == common.INIT_STREAM {
+		if interval := tk.config["settings.persisted_snapshot.init_stream.interval"].Uint64(); interval != 0 {
+			return interval
+		}
+	}
+
+	if initBuild {
+		return tk.getPersistIntervalInitBuild()
+	}
+	return tk.getPersistInterval()
+}
+
+//getStreamInMemSnapInterval returns the interval of stability timestamps
+//of the stream.
+func (tk *timekeeper) getStreamInMemSnapInterval(streamId common.StreamId) uint64 {
+
+	if streamId == common.INIT_STREAM {
+		if interval := tk.config["settings.inmemory_snapshot.init_stream.interval"].Uint64(); interval != 0 {
+			return interval
+		}
+	}
+	return tk.getInMemSnapInterval()
+}
+
 func (tk *timekeeper) setNeedsCommit(streamId common.StreamId,
 	bucket string, flushTs *common.TsVbuuid) {
 
@@ -0,0 +1,258 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCheckIngestLag(t *testing.T) {
+	tk := &timekeeper{
+		config: common.Config{
+			"settings.ingest_lag.threshold": common.ConfigValue{Value: uint64(100)},
+			"settings.ingest_lag.duration":  common.ConfigValue{Value: uint64(60)},
+		},
+		lagSince:   make(map[common.IndexInstId]time.Time),
+		lagAlerted: make(map[common.IndexInstId]bool),
+	}
+	inst := common.IndexInst{InstId: 1, State: common.INDEX_STATE_ACTIVE}
+	now := time.Now()
+
+	if msg := tk.checkIngestLag(inst, 200, now); msg != nil {
+		t.Fatalf("Unexpected event before lag is sustained")
+	}
+	if msg := tk.checkIngestLag(inst, 200, now.Add(30*time.Second)); msg != nil {
+		t.Fatalf("Unexpected event before lag is sustained")
+	}
+	msg := tk.checkIngestLag(inst, 300, now.Add(60*time.Second))
+	if msg == nil || msg.IsCleared() || msg.GetLag() != 300 || !msg.GetSince().Equal(now) {
+		t.Fatalf("Expected lag event, received %+v", msg)
+	}
+	if msg := tk.checkIngestLag(inst, 300, now.Add(90*time.Second)); msg != nil {
+		t.Fatalf("Unexpected repeated event %+v", msg)
+	}
+	msg = tk.checkIngestLag(inst, 50, now.Add(100*time.Second))
+	if msg == nil || !msg.IsCleared() {
+		t.Fatalf("Expected cleared event, received %+v", msg)
+	}
+	if msg := tk.checkIngestLag(inst, 50, now.Add(110*time.Second)); msg != nil {
+		t.Fatalf("Unexpected event after recovery %+v", msg)
+	}
+
+	// lag is expected while an index is being built.
+	inst.State = common.INDEX_STATE_INITIAL
+	tk.checkIngestLag(inst, 1000, now)
+	if msg := tk.checkIngestLag(inst, 1000, now.Add(time.Hour)); msg != nil {
+		t.Fatalf("Unexpected event for index in initial build %+v", msg)
+	}
+}
+
+func TestCheckPersistThreshold(t *testing.T) {
+	ss := InitStreamState(common.Config{
+		"numVbuckets": common.ConfigValue{Value: 4},
+		"settings.persisted_snapshot.mutation_threshold": common.ConfigValue{Value: uint64(100)},
+		"settings.persisted_snapshot.bytes_threshold":    common.ConfigValue{Value: uint64(1000)},
+	})
+	ss.initNewStream(common.MAINT_STREAM)
+	ss.initBucketInStream(common.MAINT_STREAM, "default")
+
+	ts := common.NewTsVbuuid("default", 4)
+	if ss.checkPersistThreshold(common.MAINT_STREAM, "default", ts, 0) {
+		t.Fatalf("Unexpected persist on first snapshot")
+	}
+
+	ts.Seqnos[0], ts.Seqnos[1] = 50, 40
+	if ss.checkPersistThreshold(common.MAINT_STREAM, "default", ts, 500) {
+		t.Fatalf("Unexpected persist below thresholds")
+	}
+	ts.Seqnos[2] = 10
+	if !ss.checkPersistThreshold(common.MAINT_STREAM, "default", ts, 500) {
+		t.Fatalf("Expected persist at mutation threshold")
+	}
+
+	ss.setLastPersist(common.MAINT_STREAM, "default", ts, 500)
+	if ss.checkPersistThreshold(common.MAINT_STREAM, "default", ts, 1000) {
+		t.Fatalf("Unexpected persist below thresholds")
+	}
+	if !ss.checkPersistThreshold(common.MAINT_STREAM, "default", ts, 1500) {
+		t.Fatalf("Expected persist at bytes threshold")
+	}
+}
+
+func TestAckSnapshotTs(t *testing.T) {
+	ss := InitStreamState(common.Config{
+		"numVbuckets":                  common.ConfigValue{Value: 4},
+		"settings.stream_ack.interval": common.ConfigValue{Value: uint64(5000)},
+	})
+	clock := common.NewFakeClock(time.Now())
+	ss.clock = clock
+	streamId, bucket := common.MAINT_STREAM, "default"
+	ss.initNewStream(streamId)
+	ss.initBucketInStream(streamId, bucket)
+	tk := &timekeeper{ss: ss, supvRespch: make(MsgChannel, 1)}
+
+	acked := func() *MsgAckTimestamps {
+		select {
+		case msg := <-tk.supvRespch:
+			return msg.(*MsgAckTimestamps)
+		default:
+			return nil
+		}
+	}
+
+	ts := common.NewTsVbuuid(bucket, 4)
+	ts.Seqnos[0] = 10
+	ts.SetSnapType(common.INMEM_SNAP)
+	tk.ackSnapshotTs(streamId, bucket, ts)
+	if msg := acked(); msg == nil || msg.GetAckTs().Seqnos[0] != 10 {
+		t.Fatalf("Expected in-memory snapshot to be acknowledged, got %v", msg)
+	}
+
+	// at most one ack per interval.
+	ts.Seqnos[0] = 20
+	clock.Advance(time.Second)
+	tk.ackSnapshotTs(streamId, bucket, ts)
+	if msg := acked(); msg != nil {
+		t.Fatalf("Unexpected ack within interval %v", msg)
+	}
+
+	// flushes without a snapshot are not acknowledged.
+	clock.Advance(5 * time.Second)
+	ts.SetSnapType(common.NO_SNAP)
+	tk.ackSnapshotTs(streamId, bucket, ts)
+	if msg := acked(); msg != nil {
+		t.Fatalf("Unexpected ack without snapshot %v", msg)
+	}
+	ts.SetSnapType(common.DISK_SNAP)
+	tk.ackSnapshotTs(streamId, bucket, ts)
+	if msg := acked(); msg == nil || msg.GetAckTs().Seqnos[0] != 20 {
+		t.Fatalf("Expected persisted snapshot to be acknowledged, got %v", msg)
+	}
+}
+
+func TestVbQuarantineRetry(t *testing.T) {
+	ss := InitStreamState(common.Config{
+		"numVbuckets":                      common.ConfigValue{Value: 4},
+		"settings.stream.vb_repair_budget": common.ConfigValue{Value: 2},
+	})
+	clock := common.NewFakeClock(time.Now())
+	ss.clock = clock
+	streamId, bucket := common.MAINT_STREAM, "default"
+	ss.initNewStream(streamId)
+	ss.initBucketInStream(streamId, bucket)
+	defer ss.cleanupBucketFromStream(streamId, bucket)
+	ss.streamBucketVbStatusMap[streamId][bucket][1] = VBS_STREAM_END
+
+	for i := 0; i < 2; i++ {
+		if q, r := ss.countVbRepairs(streamId, bucket); len(q) != 0 || len(r) != 0 {
+			t.Fatalf("Unexpected quarantine within budget %v %v", q, r)
+		}
+	}
+	if q, _ := ss.countVbRepairs(streamId, bucket); len(q) != 1 || q[0] != 1 {
+		t.Fatalf("Expected vbucket 1 to be quarantined, got %v", q)
+	}
+	if !ss.isVbQuarantined(streamId, bucket, 1) || ss.isVbQuarantined(streamId, bucket, 0) {
+		t.Fatalf("Expected only vbucket 1 to be quarantined")
+	}
+	if q, r := ss.countVbRepairs(streamId, bucket); len(q) != 0 || len(r) != 0 {
+		t.Fatalf("Unexpected retry before backoff %v %v", q, r)
+	}
+
+	// retried once the backoff has passed, with a doubled backoff.
+	clock.Advance(VB_QUARANTINE_RETRY_MIN)
+	if ss.isVbQuarantined(streamId, bucket, 1) {
+		t.Fatalf("Expected vbucket 1 to be due for retry")
+	}
+	q, r := ss.countVbRepairs(streamId, bucket)
+	if len(q) != 0 || len(r) != 1 || r[0] != 1 {
+		t.Fatalf("Expected vbucket 1 to be retried, got %v %v", q, r)
+	}
+	next := ss.backoffVbRetries(streamId, bucket, r)
+	if expected := clock.Now().Add(2 * VB_QUARANTINE_RETRY_MIN); !next.Equal(expected) {
+		t.Fatalf("Expected next retry at %v, got %v", expected, next)
+	}
+	if !ss.isVbQuarantined(streamId, bucket, 1) {
+		t.Fatalf("Expected vbucket 1 to be quarantined till the next retry")
+	}
+
+	// StreamBegin clears the quarantine.
+	if !ss.clearVbRepairCount(streamId, bucket, 1) {
+		t.Fatalf("Expected vbucket 1 to have been quarantined")
+	}
+	if ss.isVbOverBudget(streamId, bucket, 1) || len(ss.getQuarantinedVbs(streamId, bucket)) != 0 {
+		t.Fatalf("Expected no quarantined vbuckets after StreamBegin")
+	}
+	if ss.clearVbRepairCount(streamId, bucket, 1) {
+		t.Fatalf("Unexpected quarantine after StreamBegin")
+	}
+}
+
+func TestVbRetryBackoff(t *testing.T) {
+	if b := vbRetryBackoff(0); b != VB_QUARANTINE_RETRY_MIN {
+		t.Fatalf("Expected %v, got %v", VB_QUARANTINE_RETRY_MIN, b)
+	}
+	if b := vbRetryBackoff(3); b != 8*VB_QUARANTINE_RETRY_MIN {
+		t.Fatalf("Expected %v, got %v", 8*VB_QUARANTINE_RETRY_MIN, b)
+	}
+	if b := vbRetryBackoff(100); b != VB_QUARANTINE_RETRY_MAX {
+		t.Fatalf("Expected %v, got %v", VB_QUARANTINE_RETRY_MAX, b)
+	}
+}
+
+func TestStreamSnapshotIntervals(t *testing.T) {
+	config := common.Config{
+		"numVbuckets":                                         common.ConfigValue{Value: 4},
+		"settings.persisted_snapshot.interval":                common.ConfigValue{Value: uint64(600000)},
+		"settings.persisted_snapshot.fdb.interval":            common.ConfigValue{Value: uint64(600000)},
+		"settings.persisted_snapshot.moi.interval":            common.ConfigValue{Value: uint64(600000)},
+		"settings.persisted_snapshot_init_build.fdb.interval": common.ConfigValue{Value: uint64(300000)},
+		"settings.persisted_snapshot_init_build.moi.interval": common.ConfigValue{Value: uint64(300000)},
+		"settings.inmemory_snapshot.fdb.interval":             common.ConfigValue{Value: uint64(200)},
+		"settings.inmemory_snapshot.moi.interval":             common.ConfigValue{Value: uint64(200)},
+		"settings.persisted_snapshot.init_stream.interval":    common.ConfigValue{Value: uint64(0)},
+		"settings.inmemory_snapshot.init_stream.interval":     common.ConfigValue{Value: uint64(0)},
+	}
+	tk := &timekeeper{config: config}
+
+	// without intervals of INIT_STREAM, those of the storage mode apply
+	for _, streamId := range []common.StreamId{common.MAINT_STREAM, common.INIT_STREAM} {
+		if interval := tk.getStreamPersistInterval(streamId, false); interval != 600000 {
+			t.Errorf("%v: expected persist interval 600000, got %v", streamId, interval)
+		}
+		if interval := tk.getStreamPersistInterval(streamId, true); interval != 300000 {
+			t.Errorf("%v: expected init build interval 300000, got %v", streamId, interval)
+		}
+		if interval := tk.getStreamInMemSnapInterval(streamId); interval != 200 {
+			t.Errorf("%v: expected snapshot interval 200, got %v", streamId, interval)
+		}
+	}
+
+	config.SetValue("settings.persisted_snapshot.init_stream.interval", uint64(50))
+	config.SetValue("settings.inmemory_snapshot.init_stream.interval", uint64(20))
+	if tk.getStreamPersistInterval(common.INIT_STREAM, false) != 50 ||
+		tk.getStreamPersistInterval(common.INIT_STREAM, true) != 50 ||
+		tk.getStreamInMemSnapInterval(common.INIT_STREAM) != 20 {
+		t.Errorf("Expected intervals of INIT_STREAM")
+	}
+	if tk.getStreamPersistInterval(common.MAINT_STREAM, false) != 600000 ||
+		tk.getStreamPersistInterval(common.MAINT_STREAM, true) != 300000 ||
+		tk.getStreamInMemSnapInterval(common.MAINT_STREAM) != 200 {
+		t.Errorf("Unexpected intervals of MAINT_STREAM")
+	}
+
+	// commit of INIT_STREAM becomes overdue at its own interval
+	ss := InitStreamState(config)
+	for _, streamId := range []common.StreamId{common.MAINT_STREAM, common.INIT_STREAM} {
+		ss.initNewStream(streamId)
+		ss.initBucketInStream(streamId, "default")
+		ss.streamBucketNeedsCommitMap[streamId]["default"] = true
+		ss.streamBucketLastPersistTime[streamId]["default"] = time.Now().Add(-time.Second)
+	}
+	if ss.checkCommitOverdue(common.MAINT_STREAM, "default") {
+		t.Errorf("Unexpected commit overdue for MAINT_STREAM")
+	}
+	if !ss.checkCommitOverdue(common.INIT_STREAM, "default") {
+		t.Errorf("Expected commit overdue for INIT_STREAM")
+	}
+}
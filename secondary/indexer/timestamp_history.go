@@ -0,0 +1,239 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  stability timestamp history
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// Stability timestamps sent by the timekeeper for flush are recorded
+// per stream and bucket, for a window of time given by
+// indexer.settings.ts_history.window. A timestamp is recorded at most
+// once every indexer.settings.ts_history.interval, as stability
+// timestamps are generated every few milliseconds. The history is kept
+// in memory, and is lost on restart. Both settings can be changed at
+// runtime, disabling the history forgets the timestamps recorded.
+//
+// getTimestampAt() returns the timestamp of a stream and bucket at a
+// point in time, the latest one recorded at or before that time. The
+// history is served on /stabilityTimestamp for post-incident analysis
+// of what indexes had caught up with, it is not used to pin scans.
+//
+
+type tsHistoryEntry struct {
+	time time.Time
+	ts   *common.TsVbuuid
+}
+
+type tsHistory struct {
+	mu       sync.RWMutex
+	clock    common.Clock
+	window   time.Duration
+	interval time.Duration
+	entries  map[common.StreamId]map[string][]tsHistoryEntry // sorted by time
+}
+
+func newTsHistory(config common.Config, clock common.Clock) *tsHistory {
+	h := &tsHistory{
+		clock:   clock,
+		entries: make(map[common.StreamId]map[string][]tsHistoryEntry),
+	}
+	h.setConfig(config)
+	return h
+}
+
+func (h *tsHistory) setConfig(config common.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.window = time.Duration(config["settings.ts_history.window"].Uint64()) * time.Second
+	h.interval = time.Duration(config["settings.ts_history.interval"].Uint64()) * time.Millisecond
+	if h.window == 0 {
+		h.entries = make(map[common.StreamId]map[string][]tsHistoryEntry)
+	} else {
+		h.prune(h.clock.Now())
+	}
+}
+
+// add records the timestamp, if the history is enabled and the interval
+// has elapsed since the last timestamp of the stream and bucket.
+func (h *tsHistory) add(streamId common.StreamId, bucket string, ts *common.TsVbuuid) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.window == 0 {
+		return
+	}
+
+	now := h.clock.Now()
+	buckets, ok := h.entries[streamId]
+	if !ok {
+		buckets = make(map[string][]tsHistoryEntry)
+		h.entries[streamId] = buckets
+	}
+
+	entries := buckets[bucket]
+	if n := len(entries); n != 0 && now.Sub(entries[n-1].time) < h.interval {
+		return
+	}
+	buckets[bucket] = append(entries, tsHistoryEntry{time: now, ts: ts.Copy()})
+
+	h.prune(now)
+}
+
+// prune forgets timestamps older than the window.
+func (h *tsHistory) prune(now time.Time) {
+	for streamId, buckets := range h.entries {
+		for bucket, entries := range buckets {
+			i := 0
+			for i < len(entries) && now.Sub(entries[i].time) > h.window {
+				i++
+			}
+			if i == len(entries) {
+				delete(buckets, bucket)
+			} else if i > 0 {
+				buckets[bucket] = append([]tsHistoryEntry(nil), entries[i:]...)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(h.entries, streamId)
+		}
+	}
+}
+
+// getTimestampAt returns the stability timestamp of the stream and
+// bucket at time t, nil if there is none in the history.
+func (h *tsHistory) getTimestampAt(streamId common.StreamId, bucket string,
+	t time.Time) (*common.TsVbuuid, time.Time) {
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	entries := h.entries[streamId][bucket]
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].time.After(t)
+	})
+	if i == 0 {
+		return nil, time.Time{}
+	}
+	return entries[i-1].ts.Copy(), entries[i-1].time
+}
+
+// tsHistoryResponse is a stability timestamp of the history, as served
+// on /stabilityTimestamp.
+type tsHistoryResponse struct {
+	Stream   string    `json:"stream"`
+	Bucket   string    `json:"bucket"`
+	Time     time.Time `json:"time"`
+	SnapType string    `json:"snapType"`
+	Seqnos   []uint64  `json:"seqnos"`
+	Vbuuids  []uint64  `json:"vbuuids"`
+}
+
+func parseStreamId(s string) (common.StreamId, error) {
+	for _, streamId := range []common.StreamId{common.MAINT_STREAM,
+		common.CATCHUP_STREAM, common.INIT_STREAM} {
+		if s == streamId.String() {
+			return streamId, nil
+		}
+	}
+	return common.NIL_STREAM, fmt.Errorf("Invalid stream %v", s)
+}
+
+// handleTsHistoryReq returns the stability timestamp of the stream and
+// bucket at the given time, now if no time is given. The time is in
+// RFC3339 format.
+func (tk *timekeeper) handleTsHistoryReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	bucket := r.FormValue("bucket")
+	if bucket == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("Missing bucket\n"))
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+	if !common.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	streamId := common.MAINT_STREAM
+	if stream := r.FormValue("stream"); stream != "" {
+		if streamId, err = parseStreamId(stream); err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+	}
+
+	at := time.Now()
+	if t := r.FormValue("time"); t != "" {
+		if at, err = time.Parse(time.RFC3339, t); err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+	}
+
+	ts, tsTime := tk.tsHistory.getTimestampAt(streamId, bucket, at)
+	if ts == nil {
+		w.WriteHeader(404)
+		w.Write([]byte("Stability timestamp not found\n"))
+		return
+	}
+
+	buf, err := json.Marshal(&tsHistoryResponse{
+		Stream:   streamId.String(),
+		Bucket:   bucket,
+		Time:     tsTime,
+		SnapType: ts.GetSnapType().String(),
+		Seqnos:   ts.Seqnos,
+		Vbuuids:  ts.Vbuuids,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("%v\n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(buf)
+}
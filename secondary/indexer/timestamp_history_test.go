@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestTsHistory(window, interval uint64) (*tsHistory, *common.FakeClock) {
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("settings.ts_history.window", window)
+	config.SetValue("settings.ts_history.interval", interval)
+	clock := common.NewFakeClock(time.Now())
+	return newTsHistory(config, clock), clock
+}
+
+func testHistoryTs(seqno uint64) *common.TsVbuuid {
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos[0] = seqno
+	return ts
+}
+
+func TestTsHistoryLookup(t *testing.T) {
+	h, clock := newTestTsHistory(60, 1000)
+	start := clock.Now()
+
+	h.add(common.MAINT_STREAM, "default", testHistoryTs(10))
+	clock.Advance(500 * time.Millisecond)
+	h.add(common.MAINT_STREAM, "default", testHistoryTs(15)) // within interval
+	clock.Advance(time.Second)
+	h.add(common.MAINT_STREAM, "default", testHistoryTs(20))
+
+	testcases := []struct {
+		at    time.Duration
+		seqno uint64
+	}{
+		{-time.Second, 0},
+		{0, 10},
+		{time.Second, 10},
+		{2 * time.Second, 20},
+	}
+	for _, tc := range testcases {
+		ts, _ := h.getTimestampAt(common.MAINT_STREAM, "default", start.Add(tc.at))
+		if tc.seqno == 0 {
+			if ts != nil {
+				t.Errorf("at %v: expected no timestamp, got %v", tc.at, ts.Seqnos)
+			}
+		} else if ts == nil || ts.Seqnos[0] != tc.seqno {
+			t.Errorf("at %v: expected seqno %v, got %v", tc.at, tc.seqno, ts)
+		}
+	}
+
+	if ts, _ := h.getTimestampAt(common.INIT_STREAM, "default", clock.Now()); ts != nil {
+		t.Errorf("expected no timestamp of INIT_STREAM, got %v", ts.Seqnos)
+	}
+}
+
+func TestTsHistoryWindow(t *testing.T) {
+	h, clock := newTestTsHistory(10, 1000)
+	start := clock.Now()
+
+	h.add(common.MAINT_STREAM, "default", testHistoryTs(10))
+	clock.Advance(11 * time.Second)
+	h.add(common.MAINT_STREAM, "default", testHistoryTs(20))
+	if ts, _ := h.getTimestampAt(common.MAINT_STREAM, "default", start); ts != nil {
+		t.Errorf("expected timestamp older than window to be pruned, got %v", ts.Seqnos)
+	}
+
+	// shrinking the window prunes the history.
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("settings.ts_history.window", uint64(1))
+	clock.Advance(2 * time.Second)
+	h.setConfig(config)
+	if ts, _ := h.getTimestampAt(common.MAINT_STREAM, "default", clock.Now()); ts != nil {
+		t.Errorf("expected history to be pruned, got %v", ts.Seqnos)
+	}
+
+	// disabling the history forgets it, and stops recording.
+	config.SetValue("settings.ts_history.window", uint64(0))
+	h.setConfig(config)
+	h.add(common.MAINT_STREAM, "default", testHistoryTs(30))
+	if ts, _ := h.getTimestampAt(common.MAINT_STREAM, "default", clock.Now()); ts != nil {
+		t.Errorf("expected no history, got %v", ts.Seqnos)
+	}
+}
@@ -11,6 +11,7 @@ package client
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/couchbase/gometa/common"
 	c "github.com/couchbase/indexing/secondary/common"
 	logging "github.com/couchbase/indexing/secondary/logging"
@@ -46,8 +47,44 @@ const (
 	OPCODE_CREATE_INDEX_DEFER_BUILD                 = OPCODE_REBALANCE_RUNNING + 1
 	OPCODE_DROP_OR_PRUNE_INSTANCE_DDL               = OPCODE_CREATE_INDEX_DEFER_BUILD + 1
 	OPCODE_CLEANUP_PARTITION                        = OPCODE_DROP_OR_PRUNE_INSTANCE_DDL + 1
+	OPCODE_UPDATE_SLICE_LAYOUT                      = OPCODE_CLEANUP_PARTITION + 1
 )
 
+var opCodeNames = map[common.OpCode]string{
+	OPCODE_CREATE_INDEX:               "CreateIndex",
+	OPCODE_DROP_INDEX:                 "DropIndex",
+	OPCODE_BUILD_INDEX:                "BuildIndex",
+	OPCODE_UPDATE_INDEX_INST:          "UpdateIndexInst",
+	OPCODE_SERVICE_MAP:                "ServiceMap",
+	OPCODE_DELETE_BUCKET:              "DeleteBucket",
+	OPCODE_INDEXER_READY:              "IndexerReady",
+	OPCODE_CLEANUP_INDEX:              "CleanupIndex",
+	OPCODE_CLEANUP_DEFER_INDEX:        "CleanupDeferIndex",
+	OPCODE_CREATE_INDEX_REBAL:         "CreateIndexRebal",
+	OPCODE_BUILD_INDEX_REBAL:          "BuildIndexRebal",
+	OPCODE_DROP_INDEX_REBAL:           "DropIndexRebal",
+	OPCODE_BROADCAST_STATS:            "BroadcastStats",
+	OPCODE_BUILD_INDEX_RETRY:          "BuildIndexRetry",
+	OPCODE_RESET_INDEX:                "ResetIndex",
+	OPCODE_CONFIG_UPDATE:              "ConfigUpdate",
+	OPCODE_DROP_OR_PRUNE_INSTANCE:     "DropOrPruneInstance",
+	OPCODE_MERGE_PARTITION:            "MergePartition",
+	OPCODE_PREPARE_CREATE_INDEX:       "PrepareCreateIndex",
+	OPCODE_COMMIT_CREATE_INDEX:        "CommitCreateIndex",
+	OPCODE_REBALANCE_RUNNING:          "RebalanceRunning",
+	OPCODE_CREATE_INDEX_DEFER_BUILD:   "CreateIndexDeferBuild",
+	OPCODE_DROP_OR_PRUNE_INSTANCE_DDL: "DropOrPruneInstanceDDL",
+	OPCODE_CLEANUP_PARTITION:          "CleanupPartition",
+	OPCODE_UPDATE_SLICE_LAYOUT:        "UpdateSliceLayout",
+}
+
+func OpCodeString(op common.OpCode) string {
+	if name, ok := opCodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpCode(%d)", op)
+}
+
 /////////////////////////////////////////////////////////////////////////
 // Index List
 ////////////////////////////////////////////////////////////////////////
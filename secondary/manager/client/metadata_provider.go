@@ -26,6 +26,7 @@ import (
 	"math"
 	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -44,6 +45,7 @@ type Settings interface {
 	NumReplica() int32
 	NumPartition() int32
 	StorageMode() string
+	PreviewSampleSize() int32
 }
 
 ///////////////////////////////////////////////////////
@@ -166,12 +168,32 @@ type IndexerStatus struct {
 	Connected bool
 }
 
+//
+// DryRunResult is the would-be plan of a DDL request run in dry-run
+// mode, after all the validations of the request.  Nothing is committed.
+//
+type DryRunResult struct {
+	Operation  string                `json:"operation"`
+	Definition *c.IndexDefn          `json:"definition"`
+	Placement  []DryRunPlacement     `json:"placement,omitempty"`
+	Estimate   *planner.IndexPreview `json:"estimate,omitempty"`
+	Warnings   []string              `json:"warnings,omitempty"`
+}
+
+type DryRunPlacement struct {
+	ReplicaId  int             `json:"replicaId"`
+	IndexerId  c.IndexerId     `json:"indexerId"`
+	NodeAddr   string          `json:"nodeAddr,omitempty"`
+	Partitions []c.PartitionId `json:"partitions,omitempty"`
+}
+
 type watcherCallback func(string, c.IndexerId, c.IndexerId)
 
 var REQUEST_CHANNEL_COUNT = 1000
 
 var VALID_PARAM_NAMES = []string{"nodes", "defer_build", "retain_deleted_xattr",
-	"num_partition", "num_replica", "docKeySize", "secKeySize", "arrSize", "numDoc", "residentRatio"}
+	"num_partition", "num_replica", "docKeySize", "secKeySize", "arrSize", "numDoc", "residentRatio",
+	"eval_error_policy", "doc_meta", "include_missing"}
 
 ///////////////////////////////////////////////////////
 // Public function : MetadataProvider
@@ -1019,6 +1041,9 @@ func (o *MetadataProvider) PrepareIndexDefn(
 	var numReplica int = 0
 	var numPartition int = 0
 	var retainDeletedXATTR = false
+	var evalErrorPolicy = c.EvalErrorSkip
+	var docMeta = false
+	var includeMissing = false
 	var numDoc uint64 = 0
 	var secKeySize uint64 = 0
 	var docKeySize uint64 = 0
@@ -1079,6 +1104,33 @@ func (o *MetadataProvider) PrepareIndexDefn(
 				false
 		}
 
+		evalErrorPolicy, err, retry = o.getEvalErrorPolicyParam(plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		docMeta, err, retry = o.getDocMetaParam(plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		if docMeta && !isPrimary {
+			return nil,
+				errors.New("Fails to create index.  doc_meta can be used only for primary index."),
+				false
+		}
+
+		includeMissing, err, retry = o.getIncludeMissingParam(plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		if includeMissing && isPrimary {
+			return nil,
+				errors.New("Fails to create index.  include_missing cannot be used for primary index."),
+				false
+		}
+
 		if indexType, ok := plan["index_type"].(string); ok {
 			if c.IsValidIndexType(indexType) {
 				using = indexType
@@ -1089,6 +1141,18 @@ func (o *MetadataProvider) PrepareIndexDefn(
 			}
 		}
 
+		if docMeta {
+			storageMode := c.IndexTypeToStorageMode(c.IndexType(using))
+			if storageMode == c.NOT_SET {
+				storageMode = c.IndexTypeToStorageMode(c.IndexType(o.settings.StorageMode()))
+			}
+			if storageMode != c.FORESTDB {
+				return nil,
+					errors.New("Fails to create index.  doc_meta is supported only with forestdb storage."),
+					false
+			}
+		}
+
 		if len(partitionKeys) != 0 {
 			if clusterVersion < c.INDEXER_55_VERSION {
 				return nil,
@@ -1222,6 +1286,9 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		HashScheme:         c.CRC32,
 		NumPartitions:      uint32(numPartition),
 		RetainDeletedXATTR: retainDeletedXATTR,
+		EvalErrorPolicy:    evalErrorPolicy,
+		DocMeta:            docMeta,
+		IncludeMissing:     includeMissing,
 		NumDoc:             numDoc,
 		SecKeySize:         secKeySize,
 		DocKeySize:         docKeySize,
@@ -1232,6 +1299,20 @@ func (o *MetadataProvider) PrepareIndexDefn(
 	return idxDefn, nil, false
 }
 
+//
+// PreviewIndex estimates the number of entries and the size of the index
+// before it is created, from a sample of documents in the bucket.
+//
+func (o *MetadataProvider) PreviewIndex(defn *c.IndexDefn) (*planner.IndexPreview, error) {
+
+	sampleSize := int(o.settings.PreviewSampleSize())
+	if sampleSize <= 0 {
+		return nil, errors.New("Index preview is disabled")
+	}
+
+	return planner.PreviewIndex(o.clusterUrl, defn, o.settings.StorageMode(), sampleSize)
+}
+
 func (o *MetadataProvider) plan(defn *c.IndexDefn, plan map[string]interface{},
 	watcherMap map[c.IndexerId]int) (map[int]map[c.IndexerId][]c.PartitionId, error) {
 
@@ -1252,6 +1333,8 @@ func (o *MetadataProvider) plan(defn *c.IndexDefn, plan map[string]interface{},
 	spec.PartitionKeys = defn.PartitionKeys
 	spec.Replica = uint64(defn.NumReplica) + 1
 	spec.RetainDeletedXATTR = defn.RetainDeletedXATTR
+	spec.DocMeta = defn.DocMeta
+	spec.IncludeMissing = defn.IncludeMissing
 	spec.ExprType = string(defn.ExprType)
 
 	spec.NumDoc = defn.NumDoc
@@ -1286,6 +1369,20 @@ func (o *MetadataProvider) plan(defn *c.IndexDefn, plan map[string]interface{},
 	// 4) if cluster storage mode is not available, then ignore sizing input.
 	spec.Using = o.settings.StorageMode()
 
+	// If user does not specify sizing, then estimate it from a sample of
+	// the bucket, so that the planner can check against memory quota.
+	if spec.NumDoc == 0 && o.settings.PreviewSampleSize() > 0 {
+		if preview, err := o.PreviewIndex(defn); err == nil {
+			spec.NumDoc = preview.NumIndexed
+			spec.DocKeySize = preview.AvgDocKeySize
+			spec.SecKeySize = preview.AvgSecKeySize
+			spec.ArrKeySize = preview.AvgArrKeySize
+			spec.ArrSize = preview.AvgArrSize
+		} else {
+			logging.Warnf("MetadataProvider.plan(): Fail to estimate size of index %v: %v", defn.Name, err)
+		}
+	}
+
 	solution, err := planner.ExecutePlan(o.clusterUrl, []*planner.IndexSpec{&spec}, nodes, len(defn.Nodes) != 0)
 	if err != nil {
 		return nil, err
@@ -1431,6 +1528,75 @@ func (o *MetadataProvider) getXATTRParam(plan map[string]interface{}) (bool, err
 	return xattr, nil, false
 }
 
+func (o *MetadataProvider) getDocMetaParam(plan map[string]interface{}) (bool, error, bool) {
+
+	docMeta := false
+
+	docMeta2, ok := plan["doc_meta"].(bool)
+	if !ok {
+		docMeta_str, ok := plan["doc_meta"].(string)
+		if ok {
+			var err error
+			docMeta2, err = strconv.ParseBool(docMeta_str)
+			if err != nil {
+				return false, errors.New("Fails to create index.  Parameter doc_meta must be a boolean value of (true or false)."), false
+			}
+			docMeta = docMeta2
+
+		} else if _, ok := plan["doc_meta"]; ok {
+			return false, errors.New("Fails to create index.  Parameter doc_meta must be a boolean value of (true or false)."), false
+		}
+	} else {
+		docMeta = docMeta2
+	}
+
+	return docMeta, nil, false
+}
+
+func (o *MetadataProvider) getIncludeMissingParam(plan map[string]interface{}) (bool, error, bool) {
+
+	includeMissing := false
+
+	includeMissing2, ok := plan["include_missing"].(bool)
+	if !ok {
+		includeMissing_str, ok := plan["include_missing"].(string)
+		if ok {
+			var err error
+			includeMissing2, err = strconv.ParseBool(includeMissing_str)
+			if err != nil {
+				return false, errors.New("Fails to create index.  Parameter include_missing must be a boolean value of (true or false)."), false
+			}
+			includeMissing = includeMissing2
+
+		} else if _, ok := plan["include_missing"]; ok {
+			return false, errors.New("Fails to create index.  Parameter include_missing must be a boolean value of (true or false)."), false
+		}
+	} else {
+		includeMissing = includeMissing2
+	}
+
+	return includeMissing, nil, false
+}
+
+func (o *MetadataProvider) getEvalErrorPolicyParam(plan map[string]interface{}) (c.EvalErrorPolicy, error, bool) {
+
+	if _, ok := plan["eval_error_policy"]; !ok {
+		return c.EvalErrorSkip, nil, false
+	}
+
+	policy_str, ok := plan["eval_error_policy"].(string)
+	if !ok {
+		return c.EvalErrorSkip, errors.New("Fails to create index.  Parameter eval_error_policy must be one of (skip, index_null, fail)."), false
+	}
+
+	policy, err := c.ParseEvalErrorPolicy(policy_str)
+	if err != nil {
+		return c.EvalErrorSkip, errors.New("Fails to create index.  Parameter eval_error_policy must be one of (skip, index_null, fail)."), false
+	}
+
+	return policy, nil, false
+}
+
 func (o *MetadataProvider) getDeferredParam(plan map[string]interface{}) (bool, error, bool) {
 
 	deferred := false
@@ -1795,13 +1961,23 @@ RETRY1:
 				watchers = nil
 				errCode = 2
 				break
-			} else {
-				watchers = append(watchers, watcher)
 			}
+			// replicas must be placed on distinct nodes, even if the
+			// nodes are given by different addresses of the same node.
+			for _, w := range watchers {
+				if w == watcher {
+					errCode = 4
+				}
+			}
+			if errCode == 4 {
+				watchers = nil
+				break
+			}
+			watchers = append(watchers, watcher)
 		}
 	}
 
-	if errCode != 0 && count < 20 && !o.AllWatchersAlive() {
+	if errCode != 0 && errCode != 4 && count < 20 && !o.AllWatchersAlive() {
 		logging.Debugf("MetadataProvider:findWatcherWithRetry(): cannot find available watcher. Retrying ...")
 		time.Sleep(time.Duration(500) * time.Millisecond)
 		count++
@@ -1822,6 +1998,10 @@ RETRY1:
 		stmt1 := "Fails to create index.  There are not enough indexer nodes to create index with replica count of %v. "
 		stmt2 := stmt1 + "Some indexer nodes may be marked as excluded."
 		return nil, errors.New(fmt.Sprintf(stmt2, numReplica)), true
+
+	} else if errCode == 4 {
+		stmt1 := "Fails to create index.  Nodes %s contain the same node more than once"
+		return nil, errors.New(fmt.Sprintf(stmt1, nodes)), true
 	}
 
 	return watchers, nil, false
@@ -1879,6 +2059,170 @@ func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId) error {
 	return nil
 }
 
+///////////////////////////////////////////////////////
+// Dry Run
+///////////////////////////////////////////////////////
+
+//
+// DryRunCreateIndexWithPlan validates a create index request like
+// CreateIndexWithPlan, and returns the placement of the planner, without
+// creating the index.
+//
+func (o *MetadataProvider) DryRunCreateIndexWithPlan(
+	name, bucket, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme c.PartitionScheme, partitionKeys []string,
+	plan map[string]interface{}) (*DryRunResult, error) {
+
+	// FindIndexByName will only return valid index
+	if o.findIndexByName(name, bucket) != nil {
+		return nil, errors.New(fmt.Sprintf("Index %s already exists.", name))
+	}
+
+	idxDefn, err, _ := o.PrepareIndexDefn(name, bucket, using, exprType, whereExpr, secExprs, desc,
+		isPrimary, scheme, partitionKeys, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.dryRunCreateIndex(idxDefn, plan)
+}
+
+//
+// dryRunCreateIndex runs the prepare and plan phases of create index for
+// a prepared index definition, including size estimation and equivalent
+// index checks.  The prepare request is always canceled, so indexers
+// reject other create index requests only while the dry run is planned.
+//
+func (o *MetadataProvider) dryRunCreateIndex(idxDefn *c.IndexDefn, plan map[string]interface{}) (*DryRunResult, error) {
+
+	result := &DryRunResult{Operation: "create", Definition: idxDefn}
+
+	if idxDefn.NumDoc == 0 && o.settings.PreviewSampleSize() > 0 {
+		if preview, err := o.PreviewIndex(idxDefn); err == nil {
+			result.Estimate = preview
+			// the planner uses the estimate, without sampling again
+			idxDefn.NumDoc = preview.NumIndexed
+			idxDefn.DocKeySize = preview.AvgDocKeySize
+			idxDefn.SecKeySize = preview.AvgSecKeySize
+			idxDefn.ArrSize = preview.AvgArrSize
+		} else {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Fail to estimate index size: %v", err))
+		}
+	}
+
+	var layout map[int]map[c.IndexerId][]c.PartitionId
+
+	if o.GetClusterVersion() >= c.INDEXER_55_VERSION {
+		watcherMap, err := o.makePrepareIndexRequest(idxDefn)
+		defer o.cancelPrepareIndexRequest(idxDefn, watcherMap)
+		if err != nil {
+			return nil, err
+		}
+
+		layout, err = o.plan(idxDefn, plan, watcherMap)
+		if err != nil && strings.Contains(err.Error(), "Index already exist") {
+			return nil, err
+		}
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Planner error, round robin placement is used: %v", err))
+
+			indexerIds := make([]c.IndexerId, 0, len(watcherMap))
+			for indexerId, _ := range watcherMap {
+				indexerIds = append(indexerIds, indexerId)
+			}
+			layout = o.createLayoutWithRoundRobin(idxDefn, indexerIds)
+		}
+
+	} else {
+		watchers, err, _ := o.findWatchersWithRetry(idxDefn.Nodes, int(idxDefn.NumReplica), c.IsPartitioned(idxDefn.PartitionScheme))
+		if err != nil {
+			return nil, err
+		}
+		if len(watchers) < int(idxDefn.NumReplica)+1 {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Cannot find enough indexer node for replica.  numReplica=%v.", idxDefn.NumReplica))
+		}
+
+		indexerIds := make([]c.IndexerId, 0, len(watchers))
+		for _, watcher := range watchers {
+			indexerIds = append(indexerIds, watcher.getIndexerId())
+		}
+		layout = o.createLayoutWithRoundRobin(idxDefn, indexerIds)
+	}
+
+	result.Placement = layoutToDryRunPlacement(layout, o.getNodeAddrOfIndexer)
+
+	return result, nil
+}
+
+//
+// DryRunDropIndex validates a drop index request and returns the
+// instances to be dropped, without sending any request to the indexers.
+//
+func (o *MetadataProvider) DryRunDropIndex(defnID c.IndexDefnId) (*DryRunResult, error) {
+
+	meta := o.findIndex(defnID)
+	if meta == nil {
+		return nil, errors.New("Index does not exist.")
+	}
+
+	if _, err := o.findWatchersByDefnIdIgnoreStatus(defnID); err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot locate cluster node hosting Index %s.", meta.Definition.Name))
+	}
+
+	layout := make(map[int]map[c.IndexerId][]c.PartitionId)
+	for _, inst := range meta.Instances {
+		replicaId := int(inst.ReplicaId)
+		if _, ok := layout[replicaId]; !ok {
+			layout[replicaId] = make(map[c.IndexerId][]c.PartitionId)
+		}
+		for partnId, indexerId := range inst.IndexerId {
+			layout[replicaId][indexerId] = append(layout[replicaId][indexerId], partnId)
+		}
+	}
+
+	result := &DryRunResult{Operation: "drop", Definition: meta.Definition}
+	result.Placement = layoutToDryRunPlacement(layout, o.getNodeAddrOfIndexer)
+
+	return result, nil
+}
+
+func (o *MetadataProvider) getNodeAddrOfIndexer(indexerId c.IndexerId) string {
+	if watcher, err := o.findWatcherByIndexerId(indexerId); err == nil {
+		return watcher.getNodeAddr()
+	}
+	return ""
+}
+
+//
+// layoutToDryRunPlacement returns the placement of a layout, sorted by
+// replica and indexer, with the node address of each indexer.
+//
+func layoutToDryRunPlacement(layout map[int]map[c.IndexerId][]c.PartitionId,
+	nodeAddr func(c.IndexerId) string) []DryRunPlacement {
+
+	var placement []DryRunPlacement
+	for replicaId, indexerPartitionMap := range layout {
+		for indexerId, partitions := range indexerPartitionMap {
+			partitions = append([]c.PartitionId(nil), partitions...)
+			sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+			placement = append(placement, DryRunPlacement{
+				ReplicaId:  replicaId,
+				IndexerId:  indexerId,
+				NodeAddr:   nodeAddr(indexerId),
+				Partitions: partitions,
+			})
+		}
+	}
+	sort.Slice(placement, func(i, j int) bool {
+		if placement[i].ReplicaId != placement[j].ReplicaId {
+			return placement[i].ReplicaId < placement[j].ReplicaId
+		}
+		return placement[i].IndexerId < placement[j].IndexerId
+	})
+	return placement
+}
+
 func (o *MetadataProvider) BuildIndexes(defnIDs []c.IndexDefnId) error {
 
 	watcherIndexMap := make(map[c.IndexerId][]c.IndexDefnId)
@@ -0,0 +1,86 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestLayoutToDryRunPlacement(t *testing.T) {
+	layout := map[int]map[c.IndexerId][]c.PartitionId{
+		1: {"idx2": {4, 2}, "idx1": {3}},
+		0: {"idx3": {1}},
+	}
+	nodeAddr := func(indexerId c.IndexerId) string {
+		if indexerId == "idx1" {
+			return "host1:8091"
+		}
+		return ""
+	}
+
+	placement := layoutToDryRunPlacement(layout, nodeAddr)
+	expected := []DryRunPlacement{
+		{ReplicaId: 0, IndexerId: "idx3", Partitions: []c.PartitionId{1}},
+		{ReplicaId: 1, IndexerId: "idx1", NodeAddr: "host1:8091", Partitions: []c.PartitionId{3}},
+		{ReplicaId: 1, IndexerId: "idx2", Partitions: []c.PartitionId{2, 4}},
+	}
+	if !reflect.DeepEqual(placement, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, placement)
+	}
+	// the layout is not modified.
+	if parts := layout[1]["idx2"]; parts[0] != 4 {
+		t.Fatalf("Unexpected change to layout %v", parts)
+	}
+}
+
+func TestDryRunParamNotAccepted(t *testing.T) {
+	// dry run is a separate request, so that a create index request
+	// with dry_run in its WITH clause fails instead of creating the index.
+	for _, name := range VALID_PARAM_NAMES {
+		if name == "dry_run" {
+			t.Fatalf("dry_run must not be a create index parameter")
+		}
+	}
+}
+
+func TestFindWatchersDuplicateNode(t *testing.T) {
+	newWatcher := func(nodeAddr string) *watcher {
+		return &watcher{serviceMap: &ServiceMap{NodeAddr: nodeAddr}}
+	}
+	w1, w2 := newWatcher("host1:8091"), newWatcher("host2:8091")
+	o := &MetadataProvider{watchers: map[c.IndexerId]*watcher{"idx1": w1, "idx2": w2}}
+
+	watchers, err, _ := o.findWatchersWithRetry([]string{"host2:8091", "host1:8091"}, 1, false)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(watchers, []*watcher{w2, w1}) {
+		t.Fatalf("Expected watchers of both nodes, got %v", watchers)
+	}
+
+	// the same node given twice, by different addresses, is rejected
+	// at once, instead of waiting for watchers.
+	for _, nodes := range [][]string{
+		{"host1:8091", "host1:8091"},
+		{"host1:8091", "host2:8091", "HOST1:8091"},
+	} {
+		watchers, err, retry := o.findWatchersWithRetry(nodes, len(nodes)-1, false)
+		if err == nil || !strings.Contains(err.Error(), "same node more than once") {
+			t.Errorf("%v: expected duplicate node error, got %v", nodes, err)
+		}
+		if watchers != nil || !retry {
+			t.Errorf("%v: expected no watchers, got %v %v", nodes, watchers, retry)
+		}
+	}
+}
@@ -67,9 +67,11 @@ type IndexKeyPartDistribution struct {
 }
 
 type IndexSliceLocator struct {
-	SliceId   uint64 `json:"sliceId,omitempty"`
-	State     uint32 `json:"state,omitempty"`
-	IndexerId string `json:"indexerId,omitempty"`
+	SliceId   uint64   `json:"sliceId,omitempty"`
+	State     uint32   `json:"state,omitempty"`
+	IndexerId string   `json:"indexerId,omitempty"`
+	Vbuckets  []uint16 `json:"vbuckets,omitempty"` // empty if the slice owns all vbuckets
+	HostPath  string   `json:"hostPath,omitempty"` // storage path of the slice on the indexer
 }
 
 /////////////////////////////////////////////////////////////////////////
@@ -103,6 +105,56 @@ func (inst IndexInstDistribution) FindIndexerId() string {
 	return ""
 }
 
+//
+// Check if the slice owns the vbucket
+//
+func (s IndexSliceLocator) OwnsVbucket(vb uint16) bool {
+
+	if len(s.Vbuckets) == 0 {
+		return true
+	}
+
+	for _, v := range s.Vbuckets {
+		if v == vb {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Find the slice of the partition owning the vbucket
+//
+func (inst IndexInstDistribution) FindSliceByVbucket(partId uint64, vb uint16) *IndexSliceLocator {
+
+	for i, _ := range inst.Partitions {
+		if inst.Partitions[i].PartId == partId {
+			slices := inst.Partitions[i].SinglePartition.Slices
+			for j, _ := range slices {
+				if slices[j].OwnsVbucket(vb) {
+					return &slices[j]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//
+// Get the slice id owning each vbucket of the partition.  Vbuckets not
+// owned by any slice are not in the map.
+//
+func (inst IndexInstDistribution) GetVbucketSliceMap(partId uint64, numVbuckets int) map[uint16]uint64 {
+
+	result := make(map[uint16]uint64)
+	for vb := 0; vb < numVbuckets; vb++ {
+		if slice := inst.FindSliceByVbucket(partId, uint16(vb)); slice != nil {
+			result[uint16(vb)] = slice.SliceId
+		}
+	}
+	return result
+}
+
 func (t *IndexTopology) GetIndexInstancesByDefn(defnId c.IndexDefnId) []IndexInstDistribution {
 
 	for i, _ := range t.Definitions {
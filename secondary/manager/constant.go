@@ -11,6 +11,7 @@
 package manager
 
 import (
+	"github.com/couchbase/indexing/secondary/common"
 	"time"
 )
 
@@ -59,10 +60,21 @@ const COORDINATOR_CONFIG_STORE = "IndexCoordinatorConfigStore"
 const DEFAULT_EVT_QUEUE_SIZE = 20
 const DEFAULT_NOTIFIER_QUEUE_SIZE = 5
 
+// Metadata Feed
+const METADATA_FEED_SIZE = 1024
+const DEFAULT_METADATA_WATCH_TIMEOUT = time.Duration(60) * time.Second
+const DEFAULT_STATUS_STREAM_PROGRESS = time.Duration(5) * time.Second
+const DEFAULT_STATUS_STREAM_HEARTBEAT = time.Duration(30) * time.Second
+const DEFAULT_META_VERSION_WAIT = time.Duration(30) * time.Second
+
+// Request Journal
+const REQUEST_JOURNAL_NAME = "request_journal"
+const REQUEST_JOURNAL_SIZE = 1000
+
 // Stream Manager
-const MAINT_TOPIC = "MAINT_STREAM_TOPIC"
-const CATCHUP_TOPIC = "CATCHUP_STREAM_TOPIC"
-const INIT_TOPIC = "INIT_STREAM_TOPIC"
+const MAINT_TOPIC = common.MAINT_TOPIC
+const CATCHUP_TOPIC = common.CATCHUP_TOPIC
+const INIT_TOPIC = common.INIT_TOPIC
 
 const MAX_PROJECTOR_RETRY_ELAPSED_TIME = int64(time.Minute) * 5
 
@@ -751,6 +751,36 @@ func (c *Coordinator) getPeerUDPAddr() []string {
 	return c.env.getPeerUDPAddr()
 }
 
+//
+// Add a peer to the coordinator membership.  The new membership takes
+// effect from the next election.
+//
+func (c *Coordinator) addPeer(host string) error {
+
+	c.state.mutex.Lock()
+	defer c.state.mutex.Unlock()
+
+	if c.env == nil {
+		return nil
+	}
+	return c.env.addPeer(host)
+}
+
+//
+// Remove a peer from the coordinator membership.  The new membership takes
+// effect from the next election.
+//
+func (c *Coordinator) removePeer(host string) error {
+
+	c.state.mutex.Lock()
+	defer c.state.mutex.Unlock()
+
+	if c.env == nil {
+		return nil
+	}
+	return c.env.removePeer(host)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 //  Metadata Operations
 /////////////////////////////////////////////////////////////////////////////
@@ -17,6 +17,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 )
 
 type env struct {
@@ -25,6 +26,9 @@ type env struct {
 	hostRequestAddr net.Addr
 	peerUDPAddr     []string
 	peerTCPAddr     []string
+
+	// protects peer addresses, which change with indexer node add or remove
+	mutex sync.RWMutex
 }
 
 type node struct {
@@ -79,14 +83,21 @@ func (e *env) getHostRequestAddr() string {
 }
 
 func (e *env) getPeerUDPAddr() []string {
-	return e.peerUDPAddr
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return append([]string(nil), e.peerUDPAddr...)
 }
 
 func (e *env) getPeerTCPAddr() []string {
-	return e.peerTCPAddr
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return append([]string(nil), e.peerTCPAddr...)
 }
 
 func (e *env) getPeerHost() ([]string, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
 	var result []string = nil
 	for _, addr := range e.peerUDPAddr {
 		host, _, err := net.SplitHostPort(addr)
@@ -107,6 +118,9 @@ func (e *env) getLocalHost() (string, error) {
 }
 
 func (e *env) findMatchingPeerTCPAddr(updAddr string) string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
 	for i := 0; i < len(e.peerUDPAddr); i++ {
 		if e.peerUDPAddr[i] == updAddr {
 			return e.peerTCPAddr[i]
@@ -116,6 +130,9 @@ func (e *env) findMatchingPeerTCPAddr(updAddr string) string {
 }
 
 func (e *env) findMatchingPeerUDPAddr(tcpAddr string) string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
 	for i := 0; i < len(e.peerTCPAddr); i++ {
 		if e.peerTCPAddr[i] == tcpAddr {
 			return e.peerUDPAddr[i]
@@ -263,3 +280,76 @@ func resolveAddr(network string, addr string) (addrObj net.Addr, err error) {
 
 	return addrObj, nil
 }
+
+//
+// Add a peer running on the given host.  The peer is expected to use the
+// same election and message ports as the local host.
+//
+func (e *env) addPeer(host string) error {
+
+	_, tcpPort, err := net.SplitHostPort(e.getHostTCPAddr())
+	if err != nil {
+		return err
+	}
+
+	udpAddr, err := resolveAddr(common.ELECTION_TRANSPORT_TYPE, net.JoinHostPort(host, e.getHostElectionPort()))
+	if err != nil {
+		return err
+	}
+
+	tcpAddr, err := resolveAddr(common.MESSAGE_TRANSPORT_TYPE, net.JoinHostPort(host, tcpPort))
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, addr := range e.peerUDPAddr {
+		if addr == udpAddr.String() {
+			return nil
+		}
+	}
+
+	e.peerUDPAddr = append(e.peerUDPAddr, udpAddr.String())
+	e.peerTCPAddr = append(e.peerTCPAddr, tcpAddr.String())
+	logging.Debugf("Env.addPeer(): Peer UDP Addr %s TCP Addr %s", udpAddr.String(), tcpAddr.String())
+
+	return nil
+}
+
+//
+// Remove the peers running on the given host.
+//
+func (e *env) removePeer(host string) error {
+
+	// peer addresses are resolved, so compare against the resolved host
+	udpAddr, err := resolveAddr(common.ELECTION_TRANSPORT_TYPE, net.JoinHostPort(host, e.getHostElectionPort()))
+	if err != nil {
+		return err
+	}
+	ip, _, err := net.SplitHostPort(udpAddr.String())
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	peerUDPAddr := make([]string, 0, len(e.peerUDPAddr))
+	peerTCPAddr := make([]string, 0, len(e.peerTCPAddr))
+
+	for i, addr := range e.peerUDPAddr {
+		if h, _, err := net.SplitHostPort(addr); err == nil && h == ip {
+			logging.Debugf("Env.removePeer(): Peer UDP Addr %s TCP Addr %s", addr, e.peerTCPAddr[i])
+			continue
+		}
+		peerUDPAddr = append(peerUDPAddr, addr)
+		peerTCPAddr = append(peerTCPAddr, e.peerTCPAddr[i])
+	}
+
+	e.peerUDPAddr = peerUDPAddr
+	e.peerTCPAddr = peerTCPAddr
+
+	return nil
+}
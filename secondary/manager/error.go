@@ -29,6 +29,7 @@ const (
 	ERROR_META_IDX_DEFN_EXIST     = 52
 	ERROR_META_IDX_DEFN_NOT_EXIST = 53
 	ERROR_META_FAIL_TO_PARSE_INT  = 54
+	ERROR_META_STALE              = 55
 
 	// Event Manager (101-150)
 	ERROR_EVT_DUPLICATE_NOTIFIER = 101
@@ -36,6 +37,8 @@ const (
 	// Index Manager (151-200)
 	ERROR_MGR_DDL_CREATE_IDX = 151
 	ERROR_MGR_DDL_DROP_IDX   = 152
+	ERROR_MGR_NODE_CHANGE    = 153
+	ERROR_MGR_DDL_BUILD_IDX  = 154
 
 	// Coordinator (201-250)
 	ERROR_COOR_LISTENER_FAIL = 201
@@ -11,8 +11,11 @@ package manager
 
 import (
 	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
+	"sort"
 	"sync"
+	"time"
 )
 
 ///////////////////////////////////////////////////////
@@ -26,12 +29,54 @@ const (
 	EVENT_CREATE_INDEX
 	EVENT_DROP_INDEX
 	EVENT_UPDATE_TOPOLOGY
+	EVENT_INGEST_LAG
 )
 
+func (t EventType) String() string {
+	switch t {
+	case EVENT_CREATE_INDEX:
+		return "createIndex"
+	case EVENT_DROP_INDEX:
+		return "dropIndex"
+	case EVENT_UPDATE_TOPOLOGY:
+		return "updateTopology"
+	case EVENT_INGEST_LAG:
+		return "ingestLag"
+	}
+	return "none"
+}
+
+//
+// EventSubscription is a registered event listener, and the events it
+// listens to.
+//
+type EventSubscription struct {
+	Id     string   `json:"id"`
+	Events []string `json:"events"`
+	Queued int      `json:"queued"` // notifications not yet received
+}
+
+//
+// IngestLagEvent is notified when the ingest lag of an index instance,
+// the number of KV seqnos not yet applied to its last snapshot, stays
+// above threshold for the configured duration, and again with Cleared
+// set once the lag recovers.
+//
+type IngestLagEvent struct {
+	DefnId  common.IndexDefnId
+	InstId  common.IndexInstId
+	Bucket  string
+	Name    string
+	Lag     uint64
+	Since   time.Time
+	Cleared bool
+}
+
 type eventManager struct {
-	mutex     sync.Mutex
-	isClosed  bool
-	notifiers map[EventType]([]*notifier)
+	mutex      sync.Mutex
+	isClosed   bool
+	notifiers  map[EventType]([]*notifier)
+	nextHandle uint64
 }
 
 type notifier struct {
@@ -81,31 +126,54 @@ func (e *eventManager) close() {
 }
 
 //
-// Register a new event listener
+// Return the registered event listeners, sorted by id.
 //
-func (e *eventManager) register(id string, evtType EventType) (<-chan interface{}, error) {
+func (e *eventManager) subscriptions() []EventSubscription {
 
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	notifiers, ok := e.notifiers[evtType]
-	if !ok {
-		notifiers = make([]*notifier, 0, DEFAULT_NOTIFIER_QUEUE_SIZE)
-		e.notifiers[evtType] = notifiers
+	byId := make(map[string]*EventSubscription)
+	for evtType, notifiers := range e.notifiers {
+		for _, notifier := range notifiers {
+			sub, ok := byId[notifier.id]
+			if !ok {
+				sub = &EventSubscription{Id: notifier.id}
+				byId[notifier.id] = sub
+			}
+			sub.Events = append(sub.Events, evtType.String())
+			sub.Queued += len(notifier.notifications)
+		}
 	}
 
-	for _, notifier := range notifiers {
-		if notifier.id == id {
-			return nil, NewError(ERROR_EVT_DUPLICATE_NOTIFIER, NORMAL, EVENT_MANAGER, nil,
-				fmt.Sprintf("Notifier %d already registered", id))
-		}
+	subs := make([]EventSubscription, 0, len(byId))
+	for _, sub := range byId {
+		sort.Strings(sub.Events)
+		subs = append(subs, *sub)
 	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Id < subs[j].Id })
+	return subs
+}
+
+//
+// Register a new event listener of a component.  The listener is
+// identified by the returned handle, generated unique for the lifetime of
+// the event manager, so that a component cannot take over the
+// notifications of another component.
+//
+func (e *eventManager) register(component string, evtType EventType) (string, <-chan interface{}, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.nextHandle++
+	handle := fmt.Sprintf("%s-%d", component, e.nextHandle)
 
-	notifier := &notifier{id: id,
+	notifier := &notifier{id: handle,
 		notifications: make(chan interface{}, DEFAULT_EVT_QUEUE_SIZE)}
 	e.notifiers[evtType] = append(e.notifiers[evtType], notifier)
 
-	return notifier.notifications, nil
+	return handle, notifier.notifications, nil
 }
 
 //
@@ -0,0 +1,59 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventManagerRegister(t *testing.T) {
+	e, err := newEventManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// listeners of the same component are told apart by their handle.
+	handle1, ch1, err := e.register("feed", EVENT_CREATE_INDEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle2, ch2, err := e.register("feed", EVENT_CREATE_INDEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle3, _, err := e.register("feed", EVENT_DROP_INDEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handle1 == handle2 || handle1 == handle3 || handle2 == handle3 {
+		t.Fatalf("expected unique handles, got %v %v %v", handle1, handle2, handle3)
+	}
+
+	e.notify(EVENT_CREATE_INDEX, "defn")
+	if len(ch1) != 1 || len(ch2) != 1 {
+		t.Fatalf("expected both listeners to be notified")
+	}
+
+	// stopping one listener leaves the other listening.
+	e.unregister(handle1, EVENT_CREATE_INDEX)
+	e.notify(EVENT_CREATE_INDEX, "defn")
+	if len(ch1) != 1 || len(ch2) != 2 {
+		t.Fatalf("unexpected notifications %v %v", len(ch1), len(ch2))
+	}
+
+	expected := []EventSubscription{
+		{Id: handle2, Events: []string{"createIndex"}, Queued: 2},
+		{Id: handle3, Events: []string{"dropIndex"}},
+	}
+	if subs := e.subscriptions(); !reflect.DeepEqual(subs, expected) {
+		t.Errorf("expected %v, got %v", expected, subs)
+	}
+}
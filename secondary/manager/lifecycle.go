@@ -40,6 +40,7 @@ type LifecycleMgr struct {
 	clusterURL    string
 	incomings     chan *requestHolder
 	expedites     chan *requestHolder
+	notifications chan *requestHolder
 	bootstraps    chan *requestHolder
 	outgoings     chan c.Packet
 	killch        chan bool
@@ -49,6 +50,7 @@ type LifecycleMgr struct {
 	updator       *updator
 	requestServer RequestServer
 	prepareLock   *client.PrepareCreateRequest
+	journal       *requestJournal
 }
 
 type requestHolder struct {
@@ -70,6 +72,16 @@ type topologyChange struct {
 	InstVersion int      `json:"instVersion,omitempty"`
 }
 
+type sliceLayoutChange struct {
+	Bucket   string   `json:"bucket,omitempty"`
+	DefnId   uint64   `json:"defnId,omitempty"`
+	InstId   uint64   `json:"instId,omitempty"`
+	PartId   uint64   `json:"partId,omitempty"`
+	SliceId  uint64   `json:"sliceId,omitempty"`
+	Vbuckets []uint16 `json:"vbuckets,omitempty"`
+	HostPath string   `json:"hostPath,omitempty"`
+}
+
 type dropInstance struct {
 	Defn             common.IndexDefn `json:"defn,omitempty"`
 	Notify           bool             `json:"notify,omitempty"`
@@ -125,15 +137,16 @@ func NewLifecycleMgr(notifier MetadataNotifier, clusterURL string) (*LifecycleMg
 	}
 
 	mgr := &LifecycleMgr{repo: nil,
-		cinfo:        cinfo,
-		notifier:     notifier,
-		clusterURL:   clusterURL,
-		incomings:    make(chan *requestHolder, 1000),
-		expedites:    make(chan *requestHolder, 1000),
-		outgoings:    make(chan c.Packet, 1000),
-		killch:       make(chan bool),
-		bootstraps:   make(chan *requestHolder, 1000),
-		indexerReady: false}
+		cinfo:         cinfo,
+		notifier:      notifier,
+		clusterURL:    clusterURL,
+		incomings:     make(chan *requestHolder, 1000),
+		expedites:     make(chan *requestHolder, 1000),
+		notifications: make(chan *requestHolder, 1000),
+		outgoings:     make(chan c.Packet, 1000),
+		killch:        make(chan bool),
+		bootstraps:    make(chan *requestHolder, 1000),
+		indexerReady:  false}
 	mgr.builder = newBuilder(mgr)
 	mgr.janitor = newJanitor(mgr)
 	mgr.updator = newUpdator(mgr)
@@ -141,9 +154,10 @@ func NewLifecycleMgr(notifier MetadataNotifier, clusterURL string) (*LifecycleMg
 	return mgr, nil
 }
 
-func (m *LifecycleMgr) Run(repo *MetadataRepo, requestServer RequestServer) {
+func (m *LifecycleMgr) Run(repo *MetadataRepo, requestServer RequestServer, journal *requestJournal) {
 	m.repo = repo
 	m.requestServer = requestServer
+	m.journal = journal
 	go m.processRequest()
 }
 
@@ -156,6 +170,7 @@ func (m *LifecycleMgr) Terminate() {
 		close(m.killch)
 		m.killch = nil
 	}
+	m.journal.close()
 }
 
 //
@@ -199,6 +214,7 @@ func (m *LifecycleMgr) OnNewRequest(fid string, request protocol.RequestMsg) {
 		// up in the regular queue until indexer is ready.
 		if !m.indexerReady {
 			if op == client.OPCODE_UPDATE_INDEX_INST ||
+				op == client.OPCODE_UPDATE_SLICE_LAYOUT ||
 				op == client.OPCODE_DELETE_BUCKET ||
 				op == client.OPCODE_CLEANUP_INDEX ||
 				op == client.OPCODE_CLEANUP_PARTITION ||
@@ -209,6 +225,7 @@ func (m *LifecycleMgr) OnNewRequest(fid string, request protocol.RequestMsg) {
 		}
 
 		if op == client.OPCODE_UPDATE_INDEX_INST ||
+			op == client.OPCODE_UPDATE_SLICE_LAYOUT ||
 			op == client.OPCODE_DROP_OR_PRUNE_INSTANCE ||
 			op == client.OPCODE_MERGE_PARTITION ||
 			op == client.OPCODE_PREPARE_CREATE_INDEX ||
@@ -216,6 +233,18 @@ func (m *LifecycleMgr) OnNewRequest(fid string, request protocol.RequestMsg) {
 			op == client.OPCODE_REBALANCE_RUNNING {
 			m.expedites <- req
 
+		} else if op == client.OPCODE_BROADCAST_STATS {
+			// stats are broadcast periodically, each broadcast supersedes
+			// the previous ones.  They have their own queue so that a burst
+			// of broadcasts does not delay create/drop index, and vice versa.
+			select {
+			case m.notifications <- req:
+			default:
+				logging.Debugf("LifecycleMgr.OnNewRequest(): notification queue is full. Skip stats broadcast reqId %v",
+					request.GetReqId())
+				m.skipRequest(req, message.NewConcreteMsgFactory())
+			}
+
 		} else {
 			// for create/drop/build index, always go to the client queue -- which will wait for
 			// indexer to be ready.
@@ -284,6 +313,14 @@ END_BOOTSTRAP:
 				logging.Debugf("LifecycleMgr.handleRequest(): channel for receiving client request is closed. Terminate.")
 				return
 			}
+		case request, ok := <-m.notifications:
+			if ok {
+				m.dispatchNotification(request, factory)
+			} else {
+				// server shutdown.
+				logging.Debugf("LifecycleMgr.handleRequest(): channel for receiving notification is closed. Terminate.")
+				return
+			}
 		case request, ok := <-m.incomings:
 			if ok {
 				if dispatchExpediates() {
@@ -318,11 +355,21 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 	var err error = nil
 	var result []byte = nil
 
+	// service map and stats broadcast do not change metadata, and are
+	// too frequent to be journaled.
+	var entry *RequestJournalEntry
+	start := time.Now()
+	if op != client.OPCODE_SERVICE_MAP && op != client.OPCODE_BROADCAST_STATS {
+		entry = m.journal.begin(fid, reqId, op, key)
+	}
+
 	switch op {
 	case client.OPCODE_CREATE_INDEX:
 		err = m.handleCreateIndexScheduledBuild(key, content, common.NewUserRequestContext())
 	case client.OPCODE_UPDATE_INDEX_INST:
 		err = m.handleTopologyChange(content)
+	case client.OPCODE_UPDATE_SLICE_LAYOUT:
+		err = m.handleSliceLayoutChange(content)
 	case client.OPCODE_DROP_INDEX:
 		err = m.handleDeleteIndex(key, common.NewUserRequestContext())
 	case client.OPCODE_BUILD_INDEX:
@@ -367,6 +414,8 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 		err = m.handleCreateIndex(key, content, common.NewUserRequestContext())
 	}
 
+	m.journal.end(entry, start, err)
+
 	logging.Debugf("LifecycleMgr.dispatchRequest () : send response for requestId %d, op %d, len(result) %d", reqId, op, len(result))
 
 	if fid == "internal" {
@@ -383,6 +432,42 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 
 }
 
+//
+// dispatchNotification dispatches the latest of the pending notifications.
+// Older notifications are superseded, and are acknowledged without being
+// processed.
+//
+func (m *LifecycleMgr) dispatchNotification(request *requestHolder, factory *message.ConcreteMsgFactory) {
+
+	for {
+		select {
+		case next, ok := <-m.notifications:
+			if !ok {
+				m.dispatchRequest(request, factory)
+				return
+			}
+			m.skipRequest(request, factory)
+			request = next
+		default:
+			m.dispatchRequest(request, factory)
+			return
+		}
+	}
+}
+
+//
+// skipRequest acknowledges a request without processing it.
+//
+func (m *LifecycleMgr) skipRequest(request *requestHolder, factory *message.ConcreteMsgFactory) {
+
+	if request.fid == "internal" {
+		return
+	}
+
+	reqId := request.request.GetReqId()
+	m.outgoings <- factory.CreateResponse(request.fid, reqId, "", nil)
+}
+
 //////////////////////////////////////////////////////////////
 // Lifecycle Mgr - handler functions
 //////////////////////////////////////////////////////////////
@@ -1299,6 +1384,31 @@ func (m *LifecycleMgr) handleCleanupIndexMetadata(content []byte) error {
 // Topology change (on index inst)
 //-----------------------------------------------------------
 
+//
+// Record the layout of a slice created or relocated by the indexer.
+//
+func (m *LifecycleMgr) handleSliceLayoutChange(content []byte) error {
+
+	change := new(sliceLayoutChange)
+	if err := json.Unmarshal(content, change); err != nil {
+		return err
+	}
+
+	topology, err := m.repo.CloneTopologyByBucket(change.Bucket)
+	if err != nil {
+		return err
+	}
+	if topology == nil {
+		return nil
+	}
+
+	if topology.UpdateSliceLayoutForIndexInst(common.IndexDefnId(change.DefnId), common.IndexInstId(change.InstId),
+		change.PartId, change.SliceId, change.Vbuckets, change.HostPath) {
+		return m.repo.SetTopologyByBucket(change.Bucket, topology)
+	}
+	return nil
+}
+
 func (m *LifecycleMgr) handleTopologyChange(content []byte) error {
 
 	change := new(topologyChange)
@@ -0,0 +1,71 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"testing"
+
+	c "github.com/couchbase/gometa/common"
+	"github.com/couchbase/gometa/message"
+	"github.com/couchbase/gometa/protocol"
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func TestLifecycleMgrNotifications(t *testing.T) {
+	m := &LifecycleMgr{
+		incomings:     make(chan *requestHolder, 10),
+		expedites:     make(chan *requestHolder, 10),
+		notifications: make(chan *requestHolder, 2),
+		outgoings:     make(chan c.Packet, 10),
+		indexerReady:  true,
+	}
+	factory := message.NewConcreteMsgFactory()
+
+	// broadcasts are queued apart from DDL requests, and skipped once
+	// their queue is full.
+	for reqId := uint64(1); reqId <= 3; reqId++ {
+		m.OnNewRequest("fid", factory.CreateRequest(reqId, uint32(client.OPCODE_BROADCAST_STATS), "", nil))
+	}
+	m.OnNewRequest("fid", factory.CreateRequest(4, uint32(client.OPCODE_DROP_INDEX), "10", nil))
+
+	if len(m.notifications) != 2 || len(m.incomings) != 1 || len(m.expedites) != 0 {
+		t.Fatalf("Unexpected queues, notifications %v incomings %v expedites %v",
+			len(m.notifications), len(m.incomings), len(m.expedites))
+	}
+	if req := <-m.incomings; req.request.GetReqId() != 4 {
+		t.Fatalf("Expected drop index request, got %v", req.request.GetReqId())
+	}
+
+	// pending broadcasts are coalesced to the latest, every broadcast is
+	// responded to.
+	m.dispatchNotification(<-m.notifications, factory)
+	if len(m.notifications) != 0 {
+		t.Fatalf("Expected pending broadcasts to be coalesced")
+	}
+
+	var reqIds []uint64
+	for len(m.outgoings) != 0 {
+		resp, ok := (<-m.outgoings).(protocol.ResponseMsg)
+		if !ok || resp.GetError() != "" || resp.GetFid() != "fid" {
+			t.Fatalf("Unexpected response %v", resp)
+		}
+		reqIds = append(reqIds, resp.GetReqId())
+	}
+	if len(reqIds) != 3 || reqIds[0] != 3 || reqIds[1] != 1 || reqIds[2] != 2 {
+		t.Fatalf("Expected responses to requests [3 1 2], got %v", reqIds)
+	}
+
+	// internal broadcasts are not responded to.
+	m.OnNewRequest("internal", factory.CreateRequest(5, uint32(client.OPCODE_BROADCAST_STATS), "", nil))
+	m.dispatchNotification(<-m.notifications, factory)
+	if len(m.outgoings) != 0 {
+		t.Fatalf("Unexpected response to internal broadcast")
+	}
+}
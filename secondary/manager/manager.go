@@ -18,8 +18,10 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/manager/client"
+	qparser "github.com/couchbase/query/expression/parser"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -44,6 +46,9 @@ type IndexManager struct {
 	// bucket monitor
 	monitorKillch chan bool
 
+	// last indexer node add or remove
+	nodeChange *NodeChangePlan
+
 	mutex    sync.Mutex
 	isClosed bool
 }
@@ -180,7 +185,8 @@ func NewIndexManagerInternal(config common.Config, storageMode common.StorageMod
 	}
 
 	// start lifecycle manager
-	mgr.lifecycleMgr.Run(mgr.repo, mgr.requestServer)
+	journal := newRequestJournal(filepath.Join(mgr.basepath, REQUEST_JOURNAL_NAME), REQUEST_JOURNAL_SIZE)
+	mgr.lifecycleMgr.Run(mgr.repo, mgr.requestServer, journal)
 
 	// register request handler
 	registerRequestHandler(mgr, mgr.clusterURL)
@@ -314,45 +320,81 @@ func (m *IndexManager) NewIndexDefnIterator() (*MetaIterator, error) {
 }
 
 //
-// Listen to create Index Request
+// Get an iterator over the index definitions accepted by filter
+//
+func (m *IndexManager) NewFilteredIndexDefnIterator(filter func(*common.IndexDefn) bool) (*MetaIterator, error) {
+	return m.repo.NewFilteredIterator(filter)
+}
+
+//
+// Get the active event listeners
+//
+func (m *IndexManager) GetEventSubscriptions() []EventSubscription {
+	return m.eventMgr.subscriptions()
+}
+
+//
+// Get the latest requests processed by the lifecycle manager
+//
+func (m *IndexManager) GetRequestJournal() []RequestJournalEntry {
+	return m.lifecycleMgr.journal.list()
+}
+
+//
+// Listen to create Index Request.  The returned handle identifies the
+// listener to stop listening with.
 //
-func (m *IndexManager) StartListenIndexCreate(id string) (<-chan interface{}, error) {
-	return m.eventMgr.register(id, EVENT_CREATE_INDEX)
+func (m *IndexManager) StartListenIndexCreate(component string) (string, <-chan interface{}, error) {
+	return m.eventMgr.register(component, EVENT_CREATE_INDEX)
 }
 
 //
 // Stop Listen to create Index Request
 //
-func (m *IndexManager) StopListenIndexCreate(id string) {
-	m.eventMgr.unregister(id, EVENT_CREATE_INDEX)
+func (m *IndexManager) StopListenIndexCreate(handle string) {
+	m.eventMgr.unregister(handle, EVENT_CREATE_INDEX)
 }
 
 //
 // Listen to delete Index Request
 //
-func (m *IndexManager) StartListenIndexDelete(id string) (<-chan interface{}, error) {
-	return m.eventMgr.register(id, EVENT_DROP_INDEX)
+func (m *IndexManager) StartListenIndexDelete(component string) (string, <-chan interface{}, error) {
+	return m.eventMgr.register(component, EVENT_DROP_INDEX)
 }
 
 //
 // Stop Listen to delete Index Request
 //
-func (m *IndexManager) StopListenIndexDelete(id string) {
-	m.eventMgr.unregister(id, EVENT_DROP_INDEX)
+func (m *IndexManager) StopListenIndexDelete(handle string) {
+	m.eventMgr.unregister(handle, EVENT_DROP_INDEX)
 }
 
 //
 // Listen to update Topology Request
 //
-func (m *IndexManager) StartListenTopologyUpdate(id string) (<-chan interface{}, error) {
-	return m.eventMgr.register(id, EVENT_UPDATE_TOPOLOGY)
+func (m *IndexManager) StartListenTopologyUpdate(component string) (string, <-chan interface{}, error) {
+	return m.eventMgr.register(component, EVENT_UPDATE_TOPOLOGY)
 }
 
 //
 // Stop Listen to update Topology Request
 //
-func (m *IndexManager) StopListenTopologyUpdate(id string) {
-	m.eventMgr.unregister(id, EVENT_UPDATE_TOPOLOGY)
+func (m *IndexManager) StopListenTopologyUpdate(handle string) {
+	m.eventMgr.unregister(handle, EVENT_UPDATE_TOPOLOGY)
+}
+
+//
+// Listen to ingest lag events of index instances
+//
+func (m *IndexManager) StartListenIngestLag(component string) (string, <-chan interface{}, error) {
+	return m.eventMgr.register(component, EVENT_INGEST_LAG)
+}
+
+//
+// Stop Listen to ingest lag events
+//
+func (m *IndexManager) StopListenIngestLag(handle string) {
+	m.eventMgr.unregister(handle, EVENT_INGEST_LAG)
 }
 
 //
@@ -385,6 +427,10 @@ func (m *IndexManager) StopListenTopologyUpdate(id string) {
 //
 func (m *IndexManager) HandleCreateIndexDDL(defn *common.IndexDefn, isRebalReq bool) error {
 
+	if err := validateWhereExpr(defn); err != nil {
+		return err
+	}
+
 	key := fmt.Sprintf("%d", defn.DefnId)
 	content, err := common.MarshallIndexDefn(defn)
 	if err != nil {
@@ -409,6 +455,26 @@ func (m *IndexManager) HandleCreateIndexDDL(defn *common.IndexDefn, isRebalReq b
 	return nil
 }
 
+//
+// Validate the where clause of a partial index, so that an invalid
+// predicate fails the create index statement rather than every
+// document at the projector.  A blank where clause is not persisted.
+//
+func validateWhereExpr(defn *common.IndexDefn) error {
+
+	if strings.TrimSpace(defn.WhereExpr) == "" {
+		defn.WhereExpr = ""
+		return nil
+	}
+
+	if _, err := qparser.Parse(defn.WhereExpr); err != nil {
+		return NewError(ERROR_MGR_DDL_CREATE_IDX, NORMAL, INDEX_MANAGER, err,
+			fmt.Sprintf("Invalid where clause '%s' for index '%s': %v", defn.WhereExpr, defn.Name, err))
+	}
+
+	return nil
+}
+
 func (m *IndexManager) HandleDeleteIndexDDL(defnId common.IndexDefnId) error {
 
 	key := fmt.Sprintf("%d", defnId)
@@ -427,6 +493,43 @@ func (m *IndexManager) HandleDeleteIndexDDL(defnId common.IndexDefnId) error {
 	return nil
 }
 
+//
+// Handle Build Index DDL for the deferred indexes of a bucket given by
+// name.  The indexes are built together, in a single request, so that
+// the initial stream scans the bucket once for all of them.  The names
+// are resolved before the build is requested, and no index is built if
+// any name is not found.
+//
+func (m *IndexManager) HandleBuildIndexesByName(bucket string, names []string) error {
+
+	if len(bucket) == 0 || len(names) == 0 {
+		return NewError(ERROR_ARGUMENTS, NORMAL, INDEX_MANAGER, nil, "Missing bucket or index names for build index")
+	}
+
+	var indexIds client.IndexIdList
+	seen := make(map[common.IndexDefnId]bool)
+	for _, name := range names {
+		defn, err := m.repo.GetIndexDefnByName(bucket, name)
+		if err != nil {
+			return err
+		}
+		if defn == nil {
+			return NewError(ERROR_MGR_DDL_BUILD_IDX, NORMAL, INDEX_MANAGER, nil,
+				fmt.Sprintf("Index '%s' of bucket '%s' not found", name, bucket))
+		}
+		if !defn.Deferred {
+			logging.Warnf("IndexManager.HandleBuildIndexesByName(): index %v:%v is not deferred", bucket, name)
+		}
+		if !seen[defn.DefnId] {
+			seen[defn.DefnId] = true
+			indexIds.DefnIds = append(indexIds.DefnIds, uint64(defn.DefnId))
+		}
+	}
+
+	logging.Infof("IndexManager.HandleBuildIndexesByName(): building %v indexes of bucket %v", len(indexIds.DefnIds), bucket)
+	return m.HandleBuildIndexDDL(indexIds)
+}
+
 func (m *IndexManager) HandleBuildIndexDDL(indexIds client.IndexIdList) error {
 
 	key := fmt.Sprintf("%d", indexIds.DefnIds[0])
@@ -476,6 +579,32 @@ func (m *IndexManager) UpdateIndexInstance(bucket string, defnId common.IndexDef
 	return m.requestServer.MakeAsyncRequest(client.OPCODE_UPDATE_INDEX_INST, fmt.Sprintf("%v", defnId), buf)
 }
 
+//
+// Record the vbuckets and storage path of a slice of an index partition
+// in the topology.
+//
+func (m *IndexManager) UpdateSliceLayout(bucket string, defnId common.IndexDefnId, instId common.IndexInstId,
+	partId common.PartitionId, sliceId uint64, vbuckets []uint16, hostPath string) error {
+
+	change := &sliceLayoutChange{
+		Bucket:   bucket,
+		DefnId:   uint64(defnId),
+		InstId:   uint64(instId),
+		PartId:   uint64(partId),
+		SliceId:  sliceId,
+		Vbuckets: vbuckets,
+		HostPath: hostPath}
+
+	buf, e := json.Marshal(&change)
+	if e != nil {
+		return e
+	}
+
+	// Async for the same reason as UpdateIndexInstance.
+	logging.Debugf("IndexManager.UpdateSliceLayout(): making request for slice layout update")
+	return m.requestServer.MakeAsyncRequest(client.OPCODE_UPDATE_SLICE_LAYOUT, fmt.Sprintf("%v", defnId), buf)
+}
+
 func (m *IndexManager) UpdateIndexInstanceSync(bucket string, defnId common.IndexDefnId, instId common.IndexInstId,
 	state common.IndexState, streamId common.StreamId, err string, buildTime []uint64, rState common.RebalanceState,
 	partitions []uint64, versions []int, instVersion int) error {
@@ -617,6 +746,13 @@ func (m *IndexManager) NotifyStats(stats common.Statistics) error {
 	return m.requestServer.MakeAsyncRequest(client.OPCODE_BROADCAST_STATS, "", buf)
 }
 
+func (m *IndexManager) NotifyIngestLag(event IngestLagEvent) {
+
+	logging.Debugf("IndexManager.NotifyIngestLag(): index %v lag %v cleared %v",
+		event.InstId, event.Lag, event.Cleared)
+	m.notify(EVENT_INGEST_LAG, event)
+}
+
 func (m *IndexManager) NotifyConfigUpdate(config common.Config) error {
 
 	logging.Debugf("IndexManager.NotifyConfigUpdate(): making request for new config update")
@@ -637,6 +773,57 @@ func (m *IndexManager) GetTopologyByBucket(bucket string) (*IndexTopology, error
 	return m.repo.GetTopologyByBucket(bucket)
 }
 
+//
+// Get Topology from the local replica of the dictionary, if it lags the
+// leader by no more than maxLag.  It is served during leader election.
+//
+func (m *IndexManager) GetTopologyByBucketWithStaleness(bucket string,
+	maxLag time.Duration) (*IndexTopology, error) {
+
+	return m.repo.GetTopologyByBucketWithStaleness(bucket, maxLag)
+}
+
+//
+// Get Index Definition by name from the local replica of the dictionary,
+// if it lags the leader by no more than maxLag.
+//
+func (m *IndexManager) GetIndexDefnByNameWithStaleness(bucket string, name string,
+	maxLag time.Duration) (*common.IndexDefn, error) {
+
+	return m.repo.GetIndexDefnByNameWithStaleness(bucket, name, maxLag)
+}
+
+//
+// Get the version vector of the local dictionary, with the version of this
+// indexer.  DDL responses carry the version after the DDL is committed, so
+// that a client can read its own DDL by waiting for that version before
+// reading.
+//
+func (m *IndexManager) GetMetadataVersion() MetaVersion {
+	return m.repo.Version()
+}
+
+//
+// Wait for the local dictionary to reach the version of this indexer in
+// version, for up to timeout.
+//
+func (m *IndexManager) WaitForMetadataVersion(version MetaVersion, timeout time.Duration) error {
+	return m.repo.WaitForVersion(version, timeout)
+}
+
+//
+// Get Index Definition by name from the local dictionary, once it is at
+// the version of this indexer in version or later.
+//
+func (m *IndexManager) GetIndexDefnByNameAtVersion(bucket string, name string,
+	version MetaVersion, timeout time.Duration) (*common.IndexDefn, error) {
+
+	if err := m.repo.WaitForVersion(version, timeout); err != nil {
+		return nil, err
+	}
+	return m.repo.GetIndexDefnByName(bucket, name)
+}
+
 //
 // Set Topology to dictionary
 //
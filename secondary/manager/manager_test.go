@@ -0,0 +1,91 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	gometaC "github.com/couchbase/gometa/common"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+// testRequestServer records the requests made to it.
+type testRequestServer struct {
+	opCodes []gometaC.OpCode
+	keys    []string
+	values  [][]byte
+}
+
+func (s *testRequestServer) MakeRequest(opCode gometaC.OpCode, key string, value []byte) error {
+	s.opCodes = append(s.opCodes, opCode)
+	s.keys = append(s.keys, key)
+	s.values = append(s.values, value)
+	return nil
+}
+
+func (s *testRequestServer) MakeAsyncRequest(opCode gometaC.OpCode, key string, value []byte) error {
+	return s.MakeRequest(opCode, key, value)
+}
+
+func TestHandleBuildIndexesByName(t *testing.T) {
+	repo, _ := newTestMetadataRepo(t, time.Second)
+	for _, defn := range []*common.IndexDefn{
+		{DefnId: 10, Bucket: "default", Name: "idx1", Deferred: true},
+		{DefnId: 20, Bucket: "default", Name: "idx2", Deferred: true},
+		{DefnId: 30, Bucket: "other", Name: "idx3", Deferred: true},
+		{DefnId: 40, Bucket: "default", Name: "idx4"},
+	} {
+		repo.defnCache[defn.DefnId] = defn
+	}
+
+	server := &testRequestServer{}
+	m := &IndexManager{repo: repo, requestServer: server}
+
+	// indexes are built together, once each, whether deferred or not
+	if err := m.HandleBuildIndexesByName("default", []string{"idx2", "idx1", "idx2", "idx4"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(server.opCodes) != 1 || server.opCodes[0] != client.OPCODE_BUILD_INDEX_REBAL ||
+		server.keys[0] != "20" {
+		t.Fatalf("Expected a single build request, got %v %v", server.opCodes, server.keys)
+	}
+	indexIds, err := client.UnmarshallIndexIdList(server.values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexIds.DefnIds, []uint64{20, 10, 40}) {
+		t.Fatalf("Expected indexes [20 10 40], got %v", indexIds.DefnIds)
+	}
+
+	// no index is built if any name is not found in the bucket
+	testcases := []struct {
+		bucket string
+		names  []string
+		code   errCode
+	}{
+		{"default", []string{"idx1", "idx3"}, ERROR_MGR_DDL_BUILD_IDX},
+		{"other", []string{"idx1"}, ERROR_MGR_DDL_BUILD_IDX},
+		{"default", []string{"idx5"}, ERROR_MGR_DDL_BUILD_IDX},
+		{"default", nil, ERROR_ARGUMENTS},
+		{"", []string{"idx1"}, ERROR_ARGUMENTS},
+	}
+	for _, tc := range testcases {
+		err := m.HandleBuildIndexesByName(tc.bucket, tc.names)
+		if mgrErr, ok := err.(Error); !ok || mgrErr.code != tc.code {
+			t.Errorf("%v %v: expected error code %v, got %v", tc.bucket, tc.names, tc.code, err)
+		}
+	}
+	if len(server.opCodes) != 1 {
+		t.Fatalf("Expected no more build requests, got %v", server.keys)
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MetadataRepo struct {
@@ -33,10 +35,15 @@ type MetadataRepo struct {
 	defnCache  map[common.IndexDefnId]*common.IndexDefn
 	topoCache  map[string]*IndexTopology
 	globalTopo *GlobalTopology
+
+	versionEpoch uint64 // num of restarts, high 32 bits of versions
 }
 
 type RepoRef interface {
 	getMeta(name string) ([]byte, error)
+	getLocalMeta(name string) ([]byte, error)
+	lag() time.Duration
+	version() uint64
 	setMeta(name string, value []byte) error
 	broadcast(name string, value []byte) error
 	deleteMeta(name string) error
@@ -58,6 +65,7 @@ type LocalRepoRef struct {
 	server   *gometa.EmbeddedServer
 	eventMgr *eventManager
 	notifier MetadataNotifier
+	writes   uint64 // num of writes to the repository since start
 }
 
 type MetaIterator struct {
@@ -144,6 +152,10 @@ func NewLocalMetadataRepo(msgAddr string,
 		return nil, nil, err
 	}
 
+	if err := repo.loadVersionEpoch(); err != nil {
+		return nil, nil, err
+	}
+
 	return repo, ref.server, nil
 }
 
@@ -273,10 +285,168 @@ func (c *MetadataRepo) GetIndexDefnByName(bucket string, name string) (*common.I
 	return nil, nil
 }
 
+//
+// GetIndexDefnByNameWithStaleness returns the index definition from the
+// local replica of the repository, without contacting the leader, if
+// the replica lags the leader by no more than maxLag.
+//
+func (c *MetadataRepo) GetIndexDefnByNameWithStaleness(bucket string, name string,
+	maxLag time.Duration) (*common.IndexDefn, error) {
+
+	if err := c.checkStaleness(maxLag); err != nil {
+		return nil, err
+	}
+	return c.GetIndexDefnByName(bucket, name)
+}
+
+//
+// MetaVersion is a version vector of the metadata, keyed by indexer id.
+// The version of an indexer increases with every write to its local
+// repository, and across restarts.  Versions of different indexers are
+// not comparable.
+//
+type MetaVersion map[common.IndexerId]uint64
+
+//
+// Merge returns the version vector covering both v and other.
+//
+func (v MetaVersion) Merge(other MetaVersion) MetaVersion {
+	merged := make(MetaVersion)
+	for _, vv := range []MetaVersion{v, other} {
+		for id, version := range vv {
+			if version > merged[id] {
+				merged[id] = version
+			}
+		}
+	}
+	return merged
+}
+
+//
+// loadVersionEpoch starts a new epoch of versions of the repository.  The
+// epoch counts the restarts of the indexer and is persisted, so that the
+// versions after a restart are higher than any version before it.
+//
+func (c *MetadataRepo) loadVersionEpoch() error {
+
+	var epoch uint64
+	if val, err := c.GetLocalValue("MetadataVersionEpoch"); err == nil && len(val) != 0 {
+		if epoch, err = strconv.ParseUint(val, 10, 64); err != nil {
+			return err
+		}
+	}
+	epoch++
+
+	if err := c.SetLocalValue("MetadataVersionEpoch", strconv.FormatUint(epoch, 10)); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&c.versionEpoch, epoch)
+	return nil
+}
+
+func (c *MetadataRepo) localVersion() uint64 {
+	return atomic.LoadUint64(&c.versionEpoch)<<32 | c.repo.version()
+}
+
+//
+// Version returns the version vector of the repository, with the version
+// of the local indexer.  DDL responses carry this version, so that a
+// client can read its own DDL from the local metadata of the indexer.
+//
+func (c *MetadataRepo) Version() MetaVersion {
+	id, err := c.GetLocalIndexerId()
+	if err != nil || len(id) == 0 {
+		return nil
+	}
+	return MetaVersion{id: c.localVersion()}
+}
+
+//
+// WaitForVersion waits till the repository is at the version of the local
+// indexer in version, or later, for up to timeout.  Versions of other
+// indexers are ignored.
+//
+func (c *MetadataRepo) WaitForVersion(version MetaVersion, timeout time.Duration) error {
+
+	id, err := c.GetLocalIndexerId()
+	if err != nil {
+		return err
+	}
+	target, ok := version[id]
+	if !ok || c.localVersion() >= target {
+		return nil
+	}
+
+	ticker := time.NewTicker(time.Duration(10) * time.Millisecond)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(timeout)
+	for range ticker.C {
+		current := c.localVersion()
+		if current >= target {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return NewError(ERROR_META_STALE, NORMAL, METADATA_REPO, nil,
+				fmt.Sprintf("Local metadata version %v has not reached %v after %v", current, target, timeout))
+		}
+	}
+	return nil
+}
+
+func (c *MetadataRepo) checkStaleness(maxLag time.Duration) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if lag := c.repo.lag(); lag > maxLag {
+		return NewError(ERROR_META_STALE, NORMAL, METADATA_REPO, nil,
+			fmt.Sprintf("Local metadata lags the leader by %v, more than %v", lag, maxLag))
+	}
+	return nil
+}
+
 ///////////////////////////////////////////////////////
 //  Public Function : Index Topology
 ///////////////////////////////////////////////////////
 
+//
+// GetTopologyByBucketWithStaleness returns the topology from the local
+// replica of the repository, without contacting the leader, if the
+// replica lags the leader by no more than maxLag.
+//
+func (c *MetadataRepo) GetTopologyByBucketWithStaleness(bucket string,
+	maxLag time.Duration) (*IndexTopology, error) {
+
+	if err := c.checkStaleness(maxLag); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if topology, ok := c.topoCache[bucket]; ok && topology != nil {
+		return topology, nil
+	}
+
+	data, err := c.repo.getLocalMeta(indexTopologyKey(bucket))
+	if err != nil && strings.Contains(err.Error(), "FDB_RESULT_KEY_NOT_FOUND") {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if data == nil {
+		return nil, nil
+	}
+
+	topology, err := unmarshallIndexTopology(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c.topoCache[bucket] = topology
+	return topology, nil
+}
+
 func (c *MetadataRepo) GetTopologyByBucket(bucket string) (*IndexTopology, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -580,13 +750,23 @@ func (c *MetadataRepo) loadTopology() error {
 // Create a new iterator
 //
 func (c *MetadataRepo) NewIterator() (*MetaIterator, error) {
+	return c.NewFilteredIterator(nil)
+}
+
+//
+// Create a new iterator over the index definitions accepted by filter.
+// All definitions are iterated if filter is nil.
+//
+func (c *MetadataRepo) NewFilteredIterator(filter func(*common.IndexDefn) bool) (*MetaIterator, error) {
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	iter := &MetaIterator{pos: 0, arr: nil}
 	for _, defn := range c.defnCache {
-		iter.arr = append(iter.arr, defn)
+		if filter == nil || filter(defn) {
+			iter.arr = append(iter.arr, defn)
+		}
 	}
 	return iter, nil
 }
@@ -661,10 +841,24 @@ func (c *LocalRepoRef) getMeta(name string) ([]byte, error) {
 	return c.server.GetValue(name)
 }
 
+func (c *LocalRepoRef) getLocalMeta(name string) ([]byte, error) {
+	return c.server.GetValue(name)
+}
+
+// The local repository is authoritative for this node.
+func (c *LocalRepoRef) lag() time.Duration {
+	return 0
+}
+
+func (c *LocalRepoRef) version() uint64 {
+	return atomic.LoadUint64(&c.writes)
+}
+
 func (c *LocalRepoRef) setMeta(name string, value []byte) error {
 	if err := c.server.Set(name, value); err != nil {
 		return err
 	}
+	atomic.AddUint64(&c.writes, 1)
 
 	evtType := getEventType(name)
 	if c.eventMgr != nil && evtType != EVENT_NONE {
@@ -677,6 +871,7 @@ func (c *LocalRepoRef) broadcast(name string, value []byte) error {
 	if err := c.server.Broadcast(name, value); err != nil {
 		return err
 	}
+	atomic.AddUint64(&c.writes, 1)
 
 	evtType := getEventType(name)
 	if c.eventMgr != nil && evtType != EVENT_NONE {
@@ -689,6 +884,7 @@ func (c *LocalRepoRef) deleteMeta(name string) error {
 	if err := c.server.Delete(name); err != nil {
 		return err
 	}
+	atomic.AddUint64(&c.writes, 1)
 
 	if c.eventMgr != nil && findTypeFromKey(name) == KIND_INDEX_DEFN {
 		c.eventMgr.notify(EVENT_DROP_INDEX, []byte(name))
@@ -819,6 +1015,19 @@ func (c *RemoteRepoRef) getMeta(name string) ([]byte, error) {
 	return nil, nil
 }
 
+func (c *RemoteRepoRef) getLocalMeta(name string) ([]byte, error) {
+	return c.getMetaFromWatcher(name)
+}
+
+func (c *RemoteRepoRef) lag() time.Duration {
+	return c.watcher.lag()
+}
+
+// Versions are kept by the local repository of an indexer only.
+func (c *RemoteRepoRef) version() uint64 {
+	return 0
+}
+
 func (c *RemoteRepoRef) setMeta(name string, value []byte) error {
 
 	request := &Request{OpCode: "Set", Key: name, Value: value}
@@ -0,0 +1,197 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// testRepoRef serves metadata from a map, with a fixed lag.
+type testRepoRef struct {
+	RepoRef
+	meta      map[string][]byte
+	lagBy     time.Duration
+	remoteGet int
+	local     map[string]string
+	writes    uint64
+}
+
+func (r *testRepoRef) getLocalMeta(name string) ([]byte, error) {
+	return r.meta[name], nil
+}
+
+func (r *testRepoRef) getMeta(name string) ([]byte, error) {
+	r.remoteGet++
+	return r.meta[name], nil
+}
+
+func (r *testRepoRef) lag() time.Duration {
+	return r.lagBy
+}
+
+func (r *testRepoRef) version() uint64 {
+	return atomic.LoadUint64(&r.writes)
+}
+
+func (r *testRepoRef) getLocalValue(key string) (string, error) {
+	if val, ok := r.local[key]; ok {
+		return val, nil
+	}
+	return "", errors.New("not found")
+}
+
+func (r *testRepoRef) setLocalValue(key string, value string) error {
+	r.local[key] = value
+	return nil
+}
+
+func newTestMetadataRepo(t *testing.T, lag time.Duration) (*MetadataRepo, *testRepoRef) {
+	topology := &IndexTopology{Bucket: "default", Version: 1}
+	data, err := json.Marshal(topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := &testRepoRef{
+		meta:  map[string][]byte{indexTopologyKey("default"): data},
+		lagBy: lag,
+		local: map[string]string{"IndexerId": "idx1"},
+	}
+	repo := &MetadataRepo{
+		repo:      ref,
+		defnCache: make(map[common.IndexDefnId]*common.IndexDefn),
+		topoCache: make(map[string]*IndexTopology),
+	}
+	return repo, ref
+}
+
+func TestTopologyWithStaleness(t *testing.T) {
+	repo, ref := newTestMetadataRepo(t, 0)
+
+	topology, err := repo.GetTopologyByBucketWithStaleness("default", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if topology == nil || topology.Bucket != "default" {
+		t.Fatalf("unexpected topology %v", topology)
+	}
+	if ref.remoteGet != 0 {
+		t.Errorf("expected topology to be read from the local replica")
+	}
+
+	if topology, err := repo.GetTopologyByBucketWithStaleness("unknown", time.Second); err != nil || topology != nil {
+		t.Errorf("expected no topology, got %v %v", topology, err)
+	}
+
+	ref.lagBy = time.Minute
+	_, err = repo.GetTopologyByBucketWithStaleness("default", time.Second)
+	if e, ok := err.(Error); !ok || e.code != ERROR_META_STALE {
+		t.Errorf("expected stale metadata error, got %v", err)
+	}
+	if _, err := repo.GetTopologyByBucketWithStaleness("default", time.Hour); err != nil {
+		t.Errorf("expected lag within bound, got %v", err)
+	}
+}
+
+func TestWatcherLag(t *testing.T) {
+	s := &watcher{}
+	if lag := s.lag(); lag != 0 {
+		t.Errorf("expected no lag while connected, got %v", lag)
+	}
+
+	s.setDisconnected()
+	if lag := s.lag(); lag < 0 || lag > time.Minute {
+		t.Errorf("expected lag since disconnect, got %v", lag)
+	}
+
+	// the lag grows from the disconnect, not from the last call.
+	s.syncTime = time.Now().Add(-time.Hour)
+	s.setDisconnected()
+	if lag := s.lag(); lag < time.Hour {
+		t.Errorf("expected lag of an hour, got %v", lag)
+	}
+}
+
+func TestMetaVersion(t *testing.T) {
+	repo, ref := newTestMetadataRepo(t, 0)
+	if err := repo.loadVersionEpoch(); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.AddUint64(&ref.writes, 2)
+	version := repo.Version()
+	if len(version) != 1 || version["idx1"] != 1<<32|2 {
+		t.Fatalf("unexpected version %v", version)
+	}
+
+	// versions of other indexers are not waited for.
+	other := MetaVersion{"idx2": 1 << 40}
+	if err := repo.WaitForVersion(other, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	future := MetaVersion{"idx1": version["idx1"] + 1}
+	err := repo.WaitForVersion(future, 20*time.Millisecond)
+	if e, ok := err.(Error); !ok || e.code != ERROR_META_STALE {
+		t.Errorf("expected stale metadata error, got %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddUint64(&ref.writes, 1)
+	}()
+	if err := repo.WaitForVersion(future, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart counts writes from zero, and versions after the restart
+	// are still higher than before.
+	restarted, _ := newTestMetadataRepo(t, 0)
+	restarted.repo.(*testRepoRef).local = ref.local
+	if err := restarted.loadVersionEpoch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.WaitForVersion(future, 0); err != nil {
+		t.Errorf("expected version before restart to be reached, got %v", err)
+	}
+	if v := restarted.Version()["idx1"]; v <= future["idx1"] {
+		t.Errorf("expected version %v over %v", v, future["idx1"])
+	}
+}
+
+func TestMetaVersionMerge(t *testing.T) {
+	v1 := MetaVersion{"idx1": 5, "idx2": 3}
+	v2 := MetaVersion{"idx2": 7, "idx3": 1}
+
+	merged := v1.Merge(v2)
+	expected := MetaVersion{"idx1": 5, "idx2": 7, "idx3": 1}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+	if v1["idx2"] != 3 {
+		t.Errorf("merge modified its receiver %v", v1)
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded MetaVersion
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %v, got %v", expected, decoded)
+	}
+}
@@ -0,0 +1,758 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+type MetadataEventType string
+
+const (
+	METADATA_EVENT_CREATE MetadataEventType = "create"
+	METADATA_EVENT_DROP   MetadataEventType = "drop"
+	METADATA_EVENT_STATE  MetadataEventType = "state"
+	METADATA_EVENT_LAG    MetadataEventType = "lag"
+
+	// progress events are only sent on the index status stream, they
+	// are not kept in the feed.
+	METADATA_EVENT_PROGRESS MetadataEventType = "progress"
+)
+
+type MetadataEvent struct {
+	Seqno  uint64             `json:"seqno"`
+	Type   MetadataEventType  `json:"type"`
+	DefnId common.IndexDefnId `json:"defnId"`
+	InstId common.IndexInstId `json:"instId,omitempty"`
+	Bucket string             `json:"bucket,omitempty"`
+	Name   string             `json:"name,omitempty"`
+	State  string             `json:"state,omitempty"`
+	Error  string             `json:"error,omitempty"`
+
+	// ingest lag, for lag events
+	Lag     uint64 `json:"lag,omitempty"`
+	Cleared bool   `json:"cleared,omitempty"`
+
+	// build progress in percent, for progress events
+	Progress int `json:"progress,omitempty"`
+}
+
+//
+// Reset is set if the events since the requested seqno are no longer
+// available (e.g. indexer restarted), and the caller must reload the full
+// index metadata.  Seqno is the seqno to watch from on next request.
+//
+type MetadataWatchResponse struct {
+	Version   uint64          `json:"version,omitempty"`
+	Code      string          `json:"code,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	IndexerId string          `json:"indexerId,omitempty"`
+	Seqno     uint64          `json:"seqno"`
+	Reset     bool            `json:"reset,omitempty"`
+	Events    []MetadataEvent `json:"events,omitempty"`
+}
+
+//
+// metadataFeed keeps the recent changes to the index metadata of this
+// node, so that clients can long poll for the changes rather than
+// polling the full index metadata.
+//
+type metadataFeed struct {
+	mutex   sync.Mutex
+	seqno   uint64
+	events  []MetadataEvent
+	changed chan bool // closed on every new event
+
+	defns  map[common.IndexDefnId]*common.IndexDefn
+	states map[common.IndexInstId]common.IndexState
+}
+
+///////////////////////////////////////////////////////
+// Package Local Function
+///////////////////////////////////////////////////////
+
+//
+// Create the metadata feed, listening to the metadata changes
+// of the index manager.
+//
+func newMetadataFeed(mgr *IndexManager) (*metadataFeed, error) {
+
+	// seqno is initialized from wall clock time, so that the seqno
+	// from before an indexer restart is detected as stale.
+	feed := &metadataFeed{
+		seqno:   uint64(time.Now().UnixNano()),
+		changed: make(chan bool),
+		defns:   make(map[common.IndexDefnId]*common.IndexDefn),
+		states:  make(map[common.IndexInstId]common.IndexState),
+	}
+
+	if err := feed.initialize(mgr.getMetadataRepo()); err != nil {
+		return nil, err
+	}
+
+	createId, createCh, err := mgr.StartListenIndexCreate("metadataFeed")
+	if err != nil {
+		return nil, err
+	}
+	dropId, dropCh, err := mgr.StartListenIndexDelete("metadataFeed")
+	if err != nil {
+		mgr.StopListenIndexCreate(createId)
+		return nil, err
+	}
+	topologyId, topologyCh, err := mgr.StartListenTopologyUpdate("metadataFeed")
+	if err != nil {
+		mgr.StopListenIndexCreate(createId)
+		mgr.StopListenIndexDelete(dropId)
+		return nil, err
+	}
+	_, lagCh, err := mgr.StartListenIngestLag("metadataFeed")
+	if err != nil {
+		mgr.StopListenIndexCreate(createId)
+		mgr.StopListenIndexDelete(dropId)
+		mgr.StopListenTopologyUpdate(topologyId)
+		return nil, err
+	}
+
+	go feed.run(createCh, dropCh, topologyCh, lagCh)
+
+	return feed, nil
+}
+
+//
+// Load the current metadata, so that only the changes are reported.
+//
+func (f *metadataFeed) initialize(repo *MetadataRepo) error {
+
+	iter, err := repo.NewIterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	_, defn, err := iter.Next()
+	for err == nil {
+		f.defns[defn.DefnId] = defn
+		_, defn, err = iter.Next()
+	}
+
+	iter1, err := repo.NewTopologyIterator()
+	if err != nil {
+		return err
+	}
+	defer iter1.Close()
+
+	topology, err := iter1.Next()
+	for err == nil {
+		for _, defnRef := range topology.Definitions {
+			for _, inst := range defnRef.Instances {
+				f.states[common.IndexInstId(inst.InstId)] = common.IndexState(inst.State)
+			}
+		}
+		topology, err = iter1.Next()
+	}
+
+	return nil
+}
+
+func (f *metadataFeed) run(createCh, dropCh, topologyCh, lagCh <-chan interface{}) {
+
+	for {
+		select {
+		case obj, ok := <-createCh:
+			if !ok {
+				return
+			}
+			f.handleCreate(obj)
+
+		case obj, ok := <-dropCh:
+			if !ok {
+				return
+			}
+			f.handleDrop(obj)
+
+		case obj, ok := <-topologyCh:
+			if !ok {
+				return
+			}
+			f.handleTopology(obj)
+
+		case obj, ok := <-lagCh:
+			if !ok {
+				return
+			}
+			f.handleIngestLag(obj)
+		}
+	}
+}
+
+func (f *metadataFeed) handleCreate(obj interface{}) {
+
+	data, ok := obj.([]byte)
+	if !ok {
+		return
+	}
+
+	defn, err := common.UnmarshallIndexDefn(data)
+	if err != nil {
+		logging.Warnf("metadataFeed: fail to unmarshall index definition. Error = %v", err)
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// index definition is updated, not created
+	if _, ok := f.defns[defn.DefnId]; ok {
+		f.defns[defn.DefnId] = defn
+		return
+	}
+
+	f.defns[defn.DefnId] = defn
+	f.append(MetadataEvent{
+		Type:   METADATA_EVENT_CREATE,
+		DefnId: defn.DefnId,
+		Bucket: defn.Bucket,
+		Name:   defn.Name,
+	})
+}
+
+func (f *metadataFeed) handleDrop(obj interface{}) {
+
+	key, ok := obj.([]byte)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(indexDefnIdFromKey(string(key)), 10, 64)
+	if err != nil {
+		logging.Warnf("metadataFeed: fail to parse index definition key %v. Error = %v", string(key), err)
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// the bucket of an unknown index is not known, and no client
+	// can be allowed to see the event.
+	defn, ok := f.defns[common.IndexDefnId(id)]
+	if !ok {
+		logging.Debugf("metadataFeed: skip drop of unknown index definition %v", id)
+		return
+	}
+	delete(f.defns, defn.DefnId)
+
+	f.append(MetadataEvent{
+		Type:   METADATA_EVENT_DROP,
+		DefnId: defn.DefnId,
+		Bucket: defn.Bucket,
+		Name:   defn.Name,
+	})
+}
+
+func (f *metadataFeed) handleTopology(obj interface{}) {
+
+	data, ok := obj.([]byte)
+	if !ok {
+		return
+	}
+
+	topology, err := unmarshallIndexTopology(data)
+	if err != nil {
+		logging.Warnf("metadataFeed: fail to unmarshall index topology. Error = %v", err)
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, defnRef := range topology.Definitions {
+		for _, inst := range defnRef.Instances {
+			instId := common.IndexInstId(inst.InstId)
+			state := common.IndexState(inst.State)
+
+			if old, ok := f.states[instId]; ok && old == state {
+				continue
+			}
+			f.states[instId] = state
+
+			f.append(MetadataEvent{
+				Type:   METADATA_EVENT_STATE,
+				DefnId: common.IndexDefnId(defnRef.DefnId),
+				InstId: instId,
+				Bucket: defnRef.Bucket,
+				Name:   defnRef.Name,
+				State:  state.String(),
+				Error:  inst.Error,
+			})
+
+			if state == common.INDEX_STATE_DELETED {
+				delete(f.states, instId)
+			}
+		}
+	}
+}
+
+func (f *metadataFeed) handleIngestLag(obj interface{}) {
+
+	lag, ok := obj.(IngestLagEvent)
+	if !ok {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.append(MetadataEvent{
+		Type:    METADATA_EVENT_LAG,
+		DefnId:  lag.DefnId,
+		InstId:  lag.InstId,
+		Bucket:  lag.Bucket,
+		Name:    lag.Name,
+		Lag:     lag.Lag,
+		Cleared: lag.Cleared,
+	})
+}
+
+//
+// Append an event and wake up the watchers.  Must be called with mutex held.
+//
+func (f *metadataFeed) append(event MetadataEvent) {
+
+	f.seqno++
+	event.Seqno = f.seqno
+
+	if len(f.events) >= METADATA_FEED_SIZE {
+		f.events = append(f.events[:0], f.events[1:]...)
+	}
+	f.events = append(f.events, event)
+
+	close(f.changed)
+	f.changed = make(chan bool)
+}
+
+func (f *metadataFeed) current() uint64 {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.seqno
+}
+
+//
+// Return the events after seqno, or a channel to wait on if there is none.
+//
+func (f *metadataFeed) since(seqno uint64) (events []MetadataEvent, latest uint64, reset bool, changed <-chan bool) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if seqno == f.seqno {
+		return nil, f.seqno, false, f.changed
+	}
+
+	if seqno > f.seqno || len(f.events) == 0 || seqno < f.events[0].Seqno-1 {
+		return nil, f.seqno, true, nil
+	}
+
+	first := len(f.events) - int(f.seqno-seqno)
+	events = make([]MetadataEvent, len(f.events)-first)
+	copy(events, f.events[first:])
+	return events, f.seqno, false, nil
+}
+
+///////////////////////////////////////////////////////
+// REST
+///////////////////////////////////////////////////////
+
+//
+// Long poll for the index metadata changes on this node after the
+// given seqno.  The request returns as soon as there is any change, or
+// after timeout (in seconds) with no events.  If seqno is not given,
+// the current seqno is returned immediately.
+//
+func (m *requestHandlerContext) handleWatchIndexMetadataRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	if m.feed == nil {
+		send(http.StatusInternalServerError, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Metadata feed is not available"})
+		return
+	}
+
+	indexerId, err := m.mgr.getMetadataRepo().GetLocalIndexerId()
+	if err != nil {
+		send(http.StatusInternalServerError, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: err.Error()})
+		return
+	}
+
+	timeout := DEFAULT_METADATA_WATCH_TIMEOUT
+	if str := r.FormValue("timeout"); len(str) != 0 {
+		secs, err := strconv.Atoi(str)
+		if err != nil || secs < 0 {
+			send(http.StatusBadRequest, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Invalid timeout " + str})
+			return
+		}
+		if time.Duration(secs)*time.Second < timeout {
+			timeout = time.Duration(secs) * time.Second
+		}
+	}
+
+	var events []MetadataEvent
+	var latest uint64
+	var reset bool
+
+	if str := r.FormValue("seqno"); len(str) != 0 {
+		seqno, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			send(http.StatusBadRequest, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Invalid seqno " + str})
+			return
+		}
+		events, latest, reset = m.watchIndexMetadata(r, seqno, timeout)
+	} else {
+		latest = m.feed.current()
+	}
+
+	isVisible := bucketVisibility(m.getBucket(r), func(bucket string) bool {
+		permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+		return isAllowed(creds, []string{permission}, nil)
+	})
+
+	resp := &MetadataWatchResponse{
+		Code:      RESP_SUCCESS,
+		IndexerId: string(indexerId),
+		Seqno:     latest,
+		Reset:     reset,
+	}
+	for _, event := range events {
+		if isVisible(event.Bucket) {
+			resp.Events = append(resp.Events, event)
+		}
+	}
+
+	send(http.StatusOK, w, resp)
+}
+
+//
+// Return a filter on the buckets visible to a request: the requested
+// bucket, if any, among the buckets the caller is permitted to list.  The
+// permission is checked once per bucket, and never for an empty bucket.
+//
+func bucketVisibility(bucket string, permitted func(string) bool) func(string) bool {
+
+	allowed := make(map[string]bool)
+
+	return func(bucketn string) bool {
+		if len(bucketn) == 0 {
+			return false
+		}
+		if len(bucket) != 0 && bucket != bucketn {
+			return false
+		}
+		if _, ok := allowed[bucketn]; !ok {
+			allowed[bucketn] = permitted(bucketn)
+		}
+		return allowed[bucketn]
+	}
+}
+
+func (m *requestHandlerContext) watchIndexMetadata(r *http.Request, seqno uint64,
+	timeout time.Duration) ([]MetadataEvent, uint64, bool) {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		events, latest, reset, changed := m.feed.since(seqno)
+		if changed == nil {
+			return events, latest, reset
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, latest, false
+		case <-r.Context().Done():
+			return nil, latest, false
+		}
+	}
+}
+
+//
+// Stream the index status changes on this node as server-sent events,
+// so that admin UI can live update without polling.  The events of the
+// feed (create, drop, state and lag) are sent with the seqno as event
+// id, so that a reconnecting client resumes from Last-Event-ID.  A reset
+// event is sent if the events since that seqno are no longer available.
+// Build progress of the building indexes is sampled from the indexer
+// stats every progress interval (in seconds, 0 to disable), and sent as
+// progress events whenever it changes.
+//
+func (m *requestHandlerContext) handleStreamIndexStatusRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	if m.feed == nil {
+		send(http.StatusInternalServerError, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Metadata feed is not available"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		send(http.StatusInternalServerError, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Streaming is not supported"})
+		return
+	}
+
+	interval := DEFAULT_STATUS_STREAM_PROGRESS
+	if str := r.FormValue("progress"); len(str) != 0 {
+		secs, err := strconv.Atoi(str)
+		if err != nil || secs < 0 {
+			send(http.StatusBadRequest, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Invalid progress " + str})
+			return
+		}
+		interval = time.Duration(secs) * time.Second
+	}
+
+	seqno := m.feed.current()
+	str := r.Header.Get("Last-Event-ID")
+	if len(str) == 0 {
+		str = r.FormValue("seqno")
+	}
+	if len(str) != 0 {
+		var err error
+		if seqno, err = strconv.ParseUint(str, 10, 64); err != nil {
+			send(http.StatusBadRequest, w, &MetadataWatchResponse{Code: RESP_ERROR, Error: "Invalid seqno " + str})
+			return
+		}
+	}
+
+	isVisible := bucketVisibility(m.getBucket(r), func(bucket string) bool {
+		permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+		return isAllowed(creds, []string{permission}, nil)
+	})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var progressCh <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		progressCh = ticker.C
+	}
+	heartbeat := time.NewTicker(DEFAULT_STATUS_STREAM_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	progress := make(map[common.IndexInstId]int)
+	buildProgress := func() []MetadataEvent {
+		return m.getBuildProgress(isVisible, progress)
+	}
+
+	streamIndexStatus(w, flusher, m.feed, seqno, isVisible,
+		progressCh, heartbeat.C, buildProgress, r.Context().Done())
+}
+
+//
+// Write the events of the feed after seqno as server-sent events, till
+// done is closed or the client goes away.  Build progress events are
+// written on every tick of progressCh, and a comment on every tick of
+// heartbeatCh to keep the connection alive.
+//
+func streamIndexStatus(w http.ResponseWriter, flusher http.Flusher, feed *metadataFeed,
+	seqno uint64, isVisible func(string) bool, progressCh, heartbeatCh <-chan time.Time,
+	buildProgress func() []MetadataEvent, done <-chan struct{}) {
+
+	for {
+		events, latest, reset, changed := feed.since(seqno)
+
+		if reset {
+			data := fmt.Sprintf("{\"seqno\":%v}", latest)
+			if err := writeStatusEvent(w, fmt.Sprintf("%v", latest), "reset", []byte(data)); err != nil {
+				return
+			}
+		}
+		for _, event := range events {
+			if !isVisible(event.Bucket) {
+				continue
+			}
+			if err := writeStatusEventJSON(w, fmt.Sprintf("%v", event.Seqno), string(event.Type), &event); err != nil {
+				return
+			}
+		}
+		seqno = latest
+		flusher.Flush()
+
+		if changed == nil {
+			continue
+		}
+
+		select {
+		case <-changed:
+
+		case <-progressCh:
+			for _, event := range buildProgress() {
+				if err := writeStatusEventJSON(w, "", string(event.Type), &event); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+
+		case <-heartbeatCh:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-done:
+			return
+		}
+	}
+}
+
+//
+// Return a progress event for each building index instance whose build
+// progress has changed since last reported in progress.
+//
+func (m *requestHandlerContext) getBuildProgress(isVisible func(string) bool,
+	progress map[common.IndexInstId]int) []MetadataEvent {
+
+	var building []MetadataEvent
+
+	iter, err := m.mgr.getMetadataRepo().NewTopologyIterator()
+	if err != nil {
+		return nil
+	}
+	defer iter.Close()
+
+	current := make(map[common.IndexInstId]bool)
+	for topology, err := iter.Next(); err == nil; topology, err = iter.Next() {
+		if !isVisible(topology.Bucket) {
+			continue
+		}
+		for _, defnRef := range topology.Definitions {
+			for _, inst := range defnRef.Instances {
+				state := common.IndexState(inst.State)
+				if state != common.INDEX_STATE_INITIAL && state != common.INDEX_STATE_CATCHUP {
+					continue
+				}
+				current[common.IndexInstId(inst.InstId)] = true
+				building = append(building, MetadataEvent{
+					Type:   METADATA_EVENT_PROGRESS,
+					DefnId: common.IndexDefnId(defnRef.DefnId),
+					InstId: common.IndexInstId(inst.InstId),
+					Bucket: defnRef.Bucket,
+					Name:   common.FormatIndexInstDisplayName(defnRef.Name, int(inst.ReplicaId)),
+					State:  state.String(),
+				})
+			}
+		}
+	}
+
+	for instId := range progress {
+		if !current[instId] {
+			delete(progress, instId)
+		}
+	}
+
+	if len(building) == 0 {
+		return nil
+	}
+
+	stats, err := m.getLocalStats()
+	if err != nil {
+		logging.Debugf("RequestHandler::getBuildProgress: Error while retrieving stats %v", err)
+		return nil
+	}
+
+	var events []MetadataEvent
+	for _, event := range building {
+		key := fmt.Sprintf("%v:%v:build_progress", event.Bucket, event.Name)
+		value, ok := stats[key].(float64)
+		if !ok {
+			continue
+		}
+		event.Progress = int(value)
+		if last, ok := progress[event.InstId]; ok && last == event.Progress {
+			continue
+		}
+		progress[event.InstId] = event.Progress
+		events = append(events, event)
+	}
+	return events
+}
+
+func (m *requestHandlerContext) getLocalStats() (map[string]interface{}, error) {
+
+	cinfo := m.mgr.cinfoClient.GetClusterInfoCache()
+	if cinfo == nil {
+		return nil, fmt.Errorf("ClusterInfoCache unavailable in IndexManager")
+	}
+
+	cinfo.RLock()
+	addr, err := cinfo.GetLocalServiceAddress(common.INDEX_HTTP_SERVICE)
+	cinfo.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := getWithAuth(addr + "/stats?async=true")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	stats := new(common.Statistics)
+	if status := convertResponse(resp, stats); status == RESP_ERROR {
+		return nil, fmt.Errorf("Fail to read stats from %v", addr)
+	}
+	return stats.ToMap(), nil
+}
+
+func writeStatusEventJSON(w http.ResponseWriter, id string, eventType string, event *MetadataEvent) error {
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return writeStatusEvent(w, id, eventType, data)
+}
+
+func writeStatusEvent(w http.ResponseWriter, id string, eventType string, data []byte) error {
+
+	var buf []byte
+	if len(id) != 0 {
+		buf = append(buf, "id: "+id+"\n"...)
+	}
+	buf = append(buf, "event: "+eventType+"\n"...)
+	buf = append(buf, "data: "...)
+	buf = append(buf, data...)
+	buf = append(buf, "\n\n"...)
+
+	_, err := w.Write(buf)
+	return err
+}
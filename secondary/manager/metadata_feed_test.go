@@ -0,0 +1,155 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestMetadataFeed() *metadataFeed {
+	return &metadataFeed{
+		changed: make(chan bool),
+		defns:   make(map[common.IndexDefnId]*common.IndexDefn),
+		states:  make(map[common.IndexInstId]common.IndexState),
+	}
+}
+
+func TestMetadataFeedDrop(t *testing.T) {
+	f := newTestMetadataFeed()
+
+	defn := &common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx"}
+	data, err := common.MarshallIndexDefn(defn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.handleCreate(data)
+
+	// an unknown index has no bucket, and is not reported.
+	f.handleDrop([]byte(indexDefnKeyById(11)))
+	f.handleDrop([]byte(indexDefnKeyById(10)))
+
+	events, latest, reset, _ := f.since(0)
+	if reset || latest != 2 || len(events) != 2 {
+		t.Fatalf("unexpected events %v latest %v reset %v", events, latest, reset)
+	}
+	drop := events[1]
+	if drop.Type != METADATA_EVENT_DROP || drop.DefnId != 10 || drop.Bucket != "default" || drop.Name != "idx" {
+		t.Errorf("unexpected drop event %v", drop)
+	}
+	if _, ok := f.defns[10]; ok {
+		t.Errorf("expected definition to be removed")
+	}
+}
+
+func TestBucketVisibility(t *testing.T) {
+	checked := make(map[string]int)
+	permitted := func(bucket string) bool {
+		checked[bucket]++
+		return bucket != "private"
+	}
+
+	isVisible := bucketVisibility("", permitted)
+	for _, bucket := range []string{"default", "default", "private", ""} {
+		isVisible(bucket)
+	}
+	if !isVisible("default") || isVisible("private") || isVisible("") {
+		t.Errorf("unexpected visibility")
+	}
+	if checked["default"] != 1 || checked["private"] != 1 {
+		t.Errorf("expected one permission check per bucket, got %v", checked)
+	}
+	if _, ok := checked[""]; ok {
+		t.Errorf("expected no permission check for an empty bucket")
+	}
+
+	isVisible = bucketVisibility("default", permitted)
+	if !isVisible("default") || isVisible("other") {
+		t.Errorf("expected only the requested bucket to be visible")
+	}
+	if _, ok := checked["other"]; ok {
+		t.Errorf("expected no permission check for other buckets")
+	}
+}
+
+func TestStreamIndexStatus(t *testing.T) {
+	f := newTestMetadataFeed()
+	for _, defn := range []*common.IndexDefn{
+		{DefnId: 10, Bucket: "default", Name: "idx"},
+		{DefnId: 20, Bucket: "private", Name: "secret"},
+	} {
+		data, err := common.MarshallIndexDefn(defn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.handleCreate(data)
+	}
+
+	isVisible := bucketVisibility("", func(bucket string) bool { return bucket != "private" })
+	buildProgress := func() []MetadataEvent {
+		return []MetadataEvent{{Type: METADATA_EVENT_PROGRESS, DefnId: 10, Bucket: "default", Name: "idx", Progress: 50}}
+	}
+	progressCh, heartbeatCh := make(chan time.Time), make(chan time.Time)
+	done, exited := make(chan struct{}), make(chan bool)
+
+	w := httptest.NewRecorder()
+	go func() {
+		streamIndexStatus(w, w, f, 0, isVisible, progressCh, heartbeatCh, buildProgress, done)
+		close(exited)
+	}()
+
+	progressCh <- time.Now()
+	heartbeatCh <- time.Now()
+	f.handleDrop([]byte(indexDefnKeyById(10)))
+	heartbeatCh <- time.Now()
+	close(done)
+	<-exited
+
+	body := w.Body.String()
+	create := strings.Index(body, "id: 1\nevent: create\ndata: {")
+	progress := strings.Index(body, "event: progress\ndata: {")
+	heartbeat := strings.Index(body, ": heartbeat\n\n")
+	drop := strings.Index(body, "id: 3\nevent: drop\ndata: {")
+	if create < 0 || progress < create || heartbeat < progress || drop < heartbeat {
+		t.Fatalf("unexpected event stream %q", body)
+	}
+	if !strings.Contains(body[progress:], `"progress":50`) {
+		t.Errorf("expected build progress in %q", body)
+	}
+	if strings.Contains(body, "secret") || strings.Contains(body, "id: 2\n") {
+		t.Errorf("unexpected event of a bucket not permitted in %q", body)
+	}
+}
+
+func TestStreamIndexStatusReset(t *testing.T) {
+	f := newTestMetadataFeed()
+	data, err := common.MarshallIndexDefn(&common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.handleCreate(data)
+
+	done := make(chan struct{})
+	close(done)
+
+	// the client resumes from a seqno the feed does not have.
+	w := httptest.NewRecorder()
+	isVisible := bucketVisibility("", func(string) bool { return true })
+	streamIndexStatus(w, w, f, 10, isVisible, nil, nil, nil, done)
+
+	expected := "id: 1\nevent: reset\ndata: {\"seqno\":1}\n\n"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("expected %q, got %q", expected, body)
+	}
+}
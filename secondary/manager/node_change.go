@@ -0,0 +1,447 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/planner"
+)
+
+/////////////////////////////////////////////////////////////////////////////
+// Type Declaration
+/////////////////////////////////////////////////////////////////////////////
+
+//
+// IndexMove is the move of an index partition from one indexer node to
+// another, as decided by the planner.
+//
+type IndexMove struct {
+	Bucket  string             `json:"bucket"`
+	Name    string             `json:"name"`
+	DefnId  common.IndexDefnId `json:"defnId"`
+	InstId  common.IndexInstId `json:"instId"`
+	PartnId common.PartitionId `json:"partnId"`
+	Source  string             `json:"source"`
+	Dest    string             `json:"dest"`
+	Rebuild bool               `json:"rebuild"`
+	Done    bool               `json:"done"`
+}
+
+//
+// NodeChangePlan is the redistribution of index instances for adding,
+// removing or swapping an indexer node.  For a swap, Node is the departing
+// node and SwapNode the incoming node.  The index manager does not move
+// index partitions itself; the moves are carried out by rebalance.
+// Progress is the fraction of moves observed in the index layout of the
+// cluster.  A plan does not block later node changes, which replace it.
+//
+type NodeChangePlan struct {
+	Node      string       `json:"node"`
+	SwapNode  string       `json:"swapNode,omitempty"`
+	AddNode   bool         `json:"addNode"`
+	Moves     []*IndexMove `json:"moves"`
+	Progress  float64      `json:"progress"`
+	Cancelled bool         `json:"cancelled"`
+	Created   int64        `json:"created"`
+
+	// departing node of a swap is removed from the coordinator
+	// membership
+	swapDone bool
+}
+
+type partnKey struct {
+	instId  common.IndexInstId
+	partnId common.PartitionId
+}
+
+// prefix of the node id given by the planner to an added node
+const plannerNewNodePrefix = "newNode-"
+
+// index layout of the cluster, replaced by tests
+var retrievePlanFromCluster = planner.RetrievePlanFromCluster
+
+/////////////////////////////////////////////////////////////////////////////
+// Public API
+/////////////////////////////////////////////////////////////////////////////
+
+//
+// Add an indexer node at addr.  The node is added to the coordinator
+// membership, and the planner is run to redistribute index instances
+// over the cluster including the new node.  The returned plan reports the
+// index partitions to be moved onto the new node.
+//
+func (m *IndexManager) AddIndexerNode(addr string) (*NodeChangePlan, error) {
+	return m.changeIndexerNode(addr, true)
+}
+
+//
+// Remove the indexer node at addr.  The node is removed from the
+// coordinator membership, and the planner is run to redistribute the
+// index instances of the node over the remaining nodes.  The returned
+// plan reports the index partitions to be moved out of the node.
+//
+func (m *IndexManager) RemoveIndexerNode(addr string) (*NodeChangePlan, error) {
+	return m.changeIndexerNode(addr, false)
+}
+
+//
+// Swap the indexer node at outAddr with a new indexer node at inAddr.
+// Every index partition of the departing node is recreated on the
+// incoming node, from a replica if there is one, or else rebuilt.  The
+// departing node is removed from the coordinator membership only after
+// all the partitions are recreated, as observed by GetNodeChangePlan().
+//
+func (m *IndexManager) SwapIndexerNode(outAddr string, inAddr string) (*NodeChangePlan, error) {
+
+	inHost, err := m.validateNodeChange(inAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.validateNodeChange(outAddr); err != nil {
+		return nil, err
+	}
+	if outAddr == inAddr {
+		return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, nil,
+			fmt.Sprintf("Cannot swap indexer node %v with itself", outAddr))
+	}
+
+	plan, err := retrievePlanFromCluster(m.clusterURL, nil)
+	if err != nil {
+		return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+			fmt.Sprintf("Fail to read index layout from cluster %v", m.clusterURL))
+	}
+
+	var departing *planner.IndexerNode
+	for _, indexer := range plan.Placement {
+		switch indexer.NodeId {
+		case outAddr:
+			departing = indexer
+		case inAddr:
+			return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, nil,
+				fmt.Sprintf("Indexer node %v is already in the cluster", inAddr))
+		}
+	}
+	if departing == nil {
+		return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, nil,
+			fmt.Sprintf("Indexer node %v is not in the cluster", outAddr))
+	}
+
+	changePlan := &NodeChangePlan{
+		Node:     outAddr,
+		SwapNode: inAddr,
+		Progress: 1.0,
+		Created:  time.Now().UnixNano(),
+	}
+
+	for _, index := range departing.Indexes {
+		changePlan.Moves = append(changePlan.Moves, &IndexMove{
+			Bucket:  index.Bucket,
+			Name:    index.Name,
+			DefnId:  index.DefnId,
+			InstId:  index.InstId,
+			PartnId: index.PartnId,
+			Source:  outAddr,
+			Dest:    inAddr,
+			Rebuild: !hasReplicaPartition(plan.Placement, outAddr, index),
+		})
+	}
+	if len(changePlan.Moves) != 0 {
+		changePlan.Progress = 0.0
+	}
+
+	m.mutex.Lock()
+	coordinator := m.coordinator
+	m.mutex.Unlock()
+
+	if coordinator != nil {
+		if err := coordinator.addPeer(inHost); err != nil {
+			return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+				fmt.Sprintf("Fail to update coordinator membership for %v", inAddr))
+		}
+	}
+
+	m.mutex.Lock()
+	m.nodeChange = changePlan
+	m.mutex.Unlock()
+
+	logging.Infof("IndexManager.SwapIndexerNode(): swap node %v with %v moves %v", outAddr, inAddr, len(changePlan.Moves))
+
+	// complete right away if the departing node has no index
+	m.GetNodeChangePlan()
+	return changePlan, nil
+}
+
+//
+// Cancel the node change in progress.  Partitions already recreated are
+// left in place, but no further partition is moved and the coordinator
+// membership is restored.  For a swap, the departing node is retained.
+//
+func (m *IndexManager) CancelNodeChange() error {
+
+	changePlan, err := m.GetNodeChangePlan()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	current := m.nodeChange
+	if changePlan == nil || current == nil || current.Cancelled || current.Progress >= 1.0 {
+		m.mutex.Unlock()
+		return NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, nil, "No node change in progress")
+	}
+	current.Cancelled = true
+	coordinator := m.coordinator
+	m.mutex.Unlock()
+
+	if coordinator != nil {
+		var err error
+		switch {
+		case current.SwapNode != "":
+			err = removePeerAddr(coordinator, current.SwapNode)
+		case current.AddNode:
+			err = removePeerAddr(coordinator, current.Node)
+		default:
+			err = addPeerAddr(coordinator, current.Node)
+		}
+		if err != nil {
+			logging.Warnf("IndexManager.CancelNodeChange(): Fail to restore coordinator membership: %v", err)
+		}
+	}
+
+	logging.Infof("IndexManager.CancelNodeChange(): node change for %v cancelled at progress %v",
+		current.Node, current.Progress)
+	return nil
+}
+
+//
+// Get the plan of the last node change, with its progress refreshed from
+// the index layout of the cluster.
+//
+func (m *IndexManager) GetNodeChangePlan() (*NodeChangePlan, error) {
+
+	m.mutex.Lock()
+	changePlan := m.nodeChange
+	m.mutex.Unlock()
+
+	if changePlan == nil {
+		return nil, nil
+	}
+
+	plan, err := retrievePlanFromCluster(m.clusterURL, nil)
+	if err != nil {
+		return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+			fmt.Sprintf("Fail to read index layout from cluster %v", m.clusterURL))
+	}
+
+	current := placementByPartition(plan.Placement)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	done := 0
+	for _, move := range changePlan.Moves {
+		if !move.Done {
+			move.Done = current[partnKey{move.InstId, move.PartnId}] == move.Dest
+		}
+		if move.Done {
+			done++
+		}
+	}
+
+	changePlan.Progress = 1.0
+	if len(changePlan.Moves) != 0 {
+		changePlan.Progress = float64(done) / float64(len(changePlan.Moves))
+	}
+
+	// the departing node of a swap leaves once all its partitions are
+	// recreated on the incoming node.
+	if changePlan.SwapNode != "" && !changePlan.Cancelled && !changePlan.swapDone && changePlan.Progress >= 1.0 {
+		changePlan.swapDone = true
+		if coordinator := m.coordinator; coordinator != nil {
+			node := changePlan.Node
+			go func() {
+				if err := removePeerAddr(coordinator, node); err != nil {
+					logging.Warnf("IndexManager.GetNodeChangePlan(): Fail to remove %v from coordinator membership: %v", node, err)
+				}
+			}()
+		}
+		logging.Infof("IndexManager.GetNodeChangePlan(): swap of node %v with %v is done", changePlan.Node, changePlan.SwapNode)
+	}
+
+	result := *changePlan
+	result.Moves = make([]*IndexMove, len(changePlan.Moves))
+	for i, move := range changePlan.Moves {
+		clone := *move
+		result.Moves[i] = &clone
+	}
+	return &result, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+//
+// Validate the node address.  Return the host of the node.  A plan of an
+// earlier node change, whose moves are not all carried out, is replaced.
+//
+func (m *IndexManager) validateNodeChange(addr string) (string, error) {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+			fmt.Sprintf("Invalid indexer node address %v", addr))
+	}
+
+	if changePlan, err := m.GetNodeChangePlan(); err == nil && changePlan != nil &&
+		changePlan.Progress < 1.0 && !changePlan.Cancelled {
+		logging.Infof("IndexManager.validateNodeChange(): replace plan for node %v at progress %v",
+			changePlan.Node, changePlan.Progress)
+	}
+
+	return host, nil
+}
+
+func (m *IndexManager) changeIndexerNode(addr string, addNode bool) (*NodeChangePlan, error) {
+
+	host, err := m.validateNodeChange(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := retrievePlanFromCluster(m.clusterURL, nil)
+	if err != nil {
+		return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+			fmt.Sprintf("Fail to read index layout from cluster %v", m.clusterURL))
+	}
+
+	// planner may modify the placement
+	initial := placementByPartition(plan.Placement)
+
+	var solution *planner.Solution
+	if addNode {
+		solution, err = planner.ExecuteRebalanceWithOptions(plan, nil, false, "", "", 1, -1, -1, false, nil)
+	} else {
+		solution, err = planner.ExecuteRebalanceWithOptions(plan, nil, false, "", "", 0, -1, -1, false, []string{addr})
+	}
+	if err != nil || solution == nil {
+		return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+			fmt.Sprintf("Planner fails to redistribute indexes for node %v", addr))
+	}
+
+	changePlan := &NodeChangePlan{
+		Node:     addr,
+		AddNode:  addNode,
+		Progress: 1.0,
+		Created:  time.Now().UnixNano(),
+	}
+
+	for _, indexer := range solution.Placement {
+		dest := indexer.NodeId
+		if strings.HasPrefix(dest, plannerNewNodePrefix) {
+			dest = addr
+		}
+		for _, index := range indexer.Indexes {
+			source, ok := initial[partnKey{index.InstId, index.PartnId}]
+			if !ok || source == dest {
+				continue
+			}
+			changePlan.Moves = append(changePlan.Moves, &IndexMove{
+				Bucket:  index.Bucket,
+				Name:    index.Name,
+				DefnId:  index.DefnId,
+				InstId:  index.InstId,
+				PartnId: index.PartnId,
+				Source:  source,
+				Dest:    dest,
+			})
+		}
+	}
+	if len(changePlan.Moves) != 0 {
+		changePlan.Progress = 0.0
+	}
+
+	m.mutex.Lock()
+	coordinator := m.coordinator
+	m.mutex.Unlock()
+
+	if coordinator != nil {
+		if addNode {
+			err = coordinator.addPeer(host)
+		} else {
+			err = coordinator.removePeer(host)
+		}
+		if err != nil {
+			return nil, NewError(ERROR_MGR_NODE_CHANGE, NORMAL, INDEX_MANAGER, err,
+				fmt.Sprintf("Fail to update coordinator membership for %v", addr))
+		}
+	}
+
+	m.mutex.Lock()
+	m.nodeChange = changePlan
+	m.mutex.Unlock()
+
+	logging.Infof("IndexManager.changeIndexerNode(): node %v add %v moves %v", addr, addNode, len(changePlan.Moves))
+	return changePlan, nil
+}
+
+//
+// Check if a partition of index has a replica on a node other than addr.
+//
+func hasReplicaPartition(placement []*planner.IndexerNode, addr string, index *planner.IndexUsage) bool {
+
+	for _, indexer := range placement {
+		if indexer.NodeId == addr {
+			continue
+		}
+		for _, other := range indexer.Indexes {
+			if other.DefnId == index.DefnId && other.PartnId == index.PartnId && other.InstId != index.InstId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func addPeerAddr(coordinator *Coordinator, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	return coordinator.addPeer(host)
+}
+
+func removePeerAddr(coordinator *Coordinator, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	return coordinator.removePeer(host)
+}
+
+//
+// Map each index partition to the node holding it.
+//
+func placementByPartition(placement []*planner.IndexerNode) map[partnKey]string {
+
+	result := make(map[partnKey]string)
+	for _, indexer := range placement {
+		for _, index := range indexer.Indexes {
+			result[partnKey{index.InstId, index.PartnId}] = indexer.NodeId
+		}
+	}
+	return result
+}
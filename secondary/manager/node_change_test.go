@@ -0,0 +1,222 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/planner"
+)
+
+func setTestLayout(t *testing.T, placement map[string][]*planner.IndexUsage) func() {
+
+	saved := retrievePlanFromCluster
+	retrievePlanFromCluster = func(string, []string) (*planner.Plan, error) {
+		plan := &planner.Plan{}
+		for nodeId, indexes := range placement {
+			plan.Placement = append(plan.Placement, &planner.IndexerNode{NodeId: nodeId, Indexes: indexes})
+		}
+		return plan, nil
+	}
+	return func() { retrievePlanFromCluster = saved }
+}
+
+func testIndexUsage(instId common.IndexInstId, partnId common.PartitionId) *planner.IndexUsage {
+	return &planner.IndexUsage{
+		DefnId: common.IndexDefnId(instId), InstId: instId, PartnId: partnId,
+		Name: "idx", Bucket: "default",
+	}
+}
+
+func TestNodeChangePlanProgress(t *testing.T) {
+
+	p1, p2 := testIndexUsage(1, 1), testIndexUsage(1, 2)
+	layout := map[string][]*planner.IndexUsage{
+		"127.0.0.1:9001": {p1, p2},
+		"127.0.0.1:9002": {},
+	}
+	defer setTestLayout(t, layout)()
+
+	m := &IndexManager{clusterURL: "127.0.0.1:9000"}
+	m.nodeChange = &NodeChangePlan{
+		Node: "127.0.0.1:9001",
+		Moves: []*IndexMove{
+			{InstId: 1, PartnId: 1, Source: "127.0.0.1:9001", Dest: "127.0.0.1:9002"},
+			{InstId: 1, PartnId: 2, Source: "127.0.0.1:9001", Dest: "127.0.0.1:9002"},
+		},
+	}
+
+	changePlan, err := m.GetNodeChangePlan()
+	if err != nil {
+		t.Fatal(err)
+	} else if changePlan.Progress != 0.0 {
+		t.Fatalf("expected progress 0, got %v", changePlan.Progress)
+	}
+
+	// progress follows the moves carried out in the cluster.
+	layout["127.0.0.1:9001"] = []*planner.IndexUsage{p2}
+	layout["127.0.0.1:9002"] = []*planner.IndexUsage{p1}
+	if changePlan, _ = m.GetNodeChangePlan(); changePlan.Progress != 0.5 {
+		t.Fatalf("expected progress 0.5, got %v", changePlan.Progress)
+	}
+	if !changePlan.Moves[0].Done || changePlan.Moves[1].Done {
+		t.Errorf("unexpected moves done %v %v", changePlan.Moves[0].Done, changePlan.Moves[1].Done)
+	}
+
+	// a plan not carried out does not block a later node change.
+	if _, err := m.validateNodeChange("127.0.0.1:9003"); err != nil {
+		t.Errorf("expected pending plan to be replaced, got %v", err)
+	}
+	if _, err := m.validateNodeChange("127.0.0.1"); err == nil {
+		t.Errorf("expected error for address without port")
+	}
+}
+
+func TestPlacementByPartition(t *testing.T) {
+
+	p1, p2, r1 := testIndexUsage(1, 1), testIndexUsage(1, 2), testIndexUsage(2, 1)
+	r1.DefnId = p1.DefnId
+	placement := []*planner.IndexerNode{
+		{NodeId: "n1", Indexes: []*planner.IndexUsage{p1, p2}},
+		{NodeId: "n2", Indexes: []*planner.IndexUsage{r1}},
+	}
+
+	result := placementByPartition(placement)
+	if len(result) != 3 || result[partnKey{1, 1}] != "n1" || result[partnKey{2, 1}] != "n2" {
+		t.Errorf("unexpected placement %v", result)
+	}
+
+	if !hasReplicaPartition(placement, "n1", p1) {
+		t.Errorf("expected partition 1 to have a replica")
+	}
+	if hasReplicaPartition(placement, "n1", p2) {
+		t.Errorf("expected partition 2 to have no replica")
+	}
+}
+
+func TestEnvPeers(t *testing.T) {
+
+	udpAddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:9885")
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9886")
+	e := &env{hostUDPAddr: udpAddr, hostTCPAddr: tcpAddr}
+
+	// peer addresses are read by the coordinator while nodes change.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				e.getPeerUDPAddr()
+				e.findMatchingPeerTCPAddr("127.0.0.2:9885")
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		if err := e.addPeer("127.0.0.2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.removePeer("127.0.0.2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	e.addPeer("127.0.0.2")
+	e.addPeer("127.0.0.2")
+	if peers := e.getPeerUDPAddr(); len(peers) != 1 || peers[0] != "127.0.0.2:9885" {
+		t.Errorf("unexpected peers %v", peers)
+	}
+	if addr := e.findMatchingPeerTCPAddr("127.0.0.2:9885"); addr != "127.0.0.2:9886" {
+		t.Errorf("unexpected peer tcp address %v", addr)
+	}
+	e.removePeer("127.0.0.2")
+	if peers := e.getPeerUDPAddr(); len(peers) != 0 {
+		t.Errorf("unexpected peers %v", peers)
+	}
+}
+
+func TestSwapIndexerNode(t *testing.T) {
+
+	// partition 1 has a replica on n2, partition 2 has none.
+	p1, p2, r1 := testIndexUsage(1, 1), testIndexUsage(1, 2), testIndexUsage(2, 1)
+	r1.DefnId = p1.DefnId
+	layout := map[string][]*planner.IndexUsage{
+		"127.0.0.1:9001": {p1, p2},
+		"127.0.0.1:9002": {r1},
+	}
+	defer setTestLayout(t, layout)()
+
+	m := &IndexManager{clusterURL: "127.0.0.1:9000"}
+
+	if _, err := m.SwapIndexerNode("127.0.0.1:9001", "127.0.0.1:9001"); err == nil {
+		t.Errorf("expected error swapping a node with itself")
+	}
+	if _, err := m.SwapIndexerNode("127.0.0.1:9001", "127.0.0.1:9002"); err == nil {
+		t.Errorf("expected error swapping in a node of the cluster")
+	}
+	if _, err := m.SwapIndexerNode("127.0.0.1:9004", "127.0.0.1:9003"); err == nil {
+		t.Errorf("expected error swapping out a node not in the cluster")
+	}
+
+	changePlan, err := m.SwapIndexerNode("127.0.0.1:9001", "127.0.0.1:9003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changePlan.Moves) != 2 || changePlan.Progress != 0.0 {
+		t.Fatalf("unexpected plan %v moves progress %v", len(changePlan.Moves), changePlan.Progress)
+	}
+	for _, move := range changePlan.Moves {
+		if move.Source != "127.0.0.1:9001" || move.Dest != "127.0.0.1:9003" {
+			t.Errorf("unexpected move %v -> %v", move.Source, move.Dest)
+		}
+		if move.Rebuild != (move.PartnId == 2) {
+			t.Errorf("partition %v: unexpected rebuild %v", move.PartnId, move.Rebuild)
+		}
+	}
+
+	layout["127.0.0.1:9001"] = nil
+	layout["127.0.0.1:9003"] = []*planner.IndexUsage{p1, p2}
+	if changePlan, _ = m.GetNodeChangePlan(); changePlan.Progress != 1.0 {
+		t.Fatalf("expected progress 1, got %v", changePlan.Progress)
+	}
+	if !m.nodeChange.swapDone {
+		t.Errorf("expected departing node to be removed once the swap is done")
+	}
+	if err := m.CancelNodeChange(); err == nil {
+		t.Errorf("expected error cancelling a completed node change")
+	}
+}
+
+func TestCancelSwapIndexerNode(t *testing.T) {
+
+	layout := map[string][]*planner.IndexUsage{
+		"127.0.0.1:9001": {testIndexUsage(1, 1)},
+	}
+	defer setTestLayout(t, layout)()
+
+	m := &IndexManager{clusterURL: "127.0.0.1:9000"}
+	if _, err := m.SwapIndexerNode("127.0.0.1:9001", "127.0.0.1:9003"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CancelNodeChange(); err != nil {
+		t.Fatal(err)
+	}
+	changePlan, _ := m.GetNodeChangePlan()
+	if !changePlan.Cancelled || changePlan.Progress != 0.0 {
+		t.Errorf("unexpected cancelled %v progress %v", changePlan.Cancelled, changePlan.Progress)
+	}
+	if err := m.CancelNodeChange(); err == nil {
+		t.Errorf("expected error cancelling twice")
+	}
+}
@@ -19,9 +19,11 @@ import (
 	"github.com/couchbase/indexing/secondary/manager/client"
 	mc "github.com/couchbase/indexing/secondary/manager/common"
 	"github.com/couchbase/indexing/secondary/planner"
+	qparser "github.com/couchbase/query/expression/parser"
 	"io"
 	"math"
 	"net/http"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -51,13 +53,16 @@ type IndexRequest struct {
 	Index    common.IndexDefn       `json:"index,omitempty"`
 	IndexIds client.IndexIdList     `json:indexIds,omitempty"`
 	Plan     map[string]interface{} `json:plan,omitempty"`
+	Names    []string               `json:"names,omitempty"` // build indexes of Index.Bucket by name
 }
 
 type IndexResponse struct {
-	Version uint64 `json:"version,omitempty"`
-	Code    string `json:"code,omitempty"`
-	Error   string `json:"error,omitempty"`
-	Message string `json:"message,omitempty"`
+	Version     uint64               `json:"version,omitempty"`
+	Code        string               `json:"code,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	Message     string               `json:"message,omitempty"`
+	MetaVersion MetaVersion          `json:"metaVersion,omitempty"` // metadata version after the DDL
+	DryRun      *client.DryRunResult `json:"dryRun,omitempty"`      // plan of a dry run request
 }
 
 //
@@ -129,6 +134,90 @@ type IndexStatus struct {
 
 type indexStatusSorter []IndexStatus
 
+//
+// Index Definitions with Topology
+//
+
+type IndexDefinitionsResponse struct {
+	Version     uint64                  `json:"version,omitempty"`
+	Code        string                  `json:"code,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	Definitions []IndexDefnWithTopology `json:"definitions,omitempty"`
+}
+
+type IndexDefnWithTopology struct {
+	Definition common.IndexDefn    `json:"definition"`
+	Instances  []IndexInstTopology `json:"instances,omitempty"`
+}
+
+type IndexInstTopology struct {
+	InstId       common.IndexInstId `json:"instId"`
+	ReplicaId    int                `json:"replicaId"`
+	State        string             `json:"state"`
+	Error        string             `json:"error,omitempty"`
+	Scheduled    bool               `json:"scheduled"`
+	Hosts        []string           `json:"hosts,omitempty"`
+	PartitionMap map[string][]int   `json:"partitionMap,omitempty"`
+}
+
+type indexDefnWithTopologySorter []IndexDefnWithTopology
+type indexInstTopologySorter []IndexInstTopology
+
+//
+// Index Template
+//
+
+type IndexTemplateResponse struct {
+	Version uint64                  `json:"version,omitempty"`
+	Code    string                  `json:"code,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+	DryRun  bool                    `json:"dryRun,omitempty"`
+	Results []common.TemplateResult `json:"results,omitempty"`
+}
+
+//
+// Drop Indexes
+//
+
+type IndexDropResult struct {
+	Bucket string             `json:"bucket"`
+	Name   string             `json:"name"`
+	DefnId common.IndexDefnId `json:"defnId"`
+	Error  string             `json:"error,omitempty"`
+
+	defn  common.IndexDefn
+	addrs []string // index http address of the nodes hosting the index
+}
+
+type IndexDropResponse struct {
+	Code    string            `json:"code,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Results []IndexDropResult `json:"results,omitempty"`
+}
+
+type IndexListEntry struct {
+	DefnId      common.IndexDefnId `json:"defnId"`
+	Name        string             `json:"name"`
+	Bucket      string             `json:"bucket"`
+	IsPrimary   bool               `json:"isPrimary"`
+	SecExprs    []string           `json:"secExprs,omitempty"`
+	WhereExpr   string             `json:"where,omitempty"`
+	Using       string             `json:"using"`
+	State       string             `json:"state"`
+	Deferred    bool               `json:"deferred"`
+	Partitioned bool               `json:"partitioned"`
+	NumReplica  int                `json:"numReplica"`
+}
+
+type IndexListResponse struct {
+	Code    string           `json:"code,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+	Indexes []IndexListEntry `json:"indexes"`
+}
+
 //
 // Response
 //
@@ -138,6 +227,10 @@ const (
 	RESP_ERROR   string = "error"
 )
 
+// Indexes are listed from the local replica of the metadata, if it lags
+// the leader by no more than this.
+const listIndexesMaxStaleness = 10 * time.Second
+
 //
 // Internal data structure
 //
@@ -146,6 +239,7 @@ type requestHandlerContext struct {
 	initializer sync.Once
 	mgr         *IndexManager
 	clusterUrl  string
+	feed        *metadataFeed
 }
 
 var handlerContext requestHandlerContext
@@ -166,19 +260,34 @@ func registerRequestHandler(mgr *IndexManager, clusterUrl string) {
 		http.HandleFunc("/createIndex", handlerContext.createIndexRequest)
 		http.HandleFunc("/createIndexRebalance", handlerContext.createIndexRequestRebalance)
 		http.HandleFunc("/dropIndex", handlerContext.dropIndexRequest)
+		http.HandleFunc("/dropIndexes", handlerContext.dropIndexesRequest)
+		http.HandleFunc("/indexes", handlerContext.listIndexesRequest)
 		http.HandleFunc("/buildIndex", handlerContext.buildIndexRequest)
 		http.HandleFunc("/getLocalIndexMetadata", handlerContext.handleLocalIndexMetadataRequest)
 		http.HandleFunc("/getIndexMetadata", handlerContext.handleIndexMetadataRequest)
 		http.HandleFunc("/restoreIndexMetadata", handlerContext.handleRestoreIndexMetadataRequest)
+		http.HandleFunc("/applyIndexTemplate", handlerContext.handleApplyIndexTemplateRequest)
 		http.HandleFunc("/getIndexStatus", handlerContext.handleIndexStatusRequest)
+		http.HandleFunc("/getIndexDefinitions", handlerContext.handleIndexDefinitionsRequest)
 		http.HandleFunc("/getIndexStatement", handlerContext.handleIndexStatementRequest)
 		http.HandleFunc("/planIndex", handlerContext.handleIndexPlanRequest)
+		http.HandleFunc("/simulateIndex", handlerContext.handleIndexSimulationRequest)
 		http.HandleFunc("/settings/storageMode", handlerContext.handleIndexStorageModeRequest)
 		http.HandleFunc("/settings/planner", handlerContext.handlePlannerRequest)
+		http.HandleFunc("/watchIndexMetadata", handlerContext.handleWatchIndexMetadataRequest)
+		http.HandleFunc("/streamIndexStatus", handlerContext.handleStreamIndexStatusRequest)
+		http.HandleFunc("/eventSubscriptions", handlerContext.handleEventSubscriptionsRequest)
+		http.HandleFunc("/requestJournal", handlerContext.handleRequestJournalRequest)
 	})
 
 	handlerContext.mgr = mgr
 	handlerContext.clusterUrl = clusterUrl
+
+	feed, err := newMetadataFeed(mgr)
+	if err != nil {
+		logging.Warnf("error encountered when starting metadata feed : %v.  Ignored.\n", err)
+	}
+	handlerContext.feed = feed
 }
 
 ///////////////////////////////////////////////////////
@@ -240,7 +349,7 @@ func (m *requestHandlerContext) doCreateIndex(w http.ResponseWriter, r *http.Req
 
 	if err := m.mgr.HandleCreateIndexDDL(&indexDefn, isRebalReq); err == nil {
 		// No error, return success
-		sendIndexResponse(w)
+		m.sendIndexResponse(w)
 	} else {
 		// report failure
 		sendIndexResponseWithError(http.StatusInternalServerError, w, fmt.Sprintf("%v", err))
@@ -273,7 +382,7 @@ func (m *requestHandlerContext) dropIndexRequest(w http.ResponseWriter, r *http.
 	if indexDefn.RealInstId == 0 {
 		if err := m.mgr.HandleDeleteIndexDDL(indexDefn.DefnId); err == nil {
 			// No error, return success
-			sendIndexResponse(w)
+			m.sendIndexResponse(w)
 		} else {
 			// report failure
 			sendIndexResponseWithError(http.StatusInternalServerError, w, fmt.Sprintf("%v", err))
@@ -281,7 +390,7 @@ func (m *requestHandlerContext) dropIndexRequest(w http.ResponseWriter, r *http.
 	} else if indexDefn.InstId != 0 {
 		if err := m.mgr.DropOrPruneInstance(indexDefn, true); err == nil {
 			// No error, return success
-			sendIndexResponse(w)
+			m.sendIndexResponse(w)
 		} else {
 			// report failure
 			sendIndexResponseWithError(http.StatusInternalServerError, w, fmt.Sprintf("%v", err))
@@ -292,6 +401,179 @@ func (m *requestHandlerContext) dropIndexRequest(w http.ResponseWriter, r *http.
 	}
 }
 
+//
+// Drop the indexes of a bucket whose name matches a pattern, given by
+// the bucket and name parameters.  The indexes are dropped on all nodes
+// of the cluster.  Only DELETE and POST requests are accepted.
+//
+func (m *requestHandlerContext) dropIndexesRequest(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "DELETE" && r.Method != "POST" {
+		send(http.StatusMethodNotAllowed, w, &IndexDropResponse{Code: RESP_ERROR, Error: "Unsupported method " + r.Method})
+		return
+	}
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	bucket, namePattern, err := dropIndexesParams(r)
+	if err != nil {
+		send(http.StatusBadRequest, w, &IndexDropResponse{Code: RESP_ERROR, Error: err.Error()})
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!drop", bucket)
+	if !isAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	results, err := m.dropIndexes(creds, bucket, namePattern)
+	if err != nil {
+		send(http.StatusInternalServerError, w, &IndexDropResponse{Code: RESP_ERROR, Error: err.Error(), Results: results})
+		return
+	}
+	send(http.StatusOK, w, &IndexDropResponse{Code: RESP_SUCCESS, Results: results})
+}
+
+//
+// Get the bucket and the index name pattern of a drop indexes request.
+// Both are mandatory, "*" has to be given explicitly to drop all indexes
+// of the bucket.
+//
+func dropIndexesParams(r *http.Request) (string, string, error) {
+
+	bucket := r.FormValue("bucket")
+	if len(bucket) == 0 {
+		return "", "", errors.New("Missing bucket parameter")
+	}
+
+	namePattern := r.FormValue("name")
+	if len(namePattern) == 0 {
+		return "", "", errors.New("Missing name parameter")
+	}
+	if _, err := path.Match(namePattern, ""); err != nil {
+		return "", "", errors.New(fmt.Sprintf("Invalid index name pattern '%s'", namePattern))
+	}
+
+	return bucket, namePattern, nil
+}
+
+//
+// Drop the indexes of a bucket whose name matches namePattern, in the
+// syntax of path.Match, on all nodes of the cluster.  The matching
+// indexes are resolved from the cluster metadata before any index is
+// dropped, so an index created while the drop is in progress is not
+// dropped.  A delete token is posted for every matching index before
+// any drop request is sent, the same as a drop through the metadata
+// provider.  If a node fails to drop an index, or fails during the
+// drop, the index is dropped by the janitor of the node once the node
+// is back.  The result reports the outcome for each index.
+//
+func (m *requestHandlerContext) dropIndexes(creds cbauth.Creds, bucket string, namePattern string) ([]IndexDropResult, error) {
+
+	clusterMeta, _, addrs, err := m.getIndexMetadataWithAddrs(creds, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	results := matchIndexesToDrop(clusterMeta, addrs, bucket, namePattern)
+
+	logging.Infof("RequestHandler::dropIndexes: dropping %v indexes of bucket %v matching '%v'",
+		len(results), bucket, namePattern)
+
+	for i := range results {
+		if err := mc.PostDeleteCommandToken(results[i].DefnId); err != nil {
+			return nil, errors.New(fmt.Sprintf("Fail to drop index %s due to internal errors.  Error=%v.", results[i].Name, err))
+		}
+	}
+
+	failed := 0
+	for i := range results {
+		for _, addr := range results[i].addrs {
+			if err := m.makeDropIndexRequest(results[i].defn, addr); err != nil {
+				logging.Errorf("RequestHandler::dropIndexes: fail to drop index %v:%v on %v. Error = %v",
+					bucket, results[i].Name, addr, err)
+				results[i].Error = err.Error()
+			}
+		}
+		if len(results[i].Error) != 0 {
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		msg := fmt.Sprintf("Fail to drop %v of %v indexes of bucket '%s' on some indexer nodes.  ", failed, len(results), bucket)
+		msg += "The drop will automatically retry after the indexer nodes are back to normal."
+		return results, errors.New(msg)
+	}
+	return results, nil
+}
+
+//
+// Find the indexes of a bucket whose name matches namePattern in the
+// cluster metadata, along with the nodes hosting each index.  addrs
+// holds the address of each node of clusterMeta.
+//
+func matchIndexesToDrop(clusterMeta *ClusterIndexMetadata, addrs []string, bucket string, namePattern string) []IndexDropResult {
+
+	matches := make(map[common.IndexDefnId]*IndexDropResult)
+	for i, localMeta := range clusterMeta.Metadata {
+		for _, defn := range localMeta.IndexDefinitions {
+			if defn.Bucket != bucket {
+				continue
+			}
+			if matched, _ := path.Match(namePattern, defn.Name); !matched {
+				continue
+			}
+
+			result, ok := matches[defn.DefnId]
+			if !ok {
+				result = &IndexDropResult{Bucket: defn.Bucket, Name: defn.Name, DefnId: defn.DefnId, defn: defn}
+				matches[defn.DefnId] = result
+			}
+			if i < len(addrs) && len(addrs[i]) != 0 {
+				result.addrs = append(result.addrs, addrs[i])
+			}
+		}
+	}
+
+	results := make([]IndexDropResult, 0, len(matches))
+	for _, result := range matches {
+		results = append(results, *result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].DefnId < results[j].DefnId
+	})
+	return results
+}
+
+func (m *requestHandlerContext) makeDropIndexRequest(defn common.IndexDefn, addr string) error {
+
+	req := IndexRequest{Version: uint64(1), Type: DROP, Index: defn}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := postWithAuth(addr+"/dropIndex", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	response := new(IndexResponse)
+	status := convertResponse(resp, response)
+	if status == RESP_ERROR || response.Code == RESP_ERROR {
+		return errors.New(response.Error)
+	}
+	return nil
+}
+
 func (m *requestHandlerContext) buildIndexRequest(w http.ResponseWriter, r *http.Request) {
 
 	creds, ok := doAuth(r, w)
@@ -312,10 +594,15 @@ func (m *requestHandlerContext) buildIndexRequest(w http.ResponseWriter, r *http
 	}
 
 	// call the index manager to handle the DDL
-	indexIds := request.IndexIds
-	if err := m.mgr.HandleBuildIndexDDL(indexIds); err == nil {
+	var err error
+	if len(request.Names) != 0 {
+		err = m.mgr.HandleBuildIndexesByName(request.Index.Bucket, request.Names)
+	} else {
+		err = m.mgr.HandleBuildIndexDDL(request.IndexIds)
+	}
+	if err == nil {
 		// No error, return success
-		sendIndexResponse(w)
+		m.sendIndexResponse(w)
 	} else {
 		// report failure
 		sendIndexResponseWithError(http.StatusInternalServerError, w, fmt.Sprintf("%v", err))
@@ -646,6 +933,250 @@ func (m *requestHandlerContext) consolideStateStr(str1 string, str2 string) stri
 	return str1
 }
 
+//////////////////////////////////////////////////////
+// Index List
+///////////////////////////////////////////////////////
+
+//
+// List the indexes of this node, filtered by the bucket and state
+// parameters, ordered by the sort parameter and paginated by the offset
+// and limit parameters. sort is a comma separated list of name, bucket,
+// state and defnId, each optionally prefixed by "-" for descending order.
+//
+func (m *requestHandlerContext) listIndexesRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	if r.Method != "GET" {
+		send(http.StatusMethodNotAllowed, w, &IndexListResponse{Code: RESP_ERROR, Error: "Unsupported method"})
+		return
+	}
+
+	query := r.URL.Query()
+
+	offset, limit := 0, 0
+	for _, param := range []struct {
+		name string
+		val  *int
+	}{{"offset", &offset}, {"limit", &limit}} {
+		if str := query.Get(param.name); len(str) != 0 {
+			val, err := strconv.Atoi(str)
+			if err != nil || val < 0 {
+				send(http.StatusBadRequest, w, &IndexListResponse{Code: RESP_ERROR,
+					Error: fmt.Sprintf("Invalid %v parameter %v", param.name, str)})
+				return
+			}
+			*param.val = val
+		}
+	}
+
+	less, err := indexListSorter(query.Get("sort"))
+	if err != nil {
+		send(http.StatusBadRequest, w, &IndexListResponse{Code: RESP_ERROR, Error: err.Error()})
+		return
+	}
+
+	list, err := m.listIndexes(creds, query.Get("bucket"), query.Get("state"))
+	if err != nil {
+		send(http.StatusInternalServerError, w, &IndexListResponse{Code: RESP_ERROR, Error: err.Error()})
+		return
+	}
+	sort.SliceStable(list, func(i, j int) bool { return less(&list[i], &list[j]) })
+
+	page, offset := pageIndexList(list, offset, limit)
+
+	send(http.StatusOK, w, &IndexListResponse{
+		Code:    RESP_SUCCESS,
+		Total:   len(list),
+		Offset:  offset,
+		Limit:   limit,
+		Indexes: page,
+	})
+}
+
+//
+// pageIndexList returns the page of list starting at offset, with at
+// most limit entries, or all remaining entries if limit is 0.  The
+// offset is capped at the length of list, and returned.
+//
+func pageIndexList(list []IndexListEntry, offset, limit int) ([]IndexListEntry, int) {
+
+	total := len(list)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit != 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return list[offset:end], offset
+}
+
+//
+// getTopology returns the topology of the bucket from the local replica
+// of the metadata, so that listing indexes keeps working during leader
+// election.  If the replica lags the leader by more than
+// listIndexesMaxStaleness, the topology is read through the leader.
+//
+func (m *requestHandlerContext) getTopology(bucket string) (*IndexTopology, error) {
+
+	topology, err := m.mgr.repo.GetTopologyByBucketWithStaleness(bucket, listIndexesMaxStaleness)
+	if err != nil {
+		if e, ok := err.(Error); ok && e.code == ERROR_META_STALE {
+			logging.Debugf("RequestHandler::getTopology: %v", err)
+			return m.mgr.repo.GetTopologyByBucket(bucket)
+		}
+		return nil, err
+	}
+	return topology, nil
+}
+
+func (m *requestHandlerContext) listIndexes(creds cbauth.Creds, bucket string, state string) ([]IndexListEntry, error) {
+
+	filter := func(defn *common.IndexDefn) bool {
+		return len(bucket) == 0 || bucket == defn.Bucket
+	}
+
+	iter, err := m.mgr.NewFilteredIndexDefnIterator(filter)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	allowed := make(map[string]bool)
+	topologies := make(map[string]*IndexTopology)
+	list := make([]IndexListEntry, 0)
+
+	for _, defn, err := iter.Next(); err == nil; _, defn, err = iter.Next() {
+
+		if _, ok := allowed[defn.Bucket]; !ok {
+			permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", defn.Bucket)
+			allowed[defn.Bucket] = isAllowed(creds, []string{permission}, nil)
+		}
+		if !allowed[defn.Bucket] {
+			continue
+		}
+
+		topology, ok := topologies[defn.Bucket]
+		if !ok {
+			if topology, err = m.getTopology(defn.Bucket); err != nil {
+				return nil, err
+			}
+			topologies[defn.Bucket] = topology
+		}
+		if topology == nil {
+			continue
+		}
+
+		instances := topology.GetIndexInstancesByDefn(defn.DefnId)
+		if len(instances) == 0 {
+			continue
+		}
+
+		instState, _ := topology.GetStatusByInst(defn.DefnId, common.IndexInstId(instances[0].InstId))
+		if instState == common.INDEX_STATE_DELETED || instState == common.INDEX_STATE_NIL {
+			continue
+		}
+
+		stateStr := indexListState(instState)
+		if len(state) != 0 && !strings.EqualFold(state, stateStr) {
+			continue
+		}
+
+		list = append(list, IndexListEntry{
+			DefnId:      defn.DefnId,
+			Name:        defn.Name,
+			Bucket:      defn.Bucket,
+			IsPrimary:   defn.IsPrimary,
+			SecExprs:    defn.SecExprs,
+			WhereExpr:   defn.WhereExpr,
+			Using:       string(defn.Using),
+			State:       stateStr,
+			Deferred:    defn.Deferred,
+			Partitioned: common.IsPartitioned(defn.PartitionScheme),
+			NumReplica:  int(defn.NumReplica),
+		})
+	}
+
+	return list, nil
+}
+
+//
+// State of an index in the index list, named as in the index status.
+//
+func indexListState(state common.IndexState) string {
+
+	switch state {
+	case common.INDEX_STATE_CREATED:
+		return "Pending"
+	case common.INDEX_STATE_READY:
+		return "Created"
+	case common.INDEX_STATE_INITIAL, common.INDEX_STATE_CATCHUP:
+		return "Building"
+	case common.INDEX_STATE_ACTIVE:
+		return "Ready"
+	case common.INDEX_STATE_ERROR:
+		return "Error"
+	}
+	return "Not Available"
+}
+
+//
+// Parse the sort parameter of the index list into a less function.
+// Entries are ordered by name, bucket and defnId after the sort fields.
+//
+func indexListSorter(param string) (func(a, b *IndexListEntry) bool, error) {
+
+	type field struct {
+		cmp  func(a, b *IndexListEntry) int
+		desc bool
+	}
+
+	compareStr := func(a, b string) int { return strings.Compare(a, b) }
+	compareId := func(a, b common.IndexDefnId) int {
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	}
+
+	comparators := map[string]func(a, b *IndexListEntry) int{
+		"name":   func(a, b *IndexListEntry) int { return compareStr(a.Name, b.Name) },
+		"bucket": func(a, b *IndexListEntry) int { return compareStr(a.Bucket, b.Bucket) },
+		"state":  func(a, b *IndexListEntry) int { return compareStr(a.State, b.State) },
+		"defnId": func(a, b *IndexListEntry) int { return compareId(a.DefnId, b.DefnId) },
+	}
+
+	fields := make([]field, 0)
+	if len(param) != 0 {
+		for _, name := range strings.Split(param, ",") {
+			desc := strings.HasPrefix(name, "-")
+			cmp, ok := comparators[strings.TrimPrefix(name, "-")]
+			if !ok {
+				return nil, fmt.Errorf("Invalid sort field %v", name)
+			}
+			fields = append(fields, field{cmp: cmp, desc: desc})
+		}
+	}
+	for _, name := range []string{"name", "bucket", "defnId"} {
+		fields = append(fields, field{cmp: comparators[name]})
+	}
+
+	return func(a, b *IndexListEntry) bool {
+		for _, f := range fields {
+			if c := f.cmp(a, b); c != 0 {
+				return (c < 0) != f.desc
+			}
+		}
+		return false
+	}, nil
+}
+
 //////////////////////////////////////////////////////
 // Index Statement
 ///////////////////////////////////////////////////////
@@ -703,7 +1234,9 @@ func (m *requestHandlerContext) handleIndexMetadataRequest(w http.ResponseWriter
 
 	bucket := m.getBucket(r)
 
+	task := common.NewTask(common.TaskBackup, fmt.Sprintf("Backup index metadata of bucket %q", bucket), nil)
 	meta, err := m.getIndexMetadata(creds, bucket)
+	task.Done(err)
 	if err == nil {
 		resp := &BackupResponse{Code: RESP_SUCCESS, Result: *meta}
 		send(http.StatusOK, w, resp)
@@ -716,20 +1249,50 @@ func (m *requestHandlerContext) handleIndexMetadataRequest(w http.ResponseWriter
 
 func (m *requestHandlerContext) getIndexMetadata(creds cbauth.Creds, bucket string) (*ClusterIndexMetadata, error) {
 
+	clusterMeta, _, err := m.getIndexMetadataWithHosts(creds, bucket)
+	return clusterMeta, err
+}
+
+//
+// Get the local index metadata of all index nodes, and the host (mgmt address)
+// of each node.  The host is left empty for a node whose mgmt address cannot
+// be found, since the metadata of the node is still valid.
+//
+func (m *requestHandlerContext) getIndexMetadataWithHosts(creds cbauth.Creds, bucket string) (*ClusterIndexMetadata, []string, error) {
+
+	clusterMeta, hosts, _, err := m.getIndexMetadataWithAddrs(creds, bucket)
+	return clusterMeta, hosts, err
+}
+
+//
+// Same as getIndexMetadataWithHosts, and also return the index http
+// address of each node.
+//
+func (m *requestHandlerContext) getIndexMetadataWithAddrs(creds cbauth.Creds, bucket string) (*ClusterIndexMetadata, []string, []string, error) {
+
 	cinfo, err := m.mgr.FetchNewClusterInfoCache()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	// find all nodes that has a index http service
 	nids := cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE)
 
 	clusterMeta := &ClusterIndexMetadata{Metadata: make([]LocalIndexMetadata, len(nids))}
+	hosts := make([]string, len(nids))
+	addrs := make([]string, len(nids))
 
 	for i, nid := range nids {
 
 		addr, err := cinfo.GetServiceAddress(nid, common.INDEX_HTTP_SERVICE)
 		if err == nil {
+			addrs[i] = addr
+
+			if curl, err := cinfo.GetServiceAddress(nid, "mgmt"); err == nil {
+				hosts[i] = curl
+			} else {
+				logging.Warnf("RequestHandler::getIndexMetadata: Fail to retrieve mgmt endpoint for index node %v: %v", addr, err)
+			}
 
 			url := "/getLocalIndexMetadata"
 			if len(bucket) != 0 {
@@ -739,14 +1302,14 @@ func (m *requestHandlerContext) getIndexMetadata(creds cbauth.Creds, bucket stri
 			resp, err := getWithAuth(addr + url)
 			if err != nil {
 				logging.Debugf("RequestHandler::getIndexMetadata: Error while retrieving %v with auth %v", addr+"/getLocalIndexMetadata", err)
-				return nil, errors.New(fmt.Sprintf("Fail to retrieve index definition from url %s", addr))
+				return nil, nil, nil, errors.New(fmt.Sprintf("Fail to retrieve index definition from url %s", addr))
 			}
 			defer resp.Body.Close()
 
 			localMeta := new(LocalIndexMetadata)
 			status := convertResponse(resp, localMeta)
 			if status == RESP_ERROR {
-				return nil, errors.New(fmt.Sprintf("Fail to retrieve local metadata from url %s.", addr))
+				return nil, nil, nil, errors.New(fmt.Sprintf("Fail to retrieve local metadata from url %s.", addr))
 			}
 
 			newLocalMeta := LocalIndexMetadata{
@@ -772,11 +1335,136 @@ func (m *requestHandlerContext) getIndexMetadata(creds cbauth.Creds, bucket stri
 			clusterMeta.Metadata[i] = newLocalMeta
 
 		} else {
-			return nil, errors.New(fmt.Sprintf("Fail to retrieve http endpoint for index node"))
+			return nil, nil, nil, errors.New(fmt.Sprintf("Fail to retrieve http endpoint for index node"))
+		}
+	}
+
+	return clusterMeta, hosts, addrs, nil
+}
+
+///////////////////////////////////////////////////////
+// Index Definitions with Topology
+///////////////////////////////////////////////////////
+
+func (m *requestHandlerContext) handleIndexDefinitionsRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	bucket := m.getBucket(r)
+
+	defns, err := m.getIndexDefinitions(creds, bucket)
+	if err == nil {
+		resp := &IndexDefinitionsResponse{Code: RESP_SUCCESS, Definitions: defns}
+		send(http.StatusOK, w, resp)
+	} else {
+		logging.Debugf("RequestHandler::handleIndexDefinitionsRequest: err %v", err)
+		resp := &IndexDefinitionsResponse{Code: RESP_ERROR, Error: err.Error()}
+		send(http.StatusInternalServerError, w, resp)
+	}
+}
+
+//
+// Get the index definitions joined with the state and hosts of their
+// instances, from the local metadata of all index nodes.
+//
+func (m *requestHandlerContext) getIndexDefinitions(creds cbauth.Creds, bucket string) ([]IndexDefnWithTopology, error) {
+
+	clusterMeta, hosts, err := m.getIndexMetadataWithHosts(creds, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return joinIndexDefinitions(clusterMeta, hosts, bucket), nil
+}
+
+//
+// Join the index definitions of the local metadata of each node with the
+// state and hosts of their instances.  A node without a known host still
+// contributes the state of its instances.
+//
+func joinIndexDefinitions(clusterMeta *ClusterIndexMetadata, hosts []string, bucket string) []IndexDefnWithTopology {
+
+	defnMap := make(map[common.IndexDefnId]*IndexDefnWithTopology)
+	instMap := make(map[common.IndexInstId]*IndexInstTopology)
+	instDefnMap := make(map[common.IndexInstId]common.IndexDefnId)
+	stateMap := make(map[common.IndexInstId]common.IndexState)
+
+	for i, localMeta := range clusterMeta.Metadata {
+		for _, defn := range localMeta.IndexDefinitions {
+
+			if len(bucket) != 0 && bucket != defn.Bucket {
+				continue
+			}
+
+			if _, ok := defnMap[defn.DefnId]; !ok {
+				defnMap[defn.DefnId] = &IndexDefnWithTopology{Definition: defn}
+			}
+
+			topology := findTopologyByBucket(localMeta.IndexTopologies, defn.Bucket)
+			if topology == nil {
+				continue
+			}
+
+			for _, instance := range topology.GetIndexInstancesByDefn(defn.DefnId) {
+
+				instId := common.IndexInstId(instance.InstId)
+				state, errStr := topology.GetStatusByInst(defn.DefnId, instId)
+				if state == common.INDEX_STATE_DELETED || state == common.INDEX_STATE_NIL {
+					continue
+				}
+
+				inst, ok := instMap[instId]
+				if !ok {
+					inst = &IndexInstTopology{
+						InstId:       instId,
+						ReplicaId:    int(instance.ReplicaId),
+						Scheduled:    instance.Scheduled,
+						PartitionMap: make(map[string][]int),
+					}
+					instMap[instId] = inst
+					instDefnMap[instId] = defn.DefnId
+					stateMap[instId] = state
+				}
+
+				// a partitioned instance is in the state of its least advanced
+				// partitions, unless any of its partitions is in error.
+				if state == common.INDEX_STATE_ERROR || (state < stateMap[instId] && stateMap[instId] != common.INDEX_STATE_ERROR) {
+					stateMap[instId] = state
+				}
+
+				if len(errStr) != 0 {
+					inst.Error = strings.TrimSpace(fmt.Sprintf("%v %v", inst.Error, errStr))
+				}
+
+				if len(hosts[i]) == 0 {
+					continue
+				}
+
+				inst.Hosts = append(inst.Hosts, hosts[i])
+				for _, partnDef := range instance.Partitions {
+					inst.PartitionMap[hosts[i]] = append(inst.PartitionMap[hosts[i]], int(partnDef.PartId))
+				}
+			}
 		}
 	}
 
-	return clusterMeta, nil
+	for instId, inst := range instMap {
+		inst.State = stateMap[instId].String()
+		defnRef := defnMap[instDefnMap[instId]]
+		defnRef.Instances = append(defnRef.Instances, *inst)
+	}
+
+	result := make([]IndexDefnWithTopology, 0, len(defnMap))
+	for _, defnRef := range defnMap {
+		sort.Sort(indexInstTopologySorter(defnRef.Instances))
+		result = append(result, *defnRef)
+	}
+	sort.Sort(indexDefnWithTopologySorter(result))
+
+	return result
 }
 
 func (m *requestHandlerContext) convertIndexMetadataRequest(r *http.Request) *ClusterIndexMetadata {
@@ -821,6 +1509,20 @@ func (m *requestHandlerContext) handleLocalIndexMetadataRequest(w http.ResponseW
 
 	bucket := m.getBucket(r)
 
+	// read your own DDL, by waiting for the metadata version of the DDL,
+	// as the JSON version vector of the DDL response.
+	if str := r.FormValue("metaVersion"); len(str) != 0 {
+		var version MetaVersion
+		if err := json.Unmarshal([]byte(str), &version); err != nil {
+			sendHttpError(w, "Invalid metaVersion "+str, http.StatusBadRequest)
+			return
+		}
+		if err := m.mgr.WaitForMetadataVersion(version, DEFAULT_META_VERSION_WAIT); err != nil {
+			sendHttpError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
 	meta, err := m.getLocalIndexMetadata(creds, bucket)
 	if err == nil {
 		send(http.StatusOK, w, meta)
@@ -985,6 +1687,196 @@ func (m *requestHandlerContext) makeCreateIndexRequest(defn common.IndexDefn, ho
 	return true
 }
 
+///////////////////////////////////////////////////////
+// Index Template
+///////////////////////////////////////////////////////
+
+//
+// Apply a template of index definitions.  Indexes missing from the
+// cluster are created.  Indexes whose definition has drifted from the
+// template are only reported, unless force=true is given, in which case
+// they are dropped and created again.  Template variables are passed as
+// var=name=value parameters, and dryRun=true only reports the actions to
+// be taken.
+//
+func (m *requestHandlerContext) handleApplyIndexTemplateRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	vars := make(map[string]string)
+	for _, v := range r.URL.Query()["var"] {
+		nv := strings.SplitN(v, "=", 2)
+		if len(nv) != 2 {
+			send(http.StatusBadRequest, w, &IndexTemplateResponse{Code: RESP_ERROR, Error: fmt.Sprintf("Invalid variable %v", v)})
+			return
+		}
+		vars[nv[0]] = nv[1]
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	force := r.URL.Query().Get("force") == "true"
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		send(http.StatusBadRequest, w, &IndexTemplateResponse{Code: RESP_ERROR, Error: "Unable to read request body"})
+		return
+	}
+
+	template, err := common.ParseIndexTemplate(buf.Bytes(), vars)
+	if err != nil {
+		send(http.StatusBadRequest, w, &IndexTemplateResponse{Code: RESP_ERROR, Error: fmt.Sprintf("Invalid template.  Error=%v", err)})
+		return
+	}
+
+	// drifted indexes are dropped when forced, hence both create and drop are required
+	for _, defn := range template.Indexes {
+		ops := []string{"create"}
+		if force {
+			ops = append(ops, "drop")
+		}
+		for _, op := range ops {
+			permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!%s", defn.Bucket, op)
+			if !isAllowed(creds, []string{permission}, w) {
+				return
+			}
+		}
+	}
+
+	existing, err := m.getIndexDefinitions(creds, "")
+	if err != nil {
+		send(http.StatusInternalServerError, w, &IndexTemplateResponse{Code: RESP_ERROR, Error: fmt.Sprintf("Unable to get index definitions.  Error=%v", err)})
+		return
+	}
+
+	existingMap := make(map[string]*common.IndexDefn)
+	for i := range existing {
+		defn := &existing[i].Definition
+		existingMap[defn.Bucket+":"+defn.Name] = defn
+	}
+
+	results, failed := applyIndexTemplate(m.mgr, template.Indexes, existingMap, dryRun, force)
+
+	resp := &IndexTemplateResponse{Code: RESP_SUCCESS, DryRun: dryRun, Results: results}
+	if failed {
+		resp.Code, resp.Error = RESP_ERROR, "Unable to apply some index definitions"
+		send(http.StatusInternalServerError, w, resp)
+		return
+	}
+	send(http.StatusOK, w, resp)
+}
+
+//
+// DDL of the index manager used to apply a template.
+//
+type indexTemplateDDL interface {
+	HandleCreateIndexDDL(defn *common.IndexDefn, isRebalReq bool) error
+	HandleDeleteIndexDDL(defnId common.IndexDefnId) error
+}
+
+//
+// Apply the index definitions of a template against the existing
+// definitions, keyed by bucket:name.  It returns the result of every
+// definition, and true if any of them failed.
+//
+func applyIndexTemplate(ddl indexTemplateDDL, indexes []common.IndexDefn,
+	existing map[string]*common.IndexDefn, dryRun bool, force bool) ([]common.TemplateResult, bool) {
+
+	failed := false
+	results := make([]common.TemplateResult, 0, len(indexes))
+	for i := range indexes {
+		defn := &indexes[i]
+		result := common.TemplateResult{Bucket: defn.Bucket, Name: defn.Name, Action: common.TemplateCreate}
+
+		current, ok := existing[defn.Bucket+":"+defn.Name]
+		if ok && !common.IndexDrifted(defn, current) {
+			result.Action = common.TemplateUnchanged
+		} else if ok && !force {
+			result.Action = common.TemplateDrifted
+		} else if ok {
+			result.Action = common.TemplateRecreate
+		}
+
+		if !dryRun && (result.Action == common.TemplateCreate || result.Action == common.TemplateRecreate) {
+			if err := applyIndexTemplateDefn(ddl, defn, current); err != nil {
+				logging.Errorf("RequestHandler::applyIndexTemplate: %v index %v:%v. Error=%v",
+					result.Action, defn.Bucket, defn.Name, err)
+				result.Error = err.Error()
+				failed = true
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, failed
+}
+
+//
+// Create the index of a template definition, dropping the current
+// definition first if any.  The template definition is validated before
+// the current index is dropped, and the current index is created again
+// if the template definition fails to be created.
+//
+func applyIndexTemplateDefn(ddl indexTemplateDDL, defn, current *common.IndexDefn) error {
+
+	if len(defn.Using) != 0 && strings.ToLower(string(defn.Using)) != "gsi" {
+		if common.IndexTypeToStorageMode(defn.Using) != common.GetStorageMode() {
+			return fmt.Errorf("Storage Mode Mismatch %v", defn.Using)
+		}
+	}
+
+	if err := validateIndexTemplateDefn(defn); err != nil {
+		return err
+	}
+
+	defnId, err := common.NewIndexDefnId()
+	if err != nil {
+		return fmt.Errorf("Fail to generate index definition id %v", err)
+	}
+	defn.DefnId = defnId
+
+	if current == nil {
+		return ddl.HandleCreateIndexDDL(defn, false)
+	}
+
+	if err := ddl.HandleDeleteIndexDDL(current.DefnId); err != nil {
+		return err
+	}
+
+	createErr := ddl.HandleCreateIndexDDL(defn, false)
+	if createErr == nil {
+		return nil
+	}
+
+	restore := *current
+	if restore.DefnId, err = common.NewIndexDefnId(); err == nil {
+		err = ddl.HandleCreateIndexDDL(&restore, false)
+	}
+	if err != nil {
+		return fmt.Errorf("%v.  Fail to restore the dropped index: %v", createErr, err)
+	}
+	return fmt.Errorf("%v.  The dropped index is restored", createErr)
+}
+
+//
+// Validate the expressions of a template definition.
+//
+func validateIndexTemplateDefn(defn *common.IndexDefn) error {
+
+	if err := validateWhereExpr(defn); err != nil {
+		return err
+	}
+
+	for _, expr := range defn.SecExprs {
+		if _, err := qparser.Parse(expr); err != nil {
+			return fmt.Errorf("Invalid expression '%s' for index '%s': %v", expr, defn.Name, err)
+		}
+	}
+
+	return nil
+}
+
 //////////////////////////////////////////////////////
 // Planner
 ///////////////////////////////////////////////////////
@@ -1025,6 +1917,42 @@ func (m *requestHandlerContext) getIndexPlan(r *http.Request) (string, error) {
 	return planner.CreateIndexDDL(solution), nil
 }
 
+func (m *requestHandlerContext) handleIndexSimulationRequest(w http.ResponseWriter, r *http.Request) {
+
+	_, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	result, err := m.getIndexSimulation(r)
+
+	if err == nil {
+		send(http.StatusOK, w, result)
+	} else {
+		sendHttpError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (m *requestHandlerContext) getIndexSimulation(r *http.Request) (*planner.SimulationResult, error) {
+
+	plan, err := planner.RetrievePlanFromCluster(m.clusterUrl, nil)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Fail to retreive index information from cluster.   Error=%v", err))
+	}
+
+	specs, err := m.convertIndexPlanRequest(r)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Fail to read index spec from request.   Error=%v", err))
+	}
+
+	result, err := planner.ExecuteSimulation(plan, specs)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Fail to simulate index.   Error=%v", err))
+	}
+
+	return result, nil
+}
+
 func (m *requestHandlerContext) convertIndexPlanRequest(r *http.Request) ([]*planner.IndexSpec, error) {
 
 	var specs []*planner.IndexSpec
@@ -1049,6 +1977,37 @@ func (m *requestHandlerContext) convertIndexPlanRequest(r *http.Request) ([]*pla
 // Storage Mode
 ///////////////////////////////////////////////////////
 
+//
+// Get the active event listeners of the index manager.
+//
+func (m *requestHandlerContext) handleEventSubscriptionsRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	if !isAllowed(creds, []string{"cluster.settings!read"}, w) {
+		return
+	}
+
+	send(http.StatusOK, w, m.mgr.GetEventSubscriptions())
+}
+
+func (m *requestHandlerContext) handleRequestJournalRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	if !isAllowed(creds, []string{"cluster.settings!read"}, w) {
+		return
+	}
+
+	send(http.StatusOK, w, m.mgr.GetRequestJournal())
+}
+
 func (m *requestHandlerContext) handleIndexStorageModeRequest(w http.ResponseWriter, r *http.Request) {
 
 	creds, ok := doAuth(r, w)
@@ -1140,8 +2099,11 @@ func sendIndexResponseWithError(status int, w http.ResponseWriter, msg string) {
 	send(status, w, res)
 }
 
-func sendIndexResponse(w http.ResponseWriter) {
-	result := &IndexResponse{Code: RESP_SUCCESS}
+//
+// Send success of a DDL, with the metadata version after the DDL.
+//
+func (m *requestHandlerContext) sendIndexResponse(w http.ResponseWriter) {
+	result := &IndexResponse{Code: RESP_SUCCESS, MetaVersion: m.mgr.GetMetadataVersion()}
 	send(http.StatusOK, w, result)
 }
 
@@ -1293,3 +2255,31 @@ func (s indexStatusSorter) Less(i, j int) bool {
 
 	return s[i].Bucket < s[j].Bucket
 }
+
+func (s indexDefnWithTopologySorter) Len() int {
+	return len(s)
+}
+
+func (s indexDefnWithTopologySorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s indexDefnWithTopologySorter) Less(i, j int) bool {
+	if s[i].Definition.Bucket != s[j].Definition.Bucket {
+		return s[i].Definition.Bucket < s[j].Definition.Bucket
+	}
+
+	return s[i].Definition.Name < s[j].Definition.Name
+}
+
+func (s indexInstTopologySorter) Len() int {
+	return len(s)
+}
+
+func (s indexInstTopologySorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s indexInstTopologySorter) Less(i, j int) bool {
+	return s[i].ReplicaId < s[j].ReplicaId
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func testLocalMetadata(defn common.IndexDefn, state common.IndexState, errStr string, partns ...uint64) LocalIndexMetadata {
+
+	inst := IndexInstDistribution{InstId: 100, State: uint32(state), Error: errStr}
+	for _, partnId := range partns {
+		inst.Partitions = append(inst.Partitions, IndexPartDistribution{PartId: partnId})
+	}
+
+	return LocalIndexMetadata{
+		IndexDefinitions: []common.IndexDefn{defn},
+		IndexTopologies: []IndexTopology{{
+			Bucket: defn.Bucket,
+			Definitions: []IndexDefnDistribution{{
+				Bucket:    defn.Bucket,
+				Name:      defn.Name,
+				DefnId:    uint64(defn.DefnId),
+				Instances: []IndexInstDistribution{inst},
+			}},
+		}},
+	}
+}
+
+func TestJoinIndexDefinitions(t *testing.T) {
+	defn := common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx"}
+	other := common.IndexDefn{DefnId: 20, Bucket: "other", Name: "idx"}
+
+	clusterMeta := &ClusterIndexMetadata{Metadata: []LocalIndexMetadata{
+		testLocalMetadata(defn, common.INDEX_STATE_ACTIVE, "", 1),
+		testLocalMetadata(defn, common.INDEX_STATE_INITIAL, "", 2),
+		testLocalMetadata(other, common.INDEX_STATE_ACTIVE, ""),
+	}}
+	hosts := []string{"n1:8091", "n2:8091", "n3:8091"}
+
+	result := joinIndexDefinitions(clusterMeta, hosts, "default")
+	if len(result) != 1 || len(result[0].Instances) != 1 {
+		t.Fatalf("unexpected result %v", result)
+	}
+	inst := result[0].Instances[0]
+	if inst.State != common.INDEX_STATE_INITIAL.String() {
+		t.Errorf("expected the least advanced state, got %v", inst.State)
+	}
+	expected := map[string][]int{"n1:8091": {1}, "n2:8091": {2}}
+	if !reflect.DeepEqual(inst.PartitionMap, expected) || len(inst.Hosts) != 2 {
+		t.Errorf("unexpected hosts %v partitions %v", inst.Hosts, inst.PartitionMap)
+	}
+}
+
+func TestJoinIndexDefinitionsError(t *testing.T) {
+	defn := common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx"}
+
+	clusterMeta := &ClusterIndexMetadata{Metadata: []LocalIndexMetadata{
+		testLocalMetadata(defn, common.INDEX_STATE_ERROR, "disk full", 1),
+		testLocalMetadata(defn, common.INDEX_STATE_INITIAL, "", 2),
+	}}
+
+	// the host of the first node is unknown.
+	result := joinIndexDefinitions(clusterMeta, []string{"", "n2:8091"}, "")
+	if len(result) != 1 || len(result[0].Instances) != 1 {
+		t.Fatalf("unexpected result %v", result)
+	}
+	inst := result[0].Instances[0]
+	if inst.State != common.INDEX_STATE_ERROR.String() || inst.Error != "disk full" {
+		t.Errorf("expected error state to be reported, got %v %v", inst.State, inst.Error)
+	}
+	if !reflect.DeepEqual(inst.Hosts, []string{"n2:8091"}) {
+		t.Errorf("expected only the known host, got %v", inst.Hosts)
+	}
+}
+
+func TestDropIndexesMethod(t *testing.T) {
+	m := &requestHandlerContext{}
+	for _, method := range []string{"GET", "PUT"} {
+		r := httptest.NewRequest(method, "/dropIndexes?bucket=default&name=*", nil)
+		w := httptest.NewRecorder()
+		m.dropIndexesRequest(w, r)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%v: expected %v, got %v", method, http.StatusMethodNotAllowed, w.Code)
+		}
+	}
+}
+
+func TestDropIndexesParams(t *testing.T) {
+	testcases := []struct {
+		query  string
+		failed bool
+	}{
+		{"bucket=default&name=idx_*", false},
+		{"bucket=default&name=*", false},
+		{"bucket=default", true},
+		{"bucket=default&name=", true},
+		{"name=*", true},
+		{"bucket=default&name=[", true},
+	}
+	for _, tc := range testcases {
+		r := httptest.NewRequest("DELETE", "/dropIndexes?"+tc.query, nil)
+		bucket, namePattern, err := dropIndexesParams(r)
+		if tc.failed != (err != nil) {
+			t.Errorf("%v: unexpected error %v", tc.query, err)
+		} else if err == nil && (bucket != "default" || len(namePattern) == 0) {
+			t.Errorf("%v: unexpected params %v %v", tc.query, bucket, namePattern)
+		}
+	}
+}
+
+func TestMatchIndexesToDrop(t *testing.T) {
+	idx1 := common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx_1"}
+	idx2 := common.IndexDefn{DefnId: 20, Bucket: "default", Name: "idx_2"}
+	other := common.IndexDefn{DefnId: 30, Bucket: "default", Name: "other"}
+	otherBucket := common.IndexDefn{DefnId: 40, Bucket: "beer", Name: "idx_1"}
+
+	clusterMeta := &ClusterIndexMetadata{Metadata: []LocalIndexMetadata{
+		{IndexDefinitions: []common.IndexDefn{idx2, idx1, other}},
+		{IndexDefinitions: []common.IndexDefn{idx1, otherBucket}},
+		{IndexDefinitions: []common.IndexDefn{idx2}},
+	}}
+	addrs := []string{"n1:9102", "n2:9102", ""}
+
+	results := matchIndexesToDrop(clusterMeta, addrs, "default", "idx_*")
+	if len(results) != 2 {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if results[0].DefnId != 10 || !reflect.DeepEqual(results[0].addrs, []string{"n1:9102", "n2:9102"}) {
+		t.Errorf("unexpected result %v %v", results[0], results[0].addrs)
+	}
+	if results[1].DefnId != 20 || !reflect.DeepEqual(results[1].addrs, []string{"n1:9102"}) {
+		t.Errorf("unexpected result %v %v", results[1], results[1].addrs)
+	}
+
+	if results := matchIndexesToDrop(clusterMeta, addrs, "default", "*"); len(results) != 3 {
+		t.Errorf("expected all indexes of the bucket, got %v", results)
+	}
+}
+
+func indexListNames(list []IndexListEntry) []string {
+	names := make([]string, len(list))
+	for i, entry := range list {
+		names[i] = entry.Name
+	}
+	return names
+}
+
+func TestIndexListSorter(t *testing.T) {
+	list := []IndexListEntry{
+		{DefnId: 4, Name: "idx_b", Bucket: "default", State: "Ready"},
+		{DefnId: 3, Name: "idx_a", Bucket: "beer", State: "Created"},
+		{DefnId: 2, Name: "idx_a", Bucket: "default", State: "Ready"},
+		{DefnId: 1, Name: "idx_c", Bucket: "beer", State: "Building"},
+	}
+
+	testcases := []struct {
+		param   string
+		defnIds []common.IndexDefnId
+	}{
+		// by name, bucket and defnId by default.
+		{"", []common.IndexDefnId{3, 2, 4, 1}},
+		{"-name", []common.IndexDefnId{1, 4, 3, 2}},
+		{"bucket", []common.IndexDefnId{3, 1, 2, 4}},
+		{"state,-defnId", []common.IndexDefnId{1, 3, 4, 2}},
+		{"defnId", []common.IndexDefnId{1, 2, 3, 4}},
+	}
+	for _, tc := range testcases {
+		less, err := indexListSorter(tc.param)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.param, err)
+		}
+		sorted := append([]IndexListEntry(nil), list...)
+		sort.SliceStable(sorted, func(i, j int) bool { return less(&sorted[i], &sorted[j]) })
+		defnIds := make([]common.IndexDefnId, len(sorted))
+		for i, entry := range sorted {
+			defnIds[i] = entry.DefnId
+		}
+		if !reflect.DeepEqual(defnIds, tc.defnIds) {
+			t.Errorf("%v: expected %v, got %v", tc.param, tc.defnIds, defnIds)
+		}
+	}
+
+	for _, param := range []string{"size", "name,", "+name"} {
+		if _, err := indexListSorter(param); err == nil {
+			t.Errorf("%v: expected invalid sort field", param)
+		}
+	}
+}
+
+func TestPageIndexList(t *testing.T) {
+	list := []IndexListEntry{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+
+	testcases := []struct {
+		offset, limit int
+		names         []string
+		offsetOut     int
+	}{
+		{0, 0, []string{"a", "b", "c", "d", "e"}, 0},
+		{0, 2, []string{"a", "b"}, 0},
+		{2, 2, []string{"c", "d"}, 2},
+		{4, 2, []string{"e"}, 4},
+		{3, 0, []string{"d", "e"}, 3},
+		{5, 2, []string{}, 5},
+		{10, 2, []string{}, 5},
+	}
+	for _, tc := range testcases {
+		page, offset := pageIndexList(list, tc.offset, tc.limit)
+		if names := indexListNames(page); !reflect.DeepEqual(names, tc.names) || offset != tc.offsetOut {
+			t.Errorf("offset %v limit %v: expected %v at %v, got %v at %v",
+				tc.offset, tc.limit, tc.names, tc.offsetOut, names, offset)
+		}
+	}
+}
+
+// testTemplateDDL records the DDL of applying a template, and fails the
+// create of the definitions in failCreate.
+type testTemplateDDL struct {
+	created    []common.IndexDefn
+	dropped    []common.IndexDefnId
+	failCreate map[string]bool
+}
+
+func (d *testTemplateDDL) HandleCreateIndexDDL(defn *common.IndexDefn, isRebalReq bool) error {
+	key := defn.Name + ":" + strings.Join(defn.SecExprs, ",")
+	if d.failCreate[key] {
+		return errors.New("create failed")
+	}
+	d.created = append(d.created, *defn)
+	return nil
+}
+
+func (d *testTemplateDDL) HandleDeleteIndexDDL(defnId common.IndexDefnId) error {
+	d.dropped = append(d.dropped, defnId)
+	return nil
+}
+
+func TestApplyIndexTemplateDrift(t *testing.T) {
+	current := &common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx", SecExprs: []string{"`a`"}}
+	existing := map[string]*common.IndexDefn{"default:idx": current}
+	template := func() []common.IndexDefn {
+		return []common.IndexDefn{
+			{Bucket: "default", Name: "idx", SecExprs: []string{"b"}},
+			{Bucket: "default", Name: "new", SecExprs: []string{"c"}},
+		}
+	}
+
+	// drift is only reported without force.
+	ddl := &testTemplateDDL{}
+	results, failed := applyIndexTemplate(ddl, template(), existing, false, false)
+	if failed || len(results) != 2 || results[0].Action != common.TemplateDrifted ||
+		results[1].Action != common.TemplateCreate {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if len(ddl.dropped) != 0 || len(ddl.created) != 1 || ddl.created[0].Name != "new" {
+		t.Fatalf("unexpected DDL dropped %v created %v", ddl.dropped, ddl.created)
+	}
+
+	// a dry run makes no change.
+	ddl = &testTemplateDDL{}
+	results, failed = applyIndexTemplate(ddl, template(), existing, true, true)
+	if failed || results[0].Action != common.TemplateRecreate || len(ddl.dropped)+len(ddl.created) != 0 {
+		t.Fatalf("unexpected dry run results %v dropped %v created %v", results, ddl.dropped, ddl.created)
+	}
+
+	ddl = &testTemplateDDL{}
+	results, failed = applyIndexTemplate(ddl, template(), existing, false, true)
+	if failed || results[0].Action != common.TemplateRecreate || results[0].Error != "" {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if len(ddl.dropped) != 1 || ddl.dropped[0] != current.DefnId ||
+		len(ddl.created) != 2 || ddl.created[0].SecExprs[0] != "b" || ddl.created[0].DefnId == current.DefnId {
+		t.Fatalf("unexpected DDL dropped %v created %v", ddl.dropped, ddl.created)
+	}
+
+	// unchanged indexes are left alone.
+	ddl = &testTemplateDDL{}
+	unchanged := []common.IndexDefn{{Bucket: "default", Name: "idx", SecExprs: []string{"a"}}}
+	results, failed = applyIndexTemplate(ddl, unchanged, existing, false, true)
+	if failed || results[0].Action != common.TemplateUnchanged || len(ddl.dropped)+len(ddl.created) != 0 {
+		t.Fatalf("unexpected results %v dropped %v created %v", results, ddl.dropped, ddl.created)
+	}
+}
+
+func TestApplyIndexTemplateCreateFailed(t *testing.T) {
+	current := &common.IndexDefn{DefnId: 10, Bucket: "default", Name: "idx", SecExprs: []string{"`a`"}}
+	existing := map[string]*common.IndexDefn{"default:idx": current}
+
+	// the dropped index is restored when the template index fails.
+	ddl := &testTemplateDDL{failCreate: map[string]bool{"idx:b": true}}
+	template := []common.IndexDefn{{Bucket: "default", Name: "idx", SecExprs: []string{"b"}}}
+	results, failed := applyIndexTemplate(ddl, template, existing, false, true)
+	if !failed || !strings.Contains(results[0].Error, "restored") {
+		t.Fatalf("expected create to fail, got %v", results)
+	}
+	if len(ddl.dropped) != 1 || len(ddl.created) != 1 || ddl.created[0].SecExprs[0] != "`a`" ||
+		ddl.created[0].DefnId == current.DefnId {
+		t.Fatalf("expected index to be restored, dropped %v created %v", ddl.dropped, ddl.created)
+	}
+	if current.DefnId != 10 {
+		t.Fatalf("unexpected change of the current definition %v", current)
+	}
+
+	// an invalid definition fails before the index is dropped.
+	ddl = &testTemplateDDL{}
+	template = []common.IndexDefn{{Bucket: "default", Name: "idx", SecExprs: []string{"b +"}}}
+	results, failed = applyIndexTemplate(ddl, template, existing, false, true)
+	if !failed || results[0].Error == "" || len(ddl.dropped)+len(ddl.created) != 0 {
+		t.Fatalf("expected invalid definition to fail, got %v dropped %v created %v",
+			results, ddl.dropped, ddl.created)
+	}
+}
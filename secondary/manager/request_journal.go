@@ -0,0 +1,287 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	c "github.com/couchbase/gometa/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+///////////////////////////////////////////////////////
+// Request Journal
+///////////////////////////////////////////////////////
+
+//
+// The request journal keeps the latest requests dispatched by the
+// lifecycle manager, so that the sequence of DDL and topology changes
+// leading to a bad state can be reconstructed after a crash.
+//
+// A request is appended to the journal file before it is processed,
+// and appended again with its outcome and latency once it is done.  A
+// request left pending in the journal file on restart was interrupted.
+// The journal file is rewritten with the latest entries once it has
+// grown to twice its size.  It is not synced, so it survives a crash
+// of the process but not of the node.
+//
+
+type RequestJournalEntry struct {
+	Seq     uint64 `json:"seq"`
+	ReqId   uint64 `json:"reqId"`
+	Source  string `json:"source"`
+	OpCode  string `json:"opCode"`
+	Key     string `json:"key"`
+	Time    string `json:"time"`
+	Outcome string `json:"outcome"`
+	Latency int64  `json:"latency"` // nanoseconds
+	Error   string `json:"error,omitempty"`
+}
+
+const (
+	JOURNAL_PENDING     = "pending"
+	JOURNAL_SUCCESS     = "success"
+	JOURNAL_FAILED      = "failed"
+	JOURNAL_INTERRUPTED = "interrupted"
+)
+
+type requestJournal struct {
+	mutex   sync.Mutex
+	path    string
+	size    int
+	file    *os.File
+	entries []*RequestJournalEntry
+	seq     uint64
+	written int
+}
+
+//
+// Open the request journal, loading the entries from a previous run.
+//
+func newRequestJournal(path string, size int) *requestJournal {
+
+	j := &requestJournal{path: path, size: size}
+	j.load()
+
+	if err := j.rewrite(); err != nil {
+		logging.Errorf("requestJournal: error writing journal %v.  Journal is not persisted. Error = %v", path, err)
+	}
+
+	return j
+}
+
+func (j *requestJournal) load() {
+
+	buf, err := ioutil.ReadFile(j.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Errorf("requestJournal: error reading journal %v. Error = %v", j.path, err)
+		}
+		return
+	}
+
+	// the outcome of a request replaces its pending entry
+	bySeq := make(map[uint64]*RequestJournalEntry)
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		entry := new(RequestJournalEntry)
+		if err := json.Unmarshal(line, entry); err != nil {
+			// last line can be partially written on crash
+			logging.Warnf("requestJournal: skip unreadable journal entry in %v. Error = %v", j.path, err)
+			continue
+		}
+
+		if old, ok := bySeq[entry.Seq]; ok {
+			*old = *entry
+		} else {
+			bySeq[entry.Seq] = entry
+			j.entries = append(j.entries, entry)
+		}
+
+		if entry.Seq > j.seq {
+			j.seq = entry.Seq
+		}
+	}
+
+	for _, entry := range j.entries {
+		if entry.Outcome == JOURNAL_PENDING {
+			entry.Outcome = JOURNAL_INTERRUPTED
+		}
+	}
+
+	if len(j.entries) > j.size {
+		j.entries = j.entries[len(j.entries)-j.size:]
+	}
+
+	logging.Infof("requestJournal: loaded %v entries from %v", len(j.entries), j.path)
+}
+
+//
+// Record a request before it is processed.
+//
+func (j *requestJournal) begin(fid string, reqId uint64, op c.OpCode, key string) *RequestJournalEntry {
+
+	if j == nil {
+		return nil
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.seq++
+	entry := &RequestJournalEntry{
+		Seq:     j.seq,
+		ReqId:   reqId,
+		Source:  fid,
+		OpCode:  client.OpCodeString(op),
+		Key:     key,
+		Time:    time.Now().Format(time.RFC3339Nano),
+		Outcome: JOURNAL_PENDING,
+	}
+
+	j.entries = append(j.entries, entry)
+	if len(j.entries) > j.size {
+		j.entries = j.entries[len(j.entries)-j.size:]
+	}
+
+	j.append(entry)
+	return entry
+}
+
+//
+// Record the outcome of a request.
+//
+func (j *requestJournal) end(entry *RequestJournalEntry, start time.Time, err error) {
+
+	if j == nil || entry == nil {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entry.Latency = int64(time.Since(start))
+	if err == nil {
+		entry.Outcome = JOURNAL_SUCCESS
+	} else {
+		entry.Outcome = JOURNAL_FAILED
+		entry.Error = err.Error()
+	}
+
+	j.append(entry)
+}
+
+//
+// Get the journal entries, oldest first.
+//
+func (j *requestJournal) list() []RequestJournalEntry {
+
+	if j == nil {
+		return nil
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	result := make([]RequestJournalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		result = append(result, *entry)
+	}
+	return result
+}
+
+func (j *requestJournal) close() {
+
+	if j == nil {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+func (j *requestJournal) append(entry *RequestJournalEntry) {
+
+	if j.file == nil {
+		return
+	}
+
+	if j.written >= 2*j.size {
+		if err := j.rewrite(); err != nil {
+			logging.Errorf("requestJournal: error rewriting journal %v.  Journal is not persisted. Error = %v", j.path, err)
+		}
+		// the rewrite has the entry already
+		return
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		logging.Errorf("requestJournal: error marshalling journal entry. Error = %v", err)
+		return
+	}
+
+	if _, err := j.file.Write(append(buf, '\n')); err != nil {
+		logging.Errorf("requestJournal: error writing journal %v. Error = %v", j.path, err)
+		return
+	}
+	j.written++
+}
+
+//
+// Rewrite the journal file with the entries in memory.
+//
+func (j *requestJournal) rewrite() error {
+
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range j.entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	temp := j.path + ".tmp"
+	if err := ioutil.WriteFile(temp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, j.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	j.file = file
+	j.written = len(j.entries)
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func journalLines(t *testing.T, path string) int {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(buf, []byte("\n"))
+}
+
+func TestRequestJournalLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, REQUEST_JOURNAL_NAME)
+
+	j := newRequestJournal(path, 10)
+	start := time.Now()
+	j.end(j.begin("fid1", 1, client.OPCODE_CREATE_INDEX, "100"), start, nil)
+	j.end(j.begin("fid1", 2, client.OPCODE_BUILD_INDEX, "100"), start, errors.New("build failed"))
+	j.begin("fid2", 3, client.OPCODE_DROP_INDEX, "100")
+	j.close()
+
+	// a crash can leave the last line partially written.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"seq":4,"reqId":4,"sour`))
+	file.Close()
+
+	j = newRequestJournal(path, 10)
+	defer j.close()
+	entries := j.list()
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", entries)
+	}
+	expected := []struct {
+		opCode, outcome, err string
+	}{
+		{"CreateIndex", JOURNAL_SUCCESS, ""},
+		{"BuildIndex", JOURNAL_FAILED, "build failed"},
+		{"DropIndex", JOURNAL_INTERRUPTED, ""},
+	}
+	for i, e := range expected {
+		entry := entries[i]
+		if entry.Seq != uint64(i+1) || entry.OpCode != e.opCode ||
+			entry.Outcome != e.outcome || entry.Error != e.err {
+			t.Errorf("Unexpected entry %v: %+v", i, entry)
+		}
+	}
+
+	// the rewrite on load drops the partial line, and sequence
+	// numbers continue from the loaded entries.
+	if n := journalLines(t, path); n != 3 {
+		t.Errorf("Expected 3 lines in journal, got %v", n)
+	}
+	if entry := j.begin("fid1", 5, client.OPCODE_CREATE_INDEX, "200"); entry.Seq != 4 {
+		t.Errorf("Expected seq 4, got %v", entry.Seq)
+	}
+}
+
+func TestRequestJournalRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, REQUEST_JOURNAL_NAME)
+
+	j := newRequestJournal(path, 4)
+	start := time.Now()
+	for i := 1; i <= 4; i++ {
+		j.end(j.begin("fid", uint64(i), client.OPCODE_CREATE_INDEX, fmt.Sprint(i)), start, nil)
+	}
+	if n := journalLines(t, path); n != 8 {
+		t.Fatalf("Expected 8 lines in journal, got %v", n)
+	}
+
+	// the journal is trimmed to its size, and the file is rewritten
+	// once it has grown to twice its size.
+	j.begin("fid", 5, client.OPCODE_CREATE_INDEX, "5")
+	entries := j.list()
+	if len(entries) != 4 || entries[0].Seq != 2 || entries[3].Seq != 5 {
+		t.Fatalf("Expected entries 2 to 5, got %v", entries)
+	}
+	if n := journalLines(t, path); n != 4 {
+		t.Fatalf("Expected 4 lines in rewritten journal, got %v", n)
+	}
+	j.close()
+
+	j = newRequestJournal(path, 2)
+	defer j.close()
+	entries = j.list()
+	if len(entries) != 2 || entries[0].Seq != 4 || entries[1].Seq != 5 ||
+		entries[1].Outcome != JOURNAL_INTERRUPTED {
+		t.Fatalf("Expected entries 4 and 5, got %v", entries)
+	}
+	if n := journalLines(t, path); n != 2 {
+		t.Fatalf("Expected 2 lines in journal, got %v", n)
+	}
+}
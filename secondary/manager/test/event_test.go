@@ -52,10 +52,32 @@ func TestEventMgr(t *testing.T) {
 	time.Sleep(time.Duration(1000) * time.Millisecond)
 
 	logging.Infof("Start Listening to event")
-	notifications, err := mgr.StartListenIndexCreate("TestEventMgr")
+	handle, notifications, err := mgr.StartListenIndexCreate("TestEventMgr")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer mgr.StopListenIndexCreate(handle)
+
+	// a second listener of the same component gets its own handle.
+	handle2, notifications2, err := mgr.StartListenIndexCreate("TestEventMgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handle2 == handle {
+		t.Fatalf("Duplicate listener handle %v", handle)
+	}
+	mgr.StopListenIndexCreate(handle2)
+
+	found := false
+	for _, sub := range mgr.GetEventSubscriptions() {
+		if sub.Id == handle2 {
+			t.Fatalf("Listener %v is still subscribed", handle2)
+		}
+		found = found || sub.Id == handle
+	}
+	if !found {
+		t.Fatalf("Listener %v is not subscribed", handle)
+	}
 
 	// Add a new index definition : 300
 	idxDefn := &common.IndexDefn{
@@ -80,6 +102,12 @@ func TestEventMgr(t *testing.T) {
 		t.Fatal("Does not receive notification from watcher")
 	}
 
+	select {
+	case <-notifications2:
+		t.Fatal("Receive notification after stop listening")
+	default:
+	}
+
 	idxDefn, err = common.UnmarshallIndexDefn(([]byte)(data))
 	if err != nil {
 		t.Fatal(err)
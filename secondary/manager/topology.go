@@ -68,9 +68,11 @@ type IndexKeyPartDistribution struct {
 }
 
 type IndexSliceLocator struct {
-	SliceId   uint64 `json:"sliceId,omitempty"`
-	State     uint32 `json:"state,omitempty"`
-	IndexerId string `json:"indexerId,omitempty"`
+	SliceId   uint64   `json:"sliceId,omitempty"`
+	State     uint32   `json:"state,omitempty"`
+	IndexerId string   `json:"indexerId,omitempty"`
+	Vbuckets  []uint16 `json:"vbuckets,omitempty"` // empty if the slice owns all vbuckets
+	HostPath  string   `json:"hostPath,omitempty"` // storage path of the slice on the indexer
 }
 
 //
@@ -629,6 +631,94 @@ func (t *IndexTopology) GetRStatusByInst(defnId common.IndexDefnId, instId commo
 	return common.REBAL_ACTIVE
 }
 
+//
+// Check if the slice owns the vbucket
+//
+func (s IndexSliceLocator) OwnsVbucket(vb uint16) bool {
+
+	if len(s.Vbuckets) == 0 {
+		return true
+	}
+
+	for _, v := range s.Vbuckets {
+		if v == vb {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Find the slice of the partition owning the vbucket
+//
+func (inst IndexInstDistribution) FindSliceByVbucket(partId uint64, vb uint16) *IndexSliceLocator {
+
+	for i, _ := range inst.Partitions {
+		if inst.Partitions[i].PartId == partId {
+			slices := inst.Partitions[i].SinglePartition.Slices
+			for j, _ := range slices {
+				if slices[j].OwnsVbucket(vb) {
+					return &slices[j]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//
+// Get the slice id owning each vbucket of the partition.  Vbuckets not
+// owned by any slice are not in the map.
+//
+func (inst IndexInstDistribution) GetVbucketSliceMap(partId uint64, numVbuckets int) map[uint16]uint64 {
+
+	result := make(map[uint16]uint64)
+	for vb := 0; vb < numVbuckets; vb++ {
+		if slice := inst.FindSliceByVbucket(partId, uint16(vb)); slice != nil {
+			result[uint16(vb)] = slice.SliceId
+		}
+	}
+	return result
+}
+
+//
+// Record the layout of a slice of a partition.  The slice is added if
+// the partition does not have it yet.
+//
+func (t *IndexTopology) UpdateSliceLayoutForIndexInst(defnId common.IndexDefnId, instId common.IndexInstId,
+	partId uint64, sliceId uint64, vbuckets []uint16, hostPath string) bool {
+
+	inst := t.GetIndexInstByDefn(defnId, instId)
+	if inst == nil {
+		return false
+	}
+
+	for i, _ := range inst.Partitions {
+		if inst.Partitions[i].PartId == partId {
+			slices := inst.Partitions[i].SinglePartition.Slices
+			for j, _ := range slices {
+				if slices[j].SliceId == sliceId {
+					slices[j].Vbuckets = vbuckets
+					slices[j].HostPath = hostPath
+					return true
+				}
+			}
+
+			slice := IndexSliceLocator{}
+			slice.SliceId = sliceId
+			slice.State = inst.State
+			if len(slices) != 0 {
+				slice.IndexerId = slices[0].IndexerId
+			}
+			slice.Vbuckets = vbuckets
+			slice.HostPath = hostPath
+			inst.Partitions[i].SinglePartition.Slices = append(slices, slice)
+			return true
+		}
+	}
+	return false
+}
+
 func (t IndexInstDistribution) IsProxy() bool {
 	return t.RealInstId != 0
 }
@@ -0,0 +1,67 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdateSliceLayout(t *testing.T) {
+	topology := &IndexTopology{
+		Bucket: "default",
+		Definitions: []IndexDefnDistribution{{
+			Bucket: "default",
+			DefnId: 10,
+			Instances: []IndexInstDistribution{{
+				InstId: 100,
+				State:  1,
+				Partitions: []IndexPartDistribution{{
+					PartId: 1,
+					SinglePartition: IndexSinglePartDistribution{
+						Slices: []IndexSliceLocator{{SliceId: 0, IndexerId: "idx1"}},
+					},
+				}},
+			}},
+		}},
+	}
+
+	if topology.UpdateSliceLayoutForIndexInst(10, 101, 1, 0, nil, "/data") {
+		t.Errorf("expected unknown instance to be ignored")
+	}
+	if topology.UpdateSliceLayoutForIndexInst(10, 100, 2, 0, nil, "/data") {
+		t.Errorf("expected unknown partition to be ignored")
+	}
+
+	// existing slice owns all vbuckets.
+	if !topology.UpdateSliceLayoutForIndexInst(10, 100, 1, 0, nil, "/data/slice0") {
+		t.Fatalf("expected slice layout to be updated")
+	}
+	inst := topology.GetIndexInstByDefn(10, 100)
+	if slice := inst.FindSliceByVbucket(1, 5); slice == nil || slice.HostPath != "/data/slice0" {
+		t.Errorf("unexpected slice %v", slice)
+	}
+
+	// a new slice takes over some vbuckets, found before the first one.
+	topology.UpdateSliceLayoutForIndexInst(10, 100, 1, 0, []uint16{0, 1}, "/data/slice0")
+	if !topology.UpdateSliceLayoutForIndexInst(10, 100, 1, 1, []uint16{2, 3}, "/data2/slice1") {
+		t.Fatalf("expected slice to be added")
+	}
+	inst = topology.GetIndexInstByDefn(10, 100)
+	slices := inst.Partitions[0].SinglePartition.Slices
+	if len(slices) != 2 || slices[1].IndexerId != "idx1" || slices[1].State != 1 {
+		t.Fatalf("unexpected slices %v", slices)
+	}
+
+	expected := map[uint16]uint64{0: 0, 1: 0, 2: 1, 3: 1}
+	if vbmap := inst.GetVbucketSliceMap(1, 5); !reflect.DeepEqual(vbmap, expected) {
+		t.Errorf("expected %v, got %v", expected, vbmap)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	repo "github.com/couchbase/gometa/repository"
 	"github.com/couchbase/indexing/secondary/logging"
 	"sync"
+	"time"
 )
 
 ///////////////////////////////////////////////////////
@@ -38,6 +39,11 @@ type watcher struct {
 	isClosed      bool
 	observes      map[string]*observeHandle
 	notifications map[common.Txnid]*notificationHandle
+
+	// The local repository is in sync with the leader while connected.
+	// Once disconnected, it lags by the time since the disconnect.
+	disconnected bool
+	syncTime     time.Time
 }
 
 type observeHandle struct {
@@ -89,12 +95,16 @@ func startWatcher(mgr *IndexManager,
 	readych := make(chan bool)
 
 	// TODO: call Close() to cleanup the state upon retry by the watcher server
-	go protocol.RunWatcherServer(
-		leaderAddr,
-		s.handler,
-		s.factory,
-		s.killch,
-		readych)
+	go func() {
+		protocol.RunWatcherServer(
+			leaderAddr,
+			s.handler,
+			s.factory,
+			s.killch,
+			readych)
+
+		s.setDisconnected()
+	}()
 
 	// TODO: timeout
 	<-readych
@@ -116,6 +126,33 @@ func (s *watcher) Close() {
 	}
 }
 
+//
+// lag returns how far the local repository may be behind the leader.
+//
+func (s *watcher) lag() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.disconnected {
+		return 0
+	}
+	return time.Since(s.syncTime)
+}
+
+//
+// setDisconnected marks the local repository as no longer receiving
+// commits from the leader.  It was in sync till now.
+//
+func (s *watcher) setDisconnected() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.disconnected {
+		s.disconnected = true
+		s.syncTime = time.Now()
+	}
+}
+
 func (s *watcher) Get(key string) ([]byte, error) {
 	return s.handler.Get(key)
 }
@@ -0,0 +1,279 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+)
+
+//////////////////////////////////////////////////////////////
+// Index Size Estimation
+/////////////////////////////////////////////////////////////
+
+//
+// IndexPreview is the predicted size of an index before it is built,
+// estimated by evaluating the index expressions over a random sample of
+// documents from the bucket.  Sizes are for a single replica.
+//
+type IndexPreview struct {
+	NumDocs       uint64  `json:"numDocs"`
+	NumSampled    uint64  `json:"numSampled"`
+	Selectivity   float64 `json:"selectivity"`
+	NumIndexed    uint64  `json:"numIndexed"`
+	NumEntries    uint64  `json:"numEntries"`
+	AvgDocKeySize uint64  `json:"avgDocKeySize"`
+	AvgSecKeySize uint64  `json:"avgSecKeySize"`
+	AvgArrSize    uint64  `json:"avgArrSize"`
+	AvgArrKeySize uint64  `json:"avgArrKeySize"`
+	MemUsage      uint64  `json:"memUsage"`
+	DataSize      uint64  `json:"dataSize"`
+	DiskUsage     uint64  `json:"diskUsage"`
+}
+
+type indexSampler struct {
+	defn      *common.IndexDefn
+	secExprs  []interface{}
+	whereExpr interface{}
+	arrExpr   qexpr.Expression
+	encodeBuf []byte
+
+	numSampled uint64
+	numIndexed uint64
+	docKeySize uint64
+	secKeySize uint64
+	arrSize    uint64
+	arrKeySize uint64
+}
+
+//
+// PreviewIndex samples up to sampleSize documents from the bucket of the
+// index, and predicts the number of index entries and the size of the
+// index with the sizing of storageMode.
+//
+func PreviewIndex(clusterUrl string, defn *common.IndexDefn, storageMode string, sampleSize int) (*IndexPreview, error) {
+
+	sampler, err := newIndexSampler(defn)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := common.ConnectBucket(clusterUrl, "default", defn.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer bucket.Close()
+
+	numDocs, err := bucketItemCount(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < sampleSize && numDocs != 0; i++ {
+		resp, err := bucket.GetRandomDoc()
+		if err != nil {
+			if sampler.numSampled == 0 {
+				return nil, err
+			}
+			logging.Warnf("PreviewIndex: stop sampling bucket %v after %v documents: %v", defn.Bucket, sampler.numSampled, err)
+			break
+		}
+		sampler.sample(resp.Key, resp.Body)
+	}
+
+	return sampler.preview(numDocs, storageMode)
+}
+
+func newIndexSampler(defn *common.IndexDefn) (*indexSampler, error) {
+
+	s := &indexSampler{
+		defn:      defn,
+		encodeBuf: make([]byte, 0, 1024),
+	}
+
+	if defn.IsPrimary {
+		return s, nil
+	}
+
+	if len(defn.ExprType) != 0 && defn.ExprType != common.N1QL {
+		return nil, errors.New(fmt.Sprintf("Cannot preview index with expression type %v", defn.ExprType))
+	}
+
+	var err error
+	if s.secExprs, err = protobuf.CompileN1QLExpression(defn.SecExprs); err != nil {
+		return nil, err
+	}
+
+	if len(defn.WhereExpr) != 0 {
+		whereExprs, err := protobuf.CompileN1QLExpression([]string{defn.WhereExpr})
+		if err != nil {
+			return nil, err
+		}
+		s.whereExpr = whereExprs[0]
+	}
+
+	for _, cExpr := range s.secExprs {
+		expr := cExpr.(qexpr.Expression)
+		if isArray, _ := expr.IsArrayIndexKey(); isArray {
+			s.arrExpr = expr
+			break
+		}
+	}
+
+	return s, nil
+}
+
+//
+// Evaluate the index expressions on a sampled document.
+//
+func (s *indexSampler) sample(docid []byte, body []byte) {
+
+	s.numSampled++
+
+	if s.defn.IsPrimary {
+		s.numIndexed++
+		s.docKeySize += uint64(len(docid))
+		return
+	}
+
+	docval := qvalue.NewAnnotatedValue(qvalue.NewParsedValueWithOptions(body, true, true))
+	context := qexpr.NewIndexContext()
+
+	if s.whereExpr != nil {
+		out, _, err := protobuf.N1QLTransform(nil, docval, context, []interface{}{s.whereExpr}, nil)
+		if err != nil || string(out) != "true" {
+			return
+		}
+	}
+
+	secKey, newBuf, err := protobuf.N1QLTransform(docid, docval, context, s.secExprs, s.encodeBuf)
+	if newBuf != nil {
+		s.encodeBuf = newBuf
+	}
+	if err != nil || secKey == nil {
+		return
+	}
+
+	s.numIndexed++
+	s.docKeySize += uint64(len(docid))
+
+	if s.arrExpr == nil {
+		s.secKeySize += uint64(len(secKey))
+		return
+	}
+
+	arrSize := uint64(1)
+	if _, vector, err := s.arrExpr.EvaluateForIndex(docval, context); err == nil && len(vector) > 1 {
+		arrSize = uint64(len(vector))
+	}
+	s.arrSize += arrSize
+	s.arrKeySize += uint64(len(secKey)) / arrSize
+}
+
+//
+// Extrapolate the sample to the bucket, and compute the index size.
+//
+func (s *indexSampler) preview(numDocs uint64, storageMode string) (*IndexPreview, error) {
+
+	result := &IndexPreview{
+		NumDocs:    numDocs,
+		NumSampled: s.numSampled,
+	}
+
+	if s.numSampled == 0 || s.numIndexed == 0 {
+		return result, nil
+	}
+
+	result.Selectivity = float64(s.numIndexed) / float64(s.numSampled)
+	result.NumIndexed = uint64(float64(numDocs) * result.Selectivity)
+	result.AvgDocKeySize = s.docKeySize / s.numIndexed
+	result.AvgSecKeySize = s.secKeySize / s.numIndexed
+	result.AvgArrKeySize = s.arrKeySize / s.numIndexed
+	result.NumEntries = result.NumIndexed
+	if s.arrExpr != nil {
+		result.AvgArrSize = s.arrSize / s.numIndexed
+		result.NumEntries = result.NumIndexed * result.AvgArrSize
+	}
+
+	index := &IndexUsage{
+		Name:          s.defn.Name,
+		Bucket:        s.defn.Bucket,
+		IsPrimary:     s.defn.IsPrimary,
+		StorageMode:   storageMode,
+		NumOfDocs:     result.NumIndexed,
+		AvgDocKeySize: result.AvgDocKeySize,
+		AvgSecKeySize: result.AvgSecKeySize,
+		AvgArrSize:    result.AvgArrSize,
+		AvgArrKeySize: result.AvgArrKeySize,
+		ResidentRatio: s.defn.ResidentRatio,
+	}
+	newGeneralSizingMethod().ComputeIndexSize(index)
+
+	result.MemUsage = index.MemUsage
+	result.DataSize = index.DataSize
+	result.DiskUsage = estimateDiskUsage(index, common.SystemConfig)
+
+	return result, nil
+}
+
+//
+// Estimate the disk usage of an index from its data size.  Storage lets
+// fragmentation build up to its compaction threshold before reclaiming
+// space, so the disk usage is the data size at that threshold.  MOI
+// persists snapshots as they are, without fragmentation.
+//
+func estimateDiskUsage(index *IndexUsage, config common.Config) uint64 {
+
+	var frag int
+	switch {
+	case index.IsMOI():
+		return index.DataSize
+	case index.IsPlasma():
+		frag = config["indexer.plasma.mainIndex.LSSFragmentation"].Int()
+	default:
+		frag = config["indexer.settings.compaction.min_frag"].Int()
+	}
+
+	if frag <= 0 || frag >= 100 {
+		return index.DataSize
+	}
+	return index.DataSize * 100 / uint64(100-frag)
+}
+
+//
+// Number of active items in the bucket.
+//
+func bucketItemCount(bucket *couchbase.Bucket) (uint64, error) {
+
+	stats, err := bucket.GetStats("")
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	for _, serverStats := range stats {
+		if items, ok := serverStats["curr_items"]; ok {
+			n, err := strconv.ParseUint(items, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package planner
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestIndexSamplerSecondary(t *testing.T) {
+
+	defn := &common.IndexDefn{
+		Name: "idx_age", Bucket: "default", ExprType: common.N1QL,
+		SecExprs: []string{"`age`"}, WhereExpr: "`type` = \"user\"",
+	}
+	sampler, err := newIndexSampler(defn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sampler.sample([]byte("u1"), []byte(`{"type":"user","age":10}`))
+	sampler.sample([]byte("u2"), []byte(`{"type":"user","age":20}`))
+	sampler.sample([]byte("o1"), []byte(`{"type":"order","age":30}`))
+	sampler.sample([]byte("u3"), []byte(`{"type":"user"}`))
+
+	if sampler.numSampled != 4 || sampler.numIndexed != 2 {
+		t.Fatalf("expected 4 sampled 2 indexed, got %v %v", sampler.numSampled, sampler.numIndexed)
+	}
+
+	preview, err := sampler.preview(1000, common.ForestDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Selectivity != 0.5 || preview.NumIndexed != 500 || preview.NumEntries != 500 {
+		t.Errorf("unexpected selectivity %v indexed %v entries %v",
+			preview.Selectivity, preview.NumIndexed, preview.NumEntries)
+	}
+	if preview.AvgDocKeySize != 2 || preview.AvgSecKeySize == 0 {
+		t.Errorf("unexpected key sizes %v %v", preview.AvgDocKeySize, preview.AvgSecKeySize)
+	}
+	if preview.DataSize == 0 || preview.DiskUsage <= preview.DataSize {
+		t.Errorf("expected disk usage %v above data size %v", preview.DiskUsage, preview.DataSize)
+	}
+}
+
+func TestIndexSamplerArray(t *testing.T) {
+
+	defn := &common.IndexDefn{
+		Name: "idx_tags", Bucket: "default", ExprType: common.N1QL,
+		SecExprs: []string{"DISTINCT ARRAY t FOR t IN `tags` END"}, IsArrayIndex: true,
+	}
+	sampler, err := newIndexSampler(defn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sampler.sample([]byte("d1"), []byte(`{"tags":["a","b","c","d"]}`))
+	sampler.sample([]byte("d2"), []byte(`{"tags":["a","b"]}`))
+
+	preview, err := sampler.preview(10, common.MemDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.AvgArrSize != 3 || preview.NumEntries != 30 {
+		t.Errorf("unexpected array size %v entries %v", preview.AvgArrSize, preview.NumEntries)
+	}
+	if preview.DiskUsage != preview.DataSize {
+		t.Errorf("expected MOI disk usage %v to be the data size %v", preview.DiskUsage, preview.DataSize)
+	}
+}
+
+func TestIndexSamplerPrimary(t *testing.T) {
+
+	sampler, err := newIndexSampler(&common.IndexDefn{Name: "#primary", Bucket: "default", IsPrimary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if preview, _ := sampler.preview(100, common.ForestDB); preview.NumIndexed != 0 {
+		t.Errorf("expected empty preview without samples, got %v", preview.NumIndexed)
+	}
+
+	sampler.sample([]byte("doc-1"), []byte(`{}`))
+	sampler.sample([]byte("doc-22"), []byte(`not json`))
+
+	preview, err := sampler.preview(100, common.ForestDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.NumIndexed != 100 || preview.AvgDocKeySize != 5 {
+		t.Errorf("unexpected indexed %v doc key size %v", preview.NumIndexed, preview.AvgDocKeySize)
+	}
+}
+
+func TestEstimateDiskUsage(t *testing.T) {
+
+	config := common.SystemConfig.Clone()
+	config.SetValue("indexer.settings.compaction.min_frag", 50)
+	config.SetValue("indexer.plasma.mainIndex.LSSFragmentation", 20)
+
+	testcases := map[string]uint64{
+		common.ForestDB:        2000,
+		common.PlasmaDB:        1250,
+		common.MemoryOptimized: 1000,
+	}
+	for storageMode, expected := range testcases {
+		index := &IndexUsage{StorageMode: storageMode, DataSize: 1000}
+		if diskUsage := estimateDiskUsage(index, config); diskUsage != expected {
+			t.Errorf("%v: expected disk usage %v, got %v", storageMode, expected, diskUsage)
+		}
+	}
+}
@@ -102,6 +102,8 @@ type IndexSpec struct {
 	Immutable          bool               `json:"immutable,omitempty"`
 	IsArrayIndex       bool               `json:"isArrayIndex,omitempty"`
 	RetainDeletedXATTR bool               `json:"retainDeletedXATTR,omitempty"`
+	DocMeta            bool               `json:"docMeta,omitempty"`
+	IncludeMissing     bool               `json:"includeMissing,omitempty"`
 	NumPartition       uint64             `json:"numPartition,omitempty"`
 	PartitionScheme    string             `json:"partitionScheme,omitempty"`
 	HashScheme         uint64             `json:"hashScheme,omitempty"`
@@ -354,6 +356,122 @@ func ExecutePlan(clusterUrl string, indexSpecs []*IndexSpec, nodes []string, ove
 	return ExecutePlanWithOptions(plan, indexSpecs, detail, "", "", -1, -1, -1, false, true)
 }
 
+//
+// SimulationResult is the predicted outcome of creating a set of indexes,
+// without creating them.
+//
+type SimulationResult struct {
+	Placement  []*SimulatedIndex `json:"placement"`
+	Nodes      []*SimulatedNode  `json:"nodes"`
+	Violations []*Violation      `json:"violations,omitempty"`
+	MemQuota   uint64            `json:"memQuota"`
+	CpuQuota   uint64            `json:"cpuQuota"`
+}
+
+// SimulatedIndex is the predicted placement of an index partition.
+type SimulatedIndex struct {
+	Name      string             `json:"name"`
+	Bucket    string             `json:"bucket"`
+	PartnId   common.PartitionId `json:"partnId"`
+	NodeId    string             `json:"nodeId"`
+	MemUsage  uint64             `json:"memUsage"`
+	DiskUsage uint64             `json:"diskUsage"`
+}
+
+// SimulatedNode is the predicted resource usage of an indexer node.
+// Deltas are the usage added by the simulated indexes.
+type SimulatedNode struct {
+	NodeId    string `json:"nodeId"`
+	MemUsage  uint64 `json:"memUsage"`
+	MemDelta  uint64 `json:"memDelta"`
+	DiskUsage uint64 `json:"diskUsage"`
+	DiskDelta uint64 `json:"diskDelta"`
+}
+
+//
+// ExecuteSimulation runs the planner on a hypothetical set of indexes
+// over the given plan.  Constraint violations are reported in the result,
+// rather than as an error, along with the placement that the planner
+// would have chosen.  Deleted nodes are not reported, since no index can
+// be placed on them.
+//
+func ExecuteSimulation(plan *Plan, indexSpecs []*IndexSpec) (*SimulationResult, error) {
+
+	if err := verifyDuplicateIndex(plan, indexSpecs); err != nil {
+		return nil, err
+	}
+
+	// replicas repaired by the planner do not have an initial node either,
+	// so tell the simulated indexes apart by name.
+	simulated := make(map[string]bool)
+	for _, spec := range indexSpecs {
+		simulated[spec.Bucket+":"+spec.Name] = true
+	}
+
+	config := DefaultRunConfig()
+	config.UseLive = true
+
+	p, _, planErr := execute(config, CommandPlan, plan, indexSpecs, ([]string)(nil))
+	if p == nil || p.Result == nil {
+		if planErr == nil {
+			planErr = errors.New("planner does not return any placement")
+		}
+		return nil, planErr
+	}
+
+	solution := p.Result
+	useLive := solution.UseLiveData()
+	result := &SimulationResult{
+		MemQuota: p.constraint.GetMemQuota(),
+		CpuQuota: p.constraint.GetCpuQuota(),
+	}
+
+	for _, indexer := range solution.Placement {
+		if indexer.IsDeleted() {
+			continue
+		}
+
+		node := &SimulatedNode{
+			NodeId:    indexer.NodeId,
+			MemUsage:  indexer.GetMemTotal(useLive),
+			DiskUsage: indexer.GetDiskUsage(useLive),
+		}
+
+		for _, index := range indexer.Indexes {
+			if !simulated[index.Bucket+":"+index.Name] {
+				continue
+			}
+			simIndex := &SimulatedIndex{
+				Name:      index.GetDisplayName(),
+				Bucket:    index.Bucket,
+				PartnId:   index.PartnId,
+				NodeId:    indexer.NodeId,
+				MemUsage:  index.GetMemTotal(useLive),
+				DiskUsage: index.GetDiskUsage(useLive),
+			}
+			node.MemDelta += simIndex.MemUsage
+			node.DiskDelta += simIndex.DiskUsage
+			result.Placement = append(result.Placement, simIndex)
+		}
+		result.Nodes = append(result.Nodes, node)
+	}
+
+	eligibles := p.placement.GetEligibleIndexes()
+	if !p.constraint.SatisfyClusterConstraint(solution, eligibles) {
+		if violations := p.constraint.GetViolations(solution, eligibles); violations != nil {
+			result.Violations = violations.Violations
+		}
+	}
+
+	// an error other than constraint violations means the simulation
+	// cannot be trusted.
+	if planErr != nil && len(result.Violations) == 0 {
+		return nil, planErr
+	}
+
+	return result, nil
+}
+
 func verifyDuplicateIndex(plan *Plan, indexSpecs []*IndexSpec) error {
 	for _, spec := range indexSpecs {
 		for _, indexer := range plan.Placement {
@@ -1180,6 +1298,8 @@ func indexUsageFromSpec(sizing SizingMethod, spec *IndexSpec) ([]*IndexUsage, er
 			index.Instance.Defn.Immutable = spec.Immutable
 			index.Instance.Defn.IsArrayIndex = spec.IsArrayIndex
 			index.Instance.Defn.RetainDeletedXATTR = spec.RetainDeletedXATTR
+			index.Instance.Defn.DocMeta = spec.DocMeta
+			index.Instance.Defn.IncludeMissing = spec.IncludeMissing
 			index.Instance.Defn.Deferred = spec.Deferred
 			index.Instance.Defn.Desc = spec.Desc
 			index.Instance.Defn.NumReplica = uint32(spec.Replica) - 1
@@ -20,6 +20,7 @@ var reqAddInstances = &protobuf.AddInstancesRequest{}
 var reqDelInstances = &protobuf.DelInstancesRequest{}
 var reqRepairEndpoints = &protobuf.RepairEndpointsRequest{}
 var reqShutdownFeed = &protobuf.ShutdownTopicRequest{}
+var reqAckTimestamps = &protobuf.AckTimestampsRequest{}
 var reqStats = c.Statistics{}
 
 var angioToken = uint16(1)
@@ -37,6 +38,7 @@ func (p *Projector) mainAdminPort(reqch chan ap.Request) {
 	p.admind.Register(reqDelInstances)
 	p.admind.Register(reqRepairEndpoints)
 	p.admind.Register(reqShutdownFeed)
+	p.admind.Register(reqAckTimestamps)
 	p.admind.Register(reqStats)
 	p.admind.RegisterHTTPHandler("/stats", p.handleStats)
 	p.admind.RegisterHTTPHandler("/settings", p.handleSettings)
@@ -108,6 +110,8 @@ func (p *Projector) handleRequest(req ap.Request, opaque uint16) {
 		response = p.doRepairEndpoints(request, opaque)
 	case *protobuf.ShutdownTopicRequest:
 		response = p.doShutdownTopic(request, opaque)
+	case *protobuf.AckTimestampsRequest:
+		response = p.doAckTimestamps(request, opaque)
 	default:
 		err = c.ErrorInvalidRequest
 		logging.Errorf("%v %v\n", p.logPrefix, err)
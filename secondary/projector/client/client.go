@@ -108,6 +108,9 @@ var ErrorDCPPool = errors.New("feed.dcpPool")
 // ErrorDCPBucket
 var ErrorDCPBucket = errors.New("feed.dcpBucket")
 
+// ErrorDCPAuth
+var ErrorDCPAuth = errors.New("feed.dcpAuth")
+
 // ErrorClusterInfo
 var ErrorClusterInfo = errors.New("feed.clusterInfo")
 
@@ -147,7 +150,7 @@ func NewClient(adminport string, maxvbs int, config c.Config) *Client {
 	expBackoff := config["exponentialBackoff"].Int()
 
 	urlPrefix := config["urlPrefix"].String()
-	ap := ap.NewHTTPClient(adminport, urlPrefix)
+	ap := ap.NewClient(adminport, urlPrefix)
 	client := &Client{
 		adminport:     adminport,
 		ap:            ap,
@@ -233,6 +236,8 @@ func (client *Client) GetFailoverLogs(
 // - ErrorFeeder if upstream connection has failures.
 //      upstream connection is closed for the bucket, the bucket
 //      needs to be newly added.
+// - ErrorDCPAuth if projector fails to authenticate with kv-node for
+//      the bucket, like ErrorFeeder the bucket needs to be newly added.
 // - ErrorNotMyVbucket due to rebalances and failures.
 // - ErrorStreamRequest if StreamRequest failed for some reason
 // - ErrorResponseTimeout if request is not completed within timeout.
@@ -296,6 +301,8 @@ func (client *Client) InitialTopicRequest(
 // - ErrorFeeder if upstream connection has failures.
 //      upstream connection is closed for the bucket, the bucket
 //      needs to be newly added.
+// - ErrorDCPAuth if projector fails to authenticate with kv-node for
+//      the bucket, like ErrorFeeder the bucket needs to be newly added.
 // - ErrorNotMyVbucket due to rebalances and failures.
 // - ErrorStreamRequest if StreamRequest failed for some reason
 // - ErrorResponseTimeout if request is not completed within timeout.
@@ -307,13 +314,20 @@ func (client *Client) InitialTopicRequest(
 // * active-timestamps returned in TopicResponse response contain
 //   entries only for successfully started {bucket,vbuckets}.
 // * rollback-timestamp contains vbucket entries that need rollback.
+// * requestId, if not empty, shall be same across retries of this
+//   request, so that a retry is not processed while the original
+//   request is still in progress with projector.
 func (client *Client) MutationTopicRequest(
 	topic, endpointType string,
 	reqTimestamps []*protobuf.TsVbuuid,
-	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
+	instances []*protobuf.Instance,
+	requestId string) (*protobuf.TopicResponse, error) {
 
 	req := protobuf.NewMutationTopicRequest(topic, endpointType, instances)
 	req.ReqTimestamps = reqTimestamps
+	if requestId != "" {
+		req.RequestId = proto.String(requestId)
+	}
 	res := &protobuf.TopicResponse{}
 	err := client.withRetry(
 		func() error {
@@ -350,6 +364,8 @@ func (client *Client) MutationTopicRequest(
 // - ErrorFeeder if upstream connection has failures.
 //      upstream connection is closed for the bucket, the bucket
 //      needs to be newly added.
+// - ErrorDCPAuth if projector fails to authenticate with kv-node for
+//      the bucket, like ErrorFeeder the bucket needs to be newly added.
 // - ErrorNotMyVbucket due to rebalances and failures.
 // - ErrorStreamRequest if StreamRequest failed for some reason
 // - ErrorStreamEnd if StreamEnd failed for some reason
@@ -406,6 +422,8 @@ func (client *Client) RestartVbuckets(
 // - ErrorFeeder if upstream connection has failures.
 //      upstream connection is closed for the bucket, the bucket
 //      needs to be newly added.
+// - ErrorDCPAuth if projector fails to authenticate with kv-node for
+//      the bucket, like ErrorFeeder the bucket needs to be newly added.
 // - ErrorResponseTimeout if request is not completed within timeout.
 //
 // * except of ErrorFeeder, projector feed will book-keep oustanding
@@ -456,6 +474,8 @@ func (client *Client) ShutdownVbuckets(
 // - ErrorFeeder if upstream connection has failures.
 //      upstream connection is closed for the bucket, the bucket needs to be
 //      newly added.
+// - ErrorDCPAuth if projector fails to authenticate with kv-node for
+//      the bucket, like ErrorFeeder the bucket needs to be newly added.
 // - ErrorNotMyVbucket due to rebalances and failures.
 // - ErrorStreamRequest if StreamRequest failed for some reason
 // - ErrorResponseTimeout if request is not completed within timeout.
@@ -523,11 +543,18 @@ func (client *Client) DelBuckets(topic string, buckets []string) error {
 // - http errors for transport related failures.
 // - ErrorTopicMissing if feed is not started.
 // - ErrorInconsistentFeed for malformed feed request.
+//
+// * requestId, if not empty, shall be same across retries of this
+//   request, refer MutationTopicRequest().
 func (client *Client) AddInstances(
 	topic string,
-	instances []*protobuf.Instance) (*protobuf.TimestampResponse, error) {
+	instances []*protobuf.Instance,
+	requestId string) (*protobuf.TimestampResponse, error) {
 
 	req := protobuf.NewAddInstancesRequest(topic, instances)
+	if requestId != "" {
+		req.RequestId = proto.String(requestId)
+	}
 	res := &protobuf.TimestampResponse{}
 	err := client.withRetry(
 		func() error {
@@ -597,6 +624,32 @@ func (client *Client) RepairEndpoints(
 	return nil
 }
 
+// AckTimestamps will post the timestamps, per bucket, applied by the
+// indexer listening on endpoint. Idempotent API.
+//
+// - return http errors for transport related failures.
+// - return ErrorTopicMissing if feed is not started.
+func (client *Client) AckTimestamps(
+	topic, endpoint string, ackTimestamps []*protobuf.TsVbuuid) error {
+
+	req := protobuf.NewAckTimestampsRequest(topic, endpoint, ackTimestamps)
+	res := &protobuf.Error{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if s := res.GetError(); s != "" {
+				return fmt.Errorf(s)
+			}
+			return err // nil
+		})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // ShutdownTopic will stop the feed for topic. Idempotent API.
 //
 // - return http errors for transport related failures.
@@ -71,3 +71,8 @@ func (engine *Engine) TransformRoute(
 		vbuuid, m, data, encodeBuf, docval, context,
 	)
 }
+
+// EvalErrorStats for this engine.
+func (engine *Engine) EvalErrorStats() map[string]interface{} {
+	return engine.evaluator.EvalErrorStats()
+}
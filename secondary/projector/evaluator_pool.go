@@ -0,0 +1,149 @@
+// evaluator pool concurrency model:
+//
+//   VbucketWorker(bucket1) --*                 *---> evaluator routine
+//                            |                 |
+//   VbucketWorker(bucket1) --*--> Evaluate() --*---> evaluator routine
+//                            |                 |
+//   VbucketWorker(bucket2) --*                 *---> evaluator routine
+//
+// Evaluate() queues the evaluation per bucket, and blocks till the
+// evaluation is done, so that mutations are forwarded in seqno order.
+// Evaluator routines pick queued evaluations from buckets in round-robin
+// fashion, so that expensive expressions on one bucket don't delay
+// mutations on other buckets.
+
+package projector
+
+import "fmt"
+import "runtime"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/logging"
+
+// EvaluatorPool is a bounded set of routines evaluating secondary
+// expressions for all feeds in the projector.
+type EvaluatorPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  map[string][]*evalJob // bucket -> pending evaluations
+	buckets []string              // buckets with pending evaluations
+	next    int                   // round-robin index into buckets
+	closed  bool
+	size    int
+}
+
+type evalJob struct {
+	fn        func()
+	donech    chan bool
+	recovered interface{}
+}
+
+// NewEvaluatorPool creates a pool of `size` evaluator routines, if size
+// is ZERO pool is sized to GOMAXPROCS.
+func NewEvaluatorPool(size int) *EvaluatorPool {
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+	pool := &EvaluatorPool{
+		queues:  make(map[string][]*evalJob),
+		buckets: make([]string, 0),
+		size:    size,
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+	for i := 0; i < size; i++ {
+		go pool.run()
+	}
+	return pool
+}
+
+// Size return the number of evaluator routines.
+func (pool *EvaluatorPool) Size() int {
+	return pool.size
+}
+
+// Evaluate fn for bucket in one of the evaluator routines, and wait for
+// the evaluation to complete. Panics in fn are re-raised in the caller's
+// routine. If pool is closed, fn is evaluated in the caller's routine.
+func (pool *EvaluatorPool) Evaluate(bucket string, fn func()) {
+	job := &evalJob{fn: fn, donech: make(chan bool)}
+
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		fn()
+		return
+	}
+	queue, ok := pool.queues[bucket]
+	if !ok || len(queue) == 0 {
+		pool.buckets = append(pool.buckets, bucket)
+	}
+	pool.queues[bucket] = append(queue, job)
+	pool.cond.Signal()
+	pool.mu.Unlock()
+
+	<-job.donech
+	if job.recovered != nil {
+		panic(fmt.Sprintf("evaluator crashed: %v", job.recovered))
+	}
+}
+
+// Close the pool, pending evaluations are completed before the
+// evaluator routines exit.
+func (pool *EvaluatorPool) Close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.closed = true
+	pool.cond.Broadcast()
+}
+
+func (pool *EvaluatorPool) run() {
+	for {
+		job := pool.nextJob()
+		if job == nil {
+			return
+		}
+		pool.evaluate(job)
+	}
+}
+
+// pick the next job in round-robin fashion across buckets, block till
+// a job is available, return nil if pool is closed.
+func (pool *EvaluatorPool) nextJob() *evalJob {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for len(pool.buckets) == 0 {
+		if pool.closed {
+			return nil
+		}
+		pool.cond.Wait()
+	}
+
+	if pool.next >= len(pool.buckets) {
+		pool.next = 0
+	}
+	bucket := pool.buckets[pool.next]
+	queue := pool.queues[bucket]
+	job := queue[0]
+	queue[0] = nil
+	if queue = queue[1:]; len(queue) == 0 {
+		delete(pool.queues, bucket)
+		copy(pool.buckets[pool.next:], pool.buckets[pool.next+1:])
+		pool.buckets = pool.buckets[:len(pool.buckets)-1]
+	} else {
+		pool.queues[bucket] = queue
+		pool.next++
+	}
+	return job
+}
+
+func (pool *EvaluatorPool) evaluate(job *evalJob) {
+	defer func() {
+		if job.recovered = recover(); job.recovered != nil {
+			logging.Errorf("evaluator crashed: %v\n", job.recovered)
+			logging.Errorf("%s", logging.StackTrace())
+		}
+		close(job.donech)
+	}()
+	job.fn()
+}
@@ -0,0 +1,100 @@
+package projector
+
+import "reflect"
+import "sync"
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+func TestEvaluatorPoolDefault(t *testing.T) {
+	if size := c.SystemConfig["projector.evaluatorPoolSize"].Int(); size >= 0 {
+		t.Errorf("expected evaluator pool to be disabled by default, got %v", size)
+	}
+}
+
+func TestEvaluatorPoolFairness(t *testing.T) {
+	pool := NewEvaluatorPool(1)
+	defer pool.Close()
+
+	// block the only evaluator routine.
+	blockch, startch := make(chan bool), make(chan bool)
+	go pool.Evaluate("a", func() { close(startch); <-blockch })
+	<-startch
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	order := make([]string, 0)
+	evaluate := func(bucket, name string, queued int) {
+		wg.Add(1)
+		go pool.Evaluate(bucket, func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			wg.Done()
+		})
+		waitForQueued(t, pool, queued)
+	}
+	evaluate("a", "a1", 1)
+	evaluate("a", "a2", 2)
+	evaluate("a", "a3", 3)
+	evaluate("b", "b1", 4)
+
+	close(blockch)
+	wg.Wait()
+	// b1 is not delayed behind all evaluations queued on bucket a.
+	if expected := []string{"a1", "b1", "a2", "a3"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+}
+
+func TestEvaluatorPoolPanic(t *testing.T) {
+	pool := NewEvaluatorPool(2)
+	defer pool.Close()
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic to be re-raised in the caller")
+			}
+		}()
+		pool.Evaluate("a", func() { panic("bad expression") })
+	}()
+
+	// pool continues to evaluate after a crash.
+	done := false
+	pool.Evaluate("a", func() { done = true })
+	if !done {
+		t.Errorf("expected evaluation after crash")
+	}
+}
+
+func TestEvaluatorPoolClosed(t *testing.T) {
+	pool := NewEvaluatorPool(0)
+	if pool.Size() <= 0 {
+		t.Errorf("expected pool sized to GOMAXPROCS, got %v", pool.Size())
+	}
+	pool.Close()
+
+	done := false
+	pool.Evaluate("a", func() { done = true })
+	if !done {
+		t.Errorf("expected inline evaluation on closed pool")
+	}
+}
+
+func waitForQueued(t *testing.T, pool *EvaluatorPool, n int) {
+	for i := 0; i < 1000; i++ {
+		pool.mu.Lock()
+		queued := 0
+		for _, queue := range pool.queues {
+			queued += len(queue)
+		}
+		pool.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %v queued evaluations", n)
+}
@@ -1,6 +1,8 @@
 package projector
 
 import "fmt"
+import "strconv"
+import "strings"
 import "time"
 
 import "github.com/couchbase/indexing/secondary/logging"
@@ -33,13 +35,14 @@ import "github.com/golang/protobuf/proto"
 
 // Feed is mutation stream - for maintenance, initial-load, catchup etc...
 type Feed struct {
-	cluster      string               // immutable
-	version      protobuf.FeedVersion // immutable
-	pooln        string               // immutable
-	topic        string               // immutable
-	opaque       uint16               // opaque that created this feed.
-	endpointType string               // immutable
-	projector    *Projector
+	cluster          string               // immutable
+	version          protobuf.FeedVersion // immutable
+	pooln            string               // immutable
+	topic            string               // immutable
+	opaque           uint16               // opaque that created this feed.
+	endpointType     string               // immutable
+	endpointFeatures uint32               // immutable, advertised by downstream
+	projector        *Projector
 
 	// upstream
 	// reqTs, book-keeping on outstanding request posted to feeder.
@@ -64,11 +67,15 @@ type Feed struct {
 	finch  chan bool
 	// book-keeping for stale-ness
 	stale int
+	// timestamps acknowledged by downstream endpoints.
+	acks map[string]*endpointAck // raddr -> endpointAck
 
 	// config params
 	reqTimeout time.Duration
 	endTimeout time.Duration
+	ackTimeout time.Duration
 	epFactory  c.RouterEndpointFactory
+	evalPool   *EvaluatorPool // shared by all feeds, can be nil
 	config     c.Config
 	logPrefix  string
 }
@@ -78,6 +85,7 @@ type Feed struct {
 //    clusterAddr: KV cluster address <host:port>.
 //    feedWaitStreamReqTimeout: wait for a response to StreamRequest
 //    feedWaitStreamEndTimeout: wait for a response to StreamEnd
+//    feedAckStuckTimeout: endpoint is stuck if its ack does not advance
 //    feedChanSize: channel size for feed's control path and back path
 //    mutationChanSize: channel size of projector's data path routine
 //    syncTimeout: timeout, in ms, for sending periodic Sync messages
@@ -107,6 +115,7 @@ func NewFeed(
 		kvdata:    make(map[string]*KVData),
 		engines:   make(map[string]map[uint64]*Engine),
 		endpoints: make(map[string]c.RouterEndpoint),
+		acks:      make(map[string]*endpointAck),
 		// genServer channel
 		reqch:  make(chan []interface{}, chsize),
 		backch: make(chan []interface{}, backchsize),
@@ -114,9 +123,13 @@ func NewFeed(
 
 		reqTimeout: time.Duration(config["feedWaitStreamReqTimeout"].Int()),
 		endTimeout: time.Duration(config["feedWaitStreamEndTimeout"].Int()),
+		ackTimeout: time.Duration(config["feedAckStuckTimeout"].Int()),
 		epFactory:  epf,
 		config:     config,
 	}
+	if projector != nil {
+		feed.evalPool = projector.evalPool
+	}
 	feed.logPrefix = fmt.Sprintf("FEED[<=>%v(%v)]", topic, feed.cluster)
 
 	go feed.genServer()
@@ -145,6 +158,8 @@ const (
 	fCmdResetConfig
 	fCmdDeleteEndpoint
 	fCmdPing
+	fCmdGetAckedTimestamps
+	fCmdAckTimestamps
 )
 
 // ResetConfig for this feed.
@@ -327,6 +342,30 @@ func (feed *Feed) GetStatistics() c.Statistics {
 	return nil
 }
 
+// GetAckedTimestamps returns per bucket timestamp, with the seqno
+// acknowledged by all downstream endpoints for each vbucket streamed by
+// this feed.
+// Synchronous call.
+func (feed *Feed) GetAckedTimestamps() ([]*protobuf.TsVbuuid, error) {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdGetAckedTimestamps, respch}
+	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	if err != nil {
+		return nil, err
+	}
+	return resp[0].([]*protobuf.TsVbuuid), nil
+}
+
+// AckTimestamps records the timestamps applied by the
+// downstream endpoint of the request.
+// Synchronous call.
+func (feed *Feed) AckTimestamps(req *protobuf.AckTimestampsRequest) error {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdAckTimestamps, req, respch}
+	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	return c.OpError(err, resp, 0)
+}
+
 // Shutdown feed, its upstream connection with kv and downstream endpoints.
 // Synchronous call.
 func (feed *Feed) Shutdown(opaque uint16) error {
@@ -659,6 +698,7 @@ func (feed *Feed) handleCommand(msg []interface{}) (status string) {
 		endpoint, ok := feed.endpoints[raddr]
 		if ok && !endpoint.Ping() { // delete endpoint only if not alive.
 			delete(feed.endpoints, raddr)
+			delete(feed.acks, raddr)
 			logging.Infof("%v endpoint %v deleted\n", feed.logPrefix, raddr)
 		}
 		// If there are no more endpoints, shutdown the feed.
@@ -675,6 +715,15 @@ func (feed *Feed) handleCommand(msg []interface{}) (status string) {
 	case fCmdPing:
 		respch := msg[1].(chan []interface{})
 		respch <- []interface{}{true}
+
+	case fCmdGetAckedTimestamps:
+		respch := msg[1].(chan []interface{})
+		respch <- []interface{}{feed.ackedTimestamps()}
+
+	case fCmdAckTimestamps:
+		req := msg[1].(*protobuf.AckTimestampsRequest)
+		respch := msg[2].(chan []interface{})
+		respch <- []interface{}{feed.ackTimestamps(req)}
 	}
 	return status
 }
@@ -683,6 +732,7 @@ func (feed *Feed) handleCommand(msg []interface{}) (status string) {
 // - return ErrorInconsistentFeed for malformed feed request
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorDCPAuth if upstream connection fails to authenticate.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamRequest if StreamRequest failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
@@ -690,6 +740,7 @@ func (feed *Feed) start(
 	req *protobuf.MutationTopicRequest, opaque uint16) (err error) {
 
 	feed.endpointType = req.GetEndpointType()
+	feed.endpointFeatures = req.GetEndpointFeatures()
 	feed.version = req.GetVersion()
 
 	// update engines and endpoints
@@ -772,6 +823,7 @@ func (feed *Feed) start(
 // - return ErrorInvalidBucket if bucket is not added.
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorDCPAuth if upstream connection fails to authenticate.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamRequest if StreamRequest failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
@@ -868,6 +920,7 @@ func (feed *Feed) restartVbuckets(
 // - return ErrorInvalidBucket if bucket is not added.
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorDCPAuth if upstream connection fails to authenticate.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamEnd if StreamEnd failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
@@ -941,6 +994,7 @@ func (feed *Feed) shutdownVbuckets(
 // - return ErrorInconsistentFeed for malformed feed request
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorDCPAuth if upstream connection fails to authenticate.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamRequest if StreamRequest failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
@@ -1126,7 +1180,7 @@ func (feed *Feed) repairEndpoints(
 
 		} else if (endpoint == nil) || !endpoint.Ping() {
 			topic, typ := feed.topic, feed.endpointType
-			config := feed.config.SectionConfig("dataport.", true /*trim*/)
+			config := feed.endpointConfig(feed.config)
 			endpoint, e = feed.epFactory(topic, typ, raddr, config)
 			if e != nil {
 				fmsg := "%v ##%x endpoint-factory %q: %v\n"
@@ -1161,6 +1215,7 @@ func (feed *Feed) repairEndpoints(
 // "ok", feed is active.
 // "stale", feed is stale.
 func (feed *Feed) staleCheck() string {
+	feed.checkAcks()
 	raddrs := []string{}
 	for raddr, endpoint := range feed.endpoints {
 		if endpoint.Ping() {
@@ -1175,6 +1230,13 @@ func (feed *Feed) getStatistics() c.Statistics {
 	stats, _ := c.NewStatistics(nil)
 	stats.Set("topic", feed.topic)
 	stats.Set("engines", feed.engineNames())
+	evalStats, _ := c.NewStatistics(nil)
+	for _, engines := range feed.engines {
+		for uuid, engine := range engines {
+			evalStats.Set(strconv.FormatUint(uuid, 10), engine.EvalErrorStats())
+		}
+	}
+	stats.Set("evalErrors", evalStats)
 	for bucketn, kvdata := range feed.kvdata {
 		stats.Set("bucket-"+bucketn, kvdata.GetStatistics())
 	}
@@ -1183,9 +1245,24 @@ func (feed *Feed) getStatistics() c.Statistics {
 		endStats.Set(raddr, endpoint.GetStatistics())
 	}
 	stats.Set("endpoints", endStats)
+	stats.Set("acks", feed.ackStatistics())
 	return stats
 }
 
+func (feed *Feed) getTimestamps() []*protobuf.TsVbuuid {
+	tss := make([]*protobuf.TsVbuuid, 0, len(feed.kvdata))
+	for bucketn, kvdata := range feed.kvdata {
+		ts, err := kvdata.GetTs()
+		if err != nil {
+			fmsg := "%v kvdata %q GetTs(): %v\n"
+			logging.Errorf(fmsg, feed.logPrefix, bucketn, err)
+			continue
+		}
+		tss = append(tss, ts)
+	}
+	return tss
+}
+
 func (feed *Feed) resetConfig(config c.Config) {
 	if cv, ok := config["feedWaitStreamReqTimeout"]; ok {
 		feed.reqTimeout = time.Duration(cv.Int())
@@ -1193,16 +1270,54 @@ func (feed *Feed) resetConfig(config c.Config) {
 	if cv, ok := config["feedWaitStreamEndTimeout"]; ok {
 		feed.endTimeout = time.Duration(cv.Int())
 	}
+	if cv, ok := config["feedAckStuckTimeout"]; ok {
+		feed.ackTimeout = time.Duration(cv.Int())
+	}
 	// pass the configuration to active kvdata
 	for _, kvdata := range feed.kvdata {
 		kvdata.ResetConfig(config)
 	}
+	feed.config = feed.config.Override(config)
 	// pass the configuration to active endpoints
 	econf := config.SectionConfig("dataport.", true /*trim*/)
+	if feed.isBackfill() {
+		// backfill settings shall not be reset by maintenance settings.
+		for key, cv := range feed.config.SectionConfig("backfill.dataport.", true) {
+			econf[key] = cv
+		}
+	}
 	for _, endpoint := range feed.endpoints {
 		endpoint.ResetConfig(econf)
 	}
-	feed.config = feed.config.Override(config)
+}
+
+// isBackfill returns true if the feed streams mutations for an initial
+// index build, as opposed to maintaining already built indexes.
+func (feed *Feed) isBackfill() bool {
+	return strings.HasPrefix(feed.topic, c.INIT_TOPIC) ||
+		strings.HasPrefix(feed.topic, c.CATCHUP_TOPIC)
+}
+
+// endpointConfig returns the dataport configuration for endpoints of this
+// feed. Backfill feeds connect to their own dataport on the indexer and
+// are throttled using projector.backfill.dataport.* settings, so that an
+// index build does not add latency to maintenance mutations.
+func (feed *Feed) endpointConfig(config c.Config) c.Config {
+	econf := config.SectionConfig("dataport.", true /*trim*/)
+	if feed.isBackfill() {
+		for key, cv := range config.SectionConfig("backfill.dataport.", true) {
+			econf[key] = cv
+		}
+	}
+	// disable features not supported by downstream.
+	features := feed.endpointFeatures
+	if features&protobuf.EndpointFeatureFragments == 0 {
+		econf.SetValue("maxFrameSize", 0)
+	}
+	if features&protobuf.EndpointFeatureCRC32C == 0 {
+		econf.SetValue("frameChecksum", false)
+	}
+	return econf
 }
 
 func (feed *Feed) shutdown(opaque uint16) error {
@@ -1292,6 +1407,9 @@ func (feed *Feed) openFeeder(
 	if err != nil {
 		fmsg := "%v ##%x OpenBucketFeed(%q): %v"
 		logging.Errorf(fmsg, feed.logPrefix, opaque, bucketn, err)
+		if couchbase.IsAuthError(err) {
+			return nil, projC.ErrorDCPAuth
+		}
 		return nil, projC.ErrorFeeder
 	}
 	return feeder, nil
@@ -1500,7 +1618,7 @@ func (feed *Feed) startEndpoints(
 
 			} else if endpoint == nil || !endpoint.Ping() {
 				topic, typ := feed.topic, feed.endpointType
-				config := feed.config.SectionConfig("dataport.", true /*trim*/)
+				config := feed.endpointConfig(feed.config)
 				endpoint, e = feed.epFactory(topic, typ, raddr, config)
 				if e != nil {
 					fmsg := "%v ##%x endpoint-factory %q: %v\n"
@@ -1809,6 +1927,7 @@ func FeedConfigParams() []string {
 		"vbucketWorkers",
 		"feedWaitStreamEndTimeout",
 		"feedWaitStreamReqTimeout",
+		"feedAckStuckTimeout",
 		"mutationChanSize",
 		"encodeBufSize",
 		"routerEndpointFactory",
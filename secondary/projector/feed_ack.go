@@ -0,0 +1,211 @@
+package projector
+
+import "time"
+
+import "github.com/couchbase/indexing/secondary/logging"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+// endpointAck is the book-keeping of timestamps acknowledged by a
+// downstream endpoint. Indexer periodically acknowledges the seqnos of
+// its latest snapshot, for each bucket it receives on the endpoint.
+// Projector keeps no replay buffer for mutations sent downstream, so
+// acknowledgements are used to measure how far each endpoint is behind
+// and to detect endpoints that are stuck, not applying mutations while
+// there are mutations pending for it.
+type endpointAck struct {
+	seqnos   map[string]map[uint16]uint64 // bucket -> vbno -> seqno
+	acked    time.Time                    // last acknowledgement
+	advanced time.Time                    // last acknowledgement with progress
+	stuck    bool
+}
+
+func newEndpointAck(now time.Time) *endpointAck {
+	return &endpointAck{
+		seqnos:   make(map[string]map[uint16]uint64),
+		acked:    now,
+		advanced: now,
+	}
+}
+
+// update acknowledged seqnos with ts, return true if any vbucket has
+// advanced. Seqnos are reset if vbucket has moved to a new branch.
+func (ack *endpointAck) update(ts *protobuf.TsVbuuid) bool {
+	bucketn := ts.GetBucket()
+	seqnos, ok := ack.seqnos[bucketn]
+	if !ok {
+		seqnos = make(map[uint16]uint64)
+		ack.seqnos[bucketn] = seqnos
+	}
+	advanced := false
+	for i, vbno := range ts.GetVbnos() {
+		seqno := ts.GetSeqnos()[i]
+		if seqno != seqnos[uint16(vbno)] {
+			seqnos[uint16(vbno)] = seqno
+			advanced = true
+		}
+	}
+	return advanced
+}
+
+// pending returns the number of mutations received from kv and not yet
+// acknowledged by endpoint, counting only buckets endpoint has
+// acknowledged.
+func (ack *endpointAck) pending(curTss map[string]*protobuf.TsVbuuid) uint64 {
+	var pending uint64
+	for bucketn, seqnos := range ack.seqnos {
+		curTs, ok := curTss[bucketn]
+		if !ok {
+			continue
+		}
+		for i, vbno := range curTs.GetVbnos() {
+			acked, ok := seqnos[uint16(vbno)]
+			if seqno := curTs.GetSeqnos()[i]; ok && seqno > acked {
+				pending += seqno - acked
+			}
+		}
+	}
+	return pending
+}
+
+// ackTimestamps records the timestamps acknowledged by an endpoint of
+// this feed, acknowledgements from unknown endpoints are ignored.
+func (feed *Feed) ackTimestamps(req *protobuf.AckTimestampsRequest) error {
+	raddr := req.GetEndpoint()
+	if _, ok := feed.endpoints[raddr]; !ok {
+		fmsg := "%v ack from unknown endpoint %q ignored\n"
+		logging.Debugf(fmsg, feed.logPrefix, raddr)
+		return nil
+	}
+
+	now := time.Now()
+	ack, ok := feed.acks[raddr]
+	if !ok {
+		ack = newEndpointAck(now)
+		feed.acks[raddr] = ack
+	}
+	ack.acked = now
+	for _, ts := range req.GetAckTimestamps() {
+		if ack.update(ts) {
+			ack.advanced = now
+		}
+	}
+	if ack.stuck && ack.advanced == now {
+		fmsg := "%v endpoint %q resumed acknowledging mutations\n"
+		logging.Infof(fmsg, feed.logPrefix, raddr)
+		ack.stuck = false
+	}
+	return nil
+}
+
+// currentTimestamps returns the last seqnos received from kv, per bucket.
+func (feed *Feed) currentTimestamps() map[string]*protobuf.TsVbuuid {
+	curTss := make(map[string]*protobuf.TsVbuuid)
+	for _, ts := range feed.getTimestamps() {
+		curTss[ts.GetBucket()] = ts
+	}
+	return curTss
+}
+
+// ackedTimestamps returns, per bucket, the seqnos acknowledged by all the
+// endpoints receiving the bucket.
+func (feed *Feed) ackedTimestamps() []*protobuf.TsVbuuid {
+	raddrs := make(map[string]map[string]bool)
+	for bucketn, engines := range feed.engines {
+		raddrs[bucketn] = make(map[string]bool)
+		for _, engine := range engines {
+			for _, raddr := range engine.Endpoints() {
+				raddrs[bucketn][raddr] = true
+			}
+		}
+	}
+	return minAckedTimestamps(feed.currentTimestamps(), raddrs, feed.acks)
+}
+
+// minAckedTimestamps returns, for each vbucket in curTss, the lowest seqno
+// acknowledged by the endpoints of its bucket. Vbuckets not acknowledged
+// by all of the endpoints are left out, and so are buckets without any
+// endpoint.
+func minAckedTimestamps(curTss map[string]*protobuf.TsVbuuid,
+	raddrs map[string]map[string]bool,
+	acks map[string]*endpointAck) []*protobuf.TsVbuuid {
+
+	tss := make([]*protobuf.TsVbuuid, 0, len(curTss))
+	for bucketn, curTs := range curTss {
+		if len(raddrs[bucketn]) == 0 {
+			continue
+		}
+		ackTs := protobuf.NewTsVbuuid(curTs.GetPool(), bucketn, len(curTs.GetVbnos()))
+		for i, vbno32 := range curTs.GetVbnos() {
+			vbno := uint16(vbno32)
+			seqno, ok := uint64(0), true
+			for raddr := range raddrs[bucketn] {
+				var acked uint64
+				if ack, found := acks[raddr]; !found {
+					ok = false
+				} else if acked, found = ack.seqnos[bucketn][vbno]; !found {
+					ok = false
+				} else if seqno == 0 || acked < seqno {
+					seqno = acked
+				}
+			}
+			if ok && seqno > 0 {
+				ackTs.Append(vbno, seqno, curTs.GetVbuuids()[i], seqno, seqno)
+			}
+		}
+		if len(ackTs.GetVbnos()) > 0 {
+			tss = append(tss, ackTs)
+		}
+	}
+	return tss
+}
+
+// checkAcks marks endpoints as stuck when their acknowledgement has not
+// advanced for feedAckStuckTimeout while mutations are pending for them.
+func (feed *Feed) checkAcks() {
+	if feed.ackTimeout == 0 || len(feed.acks) == 0 {
+		return
+	}
+
+	timeout := feed.ackTimeout * time.Millisecond
+	curTss := feed.currentTimestamps()
+	for raddr, ack := range feed.acks {
+		if pending, stuck := ack.check(curTss, time.Now(), timeout); stuck {
+			fmsg := "%v endpoint %q stuck, no progress since %v, %v mutations pending\n"
+			logging.Warnf(fmsg, feed.logPrefix, raddr, ack.advanced, pending)
+		}
+	}
+}
+
+// check whether endpoint is stuck at `now`, return the number of pending
+// mutations and true if endpoint has just become stuck.
+func (ack *endpointAck) check(curTss map[string]*protobuf.TsVbuuid,
+	now time.Time, timeout time.Duration) (uint64, bool) {
+
+	if now.Sub(ack.advanced) < timeout {
+		return 0, false
+	}
+	pending := ack.pending(curTss)
+	wasStuck := ack.stuck
+	ack.stuck = pending > 0
+	return pending, ack.stuck && !wasStuck
+}
+
+func (feed *Feed) ackStatistics() c.Statistics {
+	ackStats, _ := c.NewStatistics(nil)
+	if len(feed.acks) == 0 {
+		return ackStats
+	}
+
+	curTss := feed.currentTimestamps()
+	now := time.Now()
+	for raddr, ack := range feed.acks {
+		stats, _ := c.NewStatistics(nil)
+		stats.Set("lastAck", int64(now.Sub(ack.acked)/time.Millisecond))
+		stats.Set("lastAdvance", int64(now.Sub(ack.advanced)/time.Millisecond))
+		stats.Set("pending", ack.pending(curTss))
+		stats.Set("stuck", ack.stuck)
+		ackStats.Set(raddr, stats)
+	}
+	return ackStats
+}
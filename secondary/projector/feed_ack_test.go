@@ -0,0 +1,108 @@
+package projector
+
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func TestEndpointAckUpdate(t *testing.T) {
+	ack := newEndpointAck(time.Now())
+	if !ack.update(testTopicTs("default", []uint16{0, 1}, []uint64{10, 20}, 100)) {
+		t.Fatalf("expected first ack to advance")
+	}
+	if ack.update(testTopicTs("default", []uint16{0, 1}, []uint64{10, 20}, 100)) {
+		t.Fatalf("unexpected advance for the same ack")
+	}
+	if !ack.update(testTopicTs("default", []uint16{1}, []uint64{25}, 100)) {
+		t.Fatalf("expected ack to advance")
+	}
+
+	curTss := map[string]*protobuf.TsVbuuid{
+		"default": testTopicTs("default", []uint16{0, 1, 2}, []uint64{15, 25, 50}, 100),
+		"other":   testTopicTs("other", []uint16{0}, []uint64{50}, 100),
+	}
+	// vbucket 2 and bucket other are not acknowledged by the endpoint.
+	if pending := ack.pending(curTss); pending != 5 {
+		t.Errorf("expected 5 pending mutations, got %v", pending)
+	}
+}
+
+func TestEndpointAckCheck(t *testing.T) {
+	timeout := 120 * time.Second
+	now := time.Now()
+	ack := newEndpointAck(now)
+	ack.update(testTopicTs("default", []uint16{0}, []uint64{10}, 100))
+
+	// mutations keep arriving, acks of in-memory snapshots keep the
+	// endpoint from being stuck.
+	seqno := uint64(10)
+	for i := 0; i < 100; i++ {
+		now = now.Add(5 * time.Second)
+		seqno += 10
+		curTss := map[string]*protobuf.TsVbuuid{
+			"default": testTopicTs("default", []uint16{0}, []uint64{seqno + 10}, 100),
+		}
+		if ack.update(testTopicTs("default", []uint16{0}, []uint64{seqno}, 100)) {
+			ack.advanced = now
+		}
+		if _, stuck := ack.check(curTss, now, timeout); stuck || ack.stuck {
+			t.Fatalf("unexpected stuck endpoint after %v", time.Duration(i+1)*5*time.Second)
+		}
+	}
+
+	// no progress for the timeout while mutations are pending.
+	curTss := map[string]*protobuf.TsVbuuid{
+		"default": testTopicTs("default", []uint16{0}, []uint64{seqno + 100}, 100),
+	}
+	if _, stuck := ack.check(curTss, now.Add(timeout/2), timeout); stuck {
+		t.Fatalf("unexpected stuck endpoint before timeout")
+	}
+	if pending, stuck := ack.check(curTss, now.Add(timeout), timeout); !stuck || pending != 100 {
+		t.Fatalf("expected stuck endpoint with 100 pending, got %v %v", stuck, pending)
+	}
+	// reported once.
+	if _, stuck := ack.check(curTss, now.Add(2*timeout), timeout); stuck || !ack.stuck {
+		t.Fatalf("expected endpoint to be reported stuck once")
+	}
+
+	// an idle endpoint is not stuck.
+	curTss["default"] = testTopicTs("default", []uint16{0}, []uint64{seqno}, 100)
+	if _, stuck := ack.check(curTss, now.Add(3*timeout), timeout); stuck || ack.stuck {
+		t.Fatalf("unexpected stuck endpoint without pending mutations")
+	}
+}
+
+func TestFeedAckTimestamps(t *testing.T) {
+	feed := &Feed{
+		endpoints: map[string]c.RouterEndpoint{"n1:9100": nil},
+		acks:      make(map[string]*endpointAck),
+	}
+	ts := testTopicTs("default", []uint16{0}, []uint64{10}, 100)
+
+	// acks from unknown endpoints are ignored.
+	req := protobuf.NewAckTimestampsRequest("topic", "n2:9100", []*protobuf.TsVbuuid{ts})
+	if err := feed.ackTimestamps(req); err != nil || len(feed.acks) != 0 {
+		t.Fatalf("unexpected ack %v %v", err, feed.acks)
+	}
+
+	req = protobuf.NewAckTimestampsRequest("topic", "n1:9100", []*protobuf.TsVbuuid{ts})
+	if err := feed.ackTimestamps(req); err != nil {
+		t.Fatal(err)
+	}
+	ack := feed.acks["n1:9100"]
+	if ack == nil || ack.seqnos["default"][0] != 10 {
+		t.Fatalf("unexpected ack %v", ack)
+	}
+
+	// a stuck endpoint resumes once its ack advances.
+	ack.stuck = true
+	if err := feed.ackTimestamps(req); err != nil || !ack.stuck {
+		t.Fatalf("unexpected resume without progress %v", err)
+	}
+	ts = testTopicTs("default", []uint16{0}, []uint64{20}, 100)
+	req = protobuf.NewAckTimestampsRequest("topic", "n1:9100", []*protobuf.TsVbuuid{ts})
+	if err := feed.ackTimestamps(req); err != nil || ack.stuck {
+		t.Fatalf("expected endpoint to resume %v", err)
+	}
+}
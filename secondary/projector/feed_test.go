@@ -0,0 +1,69 @@
+package projector
+
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func TestFeedEndpointConfig(t *testing.T) {
+	config := c.SystemConfig.SectionConfig("projector.", true)
+	config.SetValue("dataport.maxMutationRate", 0)
+	config.SetValue("backfill.dataport.maxMutationRate", 1000)
+
+	testcases := map[string]int{
+		c.MAINT_TOPIC + "_node1":   0,
+		c.INIT_TOPIC + "_node1":    1000,
+		c.CATCHUP_TOPIC + "_node1": 1000,
+	}
+	for topic, rate := range testcases {
+		feed := &Feed{topic: topic}
+		if feed.isBackfill() != (rate > 0) {
+			t.Errorf("%v: unexpected isBackfill %v", topic, feed.isBackfill())
+		}
+		econf := feed.endpointConfig(config)
+		if v := econf["maxMutationRate"].Int(); v != rate {
+			t.Errorf("%v: expected maxMutationRate %v, got %v", topic, rate, v)
+		}
+	}
+}
+
+func TestFeedEndpointFeatures(t *testing.T) {
+	config := c.SystemConfig.SectionConfig("projector.", true)
+	if v := config["dataport.maxFrameSize"].Int(); v != 0 {
+		t.Errorf("expected fragmentation to be disabled by default, got %v", v)
+	}
+	if config["dataport.frameChecksum"].Bool() {
+		t.Errorf("expected checksum to be disabled by default")
+	}
+	config.SetValue("dataport.maxFrameSize", 1024*1024)
+	config.SetValue("dataport.frameChecksum", true)
+
+	// downstream not advertising features, like older indexers.
+	feed := &Feed{topic: c.MAINT_TOPIC + "_node1"}
+	econf := feed.endpointConfig(config)
+	if v := econf["maxFrameSize"].Int(); v != 0 {
+		t.Errorf("expected fragmentation to be disabled, got %v", v)
+	}
+	if econf["frameChecksum"].Bool() {
+		t.Errorf("expected checksum to be disabled")
+	}
+
+	feed.endpointFeatures = protobuf.EndpointFeatureFragments
+	econf = feed.endpointConfig(config)
+	if v := econf["maxFrameSize"].Int(); v != 1024*1024 {
+		t.Errorf("expected maxFrameSize %v, got %v", 1024*1024, v)
+	}
+	if econf["frameChecksum"].Bool() {
+		t.Errorf("expected checksum to be disabled")
+	}
+
+	req := protobuf.NewMutationTopicRequest(feed.topic, "dataport", nil)
+	feed.endpointFeatures = req.GetEndpointFeatures()
+	econf = feed.endpointConfig(config)
+	if v := econf["maxFrameSize"].Int(); v != 1024*1024 {
+		t.Errorf("expected maxFrameSize %v, got %v", 1024*1024, v)
+	}
+	if !econf["frameChecksum"].Bool() {
+		t.Errorf("expected checksum to be enabled")
+	}
+}
@@ -0,0 +1,133 @@
+package projector
+
+import "sync"
+import "time"
+
+import ap "github.com/couchbase/indexing/secondary/adminport"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+// inflightExpiry is the time for which a successfully completed request
+// is remembered to de-duplicate its retries.
+const inflightExpiry = 10 * time.Minute
+
+// inflightRequests de-duplicates requests retried by clients, typically
+// after a timeout. Requests are keyed by {topic, request-id}.
+//
+// - a retry received while the original request is still in progress
+//   waits for the original request to complete instead of re-opening
+//   streams.
+// - a retry received after the original request has succeeded gets the
+//   original response, till the request expires or its topic is closed.
+// - a failed request is forgotten on completion, so that its retry is
+//   processed as a fresh request.
+type inflightRequests struct {
+	mu       sync.Mutex
+	clock    c.Clock
+	requests map[string]*inflightRequest // topic/request-id -> request
+}
+
+type inflightRequest struct {
+	topic    string
+	donech   chan bool
+	doneAt   time.Time // zero value while in progress
+	response ap.MessageMarshaller
+}
+
+func newInflightRequests(clock c.Clock) *inflightRequests {
+	return &inflightRequests{
+		clock:    clock,
+		requests: make(map[string]*inflightRequest),
+	}
+}
+
+// begin a request, if a request with the same key is in progress or has
+// succeeded, return that request with `dup` as true. Requests without
+// request-id are not de-duplicated.
+func (ir *inflightRequests) begin(
+	topic, requestId string) (req *inflightRequest, dup bool) {
+
+	if requestId == "" {
+		return nil, false
+	}
+
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	ir.expire()
+	key := topic + "/" + requestId
+	if req, ok := ir.requests[key]; ok {
+		return req, true
+	}
+	req = &inflightRequest{topic: topic, donech: make(chan bool)}
+	ir.requests[key] = req
+	return req, false
+}
+
+// end the request with its response and wake up the duplicates waiting
+// on the request.
+func (ir *inflightRequests) end(
+	topic, requestId string, req *inflightRequest,
+	response ap.MessageMarshaller) {
+
+	if req == nil {
+		return
+	}
+
+	ir.mu.Lock()
+	if responseError(response) == "" {
+		req.doneAt = ir.clock.Now()
+	} else {
+		delete(ir.requests, topic+"/"+requestId)
+	}
+	req.response = response
+	ir.mu.Unlock()
+
+	close(req.donech)
+}
+
+// forget all requests on topic, subsequent requests on the topic, even
+// with a known request-id, are processed as fresh requests.
+func (ir *inflightRequests) forget(topic string) {
+	if ir == nil {
+		return
+	}
+
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	for key, req := range ir.requests {
+		if req.topic == topic && !req.doneAt.IsZero() {
+			delete(ir.requests, key)
+		}
+	}
+}
+
+// expire completed requests older than inflightExpiry, must be called
+// with ir.mu held.
+func (ir *inflightRequests) expire() {
+	now := ir.clock.Now()
+	for key, req := range ir.requests {
+		if !req.doneAt.IsZero() && now.Sub(req.doneAt) > inflightExpiry {
+			delete(ir.requests, key)
+		}
+	}
+}
+
+// wait for the original request to complete and return its response.
+func (req *inflightRequest) wait() ap.MessageMarshaller {
+	<-req.donech
+	return req.response
+}
+
+func responseError(response ap.MessageMarshaller) string {
+	switch resp := response.(type) {
+	case *protobuf.Error:
+		return resp.GetError()
+	case interface {
+		GetErr() *protobuf.Error
+	}:
+		return resp.GetErr().GetError()
+	}
+	return ""
+}
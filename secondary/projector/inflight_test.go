@@ -0,0 +1,110 @@
+package projector
+
+import "errors"
+import "sync"
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+import "github.com/golang/protobuf/proto"
+
+func TestInflightRetryInProgress(t *testing.T) {
+	ir := newInflightRequests(c.SystemClock{})
+
+	req, dup := ir.begin("topic", "req1")
+	if dup {
+		t.Fatalf("unexpected duplicate")
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*protobuf.TopicResponse, 2)
+	for i := range responses {
+		retry, dup := ir.begin("topic", "req1")
+		if !dup || retry != req {
+			t.Fatalf("expected retry %v to wait for the original request", i)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = retry.wait().(*protobuf.TopicResponse)
+		}(i)
+	}
+
+	response := &protobuf.TopicResponse{Topic: proto.String("topic")}
+	ir.end("topic", "req1", req, response)
+	wg.Wait()
+	for i, resp := range responses {
+		if resp != response {
+			t.Errorf("retry %v: expected original response, got %v", i, resp)
+		}
+	}
+}
+
+func TestInflightRetryAfterCompletion(t *testing.T) {
+	clock := c.NewFakeClock(time.Now())
+	ir := newInflightRequests(clock)
+
+	req, _ := ir.begin("topic", "req1")
+	response := &protobuf.TopicResponse{Topic: proto.String("topic")}
+	ir.end("topic", "req1", req, response)
+
+	// a retry after success is de-duplicated.
+	if retry, dup := ir.begin("topic", "req1"); !dup {
+		t.Fatalf("expected retry after completion to be de-duplicated")
+	} else if retry.wait() != response {
+		t.Errorf("expected original response")
+	}
+	// other requests are not.
+	if _, dup := ir.begin("topic", "req2"); dup {
+		t.Errorf("unexpected duplicate for a new request-id")
+	}
+	if _, dup := ir.begin("other", "req1"); dup {
+		t.Errorf("unexpected duplicate for another topic")
+	}
+
+	// completed requests expire.
+	clock.Advance(inflightExpiry + time.Second)
+	if _, dup := ir.begin("topic", "req1"); dup {
+		t.Errorf("expected completed request to expire")
+	}
+}
+
+func TestInflightRetryAfterFailure(t *testing.T) {
+	ir := newInflightRequests(c.SystemClock{})
+
+	req, _ := ir.begin("topic", "req1")
+	ir.end("topic", "req1", req, protobuf.NewError(errors.New("failed")))
+	if _, dup := ir.begin("topic", "req1"); dup {
+		t.Errorf("expected retry after failure to be processed")
+	}
+
+	req, _ = ir.begin("topic", "req2")
+	response := (&protobuf.TopicResponse{}).SetErr(errors.New("failed"))
+	ir.end("topic", "req2", req, response)
+	if _, dup := ir.begin("topic", "req2"); dup {
+		t.Errorf("expected retry after failed response to be processed")
+	}
+}
+
+func TestInflightForget(t *testing.T) {
+	ir := newInflightRequests(c.SystemClock{})
+
+	req, _ := ir.begin("topic", "req1")
+	ir.end("topic", "req1", req, &protobuf.TopicResponse{})
+	inprogress, _ := ir.begin("topic", "req2")
+
+	// once the topic is closed a retry re-opens the topic.
+	ir.forget("topic")
+	if _, dup := ir.begin("topic", "req1"); dup {
+		t.Errorf("expected request on closed topic to be forgotten")
+	}
+	if retry, dup := ir.begin("topic", "req2"); !dup || retry != inprogress {
+		t.Errorf("expected in progress request to be retained")
+	}
+
+	// requests without request-id are never de-duplicated.
+	if req, dup := ir.begin("topic", ""); req != nil || dup {
+		t.Errorf("unexpected de-duplication without request-id")
+	}
+}
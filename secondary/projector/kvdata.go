@@ -111,6 +111,7 @@ const (
 	kvCmdGetStats
 	kvCmdResetConfig
 	kvCmdReloadHeartBeat
+	kvCmdGetTs
 	kvCmdClose
 )
 
@@ -159,6 +160,18 @@ func (kvdata *KVData) GetStatistics() map[string]interface{} {
 	return resp[0].(map[string]interface{})
 }
 
+// GetTs returns the timestamp for vbuckets streamed by this kvdata, with
+// the last seqno received for each vbucket, synchronous call.
+func (kvdata *KVData) GetTs() (*protobuf.TsVbuuid, error) {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{kvCmdGetTs, respch}
+	resp, err := c.FailsafeOp(kvdata.sbch, respch, cmd, kvdata.finch)
+	if err != nil {
+		return nil, err
+	}
+	return resp[0].(*protobuf.TsVbuuid), nil
+}
+
 // ResetConfig for kvdata.
 func (kvdata *KVData) ResetConfig(config c.Config) error {
 	respch := make(chan []interface{}, 1)
@@ -205,6 +218,7 @@ func (kvdata *KVData) runScatter(
 	}()
 
 	vbseqnos := make([]uint64, 1024)
+	lastSeqnos := make([]uint64, 1024) // last seqno received for vbucket
 
 	// stats
 	statSince := time.Now()
@@ -247,6 +261,9 @@ loop:
 			}
 			kvdata.eventCount++
 			vbseqnos[m.VBucket], _ = kvdata.scatterMutation(m, ts)
+			if vbseqnos[m.VBucket] > 0 || m.Opcode == mcd.DCP_STREAMREQ {
+				lastSeqnos[m.VBucket] = vbseqnos[m.VBucket]
+			}
 
 		case <-heartBeat:
 			heartBeat = nil
@@ -386,6 +403,17 @@ loop:
 				heartBeat = time.After(kvdata.syncTimeout)
 				respch <- []interface{}{nil}
 
+			case kvCmdGetTs:
+				respch := msg[1].(chan []interface{})
+				curTs := ts.Clone()
+				for i, vbno := range curTs.Vbnos {
+					if seqno := lastSeqnos[vbno]; seqno > 0 {
+						curTs.Seqnos[i] = seqno
+						curTs.Snapshots[i] = protobuf.NewSnapshot(seqno, seqno)
+					}
+				}
+				respch <- []interface{}{curTs}
+
 			case kvCmdClose:
 				for _, worker := range kvdata.workers {
 					worker.Close()
@@ -36,6 +36,9 @@ type Projector struct {
 	adminport   string // projector listens on this adminport
 	maxvbs      int
 	cpuProfFd   *os.File
+	topicStore  *topicStore // nil if topic recovery is disabled
+	inflight    *inflightRequests
+	evalPool    *EvaluatorPool // nil if expressions are evaluated inline
 	logPrefix   string
 }
 
@@ -45,6 +48,7 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 	p := &Projector{
 		topics:         make(map[string]*Feed),
 		topicSerialize: make(map[string]*sync.Mutex),
+		inflight:       newInflightRequests(c.SystemClock{}),
 		maxvbs:         maxvbs,
 		pooln:          "default", // TODO: should this be configurable ?
 	}
@@ -73,7 +77,7 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 	apConfig := config.SectionConfig("projector.adminport.", true)
 	apConfig.SetValue("name", "PRAM")
 	reqch := make(chan ap.Request)
-	p.admind = ap.NewHTTPServer(apConfig, reqch)
+	p.admind = ap.NewServer(apConfig, reqch)
 
 	// set GOGC percent
 	gogc := pconfig["gogc"].Int()
@@ -81,11 +85,27 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 	fmsg := "%v changing GOGC percentage from %v to %v\n"
 	logging.Infof(fmsg, p.logPrefix, oldGogc, gogc)
 
+	if size := pconfig["evaluatorPoolSize"].Int(); size >= 0 {
+		p.evalPool = NewEvaluatorPool(size)
+		fmsg := "%v evaluator pool started with %v routines\n"
+		logging.Infof(fmsg, p.logPrefix, p.evalPool.Size())
+	}
+
+	if dir := pconfig["topicRecovery.dir"].String(); dir != "" {
+		p.topicStore, err = newTopicStore(dir, p.logPrefix)
+		c.CrashOnError(err)
+	}
+
 	watchInterval := config["projector.watchInterval"].Int()
 	staleTimeout := config["projector.staleTimeout"].Int()
 	go c.MemstatLogger(int64(config["projector.memstatTick"].Int()))
 	go p.mainAdminPort(reqch)
 	go p.watcherDameon(watchInterval, staleTimeout)
+	checkpointInterval := pconfig["topicRecovery.checkpointInterval"].Int()
+	go func() {
+		p.recoverTopics()
+		p.checkpointDaemon(checkpointInterval)
+	}()
 
 	callb := func(cfg c.Config) {
 		logging.Infof("%v settings notifier from metakv\n", p.logPrefix)
@@ -130,6 +150,12 @@ func (p *Projector) ResetConfig(config c.Config) {
 	}
 	p.config = p.config.Override(config)
 
+	// size guardrails of evaluators
+	protobuf.SetSizeGuardrails(
+		p.config["projector.guardrails.maxDocSize"].Int(),
+		p.config["projector.guardrails.maxKeySize"].Int(),
+		p.config["projector.guardrails.policy"].String())
+
 	// CPU-profiling
 	cpuProfile, ok := config["projector.cpuProfile"]
 	if ok && cpuProfile.Bool() && p.cpuProfFd == nil {
@@ -242,6 +268,7 @@ func (p *Projector) DelFeed(topic string) (err error) {
 		return projC.ErrorTopicMissing
 	}
 	delete(p.topics, topic)
+	p.inflight.forget(topic)
 	opaque := feed.GetOpaque()
 	logging.Infof("%v ##%x ... feed %q deleted\n", p.logPrefix, opaque, topic)
 
@@ -362,10 +389,36 @@ func (p *Projector) doFailoverLog(
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return dcp-client failures.
 // - return ErrorResponseTimeout if request is not completed within timeout.
+// - a retried request, while the original request is in progress or
+//   after it has succeeded, is not processed again and returns the
+//   current state of the topic, refer inflightRequests.
 func (p *Projector) doMutationTopic(
 	request *protobuf.MutationTopicRequest,
 	opaque uint16) ap.MessageMarshaller {
 
+	topic, requestId := request.GetTopic(), request.GetRequestId()
+	inflight, dup := p.inflight.begin(topic, requestId)
+	if dup {
+		fmsg := "%v ##%x doMutationTopic() %q duplicate request %q\n"
+		logging.Infof(fmsg, p.logPrefix, opaque, topic, requestId)
+		response := inflight.wait().(*protobuf.TopicResponse)
+		if feed, err := p.GetFeed(topic); err == nil {
+			if curResponse := feed.GetTopicResponse(); curResponse != nil {
+				curResponse.Err = response.GetErr()
+				return curResponse
+			}
+		}
+		return response
+	}
+	response := p.mutationTopic(request, opaque)
+	p.inflight.end(topic, requestId, inflight, response)
+	return response
+}
+
+func (p *Projector) mutationTopic(
+	request *protobuf.MutationTopicRequest,
+	opaque uint16) ap.MessageMarshaller {
+
 	topic := request.GetTopic()
 
 	// log this request.
@@ -389,7 +442,16 @@ func (p *Projector) doMutationTopic(
 	if err != nil {
 		response.SetErr(err)
 	}
-	p.AddFeed(topic, feed)
+	if err := p.AddFeed(topic, feed); err == projC.ErrorTopicExist {
+		if existing, _ := p.GetFeed(topic); existing != feed {
+			// don't leak the feed created by this request.
+			fmsg := "%v ##%x feed %q already exists, closing new feed\n"
+			logging.Errorf(fmsg, prefix, opaque, topic)
+			feed.Shutdown(opaque)
+			return response
+		}
+	}
+	p.topicStore.mutationTopic(request)
 	return response
 }
 
@@ -421,6 +483,7 @@ func (p *Projector) doRestartVbuckets(
 	}
 
 	response, err := feed.RestartVbuckets(request, opaque)
+	p.topicStore.restartVbuckets(request)
 	if err == nil {
 		return response
 	}
@@ -451,6 +514,7 @@ func (p *Projector) doShutdownVbuckets(
 	}
 
 	err = feed.ShutdownVbuckets(request, opaque)
+	p.topicStore.shutdownVbuckets(request)
 	return protobuf.NewError(err)
 }
 
@@ -481,6 +545,7 @@ func (p *Projector) doAddBuckets(
 	}
 
 	response, err := feed.AddBuckets(request, opaque)
+	p.topicStore.addBuckets(request)
 	if err == nil {
 		return response
 	}
@@ -510,15 +575,34 @@ func (p *Projector) doDelBuckets(
 	}
 
 	err = feed.DelBuckets(request, opaque)
+	p.topicStore.delBuckets(request)
 	return protobuf.NewError(err)
 }
 
 // - return ErrorTopicMissing if feed is not started.
 // - return ErrorInconsistentFeed for malformed feed request
 // - otherwise, error is empty string.
+// - a retried request, while the original request is in progress or
+//   after it has succeeded, is not processed again and returns the
+//   original response, refer inflightRequests.
 func (p *Projector) doAddInstances(
 	request *protobuf.AddInstancesRequest, opaque uint16) ap.MessageMarshaller {
 
+	topic, requestId := request.GetTopic(), request.GetRequestId()
+	inflight, dup := p.inflight.begin(topic, requestId)
+	if dup {
+		fmsg := "%v ##%x doAddInstances() %q duplicate request %q\n"
+		logging.Infof(fmsg, p.logPrefix, opaque, topic, requestId)
+		return inflight.wait()
+	}
+	response := p.addInstances(request, opaque)
+	p.inflight.end(topic, requestId, inflight, response)
+	return response
+}
+
+func (p *Projector) addInstances(
+	request *protobuf.AddInstancesRequest, opaque uint16) ap.MessageMarshaller {
+
 	topic := request.GetTopic()
 
 	// log this request.
@@ -537,6 +621,7 @@ func (p *Projector) doAddInstances(
 	if err != nil {
 		response.SetErr(err)
 	}
+	p.topicStore.addInstances(request)
 	return response
 }
 
@@ -560,6 +645,7 @@ func (p *Projector) doDelInstances(
 	}
 
 	err = feed.DelInstances(request, opaque)
+	p.topicStore.delInstances(request)
 	return protobuf.NewError(err)
 }
 
@@ -608,10 +694,34 @@ func (p *Projector) doShutdownTopic(
 	}
 
 	p.DelFeed(topic)
+	p.topicStore.deleteTopic(topic)
 	err = feed.Shutdown(opaque)
 	return protobuf.NewError(err)
 }
 
+// - return ErrorTopicMissing if feed is not started.
+// - otherwise, error is empty string.
+func (p *Projector) doAckTimestamps(
+	request *protobuf.AckTimestampsRequest,
+	opaque uint16) ap.MessageMarshaller {
+
+	topic, endpoint := request.GetTopic(), request.GetEndpoint()
+
+	// log this request, acks are periodic so log at debug level.
+	prefix := p.logPrefix
+	logging.Debugf("%v ##%x doAckTimestamps() %q %q\n", prefix, opaque, topic, endpoint)
+
+	feed, err := p.acquireFeed(topic)
+	defer p.releaseFeed(topic)
+	if err != nil {
+		logging.Errorf("%v ##%x acquireFeed(): %v\n", prefix, opaque, err)
+		return protobuf.NewError(err)
+	}
+
+	err = feed.AckTimestamps(request)
+	return protobuf.NewError(err)
+}
+
 func (p *Projector) doStatistics() interface{} {
 	logging.Infof("%v doStatistics()\n", p.logPrefix)
 	defer logging.Infof("%v doStatistics() returns ...\n", p.logPrefix)
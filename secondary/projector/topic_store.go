@@ -0,0 +1,313 @@
+package projector
+
+import "io/ioutil"
+import "net/url"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/logging"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+import "github.com/golang/protobuf/proto"
+
+const topicFileExt = ".topic"
+
+// topicStore persists topic definitions, one file per topic under dir,
+// so that a restarted projector can re-open its topics. A definition is
+// saved as MutationTopicRequest, with the list of buckets and instances
+// active on the topic, and the last known timestamp for its vbuckets.
+//
+// All methods are no-op on a nil topicStore, which is the case when
+// topic recovery is disabled.
+type topicStore struct {
+	mu        sync.Mutex
+	dir       string
+	topics    map[string]*protobuf.MutationTopicRequest
+	logPrefix string
+}
+
+func newTopicStore(dir, logPrefix string) (*topicStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ts := &topicStore{
+		dir:       dir,
+		topics:    make(map[string]*protobuf.MutationTopicRequest),
+		logPrefix: logPrefix,
+	}
+	if err := ts.load(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// load topic definitions persisted by the previous projector.
+func (ts *topicStore) load() error {
+	files, err := ioutil.ReadDir(ts.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), topicFileExt) {
+			continue
+		}
+		filename := filepath.Join(ts.dir, fi.Name())
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		def := &protobuf.MutationTopicRequest{}
+		if err := proto.Unmarshal(data, def); err != nil {
+			fmsg := "%v ignoring corrupt topic file %q: %v\n"
+			logging.Errorf(fmsg, ts.logPrefix, filename, err)
+			continue
+		}
+		ts.topics[def.GetTopic()] = def
+	}
+	return nil
+}
+
+// definitions return a copy of persisted topic definitions.
+func (ts *topicStore) definitions() []*protobuf.MutationTopicRequest {
+	if ts == nil {
+		return nil
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	defs := make([]*protobuf.MutationTopicRequest, 0, len(ts.topics))
+	for _, def := range ts.topics {
+		defs = append(defs, proto.Clone(def).(*protobuf.MutationTopicRequest))
+	}
+	return defs
+}
+
+// mutationTopic creates topic definition, or merges the request with
+// an existing definition.
+func (ts *topicStore) mutationTopic(req *protobuf.MutationTopicRequest) {
+	if ts == nil {
+		return
+	}
+	ts.update(req.GetTopic(), true /*create*/, func(def *protobuf.MutationTopicRequest) {
+		def.EndpointType = proto.String(req.GetEndpointType())
+		def.Version = req.Version
+		def.EndpointFeatures = req.EndpointFeatures
+		def.ReqTimestamps = unionTimestamps(def.ReqTimestamps, req.GetReqTimestamps())
+		def.Instances = unionInstances(def.Instances, req.GetInstances())
+	})
+}
+
+func (ts *topicStore) restartVbuckets(req *protobuf.RestartVbucketsRequest) {
+	if ts == nil {
+		return
+	}
+	ts.update(req.GetTopic(), false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		def.ReqTimestamps = unionTimestamps(def.ReqTimestamps, req.GetRestartTimestamps())
+	})
+}
+
+func (ts *topicStore) shutdownVbuckets(req *protobuf.ShutdownVbucketsRequest) {
+	if ts == nil {
+		return
+	}
+	ts.update(req.GetTopic(), false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		for _, shutTs := range req.GetShutdownTimestamps() {
+			for i, reqTs := range def.ReqTimestamps {
+				if reqTs.GetBucket() == shutTs.GetBucket() {
+					vbnos := c.Vbno32to16(shutTs.GetVbnos())
+					def.ReqTimestamps[i] = reqTs.FilterByVbuckets(vbnos)
+				}
+			}
+		}
+	})
+}
+
+func (ts *topicStore) addBuckets(req *protobuf.AddBucketsRequest) {
+	if ts == nil {
+		return
+	}
+	ts.update(req.GetTopic(), false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		def.ReqTimestamps = unionTimestamps(def.ReqTimestamps, req.GetReqTimestamps())
+		def.Instances = unionInstances(def.Instances, req.GetInstances())
+	})
+}
+
+func (ts *topicStore) delBuckets(req *protobuf.DelBucketsRequest) {
+	if ts == nil {
+		return
+	}
+	buckets := make(map[string]bool)
+	for _, bucketn := range req.GetBuckets() {
+		buckets[bucketn] = true
+	}
+	ts.update(req.GetTopic(), false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		reqTss := make([]*protobuf.TsVbuuid, 0, len(def.ReqTimestamps))
+		for _, reqTs := range def.ReqTimestamps {
+			if !buckets[reqTs.GetBucket()] {
+				reqTss = append(reqTss, reqTs)
+			}
+		}
+		instances := make([]*protobuf.Instance, 0, len(def.Instances))
+		for _, instance := range def.Instances {
+			if !buckets[instance.GetBucket()] {
+				instances = append(instances, instance)
+			}
+		}
+		def.ReqTimestamps, def.Instances = reqTss, instances
+	})
+}
+
+func (ts *topicStore) addInstances(req *protobuf.AddInstancesRequest) {
+	if ts == nil {
+		return
+	}
+	ts.update(req.GetTopic(), false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		def.Instances = unionInstances(def.Instances, req.GetInstances())
+	})
+}
+
+func (ts *topicStore) delInstances(req *protobuf.DelInstancesRequest) {
+	if ts == nil {
+		return
+	}
+	uuids := make(map[uint64]bool)
+	for _, uuid := range req.GetInstanceIds() {
+		uuids[uuid] = true
+	}
+	ts.update(req.GetTopic(), false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		instances := make([]*protobuf.Instance, 0, len(def.Instances))
+		for _, instance := range def.Instances {
+			if !uuids[instance.GetUuid()] {
+				instances = append(instances, instance)
+			}
+		}
+		def.Instances = instances
+	})
+}
+
+// checkpoint vbucket seqnos acknowledged by the endpoints of topic's
+// feed, vbuckets that are not part of topic definition are ignored. A
+// vbucket is checkpointed only forward on the same branch.
+func (ts *topicStore) checkpoint(topic string, curTss []*protobuf.TsVbuuid) {
+	if ts == nil {
+		return
+	}
+	ts.update(topic, false /*create*/, func(def *protobuf.MutationTopicRequest) {
+		for _, curTs := range curTss {
+			for _, reqTs := range def.ReqTimestamps {
+				if reqTs.GetBucket() != curTs.GetBucket() {
+					continue
+				}
+				for _, vbno32 := range reqTs.GetVbnos() {
+					vbno := uint16(vbno32)
+					seqno, vbuuid, s, e, err := curTs.Get(vbno)
+					if err != nil {
+						continue
+					}
+					oldSeqno, oldVbuuid, _, _, _ := reqTs.Get(vbno)
+					if vbuuid != oldVbuuid || seqno > oldSeqno {
+						reqTs.Set(vbno, seqno, vbuuid, s, e)
+					}
+				}
+			}
+		}
+	})
+}
+
+// deleteTopic forgets the topic definition.
+func (ts *topicStore) deleteTopic(topic string) {
+	if ts == nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.topics[topic]; !ok {
+		return
+	}
+	delete(ts.topics, topic)
+	if err := os.Remove(ts.topicFile(topic)); err != nil && !os.IsNotExist(err) {
+		fmsg := "%v failed removing topic %q: %v\n"
+		logging.Errorf(fmsg, ts.logPrefix, topic, err)
+	}
+}
+
+// update topic definition with fn and persist the definition. If topic
+// definition is not present, it is created only when `create` is true.
+func (ts *topicStore) update(
+	topic string, create bool, fn func(def *protobuf.MutationTopicRequest)) {
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	def, ok := ts.topics[topic]
+	if !ok && !create {
+		return
+	} else if !ok {
+		def = &protobuf.MutationTopicRequest{Topic: proto.String(topic)}
+	}
+	def = proto.Clone(def).(*protobuf.MutationTopicRequest)
+	fn(def)
+	if err := ts.save(def); err != nil {
+		fmsg := "%v failed saving topic %q: %v\n"
+		logging.Errorf(fmsg, ts.logPrefix, topic, err)
+		return
+	}
+	ts.topics[topic] = def
+}
+
+// save topic definition atomically, by writing to a temporary file and
+// renaming it.
+func (ts *topicStore) save(def *protobuf.MutationTopicRequest) error {
+	data, err := proto.Marshal(def)
+	if err != nil {
+		return err
+	}
+	filename := ts.topicFile(def.GetTopic())
+	tmpfile := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpfile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpfile, filename)
+}
+
+func (ts *topicStore) topicFile(topic string) string {
+	return filepath.Join(ts.dir, url.QueryEscape(topic)+topicFileExt)
+}
+
+// unionTimestamps merges timestamps bucket-wise, for vbuckets present
+// in both, entries from `others` are picked.
+func unionTimestamps(tss, others []*protobuf.TsVbuuid) []*protobuf.TsVbuuid {
+	rv := make([]*protobuf.TsVbuuid, 0, len(tss)+len(others))
+	rv = append(rv, tss...)
+	for _, other := range others {
+		found := false
+		for i, ts := range rv {
+			if ts.GetBucket() == other.GetBucket() {
+				rv[i], found = ts.Union(other), true
+				break
+			}
+		}
+		if !found {
+			rv = append(rv, other.Clone())
+		}
+	}
+	return rv
+}
+
+// unionInstances merges instances by their uuid, instances in `others`
+// replace instances with same uuid.
+func unionInstances(instances, others []*protobuf.Instance) []*protobuf.Instance {
+	rv := make([]*protobuf.Instance, 0, len(instances)+len(others))
+	uuids := make(map[uint64]bool)
+	for _, instance := range others {
+		uuids[instance.GetUuid()] = true
+	}
+	for _, instance := range instances {
+		if !uuids[instance.GetUuid()] {
+			rv = append(rv, instance)
+		}
+	}
+	return append(rv, others...)
+}
@@ -0,0 +1,141 @@
+package projector
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func testTopicTs(bucket string, vbnos []uint16, seqnos []uint64, vbuuid uint64) *protobuf.TsVbuuid {
+	ts := protobuf.NewTsVbuuid("default", bucket, len(vbnos))
+	for i, vbno := range vbnos {
+		ts.Append(vbno, seqnos[i], vbuuid, seqnos[i], seqnos[i])
+	}
+	return ts
+}
+
+func newTestTopicStore(t *testing.T) (*topicStore, string) {
+	dir, err := ioutil.TempDir("", "topicstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := newTopicStore(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, dir
+}
+
+func TestTopicStoreRecovery(t *testing.T) {
+	ts, dir := newTestTopicStore(t)
+	defer os.RemoveAll(dir)
+
+	req := protobuf.NewMutationTopicRequest("MAINT_STREAM_TOPIC_n1", "dataport", nil)
+	req.ReqTimestamps = []*protobuf.TsVbuuid{
+		testTopicTs("default", []uint16{0, 1, 2}, []uint64{10, 10, 10}, 100),
+	}
+	ts.mutationTopic(req)
+
+	// checkpoint only advances the vbuckets acknowledged downstream.
+	ts.checkpoint("MAINT_STREAM_TOPIC_n1", []*protobuf.TsVbuuid{
+		testTopicTs("default", []uint16{0, 1}, []uint64{20, 5}, 100),
+	})
+
+	shut := protobuf.NewShutdownVbucketsRequest("MAINT_STREAM_TOPIC_n1")
+	shut.ShutdownTimestamps = []*protobuf.TsVbuuid{
+		testTopicTs("default", []uint16{2}, []uint64{10}, 100),
+	}
+	ts.shutdownVbuckets(shut)
+
+	// corrupt topic files are skipped on recovery.
+	ioutil.WriteFile(filepath.Join(dir, "bad"+topicFileExt), []byte("bad"), 0644)
+
+	// a restarted projector recovers the topic.
+	recovered, err := newTopicStore(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs := recovered.definitions()
+	if len(defs) != 1 || defs[0].GetTopic() != "MAINT_STREAM_TOPIC_n1" {
+		t.Fatalf("unexpected definitions %v", defs)
+	}
+	if defs[0].GetEndpointFeatures() != protobuf.EndpointFeatures {
+		t.Errorf("unexpected endpoint features %v", defs[0].GetEndpointFeatures())
+	}
+	reqTss := defs[0].GetReqTimestamps()
+	if len(reqTss) != 1 {
+		t.Fatalf("unexpected timestamps %v", reqTss)
+	}
+	expected := map[uint16]uint64{0: 20, 1: 10}
+	if len(reqTss[0].GetVbnos()) != len(expected) {
+		t.Errorf("unexpected vbuckets %v", reqTss[0].GetVbnos())
+	}
+	for vbno, seqno := range expected {
+		if s, _, _, _, err := reqTss[0].Get(vbno); err != nil || s != seqno {
+			t.Errorf("vbucket %v: expected seqno %v, got %v %v", vbno, seqno, s, err)
+		}
+	}
+
+	recovered.deleteTopic("MAINT_STREAM_TOPIC_n1")
+	if recovered, err = newTopicStore(dir, "test"); err != nil {
+		t.Fatal(err)
+	} else if defs := recovered.definitions(); len(defs) != 0 {
+		t.Errorf("expected deleted topic not to be recovered, got %v", defs)
+	}
+}
+
+func TestTopicStoreCheckpointBranch(t *testing.T) {
+	ts, dir := newTestTopicStore(t)
+	defer os.RemoveAll(dir)
+
+	req := protobuf.NewMutationTopicRequest("topic", "dataport", nil)
+	req.ReqTimestamps = []*protobuf.TsVbuuid{
+		testTopicTs("default", []uint16{0}, []uint64{10}, 100),
+	}
+	ts.mutationTopic(req)
+
+	// a new branch is checkpointed even at a lower seqno.
+	ts.checkpoint("topic", []*protobuf.TsVbuuid{
+		testTopicTs("default", []uint16{0}, []uint64{5}, 200),
+	})
+	seqno, vbuuid, _, _, _ := ts.definitions()[0].GetReqTimestamps()[0].Get(0)
+	if seqno != 5 || vbuuid != 200 {
+		t.Errorf("expected seqno 5 vbuuid 200, got %v %v", seqno, vbuuid)
+	}
+
+	// unknown topics are not created by checkpoint.
+	ts.checkpoint("other", req.ReqTimestamps)
+	if defs := ts.definitions(); len(defs) != 1 {
+		t.Errorf("unexpected definitions %v", defs)
+	}
+}
+
+func TestMinAckedTimestamps(t *testing.T) {
+	curTss := map[string]*protobuf.TsVbuuid{
+		"default": testTopicTs("default", []uint16{0, 1, 2}, []uint64{50, 50, 50}, 100),
+		"other":   testTopicTs("other", []uint16{0}, []uint64{50}, 100),
+	}
+	raddrs := map[string]map[string]bool{
+		"default": {"n1:9100": true, "n2:9100": true},
+	}
+	ack1, ack2 := newEndpointAck(time.Now()), newEndpointAck(time.Now())
+	ack1.update(testTopicTs("default", []uint16{0, 1, 2}, []uint64{40, 30, 20}, 100))
+	ack2.update(testTopicTs("default", []uint16{0, 1}, []uint64{35, 45}, 100))
+	acks := map[string]*endpointAck{"n1:9100": ack1, "n2:9100": ack2}
+
+	tss := minAckedTimestamps(curTss, raddrs, acks)
+	if len(tss) != 1 || tss[0].GetBucket() != "default" {
+		t.Fatalf("unexpected timestamps %v", tss)
+	}
+	// vbucket 2 is not acknowledged by n2.
+	if vbnos := tss[0].GetVbnos(); len(vbnos) != 2 {
+		t.Fatalf("unexpected vbuckets %v", vbnos)
+	}
+	for vbno, expected := range map[uint16]uint64{0: 35, 1: 30} {
+		if seqno, vbuuid, _, _, _ := tss[0].Get(vbno); seqno != expected || vbuuid != 100 {
+			t.Errorf("vbucket %v: expected seqno %v, got %v", vbno, expected, seqno)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package projector
 import "time"
 
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/logging"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 import "github.com/golang/protobuf/proto"
 
@@ -33,3 +34,64 @@ func (p *Projector) watcherDameon(watchInterval, staleTimeout int) {
 		}
 	}
 }
+
+// re-open topics persisted by the previous projector instance, topics
+// that are already opened by the time of recovery are skipped.
+func (p *Projector) recoverTopics() {
+	for _, def := range p.topicStore.definitions() {
+		topic := def.GetTopic()
+		if _, err := p.GetFeed(topic); err == nil {
+			continue
+		} else if len(def.GetReqTimestamps()) == 0 {
+			p.topicStore.deleteTopic(topic)
+			continue
+		}
+		fmsg := "%v recovering topic %q with buckets %v\n"
+		logging.Infof(fmsg, p.logPrefix, topic, topicBuckets(def))
+		resp := p.doMutationTopic(def, 0xFFFD).(*protobuf.TopicResponse)
+		if err := resp.GetErr(); err != nil {
+			fmsg := "%v recovering topic %q: %v\n"
+			logging.Errorf(fmsg, p.logPrefix, topic, err.GetError())
+		}
+	}
+}
+
+// periodically save the seqnos acknowledged by the endpoints of each
+// feed, so that recovered topics restart close to where they left off,
+// without skipping mutations not yet applied downstream. Topics whose
+// feed has exited are forgotten.
+func (p *Projector) checkpointDaemon(checkpointInterval int) {
+	if p.topicStore == nil {
+		return
+	}
+
+	tick := time.NewTicker(time.Duration(checkpointInterval) * time.Millisecond)
+	defer func() {
+		tick.Stop()
+	}()
+
+	for {
+		<-tick.C
+		for _, def := range p.topicStore.definitions() {
+			topic := def.GetTopic()
+			feed, err := p.GetFeed(topic)
+			if err != nil {
+				p.topicStore.deleteTopic(topic)
+				continue
+			}
+			tss, err := feed.GetAckedTimestamps()
+			if err != nil {
+				continue
+			}
+			p.topicStore.checkpoint(topic, tss)
+		}
+	}
+}
+
+func topicBuckets(def *protobuf.MutationTopicRequest) []string {
+	buckets := make([]string, 0, len(def.GetReqTimestamps()))
+	for _, ts := range def.GetReqTimestamps() {
+		buckets = append(buckets, ts.GetBucket())
+	}
+	return buckets
+}
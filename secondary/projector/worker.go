@@ -42,6 +42,7 @@ type VbucketWorker struct {
 	// evaluators and subscribers
 	engines   map[uint64]*Engine
 	endpoints map[string]c.RouterEndpoint
+	evalPool  *EvaluatorPool
 	// server channels
 	reqch chan []interface{}
 	finch chan bool
@@ -71,6 +72,7 @@ func NewVbucketWorker(
 		vbuckets:  make(map[uint16]*Vbucket),
 		engines:   make(map[uint64]*Engine),
 		endpoints: make(map[string]c.RouterEndpoint),
+		evalPool:  feed.evalPool,
 		reqch:     make(chan []interface{}, mutChanSize),
 		finch:     make(chan bool),
 		encodeBuf: make([]byte, 0, encodeBufSize),
@@ -386,21 +388,30 @@ func (worker *VbucketWorker) handleEvent(m *mc.DcpEvent) *Vbucket {
 		dataForEndpoints := make(map[string]interface{})
 		// for each engine distribute transformations to endpoints.
 		fmsg := "%v ##%x TransformRoute: %v\n"
-		nvalue := qvalue.NewParsedValueWithOptions(m.Value, true, true)
-		context := qexpr.NewIndexContext()
-		docval := qvalue.NewAnnotatedValue(nvalue)
-		for _, engine := range worker.engines {
-			newBuf, err := engine.TransformRoute(
-				v.vbuuid, m, dataForEndpoints, worker.encodeBuf, docval, context,
-			)
-			if err != nil {
-				logging.Errorf(fmsg, logPrefix, m.Opaque, err)
-			}
-			// TODO: Shrink the buffer periodically or as needed
-			if cap(newBuf) > cap(worker.encodeBuf) {
-				worker.encodeBuf = newBuf[:0]
+		evaluate := func() {
+			nvalue := qvalue.NewParsedValueWithOptions(m.Value, true, true)
+			context := qexpr.NewIndexContext()
+			docval := qvalue.NewAnnotatedValue(nvalue)
+			for _, engine := range worker.engines {
+				newBuf, err := engine.TransformRoute(
+					v.vbuuid, m, dataForEndpoints, worker.encodeBuf, docval, context,
+				)
+				if err != nil {
+					logging.Errorf(fmsg, logPrefix, m.Opaque, err)
+				}
+				// TODO: Shrink the buffer periodically or as needed
+				if cap(newBuf) > cap(worker.encodeBuf) {
+					worker.encodeBuf = newBuf[:0]
+				}
 			}
 		}
+		if worker.evalPool != nil {
+			// worker is blocked till evaluation is done, hence
+			// engines and encodeBuf are not accessed concurrently.
+			worker.evalPool.Evaluate(worker.bucket, evaluate)
+		} else {
+			evaluate()
+		}
 		// send data to corresponding endpoint.
 		for raddr, data := range dataForEndpoints {
 			if endpoint, ok := worker.endpoints[raddr]; ok {
@@ -0,0 +1,311 @@
+package protobuf
+
+import "encoding/binary"
+import "errors"
+
+// ErrorDecode is returned when a payload cannot be decoded.
+var ErrorDecode = errors.New("protobuf.decode")
+
+// protobuf wire types used by Payload.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// UnmarshalNoCopy decodes data into the payload without copying the
+// bytes fields. Docid, keys, oldkeys and partnkeys of the decoded
+// KeyVersions refer to data, which must not be modified or reused till
+// the payload is no more in use. Repeated fields of all KeyVersions
+// share the same backing arrays, which avoids per key allocations.
+func (pl *Payload) UnmarshalNoCopy(data []byte) error {
+	d := &decoder{}
+	return d.payload(data, pl)
+}
+
+// decoder carves repeated fields out of shared arrays. Repeated fields
+// that are absent are left nil, as they are by proto.Unmarshal().
+type decoder struct {
+	kvs    []KeyVersions
+	uint64 []uint64
+	uint32 []uint32
+	bytes  [][]byte
+}
+
+const decoderChunk = 1024
+
+func (d *decoder) allocKvs(n int) []KeyVersions {
+	if n == 0 {
+		return nil
+	}
+	if cap(d.kvs)-len(d.kvs) < n {
+		d.kvs = make([]KeyVersions, 0, maxInt(n, decoderChunk/16))
+	}
+	l := len(d.kvs)
+	d.kvs = d.kvs[:l+n]
+	return d.kvs[l : l+n : l+n]
+}
+
+func (d *decoder) allocUint64(n int) []uint64 {
+	if n == 0 {
+		return nil
+	}
+	if cap(d.uint64)-len(d.uint64) < n {
+		d.uint64 = make([]uint64, 0, maxInt(n, decoderChunk))
+	}
+	l := len(d.uint64)
+	d.uint64 = d.uint64[:l+n]
+	return d.uint64[l : l : l+n]
+}
+
+func (d *decoder) allocUint32(n int) []uint32 {
+	if n == 0 {
+		return nil
+	}
+	if cap(d.uint32)-len(d.uint32) < n {
+		d.uint32 = make([]uint32, 0, maxInt(n, decoderChunk))
+	}
+	l := len(d.uint32)
+	d.uint32 = d.uint32[:l+n]
+	return d.uint32[l : l : l+n]
+}
+
+func (d *decoder) allocBytes(n int) [][]byte {
+	if n == 0 {
+		return nil
+	}
+	if cap(d.bytes)-len(d.bytes) < n {
+		d.bytes = make([][]byte, 0, maxInt(n, decoderChunk))
+	}
+	l := len(d.bytes)
+	d.bytes = d.bytes[:l+n]
+	return d.bytes[l : l : l+n]
+}
+
+func (d *decoder) payload(data []byte, pl *Payload) error {
+	var vbs [][]byte
+
+	err := fields(data, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			version := uint32(v)
+			pl.Version = &version
+		case num == 2 && typ == wireBytes:
+			vbs = append(vbs, b)
+		case num == 3 && typ == wireBytes:
+			pl.Vbmap = &VbConnectionMap{}
+			return d.vbmap(b, pl.Vbmap)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(vbs) > 0 {
+		pl.Vbkeys = make([]*VbKeyVersions, len(vbs))
+		for i, b := range vbs {
+			pl.Vbkeys[i] = &VbKeyVersions{}
+			if err := d.vbKeyVersions(b, pl.Vbkeys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *decoder) vbmap(data []byte, vbmap *VbConnectionMap) error {
+	return fields(data, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wireBytes:
+			bucket := string(b)
+			vbmap.Bucket = &bucket
+		case num == 3 && typ == wireVarint:
+			vbmap.Vbuckets = append(vbmap.Vbuckets, uint32(v))
+		case num == 3 && typ == wireBytes:
+			return packed(b, func(v uint64) { vbmap.Vbuckets = append(vbmap.Vbuckets, uint32(v)) })
+		case num == 4 && typ == wireVarint:
+			vbmap.Vbuuids = append(vbmap.Vbuuids, v)
+		case num == 4 && typ == wireBytes:
+			return packed(b, func(v uint64) { vbmap.Vbuuids = append(vbmap.Vbuuids, v) })
+		}
+		return nil
+	})
+}
+
+func (d *decoder) vbKeyVersions(data []byte, vb *VbKeyVersions) error {
+	var n int
+	err := fields(data, func(num, typ int, v uint64, b []byte) error {
+		if num == 5 && typ == wireBytes {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kvs := d.allocKvs(n)
+	if n > 0 {
+		vb.Kvs = make([]*KeyVersions, 0, n)
+	}
+
+	return fields(data, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 2 && typ == wireVarint:
+			vbno := uint32(v)
+			vb.Vbucket = &vbno
+		case num == 3 && typ == wireVarint:
+			vb.Vbuuid = &v
+		case num == 4 && typ == wireBytes:
+			bucket := string(b)
+			vb.Bucketname = &bucket
+		case num == 5 && typ == wireBytes:
+			kv := &kvs[len(vb.Kvs)]
+			vb.Kvs = append(vb.Kvs, kv)
+			return d.keyVersions(b, kv)
+		case num == 6 && typ == wireVarint:
+			vb.ProjVer = ProjectorVersion(int32(v)).Enum()
+		}
+		return nil
+	})
+}
+
+func (d *decoder) keyVersions(data []byte, kv *KeyVersions) error {
+	// Count the repeated fields first, so that they can be carved out
+	// of the shared arrays.
+	var nuuids, ncommands, nkeys, noldkeys, npartnkeys int
+	err := fields(data, func(num, typ int, v uint64, b []byte) error {
+		switch num {
+		case 3:
+			nuuids += countValues(typ, b)
+		case 4:
+			ncommands += countValues(typ, b)
+		case 5:
+			nkeys++
+		case 6:
+			noldkeys++
+		case 7:
+			npartnkeys++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kv.Uuids = d.allocUint64(nuuids)
+	kv.Commands = d.allocUint32(ncommands)
+	kv.Keys = d.allocBytes(nkeys)
+	kv.Oldkeys = d.allocBytes(noldkeys)
+	kv.Partnkeys = d.allocBytes(npartnkeys)
+
+	return fields(data, func(num, typ int, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			seqno := v
+			kv.Seqno = &seqno
+		case num == 2 && typ == wireBytes:
+			kv.Docid = b
+		case num == 3 && typ == wireVarint:
+			kv.Uuids = append(kv.Uuids, v)
+		case num == 3 && typ == wireBytes:
+			return packed(b, func(v uint64) { kv.Uuids = append(kv.Uuids, v) })
+		case num == 4 && typ == wireVarint:
+			kv.Commands = append(kv.Commands, uint32(v))
+		case num == 4 && typ == wireBytes:
+			return packed(b, func(v uint64) { kv.Commands = append(kv.Commands, uint32(v)) })
+		case num == 5 && typ == wireBytes:
+			kv.Keys = append(kv.Keys, b)
+		case num == 6 && typ == wireBytes:
+			kv.Oldkeys = append(kv.Oldkeys, b)
+		case num == 7 && typ == wireBytes:
+			kv.Partnkeys = append(kv.Partnkeys, b)
+		}
+		return nil
+	})
+}
+
+// fields calls fn for every field of the message in data. v is the
+// value of varint and fixed fields, b the value of length delimited
+// fields.
+func fields(data []byte, fn func(num, typ int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrorDecode
+		}
+		data = data[n:]
+
+		num, typ := int(key>>3), int(key&0x7)
+		var v uint64
+		var b []byte
+
+		switch typ {
+		case wireVarint:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return ErrorDecode
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return ErrorDecode
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return ErrorDecode
+			}
+			b, data = data[n:n+int(l):n+int(l)], data[n+int(l):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return ErrorDecode
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return ErrorDecode
+		}
+
+		if err := fn(num, typ, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packed calls fn for every varint of a packed repeated field.
+func packed(data []byte, fn func(v uint64)) error {
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrorDecode
+		}
+		fn(v)
+		data = data[n:]
+	}
+	return nil
+}
+
+// countValues returns the number of values of a, possibly packed,
+// repeated varint field.
+func countValues(typ int, b []byte) int {
+	if typ != wireBytes {
+		return 1
+	}
+	n := 0
+	for _, c := range b {
+		if c < 0x80 {
+			n++
+		}
+	}
+	return n
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,169 @@
+package protobuf
+
+import "bytes"
+import "reflect"
+import "testing"
+
+import "github.com/golang/protobuf/proto"
+
+func testPayloadKeyVersions() *Payload {
+	kv1 := &KeyVersions{
+		Seqno:     proto.Uint64(10),
+		Docid:     []byte("doc1"),
+		Uuids:     []uint64{1, 2, 1 << 40},
+		Commands:  []uint32{1, 2, 3},
+		Keys:      [][]byte{[]byte(`["a"]`), []byte(`["b"]`), []byte{}},
+		Oldkeys:   [][]byte{[]byte(`["x"]`), []byte{}, []byte(`["z"]`)},
+		Partnkeys: [][]byte{[]byte("p1"), []byte("p2"), []byte("p3")},
+	}
+	kv2 := &KeyVersions{ // no repeated fields
+		Seqno: proto.Uint64(11),
+		Docid: []byte("doc2"),
+	}
+	kv3 := &KeyVersions{
+		Seqno:    proto.Uint64(12),
+		Docid:    []byte{},
+		Uuids:    []uint64{7},
+		Commands: []uint32{5},
+		Keys:     [][]byte{[]byte(`[1,2,3]`)},
+	}
+	return &Payload{
+		Version: proto.Uint32(1),
+		Vbkeys: []*VbKeyVersions{
+			&VbKeyVersions{
+				Vbucket:    proto.Uint32(1),
+				Vbuuid:     proto.Uint64(1234),
+				Bucketname: proto.String("default"),
+				Kvs:        []*KeyVersions{kv1, kv2},
+				ProjVer:    ProjectorVersion_V5_1_0.Enum(),
+			},
+			&VbKeyVersions{ // no key versions
+				Vbucket:    proto.Uint32(2),
+				Vbuuid:     proto.Uint64(5678),
+				Bucketname: proto.String("default"),
+			},
+			&VbKeyVersions{
+				Vbucket:    proto.Uint32(1023),
+				Vbuuid:     proto.Uint64(1 << 63),
+				Bucketname: proto.String("beer-sample"),
+				Kvs:        []*KeyVersions{kv3},
+			},
+		},
+	}
+}
+
+func testPayloadVbmap() *Payload {
+	return &Payload{
+		Version: proto.Uint32(1),
+		Vbmap: &VbConnectionMap{
+			Bucket:   proto.String("default"),
+			Vbuckets: []uint32{0, 1, 1023},
+			Vbuuids:  []uint64{11, 12, 1 << 62},
+		},
+	}
+}
+
+func TestUnmarshalNoCopyEquivalence(t *testing.T) {
+	for i, pl := range []*Payload{testPayloadKeyVersions(), testPayloadVbmap()} {
+		data, err := proto.Marshal(pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := &Payload{}
+		if err := proto.Unmarshal(data, expected); err != nil {
+			t.Fatal(err)
+		}
+		actual := &Payload{}
+		if err := actual.UnmarshalNoCopy(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("payload %d: expected %v, got %v", i, expected, actual)
+		}
+	}
+}
+
+func TestUnmarshalNoCopyAliasing(t *testing.T) {
+	data, err := proto.Marshal(testPayloadKeyVersions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pl := &Payload{}
+	if err := pl.UnmarshalNoCopy(data); err != nil {
+		t.Fatal(err)
+	}
+	kv := pl.Vbkeys[0].Kvs[0]
+	for _, b := range append([][]byte{kv.Docid}, kv.Keys[0], kv.Oldkeys[0]) {
+		if !aliases(data, b) {
+			t.Errorf("expected %q to refer to the decoded buffer", b)
+		}
+	}
+
+	// appending to a decoded field must not overwrite the next field.
+	docid := append(kv.Docid, "xyz"...)
+	if string(kv.Docid) != "doc1" || string(docid) != "doc1xyz" {
+		t.Errorf("unexpected docid %q %q", kv.Docid, docid)
+	}
+	if string(kv.Keys[0]) != `["a"]` {
+		t.Errorf("unexpected key %q", kv.Keys[0])
+	}
+}
+
+func TestUnmarshalNoCopyPacked(t *testing.T) {
+	// field 3 (uuids) and field 4 (commands) as packed varints.
+	var buf proto.Buffer
+	buf.EncodeVarint(1<<3 | wireVarint)
+	buf.EncodeVarint(10)
+	buf.EncodeVarint(3<<3 | wireBytes)
+	buf.EncodeRawBytes(packedVarints(1, 300, 1<<40))
+	buf.EncodeVarint(4<<3 | wireBytes)
+	buf.EncodeRawBytes(packedVarints(1, 2, 3))
+
+	kv := &KeyVersions{}
+	if err := (&decoder{}).keyVersions(buf.Bytes(), kv); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kv.Uuids, []uint64{1, 300, 1 << 40}) {
+		t.Errorf("unexpected uuids %v", kv.Uuids)
+	}
+	if !reflect.DeepEqual(kv.Commands, []uint32{1, 2, 3}) {
+		t.Errorf("unexpected commands %v", kv.Commands)
+	}
+	if kv.GetSeqno() != 10 {
+		t.Errorf("unexpected seqno %v", kv.GetSeqno())
+	}
+}
+
+func TestUnmarshalNoCopyTruncated(t *testing.T) {
+	data, err := proto.Marshal(testPayloadKeyVersions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated payload must never panic.
+	for i := 1; i < len(data); i++ {
+		(&Payload{}).UnmarshalNoCopy(data[:i])
+	}
+	// and must fail when the last field is cut short.
+	if err := (&Payload{}).UnmarshalNoCopy(data[:len(data)-1]); err != ErrorDecode {
+		t.Errorf("expected %v, got %v", ErrorDecode, err)
+	}
+}
+
+func packedVarints(values ...uint64) []byte {
+	var buf proto.Buffer
+	for _, v := range values {
+		buf.EncodeVarint(v)
+	}
+	return buf.Bytes()
+}
+
+func aliases(data, b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	i := bytes.Index(data, b)
+	return i >= 0 && &data[i] == &b[0]
+}
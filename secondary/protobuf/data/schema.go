@@ -0,0 +1,14 @@
+package protobuf
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// Schema describes the messages exchanged on dataport, as compiled into
+// this binary.
+func Schema() *c.ProtoSchema {
+	version := (c.ProtobufDataPathMajorNum << 4) | c.ProtobufDataPathMinorNum
+	enums := map[string]map[string]int32{
+		"protobuf.Command":          Command_value,
+		"protobuf.ProjectorVersion": ProjectorVersion_value,
+	}
+	return c.NewProtoSchema("dataport", uint32(version), "protobuf", enums, &Payload{})
+}
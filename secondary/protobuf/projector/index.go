@@ -1,6 +1,8 @@
 package protobuf
 
 import "fmt"
+import "sync"
+import "sync/atomic"
 
 import "github.com/couchbase/indexing/secondary/logging"
 import c "github.com/couchbase/indexing/secondary/common"
@@ -97,13 +99,58 @@ func (instance *IndexInst) GetPartitionObject() Partition {
 // definition of an index instance.
 type IndexEvaluator struct {
 	skExprs  []interface{} // compiled expression
+	skPaths  []FieldPath   // secondary-key on plain paths, if so
 	pkExprs  []interface{} // compiled expression
 	whExpr   interface{}   // compiled expression
 	instance *IndexInst
 	version  FeedVersion
 	xattrs   []string
+	// stats, updated concurrently by vbucket workers.
+	evalErrors  uint64 // documents failing evaluation
+	skippedDocs uint64 // documents skipped on evaluation error
+	nullKeyDocs uint64 // documents indexed with NULL key on evaluation error
+	failedDocs  uint64 // documents recorded as failed on evaluation error
+	largeDocs   uint64 // documents over the size limit
+	largeKeys   uint64 // secondary keys over the size limit
+	// recent documents failing evaluation, for EVAL_FAIL policy.
+	mu       sync.Mutex
+	failures []evalFailure
 }
 
+// evalFailure records a document that failed evaluation.
+type evalFailure struct {
+	Vbno  uint16 `json:"vbno"`
+	Seqno uint64 `json:"seqno"`
+	Docid string `json:"docid"`
+	Err   string `json:"error"`
+}
+
+// number of recent evaluation failures recorded per index.
+const evalFailureLog = 16
+
+// size guardrails, shared by all evaluators. Documents and secondary
+// keys over the limits are skipped, or handled as evaluation errors as
+// per the index's policy. A limit of 0 disables it.
+var maxDocSize, maxKeySize int64
+var skipOversized int32
+
+// SetSizeGuardrails sets the limits on the size of documents evaluated
+// and of secondary keys. Policy "skip" skips oversized documents, policy
+// "error" handles them as evaluation errors.
+func SetSizeGuardrails(docSize, keySize int, policy string) {
+	atomic.StoreInt64(&maxDocSize, int64(docSize))
+	atomic.StoreInt64(&maxKeySize, int64(keySize))
+	skip := int32(0)
+	if policy == "skip" {
+		skip = 1
+	}
+	atomic.StoreInt32(&skipOversized, skip)
+}
+
+// log evaluation errors once for every evalErrorLogSample errors, so
+// that a bad expression does not flood the log.
+const evalErrorLogSample = 1000
+
 // NewIndexEvaluator returns a reference to a new instance
 // of IndexEvaluator.
 func NewIndexEvaluator(instance *IndexInst,
@@ -125,6 +172,15 @@ func NewIndexEvaluator(instance *IndexInst,
 		if err != nil {
 			return nil, err
 		}
+		// secondary-key on plain paths is evaluated without N1QL. Array
+		// index is always evaluated by N1QL, which explodes the array
+		// expression into one entry per element.
+		if defn.GetIsArrayIndex() {
+			ie.skPaths = nil
+		} else if ie.skPaths = CompileFieldPaths(ie.skExprs); ie.skPaths != nil {
+			logging.Infof("IndexEvaluator: evaluating %v on field paths %v\n",
+				instance.GetInstId(), ie.skPaths)
+		}
 		// expression to evaluate partition key
 		exprs = defn.GetPartnExpressions()
 		xattrExprs = append(xattrExprs, exprs...)
@@ -236,32 +292,45 @@ func (ie *IndexEvaluator) TransformRoute(
 
 	meta := ie.dcpEvent2Meta(m)
 	docval.SetAttachment("meta", meta)
-	where, err := ie.wherePredicate(m, docval, context, encodeBuf)
-	if err != nil {
-		return nil, err
+	where := false
+	if err = ie.checkDocSize(m.Value); err == nil {
+		where, err = ie.wherePredicate(m, docval, context, encodeBuf)
 	}
-
-	if where && (len(m.Value) > 0 || retainDelete) { // project new secondary key
+	if err == nil && where && (len(m.Value) > 0 || retainDelete) {
+		// project new secondary key
 		npkey, err = ie.partitionKey(m, m.Key, docval, context, encodeBuf)
-		if err != nil {
-			return nil, err
+		if err == nil {
+			var value []byte
+			if !retainDelete {
+				value = m.Value
+			}
+			nkey, newBuf, err = ie.evaluate(
+				m, m.Key, value, docval, context, encodeBuf)
 		}
-		nkey, newBuf, err = ie.evaluate(m, m.Key, docval, context, encodeBuf)
-		if err != nil {
-			return nil, err
+		if err == nil {
+			err = ie.checkKeySize(nkey)
 		}
 	}
-	if len(m.OldValue) > 0 { // project old secondary key
+	if err != nil && ie.skipOversized(err) {
+		// skip document, downstream shall remove its older entry.
+		where, npkey, nkey = false, nil, nil
+	} else if err != nil {
+		ie.evalError(m, err)
+		where, npkey, nkey = ie.errorKey(encodeBuf)
+	}
+	if len(m.OldValue) > 0 && !ie.isOversized(m.OldValue) {
+		// project old secondary key
 		nvalue := qvalue.NewParsedValueWithOptions(m.OldValue, true, true)
 		docval = qvalue.NewAnnotatedValue(nvalue)
 		docval.SetAttachment("meta", meta)
 		opkey, err = ie.partitionKey(m, m.Key, docval, context, encodeBuf)
-		if err != nil {
-			return nil, err
+		if err == nil {
+			okey, newBuf, err = ie.evaluate(
+				m, m.Key, m.OldValue, docval, context, encodeBuf)
 		}
-		okey, newBuf, err = ie.evaluate(m, m.Key, docval, context, encodeBuf)
-		if err != nil {
-			return nil, err
+		if err != nil { // downstream shall lookup the old key by docid.
+			ie.evalError(m, err)
+			opkey, okey = nil, nil
 		}
 	}
 
@@ -346,12 +415,18 @@ func (ie *IndexEvaluator) TransformRoute(
 	return newBuf, nil
 }
 
+// evaluate secondary-key of the document value, parsed as docval.
 func (ie *IndexEvaluator) evaluate(
-	m *mc.DcpEvent, docid []byte, docval qvalue.AnnotatedValue,
+	m *mc.DcpEvent, docid, value []byte, docval qvalue.AnnotatedValue,
 	context qexpr.Context, encodeBuf []byte) ([]byte, []byte, error) {
 
 	defn := ie.instance.GetDefinition()
-	if defn.GetIsPrimary() { // primary index supported !!
+	if defn.GetIsPrimary() && defn.GetDocMeta() {
+		// key of primary index is unused downstream, it carries document
+		// metadata instead.
+		meta := fmt.Sprintf("[%d,%d,%d]", m.Cas, m.Expiry, len(m.Value))
+		return []byte(meta), nil, nil
+	} else if defn.GetIsPrimary() { // primary index supported !!
 		return []byte(`["` + string(docid) + `"]`), nil, nil
 	}
 
@@ -362,7 +437,18 @@ func (ie *IndexEvaluator) evaluate(
 	exprType := defn.GetExprType()
 	switch exprType {
 	case ExprType_N1QL:
-		return N1QLTransform(docid, docval, context, ie.skExprs, encodeBuf)
+		includeMissing := defn.GetIncludeMissing()
+		if ie.skPaths != nil && encodeBuf != nil && value != nil {
+			out, newBuf, ok, err := PathEvaluate(
+				docid, value, ie.skPaths, encodeBuf, includeMissing)
+			if ok {
+				return out, newBuf, err
+			}
+		}
+		if includeMissing {
+			return N1QLEvaluateMissing(docid, docval, context, ie.skExprs, encodeBuf)
+		}
+		return N1QLEvaluate(docid, docval, context, ie.skExprs, encodeBuf)
 	}
 	return nil, nil, nil
 }
@@ -382,7 +468,7 @@ func (ie *IndexEvaluator) partitionKey(
 	exprType := defn.GetExprType()
 	switch exprType {
 	case ExprType_N1QL:
-		out, _, err := N1QLTransform(docid, docval, context, ie.pkExprs, nil)
+		out, _, err := N1QLEvaluate(docid, docval, context, ie.pkExprs, nil)
 		return out, err
 	}
 	return nil, nil
@@ -406,7 +492,7 @@ func (ie *IndexEvaluator) wherePredicate(
 	switch exprType {
 	case ExprType_N1QL:
 		// TODO: can be optimized by using a custom N1QL-evaluator.
-		out, _, err := N1QLTransform(nil, docval, context, []interface{}{ie.whExpr}, encodeBuf)
+		out, _, err := N1QLEvaluate(nil, docval, context, []interface{}{ie.whExpr}, encodeBuf)
 		if out == nil { // missing is treated as false
 			return false, err
 		} else if err != nil { // errors are treated as false
@@ -419,6 +505,121 @@ func (ie *IndexEvaluator) wherePredicate(
 	return true, nil
 }
 
+// EvalErrorStats implement Evaluator{} interface.
+func (ie *IndexEvaluator) EvalErrorStats() map[string]interface{} {
+	return map[string]interface{}{
+		"policy":       ie.instance.GetDefinition().GetEvalErrorPolicy().String(),
+		"errors":       float64(atomic.LoadUint64(&ie.evalErrors)),
+		"skipped":      float64(atomic.LoadUint64(&ie.skippedDocs)),
+		"nullKeys":     float64(atomic.LoadUint64(&ie.nullKeyDocs)),
+		"failedDocs":   float64(atomic.LoadUint64(&ie.failedDocs)),
+		"largeDocs":    float64(atomic.LoadUint64(&ie.largeDocs)),
+		"largeKeys":    float64(atomic.LoadUint64(&ie.largeKeys)),
+		"recentFailed": ie.recentFailures(),
+	}
+}
+
+// recentFailures returns the documents that recently failed evaluation,
+// oldest first.
+func (ie *IndexEvaluator) recentFailures() []evalFailure {
+	ie.mu.Lock()
+	defer ie.mu.Unlock()
+	failures := make([]evalFailure, len(ie.failures))
+	copy(failures, ie.failures)
+	return failures
+}
+
+// isOversized returns true if the document is over the size limit.
+func (ie *IndexEvaluator) isOversized(value []byte) bool {
+	limit := atomic.LoadInt64(&maxDocSize)
+	return limit > 0 && int64(len(value)) > limit
+}
+
+// checkDocSize returns ErrorDocumentTooLarge if the document is over
+// the size limit.
+func (ie *IndexEvaluator) checkDocSize(value []byte) error {
+	if ie.isOversized(value) {
+		atomic.AddUint64(&ie.largeDocs, 1)
+		return c.ErrorDocumentTooLarge
+	}
+	return nil
+}
+
+// checkKeySize returns ErrorKeyTooLarge if the secondary key is over
+// the size limit.
+func (ie *IndexEvaluator) checkKeySize(key []byte) error {
+	limit := atomic.LoadInt64(&maxKeySize)
+	if limit > 0 && int64(len(key)) > limit {
+		atomic.AddUint64(&ie.largeKeys, 1)
+		return c.ErrorKeyTooLarge
+	}
+	return nil
+}
+
+// skipOversized returns true if err is an oversized document or key,
+// to be skipped as per the guardrail policy.
+func (ie *IndexEvaluator) skipOversized(err error) bool {
+	if err != c.ErrorDocumentTooLarge && err != c.ErrorKeyTooLarge {
+		return false
+	} else if atomic.LoadInt32(&skipOversized) == 0 {
+		return false
+	}
+	atomic.AddUint64(&ie.skippedDocs, 1)
+	return true
+}
+
+// evalError accounts for a document failing evaluation. With EVAL_FAIL
+// policy the document is recorded as failed, and like EVAL_SKIP it is
+// not indexed. Failing the stream instead would only have the stream
+// restarted from the same document.
+func (ie *IndexEvaluator) evalError(m *mc.DcpEvent, err error) {
+	defn := ie.instance.GetDefinition()
+	policy := defn.GetEvalErrorPolicy()
+	if n := atomic.AddUint64(&ie.evalErrors, 1); n == 1 || n%evalErrorLogSample == 0 {
+		fmsg := "inst %v index %q policy %v: %v errors, vb %v seqno %v docid %v: %v\n"
+		arg1 := logging.TagUD(string(m.Key))
+		logging.Errorf(fmsg, ie.instance.GetInstId(), defn.GetName(), policy, n,
+			m.VBucket, m.Seqno, arg1, err)
+	}
+	if policy == EvalErrorPolicy_EVAL_FAIL {
+		atomic.AddUint64(&ie.failedDocs, 1)
+		failure := evalFailure{
+			Vbno: m.VBucket, Seqno: m.Seqno, Docid: string(m.Key), Err: err.Error(),
+		}
+		ie.mu.Lock()
+		if len(ie.failures) == evalFailureLog {
+			copy(ie.failures, ie.failures[1:])
+			ie.failures = ie.failures[:evalFailureLog-1]
+		}
+		ie.failures = append(ie.failures, failure)
+		ie.mu.Unlock()
+	}
+}
+
+// errorKey returns the where predicate, partition key and secondary key
+// for a document failing evaluation, as per the index's policy.
+func (ie *IndexEvaluator) errorKey(encodeBuf []byte) (bool, []byte, []byte) {
+	defn := ie.instance.GetDefinition()
+	if defn.GetEvalErrorPolicy() != EvalErrorPolicy_EVAL_INDEX_NULL {
+		// skip document, downstream shall remove its older entry.
+		atomic.AddUint64(&ie.skippedDocs, 1)
+		return false, nil, nil
+	}
+	atomic.AddUint64(&ie.nullKeyDocs, 1)
+	arrValue := make([]interface{}, len(ie.skExprs))
+	for i := range arrValue {
+		arrValue[i] = qvalue.NewNullValue()
+	}
+	val := qvalue.NewValue(arrValue)
+	if encodeBuf != nil {
+		if out, _, err := CollateJSONEncode(val, encodeBuf); err == nil {
+			return true, nil, out
+		}
+	}
+	out, _ := val.MarshalJSON()
+	return true, nil, out
+}
+
 // helper functions
 func (ie *IndexEvaluator) dcpEvent2Meta(m *mc.DcpEvent) map[string]interface{} {
 	// If index is defined on xattr (either where-expression, part-expression
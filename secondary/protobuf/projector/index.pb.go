@@ -209,6 +209,43 @@ func (x *HashScheme) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// What to index for documents failing expression evaluation.
+type EvalErrorPolicy int32
+
+const (
+	EvalErrorPolicy_EVAL_SKIP       EvalErrorPolicy = 0
+	EvalErrorPolicy_EVAL_INDEX_NULL EvalErrorPolicy = 1
+	EvalErrorPolicy_EVAL_FAIL       EvalErrorPolicy = 2
+)
+
+var EvalErrorPolicy_name = map[int32]string{
+	0: "EVAL_SKIP",
+	1: "EVAL_INDEX_NULL",
+	2: "EVAL_FAIL",
+}
+var EvalErrorPolicy_value = map[string]int32{
+	"EVAL_SKIP":       0,
+	"EVAL_INDEX_NULL": 1,
+	"EVAL_FAIL":       2,
+}
+
+func (x EvalErrorPolicy) Enum() *EvalErrorPolicy {
+	p := new(EvalErrorPolicy)
+	*p = x
+	return p
+}
+func (x EvalErrorPolicy) String() string {
+	return proto.EnumName(EvalErrorPolicy_name, int32(x))
+}
+func (x *EvalErrorPolicy) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(EvalErrorPolicy_value, data, "EvalErrorPolicy")
+	if err != nil {
+		return err
+	}
+	*x = EvalErrorPolicy(value)
+	return nil
+}
+
 // IndexInst message as payload between co-ordinator, projector, indexer.
 type IndexInst struct {
 	InstId           *uint64          `protobuf:"varint,1,req,name=instId" json:"instId,omitempty"`
@@ -281,6 +318,10 @@ type IndexDefn struct {
 	PartnExpressions   []string         `protobuf:"bytes,11,rep,name=partnExpressions" json:"partnExpressions,omitempty"`
 	RetainDeletedXATTR *bool            `protobuf:"varint,12,opt,name=retainDeletedXATTR" json:"retainDeletedXATTR,omitempty"`
 	HashScheme         *HashScheme      `protobuf:"varint,13,opt,name=hashScheme,enum=protobuf.HashScheme" json:"hashScheme,omitempty"`
+	EvalErrorPolicy    *EvalErrorPolicy `protobuf:"varint,14,opt,name=evalErrorPolicy,enum=protobuf.EvalErrorPolicy" json:"evalErrorPolicy,omitempty"`
+	DocMeta            *bool            `protobuf:"varint,15,opt,name=docMeta" json:"docMeta,omitempty"`
+	IncludeMissing     *bool            `protobuf:"varint,16,opt,name=includeMissing" json:"includeMissing,omitempty"`
+	IsArrayIndex       *bool            `protobuf:"varint,17,opt,name=isArrayIndex" json:"isArrayIndex,omitempty"`
 	XXX_unrecognized   []byte           `json:"-"`
 }
 
@@ -379,10 +420,39 @@ func (m *IndexDefn) GetHashScheme() HashScheme {
 	return HashScheme_CRC32
 }
 
+func (m *IndexDefn) GetEvalErrorPolicy() EvalErrorPolicy {
+	if m != nil && m.EvalErrorPolicy != nil {
+		return *m.EvalErrorPolicy
+	}
+	return EvalErrorPolicy_EVAL_SKIP
+}
+
+func (m *IndexDefn) GetDocMeta() bool {
+	if m != nil && m.DocMeta != nil {
+		return *m.DocMeta
+	}
+	return false
+}
+
+func (m *IndexDefn) GetIncludeMissing() bool {
+	if m != nil && m.IncludeMissing != nil {
+		return *m.IncludeMissing
+	}
+	return false
+}
+
+func (m *IndexDefn) GetIsArrayIndex() bool {
+	if m != nil && m.IsArrayIndex != nil {
+		return *m.IsArrayIndex
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterEnum("protobuf.IndexState", IndexState_name, IndexState_value)
 	proto.RegisterEnum("protobuf.StorageType", StorageType_name, StorageType_value)
 	proto.RegisterEnum("protobuf.ExprType", ExprType_name, ExprType_value)
 	proto.RegisterEnum("protobuf.PartitionScheme", PartitionScheme_name, PartitionScheme_value)
 	proto.RegisterEnum("protobuf.HashScheme", HashScheme_name, HashScheme_value)
+	proto.RegisterEnum("protobuf.EvalErrorPolicy", EvalErrorPolicy_name, EvalErrorPolicy_value)
 }
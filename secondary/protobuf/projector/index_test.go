@@ -0,0 +1,91 @@
+package protobuf
+
+import "errors"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import "github.com/golang/protobuf/proto"
+
+func TestSizeGuardrails(t *testing.T) {
+	defer SetSizeGuardrails(0, 0, "skip")
+
+	ie := &IndexEvaluator{}
+	SetSizeGuardrails(10, 4, "skip")
+	if err := ie.checkDocSize([]byte(`{"a":1}`)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err := ie.checkDocSize([]byte(`{"a":"large"}`))
+	if err != c.ErrorDocumentTooLarge || !ie.skipOversized(err) {
+		t.Fatalf("expected oversized document to be skipped, got %v", err)
+	}
+	if err := ie.checkKeySize([]byte(`[1,2]`)); err != c.ErrorKeyTooLarge {
+		t.Fatalf("expected %v, got %v", c.ErrorKeyTooLarge, err)
+	}
+
+	SetSizeGuardrails(10, 4, "error")
+	if ie.skipOversized(c.ErrorKeyTooLarge) {
+		t.Fatalf("unexpected skip for error policy")
+	}
+	if ie.largeDocs != 1 || ie.largeKeys != 1 || ie.skippedDocs != 1 {
+		t.Fatalf("unexpected counters %v %v %v", ie.largeDocs, ie.largeKeys, ie.skippedDocs)
+	}
+}
+
+func TestEvalErrorFailPolicy(t *testing.T) {
+	ie := &IndexEvaluator{
+		instance: &IndexInst{
+			InstId: proto.Uint64(1),
+			Definition: &IndexDefn{
+				Name:            proto.String("idx"),
+				EvalErrorPolicy: EvalErrorPolicy_EVAL_FAIL.Enum(),
+			},
+		},
+	}
+
+	for seqno := uint64(1); seqno <= evalFailureLog+2; seqno++ {
+		m := &mc.DcpEvent{VBucket: 10, Seqno: seqno, Key: []byte("doc1")}
+		ie.evalError(m, errors.New("bad expression"))
+	}
+	// the document is not indexed, so that the stream is not restarted
+	// from the same document.
+	if where, pkey, key := ie.errorKey(nil); where || pkey != nil || key != nil {
+		t.Errorf("expected document to be skipped, got %v %s %s", where, pkey, key)
+	}
+
+	stats := ie.EvalErrorStats()
+	if stats["errors"] != float64(evalFailureLog+2) || stats["failedDocs"] != float64(evalFailureLog+2) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	failures := stats["recentFailed"].([]evalFailure)
+	if len(failures) != evalFailureLog {
+		t.Fatalf("expected %v recent failures, got %v", evalFailureLog, len(failures))
+	}
+	first, last := failures[0], failures[len(failures)-1]
+	if first.Seqno != 3 || last.Seqno != evalFailureLog+2 {
+		t.Errorf("expected oldest failures to be dropped, got %v %v", first, last)
+	}
+	if last.Vbno != 10 || last.Docid != "doc1" || last.Err != "bad expression" {
+		t.Errorf("unexpected failure %v", last)
+	}
+}
+
+func TestEvalErrorSkipPolicy(t *testing.T) {
+	ie := &IndexEvaluator{
+		instance: &IndexInst{
+			InstId:     proto.Uint64(1),
+			Definition: &IndexDefn{Name: proto.String("idx")},
+		},
+	}
+
+	m := &mc.DcpEvent{VBucket: 10, Seqno: 1, Key: []byte("doc1")}
+	ie.evalError(m, errors.New("bad expression"))
+	ie.errorKey(nil)
+	stats := ie.EvalErrorStats()
+	if stats["skipped"] != float64(1) || stats["failedDocs"] != float64(0) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	if failures := stats["recentFailed"].([]evalFailure); len(failures) != 0 {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
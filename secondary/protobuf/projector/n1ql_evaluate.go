@@ -1,5 +1,7 @@
 package protobuf
 
+import "fmt"
+
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/collatejson"
 import qexpr "github.com/couchbase/query/expression"
@@ -24,14 +26,66 @@ func CompileN1QLExpression(expressions []string) ([]interface{}, error) {
 
 var missing = qvalue.NewValue(string(collatejson.MissingLiteral))
 
+// EvalError is returned by N1QLEvaluate for a document that
+// failed evaluation of its index expressions.
+type EvalError struct {
+	Expr  string // expression failing evaluation, if any
+	Docid []byte
+	Err   error
+}
+
+func (e *EvalError) Error() string {
+	arg2 := logging.TagUD(string(e.Docid))
+	if e.Expr == "" {
+		return fmt.Sprintf("index key for docid %v, err: %v", arg2, e.Err)
+	}
+	arg1 := logging.TagUD(e.Expr)
+	return fmt.Sprintf("EvaluateForIndex(%q) for docid %v, err: %v", arg1, arg2, e.Err)
+}
+
 // N1QLTransform will use compiled list of expression from N1QL's DDL
 // statement and evaluate a document using them to return a secondary
-// key as JSON object.
+// key as JSON object. Documents failing evaluation are logged and
+// skipped.
 func N1QLTransform(
 	docid []byte, docval qvalue.AnnotatedValue, context qexpr.Context,
 	cExprs []interface{},
 	encodeBuf []byte) ([]byte, []byte, error) {
 
+	out, newBuf, err := N1QLEvaluate(docid, docval, context, cExprs, encodeBuf)
+	if _, ok := err.(*EvalError); ok {
+		logging.Errorf("%v skip document", err)
+		return nil, newBuf, nil
+	}
+	return out, newBuf, err
+}
+
+// N1QLEvaluate is same as N1QLTransform, except that documents failing
+// evaluation are returned as *EvalError.
+func N1QLEvaluate(
+	docid []byte, docval qvalue.AnnotatedValue, context qexpr.Context,
+	cExprs []interface{},
+	encodeBuf []byte) ([]byte, []byte, error) {
+
+	return n1qlEvaluate(docid, docval, context, cExprs, encodeBuf, false)
+}
+
+// N1QLEvaluateMissing is same as N1QLEvaluate, except that documents
+// whose leading key is MISSING are not skipped, MISSING is collated
+// below NULL in the secondary key.
+func N1QLEvaluateMissing(
+	docid []byte, docval qvalue.AnnotatedValue, context qexpr.Context,
+	cExprs []interface{},
+	encodeBuf []byte) ([]byte, []byte, error) {
+
+	return n1qlEvaluate(docid, docval, context, cExprs, encodeBuf, true)
+}
+
+func n1qlEvaluate(
+	docid []byte, docval qvalue.AnnotatedValue, context qexpr.Context,
+	cExprs []interface{},
+	encodeBuf []byte, includeMissing bool) ([]byte, []byte, error) {
+
 	arrValue := make([]interface{}, 0, len(cExprs))
 	skip := true
 	for _, cExpr := range cExprs {
@@ -39,27 +93,21 @@ func N1QLTransform(
 		scalar, vector, err := expr.EvaluateForIndex(docval, context)
 		if err != nil {
 			exprstr := qexpr.NewStringer().Visit(expr)
-			fmsg := "EvaluateForIndex(%q) for docid %v, err: %v skip document"
-			arg1 := logging.TagUD(exprstr)
-			arg2 := logging.TagUD(string(docid))
-			logging.Errorf(fmsg, arg1, arg2, err)
-			return nil, nil, nil
+			return nil, nil, &EvalError{Expr: exprstr, Docid: docid, Err: err}
 		}
 		isArray, _ := expr.IsArrayIndexKey()
 		if isArray == false {
 			if scalar == nil { //nil is ERROR condition
 				exprstr := qexpr.NewStringer().Visit(expr)
-				fmsg := "EvaluateForIndex(%q) scalar=nil, skip document %v"
-				arg1 := logging.TagUD(exprstr)
-				arg2 := logging.TagUD(string(docid))
-				logging.Errorf(fmsg, arg1, arg2)
-				return nil, nil, nil
+				err := fmt.Errorf("scalar=nil")
+				return nil, nil, &EvalError{Expr: exprstr, Docid: docid, Err: err}
 			}
 			key := scalar
-			if key.Type() == qvalue.MISSING && skip {
+			if key.Type() == qvalue.MISSING && skip && !includeMissing {
 				return nil, nil, nil
 
 			} else if key.Type() == qvalue.MISSING {
+				skip = false
 				arrValue = append(arrValue, key)
 				continue
 			}
@@ -68,11 +116,8 @@ func N1QLTransform(
 		} else {
 			if vector == nil { //nil is ERROR condition
 				exprstr := qexpr.NewStringer().Visit(expr)
-				fmsg := "EvaluateForIndex(%q) vector=nil, skip document %v"
-				arg1 := logging.TagUD(exprstr)
-				arg2 := logging.TagUD(string(docid))
-				logging.Errorf(fmsg, arg1, arg2)
-				return nil, nil, nil
+				err := fmt.Errorf("vector=nil")
+				return nil, nil, &EvalError{Expr: exprstr, Docid: docid, Err: err}
 			}
 
 			if skip { //array is leading
@@ -112,10 +157,8 @@ func N1QLTransform(
 		if encodeBuf != nil {
 			out, newBuf, err := CollateJSONEncode(qvalue.NewValue(arrValue), encodeBuf)
 			if err != nil {
-				fmsg := "CollateJSONEncode: index field for docid: %s (err: %v) skip document"
-				arg1 := logging.TagUD(docid)
-				logging.Errorf(fmsg, arg1, err)
-				return nil, newBuf, nil
+				err = fmt.Errorf("CollateJSONEncode: %v", err)
+				return nil, newBuf, &EvalError{Docid: docid, Err: err}
 			}
 			return out, newBuf, err // return as collated JSON array
 		}
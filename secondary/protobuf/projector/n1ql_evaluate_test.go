@@ -107,6 +107,31 @@ func TestN1QLTransform2000(t *testing.T) {
 	}
 }
 
+func TestN1QLEvaluateMissing(t *testing.T) {
+	cExprs, err := CompileN1QLExpression([]string{`missing`, `age`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docval := qvalue.NewAnnotatedValue(qvalue.NewParsedValue(doc150, true))
+	docval.SetAttachment("meta", make(map[string]interface{} /*meta*/))
+	context := qexpr.NewIndexContext()
+	secKey, _, err := N1QLEvaluate([]byte("docid"), docval, context, cExprs, buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if secKey != nil {
+		t.Fatalf("expected document to be skipped %v", decodeCollateJSON(secKey))
+	}
+
+	secKey, _, err = N1QLEvaluateMissing([]byte("docid"), docval, context, cExprs, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := `"` + string(collatejson.MissingLiteral) + `"`
+	if !bytes.Equal(secKey, encodeJSON(`[`+missing+`,32]`)) {
+		t.Fatalf("evaluation failed %v", decodeCollateJSON(secKey))
+	}
+}
+
 func TestInvalidDocs(t *testing.T) {
 	cExprs, err := CompileN1QLExpression([]string{`city`, `age`})
 	if err != nil {
@@ -0,0 +1,189 @@
+package protobuf
+
+import "bytes"
+import "fmt"
+
+import qexpr "github.com/couchbase/query/expression"
+import qvalue "github.com/couchbase/query/value"
+
+// FieldPath is a plain path expression, like `address`.`streetaddress`.`floor`,
+// as the names of nested fields from the document root.
+type FieldPath []string
+
+// CompileFieldPaths returns the field paths of compiled N1QL
+// expressions, nil if any of the expressions is not a plain path of
+// case sensitive field names. Secondary keys on plain paths can be
+// evaluated by PathEvaluate, without parsing the whole document.
+func CompileFieldPaths(cExprs []interface{}) []FieldPath {
+	paths := make([]FieldPath, 0, len(cExprs))
+	for _, cExpr := range cExprs {
+		path := fieldPathOf(cExpr.(qexpr.Expression))
+		if path == nil {
+			return nil
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func fieldPathOf(expr qexpr.Expression) FieldPath {
+	switch e := expr.(type) {
+	case *qexpr.Identifier:
+		if e.CaseInsensitive() {
+			return nil
+		}
+		return FieldPath{e.Identifier()}
+
+	case *qexpr.Field:
+		name, ok := e.Second().(*qexpr.FieldName)
+		if !ok || name.CaseInsensitive() {
+			return nil
+		}
+		if path := fieldPathOf(e.First()); path != nil {
+			return append(path, name.Alias())
+		}
+	}
+	return nil
+}
+
+// PathEvaluate is N1QLEvaluate for secondary keys on plain paths, with
+// a collatejson encode buffer. Values of the paths are located in the
+// JSON document and only those are parsed. It returns ok as false if
+// the document cannot be scanned, for the caller to fall back on
+// N1QLEvaluate. With includeMissing, documents whose leading key is
+// MISSING are not skipped, like N1QLEvaluateMissing.
+func PathEvaluate(
+	docid, doc []byte, paths []FieldPath, encodeBuf []byte,
+	includeMissing bool) (out []byte, newBuf []byte, ok bool, err error) {
+
+	arrValue := make([]interface{}, 0, len(paths))
+	for i, path := range paths {
+		raw, found, ok := jsonPathValue(doc, path)
+		if !ok {
+			return nil, nil, false, nil
+		} else if !found && i == 0 && !includeMissing { // leading key is missing
+			return nil, nil, true, nil
+		} else if !found {
+			arrValue = append(arrValue, qvalue.NewMissingValue())
+			continue
+		}
+		arrValue = append(arrValue, qvalue.NewParsedValueWithOptions(raw, true, true))
+	}
+
+	out, newBuf, err = CollateJSONEncode(qvalue.NewValue(arrValue), encodeBuf)
+	if err != nil {
+		err = fmt.Errorf("CollateJSONEncode: %v", err)
+		return nil, newBuf, true, &EvalError{Docid: docid, Err: err}
+	}
+	return out, newBuf, true, nil
+}
+
+// jsonPathValue returns the JSON value of path in doc, found as false
+// if the path is missing. It returns ok as false if doc is not valid
+// JSON, or has field names with escape sequences.
+func jsonPathValue(doc []byte, path FieldPath) (value []byte, found, ok bool) {
+	value = doc
+	for _, name := range path {
+		i := skipSpace(value, 0)
+		if i == len(value) {
+			return nil, false, false
+		} else if value[i] != '{' { // field of a non-object is missing
+			return nil, false, true
+		}
+
+		found = false
+		for i = skipSpace(value, i+1); i < len(value) && value[i] != '}'; {
+			if value[i] != '"' {
+				return nil, false, false
+			}
+			key, end, escaped := scanString(value, i)
+			if end < 0 || escaped {
+				return nil, false, false
+			}
+			if i = skipSpace(value, end); i == len(value) || value[i] != ':' {
+				return nil, false, false
+			}
+			i = skipSpace(value, i+1)
+			end = skipValue(value, i)
+			if end < 0 {
+				return nil, false, false
+			}
+			if string(key) == name {
+				value, found = value[i:end], true
+				break
+			}
+			if i = skipSpace(value, end); i < len(value) && value[i] == ',' {
+				i = skipSpace(value, i+1)
+			}
+		}
+		if !found {
+			return nil, false, true
+		}
+	}
+	return value, true, true
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// scanString returns the content of the JSON string starting at b[i],
+// and the offset past its closing quote, -1 if the string is not
+// terminated.
+func scanString(b []byte, i int) (content []byte, end int, escaped bool) {
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			escaped = true
+			j++
+		case '"':
+			return b[i+1 : j], j + 1, escaped
+		}
+	}
+	return nil, -1, escaped
+}
+
+// skipValue returns the offset past the JSON value starting at b[i], -1
+// if the value is not terminated.
+func skipValue(b []byte, i int) int {
+	if i == len(b) {
+		return -1
+	}
+	switch b[i] {
+	case '"':
+		_, end, _ := scanString(b, i)
+		return end
+
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(b); j++ {
+			switch b[j] {
+			case '"':
+				_, end, _ := scanString(b, j)
+				if end < 0 {
+					return -1
+				}
+				j = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return j + 1
+				}
+			}
+		}
+		return -1
+	}
+
+	end := i
+	for end < len(b) && bytes.IndexByte([]byte(",}] \t\r\n"), b[end]) < 0 {
+		end++
+	}
+	if end == i {
+		return -1
+	}
+	return end
+}
@@ -0,0 +1,123 @@
+package protobuf
+
+import (
+	"bytes"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+	"testing"
+)
+
+func TestCompileFieldPaths(t *testing.T) {
+	testcases := []struct {
+		exprs []string
+		paths []FieldPath
+	}{
+		{[]string{`city`, `age`}, []FieldPath{{"city"}, {"age"}}},
+		{[]string{"`first-name`"}, []FieldPath{{"first-name"}}},
+		{[]string{`obbligato.evaporable.age`},
+			[]FieldPath{{"obbligato", "evaporable", "age"}}},
+		{[]string{`city`, `lower(city)`}, nil},
+		{[]string{`obbligato[0]`}, nil},
+		{[]string{`meta().id`}, nil},
+	}
+	for _, tc := range testcases {
+		cExprs, err := CompileN1QLExpression(tc.exprs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := CompileFieldPaths(cExprs)
+		if len(paths) != len(tc.paths) {
+			t.Fatalf("%v: expected %v, got %v", tc.exprs, tc.paths, paths)
+		}
+		for i := range paths {
+			if len(paths[i]) != len(tc.paths[i]) {
+				t.Fatalf("%v: expected %v, got %v", tc.exprs, tc.paths, paths)
+			}
+			for j := range paths[i] {
+				if paths[i][j] != tc.paths[i][j] {
+					t.Fatalf("%v: expected %v, got %v", tc.exprs, tc.paths, paths)
+				}
+			}
+		}
+	}
+}
+
+func TestPathEvaluate(t *testing.T) {
+	testcases := []struct {
+		doc   []byte
+		exprs []string
+	}{
+		{doc150, []string{`city`, `age`}},
+		{doc150, []string{"`first-name`", `gender`}},
+		{doc150, []string{`city`, `missing`, `age`}},
+		{doc150, []string{`missing`, `age`}},
+		{doc150, []string{`city.name`}},
+		{doc2000, []string{`city`, `age`}},
+		{doc2000, []string{`obbligato.evaporable.age`, `obbligato.Labidura`}},
+		{doc2000, []string{`obbligato.evaporable.Holothuridea`}},
+		{doc2000, []string{`obbligato.blissless`, `obbligato.unalone`}},
+	}
+	context := qexpr.NewIndexContext()
+	for _, tc := range testcases {
+		cExprs, err := CompileN1QLExpression(tc.exprs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := CompileFieldPaths(cExprs)
+		if paths == nil {
+			t.Fatalf("%v: expected field paths", tc.exprs)
+		}
+
+		for _, includeMissing := range []bool{false, true} {
+			evaluate := N1QLEvaluate
+			if includeMissing {
+				evaluate = N1QLEvaluateMissing
+			}
+			docval := qvalue.NewAnnotatedValue(qvalue.NewParsedValue(tc.doc, true))
+			docval.SetAttachment("meta", make(map[string]interface{} /*meta*/))
+			ref, _, err := evaluate(
+				[]byte("docid"), docval, context, cExprs, make([]byte, 0, 10000))
+			if err != nil {
+				t.Fatal(err)
+			}
+			secKey, _, ok, err := PathEvaluate([]byte("docid"), tc.doc, paths,
+				make([]byte, 0, 10000), includeMissing)
+			if err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				t.Fatalf("%v: expected path evaluation", tc.exprs)
+			}
+			if !bytes.Equal(secKey, ref) {
+				t.Fatalf("%v %v: expected %v, got %v", tc.exprs, includeMissing,
+					decodeCollateJSON(ref), decodeCollateJSON(secKey))
+			}
+		}
+	}
+}
+
+func TestPathEvaluateFallback(t *testing.T) {
+	cExprs, err := CompileN1QLExpression([]string{`city`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := CompileFieldPaths(cExprs)
+
+	docs := [][]byte{
+		[]byte(`{"c\u0069ty": "Kathmandu"}`), []byte(`{"city": `),
+		[]byte(`{"age" 10}`), []byte(`{,`), []byte(``),
+	}
+	for _, doc := range docs {
+		_, _, ok, _ := PathEvaluate([]byte("docid"), doc, paths, buf, false)
+		if ok {
+			t.Fatalf("%q: expected fallback to N1QL", doc)
+		}
+	}
+}
+
+func BenchmarkPathEvaluate2000(b *testing.B) {
+	cExprs, _ := CompileN1QLExpression([]string{`age`})
+	paths := CompileFieldPaths(cExprs)
+	for i := 0; i < b.N; i++ {
+		PathEvaluate([]byte("docid"), doc2000, paths, buf, false)
+	}
+}
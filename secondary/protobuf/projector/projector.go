@@ -231,17 +231,34 @@ func (resp *FailoverLogResponse) ToFailoverLog(vbnos []uint16) couchbase.Failove
 //MutationTopicRequest
 //********************
 
+// Transport features of dataport endpoints. Downstream advertises the
+// features it supports in MutationTopicRequest, and projector enables
+// only the advertised features on the topic's endpoints, so that older
+// downstream continue to receive frames they can decode.
+const (
+	// EndpointFeatureFragments to receive payloads fragmented across
+	// frames.
+	EndpointFeatureFragments uint32 = 1 << iota
+	// EndpointFeatureCRC32C to receive payloads followed by their
+	// CRC32C checksum.
+	EndpointFeatureCRC32C
+)
+
+// EndpointFeatures supported by dataport of this version.
+const EndpointFeatures = EndpointFeatureFragments | EndpointFeatureCRC32C
+
 // NewMutationTopicRequest creates a new MutationTopicRequest
-// for `topic`.
+// for `topic`, advertising EndpointFeatures.
 func NewMutationTopicRequest(
 	topic, endpointType string, instances []*Instance) *MutationTopicRequest {
 
 	return &MutationTopicRequest{
-		Topic:         proto.String(topic),
-		EndpointType:  proto.String(endpointType),
-		ReqTimestamps: make([]*TsVbuuid, 0),
-		Instances:     instances,
-		Version:       FeedVersion_watson.Enum(),
+		Topic:            proto.String(topic),
+		EndpointType:     proto.String(endpointType),
+		ReqTimestamps:    make([]*TsVbuuid, 0),
+		Instances:        instances,
+		Version:          FeedVersion_watson.Enum(),
+		EndpointFeatures: proto.Uint32(EndpointFeatures),
 	}
 }
 
@@ -781,6 +798,42 @@ func (req *ShutdownTopicRequest) Decode(data []byte) (err error) {
 	return proto.Unmarshal(data, req)
 }
 
+// *************************
+// AckTimestampsRequest
+// *************************
+
+// NewAckTimestampsRequest creates a AckTimestampsRequest
+// for a topic's endpoint.
+func NewAckTimestampsRequest(
+	topic, endpoint string, ackTss []*TsVbuuid) *AckTimestampsRequest {
+
+	return &AckTimestampsRequest{
+		Topic:         proto.String(topic),
+		Endpoint:      proto.String(endpoint),
+		AckTimestamps: ackTss,
+	}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *AckTimestampsRequest) Name() string {
+	return "ackTimestampsRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *AckTimestampsRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *AckTimestampsRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *AckTimestampsRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
 //-- local functions
 
 // TODO: add other types of engines
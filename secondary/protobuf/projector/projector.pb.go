@@ -175,9 +175,13 @@ type MutationTopicRequest struct {
 	EndpointType  *string     `protobuf:"bytes,2,req,name=endpointType" json:"endpointType,omitempty"`
 	ReqTimestamps []*TsVbuuid `protobuf:"bytes,3,rep,name=reqTimestamps" json:"reqTimestamps,omitempty"`
 	// initial list of instances applicable for this topic
-	Instances        []*Instance  `protobuf:"bytes,4,rep,name=instances" json:"instances,omitempty"`
-	Version          *FeedVersion `protobuf:"varint,5,opt,name=version,enum=protobuf.FeedVersion,def=1" json:"version,omitempty"`
-	XXX_unrecognized []byte       `json:"-"`
+	Instances []*Instance  `protobuf:"bytes,4,rep,name=instances" json:"instances,omitempty"`
+	Version   *FeedVersion `protobuf:"varint,5,opt,name=version,enum=protobuf.FeedVersion,def=1" json:"version,omitempty"`
+	// retried requests with same requestId are de-duplicated
+	RequestId *string `protobuf:"bytes,6,opt,name=requestId" json:"requestId,omitempty"`
+	// transport features supported by endpoints, refer EndpointFeature*
+	EndpointFeatures *uint32 `protobuf:"varint,7,opt,name=endpointFeatures" json:"endpointFeatures,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *MutationTopicRequest) Reset()         { *m = MutationTopicRequest{} }
@@ -221,6 +225,20 @@ func (m *MutationTopicRequest) GetVersion() FeedVersion {
 	return Default_MutationTopicRequest_Version
 }
 
+func (m *MutationTopicRequest) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *MutationTopicRequest) GetEndpointFeatures() uint32 {
+	if m != nil && m.EndpointFeatures != nil {
+		return *m.EndpointFeatures
+	}
+	return 0
+}
+
 // Response back for
 // MutationTopicRequest, RestartVbucketsRequest, AddBucketsRequest
 type TopicResponse struct {
@@ -438,6 +456,7 @@ type AddInstancesRequest struct {
 	Topic            *string      `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
 	Instances        []*Instance  `protobuf:"bytes,2,rep,name=instances" json:"instances,omitempty"`
 	Version          *FeedVersion `protobuf:"varint,3,opt,name=version,enum=protobuf.FeedVersion,def=1" json:"version,omitempty"`
+	RequestId        *string      `protobuf:"bytes,4,opt,name=requestId" json:"requestId,omitempty"`
 	XXX_unrecognized []byte       `json:"-"`
 }
 
@@ -468,6 +487,13 @@ func (m *AddInstancesRequest) GetVersion() FeedVersion {
 	return Default_AddInstancesRequest_Version
 }
 
+func (m *AddInstancesRequest) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
 // DelInstancesRequest to add index-instances to a topic.
 // Respond back with TopicResponse
 type DelInstancesRequest struct {
@@ -538,6 +564,41 @@ func (m *ShutdownTopicRequest) GetTopic() string {
 	return ""
 }
 
+// Posted periodically by indexer with the timestamps, per bucket, of its
+// latest snapshot of mutations received on endpoint. Error message will
+// be sent as response.
+type AckTimestampsRequest struct {
+	Topic            *string     `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	Endpoint         *string     `protobuf:"bytes,2,req,name=endpoint" json:"endpoint,omitempty"`
+	AckTimestamps    []*TsVbuuid `protobuf:"bytes,3,rep,name=ackTimestamps" json:"ackTimestamps,omitempty"`
+	XXX_unrecognized []byte      `json:"-"`
+}
+
+func (m *AckTimestampsRequest) Reset()         { *m = AckTimestampsRequest{} }
+func (m *AckTimestampsRequest) String() string { return proto.CompactTextString(m) }
+func (*AckTimestampsRequest) ProtoMessage()    {}
+
+func (m *AckTimestampsRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *AckTimestampsRequest) GetEndpoint() string {
+	if m != nil && m.Endpoint != nil {
+		return *m.Endpoint
+	}
+	return ""
+}
+
+func (m *AckTimestampsRequest) GetAckTimestamps() []*TsVbuuid {
+	if m != nil {
+		return m.AckTimestamps
+	}
+	return nil
+}
+
 // Generic instance, can be an index instance, xdcr, search etc ...
 type Instance struct {
 	IndexInstance    *IndexInst `protobuf:"bytes,1,opt,name=indexInstance" json:"indexInstance,omitempty"`
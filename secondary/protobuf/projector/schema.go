@@ -0,0 +1,27 @@
+package protobuf
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// Schema describes the messages exchanged on projector's adminport, as
+// compiled into this binary. The version is the latest feed version.
+func Schema() *c.ProtoSchema {
+	enums := map[string]map[string]int32{
+		"protobuf.FeedVersion":     FeedVersion_value,
+		"protobuf.IndexState":      IndexState_value,
+		"protobuf.StorageType":     StorageType_value,
+		"protobuf.ExprType":        ExprType_value,
+		"protobuf.PartitionScheme": PartitionScheme_value,
+		"protobuf.HashScheme":      HashScheme_value,
+		"protobuf.EvalErrorPolicy": EvalErrorPolicy_value,
+	}
+	return c.NewProtoSchema("adminport", uint32(FeedVersion_watson), "protobuf", enums,
+		&VbmapRequest{}, &VbmapResponse{},
+		&FailoverLogRequest{}, &FailoverLogResponse{},
+		&MutationTopicRequest{}, &TopicResponse{}, &TimestampResponse{},
+		&RestartVbucketsRequest{}, &ShutdownVbucketsRequest{},
+		&AddBucketsRequest{}, &DelBucketsRequest{},
+		&AddInstancesRequest{}, &DelInstancesRequest{},
+		&RepairEndpointsRequest{}, &ShutdownTopicRequest{},
+		&AckTimestampsRequest{},
+		&Error{})
+}
@@ -36,6 +36,9 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *ScanAllRequest:
 		pl.ScanAllRequest = val
 
+	case *IntersectRequest:
+		pl.IntersectRequest = val
+
 	case *EndStreamRequest:
 		pl.EndStream = val
 
@@ -58,6 +61,12 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *HeloResponse:
 		pl.HeloResponse = val
 
+	case *AuthRequest:
+		pl.AuthRequest = val
+
+	case *AuthResponse:
+		pl.AuthResponse = val
+
 	default:
 		return nil, ErrorMissingPayload
 	}
@@ -93,6 +102,8 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetScanAllRequest(); val != nil {
 		return val, nil
+	} else if val := pl.GetIntersectRequest(); val != nil {
+		return val, nil
 	} else if val := pl.GetEndStream(); val != nil {
 		return val, nil
 		// response
@@ -110,6 +121,10 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetHeloResponse(); val != nil {
 		return val, nil
+	} else if val := pl.GetAuthRequest(); val != nil {
+		return val, nil
+	} else if val := pl.GetAuthResponse(); val != nil {
+		return val, nil
 	}
 	return nil, ErrorMissingPayload
 }
@@ -1,13 +1,45 @@
 package protobuf
 
+import "encoding/binary"
 import "errors"
 import json "github.com/couchbase/indexing/secondary/common/json"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/golang/protobuf/proto"
 
+// ErrorPackedDocIds
+var ErrorPackedDocIds = errors.New("queryport.packedDocIds")
+
+// ErrorPackedRows
+var ErrorPackedRows = errors.New("queryport.packedRows")
+
+// Row encodings of scan responses. Encodings supported by both client
+// and server are negotiated with HeloRequest, and requested by scans.
+const (
+	// EncodingPackedRows sends rows as PackedRows, keys and docids in
+	// separate columns of a single buffer each.
+	EncodingPackedRows uint32 = 1 << iota
+	// EncodingKeyDict refers to keys repeated within a PackedRows by
+	// their position, instead of sending them again.
+	EncodingKeyDict
+)
+
+// SupportedEncodings are the row encodings known to this version.
+const SupportedEncodings = EncodingPackedRows | EncodingKeyDict
+
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
+	if rows := r.GetPackedRows(); rows != nil {
+		return rows.getEntries()
+	}
+	if packed := r.GetPackedDocIds(); len(packed) > 0 {
+		pkeys, err := UnpackDocIds(packed)
+		if err != nil {
+			return nil, nil, err
+		}
+		return make([]c.SecondaryKey, len(pkeys)), pkeys, nil
+	}
+
 	entries := r.GetIndexEntries()
 	skeys := make([]c.SecondaryKey, 0, len(entries))
 	pkeys := make([][]byte, 0, len(entries))
@@ -27,6 +59,38 @@ func (r *ResponseStream) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
 	return skeys, pkeys, nil
 }
 
+// getEntries decodes every distinct key once, rows with the same key
+// share the decoded key.
+func (r *PackedRows) getEntries() ([]c.SecondaryKey, [][]byte, error) {
+	pkeys, err := UnpackDocIds(r.GetDocids())
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, refs, err := UnpackKeys(r.GetKeys())
+	if err != nil {
+		return nil, nil, err
+	} else if len(refs) != len(pkeys) {
+		return nil, nil, ErrorPackedRows
+	}
+
+	decoded := make([]c.SecondaryKey, len(keys))
+	for i, key := range keys {
+		skey := make(c.SecondaryKey, 0)
+		if err := json.Unmarshal(key, &skey); err != nil {
+			return nil, nil, err
+		}
+		decoded[i] = skey
+	}
+
+	skeys := make([]c.SecondaryKey, len(refs))
+	for i, ref := range refs {
+		if ref >= 0 {
+			skeys[i] = decoded[ref]
+		}
+	}
+	return skeys, pkeys, nil
+}
+
 // Error implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) Error() error {
 	if e := r.GetErr(); e != nil {
@@ -37,6 +101,15 @@ func (r *ResponseStream) Error() error {
 	return nil
 }
 
+// GetEstimate returns the estimated result size of the scan, sent with
+// its first response, false if not sent.
+func (r *ResponseStream) GetEstimate() (uint64, bool) {
+	if r == nil || r.EstimatedRows == nil {
+		return 0, false
+	}
+	return *r.EstimatedRows, true
+}
+
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *StreamEndResponse) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
 	return nil, nil, nil
@@ -52,6 +125,16 @@ func (r *StreamEndResponse) Error() error {
 	return nil
 }
 
+// Error returns the authentication failure, if any.
+func (r *AuthResponse) Error() error {
+	if e := r.GetErr(); e != nil {
+		if ee := e.GetError(); ee != "" {
+			return errors.New(ee)
+		}
+	}
+	return nil
+}
+
 // Count implements common.IndexStatistics{} method.
 func (s *IndexStatistics) Count() (int64, error) {
 	return int64(s.GetKeysCount()), nil
@@ -98,3 +181,95 @@ func NewTsConsistency(
 		Crc64: proto.Uint64(crc64),
 	}
 }
+
+// AppendPackedDocId appends docid to buf in packed encoding, which is
+// the uvarint length of docid followed by the docid itself.
+func AppendPackedDocId(buf, docid []byte) []byte {
+	var lenbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenbuf[:], uint64(len(docid)))
+	buf = append(buf, lenbuf[:n]...)
+	return append(buf, docid...)
+}
+
+// PackedDocIdLen returns the number of bytes docid occupies in packed
+// encoding.
+func PackedDocIdLen(docid []byte) int {
+	var lenbuf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(lenbuf[:], uint64(len(docid))) + len(docid)
+}
+
+// UnpackDocIds complements AppendPackedDocId(). Returned docids share
+// memory with `packed`.
+func UnpackDocIds(packed []byte) ([][]byte, error) {
+	docids := make([][]byte, 0)
+	for len(packed) > 0 {
+		l, n := binary.Uvarint(packed)
+		if n <= 0 || uint64(len(packed)-n) < l {
+			return nil, ErrorPackedDocIds
+		}
+		docids = append(docids, packed[n:n+int(l)])
+		packed = packed[n+int(l):]
+	}
+	return docids, nil
+}
+
+// AppendPackedKey appends key to buf in packed encoding, which is the
+// uvarint of twice the length of key followed by the key itself. An
+// empty key is a row without key.
+func AppendPackedKey(buf, key []byte) []byte {
+	var lenbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenbuf[:], uint64(len(key))<<1)
+	buf = append(buf, lenbuf[:n]...)
+	return append(buf, key...)
+}
+
+// AppendPackedKeyRef appends a reference to the ref-th key appended to
+// buf by AppendPackedKey, as the uvarint of twice ref plus one.
+func AppendPackedKeyRef(buf []byte, ref int) []byte {
+	var lenbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenbuf[:], uint64(ref)<<1|1)
+	return append(buf, lenbuf[:n]...)
+}
+
+// PackedKeyLen returns the maximum number of bytes key occupies in
+// packed encoding.
+func PackedKeyLen(key []byte) int {
+	var lenbuf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(lenbuf[:], uint64(len(key))<<1) + len(key)
+}
+
+// UnpackKeys complements AppendPackedKey() and AppendPackedKeyRef().
+// It returns the distinct keys and, for every row, the position of its
+// key, -1 for rows without key. Returned keys share memory with
+// `packed`.
+func UnpackKeys(packed []byte) ([][]byte, []int, error) {
+	keys, refs := make([][]byte, 0), make([]int, 0)
+	for len(packed) > 0 {
+		v, n := binary.Uvarint(packed)
+		if n <= 0 {
+			return nil, nil, ErrorPackedRows
+		}
+		packed = packed[n:]
+
+		if v&1 == 1 {
+			ref := v >> 1
+			if ref >= uint64(len(keys)) {
+				return nil, nil, ErrorPackedRows
+			}
+			refs = append(refs, int(ref))
+			continue
+		}
+
+		l := v >> 1
+		if uint64(len(packed)) < l {
+			return nil, nil, ErrorPackedRows
+		} else if l == 0 {
+			refs = append(refs, -1)
+			continue
+		}
+		refs = append(refs, len(keys))
+		keys = append(keys, packed[:l])
+		packed = packed[l:]
+	}
+	return keys, refs, nil
+}
@@ -14,12 +14,16 @@ It has these top-level messages:
 	QueryPayload
 	HeloRequest
 	HeloResponse
+	AuthRequest
+	AuthResponse
 	StatisticsRequest
 	StatisticsResponse
 	ScanRequest
 	ScanAllRequest
+	IntersectRequest
 	EndStreamRequest
 	ResponseStream
+	PackedRows
 	StreamEndResponse
 	CountRequest
 	CountResponse
@@ -105,6 +109,41 @@ func (m *TsConsistency) GetCrc64() uint64 {
 	return 0
 }
 
+// staleness tolerated by a scan, for StalenessConsistency. The scan
+// proceeds if the index is behind KV by at most maxSeqnos on every
+// vbucket, or was caught up with KV within the last maxTime.
+type StalenessBound struct {
+	MaxSeqnos        *uint64 `protobuf:"varint,1,opt,name=maxSeqnos" json:"maxSeqnos,omitempty"`
+	MaxTime          *int64  `protobuf:"varint,2,opt,name=maxTime" json:"maxTime,omitempty"`
+	Wait             *bool   `protobuf:"varint,3,opt,name=wait" json:"wait,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *StalenessBound) Reset()         { *m = StalenessBound{} }
+func (m *StalenessBound) String() string { return proto.CompactTextString(m) }
+func (*StalenessBound) ProtoMessage()    {}
+
+func (m *StalenessBound) GetMaxSeqnos() uint64 {
+	if m != nil && m.MaxSeqnos != nil {
+		return *m.MaxSeqnos
+	}
+	return 0
+}
+
+func (m *StalenessBound) GetMaxTime() int64 {
+	if m != nil && m.MaxTime != nil {
+		return *m.MaxTime
+	}
+	return 0
+}
+
+func (m *StalenessBound) GetWait() bool {
+	if m != nil && m.Wait != nil {
+		return *m.Wait
+	}
+	return false
+}
+
 // Request can be one of the optional field.
 type QueryPayload struct {
 	Version           *uint32             `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
@@ -119,6 +158,9 @@ type QueryPayload struct {
 	StreamEnd         *StreamEndResponse  `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
 	HeloRequest       *HeloRequest        `protobuf:"bytes,11,opt,name=heloRequest" json:"heloRequest,omitempty"`
 	HeloResponse      *HeloResponse       `protobuf:"bytes,12,opt,name=heloResponse" json:"heloResponse,omitempty"`
+	IntersectRequest  *IntersectRequest   `protobuf:"bytes,13,opt,name=intersectRequest" json:"intersectRequest,omitempty"`
+	AuthRequest       *AuthRequest        `protobuf:"bytes,14,opt,name=authRequest" json:"authRequest,omitempty"`
+	AuthResponse      *AuthResponse       `protobuf:"bytes,15,opt,name=authResponse" json:"authResponse,omitempty"`
 	XXX_unrecognized  []byte              `json:"-"`
 }
 
@@ -210,9 +252,31 @@ func (m *QueryPayload) GetHeloResponse() *HeloResponse {
 	return nil
 }
 
+func (m *QueryPayload) GetIntersectRequest() *IntersectRequest {
+	if m != nil {
+		return m.IntersectRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetAuthRequest() *AuthRequest {
+	if m != nil {
+		return m.AuthRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetAuthResponse() *AuthResponse {
+	if m != nil {
+		return m.AuthResponse
+	}
+	return nil
+}
+
 // Get current server version/capabilities
 type HeloRequest struct {
 	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Encodings        *uint32 `protobuf:"varint,2,opt,name=encodings" json:"encodings,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -227,8 +291,16 @@ func (m *HeloRequest) GetVersion() uint32 {
 	return 0
 }
 
+func (m *HeloRequest) GetEncodings() uint32 {
+	if m != nil && m.Encodings != nil {
+		return *m.Encodings
+	}
+	return 0
+}
+
 type HeloResponse struct {
 	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Encodings        *uint32 `protobuf:"varint,2,opt,name=encodings" json:"encodings,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -243,6 +315,55 @@ func (m *HeloResponse) GetVersion() uint32 {
 	return 0
 }
 
+func (m *HeloResponse) GetEncodings() uint32 {
+	if m != nil && m.Encodings != nil {
+		return *m.Encodings
+	}
+	return 0
+}
+
+// Authenticate the connection. When the server requires authentication
+// this must be the first request on a connection.
+type AuthRequest struct {
+	User             *string `protobuf:"bytes,1,req,name=user" json:"user,omitempty"`
+	Password         []byte  `protobuf:"bytes,2,req,name=password" json:"password,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *AuthRequest) Reset()         { *m = AuthRequest{} }
+func (m *AuthRequest) String() string { return proto.CompactTextString(m) }
+func (*AuthRequest) ProtoMessage()    {}
+
+func (m *AuthRequest) GetUser() string {
+	if m != nil && m.User != nil {
+		return *m.User
+	}
+	return ""
+}
+
+func (m *AuthRequest) GetPassword() []byte {
+	if m != nil {
+		return m.Password
+	}
+	return nil
+}
+
+type AuthResponse struct {
+	Err              *Error `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *AuthResponse) Reset()         { *m = AuthResponse{} }
+func (m *AuthResponse) String() string { return proto.CompactTextString(m) }
+func (*AuthResponse) ProtoMessage()    {}
+
+func (m *AuthResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
 // Get Index statistics. StatisticsResponse is returned back from indexer.
 type StatisticsRequest struct {
 	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -317,6 +438,13 @@ type ScanRequest struct {
 	PartitionIds     []uint64         `protobuf:"varint,13,rep,name=partitionIds" json:"partitionIds,omitempty"`
 	GroupAggr        *GroupAggr       `protobuf:"bytes,14,opt,name=groupAggr" json:"groupAggr,omitempty"`
 	Sorted           *bool            `protobuf:"varint,15,opt,name=sorted" json:"sorted,omitempty"`
+	Priority         *uint32          `protobuf:"varint,16,opt,name=priority" json:"priority,omitempty"`
+	Explain          *bool            `protobuf:"varint,18,opt,name=explain" json:"explain,omitempty"`
+	Staleness        *StalenessBound  `protobuf:"bytes,19,opt,name=staleness" json:"staleness,omitempty"`
+	Encoding         *uint32          `protobuf:"varint,20,opt,name=encoding" json:"encoding,omitempty"`
+	AllowPartial     *bool            `protobuf:"varint,21,opt,name=allowPartial" json:"allowPartial,omitempty"`
+	ReturnSnapshotTs *bool            `protobuf:"varint,22,opt,name=returnSnapshotTs" json:"returnSnapshotTs,omitempty"`
+	EstimateOnly     *bool            `protobuf:"varint,23,opt,name=estimateOnly" json:"estimateOnly,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -429,6 +557,55 @@ func (m *ScanRequest) GetSorted() bool {
 	return false
 }
 
+func (m *ScanRequest) GetPriority() uint32 {
+	if m != nil && m.Priority != nil {
+		return *m.Priority
+	}
+	return 0
+}
+
+func (m *ScanRequest) GetExplain() bool {
+	if m != nil && m.Explain != nil {
+		return *m.Explain
+	}
+	return false
+}
+
+func (m *ScanRequest) GetStaleness() *StalenessBound {
+	if m != nil {
+		return m.Staleness
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetEncoding() uint32 {
+	if m != nil && m.Encoding != nil {
+		return *m.Encoding
+	}
+	return 0
+}
+
+func (m *ScanRequest) GetAllowPartial() bool {
+	if m != nil && m.AllowPartial != nil {
+		return *m.AllowPartial
+	}
+	return false
+}
+
+func (m *ScanRequest) GetReturnSnapshotTs() bool {
+	if m != nil && m.ReturnSnapshotTs != nil {
+		return *m.ReturnSnapshotTs
+	}
+	return false
+}
+
+func (m *ScanRequest) GetEstimateOnly() bool {
+	if m != nil && m.EstimateOnly != nil {
+		return *m.EstimateOnly
+	}
+	return false
+}
+
 // Full table scan request from indexer.
 type ScanAllRequest struct {
 	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -438,6 +615,8 @@ type ScanAllRequest struct {
 	RequestId        *string        `protobuf:"bytes,5,opt,name=requestId" json:"requestId,omitempty"`
 	RollbackTime     *int64         `protobuf:"varint,6,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
 	PartitionIds     []uint64       `protobuf:"varint,7,rep,name=partitionIds" json:"partitionIds,omitempty"`
+	Priority         *uint32        `protobuf:"varint,8,opt,name=priority" json:"priority,omitempty"`
+	Encoding         *uint32        `protobuf:"varint,9,opt,name=encoding" json:"encoding,omitempty"`
 	XXX_unrecognized []byte         `json:"-"`
 }
 
@@ -494,6 +673,54 @@ func (m *ScanAllRequest) GetPartitionIds() []uint64 {
 	return nil
 }
 
+func (m *ScanAllRequest) GetPriority() uint32 {
+	if m != nil && m.Priority != nil {
+		return *m.Priority
+	}
+	return 0
+}
+
+func (m *ScanAllRequest) GetEncoding() uint32 {
+	if m != nil && m.Encoding != nil {
+		return *m.Encoding
+	}
+	return 0
+}
+
+// Intersect the docids of two or more scans on indexes of the same
+// bucket. Only docids common to all scans are streamed back.
+type IntersectRequest struct {
+	Scans            []*ScanRequest `protobuf:"bytes,1,rep,name=scans" json:"scans,omitempty"`
+	Limit            *int64         `protobuf:"varint,2,opt,name=limit" json:"limit,omitempty"`
+	RequestId        *string        `protobuf:"bytes,3,opt,name=requestId" json:"requestId,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *IntersectRequest) Reset()         { *m = IntersectRequest{} }
+func (m *IntersectRequest) String() string { return proto.CompactTextString(m) }
+func (*IntersectRequest) ProtoMessage()    {}
+
+func (m *IntersectRequest) GetScans() []*ScanRequest {
+	if m != nil {
+		return m.Scans
+	}
+	return nil
+}
+
+func (m *IntersectRequest) GetLimit() int64 {
+	if m != nil && m.Limit != nil {
+		return *m.Limit
+	}
+	return 0
+}
+
+func (m *IntersectRequest) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
 // Request by client to stop streaming the query results.
 type EndStreamRequest struct {
 	XXX_unrecognized []byte `json:"-"`
@@ -504,9 +731,15 @@ func (m *EndStreamRequest) String() string { return proto.CompactTextString(m) }
 func (*EndStreamRequest) ProtoMessage()    {}
 
 type ResponseStream struct {
-	IndexEntries     []*IndexEntry `protobuf:"bytes,1,rep,name=indexEntries" json:"indexEntries,omitempty"`
-	Err              *Error        `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
-	XXX_unrecognized []byte        `json:"-"`
+	IndexEntries     []*IndexEntry  `protobuf:"bytes,1,rep,name=indexEntries" json:"indexEntries,omitempty"`
+	Err              *Error         `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
+	PackedDocIds     []byte         `protobuf:"bytes,3,opt,name=packedDocIds" json:"packedDocIds,omitempty"`
+	EstimatedRows    *uint64        `protobuf:"varint,4,opt,name=estimatedRows" json:"estimatedRows,omitempty"`
+	Explain          []byte         `protobuf:"bytes,5,opt,name=explain" json:"explain,omitempty"`
+	PackedRows       *PackedRows    `protobuf:"bytes,6,opt,name=packedRows" json:"packedRows,omitempty"`
+	MissingVbuckets  []uint32       `protobuf:"varint,7,rep,name=missingVbuckets" json:"missingVbuckets,omitempty"`
+	SnapshotTs       *TsConsistency `protobuf:"bytes,8,opt,name=snapshotTs" json:"snapshotTs,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
 }
 
 func (m *ResponseStream) Reset()         { *m = ResponseStream{} }
@@ -527,6 +760,73 @@ func (m *ResponseStream) GetErr() *Error {
 	return nil
 }
 
+func (m *ResponseStream) GetPackedDocIds() []byte {
+	if m != nil {
+		return m.PackedDocIds
+	}
+	return nil
+}
+
+func (m *ResponseStream) GetEstimatedRows() uint64 {
+	if m != nil && m.EstimatedRows != nil {
+		return *m.EstimatedRows
+	}
+	return 0
+}
+
+func (m *ResponseStream) GetExplain() []byte {
+	if m != nil {
+		return m.Explain
+	}
+	return nil
+}
+
+func (m *ResponseStream) GetPackedRows() *PackedRows {
+	if m != nil {
+		return m.PackedRows
+	}
+	return nil
+}
+
+func (m *ResponseStream) GetMissingVbuckets() []uint32 {
+	if m != nil {
+		return m.MissingVbuckets
+	}
+	return nil
+}
+
+func (m *ResponseStream) GetSnapshotTs() *TsConsistency {
+	if m != nil {
+		return m.SnapshotTs
+	}
+	return nil
+}
+
+// Rows in packed encoding, keys and docids in separate columns.
+type PackedRows struct {
+	Keys             []byte `protobuf:"bytes,1,opt,name=keys" json:"keys,omitempty"`
+	Docids           []byte `protobuf:"bytes,2,opt,name=docids" json:"docids,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *PackedRows) Reset()         { *m = PackedRows{} }
+func (m *PackedRows) String() string { return proto.CompactTextString(m) }
+func (*PackedRows) ProtoMessage()    {}
+
+func (m *PackedRows) GetKeys() []byte {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
+func (m *PackedRows) GetDocids() []byte {
+	if m != nil {
+		return m.Docids
+	}
+	return nil
+}
+
 // Last response packet sent by server to end query results.
 type StreamEndResponse struct {
 	Err              *Error `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
@@ -555,6 +855,7 @@ type CountRequest struct {
 	Scans            []*Scan        `protobuf:"bytes,7,rep,name=scans" json:"scans,omitempty"`
 	RollbackTime     *int64         `protobuf:"varint,8,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
 	PartitionIds     []uint64       `protobuf:"varint,9,rep,name=partitionIds" json:"partitionIds,omitempty"`
+	Priority         *uint32        `protobuf:"varint,10,opt,name=priority" json:"priority,omitempty"`
 	XXX_unrecognized []byte         `json:"-"`
 }
 
@@ -625,6 +926,13 @@ func (m *CountRequest) GetPartitionIds() []uint64 {
 	return nil
 }
 
+func (m *CountRequest) GetPriority() uint32 {
+	if m != nil && m.Priority != nil {
+		return *m.Priority
+	}
+	return 0
+}
+
 // total number of entries in index.
 type CountResponse struct {
 	Count            *int64 `protobuf:"varint,1,req,name=count" json:"count,omitempty"`
@@ -653,6 +961,7 @@ func (m *CountResponse) GetErr() *Error {
 type Span struct {
 	Range            *Range   `protobuf:"bytes,1,opt,name=range" json:"range,omitempty"`
 	Equals           [][]byte `protobuf:"bytes,2,rep,name=equals" json:"equals,omitempty"`
+	Ranges           []*Range `protobuf:"bytes,3,rep,name=ranges" json:"ranges,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -674,6 +983,13 @@ func (m *Span) GetEquals() [][]byte {
 	return nil
 }
 
+func (m *Span) GetRanges() []*Range {
+	if m != nil {
+		return m.Ranges
+	}
+	return nil
+}
+
 type Range struct {
 	Low              []byte  `protobuf:"bytes,1,opt,name=low" json:"low,omitempty"`
 	High             []byte  `protobuf:"bytes,2,opt,name=high" json:"high,omitempty"`
@@ -710,6 +1026,8 @@ type CompositeElementFilter struct {
 	Low              []byte  `protobuf:"bytes,1,opt,name=low" json:"low,omitempty"`
 	High             []byte  `protobuf:"bytes,2,opt,name=high" json:"high,omitempty"`
 	Inclusion        *uint32 `protobuf:"varint,3,req,name=inclusion" json:"inclusion,omitempty"`
+	Nulls            *uint32 `protobuf:"varint,4,opt,name=nulls" json:"nulls,omitempty"`
+	Missing          *uint32 `protobuf:"varint,5,opt,name=missing" json:"missing,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -738,6 +1056,20 @@ func (m *CompositeElementFilter) GetInclusion() uint32 {
 	return 0
 }
 
+func (m *CompositeElementFilter) GetNulls() uint32 {
+	if m != nil && m.Nulls != nil {
+		return *m.Nulls
+	}
+	return 0
+}
+
+func (m *CompositeElementFilter) GetMissing() uint32 {
+	if m != nil && m.Missing != nil {
+		return *m.Missing
+	}
+	return 0
+}
+
 type Scan struct {
 	Filters          []*CompositeElementFilter `protobuf:"bytes,1,rep,name=filters" json:"filters,omitempty"`
 	Equals           [][]byte                  `protobuf:"bytes,2,rep,name=equals" json:"equals,omitempty"`
@@ -765,6 +1097,8 @@ func (m *Scan) GetEquals() [][]byte {
 type IndexProjection struct {
 	EntryKeys        []int64 `protobuf:"varint,1,rep" json:"EntryKeys,omitempty"`
 	PrimaryKey       *bool   `protobuf:"varint,2,opt" json:"PrimaryKey,omitempty"`
+	DocIdOnly        *bool   `protobuf:"varint,3,opt" json:"DocIdOnly,omitempty"`
+	DocMeta          *bool   `protobuf:"varint,4,opt" json:"DocMeta,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -786,6 +1120,20 @@ func (m *IndexProjection) GetPrimaryKey() bool {
 	return false
 }
 
+func (m *IndexProjection) GetDocIdOnly() bool {
+	if m != nil && m.DocIdOnly != nil {
+		return *m.DocIdOnly
+	}
+	return false
+}
+
+func (m *IndexProjection) GetDocMeta() bool {
+	if m != nil && m.DocMeta != nil {
+		return *m.DocMeta
+	}
+	return false
+}
+
 type IndexEntry struct {
 	EntryKey         []byte `protobuf:"bytes,1,opt,name=entryKey" json:"entryKey,omitempty"`
 	PrimaryKey       []byte `protobuf:"bytes,2,req,name=primaryKey" json:"primaryKey,omitempty"`
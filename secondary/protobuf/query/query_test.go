@@ -0,0 +1,85 @@
+package protobuf
+
+import "bytes"
+import "encoding/json"
+import "strings"
+import "testing"
+
+func TestPackedDocIds(t *testing.T) {
+	docids := [][]byte{
+		[]byte("doc-1"),
+		[]byte(""),
+		[]byte(strings.Repeat("x", 300)),
+	}
+
+	var packed []byte
+	size := 0
+	for _, docid := range docids {
+		packed = AppendPackedDocId(packed, docid)
+		size += PackedDocIdLen(docid)
+	}
+	if size != len(packed) {
+		t.Fatalf("expected packed length %v, got %v", size, len(packed))
+	}
+
+	res := &ResponseStream{PackedDocIds: packed}
+	skeys, pkeys, err := res.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skeys) != len(docids) || len(pkeys) != len(docids) {
+		t.Fatalf("expected %v entries, got %v/%v", len(docids), len(skeys), len(pkeys))
+	}
+	for i, docid := range docids {
+		if !bytes.Equal(docid, pkeys[i]) || skeys[i] != nil {
+			t.Errorf("entry %v: expected %q, got %q %v", i, docid, pkeys[i], skeys[i])
+		}
+	}
+
+	if _, err := UnpackDocIds(packed[:len(packed)-1]); err != ErrorPackedDocIds {
+		t.Errorf("expected %v for truncated buffer, got %v", ErrorPackedDocIds, err)
+	}
+}
+
+func TestPackedRows(t *testing.T) {
+	keys := [][]byte{[]byte(`["a",1]`), nil, []byte(`["b",2]`)}
+	docids := [][]byte{[]byte("doc-1"), []byte("doc-2"), []byte("doc-3"), []byte("doc-4")}
+
+	var packedKeys, packedDocIds []byte
+	packedKeys = AppendPackedKey(packedKeys, keys[0])
+	packedKeys = AppendPackedKey(packedKeys, keys[1])
+	packedKeys = AppendPackedKey(packedKeys, keys[2])
+	packedKeys = AppendPackedKeyRef(packedKeys, 0)
+	for _, docid := range docids {
+		packedDocIds = AppendPackedDocId(packedDocIds, docid)
+	}
+
+	res := &ResponseStream{PackedRows: &PackedRows{Keys: packedKeys, Docids: packedDocIds}}
+	skeys, pkeys, err := res.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skeys) != len(docids) || len(pkeys) != len(docids) {
+		t.Fatalf("expected %v entries, got %v/%v", len(docids), len(skeys), len(pkeys))
+	}
+	expected := []string{`["a",1]`, ``, `["b",2]`, `["a",1]`}
+	for i, docid := range docids {
+		if !bytes.Equal(docid, pkeys[i]) {
+			t.Errorf("entry %v: expected docid %q, got %q", i, docid, pkeys[i])
+		}
+		if i == 1 {
+			if skeys[i] != nil {
+				t.Errorf("entry %v: expected no key, got %v", i, skeys[i])
+			}
+			continue
+		}
+		if got, _ := json.Marshal(skeys[i]); string(got) != expected[i] {
+			t.Errorf("entry %v: expected key %v, got %v", i, expected[i], got)
+		}
+	}
+
+	bad := AppendPackedKeyRef(nil, 1)
+	if _, _, err := UnpackKeys(bad); err != ErrorPackedRows {
+		t.Errorf("expected %v for dangling reference, got %v", ErrorPackedRows, err)
+	}
+}
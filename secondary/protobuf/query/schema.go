@@ -0,0 +1,30 @@
+package protobuf
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// Schema describes the messages exchanged on queryport, as compiled into
+// this binary. Queryport has no protobuf enums, consistency and priority
+// of scans are coded as uint32 from the enums of the common package.
+func Schema() *c.ProtoSchema {
+	schema := c.NewProtoSchema(
+		"queryport", uint32(ProtobufVersion()), "protobuf", nil, &QueryPayload{})
+
+	consistency := make(map[string]int32)
+	for _, cons := range []c.Consistency{
+		c.AnyConsistency, c.SessionConsistency,
+		c.QueryConsistency, c.StalenessConsistency} {
+		consistency[cons.String()] = int32(cons)
+	}
+	schema.CodedEnum("common.Consistency", consistency,
+		"protobuf.ScanRequest.cons", "protobuf.ScanAllRequest.cons",
+		"protobuf.CountRequest.cons")
+
+	priority := make(map[string]int32)
+	for _, p := range []c.ScanPriority{c.InteractivePriority, c.BatchPriority} {
+		priority[p.String()] = int32(p)
+	}
+	schema.CodedEnum("common.ScanPriority", priority,
+		"protobuf.ScanRequest.priority", "protobuf.ScanAllRequest.priority",
+		"protobuf.CountRequest.priority")
+	return schema
+}
@@ -55,13 +55,18 @@ type Command struct {
 	// Configuration
 	ConfigKey string
 	ConfigVal string
-	Help      bool
+	// options for apply index template
+	TemplateFile string
+	TemplateVars map[string]string
+	DryRun       bool
+	Force        bool
+	Help         bool
 }
 
 // ParseArgs into Command object, return the list of arguments,
 // flagset used for parseing and error if any.
 func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
-	var fields, bindexes string
+	var fields, bindexes, vars string
 	var inclusion uint
 	var equal, low, high string
 	var useSessionCons bool
@@ -73,7 +78,7 @@ func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
 	fset.StringVar(&cmdOptions.Server, "server", "127.0.0.1:8091", "Cluster server address")
 	fset.StringVar(&cmdOptions.Auth, "auth", "", "Auth user and password")
 	fset.StringVar(&cmdOptions.Bucket, "bucket", "", "Bucket name")
-	fset.StringVar(&cmdOptions.OpType, "type", "", "Command: scan|stats|scanAll|count|nodes|create|build|move|drop|list|config")
+	fset.StringVar(&cmdOptions.OpType, "type", "", "Command: scan|stats|scanAll|count|nodes|create|build|move|drop|list|config|apply")
 	fset.StringVar(&cmdOptions.IndexName, "index", "", "Index name")
 	// options for create-index
 	fset.StringVar(&cmdOptions.WhereStr, "where", "", "where clause for create index")
@@ -95,6 +100,11 @@ func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
 	fset.StringVar(&cmdOptions.ConfigKey, "ckey", "", "Config key")
 	fset.StringVar(&cmdOptions.ConfigVal, "cval", "", "Config value")
 	fset.StringVar(&cmdOptions.Using, "using", c.PlasmaDB, "storage type to use")
+	// options for apply index template
+	fset.StringVar(&cmdOptions.TemplateFile, "file", "", "Index template file to apply")
+	fset.StringVar(&vars, "vars", "", "csv list of name=value template variables")
+	fset.BoolVar(&cmdOptions.DryRun, "dryrun", false, "Only print the plan of create, drop, move or apply")
+	fset.BoolVar(&cmdOptions.Force, "force", false, "Recreate indexes drifted from the template on apply")
 
 	// not useful to expose in sherlock
 	cmdOptions.ExprType = "N1QL"
@@ -117,6 +127,17 @@ func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
 	if len(bindexes) > 0 {
 		cmdOptions.Bindexes = strings.Split(bindexes, ",")
 	}
+	// template variables
+	cmdOptions.TemplateVars = make(map[string]string)
+	if len(vars) > 0 {
+		for _, v := range strings.Split(vars, ",") {
+			nv := strings.SplitN(v, "=", 2)
+			if len(nv) != 2 {
+				return nil, nil, fset, fmt.Errorf("Invalid template variable %v", v)
+			}
+			cmdOptions.TemplateVars[nv[0]] = nv[1]
+		}
+	}
 
 	// inclusion, secStrs, equal, low, high
 	cmdOptions.Inclusion = qclient.Inclusion(inclusion)
@@ -218,6 +239,15 @@ func HandleCommand(
 		if len(cmd.SecStrs) == 0 && !cmd.IsPrimary || cmd.IndexName == "" {
 			return fmt.Errorf("createIndex(): required fields missing")
 		}
+		if cmd.DryRun {
+			result, err := client.DryRunCreateIndex(
+				iname, bucket, cmd.Using, cmd.ExprType, cmd.WhereStr,
+				cmd.SecStrs, nil, cmd.IsPrimary, c.SINGLE, nil, []byte(cmd.With))
+			if err == nil {
+				err = printDryRunResult(w, result)
+			}
+			return err
+		}
 		defnID, err = client.CreateIndex(
 			iname, bucket, cmd.Using, cmd.ExprType,
 			cmd.PartnStr, cmd.WhereStr, cmd.SecStrs, cmd.IsPrimary,
@@ -253,6 +283,14 @@ func HandleCommand(
 			return fmt.Errorf("invalid index specified : %v", cmd.IndexName)
 		}
 
+		if cmd.DryRun {
+			result, err := client.DryRunMoveIndex(uint64(index.Definition.DefnId), cmd.WithPlan)
+			if err == nil {
+				err = printDryRunResult(w, result)
+			}
+			return err
+		}
+
 		if err == nil {
 			fmt.Fprintf(w, "Moving Index for: %v %v\n", index.Definition.DefnId, cmd.With)
 			err = client.MoveIndex(uint64(index.Definition.DefnId), cmd.WithPlan)
@@ -266,6 +304,13 @@ func HandleCommand(
 		if !ok {
 			return fmt.Errorf("invalid index specified : %v", cmd.IndexName)
 		}
+		if cmd.DryRun {
+			result, err := client.DryRunDropIndex(uint64(index.Definition.DefnId))
+			if err == nil {
+				err = printDryRunResult(w, result)
+			}
+			return err
+		}
 		err = client.DropIndex(uint64(index.Definition.DefnId))
 		if err == nil {
 			fmt.Fprintf(w, "Index dropped %v/%v\n", bucket, iname)
@@ -449,10 +494,129 @@ func HandleCommand(
 			pretty = strings.Replace(string(nbody), ",\"", ",\n\"", -1)
 			fmt.Printf("New Settings:\n%s\n", string(pretty))
 		}
+
+	case "apply":
+		data, err := ioutil.ReadFile(cmd.TemplateFile)
+		if err != nil {
+			return err
+		}
+		template, err := c.ParseIndexTemplate(data, cmd.TemplateVars)
+		if err != nil {
+			return err
+		}
+		results := ApplyIndexTemplate(client, template, cmd.DryRun, cmd.Force)
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Fprintf(w, "Index %v/%v %v failed: %v\n", result.Bucket, result.Name, result.Action, result.Error)
+				err = fmt.Errorf("index template apply failed")
+			} else {
+				fmt.Fprintf(w, "Index %v/%v %v\n", result.Bucket, result.Name, result.Action)
+			}
+		}
+		return err
+	}
+	return err
+}
+
+// ApplyIndexTemplate creates the indexes of template missing from the
+// cluster. Indexes whose definition has drifted from the template are
+// recreated with force, else only reported. With dryRun, only the
+// actions to be taken are returned.
+func ApplyIndexTemplate(
+	client *qclient.GsiClient,
+	template *c.IndexTemplate, dryRun, force bool) []c.TemplateResult {
+
+	results := make([]c.TemplateResult, 0, len(template.Indexes))
+	for i := range template.Indexes {
+		defn := &template.Indexes[i]
+		result := c.TemplateResult{Bucket: defn.Bucket, Name: defn.Name, Action: c.TemplateCreate}
+
+		index, ok := GetIndex(client, defn.Bucket, defn.Name)
+		if ok && !c.IndexDrifted(defn, index.Definition) {
+			result.Action = c.TemplateUnchanged
+		} else if ok && !force {
+			result.Action = c.TemplateDrifted
+		} else if ok {
+			result.Action = c.TemplateRecreate
+		}
+
+		if !dryRun && (result.Action == c.TemplateCreate || result.Action == c.TemplateRecreate) {
+			if err := applyIndexTemplateDefn(client, defn, index); err != nil {
+				result.Error = err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// applyIndexTemplateDefn creates the index of defn, dropping current
+// first if any. The dropped index is created again if defn fails to be
+// created.
+func applyIndexTemplateDefn(
+	client *qclient.GsiClient, defn *c.IndexDefn, current *mclient.IndexMetadata) error {
+
+	if current == nil {
+		return createIndexOfDefn(client, defn)
+	}
+
+	if err := client.DropIndex(uint64(current.Definition.DefnId)); err != nil {
+		return err
+	}
+
+	createErr := createIndexOfDefn(client, defn)
+	if createErr == nil {
+		return nil
+	}
+	if err := createIndexOfDefn(client, current.Definition); err != nil {
+		return fmt.Errorf("%v. Fail to restore the dropped index: %v", createErr, err)
+	}
+	return fmt.Errorf("%v. The dropped index is restored", createErr)
+}
+
+func createIndexOfDefn(client *qclient.GsiClient, defn *c.IndexDefn) error {
+
+	with := map[string]interface{}{"defer_build": defn.Deferred}
+	if len(defn.Nodes) > 0 {
+		with["nodes"] = defn.Nodes
 	}
+	if defn.NumReplica > 0 {
+		with["num_replica"] = defn.NumReplica
+	}
+	if defn.NumPartitions > 0 {
+		with["num_partition"] = defn.NumPartitions
+	}
+	withb, err := json.Marshal(with)
+	if err != nil {
+		return err
+	}
+
+	using, exprType, scheme := string(defn.Using), string(defn.ExprType), defn.PartitionScheme
+	if using == "" {
+		using = "gsi"
+	}
+	if exprType == "" {
+		exprType = c.N1QL
+	}
+	if scheme == "" {
+		scheme = c.SINGLE
+	}
+	_, err = client.CreateIndex3(
+		defn.Name, defn.Bucket, using, exprType, defn.WhereExpr,
+		defn.SecExprs, defn.Desc, defn.IsPrimary, scheme, defn.PartitionKeys,
+		withb)
 	return err
 }
 
+func printDryRunResult(w io.Writer, result *mclient.DryRunResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Dry run, nothing committed:\n%s\n", data)
+	return nil
+}
+
 func printIndexInfo(w io.Writer, index *mclient.IndexMetadata) {
 	defn := index.Definition
 	fmt.Fprintf(w, "Index:%s/%s, Id:%v, Using:%s, Exprs:%v, isPrimary:%v\n",
@@ -595,6 +759,10 @@ func validate(cmd *Command, fset *flag.FlagSet) error {
 		have = []string{"type", "server", "auth"}
 		dont = []string{"h", "index", "bucket", "where", "fields", "primary", "with", "indexes", "low", "high", "equal", "incl", "limit", "distinct"}
 
+	case "apply":
+		have = []string{"type", "server", "auth", "file"}
+		dont = []string{"h", "index", "bucket", "where", "fields", "primary", "with", "indexes", "low", "high", "equal", "incl", "limit", "distinct", "ckey", "cval"}
+
 	default:
 		return fmt.Errorf("Specified operation type '%s' has no validation rule. Please add one to use.", cmd.OpType)
 	}
@@ -0,0 +1,225 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/query/value"
+)
+
+//
+// Aggregate pushdown over partitions.
+//
+// When group keys do not match the partition keys, a group can span
+// partitions, and each partition returns partial aggregates for it.
+// If cbq-engine asks for full aggregates, the indexers are asked for
+// partial aggregates instead, and the broker combines the rows of the
+// same group before sending them.  COUNT, COUNTN and SUM are added up,
+// MIN and MAX are compared.  DISTINCT aggregates cannot be combined
+// from partial results, they are not pushed down over partitions.
+//
+// All groups are held in memory until the scan completes, limit and
+// offset are applied on the combined rows.
+//
+
+type aggrColumn struct {
+	grpKey   bool
+	aggrFunc common.AggrFuncType
+}
+
+type aggrCombiner struct {
+	columns []aggrColumn
+	groups  map[string]int
+	rows    [][]value.Value
+	skey    bool // rows are received as common.SecondaryKey
+
+	// limit and offset of the request, applied on combined rows
+	limit  int64
+	offset int64
+}
+
+// newAggrCombiner returns a combiner for rows of the projection, nil if
+// partial aggregates of grpAggr cannot be combined.
+func newAggrCombiner(grpAggr *GroupAggr, projection *IndexProjection) *aggrCombiner {
+
+	if grpAggr == nil || projection == nil || len(projection.EntryKeys) == 0 {
+		return nil
+	}
+
+	columns := make([]aggrColumn, len(projection.EntryKeys))
+	for i, entryId := range projection.EntryKeys {
+		found := false
+		for _, g := range grpAggr.Group {
+			if int64(g.EntryKeyId) == entryId {
+				columns[i] = aggrColumn{grpKey: true}
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, a := range grpAggr.Aggrs {
+			if int64(a.EntryKeyId) == entryId {
+				if a.Distinct || a.AggrFunc >= common.AGG_INVALID {
+					return nil
+				}
+				columns[i] = aggrColumn{aggrFunc: a.AggrFunc}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	return &aggrCombiner{
+		columns: columns,
+		groups:  make(map[string]int),
+	}
+}
+
+// add a row of partial aggregates.
+func (a *aggrCombiner) add(mskey []value.Value, uskey common.SecondaryKey) bool {
+
+	row := mskey
+	if row == nil {
+		a.skey = true
+		row = make([]value.Value, len(uskey))
+		for i, v := range uskey {
+			row[i] = value.NewValue(v)
+		}
+	}
+	if len(row) != len(a.columns) {
+		return false
+	}
+
+	var groupKey []interface{}
+	for i, col := range a.columns {
+		if col.grpKey {
+			groupKey = append(groupKey, row[i].Actual())
+		}
+	}
+	key, err := json.Marshal(groupKey)
+	if err != nil {
+		return false
+	}
+
+	pos, ok := a.groups[string(key)]
+	if !ok {
+		a.groups[string(key)] = len(a.rows)
+		a.rows = append(a.rows, row)
+		return true
+	}
+
+	curr := a.rows[pos]
+	for i, col := range a.columns {
+		if !col.grpKey {
+			curr[i] = combineAggr(col.aggrFunc, curr[i], row[i])
+		}
+	}
+	return true
+}
+
+// flush sends the combined rows, returns false if the sender stops the
+// scan.
+func (a *aggrCombiner) flush(sender ResponseSender) bool {
+
+	var count int64
+	for i, row := range a.rows {
+		if int64(i) < a.offset {
+			continue
+		}
+		if count >= a.limit {
+			break
+		}
+		count++
+
+		if a.skey {
+			skey := make(common.SecondaryKey, len(row))
+			for j, v := range row {
+				skey[j] = v.Actual()
+			}
+			if !sender(nil, nil, skey) {
+				return false
+			}
+		} else if !sender(nil, row, nil) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNullOrMissing(v value.Value) bool {
+	return v == nil || v.Type() == value.NULL || v.Type() == value.MISSING
+}
+
+func combineAggr(fn common.AggrFuncType, x, y value.Value) value.Value {
+
+	// partial aggregates of partitions without any value are null.
+	if isNullOrMissing(x) {
+		return y
+	} else if isNullOrMissing(y) {
+		return x
+	}
+
+	switch fn {
+	case common.AGG_COUNT, common.AGG_COUNTN, common.AGG_SUM:
+		return addNumbers(x, y)
+	case common.AGG_MIN:
+		if y.Collate(x) < 0 {
+			return y
+		}
+	case common.AGG_MAX:
+		if y.Collate(x) > 0 {
+			return y
+		}
+	}
+	return x
+}
+
+func addNumbers(x, y value.Value) value.Value {
+
+	xi, xok := toInt64(x.Actual())
+	yi, yok := toInt64(y.Actual())
+	if xok && yok {
+		return value.NewValue(xi + yi)
+	}
+	return value.NewValue(toFloat64(x.Actual()) + toFloat64(y.Actual()))
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < (1<<53) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/query/value"
+)
+
+func TestCombineAggr(t *testing.T) {
+	testcases := []struct {
+		fn       common.AggrFuncType
+		x, y     interface{}
+		expected interface{}
+	}{
+		{common.AGG_COUNT, 10, 20, int64(30)},
+		{common.AGG_COUNTN, int64(1), float64(2), int64(3)},
+		{common.AGG_SUM, 1.5, 2, 3.5},
+		{common.AGG_SUM, float64(1 << 60), 1, float64(1<<60) + 1},
+		{common.AGG_MIN, 10, 5, 5},
+		{common.AGG_MIN, "a", "b", "a"},
+		{common.AGG_MAX, 10, 5, 10},
+		{common.AGG_MAX, "a", "b", "b"},
+		// partitions without values return null partial aggregates.
+		{common.AGG_SUM, nil, 5, 5},
+		{common.AGG_MIN, 5, nil, 5},
+		{common.AGG_MAX, nil, nil, nil},
+	}
+	for i, tc := range testcases {
+		result := combineAggr(tc.fn, value.NewValue(tc.x), value.NewValue(tc.y))
+		if !equalValue(result, tc.expected) {
+			t.Errorf("%v: %v(%v, %v) expected %v, got %v",
+				i, tc.fn, tc.x, tc.y, tc.expected, result)
+		}
+	}
+}
+
+func equalValue(v value.Value, expected interface{}) bool {
+	if expected == nil {
+		return v.Type() == value.NULL
+	}
+	return v.Equals(value.NewValue(expected)).Truth()
+}
+
+func TestNewAggrCombiner(t *testing.T) {
+	grpAggr := &GroupAggr{
+		Group: []*GroupKey{{EntryKeyId: 1}},
+		Aggrs: []*Aggregate{
+			{AggrFunc: common.AGG_SUM, EntryKeyId: 2},
+			{AggrFunc: common.AGG_COUNT, EntryKeyId: 3, Distinct: true},
+		},
+	}
+
+	combiner := newAggrCombiner(grpAggr, &IndexProjection{EntryKeys: []int64{2, 1}})
+	if combiner == nil {
+		t.Fatal("expected combiner")
+	}
+	expected := []aggrColumn{{aggrFunc: common.AGG_SUM}, {grpKey: true}}
+	if !reflect.DeepEqual(combiner.columns, expected) {
+		t.Errorf("expected columns %v, got %v", expected, combiner.columns)
+	}
+
+	// distinct aggregates and unknown entry keys cannot be combined.
+	for _, keys := range [][]int64{{1, 3}, {1, 4}, {}} {
+		if newAggrCombiner(grpAggr, &IndexProjection{EntryKeys: keys}) != nil {
+			t.Errorf("%v: expected no combiner", keys)
+		}
+	}
+	if newAggrCombiner(nil, &IndexProjection{EntryKeys: []int64{1}}) != nil {
+		t.Errorf("expected no combiner without group aggregates")
+	}
+}
+
+func TestAggrCombinerRows(t *testing.T) {
+	newCombiner := func() *aggrCombiner {
+		return &aggrCombiner{
+			columns: []aggrColumn{
+				{grpKey: true}, {aggrFunc: common.AGG_COUNT}, {aggrFunc: common.AGG_MAX},
+			},
+			groups: make(map[string]int),
+			limit:  100,
+		}
+	}
+	rows := []common.SecondaryKey{
+		{"a", 1, 10}, {"b", 2, 5}, {"a", 3, 20}, {"c", 1, nil}, {"b", 1, 1},
+	}
+
+	// rows received as values.
+	combiner := newCombiner()
+	for _, row := range rows {
+		vals := make([]value.Value, len(row))
+		for i, v := range row {
+			vals[i] = value.NewValue(v)
+		}
+		if !combiner.add(vals, nil) {
+			t.Fatalf("failed to add %v", row)
+		}
+	}
+	if combiner.add([]value.Value{value.NewValue("a")}, nil) {
+		t.Errorf("expected row with missing columns to be rejected")
+	}
+
+	var result [][]value.Value
+	combiner.flush(func(pkey []byte, mskey []value.Value, uskey common.SecondaryKey) bool {
+		result = append(result, mskey)
+		return true
+	})
+	expected := [][]interface{}{{"a", 4, 20}, {"b", 3, 5}, {"c", 1, nil}}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for i, row := range result {
+		for j, v := range row {
+			if !equalValue(v, expected[i][j]) {
+				t.Errorf("row %v: expected %v, got %v", i, expected[i], row)
+				break
+			}
+		}
+	}
+
+	// rows received as secondary keys, with offset and limit.
+	combiner = newCombiner()
+	combiner.offset, combiner.limit = 1, 1
+	for _, row := range rows {
+		combiner.add(nil, row)
+	}
+	var skeys []common.SecondaryKey
+	combiner.flush(func(pkey []byte, mskey []value.Value, uskey common.SecondaryKey) bool {
+		skeys = append(skeys, uskey)
+		return true
+	})
+	if len(skeys) != 1 || skeys[0][0] != "b" {
+		t.Errorf("expected group b, got %v", skeys)
+	}
+
+	// flush stops when the sender stops.
+	combiner.offset, combiner.limit = 0, 100
+	n := 0
+	if combiner.flush(func(pkey []byte, mskey []value.Value, uskey common.SecondaryKey) bool {
+		n++
+		return false
+	}) || n != 1 {
+		t.Errorf("expected flush to stop after 1 row, sent %v", n)
+	}
+}
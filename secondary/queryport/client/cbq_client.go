@@ -226,7 +226,8 @@ func (b *cbqClient) GetScanports() (queryports []string) {
 func (b *cbqClient) GetScanport(
 	defnID uint64,
 	excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
-	skips map[common.IndexDefnId]bool) (queryport []string,
+	skips map[common.IndexDefnId]bool,
+	readPref common.ReadPreference) (queryport []string,
 	targetDefnID uint64, targetIndstID []uint64, rollbackTime []int64,
 	partition [][]common.PartitionId, numPartition uint32, ok bool) {
 
@@ -258,6 +259,11 @@ func (b *cbqClient) IndexState(defnID uint64) (common.IndexState, error) {
 	return common.INDEX_STATE_ACTIVE, nil
 }
 
+// IsIndexAvailable implement BridgeAccessor{} interface.
+func (b *cbqClient) IsIndexAvailable(defnID uint64) bool {
+	return true
+}
+
 // IsPrimary implement BridgeAccessor{} interface.
 func (b *cbqClient) IsPrimary(defnID uint64) bool {
 	return false
@@ -12,6 +12,7 @@ import "time"
 import "unsafe"
 import "io"
 import "net"
+import "sync"
 import "sync/atomic"
 import "fmt"
 import "syscall"
@@ -20,6 +21,7 @@ import "strings"
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/common"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 import "github.com/couchbase/query/value"
 
 // TODO:
@@ -47,6 +49,24 @@ type ResponseReader interface {
 	Error() error
 }
 
+// partialResponse is a response of a partial scan, listing vbuckets
+// missing in the results.
+type partialResponse interface {
+	GetMissingVbuckets() []uint32
+}
+
+// snapshotResponse is a response carrying the timestamp of the snapshot
+// scanned by an indexer.
+type snapshotResponse interface {
+	GetSnapshotTs() *protobuf.TsConsistency
+}
+
+// estimateResponse is a response carrying the result size of a scan,
+// estimated by an indexer from key statistics.
+type estimateResponse interface {
+	GetEstimate() (uint64, bool)
+}
+
 // ResponseSender is responsible for forwarding result to the client
 // after streams from multiple servers/ResponseHandler have been merged.
 // mskey - marshalled sec key (as Value)
@@ -76,6 +96,16 @@ type Inclusion uint32
 
 type Scans []*Scan
 
+// Span is a range of the index key between Low and High, scanned along
+// with other spans by MultiRange.
+type Span struct {
+	Low       common.SecondaryKey
+	High      common.SecondaryKey
+	Inclusion Inclusion
+}
+
+type Spans []*Span
+
 type Scan struct {
 	Seek   common.SecondaryKey
 	Filter []*CompositeElementFilter
@@ -85,11 +115,43 @@ type CompositeElementFilter struct {
 	Low       interface{}
 	High      interface{}
 	Inclusion Inclusion
+	// NULL and MISSING values of the element are included or excluded
+	// independent of the range, instead of bounding it with null.
+	Nulls   NullMissingInclusion
+	Missing NullMissingInclusion
+}
+
+// NullMissingInclusion controls how NULL or MISSING values of an element
+// are treated by a CompositeElementFilter.
+type NullMissingInclusion uint32
+
+const (
+	// NullMissingByRange includes values within low-key and high-key
+	NullMissingByRange NullMissingInclusion = iota
+	// NullMissingInclude includes values, even outside low-key and high-key
+	NullMissingInclude
+	// NullMissingExclude excludes values, even within low-key and high-key
+	NullMissingExclude
+)
+
+// IntersectScan is one of the index scans whose docids are intersected
+// by GsiScanClient.Intersect.
+type IntersectScan struct {
+	DefnID       uint64
+	Scans        Scans
+	RollbackTime int64
+	Partitions   []common.PartitionId
 }
 
 type IndexProjection struct {
 	EntryKeys  []int64
 	PrimaryKey bool
+	// DocIdOnly returns only docids, in a packed encoding, and no
+	// secondary keys. Results are not ordered across partitions.
+	DocIdOnly bool
+	// DocMeta returns [cas, expiry, size] of documents as the secondary
+	// key of primary index entries. Index should be created with doc_meta.
+	DocMeta bool
 }
 
 //Groupby/Aggregate
@@ -196,11 +258,12 @@ type BridgeAccessor interface {
 	// if `retry` is ZERO, pick the indexer under least
 	// load, else do a round-robin, based on the retry count,
 	// if more than one indexer is found hosing the index or an
-	// equivalent index.
+	// equivalent index. Replicas are picked as per `readPref`.
 	GetScanport(
 		defnID uint64,
 		excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
-		skips map[common.IndexDefnId]bool) (queryport []string, targetDefnID uint64, targetInstID []uint64,
+		skips map[common.IndexDefnId]bool,
+		readPref common.ReadPreference) (queryport []string, targetDefnID uint64, targetInstID []uint64,
 		rollbackTime []int64, partition [][]common.PartitionId, numPartitions uint32, ok bool)
 
 	// GetIndexDefn will return the index-definition structure for defnID.
@@ -215,6 +278,11 @@ type BridgeAccessor interface {
 	// IndexState returns the current state of index `defnID` and error.
 	IndexState(defnID uint64) (common.IndexState, error)
 
+	// IsIndexAvailable returns false if index `defnID`, and all its
+	// equivalent indexes, have partitions residing only on dead
+	// indexer nodes.
+	IsIndexAvailable(defnID uint64) bool
+
 	// IsPrimary returns whether index is on primary key.
 	IsPrimary(defnID uint64) bool
 
@@ -516,6 +584,60 @@ func (c *GsiClient) CreateIndex3(
 	return defnID, err
 }
 
+// DryRunCreateIndex runs the validations, prepare phase, equivalent
+// index checks, planner placement and size estimation of a create index
+// request, and returns the would-be plan without creating the index.
+func (c *GsiClient) DryRunCreateIndex(
+	name, bucket, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	with []byte) (*mclient.DryRunResult, error) {
+
+	if err := common.IsValidIndexName(name); err != nil {
+		return nil, err
+	}
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+	bridge, ok := c.bridge.(*metadataClient)
+	if !ok {
+		return nil, ErrorNotImplemented
+	}
+	return bridge.DryRunCreateIndex(
+		name, bucket, using, exprType, whereExpr,
+		secExprs, desc, isPrimary, scheme, partitionKeys, with)
+}
+
+// DryRunDropIndex validates a drop index request and returns the
+// instances that would be dropped, without dropping the index.
+func (c *GsiClient) DryRunDropIndex(defnID uint64) (*mclient.DryRunResult, error) {
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+	bridge, ok := c.bridge.(*metadataClient)
+	if !ok {
+		return nil, ErrorNotImplemented
+	}
+	return bridge.DryRunDropIndex(defnID)
+}
+
+// DryRunMoveIndex validates an alter index request moving the index to
+// the nodes in `with`, and returns the would-be placement without moving
+// the index.
+func (c *GsiClient) DryRunMoveIndex(
+	defnID uint64, with map[string]interface{}) (*mclient.DryRunResult, error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+	bridge, ok := c.bridge.(*metadataClient)
+	if !ok {
+		return nil, ErrorNotImplemented
+	}
+	return bridge.DryRunMoveIndex(defnID, with)
+}
+
 // BuildIndexes implements BridgeAccessor{} interface.
 func (c *GsiClient) BuildIndexes(defnIDs []uint64) error {
 	if c.bridge == nil {
@@ -696,6 +818,73 @@ func (c *GsiClient) RangeInternal(
 	return
 }
 
+// MultiRange scans index for several spans in a single request, limit
+// applies to all spans together. On primary index, entries are returned
+// in key order and entries within overlapping spans are returned once.
+// Spans on secondary index are scanned in order of their low key and
+// must not overlap. Indexers that predate multi-range scans return the
+// entries between the lowest and the highest key of spans.
+func (c *GsiClient) MultiRange(
+	defnID uint64, requestId string, spans Spans,
+	distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) (err error) {
+
+	broker := makeDefaultRequestBroker(callb)
+	return c.MultiRangeInternal(defnID, requestId, spans, distinct, limit, cons, vector, broker)
+}
+
+// MultiRange scans index for several spans in a single request.
+func (c *GsiClient) MultiRangeInternal(
+	defnID uint64, requestId string, spans Spans,
+	distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	broker *RequestBroker) (err error) {
+
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err = c.bridge.IndexState(defnID); err != nil {
+		return err
+	}
+
+	begin := time.Now()
+
+	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
+		handler ResponseHandler) (error, bool) {
+		var err error
+
+		vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
+		if err != nil {
+			return err, false
+		}
+
+		bound, ranges, err := encodeSpans(spans, c.bridge.IsPrimary(uint64(index.DefnId)))
+		if err != nil {
+			return err, false
+		} else if len(ranges) == 0 {
+			return nil, true
+		}
+		return qc.MultiRange(
+			uint64(index.DefnId), requestId, bound, ranges, distinct,
+			broker.GetLimit(), cons, vector, handler, rollbackTime, partitions)
+	}
+
+	broker.SetScanRequestHandler(handler)
+	broker.SetLimit(limit)
+
+	_, err = c.doScan(defnID, requestId, broker)
+	if err != nil { // callback with error
+		return err
+	}
+
+	fmsg := "MultiRange {%v,%v} - elapsed(%v) err(%v)"
+	logging.Verbosef(fmsg, defnID, requestId, time.Since(begin), err)
+	return
+}
+
 // ScanAll for full table scan.
 func (c *GsiClient) ScanAll(
 	defnID uint64, requestId string, limit int64,
@@ -785,12 +974,12 @@ func (c *GsiClient) MultiScanInternal(
 
 		if c.bridge.IsPrimary(uint64(index.DefnId)) {
 			return qc.MultiScanPrimary(
-				uint64(index.DefnId), requestId, scans, reverse, distinct,
+				uint64(index.DefnId), requestId, broker.ScansForPartitions(partitions), reverse, distinct,
 				projection, broker.GetOffset(), broker.GetLimit(), cons, vector, handler, rollbackTime, partitions)
 		}
 
 		return qc.MultiScan(
-			uint64(index.DefnId), requestId, scans, reverse, distinct,
+			uint64(index.DefnId), requestId, broker.ScansForPartitions(partitions), reverse, distinct,
 			projection, broker.GetOffset(), broker.GetLimit(), cons, vector, handler, rollbackTime, partitions)
 	}
 
@@ -941,6 +1130,105 @@ func (c *GsiClient) CountRangeInternal(
 	return count, err
 }
 
+// MinRange returns the smallest value of the leading key of the index
+// in the given range, computed by the indexer, or nil if the range has
+// no non-null value. A nil low or high leaves the range unbounded.
+func (c *GsiClient) MinRange(
+	defnID uint64, requestId string,
+	low, high interface{}, inclusion Inclusion,
+	cons common.Consistency, vector *TsConsistency) (interface{}, error) {
+
+	return c.aggregateRange(defnID, requestId, common.AGG_MIN, low, high, inclusion, cons, vector)
+}
+
+// MaxRange returns the largest value of the leading key of the index
+// in the given range, computed by the indexer, or nil if the range has
+// no non-null value. A nil low or high leaves the range unbounded.
+func (c *GsiClient) MaxRange(
+	defnID uint64, requestId string,
+	low, high interface{}, inclusion Inclusion,
+	cons common.Consistency, vector *TsConsistency) (interface{}, error) {
+
+	return c.aggregateRange(defnID, requestId, common.AGG_MAX, low, high, inclusion, cons, vector)
+}
+
+// aggregateRange pushes down aggrFunc on the leading key, over the
+// range, to the indexer. Partitions or replicas may each return a
+// partial aggregate, which are reduced here.
+func (c *GsiClient) aggregateRange(
+	defnID uint64, requestId string, aggrFunc common.AggrFuncType,
+	low, high interface{}, inclusion Inclusion,
+	cons common.Consistency, vector *TsConsistency) (interface{}, error) {
+
+	const entryKeyId = 1
+	groupAggr := &GroupAggr{
+		Name:               "aggregateRange",
+		Aggrs:              []*Aggregate{{AggrFunc: aggrFunc, EntryKeyId: entryKeyId, KeyPos: 0}},
+		DependsOnIndexKeys: []int32{0},
+		AllowPartialAggr:   true,
+	}
+	projection := &IndexProjection{EntryKeys: []int64{entryKeyId}}
+
+	aggr := &rangeAggregate{aggrFunc: aggrFunc}
+	err := c.Scan3(defnID, requestId, rangeScans(low, high, inclusion), false, false,
+		projection, 0, 0, groupAggr, nil, cons, vector, aggr.add)
+	if err == nil {
+		err = aggr.err
+	}
+	if err != nil || aggr.result == nil {
+		return nil, err
+	}
+	return aggr.result.Actual(), nil
+}
+
+// rangeScans returns the scan of the leading key over the range, nil
+// bounds are unbounded.
+func rangeScans(low, high interface{}, inclusion Inclusion) Scans {
+	filter := &CompositeElementFilter{Low: low, High: high, Inclusion: inclusion}
+	if low == nil {
+		filter.Low = common.MinUnbounded
+	}
+	if high == nil {
+		filter.High = common.MaxUnbounded
+	}
+	return Scans{&Scan{Filter: []*CompositeElementFilter{filter}}}
+}
+
+// rangeAggregate reduces the partial MIN or MAX aggregates of a range,
+// null values are ignored.
+type rangeAggregate struct {
+	aggrFunc common.AggrFuncType
+	result   value.Value
+	err      error
+}
+
+func (aggr *rangeAggregate) add(resp ResponseReader) bool {
+	if err := resp.Error(); err != nil {
+		aggr.err = err
+		return false
+	}
+	skeys, _, err := resp.GetEntries()
+	if err != nil {
+		aggr.err = err
+		return false
+	}
+	for _, skey := range skeys {
+		if len(skey) == 0 || skey[0] == nil {
+			continue
+		}
+		val := value.NewValue(skey[0])
+		if val.Type() <= value.NULL {
+			continue
+		}
+		if aggr.result == nil ||
+			(aggr.aggrFunc == common.AGG_MIN && val.Collate(aggr.result) < 0) ||
+			(aggr.aggrFunc == common.AGG_MAX && val.Collate(aggr.result) > 0) {
+			aggr.result = val
+		}
+	}
+	return true
+}
+
 func (c *GsiClient) MultiScanCount(
 	defnID uint64, requestId string,
 	scans Scans, distinct bool,
@@ -1026,22 +1314,22 @@ func (c *GsiClient) Scan3Internal(
 
 	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
 		handler ResponseHandler) (error, bool) {
-		var err error
-
-		vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
+		vector, err := broker.PinConsistency(func() (*TsConsistency, error) {
+			return c.getConsistency(qc, cons, vector, index.Bucket)
+		})
 		if err != nil {
 			return err, false
 		}
 
 		if c.bridge.IsPrimary(uint64(index.DefnId)) {
 			return qc.Scan3Primary(
-				uint64(index.DefnId), requestId, scans, reverse, distinct,
-				projection, broker.GetOffset(), broker.GetLimit(), groupAggr, broker.GetSorted(), cons, vector, handler, rollbackTime, partitions)
+				uint64(index.DefnId), requestId, broker.ScansForPartitions(partitions), reverse, distinct,
+				projection, broker.GetOffset(), broker.GetLimit(), broker.GetGroupAggr(), broker.GetSorted(), cons, vector, broker.GetStaleness(), broker.GetAllowPartial(), broker.GetReturnSnapshotTs(), handler, rollbackTime, partitions)
 		}
 
 		return qc.Scan3(
-			uint64(index.DefnId), requestId, scans, reverse, distinct,
-			projection, broker.GetOffset(), broker.GetLimit(), groupAggr, broker.GetSorted(), cons, vector, handler, rollbackTime, partitions)
+			uint64(index.DefnId), requestId, broker.ScansForPartitions(partitions), reverse, distinct,
+			projection, broker.GetOffset(), broker.GetLimit(), broker.GetGroupAggr(), broker.GetSorted(), cons, vector, broker.GetStaleness(), broker.GetAllowPartial(), broker.GetReturnSnapshotTs(), handler, rollbackTime, partitions)
 	}
 
 	broker.SetScanRequestHandler(handler)
@@ -1053,6 +1341,7 @@ func (c *GsiClient) Scan3Internal(
 	broker.SetSorted(indexOrder != nil)
 	broker.SetDistinct(distinct)
 	broker.SetIndexOrder(indexOrder)
+	broker.SetResumable(!reverse && !distinct && groupAggr == nil)
 
 	_, err = c.doScan(defnID, requestId, broker)
 	if err != nil { // callback with error
@@ -1064,6 +1353,191 @@ func (c *GsiClient) Scan3Internal(
 	return
 }
 
+// PartialScan3 is Scan3 with partial results. Indexers skip vbuckets
+// quarantined after repeated stream repair failures, or failed over and
+// being repaired, instead of failing or waiting for them, and the skipped
+// vbuckets are returned. Entries of missing
+// vbuckets may not satisfy the requested consistency.
+func (c *GsiClient) PartialScan3(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) (missingVbs []uint16, err error) {
+
+	broker := makeDefaultRequestBroker(callb)
+	broker.SetAllowPartial(true)
+	err = c.Scan3Internal(defnID, requestId, scans, reverse, distinct,
+		projection, offset, limit, groupAggr, indexOrder, cons, vector, broker)
+	if err != nil {
+		return nil, err
+	}
+	return broker.MissingVbuckets(), nil
+}
+
+// Scan3WithReadPreference is Scan3 picking the replicas of the index as
+// per readPref, overriding the read preference configured for the
+// client. ReadReplicaOk offloads heavy scans to replicas, and falls back
+// to the primary instance when no replica is available.
+func (c *GsiClient) Scan3WithReadPreference(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	cons common.Consistency, vector *TsConsistency,
+	readPref common.ReadPreference, callb ResponseHandler) (err error) {
+
+	broker := makeDefaultRequestBroker(callb)
+	broker.SetReadPreference(readPref)
+	return c.Scan3Internal(defnID, requestId, scans, reverse, distinct,
+		projection, offset, limit, groupAggr, indexOrder, cons, vector, broker)
+}
+
+// Scan3WithStaleness is Scan3 with StalenessConsistency, tolerating the
+// index lagging behind KV by the given bound. Scans that can live with
+// slightly stale results avoid waiting for indexers to catch up with KV.
+func (c *GsiClient) Scan3WithStaleness(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	staleness *StalenessBound, callb ResponseHandler) (err error) {
+
+	if staleness == nil {
+		return ErrorInvalidConsistency
+	}
+	broker := makeDefaultRequestBroker(callb)
+	broker.SetStaleness(staleness)
+	return c.Scan3Internal(defnID, requestId, scans, reverse, distinct,
+		projection, offset, limit, groupAggr, indexOrder,
+		common.StalenessConsistency, nil, broker)
+}
+
+// Scan3WithToken is Scan3 returning a consistency token, the timestamp
+// of the index snapshots scanned. Passing the token back as vector of a
+// QueryConsistency scan makes the scan at least as fresh as this one,
+// so that applications can chain reads without SessionConsistency.
+func (c *GsiClient) Scan3WithToken(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) (token *TsConsistency, err error) {
+
+	broker := makeDefaultRequestBroker(callb)
+	broker.SetReturnSnapshotTs(true)
+	err = c.Scan3Internal(defnID, requestId, scans, reverse, distinct,
+		projection, offset, limit, groupAggr, indexOrder, cons, vector, broker)
+	if err != nil {
+		return nil, err
+	}
+	return broker.SnapshotTs(), nil
+}
+
+// Scan3WithEstimate is Scan3 also returning the number of rows of the
+// scan estimated by indexers from key statistics, before the rows are
+// sent. The estimate is -1 if key statistics are not available on all
+// indexers scanned.
+func (c *GsiClient) Scan3WithEstimate(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) (estimatedRows int64, err error) {
+
+	broker := makeDefaultRequestBroker(callb)
+	err = c.Scan3Internal(defnID, requestId, scans, reverse, distinct,
+		projection, offset, limit, groupAggr, indexOrder, cons, vector, broker)
+	if err != nil {
+		return -1, err
+	}
+	return broker.EstimatedRows(), nil
+}
+
+// EstimateScan3 estimates the number of index entries qualified by
+// scans, from key statistics maintained by indexers, without scanning
+// the index. Predicates on the leading key are used for the estimate.
+func (c *GsiClient) EstimateScan3(
+	defnID uint64, requestId string, scans Scans) (count int64, err error) {
+
+	if c.bridge == nil {
+		return count, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return 0, err
+	}
+
+	begin := time.Now()
+
+	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId) (int64, error, bool) {
+		count, err := qc.EstimateScan3(uint64(index.DefnId), requestId, scans, rollbackTime, partitions)
+		return count, err, false
+	}
+
+	broker := makeDefaultRequestBroker(nil)
+	broker.SetCountRequestHandler(handler)
+
+	count, err = c.doScan(defnID, requestId, broker)
+
+	fmsg := "EstimateScan3 {%v,%v} - elapsed(%v) err(%v)"
+	logging.Verbosef(fmsg, defnID, requestId, time.Since(begin), err)
+	return count, err
+}
+
+// ExplainScan3 runs the scan without returning rows, and returns the
+// json encoded trace of the scan by each indexer hosting partitions of
+// the index, describing the snapshot, spans, partitions, timings and
+// rows filtered.
+func (c *GsiClient) ExplainScan3(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	cons common.Consistency, vector *TsConsistency) (explains [][]byte, err error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return nil, err
+	}
+	if c.bridge.IsPrimary(defnID) {
+		return nil, ErrorNotImplemented
+	}
+
+	begin := time.Now()
+
+	var mu sync.Mutex
+	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId) (int64, error, bool) {
+		vector, err := c.getConsistency(qc, cons, vector, index.Bucket)
+		if err != nil {
+			return 0, err, false
+		}
+
+		explain, err := qc.ExplainScan3(
+			uint64(index.DefnId), requestId, scans, reverse, distinct, projection, offset, limit,
+			groupAggr, indexOrder != nil, cons, vector, rollbackTime, partitions)
+		if err != nil {
+			return 0, err, false
+		}
+
+		mu.Lock()
+		explains = append(explains, explain)
+		mu.Unlock()
+		return 0, nil, false
+	}
+
+	broker := makeDefaultRequestBroker(nil)
+	broker.SetCountRequestHandler(handler)
+
+	_, err = c.doScan(defnID, requestId, broker)
+
+	fmsg := "ExplainScan3 {%v,%v} - elapsed(%v) err(%v)"
+	logging.Verbosef(fmsg, defnID, requestId, time.Since(begin), err)
+	return explains, err
+}
+
 // DescribeError return error description as human readable string.
 func (c *GsiClient) DescribeError(err error) string {
 	if desc, ok := errorDescriptions[err.Error()]; ok {
@@ -1135,6 +1609,19 @@ func (c *GsiClient) updateScanClients() {
 	}
 }
 
+// pingQueryport sends a heartbeat to the indexer on queryport, through
+// its scan client, so that heartbeats are authenticated and encrypted
+// like scans.
+func (c *GsiClient) pingQueryport(queryport string) error {
+	qcs := *((*map[string]*GsiScanClient)(atomic.LoadPointer(&c.queryClients)))
+	qc, ok := qcs[queryport]
+	if !ok {
+		return ErrorNoHost
+	}
+	_, err := qc.Helo()
+	return err
+}
+
 func (c *GsiClient) getScanClients(queryports []string) ([]*GsiScanClient, bool) {
 
 	qcs := *((*map[string]*GsiScanClient)(atomic.LoadPointer(&c.queryClients)))
@@ -1240,10 +1727,17 @@ func (c *GsiClient) doScan(defnID uint64, requestId string, broker *RequestBroke
 	wait := c.config["retryIntervalScanport"].Int()
 	retry := c.config["retryScanPort"].Int()
 	evictRetry := c.config["settings.poolSize"].Int()
+	readPref := broker.ReadPreference(c.settings.ReadPreference())
 	for i := 0; true; {
 		foundScanport := false
 
-		if queryports, targetDefnID, targetInstIds, rollbackTimes, partitions, numPartitions, ok := c.bridge.GetScanport(defnID, excludes, skips); ok {
+		if !c.bridge.IsIndexAvailable(defnID) {
+			logging.Warnf("Index %v is unavailable, nodes hosting the index are down, reqId:%v\n",
+				defnID, requestId)
+			return 0, ErrorIndexUnavailable
+		}
+
+		if queryports, targetDefnID, targetInstIds, rollbackTimes, partitions, numPartitions, ok := c.bridge.GetScanport(defnID, excludes, skips, readPref); ok {
 
 			index := c.bridge.GetIndexDefn(targetDefnID)
 			start := time.Now()
@@ -1259,7 +1753,17 @@ func (c *GsiClient) doScan(defnID uint64, requestId string, broker *RequestBroke
 				}
 
 				excludes = c.updateExcludes(defnID, excludes, scan_errs)
-				if len(scan_errs) != 0 && !isAnyGone(scan_errs) && partial {
+				if partial && isAnyGone(scan_errs) && broker.CanResume() && evictRetry > 0 {
+					// indexer went down after sending rows. Retry with the same
+					// consistency on a replica or the restarted indexer, skipping
+					// the rows already sent.
+					logging.Warnf("Scan failed after sending %v rows for index %v.  Resuming scan, reqId:%v : %v ...\n",
+						broker.SendCount(), defnID, requestId, getScanError(scan_errs))
+					broker.Resume()
+					evictRetry--
+					continue
+
+				} else if len(scan_errs) != 0 && !isAnyGone(scan_errs) && partial {
 					// partially succeeded scans, we don't reset-hash and we don't retry
 					return 0, getScanError(scan_errs)
 
@@ -1367,7 +1871,7 @@ func (c *GsiClient) getConsistency(
 		} else {
 			vector = nil
 		}
-	} else if cons == common.AnyConsistency {
+	} else if cons == common.AnyConsistency || cons == common.StalenessConsistency {
 		vector = nil
 	} else {
 		return nil, ErrorInvalidConsistency
@@ -1429,7 +1933,7 @@ func makeWithMetaProvider(
 		killch:       make(chan bool, 1),
 	}
 	atomic.StorePointer(&c.bucketHash, (unsafe.Pointer)(new(map[string]uint64)))
-	c.bridge, err = newMetaBridgeClient(cluster, config, c.metaCh, c.settings)
+	c.bridge, err = newMetaBridgeClient(cluster, config, c.metaCh, c.settings, c.pingQueryport)
 	if err != nil {
 		return nil, err
 	}
@@ -1464,6 +1968,10 @@ func (c *GsiClient) logstats(killch chan bool) {
 		case <-tick.C:
 			logging.Infof("num concurrent scans {%v}", atomic.LoadInt64(&c.numScans))
 			logging.Infof("average scan response {%v ms}", atomic.LoadInt64(&c.scanResponse)/int64(time.Millisecond))
+			for _, m := range GetNodeMetrics() {
+				logging.Infof("indexer %v requests {%v} errors {%v} recent latency {%v ms}",
+					m.Queryport, m.Requests, m.Errors, m.RecentLatency/int64(time.Millisecond))
+			}
 		case <-killch:
 			return
 		}
@@ -1500,6 +2008,17 @@ func NewTsConsistency(
 	return &TsConsistency{Vbnos: vbnos, Seqnos: seqnos, Vbuuids: vbuuids}
 }
 
+// StalenessBound specifies the staleness tolerated by a scan with
+// StalenessConsistency. The scan proceeds if the index is behind KV by
+// at most Seqnos on every vbucket, or was caught up with KV within the
+// last Time. Otherwise the scan waits for the index to catch up if Wait
+// is set, or fails.
+type StalenessBound struct {
+	Seqnos uint64
+	Time   time.Duration
+	Wait   bool
+}
+
 // Override vbucket's {seqno, vbuuid} in the timestamp-vector,
 // if vbucket is not present in the vector, append them to vector.
 func (ts *TsConsistency) Override(
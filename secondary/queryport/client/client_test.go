@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/query/value"
+)
+
+// testResponse is a response of partial aggregates.
+type testResponse struct {
+	skeys []common.SecondaryKey
+	err   error
+}
+
+func (r *testResponse) GetEntries() ([]common.SecondaryKey, [][]byte, error) {
+	return r.skeys, nil, nil
+}
+
+func (r *testResponse) Error() error {
+	return r.err
+}
+
+func TestRangeScans(t *testing.T) {
+	testcases := []struct {
+		low, high interface{}
+		inclusion Inclusion
+		encLow    string
+		encHigh   string
+	}{
+		{10.0, 20.0, Both, `10`, `20`},
+		{10.0, 20.0, Neither, `10`, `20`},
+		{10.0, 20.0, Low, `10`, `20`},
+		{10.0, 20.0, High, `10`, `20`},
+		{nil, 20.0, High, ``, `20`},
+		{"a", nil, Low, `"a"`, ``},
+		{nil, nil, Neither, ``, ``},
+	}
+	for _, tc := range testcases {
+		scans := rangeScans(tc.low, tc.high, tc.inclusion)
+		if len(scans) != 1 || len(scans[0].Filter) != 1 {
+			t.Fatalf("%v %v: expected a single filter, got %v", tc.low, tc.high, scans)
+		}
+		filter := scans[0].Filter[0]
+		if filter.Inclusion != tc.inclusion {
+			t.Errorf("%v %v: expected inclusion %v, got %v",
+				tc.low, tc.high, tc.inclusion, filter.Inclusion)
+		}
+		if (tc.low == nil && filter.Low != common.MinUnbounded) ||
+			(tc.high == nil && filter.High != common.MaxUnbounded) {
+			t.Errorf("%v %v: expected unbounded filter, got %v %v",
+				tc.low, tc.high, filter.Low, filter.High)
+		}
+
+		protoScans, err := marshallScans(scans)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fl := protoScans[0].GetFilters()[0]
+		if string(fl.GetLow()) != tc.encLow || string(fl.GetHigh()) != tc.encHigh ||
+			fl.GetInclusion() != uint32(tc.inclusion) {
+			t.Errorf("%v %v: unexpected encoded filter %v", tc.low, tc.high, fl)
+		}
+	}
+}
+
+func TestRangeAggregate(t *testing.T) {
+	partials := []*testResponse{
+		{skeys: []common.SecondaryKey{{15.0}}},
+		{skeys: []common.SecondaryKey{{nil}, {}}},
+		{skeys: []common.SecondaryKey{{"a"}, {3.0}}},
+		{skeys: []common.SecondaryKey{{[]interface{}{1.0}}}},
+	}
+
+	min := &rangeAggregate{aggrFunc: common.AGG_MIN}
+	max := &rangeAggregate{aggrFunc: common.AGG_MAX}
+	for _, resp := range partials {
+		if !min.add(resp) || !max.add(resp) {
+			t.Fatalf("Unexpected end of aggregate")
+		}
+	}
+	if min.result.Actual() != 3.0 {
+		t.Errorf("Expected min 3, got %v", min.result)
+	}
+	if max.result.Type() != value.ARRAY {
+		t.Errorf("Expected max of collated types, got %v", max.result)
+	}
+
+	// only nulls
+	aggr := &rangeAggregate{aggrFunc: common.AGG_MAX}
+	aggr.add(partials[1])
+	if aggr.result != nil {
+		t.Errorf("Expected no result, got %v", aggr.result)
+	}
+
+	err := errors.New("scan failed")
+	if aggr.add(&testResponse{err: err}) || aggr.err != err {
+		t.Errorf("Expected error %v, got %v", err, aggr.err)
+	}
+}
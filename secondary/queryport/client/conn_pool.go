@@ -1,14 +1,17 @@
 package client
 
+import "crypto/tls"
 import "errors"
 import "fmt"
 import "net"
 import "time"
 import "sync/atomic"
 
+import "github.com/couchbase/indexing/secondary/common"
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/transport"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/golang/protobuf/proto"
 import gometrics "github.com/rcrowley/go-metrics"
 
 const (
@@ -42,6 +45,9 @@ type connectionPool struct {
 	relConnBatchSize int32
 	stopCh           chan bool
 	ewma             gometrics.EWMA
+	tlsConfig        *tls.Config                    // if not nil, dial over TLS
+	authb            func() (string, string, error) // if not nil, authenticate connections
+	sockOpts         common.SocketOptions
 }
 
 type connection struct {
@@ -57,6 +63,7 @@ func newConnectionPool(
 
 	cp := &connectionPool{
 		host:             host,
+		sockOpts:         common.SocketOptions{NoDelay: true},
 		connections:      make(chan *connection, poolSize),
 		createsem:        make(chan bool, poolSize+poolOverflow),
 		maxPayload:       maxPayload,
@@ -84,11 +91,62 @@ func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := cp.sockOpts.Apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if cp.tlsConfig != nil {
+		conn = tls.Client(conn, cp.tlsConfig)
+	}
 	flags := transport.TransportFlag(0).SetProtobuf()
 	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
 	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
 	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
-	return &connection{conn, pkt}, nil
+	connectn := &connection{conn, pkt}
+	if cp.authb != nil {
+		if err := cp.authenticate(connectn); err != nil {
+			logging.Errorf("%v authentication failed: %v\n", cp.logPrefix, err)
+			conn.Close()
+			return nil, err
+		}
+	}
+	return connectn, nil
+}
+
+// authenticate a new connection with the credentials returned by authb.
+func (cp *connectionPool) authenticate(connectn *connection) error {
+	user, passwd, err := cp.authb()
+	if err != nil {
+		return err
+	}
+
+	conn, pkt := connectn.conn, connectn.pkt
+	req := &protobuf.AuthRequest{
+		User:     proto.String(user),
+		Password: []byte(passwd),
+	}
+	// ---> protobuf.AuthRequest
+	if err := pkt.Send(conn, req); err != nil {
+		return err
+	}
+
+	// <--- protobuf.AuthResponse
+	resp, err := pkt.Receive(conn)
+	if err != nil {
+		return err
+	}
+	authResp, ok := resp.(*protobuf.AuthResponse)
+	if !ok {
+		return ErrorProtocol
+	}
+
+	// <--- protobuf.StreamEndResponse
+	if endResp, err := pkt.Receive(conn); err != nil {
+		return err
+	} else if endResp != nil {
+		return ErrorProtocol
+	}
+	return authResp.Error()
 }
 
 func (cp *connectionPool) Close() (err error) {
@@ -3,6 +3,7 @@ package client
 //TODO move all these defs to common
 
 import common "github.com/couchbase/indexing/secondary/common"
+import mclient "github.com/couchbase/indexing/secondary/manager/client"
 
 type RequestType string
 
@@ -21,9 +22,10 @@ type IndexRequest struct {
 }
 
 type IndexResponse struct {
-	Version uint64 `json:"version,omitempty"`
-	Code    string `json:"code,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Version uint64                `json:"version,omitempty"`
+	Code    string                `json:"code,omitempty"`
+	Error   string                `json:"error,omitempty"`
+	DryRun  *mclient.DryRunResult `json:"dryRun,omitempty"`
 }
 
 type IndexIdList struct {
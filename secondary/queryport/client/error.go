@@ -12,6 +12,9 @@ var ErrorNoHost = errors.New("queryport.client.noHost")
 // ErrorIndexNotFound
 var ErrorIndexNotFound = errors.New("queryport.indexNotFound")
 
+// ErrorIndexUnavailable
+var ErrorIndexUnavailable = errors.New("queryport.indexUnavailable")
+
 // ErrorInstanceNotFound
 var ErrorInstanceNotFound = errors.New("queryport.instanceNotFound")
 
@@ -27,6 +30,9 @@ var ErrorInvalidConsistency = errors.New("queryport.invalidConsistency")
 // ErrorExpectedTimestamp
 var ErrorExpectedTimestamp = errors.New("queryport.expectedTimestamp")
 
+// ErrorNoMatchingIndex
+var ErrorNoMatchingIndex = errors.New("queryport.noMatchingIndex")
+
 // These error strings need to be in sync with common.ErrIndexNotFound
 // and common.ErrIndexNotReady.
 var ErrIndexNotFound = fmt.Errorf("Index not found")
@@ -36,11 +42,13 @@ var errorDescriptions = map[string]string{
 	ErrorProtocol.Error():            "fatal protocol error with server",
 	ErrorNoHost.Error():              "All indexer replica is down or unavailable or unable to process request",
 	ErrorIndexNotFound.Error():       "index deleted or node hosting the index is down",
+	ErrorIndexUnavailable.Error():    "all nodes hosting the index are down",
 	ErrorInstanceNotFound.Error():    "no instance available for the index",
 	ErrorClientUninitialized.Error(): "gsi client is not initialized",
 	ErrorNotImplemented.Error():      "client API not implemented",
 	ErrorInvalidConsistency.Error():  "supplied consistency is invalid",
 	ErrorExpectedTimestamp.Error():   "consistency timestamp is expected",
+	ErrorNoMatchingIndex.Error():     "no index matches the filters",
 	ErrIndexNotFound.Error():         "index is deleted or node hosting index is down",
 	ErrIndexNotReady.Error():         ErrIndexNotReady.Error(),
 }
@@ -0,0 +1,165 @@
+package client
+
+import "sync"
+import "sync/atomic"
+import "time"
+import "unsafe"
+
+import "github.com/couchbase/indexing/secondary/logging"
+import common "github.com/couchbase/indexing/secondary/common"
+
+// failureDetector sends periodic heartbeats to indexer nodes and declares
+// a node dead after `threshold` consecutive heartbeats are missed. Dead
+// nodes continue to receive heartbeats, and are declared alive again on
+// the first heartbeat that succeeds.
+//
+// Failure detection is local to the client: heartbeats are sent by the
+// client to the queryport of the nodes it scans, and instances on dead
+// nodes are only marked unavailable in the topology of the client. The
+// cluster topology is left unchanged, failover of indexer nodes is done
+// by the cluster manager.
+type failureDetector struct {
+	interval  time.Duration
+	threshold int
+	ping      func(queryport string) error
+
+	mu    sync.Mutex
+	nodes map[common.IndexerId]*nodeHealth
+	dead  unsafe.Pointer // *map[common.IndexerId]bool, immutable once stored
+
+	finch     chan bool
+	closeOnce sync.Once
+}
+
+type nodeHealth struct {
+	addr   string
+	misses int
+	dead   bool
+}
+
+func newFailureDetector(
+	interval time.Duration, threshold int,
+	ping func(queryport string) error) *failureDetector {
+
+	if threshold <= 0 {
+		threshold = 1
+	}
+	fd := &failureDetector{
+		interval:  interval,
+		threshold: threshold,
+		ping:      ping,
+		nodes:     make(map[common.IndexerId]*nodeHealth),
+		finch:     make(chan bool),
+	}
+	dead := make(map[common.IndexerId]bool)
+	atomic.StorePointer(&fd.dead, unsafe.Pointer(&dead))
+	return fd
+}
+
+// setNodes updates the indexer nodes to watch, `nodes` map the indexer
+// to its queryport. Health of nodes already watched is retained.
+func (fd *failureDetector) setNodes(nodes map[common.IndexerId]string) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	for indexerId, node := range fd.nodes {
+		if addr, ok := nodes[indexerId]; !ok || addr != node.addr {
+			delete(fd.nodes, indexerId)
+		}
+	}
+	for indexerId, addr := range nodes {
+		if _, ok := fd.nodes[indexerId]; !ok && addr != "" {
+			fd.nodes[indexerId] = &nodeHealth{addr: addr}
+		}
+	}
+	fd.publish()
+}
+
+// isDead returns whether indexer is declared dead.
+func (fd *failureDetector) isDead(indexerId common.IndexerId) bool {
+	dead := *((*map[common.IndexerId]bool)(atomic.LoadPointer(&fd.dead)))
+	return dead[indexerId]
+}
+
+func (fd *failureDetector) run() {
+	ticker := time.NewTicker(fd.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fd.heartbeat()
+		case <-fd.finch:
+			return
+		}
+	}
+}
+
+// heartbeat pings all nodes in parallel and updates their health. A ping
+// is bounded by the read and write deadlines of the scan client.
+func (fd *failureDetector) heartbeat() {
+	fd.mu.Lock()
+	nodes := make(map[common.IndexerId]string, len(fd.nodes))
+	for indexerId, node := range fd.nodes {
+		nodes[indexerId] = node.addr
+	}
+	fd.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	errs := make(map[common.IndexerId]error, len(nodes))
+	for indexerId, addr := range nodes {
+		wg.Add(1)
+		go func(indexerId common.IndexerId, addr string) {
+			defer wg.Done()
+			err := fd.ping(addr)
+			errsMu.Lock()
+			errs[indexerId] = err
+			errsMu.Unlock()
+		}(indexerId, addr)
+	}
+	wg.Wait()
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	changed := false
+	for indexerId, err := range errs {
+		node, ok := fd.nodes[indexerId]
+		if !ok || node.addr != nodes[indexerId] {
+			continue // node changed while pinging
+		}
+		if err == nil {
+			if node.dead {
+				logging.Infof("failureDetector: indexer %v (%v) is alive", indexerId, node.addr)
+				changed = true
+			}
+			node.misses, node.dead = 0, false
+			continue
+		}
+		node.misses++
+		if !node.dead && node.misses >= fd.threshold {
+			logging.Errorf("failureDetector: indexer %v (%v) is dead after %v missed heartbeats: %v",
+				indexerId, node.addr, node.misses, err)
+			node.dead, changed = true, true
+		}
+	}
+	if changed {
+		fd.publish()
+	}
+}
+
+// publish a new snapshot of dead nodes, must be called with fd.mu held.
+func (fd *failureDetector) publish() {
+	dead := make(map[common.IndexerId]bool)
+	for indexerId, node := range fd.nodes {
+		if node.dead {
+			dead[indexerId] = true
+		}
+	}
+	atomic.StorePointer(&fd.dead, unsafe.Pointer(&dead))
+}
+
+func (fd *failureDetector) close() {
+	fd.closeOnce.Do(func() { close(fd.finch) })
+}
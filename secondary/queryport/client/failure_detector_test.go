@@ -0,0 +1,120 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+)
+
+// testPinger fails the heartbeats of queryports in down.
+type testPinger struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (p *testPinger) ping(queryport string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down[queryport] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (p *testPinger) setDown(queryport string, down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down[queryport] = down
+}
+
+func TestFailureDetector(t *testing.T) {
+	pinger := &testPinger{down: make(map[string]bool)}
+	fd := newFailureDetector(time.Second, 2, pinger.ping)
+	defer fd.close()
+	fd.setNodes(map[common.IndexerId]string{"n1": "n1:9101", "n2": "n2:9101"})
+
+	pinger.setDown("n1:9101", true)
+	fd.heartbeat()
+	if fd.isDead("n1") {
+		t.Fatalf("Unexpected dead node after one missed heartbeat")
+	}
+	fd.heartbeat()
+	if !fd.isDead("n1") || fd.isDead("n2") {
+		t.Fatalf("Expected only n1 to be dead")
+	}
+
+	// alive again on the first heartbeat that succeeds.
+	pinger.setDown("n1:9101", false)
+	fd.heartbeat()
+	if fd.isDead("n1") {
+		t.Fatalf("Expected n1 to be alive")
+	}
+
+	// a node leaving the cluster is forgotten, with its health.
+	pinger.setDown("n2:9101", true)
+	fd.heartbeat()
+	fd.heartbeat()
+	if !fd.isDead("n2") {
+		t.Fatalf("Expected n2 to be dead")
+	}
+	fd.setNodes(map[common.IndexerId]string{"n1": "n1:9101"})
+	if fd.isDead("n2") {
+		t.Fatalf("Unexpected dead node after leaving the cluster")
+	}
+
+	fd.close()
+	fd.close()
+}
+
+func TestFailoverToReplica(t *testing.T) {
+	pinger := &testPinger{down: map[string]bool{"n1:9101": true}}
+	fd := newFailureDetector(time.Second, 1, pinger.ping)
+	defer fd.close()
+	fd.setNodes(map[common.IndexerId]string{"n1": "n1:9101", "n2": "n2:9101", "n3": "n3:9101"})
+	fd.heartbeat()
+
+	// defn 1 has partitions 1 and 2 on n1 and n2, with a replica of
+	// partition 1 on n3. defn 2 has partition 1 on n1 only.
+	currmeta := &indexTopology{
+		replicas: map[common.IndexDefnId][]common.IndexInstId{
+			1: {10, 11},
+			2: {20},
+		},
+		insts: map[common.IndexInstId]*mclient.InstanceDefn{
+			10: {DefnId: 1, InstId: 10,
+				IndexerId: map[common.PartitionId]common.IndexerId{1: "n1", 2: "n2"}},
+			11: {DefnId: 1, InstId: 11,
+				IndexerId: map[common.PartitionId]common.IndexerId{1: "n3"}},
+			20: {DefnId: 2, InstId: 20,
+				IndexerId: map[common.PartitionId]common.IndexerId{1: "n1"}},
+		},
+		rebalInsts: make(map[common.IndexInstId]*mclient.InstanceDefn),
+	}
+	b := &metadataClient{detector: fd, promoteReplica: true}
+
+	if !b.isDefnAvailable(currmeta, 1) {
+		t.Fatalf("Expected defn 1 to be available from its replica")
+	}
+	if b.isDefnAvailable(currmeta, 2) {
+		t.Fatalf("Expected defn 2 to be unavailable")
+	}
+
+	rollbackTimes := []map[common.PartitionId]int64{{1: 100, 2: 100}, {1: 100}}
+	b.pruneDeadReplica(currmeta, []uint64{10, 11}, rollbackTimes)
+	if _, ok := rollbackTimes[0][1]; ok {
+		t.Fatalf("Expected partition 1 on dead node to be pruned, got %v", rollbackTimes[0])
+	}
+	if len(rollbackTimes[0]) != 1 || len(rollbackTimes[1]) != 1 {
+		t.Fatalf("Unexpected pruning of live partitions %v", rollbackTimes)
+	}
+
+	// without failure detection, every index is available.
+	b = &metadataClient{}
+	if !b.isDefnAvailable(currmeta, 2) {
+		t.Fatalf("Expected defn 2 to be available without failure detection")
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"sort"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/expression/parser"
+)
+
+//
+// Index selection.
+//
+// SelectIndex picks the index to serve a simple query, for clients that
+// do not go through N1QL.  Candidates are the active, non-partial
+// secondary indexes of the bucket, whose leading key has a filter.
+// They are ranked by
+//
+//   1. the number of leading keys matched by filters, up to and
+//      including the first range filter,
+//   2. whether the index order satisfies the requested ordering,
+//   3. whether the index covers all filtered and ordered fields,
+//   4. the number of entries estimated from key statistics of the
+//      leading key, when the filter on it has values.
+//
+
+// IndexFilter is a predicate on a field. Low, High and Inclusion are
+// optional, they are used to estimate the entries qualified by the
+// filter on the leading key of an index.
+type IndexFilter struct {
+	Field     string
+	Equal     bool // equality predicate, Low is the value
+	Low       interface{}
+	High      interface{}
+	Inclusion Inclusion
+}
+
+// IndexOrderField is a field of the requested ordering.
+type IndexOrderField struct {
+	Field string
+	Desc  bool
+}
+
+// IndexSelection is the index selected by SelectIndex.
+type IndexSelection struct {
+	DefnID       uint64
+	Name         string
+	MatchedKeys  int   // leading keys matched by filters
+	OrderMatched bool  // index order satisfies the requested ordering
+	Covering     bool  // index keys include all fields
+	Estimate     int64 // estimated entries, -1 if not estimated
+}
+
+// SelectIndex returns the best matching index for filters and order on
+// bucket, from cached metadata and key statistics. Returns
+// ErrorNoMatchingIndex if no index can serve the filters.
+func (c *GsiClient) SelectIndex(
+	bucket string, filters []IndexFilter,
+	order []IndexOrderField) (*IndexSelection, error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+
+	indexes, _, _, err := c.bridge.Refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := rankIndexes(indexes, bucket, filters, order)
+	if len(candidates) == 0 {
+		return nil, ErrorNoMatchingIndex
+	}
+
+	// break ties of the best candidates with key statistics.
+	best := candidates[:1]
+	for _, cand := range candidates[1:] {
+		if cand.compareMatch(best[0]) != 0 {
+			break
+		}
+		best = append(best, cand)
+	}
+	if len(best) > 1 {
+		for _, cand := range best {
+			cand.Estimate = c.estimateIndex(cand, filters)
+		}
+		sort.SliceStable(best, func(i, j int) bool {
+			ei, ej := best[i].Estimate, best[j].Estimate
+			return ei >= 0 && (ej < 0 || ei < ej)
+		})
+	}
+	return &best[0].IndexSelection, nil
+}
+
+func (c *GsiClient) estimateIndex(cand *indexCandidate, filters []IndexFilter) int64 {
+	filter := findFilter(filters, cand.keys[0])
+	if filter == nil || (filter.Low == nil && filter.High == nil) {
+		return -1
+	}
+
+	elem := &CompositeElementFilter{Low: filter.Low, High: filter.High, Inclusion: filter.Inclusion}
+	if filter.Equal {
+		elem.High, elem.Inclusion = filter.Low, Both
+	}
+	scans := Scans{&Scan{Filter: []*CompositeElementFilter{elem}}}
+
+	count, err := c.EstimateScan3(cand.DefnID, "", scans)
+	if err != nil {
+		logging.Debugf("SelectIndex: estimate of index %v failed: %v", cand.Name, err)
+		return -1
+	}
+	return count
+}
+
+type indexCandidate struct {
+	IndexSelection
+	keys []string
+}
+
+// compareMatch compares candidates on all but the estimate, returns
+// a negative number if cand is a better match than other.
+func (cand *indexCandidate) compareMatch(other *indexCandidate) int {
+	if cand.MatchedKeys != other.MatchedKeys {
+		return other.MatchedKeys - cand.MatchedKeys
+	} else if cand.OrderMatched != other.OrderMatched {
+		if cand.OrderMatched {
+			return -1
+		}
+		return 1
+	} else if cand.Covering != other.Covering {
+		if cand.Covering {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// rankIndexes returns the candidate indexes, best match first. Among
+// equal matches, indexes with fewer keys come first.
+func rankIndexes(
+	indexes []*mclient.IndexMetadata, bucket string,
+	filters []IndexFilter, order []IndexOrderField) []*indexCandidate {
+
+	filters = append([]IndexFilter(nil), filters...)
+	for i := range filters {
+		filters[i].Field = normalizeField(filters[i].Field)
+	}
+	order = append([]IndexOrderField(nil), order...)
+	for i := range order {
+		order[i].Field = normalizeField(order[i].Field)
+	}
+
+	candidates := make([]*indexCandidate, 0)
+	for _, index := range indexes {
+		defn := index.Definition
+		if defn.Bucket != bucket || defn.IsPrimary || defn.IsArrayIndex ||
+			defn.WhereExpr != "" || index.State != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+
+		keys := make([]string, 0, len(defn.SecExprs))
+		for _, expr := range defn.SecExprs {
+			keys = append(keys, normalizeField(expr))
+		}
+
+		// leading keys with filters, the prefix ends at a range filter.
+		matched, equals := 0, 0
+		for _, key := range keys {
+			filter := findFilter(filters, key)
+			if filter == nil {
+				break
+			}
+			matched++
+			if !filter.Equal {
+				break
+			}
+			equals++
+		}
+		if matched == 0 {
+			continue
+		}
+
+		cand := &indexCandidate{
+			IndexSelection: IndexSelection{
+				DefnID:       uint64(defn.DefnId),
+				Name:         defn.Name,
+				MatchedKeys:  matched,
+				OrderMatched: orderMatched(keys, defn.Desc, equals, order),
+				Covering:     covering(keys, filters, order),
+				Estimate:     -1,
+			},
+			keys: keys,
+		}
+		candidates = append(candidates, cand)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if cmp := candidates[i].compareMatch(candidates[j]); cmp != 0 {
+			return cmp < 0
+		} else if len(candidates[i].keys) != len(candidates[j].keys) {
+			return len(candidates[i].keys) < len(candidates[j].keys)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates
+}
+
+// orderMatched returns whether index order satisfies the ordering. Keys
+// with equality filters are constant, so the ordering can start at any
+// key up to the first key without an equality filter.
+func orderMatched(keys []string, desc []bool, equals int, order []IndexOrderField) bool {
+	if len(order) == 0 {
+		return true
+	}
+	isDesc := func(i int) bool { return i < len(desc) && desc[i] }
+
+	for start := 0; start <= equals && start+len(order) <= len(keys); start++ {
+		matched := true
+		for i, field := range order {
+			if keys[start+i] != field.Field || isDesc(start+i) != field.Desc {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func covering(keys []string, filters []IndexFilter, order []IndexOrderField) bool {
+	hasKey := func(field string) bool {
+		for _, key := range keys {
+			if key == field {
+				return true
+			}
+		}
+		return false
+	}
+	for _, filter := range filters {
+		if !hasKey(filter.Field) {
+			return false
+		}
+	}
+	for _, field := range order {
+		if !hasKey(field.Field) {
+			return false
+		}
+	}
+	return true
+}
+
+func findFilter(filters []IndexFilter, key string) *IndexFilter {
+	for i := range filters {
+		if filters[i].Field == key {
+			return &filters[i]
+		}
+	}
+	return nil
+}
+
+// normalizeField formats a field like the expressions of index
+// definitions, so that "age" and "`age`" compare equal.
+func normalizeField(field string) string {
+	expr, err := parser.Parse(field)
+	if err != nil {
+		return field
+	}
+	return expression.NewStringer().Visit(expr)
+}
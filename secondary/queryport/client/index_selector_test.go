@@ -0,0 +1,53 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func TestRankIndexes(t *testing.T) {
+	index := func(id int, name string, desc []bool, exprs ...string) *mclient.IndexMetadata {
+		return &mclient.IndexMetadata{
+			Definition: &common.IndexDefn{
+				DefnId: common.IndexDefnId(id), Name: name, Bucket: "default",
+				SecExprs: exprs, Desc: desc,
+			},
+			State: common.INDEX_STATE_ACTIVE,
+		}
+	}
+	partial := index(5, "idx_partial", nil, "`city`", "`age`", "`name`")
+	partial.Definition.WhereExpr = "`age` > 10"
+	indexes := []*mclient.IndexMetadata{
+		index(1, "idx_age", nil, "`age`"),
+		index(2, "idx_city_age", nil, "`city`", "`age`"),
+		index(3, "idx_city_name", []bool{false, true}, "`city`", "`name`"),
+		index(4, "idx_name", nil, "`name`"),
+		partial,
+	}
+
+	filters := []IndexFilter{{Field: "city", Equal: true, Low: "paris"}, {Field: "age", Low: 10}}
+	candidates := rankIndexes(indexes, "default", filters, nil)
+	if len(candidates) != 3 || candidates[0].Name != "idx_city_age" {
+		t.Fatalf("unexpected candidates %v", candidates)
+	}
+	if candidates[0].MatchedKeys != 2 || !candidates[0].Covering {
+		t.Fatalf("unexpected selection %v", candidates[0].IndexSelection)
+	}
+
+	order := []IndexOrderField{{Field: "name", Desc: true}}
+	candidates = rankIndexes(indexes, "default", filters[:1], order)
+	if candidates[0].Name != "idx_city_name" || !candidates[0].OrderMatched {
+		t.Fatalf("unexpected selection %v", candidates[0].IndexSelection)
+	}
+	order[0].Desc = false
+	candidates = rankIndexes(indexes, "default", filters[:1], order)
+	if candidates[0].OrderMatched {
+		t.Fatalf("unexpected order match %v", candidates[0].IndexSelection)
+	}
+
+	if candidates := rankIndexes(indexes, "travel", filters, nil); len(candidates) != 0 {
+		t.Fatalf("unexpected candidates %v", candidates)
+	}
+}
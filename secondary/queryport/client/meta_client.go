@@ -33,6 +33,11 @@ type metadataClient struct {
 	logtick                 time.Duration
 	randomWeight            float64 // value between [0, 1.0)
 	equivalenceFactor       float64 // value between [0, 1.0)
+	errorRateThreshold      float64 // value between [0, 1.0]
+	promoteReplica          bool
+
+	// nil if heartbeats are disabled
+	detector *failureDetector
 
 	topoChangeLock sync.Mutex
 	metaCh         chan bool
@@ -66,7 +71,8 @@ type indexTopology struct {
 }
 
 func newMetaBridgeClient(
-	cluster string, config common.Config, metaCh chan bool, settings *ClientSettings) (c *metadataClient, err error) {
+	cluster string, config common.Config, metaCh chan bool, settings *ClientSettings,
+	ping func(queryport string) error) (c *metadataClient, err error) {
 
 	b := &metadataClient{
 		cluster:    cluster,
@@ -83,6 +89,12 @@ func newMetaBridgeClient(
 	b.logtick = time.Duration(config["logtick"].Int()) * time.Millisecond
 	b.randomWeight = config["load.randomWeight"].Float64()
 	b.equivalenceFactor = config["load.equivalenceFactor"].Float64()
+	b.errorRateThreshold = config["load.errorRateThreshold"].Float64()
+	b.promoteReplica = config["failover.promoteReplica"].Bool()
+	if interval := config["failover.heartbeatInterval"].Int(); interval > 0 && ping != nil {
+		b.detector = newFailureDetector(time.Duration(interval)*time.Millisecond,
+			config["failover.heartbeatMisses"].Int(), ping)
+	}
 	// initialize meta-data-provide.
 	uuid, err := common.NewUUID()
 	if err != nil {
@@ -100,6 +112,9 @@ func newMetaBridgeClient(
 		return nil, err
 	}
 
+	if b.detector != nil {
+		go b.detector.run()
+	}
 	go b.watchClusterChanges() // will also update the indexer list
 	go b.logstats()
 	return b, nil
@@ -242,6 +257,38 @@ RETRY:
 	return uint64(defnID), err
 }
 
+// DryRunCreateIndex validates and plans a create index request without
+// creating the index.
+func (b *metadataClient) DryRunCreateIndex(
+	indexName, bucket, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	planJSON []byte) (*mclient.DryRunResult, error) {
+
+	plan := make(map[string]interface{})
+	if planJSON != nil && len(planJSON) > 0 {
+		err := json.Unmarshal(planJSON, &plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.mdClient.DryRunCreateIndexWithPlan(
+		indexName, bucket, using, exprType, whereExpr,
+		secExprs, desc, isPrimary, scheme, partitionKeys, plan)
+}
+
+// DryRunDropIndex validates a drop index request without dropping the
+// index.
+func (b *metadataClient) DryRunDropIndex(defnID uint64) (*mclient.DryRunResult, error) {
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+
+	if _, ok := currmeta.defns[common.IndexDefnId(defnID)]; !ok {
+		return nil, ErrorIndexNotFound
+	}
+	return b.mdClient.DryRunDropIndex(common.IndexDefnId(defnID))
+}
+
 // BuildIndexes implements BridgeAccessor{} interface.
 func (b *metadataClient) BuildIndexes(defnIDs []uint64) error {
 	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
@@ -261,11 +308,32 @@ func (b *metadataClient) BuildIndexes(defnIDs []uint64) error {
 
 // MoveIndex implements BridgeAccessor{} interface.
 func (b *metadataClient) MoveIndex(defnID uint64, planJSON map[string]interface{}) error {
+	_, err := b.postMoveIndex("/moveIndexInternal", defnID, planJSON)
+	return err
+}
+
+// DryRunMoveIndex validates a move index request, and returns the nodes
+// the index would be moved to, without moving the index. Indexers not
+// supporting dry run fail the request, instead of moving the index.
+func (b *metadataClient) DryRunMoveIndex(
+	defnID uint64, planJSON map[string]interface{}) (*mclient.DryRunResult, error) {
+
+	response, err := b.postMoveIndex("/dryRunMoveIndexInternal", defnID, planJSON)
+	if err != nil {
+		return nil, err
+	} else if response.DryRun == nil {
+		return nil, ErrorNotImplemented
+	}
+	return response.DryRun, nil
+}
+
+func (b *metadataClient) postMoveIndex(url string,
+	defnID uint64, planJSON map[string]interface{}) (*IndexResponse, error) {
 
 	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
 
 	if _, ok := currmeta.defns[common.IndexDefnId(defnID)]; !ok {
-		return ErrorIndexNotFound
+		return nil, ErrorIndexNotFound
 	}
 
 	var httpport string
@@ -277,7 +345,7 @@ func (b *metadataClient) MoveIndex(defnID uint64, planJSON map[string]interface{
 	}
 
 	if httpport == "" {
-		return ErrorNoHost
+		return nil, ErrorNoHost
 	}
 
 	timeout := time.Duration(0 * time.Second)
@@ -286,29 +354,28 @@ func (b *metadataClient) MoveIndex(defnID uint64, planJSON map[string]interface{
 	ir := IndexRequest{IndexIds: idList, Plan: planJSON}
 	body, err := json.Marshal(&ir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	bodybuf := bytes.NewBuffer(body)
 
-	url := "/moveIndexInternal"
 	resp, err := postWithAuth(httpport+url, "application/json", bodybuf, timeout)
 	if err != nil {
 		errStr := fmt.Sprintf("Error communicating with index node %v. Reason %v", httpport, err)
-		return errors.New(errStr)
+		return nil, errors.New(errStr)
 	}
 	defer resp.Body.Close()
 
 	response := new(IndexResponse)
 	bytes, _ := ioutil.ReadAll(resp.Body)
 	if err := json.Unmarshal(bytes, &response); err != nil {
-		return err
+		return nil, err
 	}
 	if response.Code == RESP_ERROR {
-		return errors.New(response.Error)
+		return nil, errors.New(response.Error)
 	}
 
-	return nil
+	return response, nil
 }
 
 // DropIndex implements BridgeAccessor{} interface.
@@ -333,7 +400,7 @@ func (b *metadataClient) GetScanports() (queryports []string) {
 
 // GetScanport implements BridgeAccessor{} interface.
 func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
-	skips map[common.IndexDefnId]bool) (qp []string,
+	skips map[common.IndexDefnId]bool, readPref common.ReadPreference) (qp []string,
 	targetDefnID uint64, in []uint64, rt []int64, pid [][]common.PartitionId, numPartitions uint32, ok bool) {
 
 	var insts map[common.PartitionId]*mclient.InstanceDefn
@@ -353,7 +420,14 @@ func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDef
 		n++
 	}
 
-	insts, rollbackTimes, ok = b.pickRandom(replicas[:n], defnID, excludes[common.IndexDefnId(defnID)])
+	// partitions of replicas with different partition count, e.g. while
+	// the index is being repartitioned, cannot be combined.
+	for _, group := range groupByNumPartitions(currmeta, replicas[:n]) {
+		insts, rollbackTimes, ok = b.pickRandom(group, defnID, excludes[common.IndexDefnId(defnID)], readPref)
+		if ok {
+			break
+		}
+	}
 	if !ok {
 		if len(currmeta.equivalents[common.IndexDefnId(defnID)]) > 1 || len(currmeta.replicas[common.IndexDefnId(defnID)]) > 1 {
 			// skip this index definition for retry only if there is equivalent index or replica
@@ -414,6 +488,27 @@ func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDef
 	return qp, targetDefnID, in, rt, pid, numPartitions, true
 }
 
+// groupByNumPartitions groups replicas by their partition count, in the
+// order the partition counts first appear in replicas.
+func groupByNumPartitions(currmeta *indexTopology, replicas []uint64) [][]uint64 {
+	var groups [][]uint64
+	index := make(map[uint32]int)
+	for _, instId := range replicas {
+		var numPartitions uint32
+		if inst, ok := currmeta.insts[common.IndexInstId(instId)]; ok {
+			numPartitions = inst.NumPartitions
+		}
+		i, ok := index[numPartitions]
+		if !ok {
+			i = len(groups)
+			index[numPartitions] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], instId)
+	}
+	return groups
+}
+
 // Timeit implement BridgeAccessor{} interface.
 func (b *metadataClient) Timeit(instID uint64, partitionId common.PartitionId, value float64) {
 
@@ -446,6 +541,24 @@ func (b *metadataClient) NumReplica(defnID uint64) int {
 	return len(currmeta.replicas[common.IndexDefnId(defnID)])
 }
 
+// IsIndexAvailable implement BridgeAccessor{} interface.
+func (b *metadataClient) IsIndexAvailable(defnID uint64) bool {
+	if b.detector == nil {
+		return true
+	}
+
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+	if b.isDefnAvailable(currmeta, common.IndexDefnId(defnID)) {
+		return true
+	}
+	for _, equivDefnID := range currmeta.equivalents[common.IndexDefnId(defnID)] {
+		if b.isDefnAvailable(currmeta, equivDefnID) {
+			return true
+		}
+	}
+	return false
+}
+
 // IndexState implement BridgeAccessor{} interface.
 func (b *metadataClient) IndexState(defnID uint64) (common.IndexState, error) {
 	b.Refresh()
@@ -456,6 +569,9 @@ func (b *metadataClient) IndexState(defnID uint64) (common.IndexState, error) {
 // an active indexer leaves the cluster or during system shutdown.
 func (b *metadataClient) Close() {
 	defer func() { recover() }() // in case async Close is called.
+	if b.detector != nil {
+		b.detector.close()
+	}
 	b.mdClient.Close()
 	close(b.finch)
 }
@@ -543,7 +659,9 @@ func (b *metadataClient) equivalentIndex(
 		d1.PartitionScheme != d2.PartitionScheme ||
 		d1.HashScheme != d2.HashScheme ||
 		d1.WhereExpr != d2.WhereExpr ||
-		d1.RetainDeletedXATTR != d2.RetainDeletedXATTR {
+		d1.RetainDeletedXATTR != d2.RetainDeletedXATTR ||
+		d1.DocMeta != d2.DocMeta ||
+		d1.IncludeMissing != d2.IncludeMissing {
 
 		return false
 	}
@@ -843,7 +961,7 @@ func (b *metadataClient) pickEquivalent(defnID uint64, skips map[common.IndexDef
 // 2) a map of partition Id and rollback timestamp
 //
 func (b *metadataClient) pickRandom(replicas []uint64, defnID uint64,
-	excludes map[common.PartitionId]map[uint64]bool) (map[common.PartitionId]*mclient.InstanceDefn, map[common.PartitionId]int64, bool) {
+	excludes map[common.PartitionId]map[uint64]bool, readPref common.ReadPreference) (map[common.PartitionId]*mclient.InstanceDefn, map[common.PartitionId]int64, bool) {
 
 	//
 	// Determine number of partitions and its range
@@ -909,13 +1027,31 @@ func (b *metadataClient) pickRandom(replicas []uint64, defnID uint64,
 	}
 	replicas = shuffle(replicas)
 
+	//
+	// Order the replica list by read preference, so that partitions
+	// are picked from preferred replicas when they are available.
+	//
+	replicas = orderByReadPreference(currmeta, replicas, readPref)
+
 	//
 	// Filter out inst based on pending item stats.
 	//
 	rollbackTimesList := b.pruneStaleReplica(replicas, excludes)
 
-	// Filter based on timing of scan responses
-	b.filterByTiming(currmeta, replicas, rollbackTimesList, startPartnId, endPartnId)
+	// Promote replicas of partitions residing on dead indexers.
+	if b.promoteReplica {
+		b.pruneDeadReplica(currmeta, replicas, rollbackTimesList)
+	}
+
+	// Avoid indexers failing scans, if replicas on other indexers are
+	// available.
+	b.pruneFailingReplica(currmeta, replicas, rollbackTimesList)
+
+	// Filter based on timing of scan responses, unless the caller
+	// prefers specific replicas over the ones under least load.
+	if readPref == common.ReadNearest {
+		b.filterByTiming(currmeta, replicas, rollbackTimesList, startPartnId, endPartnId)
+	}
 
 	//
 	// Randomly select an inst after filtering
@@ -956,7 +1092,7 @@ func (b *metadataClient) pickRandom(replicas []uint64, defnID uint64,
 			// try to find an indexer under rebalancing
 			for _, instId := range replicas {
 				if inst, ok := currmeta.rebalInsts[common.IndexInstId(instId)]; ok {
-					if _, ok := inst.IndexerId[common.PartitionId(partnId)]; ok {
+					if indexerId, ok := inst.IndexerId[common.PartitionId(partnId)]; ok && !b.isIndexerDead(indexerId) {
 						chosenInst[common.PartitionId(partnId)] = inst
 						chosenTimestamp[common.PartitionId(partnId)] = 0
 					}
@@ -981,6 +1117,135 @@ func (b *metadataClient) pickRandom(replicas []uint64, defnID uint64,
 	return chosenInst, chosenTimestamp, true
 }
 
+// pruneDeadReplica removes partitions residing on dead indexers from
+// the rollback times of each replica, so that the partition is picked
+// from a live replica.
+func (b *metadataClient) pruneDeadReplica(currmeta *indexTopology, replicas []uint64,
+	rollbackTimesList []map[common.PartitionId]int64) {
+
+	if b.detector == nil {
+		return
+	}
+
+	for n, replica := range replicas {
+		inst, ok := currmeta.insts[common.IndexInstId(replica)]
+		if !ok || n >= len(rollbackTimesList) {
+			continue
+		}
+		for partnId, indexerId := range inst.IndexerId {
+			if b.isIndexerDead(indexerId) {
+				delete(rollbackTimesList[n], partnId)
+			}
+		}
+	}
+}
+
+// pruneFailingReplica removes partitions of replicas residing on indexers
+// whose recent error rate is above errorRateThreshold, when the partition
+// is available from a replica on an indexer below the threshold.
+func (b *metadataClient) pruneFailingReplica(currmeta *indexTopology, replicas []uint64,
+	rollbackTimesList []map[common.PartitionId]int64) {
+
+	if b.errorRateThreshold <= 0 {
+		return
+	}
+
+	failing := func(indexerId common.IndexerId) bool {
+		if queryport, ok := currmeta.queryports[indexerId]; ok {
+			if m := lookupNodeMetrics(queryport); m != nil {
+				return m.recentErrorRate() > b.errorRateThreshold
+			}
+		}
+		return false
+	}
+
+	// replicas of each partition, and whether the partition is available
+	// on an indexer that is not failing.
+	partns := make(map[common.PartitionId][]int)
+	healthy := make(map[common.PartitionId]bool)
+	for n, replica := range replicas {
+		inst, ok := currmeta.insts[common.IndexInstId(replica)]
+		if !ok || n >= len(rollbackTimesList) {
+			continue
+		}
+		for partnId, indexerId := range inst.IndexerId {
+			if _, ok := rollbackTimesList[n][partnId]; !ok {
+				continue
+			}
+			if failing(indexerId) {
+				partns[partnId] = append(partns[partnId], n)
+			} else {
+				healthy[partnId] = true
+			}
+		}
+	}
+
+	for partnId, failed := range partns {
+		if !healthy[partnId] {
+			continue
+		}
+		for _, n := range failed {
+			logging.Verbosef("remove inst %v partition %v from scan due to recent errors", replicas[n], partnId)
+			delete(rollbackTimesList[n], partnId)
+		}
+	}
+}
+
+// isDefnAvailable returns false if all the replicas of any of the index
+// partitions reside on dead indexers.
+func (b *metadataClient) isDefnAvailable(currmeta *indexTopology, defnID common.IndexDefnId) bool {
+
+	partns := make(map[common.PartitionId]bool)
+	check := func(inst *mclient.InstanceDefn) {
+		for partnId, indexerId := range inst.IndexerId {
+			partns[partnId] = partns[partnId] || !b.isIndexerDead(indexerId)
+		}
+	}
+
+	for _, instId := range currmeta.replicas[defnID] {
+		if inst, ok := currmeta.insts[instId]; ok {
+			check(inst)
+		}
+		if inst, ok := currmeta.rebalInsts[instId]; ok {
+			check(inst)
+		}
+	}
+
+	for _, live := range partns {
+		if !live {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *metadataClient) isIndexerDead(indexerId common.IndexerId) bool {
+	return b.detector != nil && b.detector.isDead(indexerId)
+}
+
+// orderByReadPreference moves the replicas preferred by readPref ahead
+// of other replicas, preserving their relative order.
+func orderByReadPreference(currmeta *indexTopology, replicas []uint64,
+	readPref common.ReadPreference) []uint64 {
+
+	if readPref == common.ReadNearest {
+		return replicas
+	}
+
+	preferred := make([]uint64, 0, len(replicas))
+	others := make([]uint64, 0, len(replicas))
+	for _, replica := range replicas {
+		inst, ok := currmeta.insts[common.IndexInstId(replica)]
+		isPrimary := ok && inst.ReplicaId == 0
+		if isPrimary == (readPref == common.ReadPrimary) {
+			preferred = append(preferred, replica)
+		} else {
+			others = append(others, replica)
+		}
+	}
+	return append(preferred, others...)
+}
+
 func (b *metadataClient) filterByTiming(currmeta *indexTopology, replicas []uint64, rollbackTimes []map[common.PartitionId]int64,
 	startPartnId uint64, endPartnId uint64) {
 
@@ -1521,6 +1786,9 @@ func (b *metadataClient) updateTopology(
 			newmeta.queryports[indexerID] = qp
 		}
 	}
+	if b.detector != nil {
+		b.detector.setNodes(newmeta.queryports)
+	}
 
 	// insts/defns
 	topologyMap := make(map[common.IndexerId]map[common.IndexDefnId]*mclient.IndexMetadata)
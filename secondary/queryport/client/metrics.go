@@ -0,0 +1,271 @@
+// Copyright (c) 2026 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//
+// Metrics of requests sent to indexer nodes.
+//
+// Every scan and count request sent to a queryport is counted, along
+// with its outcome and latency.  Metrics are kept per process, for all
+// clients, and are published as the expvar "queryport.client.nodes",
+// and in prometheus text format by WritePrometheus().
+//
+// Recent error rate and latency of nodes are exponentially weighted
+// moving averages.  Replicas hosted on nodes with a high recent error
+// rate are avoided when replicas on other nodes are available.
+//
+
+// upper bounds of latency histogram buckets, the last bucket is +Inf.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// weight of the latest request in moving averages.
+const metricsDecay = 0.1
+
+type nodeMetrics struct {
+	requests   int64
+	errors     int64
+	latency    int64   // sum of latencies, in nanoseconds
+	buckets    []int64 // len(latencyBuckets)+1 counts
+	errorRate  uint64  // float64 bits, moving average
+	avgLatency uint64  // float64 bits, moving average in nanoseconds
+}
+
+func newNodeMetrics() *nodeMetrics {
+	return &nodeMetrics{buckets: make([]int64, len(latencyBuckets)+1)}
+}
+
+// record a request that took `elapsed` and failed with `err`, if any.
+// Requests cancelled by the caller say nothing of the node, and are not
+// recorded.
+func (m *nodeMetrics) record(elapsed time.Duration, err error) {
+	if m == nil || isCancelError(err) {
+		return
+	}
+
+	atomic.AddInt64(&m.requests, 1)
+	atomic.AddInt64(&m.latency, int64(elapsed))
+	i := sort.Search(len(latencyBuckets), func(i int) bool {
+		return elapsed <= latencyBuckets[i]
+	})
+	atomic.AddInt64(&m.buckets[i], 1)
+
+	failed := 0.0
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+		failed = 1.0
+	}
+	updateAverage(&m.errorRate, failed)
+	updateAverage(&m.avgLatency, float64(elapsed))
+}
+
+// isCancelError returns true if the request was cancelled, or timed out,
+// by the caller. Cancel of a scan may come back from the indexer.
+func isCancelError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == context.Canceled || err == context.DeadlineExceeded ||
+		err.Error() == common.ErrClientCancel.Error()
+}
+
+func updateAverage(avg *uint64, value float64) {
+	for {
+		old := atomic.LoadUint64(avg)
+		curr := math.Float64frombits(old)
+		next := curr + metricsDecay*(value-curr)
+		if atomic.CompareAndSwapUint64(avg, old, math.Float64bits(next)) {
+			return
+		}
+	}
+}
+
+// recentErrorRate returns the moving average of failed requests.
+func (m *nodeMetrics) recentErrorRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.errorRate))
+}
+
+// recentLatency returns the moving average of request latency.
+func (m *nodeMetrics) recentLatency() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.avgLatency))
+}
+
+// NodeMetrics are the metrics of requests sent to an indexer node.
+type NodeMetrics struct {
+	Queryport       string  `json:"queryport"`
+	Requests        int64   `json:"requests"`
+	Errors          int64   `json:"errors"`
+	RecentErrorRate float64 `json:"recentErrorRate"`
+	RecentLatency   int64   `json:"recentLatency"` // nanoseconds
+	TotalLatency    int64   `json:"totalLatency"`  // nanoseconds
+	// number of requests with latency up to LatencyBuckets[i], the
+	// last count is for requests slower than all buckets.
+	Histogram []int64 `json:"histogram"`
+}
+
+// LatencyBuckets returns the upper bounds of histogram buckets of
+// NodeMetrics.
+func LatencyBuckets() []time.Duration {
+	return append([]time.Duration(nil), latencyBuckets...)
+}
+
+func (m *nodeMetrics) snapshot(queryport string) NodeMetrics {
+	s := NodeMetrics{
+		Queryport:       queryport,
+		Requests:        atomic.LoadInt64(&m.requests),
+		Errors:          atomic.LoadInt64(&m.errors),
+		RecentErrorRate: m.recentErrorRate(),
+		RecentLatency:   int64(m.recentLatency()),
+		TotalLatency:    atomic.LoadInt64(&m.latency),
+		Histogram:       make([]int64, len(m.buckets)),
+	}
+	for i := range m.buckets {
+		s.Histogram[i] = atomic.LoadInt64(&m.buckets[i])
+	}
+	return s
+}
+
+//--------------------------
+// metrics of all nodes
+//--------------------------
+
+var gNodeMetrics = struct {
+	mu    sync.RWMutex
+	nodes map[string]*nodeMetrics // queryport -> metrics
+}{nodes: make(map[string]*nodeMetrics)}
+
+func init() {
+	expvar.Publish("queryport.client.nodes", expvar.Func(func() interface{} {
+		return GetNodeMetrics()
+	}))
+}
+
+// getNodeMetrics returns the metrics of the node, creating them if
+// this is the first client for the node.
+func getNodeMetrics(queryport string) *nodeMetrics {
+	gNodeMetrics.mu.RLock()
+	m, ok := gNodeMetrics.nodes[queryport]
+	gNodeMetrics.mu.RUnlock()
+	if ok {
+		return m
+	}
+
+	gNodeMetrics.mu.Lock()
+	defer gNodeMetrics.mu.Unlock()
+	if m, ok = gNodeMetrics.nodes[queryport]; !ok {
+		m = newNodeMetrics()
+		gNodeMetrics.nodes[queryport] = m
+	}
+	return m
+}
+
+// lookupNodeMetrics returns nil if no request is sent to the node.
+func lookupNodeMetrics(queryport string) *nodeMetrics {
+	gNodeMetrics.mu.RLock()
+	defer gNodeMetrics.mu.RUnlock()
+	return gNodeMetrics.nodes[queryport]
+}
+
+// GetNodeMetrics returns the metrics of all indexer nodes, ordered by
+// queryport.
+func GetNodeMetrics() []NodeMetrics {
+	gNodeMetrics.mu.RLock()
+	defer gNodeMetrics.mu.RUnlock()
+
+	metrics := make([]NodeMetrics, 0, len(gNodeMetrics.nodes))
+	for queryport, m := range gNodeMetrics.nodes {
+		metrics = append(metrics, m.snapshot(queryport))
+	}
+	sort.Sort(nodeMetricsList(metrics))
+	return metrics
+}
+
+type nodeMetricsList []NodeMetrics
+
+func (l nodeMetricsList) Len() int           { return len(l) }
+func (l nodeMetricsList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l nodeMetricsList) Less(i, j int) bool { return l[i].Queryport < l[j].Queryport }
+
+// WritePrometheus writes the metrics of all indexer nodes in prometheus
+// text exposition format.
+func WritePrometheus(w io.Writer) error {
+	metrics := GetNodeMetrics()
+
+	write := func(format string, args ...interface{}) error {
+		_, err := fmt.Fprintf(w, format, args...)
+		return err
+	}
+
+	if err := write("# TYPE gsi_client_requests_total counter\n"); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if err := write("gsi_client_requests_total{node=%q} %d\n", m.Queryport, m.Requests); err != nil {
+			return err
+		}
+	}
+
+	if err := write("# TYPE gsi_client_errors_total counter\n"); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if err := write("gsi_client_errors_total{node=%q} %d\n", m.Queryport, m.Errors); err != nil {
+			return err
+		}
+	}
+
+	if err := write("# TYPE gsi_client_request_seconds histogram\n"); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		var count int64
+		for i, n := range m.Histogram {
+			count += n
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = fmt.Sprintf("%g", latencyBuckets[i].Seconds())
+			}
+			err := write("gsi_client_request_seconds_bucket{node=%q,le=%q} %d\n", m.Queryport, le, count)
+			if err != nil {
+				return err
+			}
+		}
+		err := write("gsi_client_request_seconds_sum{node=%q} %g\n", m.Queryport,
+			time.Duration(m.TotalLatency).Seconds())
+		if err != nil {
+			return err
+		}
+		if err := write("gsi_client_request_seconds_count{node=%q} %d\n", m.Queryport, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestNodeMetrics(t *testing.T) {
+	queryport := "test-node-metrics:9101"
+	m := getNodeMetrics(queryport)
+	if m != getNodeMetrics(queryport) {
+		t.Fatalf("expected the same metrics for %v", queryport)
+	}
+
+	m.record(2*time.Millisecond, nil)
+	m.record(time.Minute, errors.New("failed"))
+
+	// cancelled requests are not failures of the node.
+	m.record(time.Second, common.ErrClientCancel)
+	m.record(time.Second, errors.New(common.ErrClientCancel.Error()))
+	m.record(time.Second, context.Canceled)
+	m.record(time.Second, context.DeadlineExceeded)
+
+	s := m.snapshot(queryport)
+	if s.Requests != 2 || s.Errors != 1 {
+		t.Fatalf("expected 2 requests 1 error, got %v %v", s.Requests, s.Errors)
+	}
+	if s.Histogram[1] != 1 || s.Histogram[len(latencyBuckets)] != 1 {
+		t.Errorf("unexpected histogram %v", s.Histogram)
+	}
+	if rate := m.recentErrorRate(); rate <= 0 || rate >= 1 {
+		t.Errorf("unexpected recent error rate %v", rate)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `gsi_client_request_seconds_bucket{node="test-node-metrics:9101",le="+Inf"} 2`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func TestParseReadPreference(t *testing.T) {
+	testcases := map[string]common.ReadPreference{
+		"":           common.ReadNearest,
+		"nearest":    common.ReadNearest,
+		"PRIMARY":    common.ReadPrimary,
+		"replica_ok": common.ReadReplicaOk,
+		"replica-ok": common.ReadReplicaOk,
+	}
+	for s, expected := range testcases {
+		if readPref, err := common.ParseReadPreference(s); err != nil || readPref != expected {
+			t.Errorf("%q: expected %v, got %v %v", s, expected, readPref, err)
+		}
+	}
+	if _, err := common.ParseReadPreference("secondary"); err == nil {
+		t.Errorf("expected error for invalid read preference")
+	}
+}
+
+func TestOrderByReadPreference(t *testing.T) {
+	currmeta := &indexTopology{
+		insts: map[common.IndexInstId]*mclient.InstanceDefn{
+			10: &mclient.InstanceDefn{InstId: 10, ReplicaId: 1},
+			11: &mclient.InstanceDefn{InstId: 11, ReplicaId: 0},
+			12: &mclient.InstanceDefn{InstId: 12, ReplicaId: 2},
+		},
+	}
+	replicas := []uint64{10, 11, 12}
+
+	testcases := map[common.ReadPreference][]uint64{
+		common.ReadNearest:   {10, 11, 12},
+		common.ReadPrimary:   {11, 10, 12},
+		common.ReadReplicaOk: {10, 12, 11},
+	}
+	for readPref, expected := range testcases {
+		ordered := orderByReadPreference(currmeta, append([]uint64{}, replicas...), readPref)
+		if !reflect.DeepEqual(ordered, expected) {
+			t.Errorf("%v: expected %v, got %v", readPref, expected, ordered)
+		}
+	}
+}
+
+func TestBrokerReadPreference(t *testing.T) {
+	broker := makeDefaultRequestBroker(nil)
+	if readPref := broker.ReadPreference(common.ReadPrimary); readPref != common.ReadPrimary {
+		t.Errorf("expected client read preference, got %v", readPref)
+	}
+
+	// read preference of the request overrides the client's.
+	broker.SetReadPreference(common.ReadNearest)
+	if readPref := broker.ReadPreference(common.ReadPrimary); readPref != common.ReadNearest {
+		t.Errorf("expected request read preference, got %v", readPref)
+	}
+}
+
+func TestClientSettingsReadPreference(t *testing.T) {
+	s := &ClientSettings{}
+	config := common.SystemConfig.Clone()
+	if s.handleReadPreference(config); s.ReadPreference() != common.ReadNearest {
+		t.Errorf("expected default read preference nearest, got %v", s.ReadPreference())
+	}
+
+	config.SetValue("queryport.client.settings.readPreference", "replica_ok")
+	if s.handleReadPreference(config); s.ReadPreference() != common.ReadReplicaOk {
+		t.Errorf("expected read preference replica_ok, got %v", s.ReadPreference())
+	}
+
+	// invalid values are ignored.
+	config.SetValue("queryport.client.settings.readPreference", "secondary")
+	if s.handleReadPreference(config); s.ReadPreference() != common.ReadReplicaOk {
+		t.Errorf("expected read preference replica_ok, got %v", s.ReadPreference())
+	}
+}
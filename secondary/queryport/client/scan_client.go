@@ -10,19 +10,23 @@
 
 package client
 
+import "bytes"
 import "errors"
 import "fmt"
 import "io"
 import "net"
+import "strings"
 import "time"
 import json "github.com/couchbase/indexing/secondary/common/json"
 import "sync/atomic"
 
+import "github.com/couchbase/cbauth"
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/common"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 import "github.com/couchbase/indexing/secondary/transport"
 import "github.com/golang/protobuf/proto"
+import "github.com/couchbase/query/value"
 
 // GsiScanClient for scan operations.
 type GsiScanClient struct {
@@ -41,6 +45,12 @@ type GsiScanClient struct {
 	relConnBatchSize   int32
 
 	serverVersion uint32
+	priority      common.ScanPriority
+	metrics       *nodeMetrics
+
+	// row encodings asked for at helo, and those supported by the server
+	encodings      uint32
+	serverEncoding uint32
 }
 
 func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, error) {
@@ -57,10 +67,32 @@ func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, e
 		logPrefix:          fmt.Sprintf("[GsiScanClient:%q]", queryport),
 		minPoolSizeWM:      int32(config["settings.minPoolSizeWM"].Int()),
 		relConnBatchSize:   int32(config["settings.relConnBatchSize"].Int()),
+		metrics:            getNodeMetrics(queryport),
+	}
+	tlsConfig, err := makeTLSConfig(queryport, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", queryport, err)
+	}
+	if cv, ok := config["settings.scanPriority"]; ok {
+		if c.priority, err = common.ParseScanPriority(cv.String()); err != nil {
+			return nil, fmt.Errorf("%s: %v", queryport, err)
+		}
+	}
+	if cv, ok := config["settings.rowEncoding"]; ok {
+		if c.encodings, err = parseRowEncoding(cv.String()); err != nil {
+			return nil, fmt.Errorf("%s: %v", queryport, err)
+		}
 	}
 	c.pool = newConnectionPool(
 		queryport, c.poolSize, c.poolOverflow, c.maxPayload, c.cpTimeout,
 		c.cpAvailWaitTimeout, c.minPoolSizeWM, c.relConnBatchSize)
+	c.pool.tlsConfig = tlsConfig
+	c.pool.sockOpts = common.NewSocketOptions(config)
+	if cv, ok := config["auth.enabled"]; ok && cv.Bool() {
+		c.pool.authb = func() (string, string, error) {
+			return cbauth.GetHTTPServiceAuth(queryport)
+		}
+	}
 	logging.Infof("%v started ...\n", c.logPrefix)
 
 	if version, err := c.Helo(); err == nil || err == io.EOF {
@@ -83,6 +115,38 @@ func (c *GsiScanClient) RefreshServerVersion() {
 	}
 }
 
+// scanPriority returns the priority to be set on scan requests. The
+// default interactive priority is not sent.
+func (c *GsiScanClient) scanPriority() *uint32 {
+	if c.priority == common.InteractivePriority {
+		return nil
+	}
+	return proto.Uint32(uint32(c.priority))
+}
+
+// rowEncoding returns the row encoding to be set on scan requests, nil
+// if the server does not support the configured encoding.
+func (c *GsiScanClient) rowEncoding() *uint32 {
+	encoding := c.encodings & atomic.LoadUint32(&c.serverEncoding)
+	if encoding&protobuf.EncodingPackedRows == 0 {
+		return nil
+	}
+	return proto.Uint32(encoding)
+}
+
+// parseRowEncoding returns the row encodings named by s.
+func parseRowEncoding(s string) (uint32, error) {
+	switch strings.ToLower(s) {
+	case "", "default":
+		return 0, nil
+	case "packed":
+		return protobuf.EncodingPackedRows, nil
+	case "packed_dict":
+		return protobuf.EncodingPackedRows | protobuf.EncodingKeyDict, nil
+	}
+	return 0, fmt.Errorf("invalid row encoding %q", s)
+}
+
 func (c *GsiScanClient) NeedSessionConsVector() bool {
 	return atomic.LoadUint32(&c.serverVersion) == 0
 }
@@ -91,12 +155,16 @@ func (c *GsiScanClient) Helo() (uint32, error) {
 	req := &protobuf.HeloRequest{
 		Version: proto.Uint32(uint32(protobuf.ProtobufVersion())),
 	}
+	if c.encodings != 0 {
+		req.Encodings = proto.Uint32(c.encodings)
+	}
 
 	resp, err := c.doRequestResponse(req, "")
 	if err != nil {
 		return 0, err
 	}
 	heloResp := resp.(*protobuf.HeloResponse)
+	atomic.StoreUint32(&c.serverEncoding, heloResp.GetEncodings())
 	return heloResp.GetVersion(), nil
 }
 
@@ -208,6 +276,8 @@ func (c *GsiScanClient) Lookup(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
+		Encoding:     c.rowEncoding(),
 		PartitionIds: partnIds,
 		Sorted:       proto.Bool(true),
 	}
@@ -287,6 +357,8 @@ func (c *GsiScanClient) Range(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
+		Encoding:     c.rowEncoding(),
 		PartitionIds: partnIds,
 		Sorted:       proto.Bool(true),
 	}
@@ -316,6 +388,158 @@ func (c *GsiScanClient) Range(
 	return err, partial
 }
 
+// MultiRange scans index for several ranges in a single request.
+func (c *GsiScanClient) MultiRange(
+	defnID uint64, requestId string, bound *protobuf.Range, ranges []*protobuf.Range,
+	distinct bool, limit int64, cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId) (error, bool) {
+
+	connectn, err := c.pool.Get()
+	if err != nil {
+		return err, false
+	}
+	healthy := true
+	closeStream := false
+	conn, pkt := connectn.conn, connectn.pkt
+	defer func() {
+		go func() {
+			if closeStream {
+				_, healthy = c.closeStream(conn, pkt, requestId)
+			}
+			c.pool.Return(connectn, healthy)
+		}()
+	}()
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.ScanRequest{
+		DefnID:       proto.Uint64(defnID),
+		RequestId:    proto.String(requestId),
+		Span:         &protobuf.Span{Range: bound, Ranges: ranges},
+		Distinct:     proto.Bool(distinct),
+		Limit:        proto.Int64(limit),
+		Cons:         proto.Uint32(uint32(cons)),
+		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
+		Encoding:     c.rowEncoding(),
+		PartitionIds: partnIds,
+		Sorted:       proto.Bool(true),
+	}
+	if vector != nil {
+		req.Vector = protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+	}
+	// ---> protobuf.ScanRequest
+	if err := c.sendRequest(conn, pkt, req); err != nil {
+		fmsg := "%v MultiRange(%v) request transport failed `%v`\n"
+		logging.Errorf(fmsg, c.logPrefix, requestId, err)
+		healthy = false
+		return err, false
+	}
+
+	cont, partial := true, false
+	for cont {
+		// <--- protobuf.ResponseStream
+		cont, healthy, err, closeStream = c.streamResponse(conn, pkt, callb, requestId)
+		if err != nil { // if err, cont should have been set to false
+			fmsg := "%v MultiRange(%v) response failed `%v`\n"
+			logging.Errorf(fmsg, c.logPrefix, requestId, err)
+		} else { // partial succeeded
+			partial = true
+		}
+	}
+	return err, partial
+}
+
+// encodeSpans encodes spans as ranges of the scan request, along with
+// the range bounding all of them. Keys of the primary index are plain
+// sequence of binary, spans that cannot match a primary key are skipped.
+func encodeSpans(spans Spans, isPrimary bool) (*protobuf.Range, []*protobuf.Range, error) {
+	ranges := make([]*protobuf.Range, 0, len(spans))
+	encoded := make(Spans, 0, len(spans))
+	for _, span := range spans {
+		var l, h []byte
+		if isPrimary {
+			var what string
+			if len(span.Low) > 0 {
+				if l, what = curePrimaryKey(span.Low[0]); what == "after" {
+					continue
+				}
+			}
+			if len(span.High) > 0 {
+				if h, what = curePrimaryKey(span.High[0]); what == "before" {
+					continue
+				}
+			}
+		} else {
+			var err error
+			if l, err = json.Marshal(span.Low); err != nil {
+				return nil, nil, err
+			}
+			if h, err = json.Marshal(span.High); err != nil {
+				return nil, nil, err
+			}
+		}
+		ranges = append(ranges, &protobuf.Range{
+			Low: l, High: h, Inclusion: proto.Uint32(uint32(span.Inclusion)),
+		})
+		encoded = append(encoded, span)
+	}
+	return boundingRange(encoded, ranges, isPrimary), ranges, nil
+}
+
+// boundingRange returns the range from the lowest low to the highest
+// high of ranges, encoded from spans. It is sent as the single range of
+// the scan request, so that indexers that predate multi-range scans,
+// and ignore the ranges, scan a superset of the spans instead of the
+// full index. Callers apply their predicates on the returned entries.
+func boundingRange(spans Spans, ranges []*protobuf.Range, isPrimary bool) *protobuf.Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	unbounded := func(i int, low bool) bool {
+		if isPrimary && low {
+			return ranges[i].Low == nil
+		} else if isPrimary {
+			return ranges[i].High == nil
+		} else if low {
+			return len(spans[i].Low) == 0
+		}
+		return len(spans[i].High) == 0
+	}
+	less := func(i, j int, low bool) bool {
+		if isPrimary && low {
+			return bytes.Compare(ranges[i].Low, ranges[j].Low) < 0
+		} else if isPrimary {
+			return bytes.Compare(ranges[i].High, ranges[j].High) < 0
+		}
+		x, y := spans[i].High, spans[j].High
+		if low {
+			x, y = spans[i].Low, spans[j].Low
+		}
+		return value.NewValue([]interface{}(x)).Collate(value.NewValue([]interface{}(y))) < 0
+	}
+
+	lowest, highest := 0, 0
+	for i := 1; i < len(ranges); i++ {
+		if !unbounded(lowest, true) && (unbounded(i, true) || less(i, lowest, true)) {
+			lowest = i
+		}
+		if !unbounded(highest, false) && (unbounded(i, false) || less(highest, i, false)) {
+			highest = i
+		}
+	}
+	return &protobuf.Range{
+		Low:       ranges[lowest].Low,
+		High:      ranges[highest].High,
+		Inclusion: proto.Uint32(uint32(Both)),
+	}
+}
+
 // Range scan index between low and high.
 func (c *GsiScanClient) RangePrimary(
 	defnID uint64, requestId string, low, high []byte, inclusion Inclusion,
@@ -356,6 +580,8 @@ func (c *GsiScanClient) RangePrimary(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
+		Encoding:     c.rowEncoding(),
 		PartitionIds: partnIds,
 		Sorted:       proto.Bool(true),
 	}
@@ -418,6 +644,8 @@ func (c *GsiScanClient) ScanAll(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
+		Encoding:     c.rowEncoding(),
 		PartitionIds: partnIds,
 	}
 	if vector != nil {
@@ -452,56 +680,9 @@ func (c *GsiScanClient) MultiScan(
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId) (error, bool) {
 
 	// serialize scans
-	protoScans := make([]*protobuf.Scan, len(scans))
-	for i, scan := range scans {
-		if scan != nil {
-			var equals [][]byte
-			var filters []*protobuf.CompositeElementFilter
-
-			// If Seek is there, then do not marshall Range
-			if len(scan.Seek) > 0 {
-				equals = make([][]byte, len(scan.Seek))
-				for i, seek := range scan.Seek {
-					s, err := json.Marshal(seek)
-					if err != nil {
-						return err, false
-					}
-					equals[i] = s
-				}
-			} else {
-				filters = make([]*protobuf.CompositeElementFilter, len(scan.Filter))
-				if scan.Filter != nil {
-					for j, f := range scan.Filter {
-						var l, h []byte
-						var err error
-						if f.Low != common.MinUnbounded { // Do not encode if unbounded
-							l, err = json.Marshal(f.Low)
-							if err != nil {
-								return err, false
-							}
-						}
-
-						if f.High != common.MaxUnbounded { // Do not encode if unbounded
-							h, err = json.Marshal(f.High)
-							if err != nil {
-								return err, false
-							}
-						}
-
-						fl := &protobuf.CompositeElementFilter{
-							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
-						}
-
-						filters[j] = fl
-					}
-				}
-			}
-			s := &protobuf.Scan{
-				Filters: filters,
-				Equals:  equals,
-			}
-			protoScans[i] = s
-		}
+	protoScans, err := marshallScans(scans)
+	if err != nil {
+		return err, false
 	}
 
 	//IndexProjection
@@ -510,6 +691,8 @@ func (c *GsiScanClient) MultiScan(
 		protoProjection = &protobuf.IndexProjection{
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
+			DocIdOnly:  proto.Bool(projection.DocIdOnly),
+			DocMeta:    proto.Bool(projection.DocMeta),
 		}
 	}
 
@@ -548,6 +731,8 @@ func (c *GsiScanClient) MultiScan(
 		Reverse:         proto.Bool(reverse),
 		Offset:          proto.Int64(offset),
 		RollbackTime:    proto.Int64(rollbackTime),
+		Priority:        c.scanPriority(),
+		Encoding:        c.rowEncoding(),
 		PartitionIds:    partnIds,
 		Sorted:          proto.Bool(true),
 	}
@@ -620,6 +805,7 @@ func (c *GsiScanClient) MultiScanPrimary(
 
 						fl := &protobuf.CompositeElementFilter{
 							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
+							Nulls: f.Nulls.toProto(), Missing: f.Missing.toProto(),
 						}
 
 						filters = append(filters, fl)
@@ -647,6 +833,8 @@ func (c *GsiScanClient) MultiScanPrimary(
 		protoProjection = &protobuf.IndexProjection{
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
+			DocIdOnly:  proto.Bool(projection.DocIdOnly),
+			DocMeta:    proto.Bool(projection.DocMeta),
 		}
 	}
 
@@ -685,6 +873,8 @@ func (c *GsiScanClient) MultiScanPrimary(
 		Reverse:         proto.Bool(reverse),
 		Offset:          proto.Int64(offset),
 		RollbackTime:    proto.Int64(rollbackTime),
+		Priority:        c.scanPriority(),
+		Encoding:        c.rowEncoding(),
 		PartitionIds:    partnIds,
 		Sorted:          proto.Bool(true),
 	}
@@ -714,6 +904,91 @@ func (c *GsiScanClient) MultiScanPrimary(
 	return err, partial
 }
 
+// Intersect scans two or more secondary indexes of the same bucket,
+// hosted by this indexer, and streams back only the docids common to
+// all of them. Entries returned to callb carry no secondary key.
+func (c *GsiScanClient) Intersect(
+	requestId string, iscans []*IntersectScan, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) (error, bool) {
+
+	reqs := make([]*protobuf.ScanRequest, 0, len(iscans))
+	for _, iscan := range iscans {
+		protoScans, err := marshallScans(iscan.Scans)
+		if err != nil {
+			return err, false
+		}
+
+		partnIds := make([]uint64, len(iscan.Partitions))
+		for i, partnId := range iscan.Partitions {
+			partnIds[i] = uint64(partnId)
+		}
+
+		req := &protobuf.ScanRequest{
+			DefnID: proto.Uint64(iscan.DefnID),
+			Span: &protobuf.Span{
+				Range: nil,
+			},
+			RequestId:    proto.String(requestId),
+			Distinct:     proto.Bool(false),
+			Limit:        proto.Int64(0),
+			Cons:         proto.Uint32(uint32(cons)),
+			Scans:        protoScans,
+			RollbackTime: proto.Int64(iscan.RollbackTime),
+			Priority:     c.scanPriority(),
+			PartitionIds: partnIds,
+			Sorted:       proto.Bool(true),
+		}
+		if vector != nil {
+			req.Vector = protobuf.NewTsConsistency(
+				vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+		}
+		reqs = append(reqs, req)
+	}
+
+	connectn, err := c.pool.Get()
+	if err != nil {
+		return err, false
+	}
+	healthy := true
+	closeStream := false
+	conn, pkt := connectn.conn, connectn.pkt
+	defer func() {
+		go func() {
+			if closeStream {
+				_, healthy = c.closeStream(conn, pkt, requestId)
+			}
+			c.pool.Return(connectn, healthy)
+		}()
+	}()
+
+	req := &protobuf.IntersectRequest{
+		Scans:     reqs,
+		Limit:     proto.Int64(limit),
+		RequestId: proto.String(requestId),
+	}
+	// ---> protobuf.IntersectRequest
+	if err := c.sendRequest(conn, pkt, req); err != nil {
+		fmsg := "%v Intersect(%v) request transport failed `%v`\n"
+		logging.Errorf(fmsg, c.logPrefix, requestId, err)
+		healthy = false
+		return err, false
+	}
+
+	cont, partial := true, false
+	for cont {
+		// <--- protobuf.ResponseStream
+		cont, healthy, err, closeStream = c.streamResponse(conn, pkt, callb, requestId)
+		if err != nil { // if err, cont should have been set to false
+			fmsg := "%v Intersect(%v) response failed `%v`\n"
+			logging.Errorf(fmsg, c.logPrefix, requestId, err)
+		} else { // partial succeeded
+			partial = true
+		}
+	}
+	return err, partial
+}
+
 // CountLookup to count number entries for given set of keys.
 func (c *GsiScanClient) CountLookup(
 	defnID uint64, requestId string, values []common.SecondaryKey,
@@ -740,6 +1015,7 @@ func (c *GsiScanClient) CountLookup(
 		Span:         &protobuf.Span{Equals: equals},
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
 		PartitionIds: partnIds,
 	}
 	if vector != nil {
@@ -774,6 +1050,7 @@ func (c *GsiScanClient) CountLookupPrimary(
 		Span:         &protobuf.Span{Equals: values},
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
 		PartitionIds: partnIds,
 	}
 	if vector != nil {
@@ -822,6 +1099,7 @@ func (c *GsiScanClient) CountRange(
 		},
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
 		PartitionIds: partnIds,
 	}
 	if vector != nil {
@@ -861,6 +1139,7 @@ func (c *GsiScanClient) CountRangePrimary(
 		},
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
 		PartitionIds: partnIds,
 	}
 	if vector != nil {
@@ -922,6 +1201,7 @@ func (c *GsiScanClient) MultiScanCount(
 
 						fl := &protobuf.CompositeElementFilter{
 							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
+							Nulls: f.Nulls.toProto(), Missing: f.Missing.toProto(),
 						}
 
 						filters[j] = fl
@@ -951,6 +1231,7 @@ func (c *GsiScanClient) MultiScanCount(
 		Scans:        protoScans,
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
 		PartitionIds: partnIds,
 	}
 
@@ -1012,6 +1293,7 @@ func (c *GsiScanClient) MultiScanCountPrimary(
 
 						fl := &protobuf.CompositeElementFilter{
 							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
+							Nulls: f.Nulls.toProto(), Missing: f.Missing.toProto(),
 						}
 
 						filters = append(filters, fl)
@@ -1049,6 +1331,7 @@ func (c *GsiScanClient) MultiScanCountPrimary(
 		Scans:        protoScans,
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
 		PartitionIds: partnIds,
 	}
 
@@ -1073,7 +1356,7 @@ func (c *GsiScanClient) Scan3(
 	defnID uint64, requestId string, scans Scans,
 	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
 	groupAggr *GroupAggr, sorted bool,
-	cons common.Consistency, vector *TsConsistency,
+	cons common.Consistency, vector *TsConsistency, staleness *StalenessBound, allowPartial, returnSnapshotTs bool,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId) (error, bool) {
 
 	// serialize scans
@@ -1114,6 +1397,7 @@ func (c *GsiScanClient) Scan3(
 
 						fl := &protobuf.CompositeElementFilter{
 							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
+							Nulls: f.Nulls.toProto(), Missing: f.Missing.toProto(),
 						}
 
 						filters[j] = fl
@@ -1128,54 +1412,7 @@ func (c *GsiScanClient) Scan3(
 		}
 	}
 
-	//IndexProjection
-	var protoProjection *protobuf.IndexProjection
-	if projection != nil {
-		protoProjection = &protobuf.IndexProjection{
-			EntryKeys:  projection.EntryKeys,
-			PrimaryKey: proto.Bool(projection.PrimaryKey),
-		}
-	}
-
-	// Groups and Aggregates
-	var protoGroupAggr *protobuf.GroupAggr
-	if groupAggr != nil {
-		// GroupKeys
-		protoGroupKeys := make([]*protobuf.GroupKey, len(groupAggr.Group))
-		for i, grp := range groupAggr.Group {
-			gk := &protobuf.GroupKey{
-				EntryKeyId: proto.Int32(grp.EntryKeyId),
-				KeyPos:     proto.Int32(grp.KeyPos),
-				Expr:       []byte(grp.Expr),
-			}
-			protoGroupKeys[i] = gk
-		}
-		// Aggregates
-		protoAggregates := make([]*protobuf.Aggregate, len(groupAggr.Aggrs))
-		for i, aggr := range groupAggr.Aggrs {
-			ag := &protobuf.Aggregate{
-				AggrFunc:   proto.Uint32(uint32(aggr.AggrFunc)),
-				EntryKeyId: proto.Int32(aggr.EntryKeyId),
-				KeyPos:     proto.Int32(aggr.KeyPos),
-				Expr:       []byte(aggr.Expr),
-				Distinct:   proto.Bool(aggr.Distinct),
-			}
-			protoAggregates[i] = ag
-		}
-		protoIndexKeyNames := make([][]byte, len(groupAggr.IndexKeyNames))
-		for i, keyName := range groupAggr.IndexKeyNames {
-			protoIndexKeyNames[i] = []byte(keyName)
-		}
-		protoGroupAggr = &protobuf.GroupAggr{
-			Name:               []byte(groupAggr.Name),
-			GroupKeys:          protoGroupKeys,
-			Aggrs:              protoAggregates,
-			DependsOnIndexKeys: groupAggr.DependsOnIndexKeys,
-			IndexKeyNames:      protoIndexKeyNames,
-			AllowPartialAggr:   proto.Bool(groupAggr.AllowPartialAggr),
-			OnePerPrimaryKey:   proto.Bool(groupAggr.OnePerPrimaryKey),
-		}
-	}
+	protoProjection, protoGroupAggr := marshallScan3Params(projection, groupAggr)
 
 	connectn, err := c.pool.Get()
 	if err != nil {
@@ -1212,9 +1449,18 @@ func (c *GsiScanClient) Scan3(
 		Reverse:         proto.Bool(reverse),
 		Offset:          proto.Int64(offset),
 		RollbackTime:    proto.Int64(rollbackTime),
+		Priority:        c.scanPriority(),
+		Encoding:        c.rowEncoding(),
 		PartitionIds:    partnIds,
 		GroupAggr:       protoGroupAggr,
 		Sorted:          proto.Bool(sorted),
+		Staleness:       staleness.toProto(),
+	}
+	if allowPartial {
+		req.AllowPartial = proto.Bool(true)
+	}
+	if returnSnapshotTs {
+		req.ReturnSnapshotTs = proto.Bool(true)
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -1246,7 +1492,7 @@ func (c *GsiScanClient) Scan3Primary(
 	defnID uint64, requestId string, scans Scans,
 	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
 	groupAggr *GroupAggr, sorted bool,
-	cons common.Consistency, vector *TsConsistency,
+	cons common.Consistency, vector *TsConsistency, staleness *StalenessBound, allowPartial, returnSnapshotTs bool,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId) (error, bool) {
 
 	var what string
@@ -1286,6 +1532,7 @@ func (c *GsiScanClient) Scan3Primary(
 
 						fl := &protobuf.CompositeElementFilter{
 							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
+							Nulls: f.Nulls.toProto(), Missing: f.Missing.toProto(),
 						}
 
 						filters = append(filters, fl)
@@ -1313,6 +1560,8 @@ func (c *GsiScanClient) Scan3Primary(
 		protoProjection = &protobuf.IndexProjection{
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
+			DocIdOnly:  proto.Bool(projection.DocIdOnly),
+			DocMeta:    proto.Bool(projection.DocMeta),
 		}
 	}
 
@@ -1389,9 +1638,18 @@ func (c *GsiScanClient) Scan3Primary(
 		Reverse:         proto.Bool(reverse),
 		Offset:          proto.Int64(offset),
 		RollbackTime:    proto.Int64(rollbackTime),
+		Priority:        c.scanPriority(),
+		Encoding:        c.rowEncoding(),
 		PartitionIds:    partnIds,
 		GroupAggr:       protoGroupAggr,
 		Sorted:          proto.Bool(sorted),
+		Staleness:       staleness.toProto(),
+	}
+	if allowPartial {
+		req.AllowPartial = proto.Bool(true)
+	}
+	if returnSnapshotTs {
+		req.ReturnSnapshotTs = proto.Bool(true)
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -1419,6 +1677,108 @@ func (c *GsiScanClient) Scan3Primary(
 	return err, partial
 }
 
+// ExplainScan3 runs the scan on the partitions without returning rows,
+// and returns the json encoded trace of the scan by the indexer.
+func (c *GsiScanClient) ExplainScan3(
+	defnID uint64, requestId string, scans Scans,
+	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, sorted bool,
+	cons common.Consistency, vector *TsConsistency,
+	rollbackTime int64, partitions []common.PartitionId) ([]byte, error) {
+
+	protoScans, err := marshallScans(scans)
+	if err != nil {
+		return nil, err
+	}
+	protoProjection, protoGroupAggr := marshallScan3Params(projection, groupAggr)
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.ScanRequest{
+		DefnID: proto.Uint64(defnID),
+		Span: &protobuf.Span{
+			Range: nil,
+		},
+		RequestId:       proto.String(requestId),
+		Distinct:        proto.Bool(distinct),
+		Limit:           proto.Int64(limit),
+		Cons:            proto.Uint32(uint32(cons)),
+		Scans:           protoScans,
+		Indexprojection: protoProjection,
+		Reverse:         proto.Bool(reverse),
+		Offset:          proto.Int64(offset),
+		RollbackTime:    proto.Int64(rollbackTime),
+		Priority:        c.scanPriority(),
+		PartitionIds:    partnIds,
+		GroupAggr:       protoGroupAggr,
+		Sorted:          proto.Bool(sorted),
+		Explain:         proto.Bool(true),
+	}
+	if vector != nil {
+		req.Vector = protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+	}
+
+	resp, err := c.doRequestResponse(req, requestId)
+	if err != nil {
+		return nil, err
+	}
+	streamResp := resp.(*protobuf.ResponseStream)
+	if streamResp.GetErr() != nil {
+		err = errors.New(streamResp.GetErr().GetError())
+		return nil, err
+	}
+	return streamResp.GetExplain(), nil
+}
+
+// EstimateScan3 returns the number of entries qualified by scans on the
+// partitions, estimated by the indexer from key statistics, without
+// scanning the index.
+func (c *GsiScanClient) EstimateScan3(
+	defnID uint64, requestId string, scans Scans,
+	rollbackTime int64, partitions []common.PartitionId) (int64, error) {
+
+	protoScans, err := marshallScans(scans)
+	if err != nil {
+		return 0, err
+	}
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.ScanRequest{
+		DefnID: proto.Uint64(defnID),
+		Span: &protobuf.Span{
+			Range: nil,
+		},
+		RequestId:    proto.String(requestId),
+		Distinct:     proto.Bool(false),
+		Limit:        proto.Int64(0),
+		Cons:         proto.Uint32(uint32(common.AnyConsistency)),
+		Scans:        protoScans,
+		RollbackTime: proto.Int64(rollbackTime),
+		Priority:     c.scanPriority(),
+		PartitionIds: partnIds,
+		EstimateOnly: proto.Bool(true),
+	}
+
+	resp, err := c.doRequestResponse(req, requestId)
+	if err != nil {
+		return 0, err
+	}
+	streamResp := resp.(*protobuf.ResponseStream)
+	if streamResp.GetErr() != nil {
+		err = errors.New(streamResp.GetErr().GetError())
+		return 0, err
+	}
+	return int64(streamResp.GetEstimatedRows()), nil
+}
+
 func (c *GsiScanClient) Close() error {
 	return c.pool.Close()
 }
@@ -1510,6 +1870,16 @@ func (c *GsiScanClient) streamResponse(
 		callb(&protobuf.StreamEndResponse{}) // callback most likely return true
 		cont, healthy = false, true
 
+	} else if authResp, ok := resp.(*protobuf.AuthResponse); ok {
+		// server rejected an unauthenticated connection.
+		err = authResp.Error()
+		if err == nil {
+			err = ErrorProtocol
+		}
+		fmsg := "%v req(%v) connection %q rejected `%v`\n"
+		logging.Errorf(fmsg, c.logPrefix, requestId, laddr, err)
+		cont, healthy = false, false
+
 	} else {
 		streamResp := resp.(*protobuf.ResponseStream)
 		if err = streamResp.Error(); err == nil {
@@ -1571,6 +1941,144 @@ func (c *GsiScanClient) trySetDeadline(conn net.Conn, deadline time.Duration) {
 	}
 }
 
+// toProto serializes the staleness bound, nil if there is none.
+func (b *StalenessBound) toProto() *protobuf.StalenessBound {
+	if b == nil {
+		return nil
+	}
+	return &protobuf.StalenessBound{
+		MaxSeqnos: proto.Uint64(b.Seqnos),
+		MaxTime:   proto.Int64(int64(b.Time / time.Millisecond)),
+		Wait:      proto.Bool(b.Wait),
+	}
+}
+
+// toProto serializes the inclusion of NULL or MISSING values, nil if
+// it is by range.
+func (n NullMissingInclusion) toProto() *uint32 {
+	if n == NullMissingByRange {
+		return nil
+	}
+	return proto.Uint32(uint32(n))
+}
+
+// marshallScan3Params serializes the projection and the group
+// aggregates of a Scan3 request into their protobuf representation.
+func marshallScan3Params(projection *IndexProjection,
+	groupAggr *GroupAggr) (*protobuf.IndexProjection, *protobuf.GroupAggr) {
+
+	//IndexProjection
+	var protoProjection *protobuf.IndexProjection
+	if projection != nil {
+		protoProjection = &protobuf.IndexProjection{
+			EntryKeys:  projection.EntryKeys,
+			PrimaryKey: proto.Bool(projection.PrimaryKey),
+			DocIdOnly:  proto.Bool(projection.DocIdOnly),
+			DocMeta:    proto.Bool(projection.DocMeta),
+		}
+	}
+
+	// Groups and Aggregates
+	var protoGroupAggr *protobuf.GroupAggr
+	if groupAggr != nil {
+		// GroupKeys
+		protoGroupKeys := make([]*protobuf.GroupKey, len(groupAggr.Group))
+		for i, grp := range groupAggr.Group {
+			gk := &protobuf.GroupKey{
+				EntryKeyId: proto.Int32(grp.EntryKeyId),
+				KeyPos:     proto.Int32(grp.KeyPos),
+				Expr:       []byte(grp.Expr),
+			}
+			protoGroupKeys[i] = gk
+		}
+		// Aggregates
+		protoAggregates := make([]*protobuf.Aggregate, len(groupAggr.Aggrs))
+		for i, aggr := range groupAggr.Aggrs {
+			ag := &protobuf.Aggregate{
+				AggrFunc:   proto.Uint32(uint32(aggr.AggrFunc)),
+				EntryKeyId: proto.Int32(aggr.EntryKeyId),
+				KeyPos:     proto.Int32(aggr.KeyPos),
+				Expr:       []byte(aggr.Expr),
+				Distinct:   proto.Bool(aggr.Distinct),
+			}
+			protoAggregates[i] = ag
+		}
+		protoIndexKeyNames := make([][]byte, len(groupAggr.IndexKeyNames))
+		for i, keyName := range groupAggr.IndexKeyNames {
+			protoIndexKeyNames[i] = []byte(keyName)
+		}
+		protoGroupAggr = &protobuf.GroupAggr{
+			Name:               []byte(groupAggr.Name),
+			GroupKeys:          protoGroupKeys,
+			Aggrs:              protoAggregates,
+			DependsOnIndexKeys: groupAggr.DependsOnIndexKeys,
+			IndexKeyNames:      protoIndexKeyNames,
+			AllowPartialAggr:   proto.Bool(groupAggr.AllowPartialAggr),
+			OnePerPrimaryKey:   proto.Bool(groupAggr.OnePerPrimaryKey),
+		}
+	}
+
+	return protoProjection, protoGroupAggr
+}
+
+// marshallScans serializes Scans on a secondary index into their
+// protobuf representation.
+func marshallScans(scans Scans) ([]*protobuf.Scan, error) {
+	protoScans := make([]*protobuf.Scan, len(scans))
+	for i, scan := range scans {
+		if scan != nil {
+			var equals [][]byte
+			var filters []*protobuf.CompositeElementFilter
+
+			// If Seek is there, then do not marshall Range
+			if len(scan.Seek) > 0 {
+				equals = make([][]byte, len(scan.Seek))
+				for i, seek := range scan.Seek {
+					s, err := json.Marshal(seek)
+					if err != nil {
+						return nil, err
+					}
+					equals[i] = s
+				}
+			} else {
+				filters = make([]*protobuf.CompositeElementFilter, len(scan.Filter))
+				if scan.Filter != nil {
+					for j, f := range scan.Filter {
+						var l, h []byte
+						var err error
+						if f.Low != common.MinUnbounded { // Do not encode if unbounded
+							l, err = json.Marshal(f.Low)
+							if err != nil {
+								return nil, err
+							}
+						}
+
+						if f.High != common.MaxUnbounded { // Do not encode if unbounded
+							h, err = json.Marshal(f.High)
+							if err != nil {
+								return nil, err
+							}
+						}
+
+						fl := &protobuf.CompositeElementFilter{
+							Low: l, High: h, Inclusion: proto.Uint32(uint32(f.Inclusion)),
+							Nulls: f.Nulls.toProto(), Missing: f.Missing.toProto(),
+						}
+
+						filters[j] = fl
+					}
+				}
+			}
+			s := &protobuf.Scan{
+				Filters: filters,
+				Equals:  equals,
+			}
+			protoScans[i] = s
+		}
+	}
+	return protoScans, nil
+}
+
 func getEmptySpanForPrimary() *protobuf.Scan {
 	fl := &protobuf.CompositeElementFilter{
 		Low: []byte(""), High: []byte(""), Inclusion: proto.Uint32(uint32(0)),
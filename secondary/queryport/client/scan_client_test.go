@@ -0,0 +1,72 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestEncodeSpansPrimary(t *testing.T) {
+	spans := Spans{
+		&Span{Low: common.SecondaryKey{"m"}, High: common.SecondaryKey{"p"}, Inclusion: Low},
+		&Span{Low: common.SecondaryKey{[]interface{}{"a"}}, Inclusion: Both},
+		&Span{Low: common.SecondaryKey{"c"}, High: common.SecondaryKey{"e"}, Inclusion: Both},
+	}
+	bound, ranges, err := encodeSpans(spans, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// span starting after all primary keys is skipped.
+	if len(ranges) != 2 {
+		t.Fatalf("expected 2 ranges, got %v", ranges)
+	}
+	if string(ranges[0].Low) != "m" || string(ranges[0].High) != "p" ||
+		ranges[0].GetInclusion() != uint32(Low) {
+		t.Errorf("unexpected range %v", ranges[0])
+	}
+	if string(bound.Low) != "c" || string(bound.High) != "p" ||
+		bound.GetInclusion() != uint32(Both) {
+		t.Errorf("unexpected bounding range %v", bound)
+	}
+
+	// an unbounded span makes the bounding range unbounded.
+	spans = append(spans, &Span{High: common.SecondaryKey{"d"}, Inclusion: Both})
+	if bound, _, _ = encodeSpans(spans, true); bound.Low != nil || string(bound.High) != "p" {
+		t.Errorf("unexpected bounding range %v", bound)
+	}
+
+	if bound, ranges, _ = encodeSpans(Spans{spans[1]}, true); bound != nil || len(ranges) != 0 {
+		t.Errorf("expected no ranges, got %v %v", bound, ranges)
+	}
+}
+
+func TestEncodeSpansSecondary(t *testing.T) {
+	spans := Spans{
+		&Span{Low: common.SecondaryKey{5.0, "a"}, High: common.SecondaryKey{9.0}, Inclusion: Both},
+		&Span{Low: common.SecondaryKey{"x"}, High: common.SecondaryKey{"y"}, Inclusion: Low},
+		&Span{Low: common.SecondaryKey{1.0}, High: common.SecondaryKey{6.0}, Inclusion: High},
+	}
+	bound, ranges, err := encodeSpans(spans, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 3 {
+		t.Fatalf("expected 3 ranges, got %v", ranges)
+	}
+	if string(ranges[0].Low) != `[5,"a"]` || string(ranges[0].High) != `[9]` {
+		t.Errorf("unexpected range %v", ranges[0])
+	}
+
+	// numbers collate before strings.
+	if string(bound.Low) != `[1]` || string(bound.High) != `["y"]` ||
+		bound.GetInclusion() != uint32(Both) {
+		t.Errorf("unexpected bounding range %v", bound)
+	}
+
+	spans = append(spans, &Span{Low: common.SecondaryKey{2.0}, Inclusion: Both})
+	bound, ranges, _ = encodeSpans(spans, false)
+	if string(bound.Low) != `[1]` || string(bound.High) != string(ranges[3].High) {
+		t.Errorf("expected unbounded high, got %v", bound)
+	}
+}
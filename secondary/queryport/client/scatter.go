@@ -78,16 +78,52 @@ type RequestBroker struct {
 	pushdownOffset int64
 	pushdownSorted bool
 	scans          Scans
+	scanPartns     []common.PartitionId // partition of each scan, if eliminated
 	grpAggr        *GroupAggr
 	projections    *IndexProjection
 	indexOrder     *IndexKeyOrder
 	projDesc       []bool
 	distinct       bool
 
+	// partial aggregates combined by the broker
+	combiner        *aggrCombiner
+	pushdownGrpAggr *GroupAggr
+
+	// replica selection
+	readPref    common.ReadPreference
+	hasReadPref bool
+
+	// staleness tolerated by StalenessConsistency
+	staleness *StalenessBound
+
+	// partial scan, skipping vbuckets quarantined by indexers
+	allowPartial bool
+	missingVbs   map[uint32]bool
+
+	// timestamp of the snapshots scanned, returned as consistency token
+	returnSnapshotTs bool
+	snapshotTs       map[uint16][2]uint64 // vbno -> {seqno, vbuuid}
+
+	// result size estimated by indexers, by response handler
+	estimates map[ResponseHandlerId]int64
+
 	// stats
 	sendCount    int64
 	receiveCount int64
 	numIndexers  int64
+
+	// resume
+	resumable bool
+	resuming  bool
+	resumed   bool
+	sentRows  int64
+	lastPkey  []byte
+	lastSkey  common.SecondaryKey
+	resumeKey []value.Value
+
+	// consistency vector, pinned across retries of the scan
+	pinned bool
+	vector *TsConsistency
 }
 
 type doneStatus struct {
@@ -161,6 +197,211 @@ func (b *RequestBroker) SetResponseTimer(timer ResponseTimer) {
 	b.timer = timer
 }
 
+//
+// Set whether the scan can be resumed from the last row sent, if it fails
+// after sending rows.  The caller must ensure the rows are returned in
+// index order.
+//
+func (b *RequestBroker) SetResumable(resumable bool) {
+
+	b.resumable = resumable
+}
+
+//
+// Set ReadPreference, overrides the read preference configured for the
+// client, for this request.
+//
+func (b *RequestBroker) SetReadPreference(readPref common.ReadPreference) {
+
+	b.readPref = readPref
+	b.hasReadPref = true
+}
+
+//
+// Get ReadPreference for this request, falls back to `dflt` if
+// read preference is not set for the request.
+//
+func (b *RequestBroker) ReadPreference(dflt common.ReadPreference) common.ReadPreference {
+
+	if b.hasReadPref {
+		return b.readPref
+	}
+	return dflt
+}
+
+//
+// Set staleness bound, for scans with StalenessConsistency.
+//
+func (b *RequestBroker) SetStaleness(staleness *StalenessBound) {
+
+	b.staleness = staleness
+}
+
+//
+// Get staleness bound
+//
+func (b *RequestBroker) GetStaleness() *StalenessBound {
+
+	return b.staleness
+}
+
+//
+// Allow partial results, from the vbuckets available on indexers.
+//
+func (b *RequestBroker) SetAllowPartial(allowPartial bool) {
+
+	b.allowPartial = allowPartial
+}
+
+//
+// Get whether partial results are allowed
+//
+func (b *RequestBroker) GetAllowPartial() bool {
+
+	return b.allowPartial
+}
+
+//
+// Record vbuckets missing in the results of an indexer
+//
+func (b *RequestBroker) addMissingVbuckets(vbs []uint32) {
+
+	if len(vbs) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.missingVbs == nil {
+		b.missingVbs = make(map[uint32]bool)
+	}
+	for _, vb := range vbs {
+		b.missingVbs[vb] = true
+	}
+}
+
+//
+// Get vbuckets missing in the results of a partial scan, in ascending order
+//
+func (b *RequestBroker) MissingVbuckets() []uint16 {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	vbs := make([]uint16, 0, len(b.missingVbs))
+	for vb := range b.missingVbs {
+		vbs = append(vbs, uint16(vb))
+	}
+	sort.Slice(vbs, func(i, j int) bool { return vbs[i] < vbs[j] })
+	return vbs
+}
+
+//
+// Return the timestamp of the snapshots scanned by indexers
+//
+func (b *RequestBroker) SetReturnSnapshotTs(returnSnapshotTs bool) {
+
+	b.returnSnapshotTs = returnSnapshotTs
+}
+
+//
+// Get whether the timestamp of the snapshots scanned is returned
+//
+func (b *RequestBroker) GetReturnSnapshotTs() bool {
+
+	return b.returnSnapshotTs
+}
+
+//
+// Merge the timestamp of a snapshot scanned by an indexer, keeping the
+// highest seqno of each vbucket across indexers
+//
+func (b *RequestBroker) addSnapshotTs(vbnos []uint32, seqnos, vbuuids []uint64) {
+
+	if len(vbnos) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.snapshotTs == nil {
+		b.snapshotTs = make(map[uint16][2]uint64)
+	}
+	for i, vbno := range vbnos {
+		if i >= len(seqnos) || i >= len(vbuuids) {
+			break
+		}
+		if curr, ok := b.snapshotTs[uint16(vbno)]; !ok || seqnos[i] > curr[0] {
+			b.snapshotTs[uint16(vbno)] = [2]uint64{seqnos[i], vbuuids[i]}
+		}
+	}
+}
+
+//
+// Get the timestamp of the snapshots scanned, nil if not returned by indexers
+//
+func (b *RequestBroker) SnapshotTs() *TsConsistency {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.snapshotTs == nil {
+		return nil
+	}
+
+	vbnos := make([]uint16, 0, len(b.snapshotTs))
+	for vbno := range b.snapshotTs {
+		vbnos = append(vbnos, vbno)
+	}
+	sort.Slice(vbnos, func(i, j int) bool { return vbnos[i] < vbnos[j] })
+
+	seqnos := make([]uint64, len(vbnos))
+	vbuuids := make([]uint64, len(vbnos))
+	for i, vbno := range vbnos {
+		seqnos[i], vbuuids[i] = b.snapshotTs[vbno][0], b.snapshotTs[vbno][1]
+	}
+	return NewTsConsistency(vbnos, seqnos, vbuuids)
+}
+
+//
+// Record the result size estimated by the indexer of a response handler
+//
+func (b *RequestBroker) addEstimate(id ResponseHandlerId, rows uint64) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.estimates == nil {
+		b.estimates = make(map[ResponseHandlerId]int64)
+	}
+	b.estimates[id] = int64(rows)
+}
+
+//
+// Get the result size estimated by indexers, capped at the limit of the
+// request, -1 if not estimated by all indexers scanned
+//
+func (b *RequestBroker) EstimatedRows() int64 {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.estimates) == 0 || int64(len(b.estimates)) < b.NumIndexers() {
+		return -1
+	}
+
+	var rows int64
+	for _, n := range b.estimates {
+		rows += n
+	}
+	if b.limit > 0 && rows > b.limit {
+		rows = b.limit
+	}
+	return rows
+}
+
 //
 // Set Limit
 //
@@ -228,6 +469,34 @@ func (b *RequestBroker) SetScans(scans Scans) {
 	b.scans = scans
 }
 
+//
+// Get the scans to be sent to the indexer serving the given partitions.
+// If every scan is restricted to a single partition by the partition key,
+// only the scans on the given partitions are returned, so that each span is
+// routed only to the indexer owning its partition.  Otherwise, all scans are
+// returned.
+//
+func (b *RequestBroker) ScansForPartitions(partitions []common.PartitionId) Scans {
+
+	if len(b.scanPartns) == 0 || len(b.scanPartns) != len(b.scans) {
+		return b.scans
+	}
+
+	owned := make(map[common.PartitionId]bool, len(partitions))
+	for _, partnId := range partitions {
+		owned[partnId] = true
+	}
+
+	scans := make(Scans, 0, len(b.scans))
+	for i, scan := range b.scans {
+		if owned[b.scanPartns[i]] {
+			scans = append(scans, scan)
+		}
+	}
+
+	return scans
+}
+
 //
 // Set GroupAggr
 //
@@ -236,6 +505,17 @@ func (b *RequestBroker) SetGroupAggr(grpAggr *GroupAggr) {
 	b.grpAggr = grpAggr
 }
 
+//
+// Get GroupAggr to push down to indexers
+//
+func (b *RequestBroker) GetGroupAggr() *GroupAggr {
+
+	if b.pushdownGrpAggr != nil {
+		return b.pushdownGrpAggr
+	}
+	return b.grpAggr
+}
+
 //
 // Set Projection
 //
@@ -435,9 +715,19 @@ func (b *RequestBroker) reset() {
 	b.sendCount = 0
 	b.receiveCount = 0
 	b.numIndexers = 0
+	b.estimates = nil
+	b.snapshotTs = nil
+	b.missingVbs = nil
 
 	// scans
 	b.defn = nil
+	b.scanPartns = nil
+	if b.combiner != nil {
+		b.limit = b.combiner.limit
+		b.offset = b.combiner.offset
+		b.combiner = nil
+	}
+	b.pushdownGrpAggr = nil
 	b.pushdownLimit = b.limit
 	b.pushdownOffset = b.offset
 	b.pushdownSorted = b.sorted
@@ -480,6 +770,12 @@ func (c *RequestBroker) scatter(clientMaker scanClientMaker, index *common.Index
 	c.analyzeOrderBy(partition, numPartition, index)
 	c.analyzeProjection(partition, numPartition, index)
 	c.changePushdownParams(partition, numPartition, index)
+	c.analyzeAggregate(partition, numPartition, index)
+
+	// indexers return again the rows up to the last row sent
+	if c.resumed {
+		c.pushdownLimit, c.pushdownOffset = math.MaxInt64, 0
+	}
 
 	if len(partition) == len(client) {
 		for i, partitions := range partition {
@@ -691,6 +987,10 @@ func (c *RequestBroker) scatterScan2(client []*GsiScanClient, index *common.Inde
 	errMap = c.GetError()
 	partial = c.IsPartial()
 
+	if c.combiner != nil && len(errMap) == 0 {
+		c.combiner.flush(c.sender)
+	}
+
 	return
 }
 
@@ -895,6 +1195,11 @@ func (c *RequestBroker) gather(donech chan bool) {
 
 		if c.queues[id].Dequeue(&rows[id]) {
 
+			// skip rows sent before resume
+			if c.skipResumed(rows[id].pkey, rows[id].skey) {
+				continue
+			}
+
 			// skip offset
 			if curOffset < c.offset {
 				curOffset++
@@ -903,7 +1208,7 @@ func (c *RequestBroker) gather(donech chan bool) {
 
 			curLimit++
 			c.Partial(true)
-			if !c.sender(rows[id].pkey, rows[id].value, rows[id].skey) {
+			if !c.send(rows[id].pkey, rows[id].value, rows[id].skey) {
 				c.done()
 				return
 			}
@@ -971,6 +1276,11 @@ func (c *RequestBroker) forward(donech chan bool) {
 
 				if c.queues[i].Dequeue(&rows[i]) {
 
+					// skip rows sent before resume
+					if c.skipResumed(rows[i].pkey, rows[i].skey) {
+						continue
+					}
+
 					// skip offset
 					if curOffset < c.offset {
 						curOffset++
@@ -979,7 +1289,7 @@ func (c *RequestBroker) forward(donech chan bool) {
 
 					curLimit++
 					c.Partial(true)
-					if !c.sender(rows[i].pkey, rows[i].value, rows[i].skey) {
+					if !c.send(rows[i].pkey, rows[i].value, rows[i].skey) {
 						c.done()
 						return
 					}
@@ -1072,6 +1382,7 @@ func (c *RequestBroker) scanSingleNode(id ResponseHandlerId, client *GsiScanClie
 
 	begin := time.Now()
 	err, partial := c.scan(client, index, rollback, partition, c.factory(id, instId, partition))
+	client.metrics.record(time.Since(begin), err)
 	if err != nil {
 		// If there is any error, then stop the broker.
 		// This will force other go-routine to terminate.
@@ -1103,7 +1414,9 @@ func (c *RequestBroker) countSingleNode(id ResponseHandlerId, client *GsiScanCli
 		return
 	}
 
+	begin := time.Now()
 	cnt, err, partial := c.count(client, index, rollback, partition)
+	client.metrics.record(time.Since(begin), err)
 	if err != nil {
 		// If there is any error, then stop the broker.
 		// This will force other go-routine to terminate.
@@ -1144,14 +1457,7 @@ func (c *RequestBroker) SendEntries(id ResponseHandlerId, pkeys [][]byte, skeys
 		if c.useGather() {
 			var vals []value.Value
 			if c.sorted {
-				vals = make([]value.Value, len(skey))
-				for j := 0; j < len(skey); j++ {
-					if s, ok := skey[j].(string); ok && collatejson.MissingLiteral.Equal(s) {
-						vals[j] = value.NewMissingValue()
-					} else {
-						vals[j] = value.NewValue(skey[j])
-					}
-				}
+				vals = skeyToValues(skey)
 			}
 
 			var r Row
@@ -1161,8 +1467,12 @@ func (c *RequestBroker) SendEntries(id ResponseHandlerId, pkeys [][]byte, skeys
 			c.queues[int(id)].Enqueue(&r)
 		} else {
 
+			if c.skipResumed(pkeys[i], skey) {
+				continue
+			}
+
 			c.Partial(true)
-			if !c.sender(pkeys[i], nil, skey) {
+			if !c.send(pkeys[i], nil, skey) {
 				c.done()
 				return false
 			}
@@ -1176,6 +1486,239 @@ func (c *RequestBroker) SendEntries(id ResponseHandlerId, pkeys [][]byte, skeys
 	return !c.isClose()
 }
 
+func skeyToValues(skey common.SecondaryKey) []value.Value {
+
+	vals := make([]value.Value, len(skey))
+	for j := 0; j < len(skey); j++ {
+		if s, ok := skey[j].(string); ok && collatejson.MissingLiteral.Equal(s) {
+			vals[j] = value.NewMissingValue()
+		} else {
+			vals[j] = value.NewValue(skey[j])
+		}
+	}
+	return vals
+}
+
+//--------------------------
+// resume
+//--------------------------
+
+//
+// Forward a row to the sender, remembering the last row sent in case the
+// scan has to be resumed.  A resumed scan is not limited by the indexers,
+// since they may return again some of the rows already sent, so the
+// limit is applied here.
+//
+func (c *RequestBroker) send(pkey []byte, mskey []value.Value, uskey common.SecondaryKey) bool {
+
+	if c.combiner != nil {
+		return c.combiner.add(mskey, uskey)
+	}
+
+	if c.resumed && c.sentRows >= c.limit {
+		return false
+	}
+
+	if c.resumable && c.inIndexOrder() {
+		c.lastPkey = pkey
+		c.lastSkey = uskey
+		c.sentRows++
+	}
+
+	return c.sender(pkey, mskey, uskey)
+}
+
+//
+// When a scan is resumed, skip the rows up to the last row sent by the
+// failed scan.  Rows are skipped before offset and limit are applied,
+// since they have been accounted by the failed scan.
+//
+func (c *RequestBroker) skipResumed(pkey []byte, skey common.SecondaryKey) bool {
+
+	if !c.resuming {
+		return false
+	}
+
+	if c.compareLastRow(pkey, skey) <= 0 {
+		return true
+	}
+
+	c.resuming = false
+	return false
+}
+
+//
+// Whether rows are sent in index order from a single go-routine, either
+// from a single indexer or merged from multiple indexers by gather.
+//
+func (c *RequestBroker) inIndexOrder() bool {
+
+	return c.NumIndexers() == 1 || (c.useGather() && c.sorted)
+}
+
+//
+// Get the consistency vector of the scan.  The vector is computed once,
+// and pinned for retries of the scan, so that a resumed scan reads a
+// snapshot at least as recent as the one read by the failed scan.  A nil
+// vector is left to the indexer, and is not pinned.
+//
+func (c *RequestBroker) PinConsistency(get func() (*TsConsistency, error)) (*TsConsistency, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.pinned {
+		return c.vector, nil
+	}
+
+	vector, err := get()
+	if err != nil || vector == nil {
+		return vector, err
+	}
+
+	c.pinned, c.vector = true, vector
+	return vector, nil
+}
+
+//
+// Whether a scan that failed after sending rows can be resumed from the
+// last row sent.  This requires the rows to be sent in index order, with
+// all index keys and the primary key in each row.
+//
+func (c *RequestBroker) CanResume() bool {
+
+	if !c.resumable || c.lastPkey == nil || c.defn == nil {
+		return false
+	}
+
+	if c.scan == nil || c.distinct || c.grpAggr != nil || !c.inIndexOrder() {
+		return false
+	}
+
+	if c.projections != nil {
+		if c.projections.DocIdOnly || !c.projections.PrimaryKey {
+			return false
+		}
+
+		projection := make(map[int64]bool)
+		for _, position := range c.projections.EntryKeys {
+			projection[position] = true
+		}
+		for i := range c.defn.SecExprs {
+			if !projection[int64(i)] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+//
+// Resume the scan on next scatter from the last row sent.  The scans are
+// narrowed to start from the leading key of the last row, and rows up to
+// the last row are skipped.  Offset is already applied by the failed scan,
+// and the limit is reduced by the rows already sent.
+//
+func (c *RequestBroker) Resume() {
+
+	c.resuming = true
+	c.resumed = true
+	c.resumeKey = skeyToValues(c.lastSkey)
+
+	c.offset = 0
+	if c.limit != math.MaxInt64 {
+		c.limit -= c.sentRows
+	}
+	c.sentRows = 0
+
+	if len(c.lastSkey) != 0 {
+		desc := c.defn != nil && len(c.defn.Desc) != 0 && c.defn.Desc[0]
+		c.scans = resumeScans(c.scans, c.lastSkey[0], desc)
+	}
+}
+
+//
+// Narrow the range of the leading key of each scan to start from the
+// given key, in index order.  Scans that do not cover key are left as is.
+//
+func resumeScans(scans Scans, key interface{}, desc bool) Scans {
+
+	if s, ok := key.(string); ok && collatejson.MissingLiteral.Equal(s) {
+		return scans
+	}
+	from := value.NewValue(key)
+
+	result := make(Scans, len(scans))
+	for i, scan := range scans {
+		result[i] = scan
+		if scan == nil || len(scan.Filter) == 0 || scan.Filter[0] == nil {
+			continue
+		}
+
+		f := *scan.Filter[0]
+		if !desc {
+			if f.Low != common.MinUnbounded && value.NewValue(f.Low).Collate(from) >= 0 {
+				continue
+			}
+			if f.High != common.MaxUnbounded && value.NewValue(f.High).Collate(from) < 0 {
+				continue
+			}
+			f.Low = key
+			if f.Inclusion == Neither {
+				f.Inclusion = Low
+			} else if f.Inclusion == High {
+				f.Inclusion = Both
+			}
+		} else {
+			if f.High != common.MaxUnbounded && value.NewValue(f.High).Collate(from) <= 0 {
+				continue
+			}
+			if f.Low != common.MinUnbounded && value.NewValue(f.Low).Collate(from) > 0 {
+				continue
+			}
+			f.High = key
+			if f.Inclusion == Neither {
+				f.Inclusion = High
+			} else if f.Inclusion == Low {
+				f.Inclusion = Both
+			}
+		}
+
+		filters := append([]*CompositeElementFilter{&f}, scan.Filter[1:]...)
+		result[i] = &Scan{Seek: scan.Seek, Filter: filters}
+	}
+	return result
+}
+
+//
+// Compare a row with the last row sent, in index order.
+//
+func (c *RequestBroker) compareLastRow(pkey []byte, skey common.SecondaryKey) int {
+
+	key := skeyToValues(skey)
+
+	ln := len(key)
+	if len(c.resumeKey) < ln {
+		ln = len(c.resumeKey)
+	}
+
+	for i := 0; i < ln; i++ {
+		if r := key[i].Collate(c.resumeKey[i]); r != 0 {
+			if i < len(c.defn.Desc) && c.defn.Desc[i] {
+				return 0 - r
+			}
+			return r
+		}
+	}
+
+	if r := len(key) - len(c.resumeKey); r != 0 {
+		return r
+	}
+
+	return c.comparePrimaryKey(pkey, c.lastPkey)
+}
+
 //--------------------------
 // default request broker
 //--------------------------
@@ -1227,6 +1770,19 @@ func makeDefaultResponseHandler(id ResponseHandlerId, broker *RequestBroker, ins
 			broker.Error(err, instId, partitions)
 			return false
 		}
+		if r, ok := resp.(partialResponse); ok {
+			broker.addMissingVbuckets(r.GetMissingVbuckets())
+		}
+		if r, ok := resp.(snapshotResponse); ok {
+			if ts := r.GetSnapshotTs(); ts != nil {
+				broker.addSnapshotTs(ts.GetVbnos(), ts.GetSeqnos(), ts.GetVbuuids())
+			}
+		}
+		if r, ok := resp.(estimateResponse); ok {
+			if rows, ok := r.GetEstimate(); ok {
+				broker.addEstimate(id, rows)
+			}
+		}
 		skeys, pkeys, err := resp.GetEntries()
 		if err != nil {
 			logging.Errorf("defaultResponseHandler: %v", err)
@@ -1269,10 +1825,16 @@ func (c *RequestBroker) filterPartitions(index *common.IndexDefn, partitions [][
 		return partitions
 	}
 
-	filter := partitionKeyHash(partitionKeyValues, c.scans, numPartition, index.HashScheme)
-	if len(filter) == 0 {
+	scanPartns := scanPartitionIds(partitionKeyValues, c.scans, numPartition, index.HashScheme)
+	if len(scanPartns) == 0 {
 		return partitions
 	}
+	c.scanPartns = scanPartns
+
+	filter := make(map[common.PartitionId]bool)
+	for _, partnId := range scanPartns {
+		filter[partnId] = true
+	}
 
 	return filterPartitionIds(partitions, filter)
 }
@@ -1412,9 +1974,10 @@ func partitionKeyValues(requestId string, partnKeyPos []int, scans Scans) [][]in
 }
 
 //
-// Generate a list of partitonId from the partition key values of each scan
+// Generate the partitionId of each scan from its partition key values.
+// Returns nil if any scan does not have all the partition keys.
 //
-func partitionKeyHash(partnKeyValues [][]interface{}, scans Scans, numPartition uint32, hashScheme common.HashScheme) map[common.PartitionId]bool {
+func scanPartitionIds(partnKeyValues [][]interface{}, scans Scans, numPartition uint32, hashScheme common.HashScheme) []common.PartitionId {
 
 	if len(partnKeyValues) != len(scans) {
 		return nil
@@ -1426,16 +1989,15 @@ func partitionKeyHash(partnKeyValues [][]interface{}, scans Scans, numPartition
 		}
 	}
 
-	result := make(map[common.PartitionId]bool)
-	for _, values := range partnKeyValues {
+	result := make([]common.PartitionId, len(partnKeyValues))
+	for i, values := range partnKeyValues {
 
 		v, e := qvalue.NewValue(values).MarshalJSON()
 		if e != nil {
 			return nil
 		}
 
-		partnId := common.HashKeyPartition(v, int(numPartition), hashScheme)
-		result[partnId] = true
+		result[i] = common.HashKeyPartition(v, int(numPartition), hashScheme)
 	}
 
 	return result
@@ -1672,6 +2234,49 @@ func (c *RequestBroker) isPartialAggregate(partitions [][]common.PartitionId, nu
 	return false
 }
 
+//
+// If cbq-engine expects full aggregate results, but groups can span
+// partitions, push down partial aggregation to the indexers and combine
+// the partial aggregates in the broker.  Limit and offset then apply
+// only on the combined rows.
+//
+func (c *RequestBroker) analyzeAggregate(partitions [][]common.PartitionId, numPartition uint32, index *common.IndexDefn) {
+
+	if c.grpAggr == nil || c.grpAggr.AllowPartialAggr || c.scan == nil {
+		return
+	}
+
+	// non-partition index
+	if index.PartitionScheme == common.SINGLE {
+		return
+	}
+
+	// there is only a single indexer involved in the scan
+	if numPartition == 1 || len(partitions) == 1 {
+		return
+	}
+
+	if len(c.grpAggr.Group) != 0 && !c.isPartialAggregate(partitions, numPartition, index) {
+		return
+	}
+
+	combiner := newAggrCombiner(c.grpAggr, c.projections)
+	if combiner == nil {
+		return
+	}
+
+	grpAggr := *c.grpAggr
+	grpAggr.AllowPartialAggr = true
+
+	combiner.limit, combiner.offset = c.limit, c.offset
+	c.combiner = combiner
+	c.pushdownGrpAggr = &grpAggr
+	c.limit, c.offset = math.MaxInt64, 0
+	c.pushdownLimit, c.pushdownOffset = math.MaxInt64, 0
+
+	logging.Debugf("scatter: requestId %v combining partial aggregates of %v partitions", c.requestId, numPartition)
+}
+
 //
 // We cannot sort if it is pre-aggregate result, so set sorted to false.  Otherwise, the result
 // is sorted if there is an order-by clause.
@@ -0,0 +1,325 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	qvalue "github.com/couchbase/query/value"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestScanPartitionIds(t *testing.T) {
+	scans := Scans{&Scan{}, &Scan{}, &Scan{}}
+	values := [][]interface{}{{"a"}, {"b"}, {"a"}}
+
+	partnIds := scanPartitionIds(values, scans, 8, common.CRC32)
+	if len(partnIds) != len(scans) {
+		t.Fatalf("expected %v partitions, got %v", len(scans), partnIds)
+	}
+	for i, vals := range values {
+		v, _ := qvalue.NewValue(vals).MarshalJSON()
+		if expected := common.HashKeyPartition(v, 8, common.CRC32); partnIds[i] != expected {
+			t.Errorf("scan %v: expected partition %v, got %v", i, expected, partnIds[i])
+		}
+	}
+	if partnIds[0] != partnIds[2] {
+		t.Errorf("scans on the same key must map to the same partition")
+	}
+
+	// a scan without the partition key can go to any partition.
+	values[1] = nil
+	if partnIds := scanPartitionIds(values, scans, 8, common.CRC32); partnIds != nil {
+		t.Errorf("expected no partitions, got %v", partnIds)
+	}
+}
+
+func TestScansForPartitions(t *testing.T) {
+	s1, s2, s3 := &Scan{}, &Scan{}, &Scan{}
+	b := &RequestBroker{scans: Scans{s1, s2, s3}}
+
+	// without partition elimination every indexer gets all the scans.
+	if scans := b.ScansForPartitions([]common.PartitionId{1}); !reflect.DeepEqual(scans, b.scans) {
+		t.Errorf("expected all scans, got %v", scans)
+	}
+
+	b.scanPartns = []common.PartitionId{1, 2, 1}
+	testcases := []struct {
+		partitions []common.PartitionId
+		scans      Scans
+	}{
+		{[]common.PartitionId{1}, Scans{s1, s3}},
+		{[]common.PartitionId{2}, Scans{s2}},
+		{[]common.PartitionId{1, 2}, Scans{s1, s2, s3}},
+		{[]common.PartitionId{3}, Scans{}},
+	}
+	for _, tc := range testcases {
+		scans := b.ScansForPartitions(tc.partitions)
+		if !reflect.DeepEqual(scans, tc.scans) {
+			t.Errorf("partitions %v: expected %v scans, got %v", tc.partitions, len(tc.scans), len(scans))
+		}
+	}
+
+	b.reset()
+	if b.scanPartns != nil {
+		t.Errorf("expected scan partitions to be cleared on reset")
+	}
+}
+
+func TestResumeSkipsSentRows(t *testing.T) {
+	sent := 0
+	b := NewRequestBroker("req", 0, 1)
+	b.SetResponseSender(func(pkey []byte, mskey []qvalue.Value, uskey common.SecondaryKey) bool {
+		sent++
+		return true
+	})
+	b.SetResumable(true)
+	b.SetLimit(10)
+	b.SetOffset(2)
+	b.SetNumIndexers(1)
+	b.defn = &common.IndexDefn{}
+
+	for _, k := range []string{"a", "b", "c"} {
+		b.send([]byte("doc-"+k), nil, common.SecondaryKey{k})
+	}
+	b.Resume()
+
+	if b.offset != 0 || b.limit != 7 {
+		t.Errorf("expected offset 0 limit 7, got offset %v limit %v", b.offset, b.limit)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if !b.skipResumed([]byte("doc-"+k), common.SecondaryKey{k}) {
+			t.Errorf("expected row %v to be skipped", k)
+		}
+	}
+	if b.skipResumed([]byte("doc-d"), common.SecondaryKey{"d"}) {
+		t.Errorf("expected row d to be sent")
+	}
+	// once past the last row, no row is compared again.
+	if b.skipResumed([]byte("doc-a"), common.SecondaryKey{"a"}) {
+		t.Errorf("expected no skip after resuming")
+	}
+
+	// the remaining limit is applied by the broker.
+	for i := 0; i < 10; i++ {
+		b.send([]byte("doc-e"), nil, common.SecondaryKey{"e"})
+	}
+	if sent != 3+7 {
+		t.Errorf("expected %v rows sent, got %v", 3+7, sent)
+	}
+}
+
+func TestResumeScans(t *testing.T) {
+	scans := Scans{
+		&Scan{Filter: []*CompositeElementFilter{
+			{Low: "a", High: "c", Inclusion: Neither},
+			{Low: 1, High: 2, Inclusion: Both},
+		}},
+		&Scan{Filter: []*CompositeElementFilter{
+			{Low: "d", High: common.MaxUnbounded, Inclusion: Both},
+		}},
+		&Scan{Seek: common.SecondaryKey{"b"}},
+	}
+
+	resumed := resumeScans(scans, "b", false)
+	f := resumed[0].Filter
+	if f[0].Low != "b" || f[0].High != "c" || f[0].Inclusion != Low {
+		t.Errorf("unexpected filter %v", f[0])
+	}
+	if f[1] != scans[0].Filter[1] {
+		t.Errorf("expected trailing filters to be kept")
+	}
+	if scans[0].Filter[0].Low != "a" {
+		t.Errorf("expected original scans to be unchanged")
+	}
+	if resumed[1] != scans[1] || resumed[2] != scans[2] {
+		t.Errorf("expected scans not covering the key to be unchanged")
+	}
+
+	// descending leading key narrows the high end.
+	resumed = resumeScans(Scans{&Scan{Filter: []*CompositeElementFilter{
+		{Low: common.MinUnbounded, High: "c", Inclusion: Low},
+	}}}, "b", true)
+	if f := resumed[0].Filter[0]; f.Low != common.MinUnbounded || f.High != "b" || f.Inclusion != Both {
+		t.Errorf("unexpected filter %v", f)
+	}
+}
+
+func TestCanResumeGather(t *testing.T) {
+	b := NewRequestBroker("req", 0, 1)
+	b.SetResumable(true)
+	b.SetScanRequestHandler(func(*GsiScanClient, *common.IndexDefn, int64, []common.PartitionId, ResponseHandler) (error, bool) {
+		return nil, false
+	})
+	b.defn = &common.IndexDefn{}
+	b.lastPkey = []byte("doc")
+	b.SetNumIndexers(2)
+
+	if b.CanResume() {
+		t.Errorf("expected no resume for unordered rows from multiple indexers")
+	}
+	b.bGather, b.sorted = true, true
+	if !b.CanResume() {
+		t.Errorf("expected resume for rows merged in index order")
+	}
+}
+
+func TestPinConsistency(t *testing.T) {
+	b := NewRequestBroker("req", 0, 1)
+
+	calls := 0
+	get := func() (*TsConsistency, error) {
+		calls++
+		return NewTsConsistency([]uint16{1}, []uint64{uint64(calls)}, nil), nil
+	}
+	v1, _ := b.PinConsistency(get)
+	b.reset()
+	v2, _ := b.PinConsistency(get)
+	if calls != 1 || v1 != v2 {
+		t.Errorf("expected the vector to be pinned, got %v calls", calls)
+	}
+}
+
+func TestBrokerEstimatedRows(t *testing.T) {
+	broker := makeDefaultRequestBroker(nil)
+	broker.SetNumIndexers(2)
+	if rows := broker.EstimatedRows(); rows != -1 {
+		t.Errorf("expected no estimate, got %v", rows)
+	}
+
+	var resp estimateResponse = &protobuf.ResponseStream{EstimatedRows: proto.Uint64(30)}
+	rows, ok := resp.GetEstimate()
+	if !ok {
+		t.Fatal("expected estimate")
+	}
+	broker.addEstimate(0, rows)
+	// not estimated by all indexers.
+	if rows := broker.EstimatedRows(); rows != -1 {
+		t.Errorf("expected no estimate, got %v", rows)
+	}
+	if _, ok := (&protobuf.ResponseStream{}).GetEstimate(); ok {
+		t.Errorf("expected no estimate in response")
+	}
+
+	broker.addEstimate(1, 50)
+	if rows := broker.EstimatedRows(); rows != 80 {
+		t.Errorf("expected 80 rows, got %v", rows)
+	}
+	broker.SetLimit(60)
+	if rows := broker.EstimatedRows(); rows != 60 {
+		t.Errorf("expected 60 rows, got %v", rows)
+	}
+
+	broker.reset()
+	if rows := broker.EstimatedRows(); rows != -1 {
+		t.Errorf("expected no estimate after reset, got %v", rows)
+	}
+}
+
+func TestStalenessBound(t *testing.T) {
+	var nilBound *StalenessBound
+	if nilBound.toProto() != nil {
+		t.Errorf("expected no staleness bound")
+	}
+
+	bound := &StalenessBound{Seqnos: 100, Time: 2 * time.Second, Wait: true}
+	pb := bound.toProto()
+	if pb.GetMaxSeqnos() != 100 || pb.GetMaxTime() != 2000 || !pb.GetWait() {
+		t.Errorf("unexpected staleness bound %v", pb)
+	}
+
+	broker := makeDefaultRequestBroker(nil)
+	broker.SetStaleness(bound)
+	if broker.GetStaleness() != bound {
+		t.Errorf("expected staleness bound %v, got %v", bound, broker.GetStaleness())
+	}
+
+	c := &GsiClient{}
+	if vector, err := c.getConsistency(nil, common.StalenessConsistency, nil, "default"); err != nil || vector != nil {
+		t.Errorf("unexpected consistency vector %v %v", vector, err)
+	}
+	err := c.Scan3WithStaleness(1, "", nil, false, false, nil, 0, 0, nil, nil, nil, nil)
+	if err != ErrorInvalidConsistency {
+		t.Errorf("expected %v, got %v", ErrorInvalidConsistency, err)
+	}
+	err = c.Scan3WithStaleness(1, "", nil, false, false, nil, 0, 0, nil, nil, bound, nil)
+	if err != ErrorClientUninitialized {
+		t.Errorf("expected %v, got %v", ErrorClientUninitialized, err)
+	}
+}
+
+func TestBrokerSnapshotTs(t *testing.T) {
+	broker := makeDefaultRequestBroker(nil)
+	broker.SetReturnSnapshotTs(true)
+	if !broker.GetReturnSnapshotTs() {
+		t.Fatalf("expected snapshot timestamp to be returned")
+	}
+	if ts := broker.SnapshotTs(); ts != nil {
+		t.Errorf("expected no snapshot timestamp, got %v", ts)
+	}
+
+	// the highest seqno of each vbucket across indexers is kept.
+	handler1 := makeDefaultResponseHandler(0, broker, 1, nil)
+	handler2 := makeDefaultResponseHandler(1, broker, 2, nil)
+	handler1(&protobuf.ResponseStream{
+		SnapshotTs: protobuf.NewTsConsistency([]uint16{3, 1}, []uint64{30, 10}, []uint64{300, 100}, 0),
+	})
+	handler2(&protobuf.ResponseStream{
+		SnapshotTs: protobuf.NewTsConsistency([]uint16{1, 2}, []uint64{15, 20}, []uint64{101, 200}, 0),
+	})
+	handler2(&protobuf.ResponseStream{})
+
+	expected := NewTsConsistency([]uint16{1, 2, 3}, []uint64{15, 20, 30}, []uint64{101, 200, 300})
+	if ts := broker.SnapshotTs(); !reflect.DeepEqual(ts, expected) {
+		t.Errorf("expected %v, got %v", expected, ts)
+	}
+
+	// a retried scan returns the timestamp of its own snapshots.
+	broker.reset()
+	if ts := broker.SnapshotTs(); ts != nil {
+		t.Errorf("expected no snapshot timestamp after reset, got %v", ts)
+	}
+
+	c := &GsiClient{}
+	token, err := c.Scan3WithToken(1, "", nil, false, false, nil, 0, 0, nil, nil, common.AnyConsistency, nil, nil)
+	if err != ErrorClientUninitialized || token != nil {
+		t.Errorf("expected %v, got %v %v", ErrorClientUninitialized, token, err)
+	}
+}
+
+func TestBrokerMissingVbuckets(t *testing.T) {
+	broker := makeDefaultRequestBroker(nil)
+	broker.SetAllowPartial(true)
+	if !broker.GetAllowPartial() {
+		t.Fatalf("expected partial results to be allowed")
+	}
+	if vbs := broker.MissingVbuckets(); len(vbs) != 0 {
+		t.Errorf("expected no missing vbuckets, got %v", vbs)
+	}
+
+	// missing vbuckets of all indexers are returned, in ascending order.
+	handler1 := makeDefaultResponseHandler(0, broker, 1, nil)
+	handler2 := makeDefaultResponseHandler(1, broker, 2, nil)
+	handler1(&protobuf.ResponseStream{MissingVbuckets: []uint32{7, 3}})
+	handler2(&protobuf.ResponseStream{MissingVbuckets: []uint32{3, 1}})
+	handler2(&protobuf.ResponseStream{})
+
+	if vbs := broker.MissingVbuckets(); !reflect.DeepEqual(vbs, []uint16{1, 3, 7}) {
+		t.Errorf("expected [1 3 7], got %v", vbs)
+	}
+
+	// a retried scan returns the missing vbuckets of its own indexers.
+	broker.reset()
+	if vbs := broker.MissingVbuckets(); len(vbs) != 0 {
+		t.Errorf("expected no missing vbuckets after reset, got %v", vbs)
+	}
+
+	c := &GsiClient{}
+	vbs, err := c.PartialScan3(1, "", nil, false, false, nil, 0, 0, nil, nil,
+		common.SessionConsistency, nil, nil)
+	if err != ErrorClientUninitialized || vbs != nil {
+		t.Errorf("expected %v, got %v %v", ErrorClientUninitialized, vbs, err)
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import "crypto/tls"
+import "crypto/x509"
+import "fmt"
+import "io/ioutil"
+import "net"
+
+import "github.com/couchbase/indexing/secondary/common"
+
+// makeTLSConfig returns the client side TLS configuration to connect
+// with `queryport`, or nil if TLS is disabled.
+func makeTLSConfig(queryport string, config common.Config) (*tls.Config, error) {
+	if cv, ok := config["tls.enabled"]; !ok || !cv.Bool() {
+		return nil, nil
+	}
+
+	host, _, err := net.SplitHostPort(queryport)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile := config["tls.caFile"].String(); caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca certificate: %v", err)
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	// client certificate, for certificate based authentication.
+	if certFile := config["tls.certFile"].String(); certFile != "" {
+		keyFile := config["tls.keyFile"].String()
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
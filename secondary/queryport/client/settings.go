@@ -28,6 +28,8 @@ type ClientSettings struct {
 	prune_replica  int32
 	queueSize      uint64
 	concurrency    uint32
+	sampleSize     int32
+	readPref       uint32
 	config         common.Config
 	cancelCh       chan struct{}
 
@@ -158,6 +160,15 @@ func (s *ClientSettings) handleSettings(config common.Config) {
 		logging.Errorf("ClientSettings: invalid setting value for max_concurrency=%v", concurrency)
 	}
 
+	sampleSize := int32(config["queryport.client.settings.previewSampleSize"].Int())
+	if sampleSize >= 0 {
+		atomic.StoreInt32(&s.sampleSize, sampleSize)
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for previewSampleSize=%v", sampleSize)
+	}
+
+	s.handleReadPreference(config)
+
 	storageMode := config["indexer.settings.storage_mode"].String()
 	if len(storageMode) != 0 {
 		func() {
@@ -190,6 +201,10 @@ func (s *ClientSettings) StorageMode() string {
 	return s.storageMode
 }
 
+func (s *ClientSettings) PreviewSampleSize() int32 {
+	return atomic.LoadInt32(&s.sampleSize)
+}
+
 func (s *ClientSettings) BackfillLimit() int32 {
 	return atomic.LoadInt32(&s.backfillLimit)
 }
@@ -217,3 +232,17 @@ func (s *ClientSettings) ScanQueueSize() uint64 {
 func (s *ClientSettings) MaxConcurrency() uint32 {
 	return atomic.LoadUint32(&s.concurrency)
 }
+
+func (s *ClientSettings) ReadPreference() common.ReadPreference {
+	return common.ReadPreference(atomic.LoadUint32(&s.readPref))
+}
+
+func (s *ClientSettings) handleReadPreference(config common.Config) {
+	if cv, ok := config["queryport.client.settings.readPreference"]; ok {
+		if readPref, err := common.ParseReadPreference(cv.String()); err == nil {
+			atomic.StoreUint32(&s.readPref, uint32(readPref))
+		} else {
+			logging.Errorf("ClientSettings: %v", err)
+		}
+	}
+}
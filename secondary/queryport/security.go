@@ -0,0 +1,83 @@
+package queryport
+
+import "crypto/tls"
+import "crypto/x509"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "net"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// AuthHandler shall validate the credentials presented by a client
+// on a new connection. Returning a non-nil error rejects the connection.
+type AuthHandler func(user string, password []byte) error
+
+// ErrorUnauthenticated
+var ErrorUnauthenticated = errors.New("queryport.unauthenticated")
+
+// makeTLSConfig returns the server side TLS configuration for queryport,
+// or nil if TLS is disabled.
+//
+// tls.clientAuth can be one of,
+//
+//	"none"      - client certificates are not requested.
+//	"optional"  - client certificate, if presented, is verified.
+//	"mandatory" - client must present a verifiable certificate.
+func makeTLSConfig(config c.Config) (*tls.Config, error) {
+	if cv, ok := config["tls.enabled"]; !ok || !cv.Bool() {
+		return nil, nil
+	}
+
+	certFile := config["tls.certFile"].String()
+	keyFile := config["tls.keyFile"].String()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+	}
+
+	switch clientAuth := config["tls.clientAuth"].String(); clientAuth {
+	case "", "none":
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "mandatory":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls.clientAuth %q", clientAuth)
+	}
+
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		caFile := config["tls.caFile"].String()
+		if caFile == "" {
+			caFile = certFile
+		}
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca certificate: %v", err)
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.ClientCAs = caCertPool
+	}
+	return tlsConfig, nil
+}
+
+// isCertAuthenticated returns true if the client presented a
+// certificate that was verified during the TLS handshake.
+func isCertAuthenticated(conn net.Conn) bool {
+	tlsconn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	if err := tlsconn.Handshake(); err != nil {
+		return false
+	}
+	return len(tlsconn.ConnectionState().VerifiedChains) > 0
+}
@@ -0,0 +1,267 @@
+package queryport
+
+import "crypto/rand"
+import "crypto/rsa"
+import "crypto/tls"
+import "crypto/x509"
+import "crypto/x509/pkix"
+import "encoding/pem"
+import "errors"
+import "io/ioutil"
+import "math/big"
+import "net"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbase/indexing/secondary/transport"
+import "github.com/golang/protobuf/proto"
+
+func TestMakeTLSConfigDisabled(t *testing.T) {
+	config := testQueryportConfig()
+	tlsConfig, err := makeTLSConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	} else if tlsConfig != nil {
+		t.Fatalf("expected nil tls config, got %v", tlsConfig)
+	}
+}
+
+func TestMakeTLSConfigClientAuth(t *testing.T) {
+	dir, certFile, keyFile := writeTestCert(t)
+	defer os.RemoveAll(dir)
+
+	testcases := map[string]tls.ClientAuthType{
+		"none":      tls.NoClientCert,
+		"optional":  tls.VerifyClientCertIfGiven,
+		"mandatory": tls.RequireAndVerifyClientCert,
+	}
+	for clientAuth, expected := range testcases {
+		config := testTLSConfig(certFile, keyFile, clientAuth)
+		tlsConfig, err := makeTLSConfig(config)
+		if err != nil {
+			t.Fatalf("%v: %v", clientAuth, err)
+		}
+		if tlsConfig.ClientAuth != expected {
+			t.Errorf("%v: expected %v, got %v", clientAuth, expected, tlsConfig.ClientAuth)
+		}
+		if expected != tls.NoClientCert && tlsConfig.ClientCAs == nil {
+			t.Errorf("%v: expected client CAs", clientAuth)
+		}
+	}
+
+	config := testTLSConfig(certFile, keyFile, "always")
+	if _, err := makeTLSConfig(config); err == nil {
+		t.Errorf("expected error for invalid tls.clientAuth")
+	}
+}
+
+func TestServerRejectsUnauthenticated(t *testing.T) {
+	s, addr := startAuthServer(t, testQueryportConfig())
+	defer s.Close()
+
+	conn, pkt := dialTestServer(t, addr, nil)
+	defer conn.Close()
+
+	// a request before AuthRequest is rejected and not dropped silently.
+	if err := pkt.Send(conn, &protobuf.StatisticsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiveAuthResponse(pkt, conn); err == nil {
+		t.Fatalf("expected unauthenticated error")
+	}
+	if _, err := pkt.Receive(conn); err == nil {
+		t.Fatalf("expected connection to be closed")
+	}
+}
+
+func TestServerPasswordAuth(t *testing.T) {
+	s, addr := startAuthServer(t, testQueryportConfig())
+	defer s.Close()
+
+	conn, pkt := dialTestServer(t, addr, nil)
+	defer conn.Close()
+
+	req := &protobuf.AuthRequest{
+		User: proto.String("admin"), Password: []byte("wrong"),
+	}
+	if err := pkt.Send(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiveAuthResponse(pkt, conn); err == nil {
+		t.Fatalf("expected authentication failure")
+	}
+
+	conn, pkt = dialTestServer(t, addr, nil)
+	defer conn.Close()
+
+	req.Password = []byte("password")
+	if err := pkt.Send(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiveAuthResponse(pkt, conn); err != nil {
+		t.Fatal(err)
+	}
+	assertServed(t, pkt, conn)
+}
+
+func TestServerCertAuth(t *testing.T) {
+	dir, certFile, keyFile := writeTestCert(t)
+	defer os.RemoveAll(dir)
+
+	config := testTLSConfig(certFile, keyFile, "mandatory")
+	s, addr := startAuthServer(t, config)
+	defer s.Close()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	caCert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.AppendCertsFromPEM(caCert)
+	tlsConfig := &tls.Config{
+		ServerName:   "localhost",
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	// verified client certificate needs no AuthRequest.
+	conn, pkt := dialTestServer(t, addr, tlsConfig)
+	defer conn.Close()
+	assertServed(t, pkt, conn)
+}
+
+func testQueryportConfig() c.Config {
+	return c.SystemConfig.SectionConfig("indexer.queryport.", true)
+}
+
+func testTLSConfig(certFile, keyFile, clientAuth string) c.Config {
+	config := testQueryportConfig()
+	config.SetValue("tls.enabled", true)
+	config.SetValue("tls.certFile", certFile)
+	config.SetValue("tls.keyFile", keyFile)
+	config.SetValue("tls.clientAuth", clientAuth)
+	return config
+}
+
+func startAuthServer(t *testing.T, config c.Config) (*Server, string) {
+	authb := func(user string, password []byte) error {
+		if user == "admin" && string(password) == "password" {
+			return nil
+		}
+		return errors.New("invalid credentials")
+	}
+	callb := func(req interface{}, ctx interface{}, conn net.Conn, quitch <-chan bool) {
+		buf := make([]byte, 1024)
+		protobuf.EncodeAndWrite(conn, buf, &protobuf.StatisticsResponse{})
+	}
+
+	s, err := NewServerWithAuth("localhost:0", callb, nil, authb, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, s.lis.Addr().String()
+}
+
+func dialTestServer(
+	t *testing.T, addr string, tlsConfig *tls.Config) (net.Conn, *transport.TransportPacket) {
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(1024*1024, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	return conn, pkt
+}
+
+// receiveAuthResponse reads an AuthResponse followed by StreamEnd.
+func receiveAuthResponse(pkt *transport.TransportPacket, conn net.Conn) error {
+	resp, err := pkt.Receive(conn)
+	if err != nil {
+		return err
+	}
+	authResp, ok := resp.(*protobuf.AuthResponse)
+	if !ok {
+		return errors.New("expected AuthResponse")
+	}
+	if endResp, err := pkt.Receive(conn); err != nil {
+		return err
+	} else if endResp != nil {
+		return errors.New("expected StreamEndResponse")
+	}
+	return authResp.Error()
+}
+
+func assertServed(t *testing.T, pkt *transport.TransportPacket, conn net.Conn) {
+	if err := pkt.Send(conn, &protobuf.StatisticsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := pkt.Receive(conn)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := resp.(*protobuf.StatisticsResponse); !ok {
+		t.Fatalf("expected StatisticsResponse, got %T", resp)
+	}
+	if resp, err := pkt.Receive(conn); err != nil || resp != nil {
+		t.Fatalf("expected StreamEndResponse, got %v %v", resp, err)
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost, to be
+// used both as server and client certificate.
+func writeTestCert(t *testing.T) (dir, certFile, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dir, err = ioutil.TempDir("", "queryport-tls"); err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir, certFile, keyFile
+}
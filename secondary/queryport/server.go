@@ -1,6 +1,7 @@
 package queryport
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -14,6 +15,7 @@ import (
 
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/transport"
+	"github.com/golang/protobuf/proto"
 )
 
 // RequestHandler shall interpret the request message
@@ -43,6 +45,7 @@ type Server struct {
 	laddr string         // address to listen
 	callb RequestHandler // callback to application on incoming request.
 	conb  ConnectionHandler
+	authb AuthHandler // if not nil, connections must authenticate.
 	// local fields
 	mu  sync.Mutex
 	lis net.Listener
@@ -51,7 +54,9 @@ type Server struct {
 	readDeadline      time.Duration
 	writeDeadline     time.Duration
 	keepAliveInterval time.Duration
+	sockOpts          c.SocketOptions
 	streamChanSize    int
+	tlsConfig         *tls.Config
 	logPrefix         string
 	nConnections      int64
 }
@@ -65,10 +70,21 @@ func NewServer(
 	laddr string, callb RequestHandler, conb ConnectionHandler,
 	config c.Config) (s *Server, err error) {
 
+	return NewServerWithAuth(laddr, callb, conb, nil, config)
+}
+
+// NewServerWithAuth creates a new queryport daemon that requires every
+// connection to authenticate, either with a verified TLS client
+// certificate or with an AuthRequest validated by `authb`.
+func NewServerWithAuth(
+	laddr string, callb RequestHandler, conb ConnectionHandler,
+	authb AuthHandler, config c.Config) (s *Server, err error) {
+
 	s = &Server{
 		laddr:          laddr,
 		callb:          callb,
 		conb:           conb,
+		authb:          authb,
 		maxPayload:     config["maxPayload"].Int(),
 		readDeadline:   time.Duration(config["readDeadline"].Int()),
 		writeDeadline:  time.Duration(config["writeDeadline"].Int()),
@@ -78,13 +94,19 @@ func NewServer(
 	}
 	keepAliveInterval := config["keepAliveInterval"].Int()
 	s.keepAliveInterval = time.Duration(keepAliveInterval) * time.Second
+	s.sockOpts = c.NewSocketOptions(config)
+	if s.tlsConfig, err = makeTLSConfig(config); err != nil {
+		logging.Errorf("%v failed configuring TLS %v !!\n", s.logPrefix, err)
+		return nil, err
+	}
 	if s.lis, err = net.Listen("tcp", laddr); err != nil {
 		logging.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
 		return nil, err
 	}
 
 	go s.listener()
-	logging.Infof("%v started ...\n", s.logPrefix)
+	logging.Infof("%v started tls:%v auth:%v ...\n",
+		s.logPrefix, s.tlsConfig != nil, s.authb != nil)
 	return s, nil
 }
 
@@ -160,6 +182,14 @@ func (s *Server) handleConnection(conn net.Conn) {
 		tcpconn.SetKeepAlive(true)
 		tcpconn.SetKeepAlivePeriod(s.keepAliveInterval)
 	}
+	if err := s.sockOpts.Apply(conn); err != nil {
+		logging.Errorf("%v connection %v socket options: %v\n", s.logPrefix, raddr, err)
+		return
+	}
+
+	if s.tlsConfig != nil {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
 
 	// start a receive routine.
 	killch := make(chan bool)
@@ -173,12 +203,73 @@ func (s *Server) handleConnection(conn net.Conn) {
 		ctx = s.conb()
 	}
 
+	authenticated := s.authb == nil || isCertAuthenticated(conn)
+	// responses are written to wconn, coalescing writes if configured.
+	wconn := s.sockOpts.Coalesce(conn)
 	for req := range rcvch {
-		s.callb(req.r, ctx, conn, req.quitch) // blocking call
-		if req.r != Ping {
-			transport.SendResponseEnd(conn)
+		if authReq, ok := req.r.(*protobuf.AuthRequest); ok {
+			authenticated = s.authenticate(wconn, authReq)
+			transport.SendResponseEnd(wconn)
+			c.FlushConn(wconn)
+		} else if !authenticated && req.r != Ping {
+			logging.Errorf("%v connection %v request %T before authentication\n",
+				s.logPrefix, raddr, req.r)
+			// reject the request instead of leaving the client waiting.
+			s.respondAuth(wconn, ErrorUnauthenticated)
+			transport.SendResponseEnd(wconn)
+			c.FlushConn(wconn)
+		} else {
+			s.callb(req.r, ctx, wconn, req.quitch) // blocking call
+			if req.r != Ping {
+				transport.SendResponseEnd(wconn)
+			}
+			c.FlushConn(wconn)
+			continue
 		}
+
+		if !authenticated {
+			// let doReceive() and doPing() exit once connection is closed.
+			go func() {
+				for range rcvch {
+				}
+			}()
+			return
+		}
+	}
+}
+
+// authenticate the connection using credentials in `req`, and respond
+// back to the client.
+func (s *Server) authenticate(conn net.Conn, req *protobuf.AuthRequest) bool {
+	var err error
+	if s.authb != nil {
+		err = s.authb(req.GetUser(), req.GetPassword())
+	}
+
+	if err != nil {
+		logging.Errorf("%v connection %v authentication failed for %v: %v\n",
+			s.logPrefix, conn.RemoteAddr(), logging.TagUD(req.GetUser()), err)
+		s.respondAuth(conn, ErrorUnauthenticated)
+		return false
+	}
+	return s.respondAuth(conn, nil)
+}
+
+// respondAuth sends back an AuthResponse carrying `err`, if any.
+// Returns false if the response could not be sent.
+func (s *Server) respondAuth(conn net.Conn, err error) bool {
+	res := &protobuf.AuthResponse{}
+	if err != nil {
+		res.Err = &protobuf.Error{Error: proto.String(err.Error())}
+	}
+
+	buf := make([]byte, 1024)
+	if err1 := protobuf.EncodeAndWrite(conn, buf, res); err1 != nil {
+		logging.Errorf("%v connection %v auth response failed: %v\n",
+			s.logPrefix, conn.RemoteAddr(), err1)
+		return false
 	}
+	return true
 }
 
 // receive requests from remote, when this function returns
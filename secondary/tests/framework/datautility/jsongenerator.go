@@ -0,0 +1,246 @@
+package datautility
+
+import (
+	"fmt"
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"github.com/couchbase/query/value"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Generated datasets, for testing array indexes and collation beyond the
+// flat documents of the json data files. Generation is deterministic for
+// a seed. Numbers are float64, as they are when read back from KV.
+
+var genCities = []string{"Amsterdam", "Bangalore", "Cairo", "Dublin", "Lima", "Oslo", "Paris", "Tokyo"}
+var genTags = []string{"alpha", "beta", "delta", "gamma", "kappa", "omega", "sigma", "zeta"}
+
+// GenerateArrayDocs generates n documents with arrays of scalars (tags),
+// arrays of mixed types (values), arrays of objects with nested arrays
+// (orders[].items[]) and nested objects (address.geo). Tags may repeat
+// within a document, so that DISTINCT and ALL array indexes differ.
+//
+//	{
+//	  "docid": "doc12", "name": "user12", "age": 31,
+//	  "tags": ["beta", "zeta", "beta"],
+//	  "values": [12, "v12", true, null, [1, 2], {"k": 12}],
+//	  "address": {"city": "Oslo", "geo": {"lat": 12.5, "lon": -3.25}},
+//	  "orders": [{"id": 120, "items": [{"sku": "sku3", "qty": 2}]}]
+//	}
+func GenerateArrayDocs(n int, seed int64) tc.KeyValues {
+	r := rand.New(rand.NewSource(seed))
+	docs := make(tc.KeyValues)
+
+	for i := 0; i < n; i++ {
+		docid := fmt.Sprintf("doc%v", i)
+
+		tags := make([]interface{}, r.Intn(5))
+		for j := range tags {
+			tags[j] = genTags[r.Intn(len(genTags))]
+		}
+
+		mixed := []interface{}{
+			float64(r.Intn(100)),
+			fmt.Sprintf("v%v", r.Intn(100)),
+			r.Intn(2) == 0,
+			nil,
+			[]interface{}{float64(r.Intn(10)), float64(r.Intn(10))},
+			map[string]interface{}{"k": float64(r.Intn(100))},
+		}
+		values := make([]interface{}, 0, len(mixed))
+		for _, j := range r.Perm(len(mixed))[:1+r.Intn(len(mixed))] {
+			values = append(values, mixed[j])
+		}
+
+		orders := make([]interface{}, r.Intn(4))
+		for j := range orders {
+			items := make([]interface{}, 1+r.Intn(3))
+			for k := range items {
+				items[k] = map[string]interface{}{
+					"sku": fmt.Sprintf("sku%v", r.Intn(20)),
+					"qty": float64(1 + r.Intn(5)),
+				}
+			}
+			orders[j] = map[string]interface{}{
+				"id":    float64(i*10 + j),
+				"items": items,
+			}
+		}
+
+		docs[docid] = map[string]interface{}{
+			"docid":  docid,
+			"name":   fmt.Sprintf("user%v", i),
+			"age":    float64(18 + r.Intn(60)),
+			"tags":   tags,
+			"values": values,
+			"address": map[string]interface{}{
+				"city": genCities[r.Intn(len(genCities))],
+				"geo": map[string]interface{}{
+					"lat": float64(r.Intn(18000)-9000) / 100,
+					"lon": float64(r.Intn(36000)-18000) / 100,
+				},
+			},
+			"orders": orders,
+		}
+	}
+	return docs
+}
+
+// GenerateNestedDocs generates n documents nested depth levels deep, as
+// level1.level2...levelN, each level having a value of a type varying
+// with the document (number, string, bool, null, array or object) and
+// a list of values. Some documents stop short of depth, so that deeper
+// fields are missing.
+//
+//	{"docid": "doc3", "level1": {"value": 3, "list": [3, "3"], "level2": {...}}}
+func GenerateNestedDocs(n int, depth int, seed int64) tc.KeyValues {
+	r := rand.New(rand.NewSource(seed))
+	docs := make(tc.KeyValues)
+
+	for i := 0; i < n; i++ {
+		docid := fmt.Sprintf("doc%v", i)
+		doc := map[string]interface{}{"docid": docid}
+
+		parent := doc
+		levels := depth
+		if r.Intn(4) == 0 {
+			levels = r.Intn(depth + 1)
+		}
+		for l := 1; l <= levels; l++ {
+			level := map[string]interface{}{
+				"value": genMixedValue(r, i),
+				"list":  []interface{}{genMixedValue(r, i), genMixedValue(r, i+l)},
+			}
+			parent[fmt.Sprintf("level%v", l)] = level
+			parent = level
+		}
+		docs[docid] = doc
+	}
+	return docs
+}
+
+func genMixedValue(r *rand.Rand, i int) interface{} {
+	switch r.Intn(6) {
+	case 0:
+		return float64(i % 100)
+	case 1:
+		return fmt.Sprintf("s%v", i%100)
+	case 2:
+		return i%2 == 0
+	case 3:
+		return nil
+	case 4:
+		return []interface{}{float64(i % 10), fmt.Sprintf("s%v", i%10)}
+	default:
+		return map[string]interface{}{"n": float64(i % 10)}
+	}
+}
+
+// NestedPath returns the path of field at level of GenerateNestedDocs,
+// e.g. level1.level2.value for level 2.
+func NestedPath(level int, field string) string {
+	fields := make([]string, 0, level+1)
+	for l := 1; l <= level; l++ {
+		fields = append(fields, fmt.Sprintf("level%v", l))
+	}
+	return strings.Join(append(fields, field), ".")
+}
+
+/*
+The expected responses below compare values in N1QL collation order, so
+low and high can be of any type, including mixed types. A nil low or high
+is unbounded. Inclusion is as for ExpectedScanResponse_int64.
+
+Array paths have a [] suffix on every field that is an array to expand,
+e.g. tags[] for ARRAY t FOR t IN tags END, and orders[].items[].sku for
+ARRAY (ARRAY i.sku FOR i IN o.items END) FOR o IN orders END.
+*/
+
+// ExpectedScanResponse_mixed returns the expected response of a range
+// scan on jsonPath, for a field with values of mixed types.
+func ExpectedScanResponse_mixed(docs tc.KeyValues, jsonPath string, low, high interface{}, inclusion int64) tc.ScanResponse {
+	results := make(tc.ScanResponse)
+	for k, v := range docs {
+		for _, item := range pathValues(v, jsonPath) {
+			if inCollateRange(item, low, high, inclusion) {
+				results[k] = []interface{}{item}
+			}
+		}
+	}
+	return results
+}
+
+// ExpectedArrayScanResponse_path returns the expected response of a range
+// scan on the array index of arrayPath. Elements of a document are in
+// collation order, and with isDistinct, duplicate elements are indexed
+// once.
+func ExpectedArrayScanResponse_path(docs tc.KeyValues, arrayPath string, low, high interface{},
+	inclusion int64, isDistinct bool) tc.ArrayIndexScanResponse {
+
+	results := make(tc.ArrayIndexScanResponse)
+	for k, v := range docs {
+		items := pathValues(v, arrayPath)
+		sort.SliceStable(items, func(i, j int) bool {
+			return value.NewValue(items[i]).Collate(value.NewValue(items[j])) < 0
+		})
+
+		for i, item := range items {
+			if isDistinct && i > 0 && value.NewValue(item).Collate(value.NewValue(items[i-1])) == 0 {
+				continue
+			}
+			if inCollateRange(item, low, high, inclusion) {
+				results[k] = append(results[k], []interface{}{item})
+			}
+		}
+	}
+	return results
+}
+
+// pathValues returns the values of doc at path, expanding the arrays of
+// fields with a [] suffix. Missing fields have no values.
+func pathValues(doc interface{}, path string) []interface{} {
+	values := []interface{}{doc}
+	for _, f := range strings.Split(path, ".") {
+		expand := strings.HasSuffix(f, "[]")
+		f = strings.TrimSuffix(f, "[]")
+
+		next := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			fv, ok := m[f]
+			if !ok {
+				continue
+			}
+			if expand {
+				if arr, ok := fv.([]interface{}); ok {
+					next = append(next, arr...)
+				}
+				continue
+			}
+			next = append(next, fv)
+		}
+		values = next
+	}
+	return values
+}
+
+func inCollateRange(item, low, high interface{}, inclusion int64) bool {
+	val := value.NewValue(item)
+	if low != nil {
+		cmp := val.Collate(value.NewValue(low))
+		if cmp < 0 || (cmp == 0 && inclusion != 1 && inclusion != 3) {
+			return false
+		}
+	}
+	if high != nil {
+		cmp := val.Collate(value.NewValue(high))
+		if cmp > 0 || (cmp == 0 && inclusion != 2 && inclusion != 3) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,10 @@ package kvutility
 
 import (
 	"encoding/json"
+	"fmt"
 	c "github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport"
 	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
 	"io/ioutil"
 	"log"
@@ -200,3 +203,125 @@ func GetItemCountInBucket(bucketName, bucketPassword, serverUserName, serverPass
 	itemcount = basicstats["itemCount"].(float64)
 	return int(itemcount)
 }
+
+// DeleteKeyRange deletes the documents with keys prefix+i, for i in
+// [start, end). Keys that do not exist are ignored. Returns the number
+// of documents deleted.
+func DeleteKeyRange(prefix string, start, end int, bucketName string, password string, hostaddress string) int {
+	url := "http://" + bucketName + ":" + password + "@" + hostaddress
+
+	b, err := c.ConnectBucket(url, "default", bucketName)
+	tc.HandleError(err, "bucket")
+	defer b.Close()
+
+	deleted := 0
+	for i := start; i < end; i++ {
+		key := fmt.Sprintf("%v%v", prefix, i)
+		if err = b.Delete(key); err != nil {
+			if mc.IsNotFound(err) {
+				continue
+			}
+			tc.HandleError(err, "delete "+key)
+		}
+		deleted++
+	}
+	log.Printf("Deleted %v documents in range %v[%v, %v)", deleted, prefix, start, end)
+	return deleted
+}
+
+// SetKeyValuesWithExpiry sets the documents to expire after expiry
+// seconds.
+func SetKeyValuesWithExpiry(keyValues tc.KeyValues, expiry int, bucketName string, password string, hostaddress string) {
+	url := "http://" + bucketName + ":" + password + "@" + hostaddress
+
+	b, err := c.ConnectBucket(url, "default", bucketName)
+	tc.HandleError(err, "bucket")
+
+	for key, value := range keyValues {
+		err = b.Set(key, expiry, value)
+		tc.HandleError(err, "set")
+	}
+	b.Close()
+}
+
+// TouchKeys resets the expiry of the documents to expiry seconds,
+// rewriting their current value. Missing documents are skipped.
+func TouchKeys(keyValues tc.KeyValues, expiry int, bucketName string, password string, hostaddress string) {
+	url := "http://" + bucketName + ":" + password + "@" + hostaddress
+
+	b, err := c.ConnectBucket(url, "default", bucketName)
+	tc.HandleError(err, "bucket")
+
+	for key, _ := range keyValues {
+		err = b.Update(key, expiry, func(current []byte) ([]byte, error) {
+			if current == nil {
+				return nil, couchbase.UpdateCancel
+			}
+			return current, nil
+		})
+		if err == couchbase.UpdateCancel {
+			continue
+		}
+		tc.HandleError(err, "touch")
+	}
+	b.Close()
+}
+
+// GetBucketSeqnos returns the current seqno of every vbucket of the
+// bucket, indexed by vbucket, e.g. to build the vector of an at_plus
+// scan.
+func GetBucketSeqnos(bucketName string, hostaddress string) []uint64 {
+	seqnos, err := c.BucketSeqnos(hostaddress, "default", bucketName)
+	tc.HandleError(err, "bucket seqnos")
+	return seqnos
+}
+
+// WaitForSeqnos waits till the seqno of every vbucket of the bucket is
+// at least that of seqnos, and returns an error on timeout.
+func WaitForSeqnos(seqnos []uint64, timeout time.Duration, bucketName string, hostaddress string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := c.BucketSeqnos(hostaddress, "default", bucketName)
+		if err == nil && len(current) >= len(seqnos) {
+			behind := 0
+			for vb, seqno := range seqnos {
+				if current[vb] < seqno {
+					behind++
+				}
+			}
+			if behind == 0 {
+				return nil
+			}
+			err = fmt.Errorf("%v vbuckets behind", behind)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("WaitForSeqnos timed out for bucket %v: %v", bucketName, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// VerifyKeys returns the keys of keyValues that are not in the bucket,
+// or whose value differs.
+func VerifyKeys(keyValues tc.KeyValues, bucketName string, password string, hostaddress string) []string {
+	url := "http://" + bucketName + ":" + password + "@" + hostaddress
+
+	b, err := c.ConnectBucket(url, "default", bucketName)
+	tc.HandleError(err, "bucket")
+	defer b.Close()
+
+	var mismatched []string
+	for key, value := range keyValues {
+		var rv interface{}
+		if err := b.Get(key, &rv); err != nil {
+			mismatched = append(mismatched, key)
+			continue
+		}
+		expected, _ := json.Marshal(value)
+		actual, _ := json.Marshal(rv)
+		if string(expected) != string(actual) {
+			mismatched = append(mismatched, key)
+		}
+	}
+	return mismatched
+}
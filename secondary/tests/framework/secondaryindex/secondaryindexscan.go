@@ -143,6 +143,34 @@ func Range(indexName, bucketName, server string, low, high []interface{}, inclus
 	return scanResults, nil
 }
 
+// RangeStream scans the range and passes the response, chunk by chunk,
+// to callback without collecting it, e.g. to a validation.StreamValidator.
+func RangeStream(indexName, bucketName, server string, low, high []interface{}, inclusion uint32,
+	distinct bool, limit int64, consistency c.Consistency, vector *qc.TsConsistency,
+	callback qc.ResponseHandler) error {
+
+	client, e := CreateClient(server, "2itest")
+	if e != nil {
+		return e
+	}
+	defer client.Close()
+
+	defnID, _ := GetDefnID(client, bucketName, indexName)
+	start := time.Now()
+	connErr := client.Range(
+		defnID, "", c.SecondaryKey(low), c.SecondaryKey(high), qc.Inclusion(inclusion), distinct, limit,
+		consistency, vector, callback)
+	elapsed := time.Since(start)
+
+	if connErr != nil {
+		log.Printf("Connection error in Scan occured: %v", connErr)
+		return connErr
+	}
+
+	tc.LogPerfStat("RangeStream", elapsed)
+	return nil
+}
+
 func Lookup(indexName, bucketName, server string, values []interface{}, distinct bool, limit int64, consistency c.Consistency, vector *qc.TsConsistency) (tc.ScanResponse, error) {
 
 	distinct = false
@@ -0,0 +1,264 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/collatejson"
+	c "github.com/couchbase/indexing/secondary/common"
+	qc "github.com/couchbase/indexing/secondary/queryport/client"
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"github.com/couchbase/query/value"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// ExpectedLookup returns the expected secondary key of a document, and
+// false if the document is not expected in the scan response.
+type ExpectedLookup func(docid string) ([]interface{}, bool)
+
+// StreamValidator validates a scan response chunk by chunk, as it is
+// streamed by the client, without materializing the expected or the
+// actual response. So correctness can be checked on scans of millions
+// of rows.
+//
+// Every entry is checked to be within the low and high bounds of the
+// scan, and to be in index order, that is (secondary key, docid) must
+// strictly increase, which also detects duplicate entries. The running
+// count is checked against the limit after every chunk. If expected is
+// set, every entry must be expected with the same secondary key, and
+// the final count must match the expected count.
+type StreamValidator struct {
+	low       []interface{}
+	high      []interface{}
+	inclusion uint32
+	limit     int64
+	desc      []bool
+
+	expected      ExpectedLookup
+	expectedCount int64
+
+	count   int64
+	chunks  int64
+	prevKey []value.Value
+	prevPk  string
+	err     error
+}
+
+// NewStreamValidator returns a validator for a range scan with low,
+// high and inclusion, and limit (0 for no limit). Nil low or high is
+// unbounded.
+func NewStreamValidator(low, high []interface{}, inclusion uint32, limit int64) *StreamValidator {
+	return &StreamValidator{
+		low:           low,
+		high:          high,
+		inclusion:     inclusion,
+		limit:         limit,
+		expectedCount: -1,
+	}
+}
+
+// SetDesc sets the descending keys of the index.
+func (v *StreamValidator) SetDesc(desc []bool) *StreamValidator {
+	v.desc = desc
+	return v
+}
+
+// SetExpected sets the lookup of the expected entries and their count.
+func (v *StreamValidator) SetExpected(expected ExpectedLookup, count int64) *StreamValidator {
+	v.expected = expected
+	v.expectedCount = count
+	return v
+}
+
+// SetExpectedDocs sets the expected entries from docs, for an index on
+// jsonPaths. Expected count is the number of documents within the bounds
+// of the scan, up to limit.
+func (v *StreamValidator) SetExpectedDocs(docs tc.KeyValues, jsonPaths []string) *StreamValidator {
+	lookup := func(docid string) ([]interface{}, bool) {
+		doc, ok := docs[docid]
+		if !ok {
+			return nil, false
+		}
+		return docKey(doc, jsonPaths)
+	}
+
+	var count int64
+	for docid := range docs {
+		if key, ok := lookup(docid); ok && v.inBounds(skey2Values(key)) {
+			count++
+		}
+	}
+	if v.limit > 0 && count > v.limit {
+		count = v.limit
+	}
+	return v.SetExpected(lookup, count)
+}
+
+// ValidateChunk validates the next chunk of entries in the response.
+func (v *StreamValidator) ValidateChunk(skeys []c.SecondaryKey, pkeys [][]byte) error {
+	if v.err != nil {
+		return v.err
+	}
+	v.chunks++
+
+	for i, skey := range skeys {
+		pk := string(pkeys[i])
+		key := skey2Values(skey)
+
+		if !v.inBounds(key) {
+			return v.fail("Entry %v:%v in chunk %v is out of bounds low %v high %v inclusion %v",
+				skey, pk, v.chunks, v.low, v.high, v.inclusion)
+		}
+
+		if v.prevKey != nil {
+			cmp := v.compare(key, v.prevKey)
+			if cmp < 0 || (cmp == 0 && pk <= v.prevPk) {
+				return v.fail("Entry %v:%v in chunk %v is out of order after %v:%v",
+					skey, pk, v.chunks, v.prevKey, v.prevPk)
+			}
+		}
+		v.prevKey, v.prevPk = key, pk
+
+		if v.expected != nil {
+			expKey, ok := v.expected(pk)
+			if !ok {
+				return v.fail("Entry %v:%v in chunk %v is not expected", skey, pk, v.chunks)
+			}
+			if !reflect.DeepEqual([]interface{}(skey), expKey) {
+				return v.fail("Entry %v:%v in chunk %v does not match expected key %v",
+					skey, pk, v.chunks, expKey)
+			}
+		}
+	}
+
+	v.count += int64(len(skeys))
+	if v.limit > 0 && v.count > v.limit {
+		return v.fail("Scan count %v exceeds limit %v after chunk %v", v.count, v.limit, v.chunks)
+	}
+	return nil
+}
+
+// Handler returns a response handler that validates the response as
+// it is streamed. The validation error, if any, is returned by Done.
+func (v *StreamValidator) Handler() qc.ResponseHandler {
+	return func(response qc.ResponseReader) bool {
+		if err := response.Error(); err != nil {
+			v.err = err
+			return false
+		}
+		skeys, pkeys, err := response.GetEntries()
+		if err != nil {
+			v.err = err
+			return false
+		}
+		return v.ValidateChunk(skeys, pkeys) == nil
+	}
+}
+
+// Done completes the validation of the response.
+func (v *StreamValidator) Done() error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.expectedCount >= 0 && v.count != v.expectedCount {
+		return v.fail("Expected scan count %d does not match actual scan count %d",
+			v.expectedCount, v.count)
+	}
+	log.Printf("Streamed scan response of %v entries in %v chunks is valid", v.count, v.chunks)
+	return nil
+}
+
+// Count returns the number of entries validated.
+func (v *StreamValidator) Count() int64 {
+	return v.count
+}
+
+func (v *StreamValidator) fail(format string, args ...interface{}) error {
+	errorStr := fmt.Sprintf(format, args...)
+	log.Printf("%v", errorStr)
+	v.err = errors.New(errorStr)
+	return v.err
+}
+
+// compare the keys in index order.
+func (v *StreamValidator) compare(key1, key2 []value.Value) int {
+	for i := 0; i < len(key1) && i < len(key2); i++ {
+		cmp := key1[i].Collate(key2[i])
+		if i < len(v.desc) && v.desc[i] {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return len(key1) - len(key2)
+}
+
+func (v *StreamValidator) inBounds(key []value.Value) bool {
+	if v.low != nil {
+		cmp := comparePrefix(key, skey2Values(v.low))
+		if cmp < 0 || (cmp == 0 && v.inclusion&uint32(qc.Low) == 0) {
+			return false
+		}
+	}
+	if v.high != nil {
+		cmp := comparePrefix(key, skey2Values(v.high))
+		if cmp > 0 || (cmp == 0 && v.inclusion&uint32(qc.High) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// comparePrefix compares the leading values of key with bound, in value
+// collation order.
+func comparePrefix(key, bound []value.Value) int {
+	for i := 0; i < len(bound); i++ {
+		if i >= len(key) {
+			return -1
+		}
+		if cmp := key[i].Collate(bound[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// docKey returns the secondary key of doc for an index on jsonPaths, and
+// false if the leading key is missing and doc is not indexed.
+func docKey(doc interface{}, jsonPaths []string) ([]interface{}, bool) {
+	key := make([]interface{}, len(jsonPaths))
+	for i, jsonPath := range jsonPaths {
+		val, ok := doc, true
+		for _, f := range strings.Split(jsonPath, ".") {
+			var m map[string]interface{}
+			if m, ok = val.(map[string]interface{}); !ok {
+				break
+			}
+			if val, ok = m[f]; !ok {
+				break
+			}
+		}
+		if !ok {
+			if i == 0 {
+				return nil, false
+			}
+			val = tc.MissingLiteral
+		}
+		key[i] = val
+	}
+	return key, true
+}
+
+func skey2Values(skey []interface{}) []value.Value {
+	vals := make([]value.Value, len(skey))
+	for i := 0; i < len(skey); i++ {
+		if s, ok := skey[i].(string); ok && collatejson.MissingLiteral.Equal(s) {
+			vals[i] = value.NewMissingValue()
+		} else {
+			vals[i] = value.NewValue(skey[i])
+		}
+	}
+	return vals
+}
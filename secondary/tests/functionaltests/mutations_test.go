@@ -275,3 +275,33 @@ func TestLargeMutations(t *testing.T) {
 		FailTestIfError(err, "Error in drop index", t)
 	}
 }
+
+// Validate large range scans as they are streamed, without collecting
+// the expected and the actual response.
+func TestLargeMutationsStreamValidation(t *testing.T) {
+	log.Printf("In TestLargeMutationsStreamValidation()")
+	var indexName = "indexmut_1"
+	var bucketName = "default"
+	var field = "company"
+
+	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, "", []string{field}, false, nil, true, defaultIndexActiveTimeout, nil)
+	FailTestIfError(err, "Error in creating the index", t)
+
+	low, high := []interface{}{"G"}, []interface{}{"M"}
+	validator := tv.NewStreamValidator(low, high, 1, 0).SetExpectedDocs(docs, []string{field})
+	err = secondaryindex.RangeStream(indexName, bucketName, indexScanAddress, low, high, 1, false, defaultlimit, c.SessionConsistency, nil, validator.Handler())
+	FailTestIfError(err, "Error in scan", t)
+	err = validator.Done()
+	FailTestIfError(err, "Error in scan result validation", t)
+	log.Printf("Validated %v streamed entries", validator.Count())
+
+	// a limited scan, with both bounds inclusive.
+	var limit int64 = 5000
+	low, high = []interface{}{"B"}, []interface{}{"T"}
+	validator = tv.NewStreamValidator(low, high, 3, limit).SetExpectedDocs(docs, []string{field})
+	err = secondaryindex.RangeStream(indexName, bucketName, indexScanAddress, low, high, 3, false, limit, c.SessionConsistency, nil, validator.Handler())
+	FailTestIfError(err, "Error in scan", t)
+	err = validator.Done()
+	FailTestIfError(err, "Error in scan result validation", t)
+	log.Printf("Validated %v streamed entries", validator.Count())
+}
@@ -100,6 +100,7 @@ func TestPlanner(t *testing.T) {
 	initialPlacementTest(t)
 	incrPlacementTest(t)
 	rebalanceTest(t)
+	simulationTest(t)
 }
 
 //
@@ -231,3 +232,59 @@ func rebalanceTest(t *testing.T) {
 		}
 	}
 }
+
+//
+// This test simulates placing a set of new index onto an initial index
+// layout.  Only the new indexes are reported in the placement, and the
+// usage they add to each node adds up to their own usage.
+//
+func simulationTest(t *testing.T) {
+
+	log.Printf("-------------------------------------------")
+	log.Printf("simulation - 20-50M, 5 2M index, 1 replica")
+
+	plan, err := planner.ReadPlan("../testdata/planner/plan/uniform-small-10-3.json")
+	FailTestIfError(err, "Fail to read plan", t)
+
+	indexSpecs, err := planner.ReadIndexSpecs("../testdata/planner/index/small-2M-5-1.json")
+	FailTestIfError(err, "Fail to read index spec", t)
+
+	existing := plan.Placement[0].Indexes[0]
+
+	result, err := planner.ExecuteSimulation(plan, indexSpecs)
+	FailTestIfError(err, "Error in planner simulation", t)
+
+	names := make(map[string]bool)
+	for _, spec := range indexSpecs {
+		names[spec.Bucket+":"+spec.Name] = true
+	}
+
+	var memUsage, diskUsage uint64
+	for _, index := range result.Placement {
+		if !names[index.Bucket+":"+index.Name] {
+			t.Fatalf("Existing index %v:%v reported in simulated placement", index.Bucket, index.Name)
+		}
+		memUsage += index.MemUsage
+		diskUsage += index.DiskUsage
+	}
+	if len(result.Placement) < len(indexSpecs) {
+		t.Fatalf("Expected at least %v simulated indexes, got %v", len(indexSpecs), len(result.Placement))
+	}
+
+	var memDelta, diskDelta uint64
+	for _, node := range result.Nodes {
+		memDelta += node.MemDelta
+		diskDelta += node.DiskDelta
+	}
+	if memDelta != memUsage || diskDelta != diskUsage {
+		t.Fatalf("Node deltas %v/%v do not add up to index usage %v/%v", memDelta, diskDelta, memUsage, diskUsage)
+	}
+
+	// an index already in the layout cannot be simulated
+	duplicate := *indexSpecs[0]
+	duplicate.Name = existing.Name
+	duplicate.Bucket = existing.Bucket
+	if _, err := planner.ExecuteSimulation(plan, []*planner.IndexSpec{&duplicate}); err == nil {
+		t.Fatal("Expected error simulating an existing index")
+	}
+}
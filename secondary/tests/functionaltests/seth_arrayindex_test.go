@@ -293,6 +293,58 @@ func TestArraySizeIncreaseDecrease(t *testing.T) {
 	time.Sleep(5 * time.Second) // Wait for restart after this setting change
 }
 
+func TestArrayIndexGeneratedDocs(t *testing.T) {
+	log.Printf("In TestArrayIndexGeneratedDocs()")
+
+	var bucketName = "default"
+	e := secondaryindex.DropAllSecondaryIndexes(indexManagementAddress)
+	FailTestIfError(e, "Error in DropAllSecondaryIndexes", t)
+	kvutility.FlushBucket(bucketName, "", clusterconfig.Username, clusterconfig.Password, kvaddress)
+
+	kvdocs := datautility.GenerateArrayDocs(1000, 42)
+	kvutility.SetKeyValues(kvdocs, bucketName, "", clusterconfig.KVAddress)
+
+	err := secondaryindex.CreateSecondaryIndex("arr_tags", bucketName, indexManagementAddress, "", []string{"ALL DISTINCT tags"}, false, nil, true, defaultIndexActiveTimeout, nil)
+	FailTestIfError(err, "Error in creating the index", t)
+	err = secondaryindex.CreateSecondaryIndex("arr_skus", bucketName, indexManagementAddress, "", []string{"ALL ARRAY (ALL ARRAY i.sku FOR i IN o.items END) FOR o IN orders END"}, false, nil, true, defaultIndexActiveTimeout, nil)
+	FailTestIfError(err, "Error in creating the index", t)
+
+	docScanResults := datautility.ExpectedArrayScanResponse_path(kvdocs, "tags[]", "c", "p", 1, true)
+	scanResults, err := secondaryindex.ArrayIndex_Range("arr_tags", bucketName, indexScanAddress, []interface{}{"c"}, []interface{}{"p"}, 1, false, defaultlimit, c.SessionConsistency, nil)
+	FailTestIfError(err, "Error in scan", t)
+	err = tv.ValidateArrayResult(docScanResults, scanResults)
+	FailTestIfError(err, "Error in scan result validation", t)
+
+	docScanResults = datautility.ExpectedArrayScanResponse_path(kvdocs, "orders[].items[].sku", "sku10", "sku5", 3, false)
+	scanResults, err = secondaryindex.ArrayIndex_Range("arr_skus", bucketName, indexScanAddress, []interface{}{"sku10"}, []interface{}{"sku5"}, 3, false, defaultlimit, c.SessionConsistency, nil)
+	FailTestIfError(err, "Error in scan", t)
+	err = tv.ValidateArrayResult(docScanResults, scanResults)
+	FailTestIfError(err, "Error in scan result validation", t)
+}
+
+func TestNestedIndexGeneratedDocs(t *testing.T) {
+	log.Printf("In TestNestedIndexGeneratedDocs()")
+
+	var bucketName = "default"
+	e := secondaryindex.DropAllSecondaryIndexes(indexManagementAddress)
+	FailTestIfError(e, "Error in DropAllSecondaryIndexes", t)
+	kvutility.FlushBucket(bucketName, "", clusterconfig.Username, clusterconfig.Password, kvaddress)
+
+	kvdocs := datautility.GenerateNestedDocs(1000, 4, 42)
+	kvutility.SetKeyValues(kvdocs, bucketName, "", clusterconfig.KVAddress)
+
+	jsonPath := datautility.NestedPath(3, "value")
+	err := secondaryindex.CreateSecondaryIndex("nested_value", bucketName, indexManagementAddress, "", []string{jsonPath}, false, nil, true, defaultIndexActiveTimeout, nil)
+	FailTestIfError(err, "Error in creating the index", t)
+
+	// the range spans numbers, strings and bools of mixed documents.
+	docScanResults := datautility.ExpectedScanResponse_mixed(kvdocs, jsonPath, false, "s50", 3)
+	scanResults, err := secondaryindex.Range("nested_value", bucketName, indexScanAddress, []interface{}{false}, []interface{}{"s50"}, 3, false, defaultlimit, c.SessionConsistency, nil)
+	FailTestIfError(err, "Error in scan", t)
+	err = tv.Validate(docScanResults, scanResults)
+	FailTestIfError(err, "Error in scan result validation", t)
+}
+
 func updateDocsArrayField(kvdocs tc.KeyValues, bucketName string) tc.KeyValues {
 	// Update docs
 	keysToBeUpdated := make(tc.KeyValues)
@@ -0,0 +1,181 @@
+package functionaltests
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	qc "github.com/couchbase/indexing/secondary/queryport/client"
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"github.com/couchbase/indexing/secondary/tests/framework/kvutility"
+	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
+)
+
+// consistencyMutator continuously creates, updates and deletes a fixed
+// set of documents. Every write stores a new version of the document,
+// versions of a key only increase, so a scan result can be validated
+// against the writes acknowledged before the scan was issued.
+type consistencyMutator struct {
+	sync.Mutex
+	bucket   string
+	keys     []string
+	version  int
+	versions map[string]int // key -> version acknowledged by KV, negated if deleted
+	stopch   chan bool
+	donech   chan bool
+}
+
+func newConsistencyMutator(bucket string, numDocs int) *consistencyMutator {
+	m := &consistencyMutator{
+		bucket:   bucket,
+		versions: make(map[string]int),
+		stopch:   make(chan bool),
+		donech:   make(chan bool),
+	}
+	for i := 0; i < numDocs; i++ {
+		m.keys = append(m.keys, fmt.Sprintf("scan_consistency_%v", i))
+	}
+	return m
+}
+
+func (m *consistencyMutator) load() {
+	for _, key := range m.keys {
+		m.write(key, false)
+	}
+}
+
+func (m *consistencyMutator) run() {
+	defer close(m.donech)
+
+	rnd := rand.New(rand.NewSource(int64(seed)))
+	for {
+		select {
+		case <-m.stopch:
+			return
+		default:
+		}
+		key := m.keys[rnd.Intn(len(m.keys))]
+		m.Lock()
+		exists := m.versions[key] > 0
+		m.Unlock()
+		// deleting a missing key fails in kvutility.
+		m.write(key, exists && rnd.Intn(5) == 0)
+	}
+}
+
+func (m *consistencyMutator) write(key string, del bool) {
+	m.Lock()
+	m.version++
+	version := m.version
+	m.Unlock()
+
+	if del {
+		kvutility.Delete(key, m.bucket, "", clusterconfig.KVAddress)
+		version = -version
+	} else {
+		doc := map[string]interface{}{"scan_version": version}
+		kvutility.Set(key, doc, m.bucket, "", clusterconfig.KVAddress)
+	}
+
+	m.Lock()
+	m.versions[key] = version
+	m.Unlock()
+}
+
+func (m *consistencyMutator) stop() {
+	close(m.stopch)
+	<-m.donech
+}
+
+// acknowledged returns the versions acknowledged by KV so far.
+func (m *consistencyMutator) acknowledged() map[string]int {
+	m.Lock()
+	defer m.Unlock()
+
+	versions := make(map[string]int)
+	for key, version := range m.versions {
+		versions[key] = version
+	}
+	return versions
+}
+
+// validateConsistency checks that scanResults includes every write
+// acknowledged before the scan, or a later write of the same key.
+func validateConsistency(acked map[string]int, scanResults tc.ScanResponse) error {
+	for key, version := range acked {
+		val, ok := scanResults[key]
+		if !ok {
+			if version > 0 {
+				return fmt.Errorf("missing doc %v version %v", key, version)
+			}
+			continue
+		}
+		if scanned := int(val[0].(float64)); version > 0 && scanned < version {
+			return fmt.Errorf("stale doc %v version %v, expected version >= %v",
+				key, scanned, version)
+		} else if version < 0 && scanned < -version {
+			return fmt.Errorf("doc %v version %v found after its delete", key, scanned)
+		}
+	}
+	return nil
+}
+
+func testScanConsistencyUnderMutations(t *testing.T, consistency c.Consistency) {
+	var bucketName = "default"
+	var indexName = "index_scan_version"
+
+	e := secondaryindex.DropAllSecondaryIndexes(indexManagementAddress)
+	FailTestIfError(e, "Error in DropAllSecondaryIndexes", t)
+
+	mutator := newConsistencyMutator(bucketName, 1000)
+	mutator.load()
+	defer func() {
+		for key, version := range mutator.acknowledged() {
+			if version > 0 {
+				kvutility.Delete(key, bucketName, "", clusterconfig.KVAddress)
+			}
+		}
+	}()
+
+	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, "", []string{"scan_version"}, false, nil, true, defaultIndexActiveTimeout, nil)
+	FailTestIfError(err, "Error in creating the index", t)
+
+	client, err := secondaryindex.CreateClient(indexScanAddress, "2itest")
+	FailTestIfError(err, "Error in creating client", t)
+	defer client.Close()
+
+	go mutator.run()
+	defer mutator.stop()
+
+	i, deadline := 0, time.Now().Add(60*time.Second)
+	for ; time.Now().Before(deadline); i++ {
+		acked := mutator.acknowledged()
+
+		var vector *qc.TsConsistency
+		if consistency == c.QueryConsistency {
+			// every acknowledged write is covered by the current KV seqnos.
+			vector, err = client.BucketTs(bucketName)
+			FailTestIfError(err, "Error in getting bucket timestamp", t)
+		}
+
+		scanResults, err := secondaryindex.ScanAll(indexName, bucketName, indexScanAddress, defaultlimit, consistency, vector)
+		FailTestIfError(err, "Error in scan", t)
+		err = validateConsistency(acked, scanResults)
+		FailTestIfError(err, fmt.Sprintf("Error in scan result validation, scan %v", i), t)
+	}
+	log.Printf("Validated %v scans under mutations", i)
+}
+
+func TestScanConsistencyUnderMutations_AtPlus(t *testing.T) {
+	log.Printf("In TestScanConsistencyUnderMutations_AtPlus()")
+	testScanConsistencyUnderMutations(t, c.QueryConsistency)
+}
+
+func TestScanConsistencyUnderMutations_RequestPlus(t *testing.T) {
+	log.Printf("In TestScanConsistencyUnderMutations_RequestPlus()")
+	testScanConsistencyUnderMutations(t, c.SessionConsistency)
+}
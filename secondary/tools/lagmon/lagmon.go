@@ -0,0 +1,273 @@
+// lagmon polls the stats of all indexer nodes of a cluster and renders,
+// per index, the ingest lag, the documents pending and queued, and the
+// average scan latency as a terminal dashboard. With -csv, every sample
+// is also appended to a csv file, to attach to support cases.
+//
+//	lagmon -auth Administrator:asdasd -interval 5 -csv lag.csv localhost:9000
+package main
+
+import "encoding/csv"
+import "encoding/json"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "os"
+import "sort"
+import "strconv"
+import "strings"
+import "text/tabwriter"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/cbauth"
+
+var options struct {
+	auth     string
+	user     string
+	password string
+	interval int
+	bucket   string
+	csvfile  string
+	once     bool
+	top      int
+}
+
+// per index stats shown by the dashboard.
+var lagStats = []string{
+	"ingest_lag",
+	"num_docs_pending",
+	"num_docs_queued",
+	"avg_scan_latency",
+}
+
+type indexLag struct {
+	node    string
+	bucket  string
+	index   string
+	lag     int64
+	pending int64
+	queued  int64
+	latency int64 // nanoseconds
+}
+
+func argParse() string {
+	flag.StringVar(&options.auth, "auth", "Administrator:asdasd",
+		"Auth user and password")
+	flag.IntVar(&options.interval, "interval", 5,
+		"poll interval in seconds")
+	flag.StringVar(&options.bucket, "bucket", "",
+		"only show indexes of bucket")
+	flag.StringVar(&options.csvfile, "csv", "",
+		"append every sample to csv file")
+	flag.BoolVar(&options.once, "once", false,
+		"poll once and exit")
+	flag.IntVar(&options.top, "top", 0,
+		"only show top indexes by ingest lag, 0 for all")
+
+	flag.Parse()
+
+	up := strings.SplitN(options.auth, ":", 2)
+	if len(up) != 2 {
+		log.Fatalf("invalid -auth %q, expected user:password", options.auth)
+	}
+	options.user, options.password = up[0], up[1]
+
+	args := flag.Args()
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "usage: %v [options] <cluster-addr>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	return args[0]
+}
+
+func main() {
+	cluster := argParse()
+
+	if _, err := cbauth.InternalRetryDefaultInit(cluster, options.user, options.password); err != nil {
+		log.Fatalf("Failed to initialize cbauth: %s", err)
+	}
+
+	var csvw *csv.Writer
+	if options.csvfile != "" {
+		flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+		fd, err := os.OpenFile(options.csvfile, flags, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fd.Close()
+		csvw = csv.NewWriter(fd)
+		if info, err := fd.Stat(); err == nil && info.Size() == 0 {
+			csvw.Write([]string{"time", "node", "bucket", "index",
+				"ingest_lag", "num_docs_pending", "num_docs_queued",
+				"avg_scan_latency_ns"})
+		}
+	}
+
+	for {
+		now := time.Now()
+		lags, errs := pollCluster(cluster)
+		render(now, lags, errs)
+		if csvw != nil {
+			writeCSV(csvw, now, lags)
+		}
+		if options.once {
+			return
+		}
+		time.Sleep(time.Duration(options.interval) * time.Second)
+	}
+}
+
+// pollCluster returns the stats of all indexes, from all indexer nodes,
+// and the errors of nodes that could not be polled.
+func pollCluster(cluster string) ([]*indexLag, []error) {
+	cinfo, err := c.FetchNewClusterInfoCache(cluster, "default")
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	lags, errs := make([]*indexLag, 0), make([]error, 0)
+	for _, nid := range cinfo.GetNodesByServiceType(c.INDEX_HTTP_SERVICE) {
+		addr, err := cinfo.GetServiceAddress(nid, c.INDEX_HTTP_SERVICE)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		nodeLags, err := pollNode(addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", addr, err))
+			continue
+		}
+		lags = append(lags, nodeLags...)
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].lag != lags[j].lag {
+			return lags[i].lag > lags[j].lag
+		}
+		if lags[i].bucket != lags[j].bucket {
+			return lags[i].bucket < lags[j].bucket
+		}
+		return lags[i].index < lags[j].index
+	})
+	return lags, errs
+}
+
+func pollNode(addr string) ([]*indexLag, error) {
+	req, err := http.NewRequest("GET", "http://"+addr+"/stats?async=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(options.user, options.password)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v %s", resp.Status, body)
+	}
+
+	stats := make(map[string]interface{})
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return nil, err
+	}
+
+	// index stats are keyed as bucket:index:stat
+	indexes := make(map[string]*indexLag)
+	for key, value := range stats {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) != 3 || !isLagStat(parts[2]) {
+			continue
+		}
+		if options.bucket != "" && parts[0] != options.bucket {
+			continue
+		}
+		v, ok := value.(float64)
+		if !ok {
+			continue
+		}
+
+		name := parts[0] + ":" + parts[1]
+		il, ok := indexes[name]
+		if !ok {
+			il = &indexLag{node: addr, bucket: parts[0], index: parts[1]}
+			indexes[name] = il
+		}
+		switch parts[2] {
+		case "ingest_lag":
+			il.lag = int64(v)
+		case "num_docs_pending":
+			il.pending = int64(v)
+		case "num_docs_queued":
+			il.queued = int64(v)
+		case "avg_scan_latency":
+			il.latency = int64(v)
+		}
+	}
+
+	lags := make([]*indexLag, 0, len(indexes))
+	for _, il := range indexes {
+		lags = append(lags, il)
+	}
+	return lags, nil
+}
+
+func isLagStat(stat string) bool {
+	for _, s := range lagStats {
+		if s == stat {
+			return true
+		}
+	}
+	return false
+}
+
+func render(now time.Time, lags []*indexLag, errs []error) {
+	if !options.once {
+		fmt.Print("\033[H\033[2J") // clear screen
+	}
+	fmt.Printf("lagmon %v, %v indexes, every %vs\n\n",
+		now.Format("2006-01-02 15:04:05"), len(lags), options.interval)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "NODE\tBUCKET\tINDEX\tINGEST_LAG\tPENDING\tQUEUED\tSCAN_LATENCY\t")
+	for i, il := range lags {
+		if options.top > 0 && i >= options.top {
+			break
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			il.node, il.bucket, il.index, il.lag, il.pending, il.queued,
+			time.Duration(il.latency))
+	}
+	w.Flush()
+
+	for _, err := range errs {
+		fmt.Printf("\nerror: %v", err)
+	}
+	fmt.Println()
+}
+
+func writeCSV(w *csv.Writer, now time.Time, lags []*indexLag) {
+	ts := now.Format(time.RFC3339)
+	for _, il := range lags {
+		w.Write([]string{
+			ts, il.node, il.bucket, il.index,
+			strconv.FormatInt(il.lag, 10),
+			strconv.FormatInt(il.pending, 10),
+			strconv.FormatInt(il.queued, 10),
+			strconv.FormatInt(il.latency, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("error writing %v: %v", options.csvfile, err)
+	}
+}
@@ -29,6 +29,9 @@ var ErrorDecoderUnknown = errors.New("transport.decoderUnknown")
 //ErrorChecksumMismatch for mismatch in checksum
 var ErrorChecksumMismatch = errors.New("transport.checksumUnknown")
 
+// ErrorFrameCorrupt for mismatch in CRC32C checksum of payload.
+var ErrorFrameCorrupt = errors.New("transport.frameCorrupt")
+
 // packet field offset and size in bytes
 const (
 	pktLenOffset   int = 0
@@ -53,6 +56,11 @@ type TransportPacket struct {
 	buf      []byte
 	encoders map[byte]Encoder
 	decoders map[byte]Decoder
+	// fragmentation
+	maxFrame   int    // maximum size of a packet on the wire, 0 is unlimited
+	maxPayload int    // maximum size of reassembled payload, 0 is unlimited
+	frags      []byte // fragments received so far
+	crc        bool   // send CRC32C checksum with payload
 }
 
 // Encoder callback
@@ -93,6 +101,23 @@ func (pkt *TransportPacket) SetDecoder(typ byte, callb Decoder) *TransportPacket
 	return pkt
 }
 
+// SetFrameSize limits the size of packets on the wire to maxFrame
+// bytes. Payloads larger than maxFrame are sent as a sequence of
+// fragments, and reassembled by the receiver upto maxPayload bytes.
+// Zero value for either of them does not limit the size.
+func (pkt *TransportPacket) SetFrameSize(maxFrame, maxPayload int) *TransportPacket {
+	pkt.maxFrame, pkt.maxPayload = maxFrame, maxPayload
+	return pkt
+}
+
+// SetCRC32C sends payloads followed by their CRC32C checksum, to be
+// validated by the receiver. Receiver validates checksum of payloads
+// irrespective of this setting.
+func (pkt *TransportPacket) SetCRC32C(crc bool) *TransportPacket {
+	pkt.crc = crc
+	return pkt
+}
+
 // Send payload to the other end using sufficient encoding and compression.
 func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err error) {
 	var data []byte
@@ -106,7 +131,21 @@ func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err err
 		return
 	}
 
-	err = Send(conn, pkt.buf, pkt.flags, data, true)
+	// fragment, such that packet including its checksum fits the frame.
+	maxFrame := pkt.maxFrame
+	if pkt.crc && maxFrame > crcSize {
+		maxFrame -= crcSize
+	}
+	for maxFrame > 0 && len(data) > maxFrame {
+		flags := pkt.flags.SetMoreFragments()
+		err = sendFrame(conn, pkt.buf, flags, data[:maxFrame], true, pkt.crc)
+		if err != nil {
+			return
+		}
+		data = data[maxFrame:]
+	}
+
+	err = sendFrame(conn, pkt.buf, pkt.flags, data, true, pkt.crc)
 	return
 }
 
@@ -116,9 +155,27 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 	var data []byte
 	var flags TransportFlag
 
-	flags, data, err = Receive(conn, pkt.buf)
-	if err != nil {
-		return
+	for {
+		flags, data, err = receiveFrame(conn, pkt.buf, pkt.maxFrame)
+		if err != nil {
+			pkt.frags = pkt.frags[:0]
+			return
+		}
+		if !flags.HasMoreFragments() && len(pkt.frags) == 0 {
+			break
+		}
+		// reassemble fragments
+		if pkt.maxPayload > 0 && len(pkt.frags)+len(data) > pkt.maxPayload {
+			fmsg := "reassembled payload length %v > %v\n"
+			logging.Errorf(fmsg, len(pkt.frags)+len(data), pkt.maxPayload)
+			pkt.frags = pkt.frags[:0]
+			return nil, ErrorPacketOverflow
+		}
+		pkt.frags = append(pkt.frags, data...)
+		if !flags.HasMoreFragments() {
+			data, pkt.frags = pkt.frags, pkt.frags[:0]
+			break
+		}
 	}
 
 	// Special packet to indicate end response
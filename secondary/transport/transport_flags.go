@@ -3,9 +3,10 @@
 //       byte|       0       |       1       |
 //           +---------------+---------------+
 //       bits|0 1 2 3 4 5 6 7|0 1 2 3 4 5 6 7|
-//           +-------+-------+---------------+  COMP. - Compression
-//          0| COMP. |  ENC. |  checksum   |X|  ENC.  - Encoding
-//           +-------+-------+---------------+  X     - Reserved for future
+//           +-----+-+-------+---------------+  COMP. - Compression
+//          0|COMP.|C|  ENC. |  checksum   |F|  C     - CRC32C trailer
+//           +-----+-+-------+---------------+  ENC.  - Encoding
+//                                              F     - More fragments follow
 
 package transport
 
@@ -32,22 +33,34 @@ type TransportFlag uint16
 
 // GetCompression returns the compression bits from flags
 func (flags TransportFlag) GetCompression() byte {
-	return byte(flags & TransportFlag(0x000F))
+	return byte(flags & TransportFlag(0x0007))
 }
 
 // SetSnappy will set packet compression to snappy
 func (flags TransportFlag) SetSnappy() TransportFlag {
-	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionSnappy)
+	return (flags & TransportFlag(0xFFF8)) | TransportFlag(CompressionSnappy)
 }
 
 // SetGzip will set packet compression to Gzip
 func (flags TransportFlag) SetGzip() TransportFlag {
-	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionGzip)
+	return (flags & TransportFlag(0xFFF8)) | TransportFlag(CompressionGzip)
 }
 
 // SetBzip2 will set packet compression to bzip2
 func (flags TransportFlag) SetBzip2() TransportFlag {
-	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionBzip2)
+	return (flags & TransportFlag(0xFFF8)) | TransportFlag(CompressionBzip2)
+}
+
+// SetCRC32C will mark the packet's payload as followed by its CRC32C
+// checksum.
+func (flags TransportFlag) SetCRC32C() TransportFlag {
+	return flags | TransportFlag(0x0008)
+}
+
+// HasCRC32C will tell whether packet's payload is followed by its
+// CRC32C checksum.
+func (flags TransportFlag) HasCRC32C() bool {
+	return (flags & TransportFlag(0x0008)) != 0
 }
 
 // GetEncoding will get the encoding bits from flags
@@ -78,3 +91,15 @@ func (flags TransportFlag) IsValidEncoding() bool {
 	}
 	return false
 }
+
+// SetMoreFragments will mark the packet as a fragment of a payload,
+// that is followed by more fragments.
+func (flags TransportFlag) SetMoreFragments() TransportFlag {
+	return flags | TransportFlag(0x8000)
+}
+
+// HasMoreFragments will tell whether packet is followed by more
+// fragments of the same payload.
+func (flags TransportFlag) HasMoreFragments() bool {
+	return (flags & TransportFlag(0x8000)) != 0
+}
@@ -2,9 +2,24 @@ package transport
 
 import "io"
 import "encoding/binary"
+import "hash/crc32"
 import "github.com/couchbase/indexing/secondary/logging"
 
+// size of CRC32C trailer following the payload.
+const crcSize int = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 func Send(conn transporter, buf []byte, flags TransportFlag, payload []byte, addChksm bool) (err error) {
+	return sendFrame(conn, buf, flags, payload, addChksm, false)
+}
+
+// sendFrame is same as Send, except that if addCRC is true payload is
+// followed by its CRC32C checksum.
+func sendFrame(
+	conn transporter, buf []byte, flags TransportFlag, payload []byte,
+	addChksm, addCRC bool) (err error) {
+
 	// transport framing
 	l := pktLenSize + pktFlagSize
 	if maxLen := len(buf); l > maxLen {
@@ -13,8 +28,12 @@ func Send(conn transporter, buf []byte, flags TransportFlag, payload []byte, add
 		return
 	}
 
+	pktlen := len(payload)
+	if addCRC {
+		flags, pktlen = flags.SetCRC32C(), pktlen+crcSize
+	}
 	a, b := pktLenOffset, pktLenOffset+pktLenSize
-	binary.BigEndian.PutUint32(buf[a:b], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[a:b], uint32(pktlen))
 
 	if payload != nil && addChksm {
 		chksm := computeChecksum(buf[a:b])
@@ -29,6 +48,13 @@ func Send(conn transporter, buf []byte, flags TransportFlag, payload []byte, add
 	if err = connWrite(conn, payload); err != nil {
 		return err
 	}
+	if addCRC {
+		a, b = 0, crcSize
+		binary.BigEndian.PutUint32(buf[a:b], crc32.Checksum(payload, crcTable))
+		if err = connWrite(conn, buf[a:b]); err != nil {
+			return err
+		}
+	}
 	laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
 	logging.Tracef("wrote %v bytes on connection %v->%v", len(payload), laddr, raddr)
 	return nil
@@ -55,6 +81,15 @@ func SendResponseEnd(conn transporter) error {
 }
 
 func Receive(conn transporter, buf []byte) (flags TransportFlag, payload []byte, err error) {
+	return receiveFrame(conn, buf, 0)
+}
+
+// receiveFrame is same as Receive, except that packets larger than
+// maxFrame are rejected with ErrorPacketOverflow, before allocating
+// memory for them. Zero maxFrame does not limit the packet size.
+func receiveFrame(
+	conn transporter, buf []byte, maxFrame int) (flags TransportFlag, payload []byte, err error) {
+
 	// transport de-framing
 	bufHeader := safeBufSlice(buf, pktDataOffset)
 	if err = fullRead(conn, bufHeader); err != nil {
@@ -91,6 +126,12 @@ func Receive(conn transporter, buf []byte) (flags TransportFlag, payload []byte,
 		}
 	}
 
+	if maxFrame > 0 && int(pktlen) > maxFrame {
+		logging.Errorf("receiving packet length %v > %v\n", pktlen, maxFrame)
+		err = ErrorPacketOverflow
+		return
+	}
+
 	bufPkt := safeBufSlice(buf, int(pktlen))
 	if err = fullRead(conn, bufPkt); err != nil {
 		if err == io.EOF {
@@ -101,6 +142,21 @@ func Receive(conn transporter, buf []byte) (flags TransportFlag, payload []byte,
 		return
 	}
 
+	if flags.HasCRC32C() {
+		if len(bufPkt) < crcSize {
+			logging.Errorf("packet length %v too short for CRC32C", len(bufPkt))
+			err = ErrorFrameCorrupt
+			return
+		}
+		a, b = len(bufPkt)-crcSize, len(bufPkt)
+		crc := binary.BigEndian.Uint32(bufPkt[a:b])
+		if bufPkt = bufPkt[:a]; crc32.Checksum(bufPkt, crcTable) != crc {
+			logging.Errorf("CRC32C mismatch on packet of length %v", len(bufPkt))
+			err = ErrorFrameCorrupt
+			return
+		}
+	}
+
 	return flags, bufPkt, err
 }
 